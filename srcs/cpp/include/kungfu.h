@@ -17,6 +17,9 @@ extern void order_group_wait(order_group_t *, int32_t *arrive_order);
 
 extern void kungfu_run_main();
 
+// add a field to the fingerprint checked across peers, before the peer starts
+extern void kungfu_set_fingerprint(const char *key, const char *value);
+
 #ifdef __cplusplus
 }
 
@@ -13,6 +13,7 @@ type-category :
     - signed: 1
     - float: 2
     - bool: 3
+    - brain float: 4
 
 type-bytes : 1 2 4 8
 bits-per-byte : 8
@@ -36,6 +37,8 @@ enum KungFu_Datatype {
     KungFu_DOUBLE  = TYPE_CODE(2, 8),
 
     KungFu_BOOL = TYPE_CODE(3, 1),
+
+    KungFu_BFLOAT16 = TYPE_CODE(4, 2),
 };
 
 typedef enum KungFu_Datatype KungFu_Datatype;
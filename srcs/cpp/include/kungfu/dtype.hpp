@@ -11,6 +11,10 @@ struct float16 {
     uint16_t value;
 };
 
+struct bfloat16 {
+    uint16_t value;
+};
+
 namespace internal
 {
 namespace types
@@ -35,6 +39,10 @@ template <> struct data_type_t<float16> {
     static constexpr V value = KungFu_FLOAT16;
 };
 
+template <> struct data_type_t<bfloat16> {
+    static constexpr V value = KungFu_BFLOAT16;
+};
+
 template <> struct data_type_t<float> {
     static constexpr V value = KungFu_FLOAT;
 };
@@ -0,0 +1,37 @@
+#pragma once
+#include <stdint.h>
+
+#include <kungfu/dtype.h>
+#include <kungfu/op.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// kungfu_jax_descriptor_t is passed as the first operand of every KungFu
+// custom call, it describes the layout of the data operand. Its fields are
+// int64 so that the count of large tensors doesn't overflow.
+typedef struct {
+    int64_t count;
+    int64_t dtype;  // KungFu_Datatype
+    int64_t op;     // KungFu_Op, ignored by broadcast
+} kungfu_jax_descriptor_t;
+
+// The following functions follow the XLA CPU custom call convention:
+//      void f(void *out, const void **in)
+// where in[0] is a kungfu_jax_descriptor_t and in[1] is the input buffer.
+
+extern void kungfu_jax_all_reduce(void *out, const void **in);
+extern void kungfu_jax_broadcast(void *out, const void **in);
+
+// Async variants write an int32 handle to out and return immediately, they
+// reduce a copy of in[1], as XLA may reuse the buffers of a custom call after
+// it returns. kungfu_jax_wait_handle takes the handle as in[0], and writes the
+// result to out, which has the shape of in[1] of the async call.
+// They are designed to be used from host callbacks.
+extern void kungfu_jax_all_reduce_async(void *out, const void **in);
+extern void kungfu_jax_wait_handle(void *out, const void **in);
+
+#ifdef __cplusplus
+}
+#endif
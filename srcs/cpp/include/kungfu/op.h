@@ -10,6 +10,12 @@ enum KungFu_Op {
     KungFu_MIN,
     KungFu_MAX,
     KungFu_PROD,
+    KungFu_ADASUM,  // projection-based combination of whole vectors
+    KungFu_LAND,    // logical and, of non-zero values as 1
+    KungFu_LOR,     // logical or, of non-zero values as 1
+    KungFu_BAND,    // bitwise ops of integers
+    KungFu_BOR,
+    KungFu_BXOR,
 };
 
 typedef enum KungFu_Op KungFu_Op;
@@ -18,6 +24,24 @@ extern void std_transform_2(const void *input1, const void *input2,
                             void *output, const int n, const KungFu_Datatype dt,
                             const KungFu_Op o);
 
+// std_transform_2_compensated performs output = input1 + input2, and adds the
+// rounding errors to comp, of float for KungFu_FLOAT16 and of dt otherwise.
+extern void std_transform_2_compensated(const void *input1, const void *input2,
+                                        void *output, void *comp, const int n,
+                                        const KungFu_Datatype dt);
+
+// std_compensate adds comp to output, and resets comp to zero.
+extern void std_compensate(void *output, void *comp, const int n,
+                           const KungFu_Datatype dt);
+
+// std_widen converts the n 16-bit floats of dt of input to float, and
+// std_narrow rounds them back, so that many ops are computed in float
+// and rounded once.
+extern void std_widen(const void *input, float *output, const int n,
+                      const KungFu_Datatype dt);
+extern void std_narrow(const float *input, void *output, const int n,
+                       const KungFu_Datatype dt);
+
 #ifdef __cplusplus
 }
 #endif
@@ -0,0 +1,44 @@
+#pragma once
+#include <stdint.h>
+
+#include <kungfu/dtype.h>
+#include <kungfu/op.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// C API consumed by the communication backend of ONNX Runtime Training.
+// All functions return 0 on success.
+
+extern int kungfu_ort_rank();
+extern int kungfu_ort_size();
+extern int kungfu_ort_local_rank();
+extern int kungfu_ort_local_size();
+
+extern int kungfu_ort_barrier();
+
+extern int kungfu_ort_all_reduce(const void *sendbuf, void *recvbuf,
+                                 int64_t count, KungFu_Datatype dtype,
+                                 KungFu_Op op, const char *name);
+
+// kungfu_ort_all_reduce_async returns immediately and writes a handle,
+// which must be passed to kungfu_ort_wait exactly once.
+extern int kungfu_ort_all_reduce_async(const void *sendbuf, void *recvbuf,
+                                       int64_t count, KungFu_Datatype dtype,
+                                       KungFu_Op op, const char *name,
+                                       int32_t *handle);
+
+extern int kungfu_ort_broadcast(const void *sendbuf, void *recvbuf,
+                                int64_t count, KungFu_Datatype dtype,
+                                const char *name);
+
+extern int kungfu_ort_wait(int32_t handle);
+
+// kungfu_ort_resize_cluster_from_url lets the training loop react to
+// membership changes, detached peers should stop training.
+extern int kungfu_ort_resize_cluster_from_url(int *changed, int *detached);
+
+#ifdef __cplusplus
+}
+#endif
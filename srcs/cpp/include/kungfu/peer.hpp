@@ -1,4 +1,5 @@
 #pragma once
+#include <cstddef>
 #include <functional>
 #include <kungfu/dtype.hpp>
 
@@ -32,6 +33,46 @@ class Peer
     int LocalSize() const;
     int HostCount() const;
 
+    // step and epoch agreed by all peers, they are synchronized after resizing
+    int Step() const;
+    int Epoch() const;
+    int AdvanceStep();
+    int AdvanceEpoch();
+
+    // group the collectives of a training step, EndStep gets their summary, with
+    // durations in seconds
+    void BeginStep();
+    int EndStep(int32_t *collectives, int64_t *bytes, float *duration,
+                float *critical_path, float *fusion_efficiency);
+
+    // make the collectives of all peers fail, so that they don't wait for this
+    // peer after a fatal error
+    int Abort(const char *reason);
+
+    // set the value of an external signal, e.g. GPU utilization, which is
+    // correlated with the durations of collectives by the monitor
+    int ReportSignal(const char *name, double value);
+
+    // bytes of a bucket of fused gradients set by the profile, 0 if disabled
+    int FusionSize() const;
+
+    // true if all peers send device memory by GPUDirect RDMA, for the GPU
+    // integration to broadcast device tensors in place by it rather than
+    // staging them in host memory, it must be called by all peers
+    bool GPUDirect() const;
+
+    // register size bytes of host memory at ptr, e.g. of the gradients that
+    // are all reduced in every step, so that the RDMA transport sends them
+    // without a copy, until they are released
+    void RegisterBuffer(void *ptr, size_t size);
+    void ReleaseBuffer(void *ptr);
+
+    // register size bytes of device memory at ptr for GPUDirect RDMA, by the
+    // dma-buf dmabuf_fd at offset if it is not negative
+    int RegisterDeviceBuffer(void *ptr, size_t size, int dmabuf_fd = -1,
+                             uint64_t offset = 0);
+    void ReleaseDeviceBuffer(void *ptr);
+
     // call Done asynchronously
     int Noop(const DoneCallback &done);
 
@@ -46,6 +87,12 @@ class Peer
     int Save(const char *version, const char *name, const void *buf, int count,
              KungFu_Datatype dtype, const DoneCallback &done);
 
+    // upload buf of rank 0 to url/name, must be called by all peers
+    int Checkpoint(const char *url, const char *name, const void *buf,
+                   int count, KungFu_Datatype dtype);
+    int Checkpoint(const char *url, const char *name, const void *buf,
+                   int count, KungFu_Datatype dtype, const DoneCallback &done);
+
     // p2p APIs
     int Request(int destRank, const char *name, void *buf, int count,
                 KungFu_Datatype dtype);
@@ -69,23 +116,41 @@ class Peer
                   const char *name, const DoneCallback &done);
 
     // variant of https://www.open-mpi.org/doc/v4.0/man3/MPI_Reduce.3.php
-    int Reduce(const void *sendbuf, void *recvbuf, int count,
+    int Reduce(const void *sendbuf, void *recvbuf, int64_t count,
                KungFu_Datatype dtype, KungFu_Op op, const char *name,
                const DoneCallback &done);
 
     // variant of https://www.open-mpi.org/doc/v4.0/man3/MPI_Allreduce.3.php
-    int AllReduce(const void *sendbuf, void *recvbuf, int count,
+    int AllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                   KungFu_Datatype dtype, KungFu_Op op, const char *name);
-    int AllReduce(const void *sendbuf, void *recvbuf, int count,
+    int AllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                   KungFu_Datatype dtype, KungFu_Op op, const char *name,
                   const DoneCallback &done);
 
-    int CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
+    int CrossAllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                        KungFu_Datatype dtype, KungFu_Op op, const char *name);
-    int CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
+    int CrossAllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                        KungFu_Datatype dtype, KungFu_Op op, const char *name,
                        const DoneCallback &done);
 
+    // averages gradients like AllReduce, and estimates the gradient noise
+    // scale in the same pass, result = [|G|^2, tr(Sigma)]
+    int GradientNoiseScale(const void *sendbuf, void *recvbuf, int count,
+                           KungFu_Datatype dtype, int batch_size, float *result,
+                           const char *name, const DoneCallback &done);
+
+    // sqrt of the sum of squared norms of n tensors of all peers
+    int GlobalNorm(const void *const *bufs, const int32_t *counts, int n,
+                   KungFu_Datatype dtype, float *norm, const char *name,
+                   const DoneCallback &done);
+
+    // for SyncBatchNorm: sendbuf = [sum(c), sum of squares(c), count],
+    // recvbuf = [mean(c), biased variance(c), total count]
+    int BatchNormStats(const float *sendbuf, float *recvbuf, int channels,
+                       const char *name);
+    int BatchNormStats(const float *sendbuf, float *recvbuf, int channels,
+                       const char *name, const DoneCallback &done);
+
     int MonitoredAllReduce(const void *sendbuf, void *recvbuf, int count,
                            KungFu_Datatype dtype, KungFu_Op op,
                            const int32_t *tree, const char *name,
@@ -98,15 +163,54 @@ class Peer
                   void *recvbuf, const char *name, const DoneCallback &done);
 
     // variant of https://www.open-mpi.org/doc/v4.0/man3/MPI_Bcast.3.php
-    int Broadcast(const void *sendbuf, void *recvbuf, int count,
+    int Broadcast(const void *sendbuf, void *recvbuf, int64_t count,
                   KungFu_Datatype dtype, const char *name);
-    int Broadcast(const void *sendbuf, void *recvbuf, int count,
+    int Broadcast(const void *sendbuf, void *recvbuf, int64_t count,
                   KungFu_Datatype dtype, const char *name,
                   const DoneCallback &done);
 
-    int LocalBroadcast(const void *sendbuf, void *recvbuf, int count,
+    // Broadcast that skips the transfer if all peers have cached the value of
+    // the root, the value is compared by its content hash
+    int CachedBroadcast(const void *sendbuf, void *recvbuf, int count,
+                        KungFu_Datatype dtype, const char *name);
+    int CachedBroadcast(const void *sendbuf, void *recvbuf, int count,
+                        KungFu_Datatype dtype, const char *name,
+                        const DoneCallback &done);
+    // drop the cached value of name on this peer
+    void InvalidateBroadcast(const char *name);
+
+    // process the result of every AllReduce of name by a comma separated
+    // list of scale=<factor> and clip=<lo>:<hi>, none to remove them
+    int SetPostHooks(const char *name, const char *hooks);
+
+    // dynamic loss scaling: any_overflow is true if any peer overflowed,
+    // next_scale is the minimum of the scales proposed by all peers
+    int LossScaleConsensus(bool overflow, float scale, bool *any_overflow,
+                           float *next_scale, const char *name);
+
+    // population-based training: ranks[0..k) are the ranks of the k peers
+    // with the highest scores
+    int TopK(float score, int k, int32_t *ranks, const char *name);
+    // like TopK, and the other peers receive the model of a top peer
+    int TopKExploit(float score, int k, int32_t *ranks, const void *sendbuf,
+                    void *recvbuf, int count, KungFu_Datatype dtype,
+                    const char *name);
+
+    // ZeRO-style sharding: each named tensor is owned by one rank, all peers
+    // must assign the same tensors in the same order
+    int AssignShard(const char *name, int size, int32_t *owner);
+    // broadcast a parameter from its owner
+    int ShardBroadcast(const void *sendbuf, void *recvbuf, int count,
+                       KungFu_Datatype dtype, const char *name,
+                       const DoneCallback &done);
+    // reduce a gradient to its owner
+    int ShardReduce(const void *sendbuf, void *recvbuf, int count,
+                    KungFu_Datatype dtype, KungFu_Op op, const char *name,
+                    const DoneCallback &done);
+
+    int LocalBroadcast(const void *sendbuf, void *recvbuf, int64_t count,
                        KungFu_Datatype dtype, const char *name);
-    int LocalBroadcast(const void *sendbuf, void *recvbuf, int count,
+    int LocalBroadcast(const void *sendbuf, void *recvbuf, int64_t count,
                        KungFu_Datatype dtype, const char *name,
                        const DoneCallback &done);
 
@@ -150,6 +254,19 @@ class Peer
     // control APIs
     int ResizeClusterFromURL(bool *changed, bool *keep);
 
+    // stale synchronous parallel: blocks only while any other peer is more
+    // than bound steps behind
+    int StaleSync(int step, int bound);
+    int StaleSync(int step, int bound, const DoneCallback &done);
+
+    // apply pause/resume/scale requests received by the control server
+    int StepBoundary(int step, bool *changed, bool *keep);
+
+    // publish buf as the snapshot of name at the current step, if an
+    // evaluator is waiting for it, returns true if published
+    bool SnapshotBoundary(const char *name, const void *buf, int count,
+                          KungFu_Datatype dtype);
+
     int ProposeNewSize(int new_size);
 };
 }  // namespace kungfu
@@ -4,6 +4,7 @@
 extern "C" {
 extern void kungfu_python_init();
 extern void kungfu_python_init_nccl();
+extern void kungfu_python_init_fingerprint();  // before kungfu_python_init
 
 extern void kungfu_python_finialize();
 extern void kungfu_python_finialize_nccl();
@@ -19,7 +20,53 @@ extern int kungfu_local_rank();  // get current local rank
 extern int kungfu_local_size();  // get current local size
 extern void kungfu_barrier();
 
+extern int kungfu_step();           // get the step agreed by all peers
+extern int kungfu_epoch();          // get the epoch agreed by all peers
+extern int kungfu_advance_step();   // increment and get the step
+extern int kungfu_advance_epoch();  // increment and get the epoch
+
+extern void kungfu_begin_step();  // start grouping collectives into a step
+extern int kungfu_end_step(int32_t *collectives, int64_t *bytes,
+                           float *duration, float *critical_path,
+                           float *fusion_efficiency);
+
+extern int kungfu_abort(const char *reason);  // fail collectives of all peers
+extern int kungfu_report_signal(const char *name,
+                                double value);  // set an external signal
+
+extern int kungfu_fusion_size();  // get bytes of a bucket of fused gradients
+
 extern int kungfu_propose_new_size(int new_size);
+
+extern int kungfu_loss_scale_consensus(int overflow, float scale,
+                                       char *any_overflow, float *next_scale,
+                                       const char *name);
+
+extern int kungfu_top_k(float score, int k, int32_t *ranks, const char *name);
+
+extern int kungfu_top_k_exploit(float score, int k, int32_t *ranks,
+                                const void *sendbuf, void *recvbuf, int size,
+                                const char *name);
+
+// broadcast size bytes of the root, skipped if all peers have cached them
+extern int kungfu_cached_broadcast(const void *sendbuf, void *recvbuf,
+                                   int size, const char *name);
+
+extern void kungfu_invalidate_broadcast(const char *name);
+
+extern int kungfu_set_post_hooks(const char *name, const char *hooks);
+
+extern int kungfu_stale_sync(int step, int bound);
+
+extern int kungfu_step_boundary(int step, char *changed, char *keep);
+
+// publish size bytes as the snapshot of name for evaluators, if requested
+extern int kungfu_snapshot_boundary(const char *name, const void *buf,
+                                    int size);
+
+// upload size bytes of rank 0 to url/name
+extern int kungfu_checkpoint(const char *url, const char *name,
+                             const void *buf, int size);
 }
 
 namespace kungfu
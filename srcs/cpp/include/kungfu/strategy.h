@@ -12,6 +12,7 @@ enum KungFu_Strategy {
     KungFu_Clique,
     KungFu_BinaryTreeStar,
     KungFu_MultiBinaryTreeStar,
+    KungFu_MultiRing,
     KungFu_AUTO,
 };
 
@@ -54,6 +54,7 @@ class TorchCudaHelper
     void from_cuda(void *dst, const void *src, size_t size);
     void to_cuda(torch::Tensor &t, const void *buffer);
     void to_cuda(void *dst, const void *src, size_t size);
+    void copy_cuda(void *dst, const void *src, size_t size);
 };
 
 void wait_handle(int handle);
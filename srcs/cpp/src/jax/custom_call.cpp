@@ -0,0 +1,115 @@
+#include <atomic>
+#include <cstring>
+#include <map>
+#include <memory>
+#include <mutex>
+#include <string>
+#include <vector>
+
+#include <kungfu.h>
+#include <kungfu/jax/custom_call.h>
+#include <kungfu/python/init.h>
+#include <kungfu/utils/handler_manager.hpp>
+
+namespace kungfu
+{
+namespace jax
+{
+// All peers run the same compiled program, so the n-th custom call of the
+// same kind on each peer refers to the same collective.
+class NameGenerator
+{
+    const std::string prefix_;
+    std::atomic<uint64_t> counter_;
+
+  public:
+    explicit NameGenerator(const std::string &prefix)
+        : prefix_(prefix), counter_(0)
+    {
+    }
+
+    std::string operator()()
+    {
+        return prefix_ + std::to_string(counter_++);
+    }
+};
+
+NameGenerator all_reduce_names("kungfu::jax::all_reduce::");
+NameGenerator broadcast_names("kungfu::jax::broadcast::");
+
+HandleManager<int32_t> handles;
+
+// XLA only keeps the operands and the result of a custom call alive during
+// the call, so an async collective runs on buffers owned by KungFu, which
+// kungfu_jax_wait_handle copies the result from.
+struct AsyncBuffers {
+    std::vector<char> send;
+    std::vector<char> recv;
+};
+
+std::mutex async_mu;
+std::map<int32_t, std::unique_ptr<AsyncBuffers>> async_buffers;
+
+const kungfu_jax_descriptor_t &get_descriptor(const void **in)
+{
+    return *reinterpret_cast<const kungfu_jax_descriptor_t *>(in[0]);
+}
+}  // namespace jax
+}  // namespace kungfu
+
+void kungfu_jax_all_reduce(void *out, const void **in)
+{
+    using namespace kungfu::jax;
+    const auto &d          = get_descriptor(in);
+    const std::string name = all_reduce_names();
+    _default_peer->AllReduce(in[1], out, d.count,
+                             static_cast<KungFu_Datatype>(d.dtype),
+                             static_cast<KungFu_Op>(d.op), name.c_str());
+}
+
+void kungfu_jax_broadcast(void *out, const void **in)
+{
+    using namespace kungfu::jax;
+    const auto &d          = get_descriptor(in);
+    const std::string name = broadcast_names();
+    _default_peer->Broadcast(in[1], out, d.count,
+                             static_cast<KungFu_Datatype>(d.dtype),
+                             name.c_str());
+}
+
+void kungfu_jax_all_reduce_async(void *out, const void **in)
+{
+    using namespace kungfu::jax;
+    const auto &d          = get_descriptor(in);
+    const std::string name = all_reduce_names();
+    const auto dtype       = static_cast<KungFu_Datatype>(d.dtype);
+    const size_t size      = d.count * kungfu_type_size(dtype);
+    std::unique_ptr<AsyncBuffers> bufs(new AsyncBuffers);
+    bufs->send.resize(size);
+    bufs->recv.resize(size);
+    std::memcpy(bufs->send.data(), in[1], size);
+    const int32_t h = handles.create();
+    *reinterpret_cast<int32_t *>(out) = h;
+    AsyncBuffers *b                   = bufs.get();
+    {
+        std::lock_guard<std::mutex> lk(async_mu);
+        async_buffers[h] = std::move(bufs);
+    }
+    _default_peer->AllReduce(b->send.data(), b->recv.data(), d.count, dtype,
+                             static_cast<KungFu_Op>(d.op), name.c_str(),
+                             [h] { handles.done(h); });
+}
+
+void kungfu_jax_wait_handle(void *out, const void **in)
+{
+    using namespace kungfu::jax;
+    const int32_t h = *reinterpret_cast<const int32_t *>(in[0]);
+    handles.wait(h);
+    std::unique_ptr<AsyncBuffers> bufs;
+    {
+        std::lock_guard<std::mutex> lk(async_mu);
+        bufs = std::move(async_buffers.at(h));
+        async_buffers.erase(h);
+    }
+    std::memcpy(out, bufs->recv.data(), bufs->recv.size());
+}
@@ -25,3 +25,8 @@ void order_group_wait(order_group_t *og, int32_t *arrive_order)
 }
 
 void kungfu_run_main() { GoKungfuRunMain(); }
+
+void kungfu_set_fingerprint(const char *key, const char *value)
+{
+    GoKungfuSetFingerprint(const_cast<char *>(key), const_cast<char *>(value));
+}
@@ -0,0 +1,62 @@
+#include <kungfu.h>
+#include <kungfu/ort/communicator.h>
+#include <kungfu/python/init.h>
+#include <kungfu/utils/handler_manager.hpp>
+
+namespace kungfu
+{
+namespace ort
+{
+HandleManager<int32_t> handles;
+}  // namespace ort
+}  // namespace kungfu
+
+int kungfu_ort_rank() { return _default_peer->Rank(); }
+
+int kungfu_ort_size() { return _default_peer->Size(); }
+
+int kungfu_ort_local_rank() { return _default_peer->LocalRank(); }
+
+int kungfu_ort_local_size() { return _default_peer->LocalSize(); }
+
+int kungfu_ort_barrier() { return _default_peer->Barrier(); }
+
+int kungfu_ort_all_reduce(const void *sendbuf, void *recvbuf, int64_t count,
+                          KungFu_Datatype dtype, KungFu_Op op,
+                          const char *name)
+{
+    return _default_peer->AllReduce(sendbuf, recvbuf, count, dtype, op, name);
+}
+
+int kungfu_ort_all_reduce_async(const void *sendbuf, void *recvbuf,
+                                int64_t count, KungFu_Datatype dtype,
+                                KungFu_Op op, const char *name,
+                                int32_t *handle)
+{
+    using kungfu::ort::handles;
+    const int32_t h = handles.create();
+    *handle         = h;
+    return _default_peer->AllReduce(sendbuf, recvbuf, count, dtype, op, name,
+                                    [h] { handles.done(h); });
+}
+
+int kungfu_ort_broadcast(const void *sendbuf, void *recvbuf, int64_t count,
+                         KungFu_Datatype dtype, const char *name)
+{
+    return _default_peer->Broadcast(sendbuf, recvbuf, count, dtype, name);
+}
+
+int kungfu_ort_wait(int32_t handle)
+{
+    kungfu::ort::handles.wait(handle);
+    return 0;
+}
+
+int kungfu_ort_resize_cluster_from_url(int *changed, int *detached)
+{
+    bool c, keep;
+    const int err = _default_peer->ResizeClusterFromURL(&c, &keep);
+    *changed      = c;
+    *detached     = !keep;
+    return err;
+}
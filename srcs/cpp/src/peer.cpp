@@ -18,6 +18,25 @@ bool Peer::Detached() const { return GoKungfuDetached(); }
 
 uint64_t Peer::Uid() const { return GoKungfuUID(); }
 
+int Peer::FusionSize() const { return GoKungfuFusionSize(); }
+
+bool Peer::GPUDirect() const { return GoKungfuGPUDirect(); }
+
+void Peer::RegisterBuffer(void *ptr, size_t size)
+{
+    GoKungfuRegisterBuffer(ptr, size);
+}
+
+void Peer::ReleaseBuffer(void *ptr) { GoKungfuReleaseBuffer(ptr); }
+
+int Peer::RegisterDeviceBuffer(void *ptr, size_t size, int dmabuf_fd,
+                               uint64_t offset)
+{
+    return GoKungfuRegisterDeviceBuffer(ptr, size, dmabuf_fd, offset);
+}
+
+void Peer::ReleaseDeviceBuffer(void *ptr) { GoKungfuReleaseDeviceBuffer(ptr); }
+
 int Peer::Noop(const DoneCallback &done)
 {
     return GoKungfuNoop(new CallbackWrapper(done));
@@ -54,6 +73,22 @@ int Peer::Save(const char *version, const char *name, const void *buf,
                                new CallbackWrapper(done));
 }
 
+int Peer::Checkpoint(const char *url, const char *name, const void *buf,
+                     int count, KungFu_Datatype dtype)
+{
+    return GoKungfuCheckpoint(const_cast<char *>(url), const_cast<char *>(name),
+                              const_cast<void *>(buf), GoInt(count), dtype,
+                              nullptr);
+}
+
+int Peer::Checkpoint(const char *url, const char *name, const void *buf,
+                     int count, KungFu_Datatype dtype, const DoneCallback &done)
+{
+    return GoKungfuCheckpoint(const_cast<char *>(url), const_cast<char *>(name),
+                              const_cast<void *>(buf), GoInt(count), dtype,
+                              new CallbackWrapper(done));
+}
+
 int Peer::Request(int destRank, const char *name, void *buf, int count,
                   KungFu_Datatype dtype)
 {
@@ -98,6 +133,32 @@ int Peer::ResizeClusterFromURL(bool *changed, bool *keep)
                                         reinterpret_cast<char *>(keep));
 }
 
+int Peer::StepBoundary(int step, bool *changed, bool *keep)
+{
+    static_assert(sizeof(bool) == sizeof(char), "");
+    return GoKungfuStepBoundary(GoInt(step), reinterpret_cast<char *>(changed),
+                                reinterpret_cast<char *>(keep));
+}
+
+bool Peer::SnapshotBoundary(const char *name, const void *buf, int count,
+                            KungFu_Datatype dtype)
+{
+    return GoKungfuSnapshotBoundary(const_cast<char *>(name),
+                                    const_cast<void *>(buf), GoInt(count),
+                                    dtype);
+}
+
+int Peer::StaleSync(int step, int bound)
+{
+    return GoKungfuStaleSync(GoInt(step), GoInt(bound), nullptr);
+}
+
+int Peer::StaleSync(int step, int bound, const DoneCallback &done)
+{
+    return GoKungfuStaleSync(GoInt(step), GoInt(bound),
+                             new CallbackWrapper(done));
+}
+
 int Peer::ProposeNewSize(int new_size)
 {
     return GoKungfuProposeNewSize(GoInt(new_size));
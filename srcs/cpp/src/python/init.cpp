@@ -27,7 +27,98 @@ int kungfu_local_size() { return _default_peer->LocalSize(); }
 
 void kungfu_barrier() { _default_peer->Barrier(); }
 
+int kungfu_step() { return _default_peer->Step(); }
+
+int kungfu_epoch() { return _default_peer->Epoch(); }
+
+int kungfu_advance_step() { return _default_peer->AdvanceStep(); }
+
+int kungfu_advance_epoch() { return _default_peer->AdvanceEpoch(); }
+
+void kungfu_begin_step() { _default_peer->BeginStep(); }
+
+int kungfu_end_step(int32_t *collectives, int64_t *bytes, float *duration,
+                    float *critical_path, float *fusion_efficiency)
+{
+    return _default_peer->EndStep(collectives, bytes, duration, critical_path,
+                                  fusion_efficiency);
+}
+
+int kungfu_abort(const char *reason) { return _default_peer->Abort(reason); }
+
+int kungfu_report_signal(const char *name, double value)
+{
+    return _default_peer->ReportSignal(name, value);
+}
+
+int kungfu_fusion_size() { return _default_peer->FusionSize(); }
+
 int kungfu_propose_new_size(int new_size)
 {
     return _default_peer->ProposeNewSize(new_size);
 }
+
+int kungfu_loss_scale_consensus(int overflow, float scale, char *any_overflow,
+                                float *next_scale, const char *name)
+{
+    bool o;
+    const int code = _default_peer->LossScaleConsensus(overflow, scale, &o,
+                                                       next_scale, name);
+    *any_overflow = o;
+    return code;
+}
+
+int kungfu_top_k(float score, int k, int32_t *ranks, const char *name)
+{
+    return _default_peer->TopK(score, k, ranks, name);
+}
+
+int kungfu_top_k_exploit(float score, int k, int32_t *ranks,
+                         const void *sendbuf, void *recvbuf, int size,
+                         const char *name)
+{
+    return _default_peer->TopKExploit(score, k, ranks, sendbuf, recvbuf, size,
+                                      KungFu_UINT8, name);
+}
+
+int kungfu_cached_broadcast(const void *sendbuf, void *recvbuf, int size,
+                            const char *name)
+{
+    return _default_peer->CachedBroadcast(sendbuf, recvbuf, size,
+                                          KungFu_UINT8, name);
+}
+
+void kungfu_invalidate_broadcast(const char *name)
+{
+    _default_peer->InvalidateBroadcast(name);
+}
+
+int kungfu_set_post_hooks(const char *name, const char *hooks)
+{
+    return _default_peer->SetPostHooks(name, hooks);
+}
+
+int kungfu_stale_sync(int step, int bound)
+{
+    return _default_peer->StaleSync(step, bound);
+}
+
+int kungfu_snapshot_boundary(const char *name, const void *buf, int size)
+{
+    return _default_peer->SnapshotBoundary(name, buf, size, KungFu_UINT8);
+}
+
+int kungfu_step_boundary(int step, char *changed, char *keep)
+{
+    bool c, k;
+    const int code = _default_peer->StepBoundary(step, &c, &k);
+    *changed = c;
+    *keep = k;
+    return code;
+}
+
+int kungfu_checkpoint(const char *url, const char *name, const void *buf,
+                      int size)
+{
+    return _default_peer->Checkpoint(url, name, buf, size, KungFu_UINT8);
+}
@@ -1,3 +1,8 @@
+#include <string>
+
+#include <cuda_runtime.h>
+#include <nccl.h>
+
 #include <kungfu.h>
 #include <kungfu/nccl/helper.hpp>
 #include <kungfu/python/init.h>
@@ -10,3 +15,14 @@ void kungfu_python_init_nccl()
 }
 
 void kungfu_python_finialize_nccl() { _default_nccl_helper.reset(nullptr); }
+
+void kungfu_python_init_fingerprint()
+{
+    int version;
+    if (cudaRuntimeGetVersion(&version) == cudaSuccess) {
+        kungfu_set_fingerprint("cuda", std::to_string(version).c_str());
+    }
+    if (ncclGetVersion(&version) == ncclSuccess) {
+        kungfu_set_fingerprint("nccl", std::to_string(version).c_str());
+    }
+}
@@ -15,6 +15,33 @@ int Peer::LocalSize() const { return GoKungfuLocalSize(); }
 
 int Peer::HostCount() const { return GoKungfuHostCount(); }
 
+int Peer::Step() const { return GoKungfuStep(); }
+
+int Peer::Epoch() const { return GoKungfuEpoch(); }
+
+int Peer::AdvanceStep() { return GoKungfuAdvanceStep(); }
+
+int Peer::AdvanceEpoch() { return GoKungfuAdvanceEpoch(); }
+
+void Peer::BeginStep() { GoKungfuBeginStep(); }
+
+int Peer::EndStep(int32_t *collectives, int64_t *bytes, float *duration,
+                  float *critical_path, float *fusion_efficiency)
+{
+    return GoKungfuEndStep(collectives, bytes, duration, critical_path,
+                           fusion_efficiency);
+}
+
+int Peer::Abort(const char *reason)
+{
+    return GoKungfuAbort(const_cast<char *>(reason));
+}
+
+int Peer::ReportSignal(const char *name, double value)
+{
+    return GoKungfuReportSignal(const_cast<char *>(name), value);
+}
+
 int Peer::Barrier() { return GoKungfuBarrier(nullptr); }
 
 int Peer::Barrier(const DoneCallback &done)
@@ -39,7 +66,7 @@ int Peer::Consensus(const void *buf, int count, KungFu_Datatype dtype, bool *ok,
                              new CallbackWrapper(done));
 }
 
-int Peer::Reduce(const void *sendbuf, void *recvbuf, int count,
+int Peer::Reduce(const void *sendbuf, void *recvbuf, int64_t count,
                  KungFu_Datatype dtype, KungFu_Op op, const char *name,
                  const DoneCallback &done)
 {
@@ -48,14 +75,14 @@ int Peer::Reduce(const void *sendbuf, void *recvbuf, int count,
                           new CallbackWrapper(done));
 }
 
-int Peer::AllReduce(const void *sendbuf, void *recvbuf, int count,
+int Peer::AllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                     KungFu_Datatype dtype, KungFu_Op op, const char *name)
 {
     return GoKungfuAllReduce(const_cast<void *>(sendbuf), recvbuf, GoInt(count),
                              dtype, op, const_cast<char *>(name), nullptr);
 }
 
-int Peer::AllReduce(const void *sendbuf, void *recvbuf, int count,
+int Peer::AllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                     KungFu_Datatype dtype, KungFu_Op op, const char *name,
                     const DoneCallback &done)
 {
@@ -64,7 +91,7 @@ int Peer::AllReduce(const void *sendbuf, void *recvbuf, int count,
                              new CallbackWrapper(done));
 }
 
-int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
+int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                          KungFu_Datatype dtype, KungFu_Op op, const char *name)
 {
     return GoKungfuCrossAllReduce(const_cast<void *>(sendbuf), recvbuf,
@@ -72,7 +99,7 @@ int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
                                   const_cast<char *>(name), nullptr);
 }
 
-int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
+int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int64_t count,
                          KungFu_Datatype dtype, KungFu_Op op, const char *name,
                          const DoneCallback &done)
 {
@@ -81,6 +108,43 @@ int Peer::CrossAllReduce(const void *sendbuf, void *recvbuf, int count,
         const_cast<char *>(name), new CallbackWrapper(done));
 }
 
+int Peer::GradientNoiseScale(const void *sendbuf, void *recvbuf, int count,
+                             KungFu_Datatype dtype, int batch_size,
+                             float *result, const char *name,
+                             const DoneCallback &done)
+{
+    return GoKungfuGradientNoiseScale(
+        const_cast<void *>(sendbuf), recvbuf, GoInt(count), dtype,
+        GoInt(batch_size), result, const_cast<char *>(name),
+        new CallbackWrapper(done));
+}
+
+int Peer::GlobalNorm(const void *const *bufs, const int32_t *counts, int n,
+                     KungFu_Datatype dtype, float *norm, const char *name,
+                     const DoneCallback &done)
+{
+    return GoKungfuGlobalNorm(const_cast<void **>(bufs),
+                              const_cast<int32_t *>(counts), GoInt(n), dtype,
+                              norm, const_cast<char *>(name),
+                              new CallbackWrapper(done));
+}
+
+int Peer::BatchNormStats(const float *sendbuf, float *recvbuf, int channels,
+                         const char *name)
+{
+    return GoKungfuBatchNormStats(const_cast<float *>(sendbuf), recvbuf,
+                                  GoInt(channels), const_cast<char *>(name),
+                                  nullptr);
+}
+
+int Peer::BatchNormStats(const float *sendbuf, float *recvbuf, int channels,
+                         const char *name, const DoneCallback &done)
+{
+    return GoKungfuBatchNormStats(const_cast<float *>(sendbuf), recvbuf,
+                                  GoInt(channels), const_cast<char *>(name),
+                                  new CallbackWrapper(done));
+}
+
 int Peer::MonitoredAllReduce(const void *sendbuf, void *recvbuf, int count,
                              KungFu_Datatype dtype, KungFu_Op op,
                              const int32_t *tree, const char *name,
@@ -107,14 +171,14 @@ int Peer::AllGather(const void *sendbuf, int count, KungFu_Datatype dtype,
                              new CallbackWrapper(done));
 }
 
-int Peer::Broadcast(const void *sendbuf, void *recvbuf, int count,
+int Peer::Broadcast(const void *sendbuf, void *recvbuf, int64_t count,
                     KungFu_Datatype dtype, const char *name)
 {
     return GoKungfuBroadcast(const_cast<void *>(sendbuf), recvbuf, GoInt(count),
                              dtype, const_cast<char *>(name), nullptr);
 }
 
-int Peer::Broadcast(const void *sendbuf, void *recvbuf, int count,
+int Peer::Broadcast(const void *sendbuf, void *recvbuf, int64_t count,
                     KungFu_Datatype dtype, const char *name,
                     const DoneCallback &done)
 {
@@ -123,7 +187,84 @@ int Peer::Broadcast(const void *sendbuf, void *recvbuf, int count,
                              new CallbackWrapper(done));
 }
 
-int Peer::LocalBroadcast(const void *sendbuf, void *recvbuf, int count,
+int Peer::CachedBroadcast(const void *sendbuf, void *recvbuf, int count,
+                          KungFu_Datatype dtype, const char *name)
+{
+    return GoKungfuCachedBroadcast(const_cast<void *>(sendbuf), recvbuf,
+                                   GoInt(count), dtype,
+                                   const_cast<char *>(name), nullptr);
+}
+
+int Peer::CachedBroadcast(const void *sendbuf, void *recvbuf, int count,
+                          KungFu_Datatype dtype, const char *name,
+                          const DoneCallback &done)
+{
+    return GoKungfuCachedBroadcast(const_cast<void *>(sendbuf), recvbuf,
+                                   GoInt(count), dtype,
+                                   const_cast<char *>(name),
+                                   new CallbackWrapper(done));
+}
+
+void Peer::InvalidateBroadcast(const char *name)
+{
+    GoKungfuInvalidateBroadcast(const_cast<char *>(name));
+}
+
+int Peer::SetPostHooks(const char *name, const char *hooks)
+{
+    return GoKungfuSetPostHooks(const_cast<char *>(name),
+                                const_cast<char *>(hooks));
+}
+
+int Peer::LossScaleConsensus(bool overflow, float scale, bool *any_overflow,
+                             float *next_scale, const char *name)
+{
+    static_assert(sizeof(bool) == sizeof(char), "");
+    return GoKungfuLossScaleConsensus(
+        GoInt(overflow), scale, reinterpret_cast<char *>(any_overflow),
+        next_scale, const_cast<char *>(name));
+}
+
+int Peer::TopK(float score, int k, int32_t *ranks, const char *name)
+{
+    return GoKungfuTopK(score, GoInt(k), ranks, const_cast<char *>(name));
+}
+
+int Peer::TopKExploit(float score, int k, int32_t *ranks, const void *sendbuf,
+                      void *recvbuf, int count, KungFu_Datatype dtype,
+                      const char *name)
+{
+    return GoKungfuTopKExploit(score, GoInt(k), ranks,
+                               const_cast<void *>(sendbuf), recvbuf,
+                               GoInt(count), dtype, const_cast<char *>(name));
+}
+
+int Peer::AssignShard(const char *name, int size, int32_t *owner)
+{
+    return GoKungfuAssignShard(const_cast<char *>(name), GoInt(size), owner);
+}
+
+int Peer::ShardBroadcast(const void *sendbuf, void *recvbuf, int count,
+                         KungFu_Datatype dtype, const char *name,
+                         const DoneCallback &done)
+{
+    return GoKungfuShardBroadcast(const_cast<void *>(sendbuf), recvbuf,
+                                  GoInt(count), dtype,
+                                  const_cast<char *>(name),
+                                  new CallbackWrapper(done));
+}
+
+int Peer::ShardReduce(const void *sendbuf, void *recvbuf, int count,
+                      KungFu_Datatype dtype, KungFu_Op op, const char *name,
+                      const DoneCallback &done)
+{
+    return GoKungfuShardReduce(const_cast<void *>(sendbuf), recvbuf,
+                               GoInt(count), dtype, op,
+                               const_cast<char *>(name),
+                               new CallbackWrapper(done));
+}
+
+int Peer::LocalBroadcast(const void *sendbuf, void *recvbuf, int64_t count,
                          KungFu_Datatype dtype, const char *name)
 {
     return GoKungfuLocalBroadcast(const_cast<void *>(sendbuf), recvbuf,
@@ -131,7 +272,7 @@ int Peer::LocalBroadcast(const void *sendbuf, void *recvbuf, int count,
                                   nullptr);
 }
 
-int Peer::LocalBroadcast(const void *sendbuf, void *recvbuf, int count,
+int Peer::LocalBroadcast(const void *sendbuf, void *recvbuf, int64_t count,
                          KungFu_Datatype dtype, const char *name,
                          const DoneCallback &done)
 {
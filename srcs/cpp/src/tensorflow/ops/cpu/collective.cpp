@@ -60,13 +60,19 @@ static const std::map<std::string, KungFu_Op> kungfu_ops({
     {"min", KungFu_MIN},
     {"max", KungFu_MAX},
     {"prod", KungFu_PROD},
+    {"adasum", KungFu_ADASUM},
+    {"land", KungFu_LAND},
+    {"lor", KungFu_LOR},
+    {"band", KungFu_BAND},
+    {"bor", KungFu_BOR},
+    {"bxor", KungFu_BXOR},
 });
 
 // The AllReduce operator takes a single tensor (e.g. the computed gradient),
 // and reduce (by taking sum) with the peers, and finally returns a tensor with
 // exactly the same shape.
 REGISTER_KUNGFU_OP(AllReduce)
-    .Attr("T: {int32, int64, float16, float32, float64}")
+    .Attr("T: {int32, int64, float16, bfloat16, float32, float64}")
     .Attr("op: string")
     .Input("input: T")
     .Output("output: T")
@@ -103,7 +109,7 @@ class AllReduce : public AsyncOpKernel
 REGISTER_KUNGFU_KERNEL_BUILDER(AllReduce, DEVICE_CPU);
 
 REGISTER_KUNGFU_OP(MonitoredAllReduce)
-    .Attr("T: {int32, int64, float16, float32, float64}")
+    .Attr("T: {int32, int64, float16, bfloat16, float32, float64}")
     .Attr("op: string")
     .Input("input: T")
     .Input("tree: int32")
@@ -152,7 +158,7 @@ class MonitoredAllReduce : public AsyncOpKernel
 REGISTER_KUNGFU_KERNEL_BUILDER(MonitoredAllReduce, DEVICE_CPU);
 
 REGISTER_KUNGFU_OP(AllGather)
-    .Attr("T: {int32, int64, float16, float32, float64, bool}")
+    .Attr("T: {int32, int64, float16, bfloat16, float32, float64, bool}")
     .Input("input: T")
     .Output("output: T");
 // .SetShapeFn(shape_inference::UnchangedShape);
@@ -183,7 +189,7 @@ class AllGather : public AsyncOpKernel
 REGISTER_KUNGFU_KERNEL_BUILDER(AllGather, DEVICE_CPU);
 
 REGISTER_KUNGFU_OP(Broadcast)
-    .Attr("T: {int32, int64, float16, float32, float64, bool}")
+    .Attr("T: {int32, int64, float16, bfloat16, float32, float64, bool}")
     .Input("input: T")
     .Output("output: T")
     .SetShapeFn(shape_inference::UnchangedShape);
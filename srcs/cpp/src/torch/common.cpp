@@ -43,6 +43,12 @@ const std::map<std::string, KungFu_Op> _kungfu_ops({
     {"min", KungFu_MIN},
     {"max", KungFu_MAX},
     {"prod", KungFu_PROD},
+    {"adasum", KungFu_ADASUM},
+    {"land", KungFu_LAND},
+    {"lor", KungFu_LOR},
+    {"band", KungFu_BAND},
+    {"bor", KungFu_BOR},
+    {"bxor", KungFu_BXOR},
 });
 
 const std::map<std::string, Torch_Tensor_Type> _torch_tensor_types({
@@ -4,9 +4,16 @@ namespace kungfu
 {
 void all_reduce_cpu(torch::Tensor input, torch::Tensor output,
                     const std::string &type, const std::string &op);
+void batch_norm_stats_cpu(torch::Tensor input, torch::Tensor output,
+                          const std::string &type, const std::string &name);
+void register_buffer(torch::Tensor x);
+void release_buffer(uintptr_t ptr);
 }  // namespace kungfu
 
 PYBIND11_MODULE(TORCH_EXTENSION_NAME, m)
 {
     m.def("all_reduce_cpu", &kungfu::all_reduce_cpu);    //
+    m.def("batch_norm_stats_cpu", &kungfu::batch_norm_stats_cpu);
+    m.def("register_buffer", &kungfu::register_buffer);
+    m.def("release_buffer", &kungfu::release_buffer);
 }
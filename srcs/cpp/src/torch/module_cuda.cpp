@@ -16,6 +16,9 @@ int broadcast_cuda_async(torch::Tensor input, torch::Tensor output,
                          const std::string &type,
                          const std::string &tensor_name);
 
+void register_buffer(torch::Tensor x);
+void release_buffer(uintptr_t ptr);
+
 void wait_handle(int handle);
 void wait_all_handles(const std::vector<int> &handles);
 }  // namespace kungfu
@@ -24,6 +27,8 @@ PYBIND11_MODULE(TORCH_EXTENSION_NAME, m)
 {
     m.def("all_reduce_cpu", &kungfu::all_reduce_cpu);
     m.def("all_reduce_cuda", &kungfu::all_reduce_cuda);
+    m.def("register_buffer", &kungfu::register_buffer);
+    m.def("release_buffer", &kungfu::release_buffer);
     m.def("all_reduce_cuda_async", &kungfu::all_reduce_cuda_async);
     m.def("broadcast_cuda_async", &kungfu::broadcast_cuda_async);
 
@@ -33,4 +33,30 @@ void all_reduce_cpu(torch::Tensor input, torch::Tensor output,
         std::cerr << __func__ << " not implemented for " << type << std::endl;
     }
 }
+
+// register_buffer registers the memory of a host tensor that is all reduced in
+// every step, e.g. a gradient, so that the RDMA transport sends it without a copy
+void register_buffer(torch::Tensor x)
+{
+    _default_peer->RegisterBuffer(x.data_ptr(), data_size(x));
+}
+
+void release_buffer(uintptr_t ptr)
+{
+    _default_peer->ReleaseBuffer(reinterpret_cast<void *>(ptr));
+}
+
+void batch_norm_stats_cpu(torch::Tensor input, torch::Tensor output,
+                          const std::string &type, const std::string &name)
+{
+    const auto tt = _torch_tensor_types.at(type);
+    if (tt != Torch_Cpu_Float) {
+        std::cerr << __func__ << " not implemented for " << type << std::endl;
+        return;
+    }
+    const int channels = get_tensor_shape(input).size() / 2;
+    _default_peer->BatchNormStats(
+        reinterpret_cast<const float *>(input.data_ptr()),
+        reinterpret_cast<float *>(output.data_ptr()), channels, name.c_str());
+}
 }  // namespace kungfu
@@ -53,6 +53,18 @@ int all_reduce_cuda_async(torch::Tensor input, torch::Tensor output,
     return handle;
 }
 
+// device tensors of at least this size are broadcast by GPUDirect RDMA if all
+// peers agreed on it, smaller ones don't amortize the registration of memory
+constexpr size_t gpudirect_threshold = 1 << 20;
+
+// use_gpudirect agrees on GPUDirect RDMA with all peers at the first broadcast,
+// which all peers submit from the same thread in the same order
+bool use_gpudirect(size_t size)
+{
+    static const bool gpudirect = _default_peer->GPUDirect();
+    return gpudirect && size >= gpudirect_threshold;
+}
+
 int broadcast_cuda_async(torch::Tensor input, torch::Tensor output,
                          const std::string &type,
                          const std::string &tensor_name)
@@ -63,7 +75,21 @@ int broadcast_cuda_async(torch::Tensor input, torch::Tensor output,
     const void *px              = input.data_ptr();
     void *py                    = output.data_ptr();
 
+    const bool gpudirect = use_gpudirect(size);
+    if (gpudirect && _default_peer->RegisterDeviceBuffer(py, size) != 0) {
+        throw std::runtime_error("RegisterDeviceBuffer failed");
+    }
     const int handle = _torch_cuda_helper.handle_manager().create();
+    if (gpudirect) {
+        // broadcast in place in device memory, which the peers write directly
+        _torch_cuda_helper.copy_cuda(py, px, size);
+        _default_peer->Broadcast(
+            py, py, count, dtype, tensor_name.c_str(), [=] {
+                _default_peer->ReleaseDeviceBuffer(py);
+                _torch_cuda_helper.handle_manager().done(handle);
+            });
+        return handle;
+    }
     _default_peer->Noop([=] {
         char *buffer = new char[size];
         _torch_cuda_helper.from_cuda(buffer, px, size);
@@ -32,6 +32,11 @@ void TorchCudaHelper::to_cuda(void *dst, const void *src, size_t size)
     up_stream_->memcpy(dst, src, size, cudaMemcpyHostToDevice);
 }
 
+void TorchCudaHelper::copy_cuda(void *dst, const void *src, size_t size)
+{
+    down_stream_->memcpy(dst, src, size, cudaMemcpyDeviceToDevice);
+}
+
 void wait_handle(int handle)
 {
     _torch_cuda_helper.handle_manager().wait(handle);
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+var (
+	server   = flag.String("server", "http://127.0.0.1:8080", "control server URL of rank 0")
+	sockFile = flag.String("sock", "", "Unix socket file of the control server of rank 0, overrides -server")
+)
+
+var client = http.DefaultClient
+
+const usage = `Usage: kungfu-ctl [-server URL | -sock FILE] <command> [args]
+
+Commands:
+    progress                    show job progress
+    peers                       list peers with their capabilities
+    sessions                    show the bytes, time on wire and queueing delay of each session of rank 0
+    probe <A> <B> [size]        measure the link from rank A to rank B by sending size bytes (default 1MiB)
+    graph [stream]              show the graphs of the active strategies with the rates of their links, as JSON nodes and links,
+                                or a graph per line every second
+    degraded <A> <B>            report the link from rank A to rank B as degraded, to invalidate its stats and re-probe it
+    pause                       pause training at the next step boundary
+    resume                      resume training
+    scale <N>                   resize the job to N workers
+    snapshot <name> [min-step]  write the model snapshot of name taken at min-step or later (default: next step) to stdout
+    strategy list               list global strategies
+    strategy stats              show the messages, bytes, bandwidth and errors of each global strategy of rank 0
+    strategy suspend <name>     stop using a strategy
+    strategy resume <name>      resume a suspended strategy
+    strategy install <name> <file.json|file.dot>
+                                install a broadcast tree, given as a forest array (JSON) or a digraph (DOT)
+    strategy retune             regenerate the global strategies
+`
+
+var errInvalidCommand = errors.New("invalid command")
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if len(*sockFile) > 0 {
+		client = unixClient(*sockFile)
+		*server = "http://unix"
+	}
+	if err := run(flag.Args()); err != nil {
+		if err == errInvalidCommand {
+			flag.Usage()
+		}
+		utils.ExitErr(err)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 1 {
+		return errInvalidCommand
+	}
+	switch cmd, args := args[0], args[1:]; {
+	case cmd == "progress" && len(args) == 0:
+		return get("/progress")
+	case cmd == "peers" && len(args) == 0:
+		return get("/peers")
+	case cmd == "sessions" && len(args) == 0:
+		return get("/sessions")
+	case cmd == "probe" && (len(args) == 2 || len(args) == 3):
+		q := url.Values{"a": {args[0]}, "b": {args[1]}}
+		if len(args) == 3 {
+			q.Set("size", args[2])
+		}
+		return get("/links/probe?" + q.Encode())
+	case cmd == "graph" && len(args) == 0:
+		return get("/graph")
+	case cmd == "graph" && len(args) == 1 && args[0] == "stream":
+		return get("/graph?stream=1")
+	case cmd == "degraded" && len(args) == 2:
+		return post("/links/degraded", url.Values{"from": {args[0]}, "to": {args[1]}}, nil)
+	case cmd == "pause" && len(args) == 0:
+		return post("/pause", nil, nil)
+	case cmd == "resume" && len(args) == 0:
+		return post("/resume", nil, nil)
+	case cmd == "scale" && len(args) == 1:
+		return post("/scale", url.Values{"size": {args[0]}}, nil)
+	case cmd == "snapshot" && (len(args) == 1 || len(args) == 2):
+		q := url.Values{"name": {args[0]}}
+		if len(args) == 2 {
+			q.Set("min_step", args[1])
+		}
+		return getSnapshot("/snapshot?" + q.Encode())
+	case cmd == "strategy" && len(args) > 0:
+		return runStrategy(args[0], args[1:])
+	}
+	return errInvalidCommand
+}
+
+func runStrategy(cmd string, args []string) error {
+	switch {
+	case cmd == "list" && len(args) == 0:
+		return get("/strategies")
+	case cmd == "stats" && len(args) == 0:
+		return get("/strategies/stats")
+	case cmd == "suspend" && len(args) == 1:
+		return post("/strategies/suspend", url.Values{"name": {args[0]}}, nil)
+	case cmd == "resume" && len(args) == 1:
+		return post("/strategies/resume", url.Values{"name": {args[0]}}, nil)
+	case cmd == "retune" && len(args) == 0:
+		return post("/strategies/retune", nil, nil)
+	case cmd == "install" && len(args) == 2:
+		forest, err := readForest(args[1])
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(map[string]interface{}{"name": args[0], "forest": forest})
+		if err != nil {
+			return err
+		}
+		return post("/strategies/install", nil, bytes.NewReader(body))
+	}
+	return errInvalidCommand
+}
+
+func readForest(filename string) ([]int32, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(path.Ext(filename)) == ".dot" {
+		g, err := graph.FromDOT(string(bs))
+		if err != nil {
+			return nil, err
+		}
+		return g.ForestArray()
+	}
+	var forest []int32
+	if err := json.Unmarshal(bs, &forest); err != nil {
+		return nil, err
+	}
+	return forest, nil
+}
+
+func get(p string) error {
+	resp, err := client.Get(*server + p)
+	if err != nil {
+		return err
+	}
+	return show(resp)
+}
+
+const snapshotStepHeader = `X-KungFu-Step` // see peer.SnapshotStepHeader
+
+// getSnapshot writes the snapshot to stdout and its step to stderr.
+func getSnapshot(p string) error {
+	resp, err := client.Get(*server + p)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		fmt.Fprintf(os.Stderr, "step: %s\n", resp.Header.Get(snapshotStepHeader))
+	}
+	return show(resp)
+}
+
+func post(p string, q url.Values, body io.Reader) error {
+	u := *server + p
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	resp, err := client.Post(u, "application/json", body)
+	if err != nil {
+		return err
+	}
+	return show(resp)
+}
+
+func unixClient(sockFile string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sockFile)
+			},
+		},
+	}
+}
+
+func show(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(bs)))
+	}
+	_, err := io.Copy(os.Stdout, resp.Body)
+	return err
+}
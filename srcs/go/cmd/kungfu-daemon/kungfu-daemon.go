@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/daemon"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+var (
+	sockFile  = flag.String("sock", daemon.DefaultSockFile, "Unix socket file, jobs attach to it by setting "+config.DaemonSockEnvKey)
+	bandwidth = flag.Int("bandwidth", 0, "bandwidth in Mbps shared by jobs, the NIC speed is used if 0")
+	slots     = flag.Int("slots", 0, "workers of all jobs, jobs of lower priority are shrunk to fit, no limit if 0")
+	listJobs  = flag.Bool("jobs", false, "list jobs of the running daemon")
+)
+
+func main() {
+	flag.Parse()
+	if *listJobs {
+		jobs, err := daemon.Attach(*sockFile, "", 0).Jobs()
+		if err != nil {
+			utils.ExitErr(err)
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "    ")
+		e.Encode(jobs)
+		return
+	}
+	d := daemon.New(*sockFile, *bandwidth, *slots)
+	if err := d.Listen(); err != nil {
+		utils.ExitErr(err)
+	}
+	log.Infof("kungfu-daemon listening on %s", *sockFile)
+	utils.Trap(func(os.Signal) { d.Close() })
+	d.Serve()
+	os.Remove(*sockFile)
+}
@@ -73,6 +73,7 @@ func Main(args []string) {
 	j := job.Job{
 		StartTime:   time.Unix(int64(f.JobStartTime), 0),
 		Strategy:    f.Strategy,
+		Profile:     f.Profile,
 		Parent:      self,
 		HostList:    f.HostList,
 		PortRange:   f.PortRange,
@@ -91,6 +92,18 @@ func Main(args []string) {
 		Runners: runners,
 		Workers: peers,
 	}
+	if len(f.Sweep) > 0 {
+		spec, err := runner.ParseSweepSpec(f.Sweep)
+		if err != nil {
+			utils.ExitErr(err)
+		}
+		for _, r := range runner.Sweep(ctx, localhostIPv4, hl, f.PortRange, j, &f, spec) {
+			if len(r.Err) > 0 {
+				os.Exit(1)
+			}
+		}
+		return
+	}
 	if f.Watch {
 		ch := make(chan runner.Stage, 1)
 		if f.InitVersion < 0 {
@@ -0,0 +1,62 @@
+package base
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A ReduceFunc performs z[i] = x[i] op y[i] for vectors z and x, y, of the same count and type. z may alias x or y.
+// It must be element-wise, as Workspaces are split into chunks reduced separately.
+type ReduceFunc func(z, x, y *Vector)
+
+// customOPBase is the first OP of registered ReduceFuncs, far above the ops of kungfu/op.h.
+const customOPBase OP = 1 << 16
+
+type customOP struct {
+	name string
+	f    ReduceFunc
+}
+
+var customOPs = struct {
+	sync.RWMutex
+	ops   []customOP
+	index map[string]OP
+}{index: make(map[string]OP)}
+
+// RegisterOP registers f as the reduce function of the named op, and returns the OP to use in Workspaces.
+// Peers must register the same ops in the same order, so that their OPs agree, which collectives check by their names.
+// Registering a name again replaces its function, and returns the same OP.
+func RegisterOP(name string, f ReduceFunc) (OP, error) {
+	if len(name) == 0 || f == nil {
+		return 0, fmt.Errorf("invalid op %q", name)
+	}
+	for _, builtin := range opNames {
+		if name == builtin {
+			return 0, fmt.Errorf("op %q is builtin", name)
+		}
+	}
+	customOPs.Lock()
+	defer customOPs.Unlock()
+	if op, ok := customOPs.index[name]; ok {
+		customOPs.ops[op-customOPBase].f = f
+		return op, nil
+	}
+	op := customOPBase + OP(len(customOPs.ops))
+	customOPs.ops = append(customOPs.ops, customOP{name: name, f: f})
+	customOPs.index[name] = op
+	return op, nil
+}
+
+// IsCustom returns true if op was returned by RegisterOP.
+func (op OP) IsCustom() bool {
+	return op >= customOPBase
+}
+
+func lookupOP(op OP) (string, ReduceFunc, bool) {
+	customOPs.RLock()
+	defer customOPs.RUnlock()
+	if i := int(op - customOPBase); op.IsCustom() && i < len(customOPs.ops) {
+		return customOPs.ops[i].name, customOPs.ops[i].f, true
+	}
+	return "", nil, false
+}
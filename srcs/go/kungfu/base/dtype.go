@@ -1,8 +1,11 @@
 package base
 
 // #include "kungfu/dtype.h"
+// #include "f16.h"
 import "C"
 
+import "sort"
+
 type DataType C.KungFu_Datatype
 
 const (
@@ -19,6 +22,8 @@ const (
 	F16 DataType = C.KungFu_FLOAT16
 	F32 DataType = C.KungFu_FLOAT
 	F64 DataType = C.KungFu_DOUBLE
+
+	BF16 DataType = C.KungFu_BFLOAT16
 )
 
 func (t DataType) Size() int {
@@ -39,8 +44,25 @@ var dtypeNames = map[DataType]string{
 	F16: "f16",
 	F32: "f32",
 	F64: "f64",
+
+	BF16: "bf16",
 }
 
 func (t DataType) String() string {
 	return dtypeNames[t]
 }
+
+// DataTypeNames returns the names of all data types in order.
+func DataTypeNames() []string {
+	var names []string
+	for _, name := range dtypeNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// F16SIMD returns true if F16 values are summed by F16C instructions, which may round differently than the portable sum.
+func F16SIMD() bool {
+	return C.float16_simd() != 0
+}
@@ -1,10 +1,113 @@
 #include "f16.h"
 
+#include <math.h>
 #include <stdint.h>
+#include <string.h>
+
+float float16_to_float(uint16_t h)
+{
+    uint32_t sign = (uint32_t)(h & 0x8000) << 16;
+    uint32_t exp  = (h >> 10) & 0x1f;
+    uint32_t mant = h & 0x3ff;
+    uint32_t f;
+    if (exp == 0 && mant == 0) {
+        f = sign;
+    } else if (exp == 0) {  // subnormal
+        exp = 127 - 15 + 1;
+        for (; (mant & 0x400) == 0; mant <<= 1) { exp--; }
+        f = sign | (exp << 23) | ((mant & 0x3ff) << 13);
+    } else if (exp == 0x1f) {
+        f = sign | 0x7f800000 | (mant << 13);
+    } else {
+        f = sign | ((exp + 127 - 15) << 23) | (mant << 13);
+    }
+    float v;
+    memcpy(&v, &f, sizeof(v));
+    return v;
+}
+
+// float_to_float16 rounds to the nearest even.
+uint16_t float_to_float16(float v)
+{
+    uint32_t f;
+    memcpy(&f, &v, sizeof(f));
+    uint16_t sign = (f >> 16) & 0x8000;
+    uint32_t exp  = (f >> 23) & 0xff;
+    uint32_t mant = f & 0x7fffff;
+    if (exp == 0xff) { return sign | 0x7c00 | (mant ? 0x200 : 0); }
+    int e = (int)exp - 127 + 15;
+    if (e >= 0x1f) { return sign | 0x7c00; }
+    if (e <= 0) {  // subnormal
+        if (e < -10) { return sign; }
+        mant |= 0x800000;
+        int shift     = 14 - e;
+        uint32_t h    = mant >> shift;
+        uint32_t rem  = mant & ((1u << shift) - 1);
+        uint32_t half = 1u << (shift - 1);
+        if (rem > half || (rem == half && (h & 1))) { h++; }
+        return sign | h;
+    }
+    uint16_t h   = sign | (e << 10) | (mant >> 13);
+    uint32_t rem = mant & 0x1fff;
+    if (rem > 0x1000 || (rem == 0x1000 && (h & 1))) { h++; }  // may carry into the exponent
+    return h;
+}
+
+float bfloat16_to_float(uint16_t h)
+{
+    uint32_t f = (uint32_t)h << 16;
+    float v;
+    memcpy(&v, &f, sizeof(v));
+    return v;
+}
+
+// float_to_bfloat16 rounds to the nearest even.
+uint16_t float_to_bfloat16(float v)
+{
+    uint32_t f;
+    memcpy(&f, &v, sizeof(f));
+    if ((f & 0x7fffffff) > 0x7f800000) { return (f >> 16) | 0x40; }  // quiet NaN
+    return (f + 0x7fff + ((f >> 16) & 1)) >> 16;
+}
+
+// name##_sum_compensated adds in float, and keeps the rounding errors of the
+// addition and of the conversion to 16 bits in c, as in Neumaier summation.
+#define DEFINE_COMPENSATED(name)                                               \
+    void name##_sum_compensated(void *pz, float *c, const void *px,            \
+                                const void *py, int len)                       \
+    {                                                                          \
+        uint16_t *z       = (uint16_t *)pz;                                    \
+        const uint16_t *x = (const uint16_t *)px;                              \
+        const uint16_t *y = (const uint16_t *)py;                              \
+        for (int i = 0; i < len; ++i) {                                        \
+            const float a = name##_to_float(x[i]);                             \
+            const float b = name##_to_float(y[i]);                             \
+            const float t = a + b;                                             \
+            c[i] += fabsf(a) >= fabsf(b) ? (a - t) + b : (b - t) + a;          \
+            z[i] = float_to_##name(t);                                         \
+            c[i] += t - name##_to_float(z[i]);                                 \
+        }                                                                      \
+    }                                                                          \
+                                                                               \
+    void name##_compensate(void *pz, float *c, int len)                        \
+    {                                                                          \
+        uint16_t *z = (uint16_t *)pz;                                          \
+        for (int i = 0; i < len; ++i) {                                        \
+            z[i] = float_to_##name(name##_to_float(z[i]) + c[i]);              \
+            c[i] = 0;                                                          \
+        }                                                                      \
+    }
+
+DEFINE_COMPENSATED(float16)
+DEFINE_COMPENSATED(bfloat16)
+
+#undef DEFINE_COMPENSATED
 
 #ifdef ENABLE_F16
 #include <immintrin.h>
 
+int float16_simd() { return 1; }
+
 // -mavx # for _mm256_add_ps
 // -mf16c # for _mm256_cvtph_ps, _mm256_cvtps_ph
 
@@ -51,13 +154,16 @@ void float16_sum(void *pz, const void *px, const void *py, int len)
 
 #else
 
-#include <stdio.h>
-#include <stdlib.h>
+int float16_simd() { return 0; }
 
-void float16_sum(void *z, const void *x, const void *y, int len)
+void float16_sum(void *pz, const void *px, const void *py, int len)
 {
-    fprintf(stderr, "f16 support not enabled\n");
-    exit(1);
+    uint16_t *z       = (uint16_t *)pz;
+    const uint16_t *x = (const uint16_t *)px;
+    const uint16_t *y = (const uint16_t *)py;
+    for (int i = 0; i < len; ++i) {
+        z[i] = float_to_float16(float16_to_float(x[i]) + float16_to_float(y[i]));
+    }
 }
 
 #endif
@@ -1,10 +1,24 @@
 #pragma once
+#include <stdint.h>
 
 #ifdef __cplusplus
 extern "C" {
 #endif
 
 extern void float16_sum(void *z, const void *x, const void *y, int len);
+extern int float16_simd();  // 1 if float16_sum uses F16C instructions
+
+extern float float16_to_float(uint16_t h);
+extern uint16_t float_to_float16(float v);
+extern float bfloat16_to_float(uint16_t h);
+extern uint16_t float_to_bfloat16(float v);
+
+extern void float16_sum_compensated(void *z, float *c, const void *x,
+                                    const void *y, int len);
+extern void float16_compensate(void *z, float *c, int len);
+extern void bfloat16_sum_compensated(void *z, float *c, const void *x,
+                                     const void *y, int len);
+extern void bfloat16_compensate(void *z, float *c, int len);
 
 #ifdef __cplusplus
 }
@@ -1,7 +1,10 @@
 
 #include <algorithm>
+#include <cmath>
 #include <cstdint>
 #include <functional>
+#include <type_traits>
+#include <vector>
 
 #include "f16.h"
 #include "kungfu/op.h"
@@ -14,6 +17,80 @@ template <typename T> struct std_max {
     T operator()(const T &x, const T &y) const { return std::max(x, y); }
 };
 
+// https://arxiv.org/abs/2006.02924
+// z = (1 - x.y / 2|x|^2) x + (1 - x.y / 2|y|^2) y, z may alias x or y.
+template <typename T> void adasum(const T *x, const T *y, T *z, const int n)
+{
+    double dot = 0, xx = 0, yy = 0;
+    for (int i = 0; i < n; ++i) {
+        dot += static_cast<double>(x[i]) * y[i];
+        xx += static_cast<double>(x[i]) * x[i];
+        yy += static_cast<double>(y[i]) * y[i];
+    }
+    const double a = xx > 0 ? 1 - dot / (2 * xx) : 1;
+    const double b = yy > 0 ? 1 - dot / (2 * yy) : 1;
+    for (int i = 0; i < n; ++i) { z[i] = static_cast<T>(a * x[i] + b * y[i]); }
+}
+
+// z = x + y, with the rounding errors added to c, as in Neumaier summation,
+// where x is the running sum. z may alias x or y.
+template <typename T>
+void neumaier_sum(const T *x, const T *y, T *z, T *c, const int n)
+{
+    for (int i = 0; i < n; ++i) {
+        const T t = x[i] + y[i];
+        if (std::abs(x[i]) >= std::abs(y[i])) {
+            c[i] += (x[i] - t) + y[i];
+        } else {
+            c[i] += (y[i] - t) + x[i];
+        }
+        z[i] = t;
+    }
+}
+
+template <typename T> void compensate(T *z, T *c, const int n)
+{
+    for (int i = 0; i < n; ++i) {
+        z[i] += c[i];
+        c[i] = 0;
+    }
+}
+
+template <typename T> struct logical_and {
+    T operator()(const T &x, const T &y) const { return x != 0 && y != 0; }
+};
+
+template <typename T> struct logical_or {
+    T operator()(const T &x, const T &y) const { return x != 0 || y != 0; }
+};
+
+// bitwise applies the bitwise op o of integers, it exits for floats.
+template <typename T>
+void bitwise(const T *x, const T *y, T *z, const int n, const KungFu_Op o,
+             std::true_type)
+{
+    switch (o) {
+    case KungFu_BAND:
+        std::transform(x, x + n, y, z, std::bit_and<T>());
+        break;
+    case KungFu_BOR:
+        std::transform(x, x + n, y, z, std::bit_or<T>());
+        break;
+    case KungFu_BXOR:
+        std::transform(x, x + n, y, z, std::bit_xor<T>());
+        break;
+    default:
+        exit(1);
+    }
+}
+
+template <typename T>
+void bitwise(const T *x, const T *y, T *z, const int n, const KungFu_Op o,
+             std::false_type)
+{
+    exit(1);
+}
+
 struct workspace {
     const void *input1;
     const void *input2;
@@ -37,6 +114,20 @@ struct workspace {
         case KungFu_PROD:
             std::transform(x, x + n, y, z, std::multiplies<T>());
             break;
+        case KungFu_ADASUM:
+            adasum(x, y, z, n);
+            break;
+        case KungFu_LAND:
+            std::transform(x, x + n, y, z, logical_and<T>());
+            break;
+        case KungFu_LOR:
+            std::transform(x, x + n, y, z, logical_or<T>());
+            break;
+        case KungFu_BAND:
+        case KungFu_BOR:
+        case KungFu_BXOR:
+            bitwise(x, y, z, n, o, std::is_integral<T>());
+            break;
         default:
             exit(1);
         }
@@ -49,9 +140,29 @@ struct workspace {
             float16_sum(output, input1, input2, n);
             break;
         default:
-            exit(1);
+            call_as_16_bits<float16_to_float, float_to_float16>(n, o);
         }
     }
+
+    // call_as_16_bits computes in float, and rounds the results to 16 bits,
+    // once per pairwise op. See std_widen to round the result of many ops once.
+    template <float (*to_float)(uint16_t), uint16_t (*from_float)(float)>
+    void call_as_16_bits(const int n, const KungFu_Op o) const
+    {
+        const uint16_t *x = reinterpret_cast<const uint16_t *>(input1);
+        const uint16_t *y = reinterpret_cast<const uint16_t *>(input2);
+        uint16_t *z       = reinterpret_cast<uint16_t *>(output);
+        std::vector<float> a(n), b(n);
+        std::transform(x, x + n, a.begin(), to_float);
+        std::transform(y, y + n, b.begin(), to_float);
+        const workspace w = {
+            .input1 = a.data(),
+            .input2 = b.data(),
+            .output = a.data(),
+        };
+        w.call_as<float>(n, o);
+        std::transform(a.begin(), a.end(), z, from_float);
+    }
 };
 
 void std_transform_2(const void *input1, const void *input2, void *output,
@@ -85,9 +196,98 @@ void std_transform_2(const void *input1, const void *input2, void *output,
 
         CASE(KungFu_FLOAT, float);
         CASE(KungFu_DOUBLE, double);
+
+    case KungFu_BFLOAT16:
+        w.call_as_16_bits<bfloat16_to_float, float_to_bfloat16>(n, o);
+        break;
     default:
         exit(1);
     };
 
 #undef CASE
 }
+
+void std_transform_2_compensated(const void *input1, const void *input2,
+                                 void *output, void *comp, const int n,
+                                 const KungFu_Datatype dt)
+{
+    switch (dt) {
+    case KungFu_FLOAT16:
+        float16_sum_compensated(output, reinterpret_cast<float *>(comp),
+                                input1, input2, n);
+        break;
+    case KungFu_BFLOAT16:
+        bfloat16_sum_compensated(output, reinterpret_cast<float *>(comp),
+                                 input1, input2, n);
+        break;
+    case KungFu_FLOAT:
+        neumaier_sum(reinterpret_cast<const float *>(input1),
+                     reinterpret_cast<const float *>(input2),
+                     reinterpret_cast<float *>(output),
+                     reinterpret_cast<float *>(comp), n);
+        break;
+    case KungFu_DOUBLE:
+        neumaier_sum(reinterpret_cast<const double *>(input1),
+                     reinterpret_cast<const double *>(input2),
+                     reinterpret_cast<double *>(output),
+                     reinterpret_cast<double *>(comp), n);
+        break;
+    default:
+        exit(1);
+    }
+}
+
+void std_compensate(void *output, void *comp, const int n,
+                    const KungFu_Datatype dt)
+{
+    switch (dt) {
+    case KungFu_FLOAT16:
+        float16_compensate(output, reinterpret_cast<float *>(comp), n);
+        break;
+    case KungFu_BFLOAT16:
+        bfloat16_compensate(output, reinterpret_cast<float *>(comp), n);
+        break;
+    case KungFu_FLOAT:
+        compensate(reinterpret_cast<float *>(output),
+                   reinterpret_cast<float *>(comp), n);
+        break;
+    case KungFu_DOUBLE:
+        compensate(reinterpret_cast<double *>(output),
+                   reinterpret_cast<double *>(comp), n);
+        break;
+    default:
+        exit(1);
+    }
+}
+
+void std_widen(const void *input, float *output, const int n,
+               const KungFu_Datatype dt)
+{
+    const uint16_t *x = reinterpret_cast<const uint16_t *>(input);
+    switch (dt) {
+    case KungFu_FLOAT16:
+        std::transform(x, x + n, output, float16_to_float);
+        break;
+    case KungFu_BFLOAT16:
+        std::transform(x, x + n, output, bfloat16_to_float);
+        break;
+    default:
+        exit(1);
+    }
+}
+
+void std_narrow(const float *input, void *output, const int n,
+                const KungFu_Datatype dt)
+{
+    uint16_t *z = reinterpret_cast<uint16_t *>(output);
+    switch (dt) {
+    case KungFu_FLOAT16:
+        std::transform(input, input + n, z, float_to_float16);
+        break;
+    case KungFu_BFLOAT16:
+        std::transform(input, input + n, z, float_to_bfloat16);
+        break;
+    default:
+        exit(1);
+    }
+}
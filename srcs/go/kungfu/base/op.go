@@ -1,6 +1,12 @@
 package base
 
-import "unsafe"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/lsds/KungFu/srcs/go/utils/assert"
+)
 
 // #cgo CXXFLAGS: -std=c++11
 // #include "kungfu/op.h"
@@ -13,17 +19,77 @@ const (
 	MIN  OP = C.KungFu_MIN
 	MAX  OP = C.KungFu_MAX
 	PROD OP = C.KungFu_PROD
+
+	// ADASUM combines whole vectors, see ElementWise.
+	ADASUM OP = C.KungFu_ADASUM
+
+	// LAND and LOR result in 1 or 0, of non-zero values as 1.
+	LAND OP = C.KungFu_LAND
+	LOR  OP = C.KungFu_LOR
+
+	// BAND, BOR and BXOR are of integers only.
+	BAND OP = C.KungFu_BAND
+	BOR  OP = C.KungFu_BOR
+	BXOR OP = C.KungFu_BXOR
 )
 
+var opNames = map[OP]string{
+	SUM:    "sum",
+	MIN:    "min",
+	MAX:    "max",
+	PROD:   "prod",
+	ADASUM: "adasum",
+	LAND:   "land",
+	LOR:    "lor",
+	BAND:   "band",
+	BOR:    "bor",
+	BXOR:   "bxor",
+}
+
+func (op OP) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	if name, _, ok := lookupOP(op); ok {
+		return name
+	}
+	return fmt.Sprintf("op(%d)", int(op))
+}
+
+// ElementWise returns whether op combines vectors element by element. Only the Workspaces of element-wise OPs are
+// split into chunks, segments or stream segments that are reduced separately, or chosen a strategy by size.
+func (op OP) ElementWise() bool {
+	return op != ADASUM
+}
+
 // Transform performs y[i] += x[i] for vectors y and x
 func Transform(y, x *Vector, op OP) {
 	// Assuming Count and Type are consistent
 	Transform2(y, x, y, op)
 }
 
+// maxTransformCount is the max count of the kernels, which take int counts.
+const maxTransformCount = math.MaxInt32
+
 // Transform2 performs z[i] = x[i] + y[i] for vectors z and x, y.
 func Transform2(z, x, y *Vector, op OP) {
 	// Assuming Count and Type are consistent
+	if z.Count > maxTransformCount && op.ElementWise() {
+		for begin := 0; begin < z.Count; begin += maxTransformCount {
+			end := begin + maxTransformCount
+			if end > z.Count {
+				end = z.Count
+			}
+			Transform2(z.Slice(begin, end), x.Slice(begin, end), y.Slice(begin, end), op)
+		}
+		return
+	}
+	if op.IsCustom() {
+		_, f, ok := lookupOP(op)
+		assert.True(ok)
+		f(z, x, y)
+		return
+	}
 	C.std_transform_2(
 		// ptr(x.Data), // panic when x.Data is returned from bytes.Buffer
 		// ptr(y.Data),
@@ -35,6 +101,108 @@ func Transform2(z, x, y *Vector, op OP) {
 		C.int(z.Count), C.KungFu_Datatype(z.Type), C.KungFu_Op(op))
 }
 
+// A Compensator holds the rounding errors of the sums into a vector, to correct them as in compensated (Neumaier) summation,
+// which is more accurate than the plain sums of many terms, or of float16 values, at the cost of extra FLOPs.
+type Compensator struct {
+	dtype DataType
+	c     *Vector // of F32 for F16 and BF16
+}
+
+// NewCompensator returns a Compensator for vectors of count floats of dtype, and nil for other types, whose sums are exact.
+func NewCompensator(count int, dtype DataType) *Compensator {
+	switch dtype {
+	case F16, BF16:
+		return &Compensator{dtype: dtype, c: NewVector(count, F32)}
+	case F32, F64:
+		return &Compensator{dtype: dtype, c: NewVector(count, dtype)}
+	}
+	return nil
+}
+
+// Transform2 performs z[i] = x[i] + y[i] as Transform2 with SUM, and keeps the rounding errors, where x is the running sum.
+// A nil Compensator performs the plain sum.
+func (k *Compensator) Transform2(z, x, y *Vector) {
+	if k == nil {
+		Transform2(z, x, y, SUM)
+		return
+	}
+	C.std_transform_2_compensated(
+		unsafe.Pointer(&x.Data[0]),
+		unsafe.Pointer(&y.Data[0]),
+		unsafe.Pointer(&z.Data[0]),
+		unsafe.Pointer(&k.c.Data[0]),
+		C.int(z.Count), C.KungFu_Datatype(k.dtype))
+}
+
+// Compensate adds the rounding errors to z, and resets them.
+func (k *Compensator) Compensate(z *Vector) {
+	if k == nil {
+		return
+	}
+	C.std_compensate(unsafe.Pointer(&z.Data[0]), unsafe.Pointer(&k.c.Data[0]), C.int(z.Count), C.KungFu_Datatype(k.dtype))
+}
+
+// Terms returns the rounding errors, e.g. to send them with a partial sum, for the receiver to Add them to its own.
+func (k *Compensator) Terms() *Vector {
+	return k.c
+}
+
+// Add adds the rounding errors c of another sum, e.g. of a partial sum received from another peer.
+func (k *Compensator) Add(c *Vector) {
+	Transform2(k.c, k.c, c, SUM)
+}
+
+// Reset discards the rounding errors, e.g. once they are sent with the partial sum.
+func (k *Compensator) Reset() {
+	for i := range k.c.Data {
+		k.c.Data[i] = 0
+	}
+}
+
+// An Accumulator reduces vectors of 16-bit floats in float32, so that the result is rounded to 16 bits once,
+// rather than after each of the pairwise ops of Transform2.
+type Accumulator struct {
+	dtype   DataType
+	acc, y  *Vector // of F32
+	started bool
+}
+
+// NewAccumulator returns an Accumulator for vectors of count floats of dtype, and nil for types other than F16 and BF16.
+func NewAccumulator(count int, dtype DataType) *Accumulator {
+	switch dtype {
+	case F16, BF16:
+		return &Accumulator{dtype: dtype, acc: NewVector(count, F32), y: NewVector(count, F32)}
+	}
+	return nil
+}
+
+// Transform2 performs z[i] = x[i] op y[i] as Transform2, where x is the running result, which is kept in float32
+// since the first call after Reset, so that z is the running result rounded once. A nil Accumulator performs the plain op.
+func (a *Accumulator) Transform2(z, x, y *Vector, op OP) {
+	if a == nil {
+		Transform2(z, x, y, op)
+		return
+	}
+	if !a.started {
+		a.widen(a.acc, x)
+		a.started = true
+	}
+	a.widen(a.y, y)
+	Transform2(a.acc, a.acc, a.y, op)
+	C.std_narrow((*C.float)(unsafe.Pointer(&a.acc.Data[0])), unsafe.Pointer(&z.Data[0]), C.int(z.Count), C.KungFu_Datatype(a.dtype))
+}
+
+// Reset discards the running result, e.g. once z is overwritten by other values.
+func (a *Accumulator) Reset() {
+	if a != nil {
+		a.started = false
+	}
+}
+
+func (a *Accumulator) widen(z, x *Vector) {
+	C.std_widen(unsafe.Pointer(&x.Data[0]), (*C.float)(unsafe.Pointer(&z.Data[0])), C.int(z.Count), C.KungFu_Datatype(a.dtype))
+}
+
 // panic: runtime error: cgo argument has Go pointer to Go pointer
 // func ptr(bs []byte) unsafe.Pointer {
 // 	return unsafe.Pointer(&bs[0])
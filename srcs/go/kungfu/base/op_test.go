@@ -0,0 +1,242 @@
+package base
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func Test_AdaSum(t *testing.T) {
+	tests := []struct {
+		x, y, z []float32
+	}{
+		{[]float32{1, 0}, []float32{0, 1}, []float32{1, 1}}, // orthogonal: sum
+		{[]float32{1, 1}, []float32{1, 1}, []float32{1, 1}}, // parallel: average
+		{[]float32{0, 0}, []float32{2, 3}, []float32{2, 3}},
+	}
+	for _, tt := range tests {
+		x := NewVector(2, F32)
+		y := NewVector(2, F32)
+		z := NewVector(2, F32)
+		copy(x.AsF32(), tt.x)
+		copy(y.AsF32(), tt.y)
+		Transform2(z, x, y, ADASUM)
+		for i, v := range z.AsF32() {
+			if v != tt.z[i] {
+				t.Errorf("AdaSum(%v, %v) = %v, want %v", tt.x, tt.y, z.AsF32(), tt.z)
+				break
+			}
+		}
+	}
+}
+
+func Test_Compensator(t *testing.T) {
+	const n = 10000
+	tests := []struct {
+		dtype DataType
+		x     float64 // below half of the ulp of 1, so that plain sums stay 1
+		tol   float64
+	}{
+		{F16, 1.0 / 4096, 1.0 / 512},
+		{BF16, 1.0 / 512, 1.0 / 16},
+		{F32, 1e-8, 1e-7},
+		{F64, 1e-17, 1e-15},
+	}
+	for _, tt := range tests {
+		sum := NewVector(1, tt.dtype)
+		x := NewVector(1, tt.dtype)
+		setOne(sum, tt.dtype, 1)
+		setOne(x, tt.dtype, tt.x)
+		k := NewCompensator(1, tt.dtype)
+		for i := 0; i < n; i++ {
+			k.Transform2(sum, sum, x)
+		}
+		k.Compensate(sum)
+		want := 1 + n*getOne(x, tt.dtype)
+		if got := getOne(sum, tt.dtype); math.Abs(got-want) > tt.tol {
+			t.Errorf("compensated %s sum = %v, want %v", tt.dtype, got, want)
+		}
+	}
+}
+
+func setOne(v *Vector, dtype DataType, x float64) {
+	switch dtype {
+	case F16:
+		binary.LittleEndian.PutUint16(v.Data, toF16(x))
+	case BF16:
+		binary.LittleEndian.PutUint16(v.Data, uint16(math.Float32bits(float32(x))>>16))
+	case F32:
+		v.AsF32()[0] = float32(x)
+	case F64:
+		v.AsF64()[0] = x
+	}
+}
+
+func getOne(v *Vector, dtype DataType) float64 {
+	switch dtype {
+	case F16:
+		return fromF16(binary.LittleEndian.Uint16(v.Data))
+	case BF16:
+		return float64(math.Float32frombits(uint32(binary.LittleEndian.Uint16(v.Data)) << 16))
+	case F32:
+		return float64(v.AsF32()[0])
+	}
+	return v.AsF64()[0]
+}
+
+// toF16 and fromF16 convert normal numbers exactly representable in float16.
+func toF16(x float64) uint16 {
+	e := math.Floor(math.Log2(x))
+	m := x/math.Exp2(e) - 1
+	return uint16(int(e)+15)<<10 | uint16(m*1024)
+}
+
+func fromF16(h uint16) float64 {
+	return (1 + float64(h&0x3ff)/1024) * math.Exp2(float64(int(h>>10&0x1f)-15))
+}
+
+func Test_LogicalBitwise(t *testing.T) {
+	tests := []struct {
+		op   OP
+		x, y []int32
+		z    []int32
+	}{
+		{LAND, []int32{0, 2, 0, 3}, []int32{0, 0, 5, 7}, []int32{0, 0, 0, 1}},
+		{LOR, []int32{0, 2, 0, 3}, []int32{0, 0, 5, 7}, []int32{0, 1, 1, 1}},
+		{BAND, []int32{0, 6, 5, 3}, []int32{0, 3, 5, 4}, []int32{0, 2, 5, 0}},
+		{BOR, []int32{0, 6, 5, 3}, []int32{0, 3, 5, 4}, []int32{0, 7, 5, 7}},
+		{BXOR, []int32{0, 6, 5, 3}, []int32{0, 3, 5, 4}, []int32{0, 5, 0, 7}},
+	}
+	for _, tt := range tests {
+		x := NewVector(4, I32)
+		y := NewVector(4, I32)
+		z := NewVector(4, I32)
+		copy(x.AsI32(), tt.x)
+		copy(y.AsI32(), tt.y)
+		Transform2(z, x, y, tt.op)
+		for i, v := range z.AsI32() {
+			if v != tt.z[i] {
+				t.Errorf("%s(%v, %v) = %v, want %v", tt.op, tt.x, tt.y, z.AsI32(), tt.z)
+				break
+			}
+		}
+	}
+}
+
+func Test_RegisterOP(t *testing.T) {
+	if _, err := RegisterOP("sum", func(z, x, y *Vector) {}); err == nil {
+		t.Errorf("builtin op registered")
+	}
+	absMax := func(z, x, y *Vector) {
+		for i := range z.AsF32() {
+			a, b := x.AsF32()[i], y.AsF32()[i]
+			if math.Abs(float64(a)) < math.Abs(float64(b)) {
+				a = b
+			}
+			z.AsF32()[i] = a
+		}
+	}
+	op, err := RegisterOP("absmax", absMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := RegisterOP("absmax", absMax); again != op || op.String() != "absmax" {
+		t.Errorf("registered absmax as %d then %d, named %s", op, again, op)
+	}
+	x := NewVector(2, F32)
+	y := NewVector(2, F32)
+	copy(x.AsF32(), []float32{-3, 1})
+	copy(y.AsF32(), []float32{2, -2})
+	Transform(y, x, op)
+	if z := y.AsF32(); z[0] != -3 || z[1] != -2 {
+		t.Errorf("absmax = %v, want [-3 -2]", z)
+	}
+}
+
+func Test_16BitFloats(t *testing.T) {
+	tests := []struct {
+		op   OP
+		x, y float64
+		z    float64
+	}{
+		{SUM, 1.5, 2.25, 3.75},
+		{MAX, -1, 0.5, 0.5},
+		{MIN, -1, 0.5, -1},
+		{PROD, 1.5, -2, -3},
+	}
+	for _, dtype := range []DataType{F16, BF16} {
+		for _, tt := range tests {
+			if dtype == F16 && tt.x < 0 || dtype == F16 && tt.y < 0 {
+				continue // toF16 is of positive numbers
+			}
+			x := NewVector(1, dtype)
+			y := NewVector(1, dtype)
+			z := NewVector(1, dtype)
+			setOne(x, dtype, tt.x)
+			setOne(y, dtype, tt.y)
+			Transform2(z, x, y, tt.op)
+			if got := getOne(z, dtype); got != tt.z {
+				t.Errorf("%s %s(%v, %v) = %v, want %v", dtype, tt.op, tt.x, tt.y, got, tt.z)
+			}
+		}
+	}
+}
+
+func Test_CompensatorTerms(t *testing.T) {
+	root, other := NewCompensator(1, F32), NewCompensator(1, F32)
+	partial, x := NewVector(1, F32), NewVector(1, F32)
+	setOne(partial, F32, 1e8)
+	setOne(x, F32, 1)
+	other.Transform2(partial, partial, x) // 1e8 + 1 rounds to 1e8, sent with its rounding error
+	root.Add(other.Terms())
+	other.Reset()
+	if got := getOne(other.Terms(), F32); got != 0 {
+		t.Errorf("rounding error %v after Reset", got)
+	}
+	sum := NewVector(1, F32)
+	setOne(sum, F32, -1e8)
+	root.Transform2(sum, sum, partial)
+	root.Compensate(sum)
+	if got := getOne(sum, F32); got != 1 {
+		t.Errorf("compensated sum %v with the rounding error of the partial sum, want 1", got)
+	}
+}
+
+func Test_Accumulator(t *testing.T) {
+	const n = 4096
+	tests := []struct {
+		dtype DataType
+		x     float64 // below half of the ulp of 1, so that plain sums stay 1
+	}{
+		{F16, 1.0 / 4096},
+		{BF16, 1.0 / 512},
+	}
+	for _, tt := range tests {
+		sum, plain := NewVector(1, tt.dtype), NewVector(1, tt.dtype)
+		x := NewVector(1, tt.dtype)
+		setOne(sum, tt.dtype, 1)
+		setOne(plain, tt.dtype, 1)
+		setOne(x, tt.dtype, tt.x)
+		a := NewAccumulator(1, tt.dtype)
+		for i := 0; i < n; i++ {
+			a.Transform2(sum, sum, x, SUM)
+			Transform2(plain, plain, x, SUM)
+		}
+		if got := getOne(plain, tt.dtype); got != 1 {
+			t.Errorf("plain %s sum = %v, want 1", tt.dtype, got)
+		}
+		want := 1 + n*tt.x
+		if got := getOne(sum, tt.dtype); got != want {
+			t.Errorf("accumulated %s sum = %v, want %v", tt.dtype, got, want)
+		}
+		a.Reset()
+		setOne(sum, tt.dtype, 1)
+		a.Transform2(sum, sum, sum, PROD)
+		if got := getOne(sum, tt.dtype); got != 1 {
+			t.Errorf("%s product = %v after Reset, want 1", tt.dtype, got)
+		}
+	}
+	if NewAccumulator(1, F32) != nil {
+		t.Errorf("Accumulator of %s", F32)
+	}
+}
@@ -15,6 +15,7 @@ const (
 	BinaryTree          Strategy = C.KungFu_BinaryTree
 	BinaryTreeStar      Strategy = C.KungFu_BinaryTreeStar
 	MultiBinaryTreeStar Strategy = C.KungFu_MultiBinaryTreeStar
+	MultiRing           Strategy = C.KungFu_MultiRing
 	Auto                Strategy = C.KungFu_AUTO
 )
 
@@ -29,6 +30,7 @@ var (
 		BinaryTree:          `BINARY_TREE`,
 		BinaryTreeStar:      `BINARY_TREE_STAR`,
 		MultiBinaryTreeStar: `MULTI_BINARY_TREE_STAR`,
+		MultiRing:           `MULTI_RING`,
 		Auto:                `AUTO`,
 	}
 )
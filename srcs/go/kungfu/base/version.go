@@ -0,0 +1,4 @@
+package base
+
+// Version is the version of KungFu, the same as that of the Python package.
+const Version = `0.2.2`
@@ -12,6 +12,12 @@ type Workspace struct {
 	RecvBuf *Vector // if RecvBuf == SendBuf, will perform inplace operation
 	OP      OP
 	Name    string
+
+	Compensated bool // reduce SUM of floats with compensated summation, which is more accurate but costs extra FLOPs
+
+	// Degraded, if not nil, is set to true if the collective returned the local buffer rather than the result of
+	// all peers, as the session is in local-only mode.
+	Degraded *bool
 }
 
 // 0 <= begin < end <= count - 1
@@ -21,6 +27,9 @@ func (w Workspace) slice(begin, end int) Workspace {
 		RecvBuf: w.RecvBuf.Slice(begin, end),
 		OP:      w.OP,
 		Name:    fmt.Sprintf("part::%s[%d:%d]", w.Name, begin, end),
+
+		Compensated: w.Compensated,
+		Degraded:    w.Degraded,
 	}
 }
 
@@ -0,0 +1,91 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const azureAPIVersion = `2019-12-12`
+
+type azureUploader struct {
+	account   string
+	container string
+	prefix    string
+	sasToken  string
+	client    http.Client
+}
+
+var errNoAzureSAS = errors.New("AZURE_STORAGE_SAS_TOKEN is required")
+
+func newAzureUploader(account, container, prefix string) (*azureUploader, error) {
+	sas := strings.TrimPrefix(os.Getenv(`AZURE_STORAGE_SAS_TOKEN`), `?`)
+	if len(sas) == 0 {
+		return nil, errNoAzureSAS
+	}
+	return &azureUploader{
+		account:   account,
+		container: container,
+		prefix:    prefix,
+		sasToken:  sas,
+	}, nil
+}
+
+type blockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// Upload puts each part as a block with Content-MD5, then commits the block list.
+func (u *azureUploader) Upload(ctx context.Context, key string, data []byte) error {
+	key = joinKey(u.prefix, key)
+	var bl blockList
+	for i, part := range splitParts(data) {
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+		err := withRetry(ctx, fmt.Sprintf("PutBlock(%s, %d)", key, i), func() error {
+			h := http.Header{"Content-Md5": {md5Base64(part)}}
+			return u.put(ctx, key, `comp=block&blockid=`+uriEncode(id, true), part, h)
+		})
+		if err != nil {
+			return err
+		}
+		bl.Latest = append(bl.Latest, id)
+	}
+	body, err := xml.Marshal(bl)
+	if err != nil {
+		return err
+	}
+	return withRetry(ctx, "PutBlockList("+key+")", func() error {
+		h := http.Header{"X-Ms-Blob-Content-Md5": {md5Base64(data)}}
+		return u.put(ctx, key, `comp=blocklist`, body, h)
+	})
+}
+
+func (u *azureUploader) put(ctx context.Context, key, query string, body []byte, h http.Header) error {
+	url := fmt.Sprintf(`https://%s.blob.core.windows.net/%s/%s?%s&%s`, u.account, u.container, uriEncode(key, false), query, u.sasToken)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range h {
+		req.Header[k] = vs
+	}
+	req.Header.Set(`X-Ms-Version`, azureAPIVersion)
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return statusError{op: "PUT " + key, status: resp.Status, body: string(bs)}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package checkpoint streams checkpoints from a designated peer to object storage,
+// so that elastic jobs can checkpoint without a shared filesystem.
+package checkpoint
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/log"
+)
+
+// Uploader writes an object to a remote store.
+type Uploader interface {
+	Upload(ctx context.Context, key string, data []byte) error
+}
+
+const (
+	partSize    = 8 * 1024 * 1024 // multiple of 256KiB, required by GCS
+	maxAttempts = 5
+)
+
+var (
+	errUnsupportedURL = errors.New("unsupported checkpoint URL")
+	errUploadFailed   = errors.New("checkpoint upload failed on root")
+	errNoUploader     = errors.New("checkpoint uploader can't be created on another peer")
+)
+
+// NewUploader creates an Uploader from an URL of the form
+// s3://bucket/prefix, gs://bucket/prefix or az://account/container/prefix.
+func NewUploader(rawURL string) (Uploader, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return newS3Uploader(u.Host, prefix)
+	case "gs":
+		return newGCSUploader(u.Host, prefix), nil
+	case "az":
+		parts := strings.SplitN(prefix, "/", 2)
+		if len(parts[0]) == 0 {
+			return nil, errUnsupportedURL
+		}
+		var p string
+		if len(parts) > 1 {
+			p = parts[1]
+		}
+		return newAzureUploader(u.Host, parts[0], p)
+	}
+	return nil, errUnsupportedURL
+}
+
+// Save uploads data from rank 0 to the given key of the uploader created by newUploader, e.g. of NewUploader.
+// All peers must call Save, and all of them return an error if the uploader can't be created on any peer,
+// or the upload failed.
+func Save(ctx context.Context, sess *session.Session, newUploader func() (Uploader, error), key string, data []byte) error {
+	up, err := newUploader()
+	if err != nil {
+		log.Errorf("failed to create checkpoint uploader for %s: %v", key, err)
+	}
+	failed, err2 := anyFailed(sess, err != nil, "kungfu::checkpoint-uploader:"+key)
+	if err2 != nil {
+		return err2
+	}
+	if failed {
+		if err != nil {
+			return err
+		}
+		return errNoUploader
+	}
+	if err := sess.Barrier(); err != nil {
+		return err
+	}
+	status := kb.NewVector(1, kb.I32)
+	if sess.Rank() == 0 {
+		if err := up.Upload(ctx, key, data); err != nil {
+			log.Errorf("failed to upload checkpoint %s: %v", key, err)
+			status.AsI32()[0] = 1
+		}
+	}
+	w := kb.Workspace{SendBuf: status, RecvBuf: status, Name: "kungfu::checkpoint:" + key}
+	if err := sess.Broadcast(w); err != nil {
+		return err
+	}
+	if status.AsI32()[0] != 0 {
+		return errUploadFailed
+	}
+	return nil
+}
+
+// anyFailed returns true on all peers if failed is true on any of them.
+func anyFailed(sess *session.Session, failed bool, name string) (bool, error) {
+	x := kb.NewVector(1, kb.I32)
+	y := kb.NewVector(1, kb.I32)
+	if failed {
+		x.AsI32()[0] = 1
+	}
+	if err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: name}); err != nil {
+		return false, err
+	}
+	return y.AsI32()[0] != 0, nil
+}
+
+func joinKey(prefix, key string) string {
+	return path.Join(prefix, key)
+}
+
+func md5Base64(bs []byte) string {
+	h := md5.Sum(bs)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func splitParts(data []byte) [][]byte {
+	var parts [][]byte
+	for len(data) > partSize {
+		parts = append(parts, data[:partSize])
+		data = data[partSize:]
+	}
+	return append(parts, data)
+}
+
+type statusError struct {
+	op     string
+	status string
+	body   string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.op, e.status, e.body)
+}
@@ -0,0 +1,87 @@
+package checkpoint_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/checkpoint"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/tests/go/testutils"
+)
+
+type fakeUploader struct {
+	sync.Mutex
+	err     error
+	uploads map[string][]byte
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, key string, data []byte) error {
+	u.Lock()
+	defer u.Unlock()
+	if u.err != nil {
+		return u.err
+	}
+	u.uploads[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func Test_Save(t *testing.T) {
+	c, err := testutils.StartCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	up := &fakeUploader{uploads: make(map[string][]byte)}
+	errs := make([]error, 3)
+	c.Run(func(rank int, sess *session.Session) error {
+		newUploader := func() (checkpoint.Uploader, error) { return up, nil }
+		errs[rank] = checkpoint.Save(context.TODO(), sess, newUploader, "ok", []byte{byte(rank + 1)})
+		return nil
+	})
+	for rank, err := range errs {
+		if err != nil {
+			t.Errorf("Save on %d: %v", rank, err)
+		}
+	}
+	if got := up.uploads["ok"]; len(got) != 1 || got[0] != 1 {
+		t.Errorf("uploaded %v, want the data of rank 0", got)
+	}
+
+	errBadURL := errors.New("bad URL")
+	c.Run(func(rank int, sess *session.Session) error {
+		newUploader := func() (checkpoint.Uploader, error) {
+			if rank == 1 {
+				return nil, errBadURL
+			}
+			return up, nil
+		}
+		errs[rank] = checkpoint.Save(context.TODO(), sess, newUploader, "no-uploader", nil)
+		return nil
+	})
+	for rank, err := range errs {
+		if err == nil {
+			t.Errorf("Save on %d succeeded without an uploader on 1", rank)
+		}
+	}
+	if !errors.Is(errs[1], errBadURL) {
+		t.Errorf("Save on 1: %v, want %v", errs[1], errBadURL)
+	}
+	if _, ok := up.uploads["no-uploader"]; ok {
+		t.Errorf("uploaded without an uploader on 1")
+	}
+
+	up.err = errors.New("unavailable")
+	c.Run(func(rank int, sess *session.Session) error {
+		newUploader := func() (checkpoint.Uploader, error) { return up, nil }
+		errs[rank] = checkpoint.Save(context.TODO(), sess, newUploader, "unavailable", nil)
+		return nil
+	})
+	for rank, err := range errs {
+		if err == nil {
+			t.Errorf("Save on %d succeeded after the upload failed", rank)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const (
+	gcsUploadURL = `https://storage.googleapis.com/upload/storage/v1/b/`
+	gcpTokenURL  = `http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token`
+)
+
+type gcsUploader struct {
+	bucket string
+	prefix string
+	client http.Client
+}
+
+func newGCSUploader(bucket, prefix string) *gcsUploader {
+	return &gcsUploader{bucket: bucket, prefix: prefix}
+}
+
+// Upload uses the resumable upload protocol, and verifies the MD5 reported by GCS.
+func (u *gcsUploader) Upload(ctx context.Context, key string, data []byte) error {
+	key = joinKey(u.prefix, key)
+	token, err := u.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	var session string
+	err = withRetry(ctx, "StartResumableUpload("+key+")", func() error {
+		var err error
+		session, err = u.start(ctx, token, key, len(data))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		MD5Hash string `json:"md5Hash"`
+	}
+	var offset int
+	for _, part := range splitParts(data) {
+		final := offset+len(part) == len(data)
+		err := withRetry(ctx, fmt.Sprintf("UploadChunk(%s, %d)", key, offset), func() error {
+			resp, err := u.put(ctx, token, session, offset, part, len(data))
+			if err != nil || !final {
+				return err
+			}
+			return json.Unmarshal(resp, &result)
+		})
+		if err != nil {
+			return err
+		}
+		offset += len(part)
+	}
+	if want := md5Base64(data); result.MD5Hash != want {
+		return fmt.Errorf("md5 mismatch for %s: got %q, want %q", key, result.MD5Hash, want)
+	}
+	return nil
+}
+
+func (u *gcsUploader) start(ctx context.Context, token, key string, size int) (string, error) {
+	q := url.Values{"uploadType": {"resumable"}, "name": {key}}
+	req, err := http.NewRequest(http.MethodPost, gcsUploadURL+url.PathEscape(u.bucket)+`/o?`+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(`Authorization`, `Bearer `+token)
+	req.Header.Set(`X-Upload-Content-Length`, strconv.Itoa(size))
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return "", statusError{op: "StartResumableUpload", status: resp.Status, body: string(bs)}
+	}
+	return resp.Header.Get(`Location`), nil
+}
+
+const statusResumeIncomplete = 308
+
+func (u *gcsUploader) put(ctx context.Context, token, session string, offset int, part []byte, total int) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPut, session, bytes.NewReader(part))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(`Authorization`, `Bearer `+token)
+	if total == 0 {
+		req.Header.Set(`Content-Range`, `bytes */0`)
+	} else {
+		req.Header.Set(`Content-Range`, fmt.Sprintf(`bytes %d-%d/%d`, offset, offset+len(part)-1, total))
+	}
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, statusResumeIncomplete:
+		return bs, nil
+	}
+	return nil, statusError{op: "UploadChunk", status: resp.Status, body: string(bs)}
+}
+
+var errNoGCPToken = errors.New("no GCP access token")
+
+// accessToken uses GOOGLE_OAUTH_ACCESS_TOKEN if set, otherwise asks the metadata server.
+func (u *gcsUploader) accessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv(`GOOGLE_OAUTH_ACCESS_TOKEN`); len(token) > 0 {
+		return token, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, gcpTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(`Metadata-Flavor`, `Google`)
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errNoGCPToken
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.AccessToken, nil
+}
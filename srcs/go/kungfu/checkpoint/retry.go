@@ -0,0 +1,26 @@
+package checkpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/log"
+)
+
+func withRetry(ctx context.Context, name string, f func() error) error {
+	backoff := 500 * time.Millisecond
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		log.Warnf("%s failed (attempt %d/%d): %v", name, i+1, maxAttempts, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
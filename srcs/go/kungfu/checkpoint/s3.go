@@ -0,0 +1,254 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type s3Uploader struct {
+	endpoint     string
+	region       string
+	bucket       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       http.Client
+}
+
+var errNoAWSCredentials = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+
+func newS3Uploader(bucket, prefix string) (*s3Uploader, error) {
+	u := &s3Uploader{
+		region:       os.Getenv(`AWS_REGION`),
+		bucket:       bucket,
+		prefix:       prefix,
+		accessKey:    os.Getenv(`AWS_ACCESS_KEY_ID`),
+		secretKey:    os.Getenv(`AWS_SECRET_ACCESS_KEY`),
+		sessionToken: os.Getenv(`AWS_SESSION_TOKEN`),
+		endpoint:     os.Getenv(`AWS_ENDPOINT_URL`), // e.g. for MinIO
+	}
+	if len(u.accessKey) == 0 || len(u.secretKey) == 0 {
+		return nil, errNoAWSCredentials
+	}
+	if len(u.region) == 0 {
+		u.region = `us-east-1`
+	}
+	if len(u.endpoint) == 0 {
+		u.endpoint = `https://s3.` + u.region + `.amazonaws.com`
+	}
+	return u, nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, key string, data []byte) error {
+	key = joinKey(u.prefix, key)
+	uploadID, err := u.createMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	var complete completeMultipartUpload
+	for i, part := range splitParts(data) {
+		n := i + 1
+		var etag string
+		err := withRetry(ctx, fmt.Sprintf("UploadPart(%s, %d)", key, n), func() error {
+			var err error
+			etag, err = u.uploadPart(ctx, key, uploadID, n, part)
+			return err
+		})
+		if err != nil {
+			u.abortMultipartUpload(ctx, key, uploadID)
+			return err
+		}
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: n, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"uploadId": {uploadID}}
+	err = withRetry(ctx, "CompleteMultipartUpload("+key+")", func() error {
+		resp, err := u.do(ctx, http.MethodPost, key, q, body, nil)
+		if err != nil {
+			return err
+		}
+		// CompleteMultipartUpload may fail after sending 200 OK.
+		if bytes.Contains(resp, []byte("<Error>")) {
+			return statusError{op: "CompleteMultipartUpload", status: "200 OK", body: string(resp)}
+		}
+		return nil
+	})
+	if err != nil {
+		u.abortMultipartUpload(ctx, key, uploadID)
+	}
+	return err
+}
+
+func (u *s3Uploader) createMultipartUpload(ctx context.Context, key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := withRetry(ctx, "CreateMultipartUpload("+key+")", func() error {
+		resp, err := u.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+		if err != nil {
+			return err
+		}
+		return xml.Unmarshal(resp, &result)
+	})
+	return result.UploadID, err
+}
+
+func (u *s3Uploader) uploadPart(ctx context.Context, key, uploadID string, n int, part []byte) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+	// S3 verifies Content-MD5 and rejects corrupted parts.
+	h := http.Header{"Content-Md5": {md5Base64(part)}}
+	var etag string
+	_, err := u.doWithHeader(ctx, http.MethodPut, key, q, part, h, func(resp *http.Response) {
+		etag = resp.Header.Get("ETag")
+	})
+	return etag, err
+}
+
+func (u *s3Uploader) abortMultipartUpload(ctx context.Context, key, uploadID string) {
+	u.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+}
+
+func (u *s3Uploader) do(ctx context.Context, method, key string, q url.Values, body []byte, h http.Header) ([]byte, error) {
+	return u.doWithHeader(ctx, method, key, q, body, h, nil)
+}
+
+func (u *s3Uploader) doWithHeader(ctx context.Context, method, key string, q url.Values, body []byte, h http.Header, f func(*http.Response)) ([]byte, error) {
+	path := `/` + u.bucket + `/` + key
+	req, err := http.NewRequest(method, u.endpoint+uriEncode(path, false)+`?`+canonicalQuery(q), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range h {
+		req.Header[k] = vs
+	}
+	u.sign(req, path, q, body, time.Now().UTC())
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return nil, statusError{op: method + " " + path, status: resp.Status, body: string(bs)}
+	}
+	if f != nil {
+		f(resp)
+	}
+	return bs, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (u *s3Uploader) sign(req *http.Request, path string, q url.Values, body []byte, t time.Time) {
+	amzDate := t.Format(`20060102T150405Z`)
+	date := t.Format(`20060102`)
+	payloadHash := sha256Hex(body)
+	req.Header.Set(`X-Amz-Date`, amzDate)
+	req.Header.Set(`X-Amz-Content-Sha256`, payloadHash)
+	if len(u.sessionToken) > 0 {
+		req.Header.Set(`X-Amz-Security-Token`, u.sessionToken)
+	}
+	headers := map[string]string{`host`: req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + `:` + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, `;`)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		canonicalQuery(q),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + `/` + u.region + `/s3/aws4_request`
+	stringToSign := strings.Join([]string{
+		`AWS4-HMAC-SHA256`,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSHA256([]byte(`AWS4`+u.secretKey), date)
+	key = hmacSHA256(key, u.region)
+	key = hmacSHA256(key, `s3`)
+	key = hmacSHA256(key, `aws4_request`)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set(`Authorization`, fmt.Sprintf(`AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s`, u.accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, uriEncode(k, true)+`=`+uriEncode(q.Get(k), true))
+	}
+	return strings.Join(parts, `&`)
+}
+
+// uriEncode encodes s as required by SigV4, which differs slightly from url.QueryEscape.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, `%%%02X`, c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(bs []byte) string {
+	h := sha256.Sum256(bs)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
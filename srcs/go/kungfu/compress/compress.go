@@ -0,0 +1,69 @@
+// Package compress is the plug-in interface of gradient compression schemes, which depends on neither the session
+// nor any framework, so that a scheme can be shipped as a separate Go module, and linked into a worker by importing it.
+//
+// A scheme registers itself from an init function:
+//
+//	func init() { compress.Register(mySketch{}) }
+//
+// and is selected by all peers by its name, with KUNGFU_CONFIG_CODEC or by Session.SetCodec.
+// Peers negotiate the scheme whenever a strategy is installed, and stop compressing if any of them lacks it.
+package compress
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Compressor compresses the chunks of an all reduce of float32 gradients by SUM on the wire.
+// The receiver of a chunk decompresses it before reducing it, and the result of the broadcast is decompressed
+// from the chunk compressed by its root, so that all peers get the same result of a lossy scheme.
+// Compress and Decompress may be called concurrently on different chunks.
+type Compressor interface {
+	Name() string
+
+	// Compress returns the compressed chunk, and its metadata, e.g. a scale or a seed, which may be nil.
+	Compress(chunk []float32) (data []byte, meta []byte)
+
+	// Decompress decompresses data and meta returned by Compress into chunk, which has the length of the compressed chunk.
+	Decompress(data []byte, meta []byte, chunk []float32) error
+}
+
+var (
+	mu          sync.Mutex
+	compressors = make(map[string]Compressor)
+)
+
+// Register makes a compressor available by its name. It panics if the name is empty or is already registered.
+// The built-in codecs of the session, e.g. FP16, take precedence over a compressor of the same name.
+func Register(c Compressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	if c == nil || len(c.Name()) == 0 {
+		panic("compress.Register: nil compressor or empty name")
+	}
+	if _, dup := compressors[c.Name()]; dup {
+		panic(fmt.Sprintf("compress.Register: %s is registered twice", c.Name()))
+	}
+	compressors[c.Name()] = c
+}
+
+// Lookup returns the compressor registered by name.
+func Lookup(name string) (Compressor, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// Names returns the sorted names of the registered compressors.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
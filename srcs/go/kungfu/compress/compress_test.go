@@ -0,0 +1,31 @@
+package compress
+
+import "testing"
+
+type nopCompressor struct{ name string }
+
+func (c nopCompressor) Name() string { return c.name }
+
+func (nopCompressor) Compress(chunk []float32) ([]byte, []byte) { return nil, nil }
+
+func (nopCompressor) Decompress(data []byte, meta []byte, chunk []float32) error { return nil }
+
+func Test_Register(t *testing.T) {
+	Register(nopCompressor{"b"})
+	Register(nopCompressor{"a"})
+	if c, ok := Lookup("a"); !ok || c.Name() != "a" {
+		t.Errorf("Lookup(a) = %v, %v", c, ok)
+	}
+	if _, ok := Lookup("c"); ok {
+		t.Errorf("Lookup(c) should fail")
+	}
+	if names := Names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Names() = %q", names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a name twice should panic")
+		}
+	}()
+	Register(nopCompressor{"a"})
+}
@@ -2,74 +2,399 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lsds/KungFu/srcs/go/utils"
 )
 
-const (
-	UseUnixSock = true
-)
-
-const (
-	ConnRetryCount  = 500
-	ConnRetryPeriod = 200 * time.Millisecond
-)
-
 var (
+	ConnRetryCount    = 500
+	ConnRetryPeriod   = 200 * time.Millisecond
 	WaitRunnerTimeout = 5 * time.Minute
 )
 
 const (
-	EnableMonitoringEnvKey     = `KUNGFU_CONFIG_ENABLE_MONITORING`
-	EnableStallDetectionEnvKey = `KUNGFU_CONFIG_ENABLE_STALL_DETECTION`
-	LogLevelEnvKey             = `KUNGFU_CONFIG_LOG_LEVEL`
-	MonitoringPeriodEnvKey     = `KUNGFU_CONFIG_MONITORING_PERIOD`
-	StrategyHashMethodEnvKey   = `KUNGFU_CONFIG_STRATEGY_HASH_METHOD`
-	WaitRunnerTimeoutEnvKey    = `KUNGFU_CONFIG_WAIT_RUNNER_TIMEOUT`
+	AlgorithmTableEnvKey           = `KUNGFU_CONFIG_ALGORITHM_TABLE`          // comma separated list of <max bytes>:<algorithm>, or default
+	BandwidthEnvKey                = `KUNGFU_CONFIG_BANDWIDTH`                // Mbps shared by QoS classes
+	CanaryPeriodEnvKey             = `KUNGFU_CONFIG_CANARY_PERIOD`            // period of canary all reduce over suspended strategies
+	ChunkSizeEnvKey                = `KUNGFU_CONFIG_CHUNK_SIZE`               // bytes
+	ChunkSizesEnvKey               = `KUNGFU_CONFIG_CHUNK_SIZES`              // comma separated list of <max bytes>:<chunk bytes>, learned by chunk tuning
+	ChunkTuningIntervalEnvKey      = `KUNGFU_CONFIG_CHUNK_TUNING_INTERVAL`    // steps between tuning the chunk sizes by their throughput, 0 disables
+	CodecEnvKey                    = `KUNGFU_CONFIG_CODEC`                    // name of the codec of all reduce messages, e.g. FP16
+	CodecSelectionIntervalEnvKey   = `KUNGFU_CONFIG_CODEC_SELECTION_INTERVAL` // steps between choosing by their throughput if messages of each size are encoded, 0 disables
+	CodecSizesEnvKey               = `KUNGFU_CONFIG_CODEC_SIZES`              // comma separated list of <max bytes>:<on | off>, or host=<list>;network=<list> by link class, learned by codec selection
+	CollectiveTimeoutEnvKey        = `KUNGFU_CONFIG_COLLECTIVE_TIMEOUT`       // chunks of collectives exceeding it are retried on another strategy
+	ControlPortEnvKey              = `KUNGFU_CONFIG_CONTROL_PORT`
+	ControlSockEnvKey              = `KUNGFU_CONFIG_CONTROL_SOCK` // Unix socket file of the control server of rank 0
+	DaemonSockEnvKey               = `KUNGFU_CONFIG_DAEMON_SOCK`  // Unix socket file of kungfu-daemon to attach to
+	EnableAutoTuneEnvKey           = `KUNGFU_CONFIG_ENABLE_AUTO_TUNE`
+	EnableCapabilitiesEnvKey       = `KUNGFU_CONFIG_ENABLE_CAPABILITIES`
+	EnableCloudHintsEnvKey         = `KUNGFU_CONFIG_ENABLE_CLOUD_HINTS`
+	EnableHostProxyEnvKey          = `KUNGFU_CONFIG_ENABLE_HOST_PROXY` // only host masters communicate across hosts
+	GRPCControlPortEnvKey          = `KUNGFU_CONFIG_GRPC_CONTROL_PORT`
+	JobEnvKey                      = `KUNGFU_CONFIG_JOB`                    // namespace of the job in kungfu-daemon
+	JobPriorityEnvKey              = `KUNGFU_CONFIG_JOB_PRIORITY`           // jobs of higher priority preempt workers of others in kungfu-daemon
+	EnableLocalFallbackEnvKey      = `KUNGFU_CONFIG_ENABLE_LOCAL_FALLBACK`  // continue in local-only mode if all remote peers are unreachable, see ResilienceTimeout
+	EnableLinkSchedulingEnvKey     = `KUNGFU_CONFIG_ENABLE_LINK_SCHEDULING` // order the messages of QoS classes on each connection by their weights
+	EnableMonitoringEnvKey         = `KUNGFU_CONFIG_ENABLE_MONITORING`
+	MonitoringPortEnvKey           = `KUNGFU_CONFIG_MONITORING_PORT`       // port of the metrics endpoint, the port of the peer + 10000 by default
+	EnableRUDPEnvKey               = `KUNGFU_CONFIG_ENABLE_RUDP`           // use reliable UDP for peers of high RTT
+	EnableResilienceEnvKey         = `KUNGFU_CONFIG_ENABLE_RESILIENCE`     // reroute all reduces around failed peers, see ResilienceTimeout
+	EnableRootSelectionEnvKey      = `KUNGFU_CONFIG_ENABLE_ROOT_SELECTION` // root trees at the peer of min cost by probed links
+	EnableStallDetectionEnvKey     = `KUNGFU_CONFIG_ENABLE_STALL_DETECTION`
+	EnableStrategyMonitoringEnvKey = `KUNGFU_CONFIG_ENABLE_STRATEGY_MONITORING` // suspend strategies slowed down by interference, and reactivate them when it disappears
+	EnableTopologyEnvKey           = `KUNGFU_CONFIG_ENABLE_TOPOLOGY`            // generate strategies from the sockets and switches of peers, the switch is the rack
+	FusionSizeEnvKey               = `KUNGFU_CONFIG_FUSION_SIZE`                // bytes
+	LinkProbePeriodEnvKey          = `KUNGFU_CONFIG_LINK_PROBE_PERIOD`          // period of probing the links of open circuit breakers
+	LinkRetryBudgetEnvKey          = `KUNGFU_CONFIG_LINK_RETRY_BUDGET`          // consecutive failures of a link tolerated before its circuit breaker opens, 0 disables
+	LogLevelEnvKey                 = `KUNGFU_CONFIG_LOG_LEVEL`
+	LogFormatEnvKey                = `KUNGFU_CONFIG_LOG_FORMAT` // text or json
+	MonitoringPeriodEnvKey         = `KUNGFU_CONFIG_MONITORING_PERIOD`
+	PartitionPolicyEnvKey          = `KUNGFU_CONFIG_PARTITION_POLICY`   // one of halt | majority, what peers do when their consensus on changing the cluster fails, empty to exit
+	PartitionTimeoutEnvKey         = `KUNGFU_CONFIG_PARTITION_TIMEOUT`  // a peer not answering pings within it is taken as partitioned
+	ResilienceTimeoutEnvKey        = `KUNGFU_CONFIG_RESILIENCE_TIMEOUT` // an all reduce not finished within it checks for failed peers
+	ProfileEnvKey                  = `KUNGFU_CONFIG_PROFILE`            // one of latency | bandwidth | wan
+	P2PKeyFileEnvKey               = `KUNGFU_CONFIG_P2P_KEY_FILE`       // file of the secret shared by peers to encrypt and verify the models they request from each other
+	QoSClassesEnvKey               = `KUNGFU_CONFIG_QOS_CLASSES`        // comma separated list of <name>=<weight>[:<reserved Mbps>][@<DSCP>]
+	RUDPRTTThresholdEnvKey         = `KUNGFU_CONFIG_RUDP_RTT_THRESHOLD`
+	RackEnvKey                     = `KUNGFU_CONFIG_RACK`                   // must be set on all hosts to enable the rack hierarchy
+	ReactivationThresholdEnvKey    = `KUNGFU_CONFIG_REACTIVATION_THRESHOLD` // reactivate a suspended strategy if its canary takes at most this times that of active strategies
+	ReweightIntervalEnvKey         = `KUNGFU_CONFIG_REWEIGHT_INTERVAL`      // steps between reweighting strategies by their throughput, for the WEIGHTED strategy hash
+	SegmentSizeEnvKey              = `KUNGFU_CONFIG_SEGMENT_SIZE`           // bytes of a segment of a chunk in the pipeline of graphs, 0 disables pipelining
+	StandbyStrategiesEnvKey        = `KUNGFU_CONFIG_STANDBY_STRATEGIES`     // comma separated list of strategies promoted when an active strategy is suspended
+	StatSamplingEnvKey             = `KUNGFU_CONFIG_STAT_SAMPLING`          // N to time every N-th chunk, or p in (0, 1] to time a chunk with probability p
+	StrategyHashMethodEnvKey       = `KUNGFU_CONFIG_STRATEGY_HASH_METHOD`
+	StreamsPerPeerEnvKey           = `KUNGFU_CONFIG_STREAMS_PER_PEER` // parallel TCP connections of collectives to each peer of another host
+	StrategyEnvKey                 = `KUNGFU_STRATEGY`                // name of a strategy registered by session.RegisterStrategy
+	SyncPeriodsEnvKey              = `KUNGFU_CONFIG_SYNC_PERIODS`     // comma separated list of <name pattern>=<N>, to all reduce the tensors of the names containing the pattern every N steps
+	TLSCAFileEnvKey                = `KUNGFU_CONFIG_TLS_CA_FILE`      // PEM file of the CA signing the certificates of peers, enables mutual TLS between hosts
+	TLSCertFileEnvKey              = `KUNGFU_CONFIG_TLS_CERT_FILE`    // PEM file of the certificate of the host, with its IPv4 as an IP SAN
+	TLSKeyFileEnvKey               = `KUNGFU_CONFIG_TLS_KEY_FILE`     // PEM file of the private key of the certificate
+	TransportEnvKey                = `KUNGFU_TRANSPORT`               // one of tcp | quic | rdma, the transport of connections between hosts
+	UseLoopbackEnvKey              = `KUNGFU_CONFIG_USE_LOOPBACK`     // pass messages between peers of the same process in memory
+	UseUnixSockEnvKey              = `KUNGFU_CONFIG_USE_UNIX_SOCK`    // use Unix sockets between peers of the same host
+	WaitRunnerTimeoutEnvKey        = `KUNGFU_CONFIG_WAIT_RUNNER_TIMEOUT`
+	ZeroCopyThresholdEnvKey        = `KUNGFU_CONFIG_ZERO_COPY_THRESHOLD` // bytes from which messages are sent by a single scatter-gather write, 0 disables
 )
 
 var ConfigEnvKeys = []string{
+	AlgorithmTableEnvKey,
+	BandwidthEnvKey,
+	CanaryPeriodEnvKey,
+	ChunkSizeEnvKey,
+	ChunkSizesEnvKey,
+	ChunkTuningIntervalEnvKey,
+	CodecEnvKey,
+	CodecSelectionIntervalEnvKey,
+	CodecSizesEnvKey,
+	CollectiveTimeoutEnvKey,
+	ControlPortEnvKey,
+	ControlSockEnvKey,
+	DaemonSockEnvKey,
+	EnableAutoTuneEnvKey,
+	EnableCapabilitiesEnvKey,
+	EnableCloudHintsEnvKey,
+	EnableHostProxyEnvKey,
+	GRPCControlPortEnvKey,
+	JobEnvKey,
+	JobPriorityEnvKey,
+	EnableLocalFallbackEnvKey,
+	EnableLinkSchedulingEnvKey,
 	EnableMonitoringEnvKey,
+	MonitoringPortEnvKey,
+	EnableRUDPEnvKey,
+	EnableResilienceEnvKey,
+	EnableRootSelectionEnvKey,
+	EnableStrategyMonitoringEnvKey,
+	EnableTopologyEnvKey,
+	FusionSizeEnvKey,
+	LinkProbePeriodEnvKey,
+	LinkRetryBudgetEnvKey,
 	MonitoringPeriodEnvKey,
 	LogLevelEnvKey,
+	LogFormatEnvKey,
+	PartitionPolicyEnvKey,
+	PartitionTimeoutEnvKey,
+	ResilienceTimeoutEnvKey,
+	ProfileEnvKey,
+	P2PKeyFileEnvKey,
+	QoSClassesEnvKey,
+	RUDPRTTThresholdEnvKey,
+	RackEnvKey,
+	ReactivationThresholdEnvKey,
+	ReweightIntervalEnvKey,
+	SegmentSizeEnvKey,
+	StandbyStrategiesEnvKey,
+	StatSamplingEnvKey,
 	StrategyHashMethodEnvKey,
+	StreamsPerPeerEnvKey,
+	SyncPeriodsEnvKey,
+	TLSCAFileEnvKey,
+	TLSCertFileEnvKey,
+	TLSKeyFileEnvKey,
+	TransportEnvKey,
+	StrategyEnvKey,
+	UseLoopbackEnvKey,
+	UseUnixSockEnvKey,
+	ZeroCopyThresholdEnvKey,
 }
 
 var (
-	EnableMonitoring     = false
-	EnableStallDetection = false
-	LogLevel             = `INFO`
-	MonitoringPeriod     = 1 * time.Second
-	StrategyHashMethod   = `NAME`
+	AlgorithmTable           = ``
+	Bandwidth                = 0
+	CanaryPeriod             = 30 * time.Second
+	ChunkSize                = 1 * Mi
+	ChunkSizes               = ``
+	ChunkTuningInterval      = 0
+	Codec                    = ``
+	CodecSelectionInterval   = 0
+	CodecSizes               = ``
+	CollectiveTimeout        = time.Duration(0)
+	ControlPort              = 0
+	ControlSock              = ``
+	DaemonSock               = ``
+	EnableAutoTune           = false
+	EnableCapabilities       = false
+	EnableCloudHints         = false
+	EnableHostProxy          = false
+	GRPCControlPort          = 0
+	Job                      = ``
+	JobPriority              = 0
+	EnableLocalFallback      = false
+	EnableLinkScheduling     = false
+	EnableMonitoring         = false
+	MonitoringPort           = 0
+	EnableRUDP               = false
+	EnableResilience         = false
+	EnableRootSelection      = false
+	EnableStallDetection     = false
+	EnableStrategyMonitoring = false
+	EnableTopology           = false
+	FusionSize               = 0
+	LinkProbePeriod          = 10 * time.Second
+	LinkRetryBudget          = 0
+	LogLevel                 = `INFO`
+	LogFormat                = `text`
+	MonitoringPeriod         = 1 * time.Second
+	PartitionPolicy          = ``
+	PartitionTimeout         = 5 * time.Second
+	ResilienceTimeout        = 30 * time.Second
+	ProfileName              = ``
+	P2PKeyFile               = ``
+	QoSClasses               = ``
+	RUDPRTTThreshold         = 20 * time.Millisecond
+	Rack                     = ``
+	ReactivationThreshold    = 1.2
+	ReweightInterval         = 100
+	SegmentSize              = 0
+	StandbyStrategies        = ``
+	StatSampling             = `1`
+	SyncPeriods              = ``
+	TLSCAFile                = ``
+	TLSCertFile              = ``
+	TLSKeyFile               = ``
+	Transport                = `tcp`
+	StrategyHashMethod       = `NAME`
+	StreamsPerPeer           = 1
+	Strategy                 = ``
+	UseLoopback              = false
+	UseUnixSock              = true
+	ZeroCopyThreshold        = 256 << 10
 )
 
 func init() {
+	if val := os.Getenv(ProfileEnvKey); len(val) > 0 {
+		if err := UseProfile(val); err != nil {
+			utils.ExitErr(err)
+		}
+	}
+	if val := os.Getenv(AlgorithmTableEnvKey); len(val) > 0 {
+		AlgorithmTable = val
+	}
+	if val := os.Getenv(ChunkSizesEnvKey); len(val) > 0 {
+		ChunkSizes = val
+	}
+	if val := os.Getenv(ChunkTuningIntervalEnvKey); len(val) > 0 {
+		ChunkTuningInterval = parseInt(val)
+	}
+	if val := os.Getenv(BandwidthEnvKey); len(val) > 0 {
+		Bandwidth = parseInt(val)
+	}
+	if val := os.Getenv(CanaryPeriodEnvKey); len(val) > 0 {
+		CanaryPeriod = parseDuration(val)
+	}
+	if val := os.Getenv(CodecEnvKey); len(val) > 0 {
+		Codec = val // checked by the session
+	}
+	if val := os.Getenv(CodecSelectionIntervalEnvKey); len(val) > 0 {
+		CodecSelectionInterval = parseInt(val)
+	}
+	if val := os.Getenv(CodecSizesEnvKey); len(val) > 0 {
+		CodecSizes = val // checked by the session
+	}
+	if val := os.Getenv(ControlPortEnvKey); len(val) > 0 {
+		ControlPort = parseInt(val)
+	}
+	if val := os.Getenv(ControlSockEnvKey); len(val) > 0 {
+		ControlSock = val
+	}
+	if val := os.Getenv(DaemonSockEnvKey); len(val) > 0 {
+		DaemonSock = val
+	}
+	if val := os.Getenv(GRPCControlPortEnvKey); len(val) > 0 {
+		GRPCControlPort = parseInt(val)
+	}
+	if val := os.Getenv(JobEnvKey); len(val) > 0 {
+		Job = val
+	}
+	if val := os.Getenv(JobPriorityEnvKey); len(val) > 0 {
+		JobPriority = parseInt(val)
+	}
+	if val := os.Getenv(LinkProbePeriodEnvKey); len(val) > 0 {
+		LinkProbePeriod = parseDuration(val)
+	}
+	if val := os.Getenv(LinkRetryBudgetEnvKey); len(val) > 0 {
+		LinkRetryBudget = parseInt(val)
+	}
+	if val := os.Getenv(EnableAutoTuneEnvKey); len(val) > 0 {
+		EnableAutoTune = isTrue(val)
+	}
+	if val := os.Getenv(EnableResilienceEnvKey); len(val) > 0 {
+		EnableResilience = isTrue(val)
+	}
+	if val := os.Getenv(EnableRootSelectionEnvKey); len(val) > 0 {
+		EnableRootSelection = isTrue(val)
+	}
+	if val := os.Getenv(EnableCapabilitiesEnvKey); len(val) > 0 {
+		EnableCapabilities = isTrue(val)
+	}
+	if val := os.Getenv(EnableCloudHintsEnvKey); len(val) > 0 {
+		EnableCloudHints = isTrue(val)
+	}
+	if val := os.Getenv(EnableHostProxyEnvKey); len(val) > 0 {
+		EnableHostProxy = isTrue(val)
+	}
+	if val := os.Getenv(EnableLocalFallbackEnvKey); len(val) > 0 {
+		EnableLocalFallback = isTrue(val)
+	}
+	if val := os.Getenv(EnableLinkSchedulingEnvKey); len(val) > 0 {
+		EnableLinkScheduling = isTrue(val)
+	}
 	if val := os.Getenv(EnableMonitoringEnvKey); len(val) > 0 {
 		EnableMonitoring = isTrue(val)
 	}
+	if val := os.Getenv(MonitoringPortEnvKey); len(val) > 0 {
+		MonitoringPort = parseInt(val)
+	}
+	if val := os.Getenv(EnableRUDPEnvKey); len(val) > 0 {
+		EnableRUDP = isTrue(val)
+	}
+	if val := os.Getenv(RUDPRTTThresholdEnvKey); len(val) > 0 {
+		RUDPRTTThreshold = parseDuration(val)
+	}
 	if val := os.Getenv(EnableStallDetectionEnvKey); len(val) > 0 {
 		EnableStallDetection = isTrue(val)
 	}
+	if val := os.Getenv(EnableStrategyMonitoringEnvKey); len(val) > 0 {
+		EnableStrategyMonitoring = isTrue(val)
+	}
+	if val := os.Getenv(EnableTopologyEnvKey); len(val) > 0 {
+		EnableTopology = isTrue(val)
+	}
 	if val := os.Getenv(MonitoringPeriodEnvKey); len(val) > 0 {
 		MonitoringPeriod = parseDuration(val)
 	}
+	if val := os.Getenv(PartitionPolicyEnvKey); len(val) > 0 {
+		PartitionPolicy = val // checked by the peer
+	}
+	if val := os.Getenv(PartitionTimeoutEnvKey); len(val) > 0 {
+		PartitionTimeout = parseDuration(val)
+	}
+	if val := os.Getenv(P2PKeyFileEnvKey); len(val) > 0 {
+		P2PKeyFile = val
+	}
+	if val := os.Getenv(QoSClassesEnvKey); len(val) > 0 {
+		QoSClasses = val
+	}
+	if val := os.Getenv(RackEnvKey); len(val) > 0 {
+		Rack = val
+	}
+	if val := os.Getenv(ReactivationThresholdEnvKey); len(val) > 0 {
+		ReactivationThreshold = parseFloat(val)
+	}
+	if val := os.Getenv(ReweightIntervalEnvKey); len(val) > 0 {
+		ReweightInterval = parseInt(val)
+	}
 	if val := os.Getenv(LogLevelEnvKey); len(val) > 0 {
 		LogLevel = strings.ToUpper(val) // FIXME: check enum value
 	}
+	if val := os.Getenv(LogFormatEnvKey); len(val) > 0 {
+		LogFormat = strings.ToLower(val)
+	}
+	if val := os.Getenv(StandbyStrategiesEnvKey); len(val) > 0 {
+		StandbyStrategies = val
+	}
+	if val := os.Getenv(StatSamplingEnvKey); len(val) > 0 {
+		StatSampling = val // checked by the session
+	}
+	if val := os.Getenv(SyncPeriodsEnvKey); len(val) > 0 {
+		SyncPeriods = val // checked by the session
+	}
+	if val := os.Getenv(TLSCAFileEnvKey); len(val) > 0 {
+		TLSCAFile = val
+	}
+	if val := os.Getenv(TLSCertFileEnvKey); len(val) > 0 {
+		TLSCertFile = val
+	}
+	if val := os.Getenv(TLSKeyFileEnvKey); len(val) > 0 {
+		TLSKeyFile = val
+	}
+	if val := os.Getenv(TransportEnvKey); len(val) > 0 {
+		Transport = strings.ToLower(val)
+	}
 	if val := os.Getenv(StrategyHashMethodEnvKey); len(val) > 0 {
-		StrategyHashMethod = strings.ToUpper(val) // FIXME: check enum value
+		StrategyHashMethod = val // checked by the session
+	}
+	if val := os.Getenv(StreamsPerPeerEnvKey); len(val) > 0 {
+		StreamsPerPeer = parseInt(val)
+	}
+	if val := os.Getenv(StrategyEnvKey); len(val) > 0 {
+		Strategy = val
 	}
-	if val := os.Getenv(WaitRunnerTimeoutEnvKey); len(val) > 0 {
-		WaitRunnerTimeout = parseDuration(val)
+	if val := os.Getenv(UseLoopbackEnvKey); len(val) > 0 {
+		UseLoopback = isTrue(val)
 	}
+	if val := os.Getenv(UseUnixSockEnvKey); len(val) > 0 {
+		UseUnixSock = isTrue(val)
+	}
+	if val := os.Getenv(ZeroCopyThresholdEnvKey); len(val) > 0 {
+		ZeroCopyThreshold = parseInt(val)
+	}
+	loadTuningEnvs()
 }
 
 func isTrue(val string) bool {
 	return val == "true"
 }
 
+func parseInt(val string) int {
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		utils.ExitErr(err)
+	}
+	return n
+}
+
+func parseFloat(val string) float64 {
+	x, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		utils.ExitErr(err)
+	}
+	return x
+}
+
 func parseDuration(val string) time.Duration {
 	d, err := time.ParseDuration(val)
 	if err != nil {
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const Mi = 1 << 20
+
+// Profile jointly sets the knobs of collectives for a kind of network.
+type Profile struct {
+	ChunkSize         int    // bytes of a chunk of a collective, chunks are run in parallel
+	SegmentSize       int    // bytes of a segment of a chunk, segments are pipelined along the graphs, 0 disables pipelining
+	FusionSize        int    // bytes of a bucket of fused gradients, 0 disables fusion
+	Strategy          string // preferred all reduce strategy, used by kungfu-run if -strategy is not given
+	ConnRetryCount    int
+	ConnRetryPeriod   time.Duration
+	WaitRunnerTimeout time.Duration
+	CollectiveTimeout time.Duration // chunks exceeding it are retried on another strategy, 0 disables retries
+	ResilienceTimeout time.Duration // an all reduce not finished within it checks for failed peers
+}
+
+var profiles = map[string]Profile{
+	// many small messages in a low latency network: small chunks and buckets, the tree of least hops, fail fast
+	`latency`: {
+		ChunkSize:         256 << 10,
+		SegmentSize:       0,
+		FusionSize:        4 * Mi,
+		Strategy:          `BINARY_TREE_STAR`,
+		ConnRetryCount:    100,
+		ConnRetryPeriod:   100 * time.Millisecond,
+		WaitRunnerTimeout: 1 * time.Minute,
+		CollectiveTimeout: 2 * time.Second,
+		ResilienceTimeout: 10 * time.Second,
+	},
+	// large tensors in a high bandwidth network: large chunks and buckets over the ring, pipelined in segments
+	`bandwidth`: {
+		ChunkSize:         4 * Mi,
+		SegmentSize:       1 * Mi,
+		FusionSize:        64 * Mi,
+		Strategy:          `RING`,
+		ConnRetryCount:    500,
+		ConnRetryPeriod:   200 * time.Millisecond,
+		WaitRunnerTimeout: 5 * time.Minute,
+		CollectiveTimeout: 0,
+		ResilienceTimeout: 30 * time.Second,
+	},
+	// high latency links between data centers: few large messages pipelined in segments, tolerate slow connections
+	`wan`: {
+		ChunkSize:         8 * Mi,
+		SegmentSize:       1 * Mi,
+		FusionSize:        256 * Mi,
+		Strategy:          `MULTI_BINARY_TREE_STAR`,
+		ConnRetryCount:    1500,
+		ConnRetryPeriod:   400 * time.Millisecond,
+		WaitRunnerTimeout: 15 * time.Minute,
+		CollectiveTimeout: 5 * time.Minute,
+		ResilienceTimeout: 10 * time.Minute,
+	},
+}
+
+// ProfileNames returns the names of the profiles in order.
+func ProfileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the profile of the given name.
+func GetProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid profile %q, options are: %v", name, ProfileNames())
+	}
+	return &p, nil
+}
+
+// UseProfile sets the knobs of the named profile, the knobs set by their own env keys take precedence.
+func UseProfile(name string) error {
+	p, err := GetProfile(name)
+	if err != nil {
+		return err
+	}
+	ProfileName = name
+	ChunkSize = p.ChunkSize
+	SegmentSize = p.SegmentSize
+	FusionSize = p.FusionSize
+	ConnRetryCount = p.ConnRetryCount
+	ConnRetryPeriod = p.ConnRetryPeriod
+	WaitRunnerTimeout = p.WaitRunnerTimeout
+	CollectiveTimeout = p.CollectiveTimeout
+	ResilienceTimeout = p.ResilienceTimeout
+	loadTuningEnvs()
+	return nil
+}
+
+func loadTuningEnvs() {
+	if val := os.Getenv(ChunkSizeEnvKey); len(val) > 0 {
+		ChunkSize = parseInt(val)
+	}
+	if val := os.Getenv(SegmentSizeEnvKey); len(val) > 0 {
+		SegmentSize = parseInt(val)
+	}
+	if val := os.Getenv(FusionSizeEnvKey); len(val) > 0 {
+		FusionSize = parseInt(val)
+	}
+	if val := os.Getenv(WaitRunnerTimeoutEnvKey); len(val) > 0 {
+		WaitRunnerTimeout = parseDuration(val)
+	}
+	if val := os.Getenv(CollectiveTimeoutEnvKey); len(val) > 0 {
+		CollectiveTimeout = parseDuration(val)
+	}
+	if val := os.Getenv(ResilienceTimeoutEnvKey); len(val) > 0 {
+		ResilienceTimeout = parseDuration(val)
+	}
+}
@@ -0,0 +1,491 @@
+// Package daemon implements a long-lived per-host process shared by successive and concurrent jobs.
+// The daemon owns the TCP listeners of workers and the results of topology probes,
+// so that jobs attaching to it don't rebind ports and repeat probes on start.
+// Connections between peers are still owned by jobs.
+//
+// Each job has its own namespace: a port is owned by one active job at a time.
+// Workers report the bytes they sent, and the daemon shares the bandwidth of the host fairly
+// among active jobs by returning a rate limit to each worker.
+//
+// If the number of worker slots of the host is limited, jobs get slots in order of priority, then of attaching.
+// Workers learn the number of workers their job may have on the host from reports,
+// so a job of higher priority preempts workers of lower priority jobs by elastic shrink,
+// and they grow back to their largest size on the host when slots are free again.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/platforms/cloud"
+	"github.com/lsds/KungFu/srcs/go/platforms/hwinfo"
+)
+
+// DefaultSockFile is the Unix socket file the daemon listens on.
+const DefaultSockFile = `/tmp/kungfu-daemon.sock`
+
+const (
+	opProbe  = `probe`
+	opListen = `listen`
+	opReport = `report`
+	opDetach = `detach`
+	opJobs   = `jobs`
+)
+
+// jobTimeout is the duration after which a worker that hasn't reported is considered gone.
+const jobTimeout = 10 * time.Second
+
+// NoLimit is the target of jobs if slots are not limited.
+const NoLimit = -1
+
+type request struct {
+	Op       string `json:"op"`
+	Job      string `json:"job,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Port     uint16 `json:"port,omitempty"`
+	Cloud    bool   `json:"cloud,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"` // bytes sent since the last report
+}
+
+type response struct {
+	Err    string    `json:"error,omitempty"`
+	Probe  *Probe    `json:"probe,omitempty"`
+	Rate   float64   `json:"rate,omitempty"` // bytes per second, 0 for no limit
+	Target int       `json:"target"`         // workers the job may have on the host, or NoLimit
+	Jobs   []JobInfo `json:"jobs,omitempty"`
+}
+
+// JobInfo is the accounting of a job on the host.
+type JobInfo struct {
+	Name     string  `json:"name"`
+	Priority int     `json:"priority"`
+	Workers  int     `json:"workers"`
+	Target   int     `json:"target"` // workers the job may have on the host, or NoLimit
+	Bytes    int64   `json:"bytes"`  // total bytes sent by workers of the host
+	Rate     float64 `json:"rate"`   // bandwidth share of each worker in bytes per second, 0 for no limit
+}
+
+type job struct {
+	workers  map[uint16]time.Time // port -> last seen
+	bytes    int64
+	priority int
+	attached time.Time
+	peak     int // the largest number of workers, restored when slots are free
+}
+
+type ownedListener struct {
+	*net.TCPListener
+	job string
+}
+
+// Probe is the result of topology probes of the host.
+type Probe struct {
+	Capability plan.Capability  `json:"capability"`
+	Placement  *cloud.Placement `json:"placement,omitempty"` // nil if not in a known cloud or not requested
+}
+
+// Daemon serves jobs of the current host via a Unix socket.
+type Daemon struct {
+	sync.Mutex
+
+	sockFile  string
+	listener  *net.UnixListener
+	listeners map[uint16]*ownedListener
+	jobs      map[string]*job
+	bandwidth float64 // bytes per second shared by jobs, 0 for no limit
+	slots     int     // workers of all jobs, 0 for no limit
+
+	capability   plan.Capability
+	cloudProbed  bool
+	placement    *cloud.Placement
+	probeCloudFn func() (*cloud.Placement, error)
+}
+
+// New creates a daemon sharing bandwidthMbps among jobs, the NIC speed is used if bandwidthMbps is 0.
+// Jobs share slots workers by priority, there is no limit if slots is 0.
+func New(sockFile string, bandwidthMbps int, slots int) *Daemon {
+	capability := hwinfo.Detect()
+	if bandwidthMbps == 0 {
+		bandwidthMbps = capability.NICSpeed
+	}
+	return &Daemon{
+		sockFile:   sockFile,
+		listeners:  make(map[uint16]*ownedListener),
+		jobs:       make(map[string]*job),
+		bandwidth:  float64(bandwidthMbps) * 1e6 / 8,
+		slots:      slots,
+		capability: capability,
+		probeCloudFn: func() (*cloud.Placement, error) {
+			return cloud.Detect(context.TODO())
+		},
+	}
+}
+
+func (d *Daemon) Listen() error {
+	if err := os.Remove(d.sockFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: d.sockFile, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	d.listener = lis
+	return nil
+}
+
+// Serve accepts jobs until Close is called.
+func (d *Daemon) Serve() {
+	for {
+		conn, err := d.listener.AcceptUnix()
+		if err != nil {
+			log.Debugf("daemon stopped accepting: %v", err)
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Daemon) Close() {
+	d.listener.Close()
+	d.Lock()
+	defer d.Unlock()
+	for _, l := range d.listeners {
+		l.Close()
+	}
+}
+
+func (d *Daemon) handle(conn *net.UnixConn) {
+	defer conn.Close()
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Warnf("invalid daemon request: %v", err)
+		return
+	}
+	var resp response
+	var oob []byte
+	switch req.Op {
+	case opProbe:
+		resp.Probe = d.probe(req.Cloud)
+	case opListen:
+		f, err := d.listen(req.Job, req.Priority, req.Port)
+		if err != nil {
+			resp.Err = err.Error()
+			break
+		}
+		defer f.Close()
+		oob = syscall.UnixRights(int(f.Fd()))
+	case opReport:
+		resp.Rate, resp.Target = d.report(req.Job, req.Priority, req.Port, req.Bytes)
+	case opDetach:
+		d.detach(req.Job, req.Port, req.Bytes)
+	case opJobs:
+		resp.Jobs = d.jobInfos()
+	default:
+		resp.Err = fmt.Sprintf("unknown op %q", req.Op)
+	}
+	bs, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("failed to encode daemon response: %v", err)
+		return
+	}
+	if _, _, err := conn.WriteMsgUnix(bs, oob, nil); err != nil {
+		log.Warnf("failed to write daemon response: %v", err)
+	}
+}
+
+func (d *Daemon) probe(withCloud bool) *Probe {
+	d.Lock()
+	defer d.Unlock()
+	p := &Probe{Capability: d.capability}
+	if withCloud {
+		if !d.cloudProbed {
+			placement, err := d.probeCloudFn()
+			if err != nil {
+				log.Warnf("failed to detect cloud placement: %v", err)
+			}
+			d.placement, d.cloudProbed = placement, true
+		}
+		p.Placement = d.placement
+	}
+	return p
+}
+
+// listen returns a duplicate of the file of the listener on port, the listener is created on first use.
+// It fails if the port is owned by another active job.
+func (d *Daemon) listen(jobName string, priority int, port uint16) (*os.File, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.prune()
+	l, ok := d.listeners[port]
+	if ok && l.job != jobName {
+		if j, ok := d.jobs[l.job]; ok {
+			if _, ok := j.workers[port]; ok {
+				return nil, fmt.Errorf("port %d is used by job %q", port, l.job)
+			}
+		}
+	}
+	if !ok {
+		addr := &net.TCPAddr{Port: int(port)}
+		tl, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("daemon: listening on %s", addr)
+		l = &ownedListener{TCPListener: tl}
+		d.listeners[port] = l
+	}
+	l.job = jobName
+	d.getJob(jobName, priority).see(port)
+	return l.File()
+}
+
+func (d *Daemon) getJob(name string, priority int) *job {
+	j, ok := d.jobs[name]
+	if !ok {
+		j = &job{workers: make(map[uint16]time.Time), attached: time.Now()}
+		d.jobs[name] = j
+		log.Infof("daemon: job %q of priority %d attached", name, priority)
+	}
+	j.priority = priority
+	return j
+}
+
+func (j *job) see(port uint16) {
+	j.workers[port] = time.Now()
+	if n := len(j.workers); n > j.peak {
+		j.peak = n
+	}
+}
+
+// prune removes the workers that haven't reported for jobTimeout, and jobs without workers.
+func (d *Daemon) prune() {
+	now := time.Now()
+	for name, j := range d.jobs {
+		for port, t := range j.workers {
+			if now.Sub(t) > jobTimeout {
+				delete(j.workers, port)
+			}
+		}
+		if len(j.workers) == 0 {
+			delete(d.jobs, name)
+			log.Infof("daemon: job %q detached, sent %d bytes", name, j.bytes)
+		}
+	}
+}
+
+// report accounts the bytes sent by a worker, and returns its bandwidth share and the target of its job.
+func (d *Daemon) report(jobName string, priority int, port uint16, n int64) (float64, int) {
+	d.Lock()
+	defer d.Unlock()
+	j := d.getJob(jobName, priority)
+	j.see(port)
+	j.bytes += n
+	d.prune()
+	return d.share(j), d.targets()[jobName]
+}
+
+// targets assigns the slots to jobs in order of priority then of attaching, up to the largest size of each job.
+func (d *Daemon) targets() map[string]int {
+	ts := make(map[string]int)
+	if d.slots <= 0 {
+		for name := range d.jobs {
+			ts[name] = NoLimit
+		}
+		return ts
+	}
+	var names []string
+	for name := range d.jobs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, k int) bool {
+		a, b := d.jobs[names[i]], d.jobs[names[k]]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.attached.Before(b.attached)
+	})
+	free := d.slots
+	for _, name := range names {
+		t := d.jobs[name].peak
+		if t > free {
+			t = free
+		}
+		ts[name] = t
+		free -= t
+	}
+	return ts
+}
+
+// share divides the bandwidth equally among active jobs, then among the workers of a job.
+// There is no limit without contention.
+func (d *Daemon) share(j *job) float64 {
+	if d.bandwidth <= 0 || len(d.jobs) < 2 || len(j.workers) == 0 {
+		return 0
+	}
+	return d.bandwidth / float64(len(d.jobs)) / float64(len(j.workers))
+}
+
+func (d *Daemon) detach(jobName string, port uint16, n int64) {
+	d.Lock()
+	defer d.Unlock()
+	if j, ok := d.jobs[jobName]; ok {
+		delete(j.workers, port)
+		j.bytes += n
+	}
+	d.prune()
+}
+
+func (d *Daemon) jobInfos() []JobInfo {
+	d.Lock()
+	defer d.Unlock()
+	d.prune()
+	ts := d.targets()
+	var infos []JobInfo
+	for name, j := range d.jobs {
+		infos = append(infos, JobInfo{
+			Name:     name,
+			Priority: j.priority,
+			Workers:  len(j.workers),
+			Target:   ts[name],
+			Bytes:    j.bytes,
+			Rate:     d.share(j),
+		})
+	}
+	sort.Slice(infos, func(i, k int) bool { return infos[i].Name < infos[k].Name })
+	return infos
+}
+
+// Client attaches a job to a daemon.
+type Client struct {
+	sockFile string
+	job      string
+	priority int
+}
+
+// Attach attaches a job of the given priority, a job of higher priority can preempt workers of lower priority jobs.
+func Attach(sockFile string, job string, priority int) *Client {
+	return &Client{sockFile: sockFile, job: job, priority: priority}
+}
+
+// Probe returns the cached probe results, including the cloud placement if withCloud.
+func (c *Client) Probe(withCloud bool) (*Probe, error) {
+	resp, _, err := c.call(request{Op: opProbe, Cloud: withCloud})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Probe, nil
+}
+
+var errNoListener = errors.New("daemon sent no listener")
+
+// Listener returns the TCP listener on port owned by the daemon.
+// Closing it doesn't close the listener of the daemon, which is reused by the next job.
+func (c *Client) Listener(port uint16) (net.Listener, error) {
+	_, fds, err := c.call(request{Op: opListen, Job: c.job, Priority: c.priority, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, errNoListener
+	}
+	f := os.NewFile(uintptr(fds[0]), fmt.Sprintf("kungfu-daemon-listener-%d", port))
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// Report accounts n bytes sent by the worker on port since the last report,
+// and returns its bandwidth share and the number of workers its job may have on the host (or NoLimit).
+// Workers must report at least once per jobTimeout to keep their ports.
+func (c *Client) Report(port uint16, n int64) (float64, int, error) {
+	resp, _, err := c.call(request{Op: opReport, Job: c.job, Priority: c.priority, Port: port, Bytes: n})
+	if err != nil {
+		return 0, NoLimit, err
+	}
+	return resp.Rate, resp.Target, nil
+}
+
+// Detach accounts the last n bytes sent by the worker on port, and releases the port.
+func (c *Client) Detach(port uint16, n int64) error {
+	_, _, err := c.call(request{Op: opDetach, Job: c.job, Port: port, Bytes: n})
+	return err
+}
+
+// Jobs returns the accounting of active jobs.
+func (c *Client) Jobs() ([]JobInfo, error) {
+	resp, _, err := c.call(request{Op: opJobs})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Jobs, nil
+}
+
+const maxResponseSize = 64 * 1024
+
+func (c *Client) call(req request) (*response, []int, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: c.sockFile, Net: "unix"})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, maxResponseSize)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	var n int
+	var fds []int
+	for {
+		m, oobn, _, _, err := conn.ReadMsgUnix(buf[n:], oob)
+		if oobn > 0 {
+			rights, err := parseRights(oob[:oobn])
+			if err != nil {
+				return nil, nil, err
+			}
+			fds = append(fds, rights...)
+		}
+		n += m
+		if err != nil || m == 0 || n == len(buf) {
+			break
+		}
+	}
+	var resp response
+	if err := json.Unmarshal(buf[:n], &resp); err != nil {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, nil, err
+	}
+	if len(resp.Err) > 0 {
+		return nil, nil, errors.New(resp.Err)
+	}
+	return &resp, fds, nil
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, m := range msgs {
+		rights, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
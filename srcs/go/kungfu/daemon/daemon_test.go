@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Daemon(t *testing.T) {
+	d := New(filepath.Join(t.TempDir(), "daemon.sock"), 0, 0)
+	if err := d.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	go d.Serve()
+
+	c := Attach(d.sockFile, "a", 0)
+	p, err := c.Probe(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Capability != d.capability || p.Placement != nil {
+		t.Errorf("unexpected probe result: %+v", p)
+	}
+
+	l1, err := c.Listener(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(l1.Addr().(*net.TCPAddr).Port)
+	l1.Close() // the daemon keeps listening on port 0
+	l2, err := c.Listener(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	if got := uint16(l2.Addr().(*net.TCPAddr).Port); got != port {
+		t.Errorf("listener is not reused: port %d, want %d", got, port)
+	}
+	go func() {
+		if conn, err := l2.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func Test_DaemonJobs(t *testing.T) {
+	d := New(filepath.Join(t.TempDir(), "daemon.sock"), 800, 0) // 100MB/s
+	if err := d.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	go d.Serve()
+
+	a := Attach(d.sockFile, "a", 0)
+	b := Attach(d.sockFile, "b", 0)
+	l, err := a.Listener(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := b.Listener(0); err == nil {
+		t.Error("port of job a is given to job b")
+	}
+	if rate, _, err := a.Report(0, 100); err != nil || rate != 0 {
+		t.Errorf("unexpected rate without contention: %f, %v", rate, err)
+	}
+	b.Report(1, 10)
+	b.Report(2, 10)
+	if rate, _, _ := a.Report(0, 100); rate != 50e6 {
+		t.Errorf("unexpected rate of job a: %f", rate)
+	}
+	if rate, _, _ := b.Report(1, 0); rate != 25e6 {
+		t.Errorf("unexpected rate of job b: %f", rate)
+	}
+	jobs, err := a.Jobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].Bytes != 200 || jobs[1].Workers != 2 || jobs[1].Bytes != 20 {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+	if err := a.Detach(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	l2, err := b.Listener(0)
+	if err != nil {
+		t.Errorf("port is not released: %v", err)
+	} else {
+		l2.Close()
+	}
+}
+
+func Test_DaemonPreemption(t *testing.T) {
+	d := New(filepath.Join(t.TempDir(), "daemon.sock"), 0, 4)
+	if err := d.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	go d.Serve()
+
+	low := Attach(d.sockFile, "low", 0)
+	high := Attach(d.sockFile, "high", 1)
+	for port := uint16(10); port < 14; port++ {
+		low.Report(port, 0)
+	}
+	if _, target, _ := low.Report(10, 0); target != 4 {
+		t.Errorf("unexpected target of job low without contention: %d", target)
+	}
+	high.Report(20, 0)
+	high.Report(21, 0)
+	if _, target, _ := high.Report(20, 0); target != 2 {
+		t.Errorf("unexpected target of job high: %d", target)
+	}
+	if _, target, _ := low.Report(10, 0); target != 2 {
+		t.Errorf("job low is not preempted: %d", target)
+	}
+	// low shrinks, then high finishes
+	low.Detach(12, 0)
+	low.Detach(13, 0)
+	high.Detach(20, 0)
+	high.Detach(21, 0)
+	if _, target, _ := low.Report(10, 0); target != 4 {
+		t.Errorf("job low is not restored: %d", target)
+	}
+}
@@ -17,8 +17,11 @@ type Config struct {
 
 	InitClusterVersion string
 	InitPeers          plan.PeerList
+	Groups             plan.Groups
 
 	Single bool
+
+	Transport string // of connections between hosts, config.Transport if empty
 }
 
 func ParseConfigFromEnv() (*Config, error) {
@@ -45,12 +48,17 @@ func ParseConfigFromEnv() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	groups, err := plan.ParseGroups(os.Getenv(GroupsEnvKey))
+	if err != nil {
+		return nil, err
+	}
 	return &Config{
 		ConfigServer:       getConfigServerFromEnv(),
 		Self:               *self,
 		Parent:             *parent,
 		InitRunners:        initRunners,
 		InitPeers:          initPeers,
+		Groups:             groups,
 		Strategy:           *strategy,
 		InitClusterVersion: os.Getenv(InitClusterVersionEnvKey),
 	}, nil
@@ -10,6 +10,7 @@ const (
 	RunnerListEnvKey        = `KUNGFU_INIT_RUNNERS`
 	SelfSpecEnvKey          = `KUNGFU_SELF_SPEC` // self spec should never change during the life of a process
 	AllReduceStrategyEnvKey = `KUNGFU_ALLREDUCE_STRATEGY`
+	GroupsEnvKey            = `KUNGFU_GROUPS`
 
 	JobStartTimestamp  = `KUNGFU_JOB_START_TIMESTAMP`
 	ProcStartTimestamp = `KUNGFU_PROC_START_TIMESTAMP`
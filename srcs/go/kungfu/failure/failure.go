@@ -0,0 +1,118 @@
+// Package failure classifies the failures of collectives into kinds, so that frameworks can choose a recovery by the kind
+// of an error without matching its message: e.g. retry after a timeout, resize after a peer crash, abort after a
+// protocol mismatch.
+package failure
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Kind is the category of a failure.
+type Kind int
+
+const (
+	Unknown          Kind = iota
+	PeerCrash             // a peer exited or is unreachable
+	Timeout               // the collective didn't finish in time
+	ProtocolMismatch      // peers disagree on the wire protocol, or on the strategies, codecs or ops of collectives
+	Cancelled             // a peer aborted the collectives
+	ShrinkInProgress      // the cluster is being resized, peers are of different cluster versions
+)
+
+var names = [...]string{
+	Unknown:          `unknown`,
+	PeerCrash:        `peer-crash`,
+	Timeout:          `timeout`,
+	ProtocolMismatch: `protocol-mismatch`,
+	Cancelled:        `cancelled`,
+	ShrinkInProgress: `shrink-in-progress`,
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(names) {
+		return names[Unknown]
+	}
+	return names[k]
+}
+
+// Code is the non-zero error code of the C API for a failure of the kind, 1 for Unknown.
+func (k Kind) Code() int {
+	return 1 + int(k)
+}
+
+// rank orders the kinds of the errors in a chain, the kind of the highest rank is the kind of the chain,
+// e.g. a connection refused by a peer that has been resized away is not a crash.
+var rank = [...]int{
+	Unknown:          0,
+	Timeout:          1,
+	PeerCrash:        2,
+	ProtocolMismatch: 3,
+	ShrinkInProgress: 4,
+	Cancelled:        5,
+}
+
+// Classified is an error that carries its kind.
+type Classified interface {
+	error
+	FailureKind() Kind
+}
+
+type classified struct {
+	kind Kind
+	msg  string
+}
+
+func (e *classified) Error() string     { return e.msg }
+func (e *classified) FailureKind() Kind { return e.kind }
+
+// New returns an error of the kind with the message, for sentinel errors.
+func New(kind Kind, msg string) error {
+	return &classified{kind: kind, msg: msg}
+}
+
+// Of returns the kind of err, which is the kind of the highest rank of the errors that err wraps,
+// including the errors merged by utils.MergeErrors. It's Unknown if err is nil or no error of the chain has a kind.
+func Of(err error) Kind {
+	k := Unknown
+	walk(err, func(e error) {
+		if x := kindOf(e); rank[x] > rank[k] {
+			k = x
+		}
+	})
+	return k
+}
+
+// kindOf classifies a single error, errors of the standard library are classified by their values.
+func kindOf(err error) Kind {
+	if c, ok := err.(Classified); ok {
+		return c.FailureKind()
+	}
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE, syscall.EHOSTUNREACH:
+		return PeerCrash
+	case context.DeadlineExceeded:
+		return Timeout
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return Timeout
+	}
+	return Unknown
+}
+
+func walk(err error, f func(error)) {
+	if err == nil {
+		return
+	}
+	f(err)
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		walk(e.Unwrap(), f)
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			walk(err, f)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+func Test_Of(t *testing.T) {
+	timeout := New(Timeout, "timeout")
+	shrink := New(ShrinkInProgress, "invalid token")
+	crash := New(PeerCrash, "can't establish connection")
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: &net.AddrError{}}
+	tests := []struct {
+		err  error
+		kind Kind
+	}{
+		{nil, Unknown},
+		{errors.New("other"), Unknown},
+		{timeout, Timeout},
+		{fmt.Errorf("%w: x didn't arrive", timeout), Timeout},
+		{io.EOF, PeerCrash},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, PeerCrash},
+		{reset, Unknown},
+		{fmt.Errorf("%w: %w", crash, shrink), ShrinkInProgress},
+		{utils.MergeErrors([]error{timeout, nil, crash}, "AllReduce"), PeerCrash},
+		{utils.MergeErrors([]error{io.EOF, New(Cancelled, "aborted")}, "AllReduce"), Cancelled},
+	}
+	for i, tt := range tests {
+		if got := Of(tt.err); got != tt.kind {
+			t.Errorf("#%d: Of(%v) = %s, want %s", i, tt.err, got, tt.kind)
+		}
+	}
+}
+
+func Test_Code(t *testing.T) {
+	if Unknown.Code() != 1 {
+		t.Errorf("Unknown.Code() = %d, want 1", Unknown.Code())
+	}
+	if s := Kind(100).String(); s != "unknown" {
+		t.Errorf("Kind(100).String() = %q", s)
+	}
+}
@@ -18,12 +18,14 @@ type Job struct {
 	StartTime    time.Time
 	ConfigServer string
 	Strategy     base.Strategy
+	Profile      string
 	Parent       plan.PeerID
 	HostList     plan.HostList
 	PortRange    plan.PortRange
 	Prog         string
 	Args         []string
 	LogDir       string
+	Envs         proc.Envs // of the workers, overriding the others
 
 	AllowNVLink bool
 }
@@ -41,9 +43,15 @@ func (j Job) NewProc(peer plan.PeerID, gpuID int, initClusterVersion int, cluste
 		env.ConfigServerEnvKey:       j.ConfigServer,
 		env.AllowNvLink:              fmt.Sprintf("%v", j.AllowNVLink),
 	}
+	if len(j.Profile) > 0 {
+		envs[config.ProfileEnvKey] = j.Profile
+	}
 	if len(j.ConfigServer) > 0 {
 		envs[env.ConfigServerEnvKey] = j.ConfigServer
 	}
+	if groups := j.HostList.Groups(); len(groups) > 0 {
+		envs[env.GroupsEnvKey] = groups.String()
+	}
 	cudaIdx := strconv.Itoa(getCudaIndex(gpuID))
 	envs[`KUNGFU_`+cudaVisibleDevicesKey] = cudaIdx
 	if j.AllowNVLink {
@@ -52,7 +60,7 @@ func (j Job) NewProc(peer plan.PeerID, gpuID int, initClusterVersion int, cluste
 		envs[cudaVisibleDevicesKey] = cudaIdx
 	}
 
-	allEnvs := proc.Merge(getConfigEnvs(), envs)
+	allEnvs := proc.Merge(proc.Merge(getConfigEnvs(), envs), j.Envs)
 	allEnvs.AddIfMissing(`PYTHONUNBUFFERED`, `1`)
 	var pubAddr string
 	for _, h := range j.HostList {
@@ -0,0 +1,15 @@
+package peer
+
+import (
+	"context"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/checkpoint"
+)
+
+// Checkpoint uploads buf of rank 0 to url/name, e.g. s3://bucket/prefix.
+// It must be called by all peers of the current session.
+func (p *Peer) Checkpoint(url, name string, buf *base.Vector) error {
+	newUploader := func() (checkpoint.Uploader, error) { return checkpoint.NewUploader(url) }
+	return checkpoint.Save(context.TODO(), p.CurrentSession(), newUploader, name, buf.Data)
+}
@@ -0,0 +1,527 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/runner"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/proc"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// controller holds the job control state, which is owned by rank 0
+// and applied by all peers at step boundaries.
+type controller struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	paused     bool
+	targetSize int
+	commands   []strategyCommand
+	strategies func() []session.StrategyInfo
+	session    func() *session.Session
+	snapshots  *snapshots
+	links      *linkRates
+
+	step    int
+	size    int
+	version int
+	updated time.Time
+}
+
+// strategyCommand is a strategy management request, applied by all peers at a step boundary.
+type strategyCommand struct {
+	Op     string  `json:"op"`
+	Name   string  `json:"name,omitempty"`
+	Forest []int32 `json:"forest,omitempty"`
+}
+
+const (
+	opSuspend = `suspend`
+	opResume  = `resume`
+	opInstall = `install`
+	opRetune  = `retune`
+)
+
+func newController() *controller {
+	c := &controller{snapshots: newSnapshots(), links: &linkRates{}}
+	c.cond = sync.NewCond(&c.Mutex)
+	return c
+}
+
+type jobProgress struct {
+	Step       int       `json:"step"`
+	Size       int       `json:"size"`
+	Version    int       `json:"version"`
+	Paused     bool      `json:"paused"`
+	TargetSize int       `json:"target_size"`
+	Updated    time.Time `json:"updated"`
+}
+
+func (c *controller) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet && req.URL.Path == "/progress" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(c.progress())
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/peers" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(c.session().Peers())
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/sessions" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(c.session().SessionUsages())
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/strategies/stats" {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(c.session().StrategyStats())
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/links/probe" {
+		c.probeLink(w, req)
+		return
+	}
+	if req.Method == http.MethodPost && req.URL.Path == "/links/degraded" {
+		c.reportDegradedLink(w, req)
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/graph" {
+		c.serveGraph(w, req)
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/snapshot" {
+		c.serveSnapshot(w, req)
+		return
+	}
+	if req.Method == http.MethodGet && req.URL.Path == "/strategies" {
+		c.Lock()
+		list := c.strategies
+		c.Unlock()
+		if list == nil {
+			http.Error(w, "session not ready", http.StatusServiceUnavailable)
+			return
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(list())
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	switch req.URL.Path {
+	case "/pause":
+		c.paused = true
+	case "/resume":
+		c.paused = false
+	case "/scale":
+		n, err := strconv.Atoi(req.FormValue("size"))
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid size: %q", req.FormValue("size")), http.StatusBadRequest)
+			return
+		}
+		c.targetSize = n
+	case "/strategies/suspend":
+		c.commands = append(c.commands, strategyCommand{Op: opSuspend, Name: req.FormValue("name")})
+	case "/strategies/resume":
+		c.commands = append(c.commands, strategyCommand{Op: opResume, Name: req.FormValue("name")})
+	case "/strategies/retune":
+		c.commands = append(c.commands, strategyCommand{Op: opRetune})
+	case "/strategies/install":
+		var cmd strategyCommand
+		if err := json.NewDecoder(req.Body).Decode(&cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(cmd.Name) == 0 || len(cmd.Forest) == 0 {
+			http.Error(w, "name and forest are required", http.StatusBadRequest)
+			return
+		}
+		cmd.Op = opInstall
+		c.commands = append(c.commands, cmd)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	log.Infof("control request %s accepted", req.URL)
+	c.cond.Broadcast()
+}
+
+const defaultProbeSize = 1 << 20
+
+// probeLink measures the link between the peers of ranks a and b, with size bytes (1MiB by default, at most client.MaxProbeSize).
+func (c *controller) probeLink(w http.ResponseWriter, req *http.Request) {
+	a, errA := strconv.Atoi(req.FormValue("a"))
+	b, errB := strconv.Atoi(req.FormValue("b"))
+	if errA != nil || errB != nil {
+		http.Error(w, "ranks a and b are required", http.StatusBadRequest)
+		return
+	}
+	size := defaultProbeSize
+	if val := req.FormValue("size"); len(val) > 0 {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid size: %q", val), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	if size < 0 || size > client.MaxProbeSize {
+		http.Error(w, fmt.Sprintf("invalid size: %d, must be in [0, %d]", size, client.MaxProbeSize), http.StatusBadRequest)
+		return
+	}
+	sess := c.session()
+	if n := sess.Size(); a < 0 || a >= n || b < 0 || b >= n || a == b {
+		http.Error(w, fmt.Sprintf("invalid probe %d -> %d in %d peers", a, b, n), http.StatusBadRequest)
+		return
+	}
+	stats, err := sess.ProbeLink(a, b, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	e.Encode(stats)
+}
+
+// reportDegradedLink notifies all peers that the link between the peers of ranks from and to is degraded,
+// so that they invalidate the stats of the strategies using it, and the peer from re-probes it.
+func (c *controller) reportDegradedLink(w http.ResponseWriter, req *http.Request) {
+	from, errFrom := strconv.Atoi(req.FormValue("from"))
+	to, errTo := strconv.Atoi(req.FormValue("to"))
+	if errFrom != nil || errTo != nil {
+		http.Error(w, "ranks from and to are required", http.StatusBadRequest)
+		return
+	}
+	sess := c.session()
+	if n := sess.Size(); from < 0 || from >= n || to < 0 || to >= n || from == to {
+		http.Error(w, fmt.Sprintf("invalid link %d -> %d in %d peers", from, to, n), http.StatusBadRequest)
+		return
+	}
+	e := session.TopologyEvent{Kind: session.LinkDegraded, From: from, To: to}
+	if err := sess.ReportTopologyEvent(e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Infof("control request %s accepted", req.URL)
+}
+
+func (c *controller) progress() jobProgress {
+	c.Lock()
+	defer c.Unlock()
+	return jobProgress{
+		Step:       c.step,
+		Size:       c.size,
+		Version:    c.version,
+		Paused:     c.paused,
+		TargetSize: c.targetSize,
+		Updated:    c.updated,
+	}
+}
+
+// update applies f to the state and wakes up the waiting rank 0.
+func (c *controller) update(f func()) {
+	c.Lock()
+	defer c.Unlock()
+	f()
+	c.cond.Broadcast()
+}
+
+func (c *controller) record(step, size, version int, strategies func() []session.StrategyInfo) {
+	c.Lock()
+	defer c.Unlock()
+	c.strategies = strategies
+	c.step = step
+	c.size = size
+	c.version = version
+	c.updated = time.Now()
+}
+
+// replicate keeps the state of the coordinator on a standby peer, with its pending commands,
+// to take over with it if the coordinator fails.
+func (c *controller) replicate(paused bool, targetSize int, commands []strategyCommand) {
+	c.Lock()
+	defer c.Unlock()
+	c.paused = paused
+	c.targetSize = targetSize
+	c.commands = commands
+}
+
+// state returns the current state and the pending commands, and removes the next command, which is applied first.
+// If paused, it waits for a change or until timeout.
+func (c *controller) state(timeout time.Duration) (bool, int, []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if c.paused && len(c.commands) == 0 {
+		t := time.AfterFunc(timeout, c.cond.Broadcast)
+		c.cond.Wait()
+		t.Stop()
+	}
+	var payload []byte
+	if len(c.commands) > 0 {
+		payload, _ = json.Marshal(c.commands)
+		c.commands = c.commands[1:]
+	}
+	return c.paused, c.targetSize, payload
+}
+
+// startControlServer serves the control API on a TCP port if port > 0, and on a Unix socket if sockFile is not empty.
+// It returns a function that stops the servers.
+func (p *Peer) startControlServer(port int, sockFile string) func() {
+	p.controller.session = p.CurrentSession
+	var servers []*http.Server
+	if port > 0 {
+		addr := net.JoinHostPort("", strconv.Itoa(port))
+		log.Infof("control server: http://%s/", addr)
+		s := &http.Server{Addr: addr, Handler: p.controller}
+		servers = append(servers, s)
+		go func() {
+			lis, err := listenControl(addr)
+			if err != nil {
+				log.Errorf("failed to start control server on %s: %v", addr, err)
+				return
+			}
+			if err := s.Serve(lis); err != nil && err != http.ErrServerClosed {
+				log.Errorf("control server stopped: %v", err)
+			}
+		}()
+	}
+	stop := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+	if len(sockFile) > 0 {
+		if err := os.Remove(sockFile); err != nil && !os.IsNotExist(err) {
+			log.Errorf("can't cleanup socket file %s: %v", sockFile, err)
+			return stop
+		}
+		lis, err := net.Listen("unix", sockFile)
+		if err != nil {
+			log.Errorf("failed to start control server on %s: %v", sockFile, err)
+			return stop
+		}
+		log.Infof("control server: unix://%s", sockFile)
+		s := &http.Server{Handler: p.controller}
+		servers = append(servers, s)
+		go func() {
+			if err := s.Serve(lis); err != nil && err != http.ErrServerClosed {
+				log.Errorf("control server stopped: %v", err)
+			}
+		}()
+	}
+	return stop
+}
+
+// controlListenTimeout bounds the wait of a new coordinator for the port of the control server,
+// which the previous coordinator on the same host releases when it learns about the new cluster.
+const controlListenTimeout = 10 * time.Second
+
+func listenControl(addr string) (net.Listener, error) {
+	deadline := time.Now().Add(controlListenTimeout)
+	for {
+		lis, err := net.Listen("tcp", addr)
+		if err == nil || time.Now().After(deadline) {
+			return lis, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+const pausePollPeriod = 1 * time.Second
+
+// StepBoundary applies pending control requests (pause, resume, scale, strategy commands) received by rank 0.
+// It must be called by all peers at the same step, it blocks while the job is paused,
+// and it returns the same results as ResizeClusterFromURL. In local-only mode, it doesn't apply requests,
+// and it returns changed when the peers rejoin, for them to synchronize the model again and to resume from the step
+// of the session, which they agree on as the latest of their steps.
+// The session advances to step, so that the strategies swapped at the previous boundary are used from it.
+func (p *Peer) StepBoundary(step int) (bool, bool, error) {
+	for {
+		sess := p.CurrentSession()
+		sess.AdvanceStepTo(int64(step))
+		if sess.Rejoined() {
+			return true, true, nil // the model must be synchronized again
+		}
+		if sess.LocalOnly() {
+			return false, true, nil
+		}
+		x := base.NewVector(4, base.I32)
+		var payload []byte
+		p.controller.record(step, sess.Size(), p.clusterVersion, sess.GlobalStrategies)
+		if sess.Rank() == 0 {
+			var paused bool
+			var targetSize int
+			paused, targetSize, payload = p.controller.state(pausePollPeriod)
+			x.AsI32()[0] = boolToInt32(paused)
+			x.AsI32()[1] = int32(targetSize)
+			x.AsI32()[2] = int32(len(payload))
+			x.AsI32()[3] = boolToInt32(p.controller.links.isWatched())
+		}
+		w := base.Workspace{SendBuf: x, RecvBuf: x, Name: "kungfu::control"}
+		if err := broadcastControl(sess, w); err != nil {
+			return p.failover(err)
+		}
+		var pending []strategyCommand
+		if n := int(x.AsI32()[2]); n > 0 {
+			y := base.NewVector(n, base.U8)
+			copy(y.Data, payload)
+			w := base.Workspace{SendBuf: y, RecvBuf: y, Name: "kungfu::control:command"}
+			if err := sess.Broadcast(w); err != nil {
+				return false, true, err
+			}
+			if err := json.Unmarshal(y.Data, &pending); err != nil || len(pending) == 0 {
+				log.Errorf("invalid strategy commands: %v", err)
+			} else {
+				applyStrategyCommand(sess, pending[0])
+				pending = pending[1:]
+			}
+		}
+		watched := x.AsI32()[3] != 0
+		if sess.Rank() != 0 {
+			p.controller.replicate(x.AsI32()[0] != 0, int(x.AsI32()[1]), pending)
+			if watched {
+				p.controller.links.watch()
+			}
+		}
+		if watched {
+			sent, err := sess.AllGatherSentBytes()
+			if err != nil {
+				return false, true, err
+			}
+			p.controller.links.record(sent)
+		}
+		if err := sess.CheckLinkBreakers(); err != nil {
+			return false, true, err
+		}
+		if config.EnableStrategyMonitoring {
+			if err := sess.MonitorStrategies(); err != nil {
+				return false, true, err
+			}
+		}
+		if err := sess.ReweightStrategies(); err != nil {
+			return false, true, err
+		}
+		chunkSizes := config.ChunkSizes
+		if err := sess.TuneChunkSizes(); err != nil {
+			return false, true, err
+		}
+		if config.ChunkSizes != chunkSizes && sess.LocalRank() == 0 {
+			p.publishConfig(proc.Envs{config.ChunkSizesEnvKey: config.ChunkSizes})
+		}
+		if err := sess.SelectCodecs(); err != nil {
+			return false, true, err
+		}
+		if paused := x.AsI32()[0] != 0; paused {
+			continue
+		}
+		if targetSize := int(x.AsI32()[1]); targetSize > 0 && targetSize != sess.Size() {
+			cluster := p.getCurrentCluster()
+			newCluster, err := cluster.Resize(targetSize)
+			if err != nil {
+				return false, true, err
+			}
+			return p.resizeTo(*newCluster)
+		}
+		// all peers join the agreement, as only some of them may be attached to a daemon,
+		// and the others contribute nothing as their target is not limited
+		newCluster, err := p.preemption.propose(sess, p.self, p.getCurrentCluster())
+		if err != nil {
+			return false, true, err
+		}
+		if newCluster != nil {
+			log.Infof("preemption: resizing from %d to %d workers", sess.Size(), len(newCluster.Workers))
+			return p.resizeTo(*newCluster)
+		}
+		return false, true, nil
+	}
+}
+
+func (p *Peer) resizeTo(cluster plan.Cluster) (bool, bool, error) {
+	return p.resizeWith(cluster, p.consensus)
+}
+
+// resizeWith changes the cluster to cluster as resizeTo, if the workers agree on it by consensus.
+func (p *Peer) resizeWith(cluster plan.Cluster, consensus consensusFunc) (bool, bool, error) {
+	changed, keep, err := p.propose(cluster, consensus)
+	if err != nil {
+		return false, true, err
+	}
+	if keep {
+		p.Update()
+		p.electCoordinator()
+	} else {
+		p.detached = true
+		p.resignCoordinator()
+	}
+	return changed, keep, nil
+}
+
+func applyStrategyCommand(sess *session.Session, cmd strategyCommand) {
+	var err error
+	switch cmd.Op {
+	case opSuspend:
+		err = sess.SuspendStrategy(cmd.Name)
+	case opResume:
+		err = sess.ResumeStrategy(cmd.Name)
+	case opInstall:
+		err = sess.InstallStrategy(cmd.Name, cmd.Forest)
+	case opRetune:
+		err = sess.Retune()
+	default:
+		err = fmt.Errorf("unknown op %q", cmd.Op)
+	}
+	if err != nil {
+		// all peers fail consistently, so training can continue with the current strategies.
+		log.Warnf("strategy command %s %s failed: %v", cmd.Op, cmd.Name, err)
+		return
+	}
+	log.Infof("strategy command %s %s applied", cmd.Op, cmd.Name)
+}
+
+func boolToInt32(v bool) int32 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// publishConfig sends envs learned during training to the runner of this peer, so that the workers it starts later,
+// e.g. after a resize or a restart, start from them, see runner.ConfigMessageName. It doesn't wait for the runner.
+func (p *Peer) publishConfig(envs proc.Envs) {
+	if p.single {
+		return
+	}
+	bs, err := json.Marshal(envs)
+	if err != nil {
+		log.Warnf("encoding the config failed: %v", err)
+		return
+	}
+	go func() {
+		if err := p.router.Send(p.parent.WithName(runner.ConfigMessageName), bs, connection.ConnControl, 0); err != nil {
+			log.Warnf("publishing the config to %s failed: %v", p.parent, err)
+		}
+	}()
+}
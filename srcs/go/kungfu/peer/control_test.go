@@ -0,0 +1,53 @@
+package peer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_listenControl(t *testing.T) {
+	prev, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := prev.Addr().String()
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		prev.Close()
+	}()
+	lis, err := listenControl(addr)
+	if err != nil {
+		t.Fatalf("listen on %s after the previous listener closed: %v", addr, err)
+	}
+	lis.Close()
+}
+
+func Test_probeLinkSize(t *testing.T) {
+	c := newController() // the size is checked before the session is used
+	for _, size := range []string{"-1", "67108865", "x"} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/links/probe?a=0&b=1&size="+size, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("probe of %s bytes: status %d, want %d", size, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func Test_handleTopologyMessageUnlocked(t *testing.T) {
+	p := &Peer{}
+	p.Lock() // as Update, while it waits on the other peers
+	defer p.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.handleTopologyMessage([]byte(`{"kind":"link-degraded","from":0,"to":1}`))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleTopologyMessage waits on the lock of the peer")
+	}
+}
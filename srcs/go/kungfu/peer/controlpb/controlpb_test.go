@@ -0,0 +1,44 @@
+package controlpb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_wire(t *testing.T) {
+	// protoc --encode=kungfu.control.v1.ResizeRequest control.proto <<< 'size: 150'
+	if bs, _ := (&ResizeRequest{Size: 150}).Marshal(); !bytes.Equal(bs, []byte{0x08, 0x96, 0x01}) {
+		t.Errorf("unexpected encoding: %x", bs)
+	}
+	if bs, _ := (&ResizeRequest{Size: -1}).Marshal(); len(bs) != 11 {
+		t.Errorf("negative int32 should be encoded in 10 bytes, got %x", bs)
+	}
+}
+
+func Test_roundtrip(t *testing.T) {
+	c := Cluster{
+		Version: 3,
+		Workers: []string{"10.0.0.1:10000", "10.0.0.2:10000"},
+		Runners: []string{"10.0.0.1:38080"},
+	}
+	bs, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d Cluster
+	if err := d.Unmarshal(bs); err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != c.Version || len(d.Workers) != 2 || d.Workers[1] != c.Workers[1] || d.Runners[0] != c.Runners[0] {
+		t.Errorf("roundtrip failed: %v", d)
+	}
+	p := Progress{Step: 1 << 40, Size: 4, Paused: true, UpdatedUnixNano: -5}
+	bs, _ = p.Marshal()
+	var q Progress
+	if err := q.Unmarshal(bs); err != nil || q != p {
+		t.Errorf("roundtrip failed: %v, %v", q, err)
+	}
+	if err := q.Unmarshal(bs[:len(bs)-1]); err == nil {
+		t.Errorf("expect error for truncated message")
+	}
+}
@@ -0,0 +1,179 @@
+package controlpb
+
+// Message is implemented by all messages of the Control service.
+type Message interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+type Empty struct{}
+
+func (m *Empty) Marshal() ([]byte, error) { return nil, nil }
+
+func (m *Empty) Unmarshal(bs []byte) error {
+	return decode(bs, func(field) error { return nil })
+}
+
+type Cluster struct {
+	Version int32
+	Workers []string
+	Runners []string
+}
+
+func (m *Cluster) Marshal() ([]byte, error) {
+	var e encoder
+	e.int(1, int64(m.Version))
+	e.repeatedString(2, m.Workers)
+	e.repeatedString(3, m.Runners)
+	return e.buf, nil
+}
+
+func (m *Cluster) Unmarshal(bs []byte) error {
+	*m = Cluster{}
+	return decode(bs, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Version = f.int32()
+		case 2:
+			m.Workers = append(m.Workers, string(f.bytes))
+		case 3:
+			m.Runners = append(m.Runners, string(f.bytes))
+		}
+		return nil
+	})
+}
+
+type ResizeRequest struct {
+	Size int32
+}
+
+func (m *ResizeRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.int(1, int64(m.Size))
+	return e.buf, nil
+}
+
+func (m *ResizeRequest) Unmarshal(bs []byte) error {
+	*m = ResizeRequest{}
+	return decode(bs, func(f field) error {
+		if f.num == 1 {
+			m.Size = f.int32()
+		}
+		return nil
+	})
+}
+
+type Progress struct {
+	Step            int64
+	Size            int32
+	Version         int32
+	Paused          bool
+	TargetSize      int32
+	UpdatedUnixNano int64
+}
+
+func (m *Progress) Marshal() ([]byte, error) {
+	var e encoder
+	e.int(1, m.Step)
+	e.int(2, int64(m.Size))
+	e.int(3, int64(m.Version))
+	e.bool(4, m.Paused)
+	e.int(5, int64(m.TargetSize))
+	e.int(6, m.UpdatedUnixNano)
+	return e.buf, nil
+}
+
+func (m *Progress) Unmarshal(bs []byte) error {
+	*m = Progress{}
+	return decode(bs, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Step = f.int64()
+		case 2:
+			m.Size = f.int32()
+		case 3:
+			m.Version = f.int32()
+		case 4:
+			m.Paused = f.bool()
+		case 5:
+			m.TargetSize = f.int32()
+		case 6:
+			m.UpdatedUnixNano = f.int64()
+		}
+		return nil
+	})
+}
+
+type BarrierRequest struct {
+	Name    string
+	Parties int32
+}
+
+func (m *BarrierRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.string(1, m.Name)
+	e.int(2, int64(m.Parties))
+	return e.buf, nil
+}
+
+func (m *BarrierRequest) Unmarshal(bs []byte) error {
+	*m = BarrierRequest{}
+	return decode(bs, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Name = string(f.bytes)
+		case 2:
+			m.Parties = f.int32()
+		}
+		return nil
+	})
+}
+
+type ConsensusRequest struct {
+	Name    string
+	Parties int32
+	Value   []byte
+}
+
+func (m *ConsensusRequest) Marshal() ([]byte, error) {
+	var e encoder
+	e.string(1, m.Name)
+	e.int(2, int64(m.Parties))
+	e.bytes(3, m.Value)
+	return e.buf, nil
+}
+
+func (m *ConsensusRequest) Unmarshal(bs []byte) error {
+	*m = ConsensusRequest{}
+	return decode(bs, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Name = string(f.bytes)
+		case 2:
+			m.Parties = f.int32()
+		case 3:
+			m.Value = append([]byte(nil), f.bytes...)
+		}
+		return nil
+	})
+}
+
+type ConsensusResponse struct {
+	OK bool
+}
+
+func (m *ConsensusResponse) Marshal() ([]byte, error) {
+	var e encoder
+	e.bool(1, m.OK)
+	return e.buf, nil
+}
+
+func (m *ConsensusResponse) Unmarshal(bs []byte) error {
+	*m = ConsensusResponse{}
+	return decode(bs, func(f field) error {
+		if f.num == 1 {
+			m.OK = f.bool()
+		}
+		return nil
+	})
+}
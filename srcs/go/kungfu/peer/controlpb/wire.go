@@ -0,0 +1,134 @@
+// Package controlpb implements the messages of srcs/proto/kungfu/control/v1/control.proto.
+// The wire format is encoded by hand, so that it doesn't depend on the protobuf runtime.
+package controlpb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errTruncated     = errors.New("controlpb: truncated message")
+	errInvalidWire   = errors.New("controlpb: invalid wire type")
+	errInvalidVarint = errors.New("controlpb: invalid varint")
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], x)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) tag(field int, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// int encodes int32 and int64 fields, default values are omitted as in proto3.
+func (e *encoder) int(field int, x int64) {
+	if x != 0 {
+		e.tag(field, wireVarint)
+		e.varint(uint64(x))
+	}
+}
+
+func (e *encoder) bool(field int, x bool) {
+	if x {
+		e.tag(field, wireVarint)
+		e.varint(1)
+	}
+}
+
+func (e *encoder) bytes(field int, bs []byte) {
+	if len(bs) > 0 {
+		e.tag(field, wireBytes)
+		e.varint(uint64(len(bs)))
+		e.buf = append(e.buf, bs...)
+	}
+}
+
+func (e *encoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+// repeatedString encodes all elements, including empty ones.
+func (e *encoder) repeatedString(field int, ss []string) {
+	for _, s := range ss {
+		e.tag(field, wireBytes)
+		e.varint(uint64(len(s)))
+		e.buf = append(e.buf, s...)
+	}
+}
+
+type field struct {
+	num    int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+func (f field) int64() int64 { return int64(f.varint) }
+
+func (f field) int32() int32 { return int32(f.varint) }
+
+func (f field) bool() bool { return f.varint != 0 }
+
+// decode calls visit for each field of bs, unknown fields are passed to visit as well.
+func decode(bs []byte, visit func(field) error) error {
+	for len(bs) > 0 {
+		key, n := binary.Uvarint(bs)
+		if n <= 0 {
+			return errInvalidVarint
+		}
+		bs = bs[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			x, n := binary.Uvarint(bs)
+			if n <= 0 {
+				return errInvalidVarint
+			}
+			f.varint = x
+			bs = bs[n:]
+		case wireFixed64:
+			if len(bs) < 8 {
+				return errTruncated
+			}
+			f.varint = binary.LittleEndian.Uint64(bs)
+			bs = bs[8:]
+		case wireFixed32:
+			if len(bs) < 4 {
+				return errTruncated
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(bs))
+			bs = bs[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(bs)
+			if n <= 0 {
+				return errInvalidVarint
+			}
+			bs = bs[n:]
+			if uint64(len(bs)) < l {
+				return errTruncated
+			}
+			f.bytes = bs[:l]
+			bs = bs[l:]
+		default:
+			return errInvalidWire
+		}
+		if err := visit(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// coordinator runs the control servers on the peer of rank 0, which coordinates the job.
+// All other peers are warm standbys: they replicate the control state at step boundaries,
+// and the lowest rank of the surviving peers takes over with it if the coordinator fails.
+type coordinator struct {
+	sync.Mutex
+	stop   []func() // of the running servers, nil if this peer is not the coordinator
+	failed plan.PeerList
+}
+
+func (c *coordinator) stopServers() {
+	for _, stop := range c.stop {
+		stop()
+	}
+	c.stop = nil
+}
+
+func hasControlServer() bool {
+	return config.ControlPort > 0 || len(config.ControlSock) > 0 || config.GRPCControlPort > 0
+}
+
+// electCoordinator starts the control servers if this peer is rank 0 of the current session,
+// and stops them if it was the coordinator and isn't anymore.
+func (p *Peer) electCoordinator() {
+	if p.single || !hasControlServer() {
+		return
+	}
+	sess := p.CurrentSession()
+	c := p.coordinator
+	c.Lock()
+	defer c.Unlock()
+	if sess.Rank() != 0 {
+		if c.stop != nil {
+			log.Infof("%s is no longer the coordinator since v%d, stopping the control servers", p.self, p.clusterVersion)
+			c.stopServers()
+		}
+		return
+	}
+	if c.stop != nil {
+		return
+	}
+	if p.clusterVersion > p.initClusterVersion {
+		log.Warnf("%s took over as the coordinator in v%d with the replicated state: %+v", p.self, p.clusterVersion, p.controller.progress())
+	}
+	if config.ControlPort > 0 || len(config.ControlSock) > 0 {
+		c.stop = append(c.stop, p.startControlServer(config.ControlPort, config.ControlSock))
+	}
+	if config.GRPCControlPort > 0 {
+		c.stop = append(c.stop, p.startGRPCControlServer(config.GRPCControlPort))
+	}
+}
+
+// resignCoordinator stops the control servers if this peer was the coordinator, when it's detached from the cluster.
+func (p *Peer) resignCoordinator() {
+	c := p.coordinator
+	c.Lock()
+	defer c.Unlock()
+	if c.stop != nil {
+		log.Infof("%s is detached, stopping the control servers", p.self)
+		c.stopServers()
+	}
+}
+
+// broadcastControl broadcasts the control state from the coordinator. In resilience mode, if it fails or doesn't finish within
+// config.ResilienceTimeout, it returns a *session.PeerFailedError of the peers that the surviving peers agreed have failed.
+func broadcastControl(sess *session.Session, w base.Workspace) error {
+	if !config.EnableResilience {
+		return sess.Broadcast(w)
+	}
+	done := make(chan error, 1)
+	go func() { done <- sess.Broadcast(w) }()
+	var err error
+	select {
+	case err = <-done:
+		if err == nil {
+			return nil
+		}
+	case <-time.After(config.ResilienceTimeout):
+		err = fmt.Errorf("control broadcast timeout after %s", config.ResilienceTimeout)
+	}
+	failed, ferr := sess.FailedPeers()
+	if ferr != nil {
+		return fmt.Errorf("%v, and %v", err, ferr)
+	}
+	if failed == nil {
+		return err
+	}
+	return failed
+}
+
+// failover resizes the cluster without the failed peers, which the surviving peers have agreed on,
+// so that the lowest surviving rank becomes the coordinator if rank 0 failed, with the replicated control state.
+// The surviving peers agree on the new cluster among themselves, within config.ResilienceTimeout, as the consensus of
+// the session can't finish without the failed peers. If it times out, e.g. as more peers failed meanwhile, and
+// config.PartitionPolicy is set, they probe for a quorum without pinging the failed peers again, see agreedFailed.
+// The control requests that a failed coordinator accepted since the last step boundary are lost.
+func (p *Peer) failover(err error) (bool, bool, error) {
+	var failed *session.PeerFailedError
+	if !errors.As(err, &failed) {
+		return false, true, err
+	}
+	log.Warnf("%v, resizing the cluster without them", failed)
+	p.coordinator.Lock()
+	p.coordinator.failed = failed.Peers
+	p.coordinator.Unlock()
+	cluster := p.getCurrentCluster()
+	cluster.Workers, _ = cluster.Workers.Diff(failed.Peers)
+	sub, serr := p.CurrentSession().Subset("failover", cluster.Workers)
+	if serr != nil {
+		return false, true, fmt.Errorf("%w, and %w", err, serr)
+	}
+	consensus := func(bs []byte) (bool, error) { return sub.BytesConsensusWithin(bs, "", config.ResilienceTimeout) }
+	return p.resizeWith(cluster, consensus)
+}
+
+// agreedFailed returns the workers of the current cluster that the surviving peers have agreed as failed,
+// they are not pinged again as they may hang rather than refuse connections.
+func (p *Peer) agreedFailed(workers plan.PeerList) plan.PeerList {
+	p.coordinator.Lock()
+	defer p.coordinator.Unlock()
+	return workers.Intersection(p.coordinator.failed)
+}
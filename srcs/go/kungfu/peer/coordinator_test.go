@@ -0,0 +1,99 @@
+package peer_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/peer"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/tests/go/testutils"
+)
+
+func Test_failover(t *testing.T) {
+	defer func(on bool, d, p time.Duration, sock string) {
+		config.EnableResilience, config.ResilienceTimeout, config.PartitionTimeout, config.ControlSock = on, d, p, sock
+	}(config.EnableResilience, config.ResilienceTimeout, config.PartitionTimeout, config.ControlSock)
+	config.EnableResilience = true
+	config.ResilienceTimeout = 500 * time.Millisecond
+	config.PartitionTimeout = 200 * time.Millisecond
+	config.ControlSock = filepath.Join(t.TempDir(), "control.sock")
+	c, err := testutils.StartCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, p := range c.Peers {
+		p.StepBoundary(0) // elects the coordinator
+	}
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", config.ControlSock)
+		},
+	}}
+	for _, path := range []string{"/strategies/suspend?name=none", "/strategies/install"} {
+		body := strings.NewReader(`{"name":"PAIR","forest":[0,0]}`)
+		resp, err := hc.Post("http://control"+path, "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	stepBoundary := func(peers []*peer.Peer, step int) {
+		var wg sync.WaitGroup
+		for i, p := range peers {
+			wg.Add(1)
+			go func(i int, p *peer.Peer) {
+				defer wg.Done()
+				if _, keep, err := p.StepBoundary(step); err != nil || !keep {
+					t.Errorf("step boundary %d of peer %d: %v, keep: %t", step, i, err, keep)
+				}
+			}(i, p)
+		}
+		wg.Wait()
+	}
+	stepBoundary(c.Peers, 1) // applies the first command, and replicates the second at the standbys
+	c.Peers[0].Close()       // the coordinator fails
+	survivors := c.Peers[1:]
+	stepBoundary(survivors, 2)
+	if t.Failed() {
+		t.FailNow()
+	}
+	for i, p := range survivors {
+		if n := p.CurrentSession().Size(); n != 2 {
+			t.Fatalf("session of %d peers at peer %d after failover, want 2", n, i+1)
+		}
+	}
+	stepBoundary(survivors, 3) // applies the replicated command
+	stepBoundary(survivors, 4) // from which the installed strategy is used
+	resp, err := hc.Get("http://control/strategies")
+	if err != nil {
+		t.Fatalf("control server of the new coordinator: %v", err)
+	}
+	defer resp.Body.Close()
+	var strategies []session.StrategyInfo
+	if err := json.NewDecoder(resp.Body).Decode(&strategies); err != nil {
+		t.Fatal(err)
+	}
+	if !hasStrategy(strategies, "PAIR") {
+		t.Errorf("command pending at the failed coordinator is not applied: %v", fmt.Sprint(strategies))
+	}
+}
+
+func hasStrategy(strategies []session.StrategyInfo, name string) bool {
+	for _, s := range strategies {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package peer
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+)
+
+const (
+	graphWatchTimeout = 10 * time.Second // sent bytes are gathered at step boundaries while a graph was requested this recently
+	graphStreamPeriod = 1 * time.Second
+)
+
+// linkGraph is the graphs of the active global strategies with the utilization of their links, in the nodes and links format
+// of force directed graph layouts, e.g. d3-force, where nodes are peers and links are the edges of graphs with their rates.
+type linkGraph struct {
+	Nodes   []session.PeerInfo `json:"nodes"`
+	Links   []graphLink        `json:"links"`
+	Updated time.Time          `json:"updated"` // when the sent bytes were last gathered
+}
+
+type graphLink struct {
+	session.GraphLink
+	Bytes int64   `json:"bytes"` // sent from source to target by all collectives
+	Rate  float64 `json:"rate"`  // bytes per second between the last two step boundaries that gathered the sent bytes
+}
+
+// linkRates holds the bytes sent between peers, gathered by all peers at step boundaries while a graph is watched at the
+// coordinator, so that a standby takes over with them.
+type linkRates struct {
+	sync.Mutex
+	watched time.Time
+	sent    []int64 // of the ranks of senders and receivers
+	rates   []float64
+	updated time.Time
+}
+
+func (l *linkRates) watch() {
+	l.Lock()
+	defer l.Unlock()
+	l.watched = time.Now()
+}
+
+func (l *linkRates) isWatched() bool {
+	l.Lock()
+	defer l.Unlock()
+	return time.Since(l.watched) < graphWatchTimeout
+}
+
+// record updates the rates by the bytes sent since the last call, which are unknown after the cluster changed.
+func (l *linkRates) record(sent []int64) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	rates := make([]float64, len(sent))
+	if len(l.sent) == len(sent) {
+		if d := now.Sub(l.updated).Seconds(); d > 0 {
+			for i := range sent {
+				rates[i] = float64(sent[i]-l.sent[i]) / d
+			}
+		}
+	}
+	l.sent, l.rates, l.updated = sent, rates, now
+}
+
+func (l *linkRates) graph(sess *session.Session) linkGraph {
+	g := linkGraph{Nodes: sess.Peers()}
+	np := len(g.Nodes)
+	l.Lock()
+	defer l.Unlock()
+	g.Updated = l.updated
+	for _, e := range sess.GraphLinks() {
+		link := graphLink{GraphLink: e}
+		if i := e.Source*np + e.Target; len(l.sent) == np*np {
+			link.Bytes = l.sent[i]
+			link.Rate = l.rates[i]
+		}
+		g.Links = append(g.Links, link)
+	}
+	return g
+}
+
+// serveGraph writes the current graph, or streams a graph per line every graphStreamPeriod if stream is set,
+// until the client disconnects.
+func (c *controller) serveGraph(w http.ResponseWriter, req *http.Request) {
+	c.links.watch()
+	if len(req.FormValue("stream")) == 0 {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "    ")
+		e.Encode(c.links.graph(c.session()))
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	e := json.NewEncoder(w)
+	t := time.NewTicker(graphStreamPeriod)
+	defer t.Stop()
+	for {
+		c.links.watch()
+		if err := e.Encode(c.links.graph(c.session())); err != nil {
+			return
+		}
+		f.Flush()
+		select {
+		case <-req.Context().Done():
+			return
+		case <-t.C:
+		}
+	}
+}
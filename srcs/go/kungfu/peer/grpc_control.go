@@ -0,0 +1,168 @@
+//go:build grpc
+// +build grpc
+
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/peer/controlpb"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodec encodes controlpb messages in the protobuf wire format.
+type grpcCodec struct{}
+
+func (grpcCodec) Name() string { return "proto" }
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(controlpb.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Marshal()
+}
+
+func (grpcCodec) Unmarshal(bs []byte, v interface{}) error {
+	m, ok := v.(controlpb.Message)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	return m.Unmarshal(bs)
+}
+
+// controlService implements the Control service of control.proto.
+type controlService struct {
+	p          *Peer
+	rendezvous *rendezvous
+}
+
+func (s *controlService) Bootstrap(ctx context.Context, _ *controlpb.Empty) (*controlpb.Cluster, error) {
+	s.p.Lock()
+	cluster, version := s.p.currentCluster.Clone(), s.p.clusterVersion
+	s.p.Unlock()
+	c := &controlpb.Cluster{Version: int32(version)}
+	for _, w := range cluster.Workers {
+		c.Workers = append(c.Workers, w.String())
+	}
+	for _, r := range cluster.Runners {
+		c.Runners = append(c.Runners, r.String())
+	}
+	return c, nil
+}
+
+func (s *controlService) Pause(ctx context.Context, _ *controlpb.Empty) (*controlpb.Empty, error) {
+	s.p.controller.update(func() { s.p.controller.paused = true })
+	return &controlpb.Empty{}, nil
+}
+
+func (s *controlService) Resume(ctx context.Context, _ *controlpb.Empty) (*controlpb.Empty, error) {
+	s.p.controller.update(func() { s.p.controller.paused = false })
+	return &controlpb.Empty{}, nil
+}
+
+func (s *controlService) Resize(ctx context.Context, req *controlpb.ResizeRequest) (*controlpb.Empty, error) {
+	if req.Size <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid size: %d", req.Size)
+	}
+	s.p.controller.update(func() { s.p.controller.targetSize = int(req.Size) })
+	return &controlpb.Empty{}, nil
+}
+
+func (s *controlService) GetProgress(ctx context.Context, _ *controlpb.Empty) (*controlpb.Progress, error) {
+	p := s.p.controller.progress()
+	return &controlpb.Progress{
+		Step:            int64(p.Step),
+		Size:            int32(p.Size),
+		Version:         int32(p.Version),
+		Paused:          p.Paused,
+		TargetSize:      int32(p.TargetSize),
+		UpdatedUnixNano: p.Updated.UnixNano(),
+	}, nil
+}
+
+func (s *controlService) Barrier(ctx context.Context, req *controlpb.BarrierRequest) (*controlpb.Empty, error) {
+	if _, err := s.rendezvous.join(ctx, "barrier:"+req.Name, int(req.Parties), nil); err != nil {
+		return nil, rendezvousStatus(err)
+	}
+	return &controlpb.Empty{}, nil
+}
+
+func (s *controlService) Consensus(ctx context.Context, req *controlpb.ConsensusRequest) (*controlpb.ConsensusResponse, error) {
+	ok, err := s.rendezvous.join(ctx, "consensus:"+req.Name, int(req.Parties), req.Value)
+	if err != nil {
+		return nil, rendezvousStatus(err)
+	}
+	return &controlpb.ConsensusResponse{OK: ok}, nil
+}
+
+func rendezvousStatus(err error) error {
+	if errors.Is(err, errInvalidParties) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.FromContextError(err).Err()
+}
+
+func unaryHandler(newReq func() controlpb.Message, call func(*controlService, context.Context, controlpb.Message) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newReq()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		return call(srv.(*controlService), ctx, req)
+	}
+}
+
+var controlServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kungfu.control.v1.Control",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Bootstrap", Handler: unaryHandler(func() controlpb.Message { return &controlpb.Empty{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Bootstrap(ctx, req.(*controlpb.Empty))
+		})},
+		{MethodName: "Pause", Handler: unaryHandler(func() controlpb.Message { return &controlpb.Empty{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Pause(ctx, req.(*controlpb.Empty))
+		})},
+		{MethodName: "Resume", Handler: unaryHandler(func() controlpb.Message { return &controlpb.Empty{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Resume(ctx, req.(*controlpb.Empty))
+		})},
+		{MethodName: "Resize", Handler: unaryHandler(func() controlpb.Message { return &controlpb.ResizeRequest{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Resize(ctx, req.(*controlpb.ResizeRequest))
+		})},
+		{MethodName: "GetProgress", Handler: unaryHandler(func() controlpb.Message { return &controlpb.Empty{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.GetProgress(ctx, req.(*controlpb.Empty))
+		})},
+		{MethodName: "Barrier", Handler: unaryHandler(func() controlpb.Message { return &controlpb.BarrierRequest{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Barrier(ctx, req.(*controlpb.BarrierRequest))
+		})},
+		{MethodName: "Consensus", Handler: unaryHandler(func() controlpb.Message { return &controlpb.ConsensusRequest{} }, func(s *controlService, ctx context.Context, req controlpb.Message) (interface{}, error) {
+			return s.Consensus(ctx, req.(*controlpb.ConsensusRequest))
+		})},
+	},
+	Metadata: "kungfu/control/v1/control.proto",
+}
+
+func (p *Peer) startGRPCControlServer(port int) func() {
+	addr := net.JoinHostPort("", strconv.Itoa(port))
+	s := grpc.NewServer(grpc.ForceServerCodec(grpcCodec{}))
+	s.RegisterService(&controlServiceDesc, &controlService{p: p, rendezvous: newRendezvous()})
+	log.Infof("gRPC control server: %s", addr)
+	go func() {
+		lis, err := listenControl(addr)
+		if err != nil {
+			log.Errorf("failed to start gRPC control server: %v", err)
+			return
+		}
+		if err := s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+			log.Errorf("gRPC control server stopped: %v", err)
+		}
+	}()
+	return s.Stop
+}
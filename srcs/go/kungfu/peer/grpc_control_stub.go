@@ -0,0 +1,11 @@
+//go:build !grpc
+// +build !grpc
+
+package peer
+
+import "github.com/lsds/KungFu/srcs/go/log"
+
+func (p *Peer) startGRPCControlServer(port int) func() {
+	log.Warnf("gRPC control server is not available, rebuild with -tags grpc")
+	return func() {}
+}
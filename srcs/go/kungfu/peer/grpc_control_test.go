@@ -0,0 +1,92 @@
+//go:build grpc
+// +build grpc
+
+package peer_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/peer/controlpb"
+	"github.com/lsds/KungFu/tests/go/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type codec struct{}
+
+func (codec) Name() string { return "proto" }
+
+func (codec) Marshal(v interface{}) ([]byte, error) { return v.(controlpb.Message).Marshal() }
+
+func (codec) Unmarshal(bs []byte, v interface{}) error { return v.(controlpb.Message).Unmarshal(bs) }
+
+func Test_grpcControl(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	config.GRPCControlPort = port
+	defer func() { config.GRPCControlPort = 0 }()
+
+	c, err := testutils.StartCluster(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	call := func(method string, req, resp controlpb.Message) error {
+		return conn.Invoke(ctx, "/kungfu.control.v1.Control/"+method, req, resp)
+	}
+
+	var cluster controlpb.Cluster
+	if err := call("Bootstrap", &controlpb.Empty{}, &cluster); err != nil {
+		t.Fatal(err)
+	}
+	if len(cluster.Workers) != 2 {
+		t.Errorf("Bootstrap returned %d workers, want 2", len(cluster.Workers))
+	}
+	if err := call("Resize", &controlpb.ResizeRequest{Size: 1}, &controlpb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	var progress controlpb.Progress
+	if err := call("GetProgress", &controlpb.Empty{}, &progress); err != nil {
+		t.Fatal(err)
+	}
+	if progress.TargetSize != 1 {
+		t.Errorf("target size is %d after Resize to 1", progress.TargetSize)
+	}
+
+	resps := make([]controlpb.ConsensusResponse, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range resps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = call("Consensus", &controlpb.ConsensusRequest{Name: "v", Parties: 2, Value: []byte("x")}, &resps[i])
+		}(i)
+	}
+	wg.Wait()
+	for i := range resps {
+		if errs[i] != nil || !resps[i].OK {
+			t.Errorf("Consensus: %v, ok: %v", errs[i], resps[i].OK)
+		}
+	}
+}
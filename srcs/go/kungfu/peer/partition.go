@@ -0,0 +1,212 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// Partition policies, of peers whose consensus on changing the current cluster failed, none by default so that they exit.
+const (
+	PartitionHalt     = `halt`     // peers stop, so that no part of a partitioned cluster changes it
+	PartitionMajority = `majority` // peers reaching a majority of the cluster continue without the others, and the others stop
+)
+
+var errNoQuorum = failure.New(failure.PeerCrash, "no quorum of the cluster") // the peers of this partition are unreachable by the others
+
+// A consensusFunc returns whether the workers agree on bs, or an error if the consensus failed.
+type consensusFunc func(bs []byte) (bool, error)
+
+// agree returns whether the workers agree on cluster by consensus. If the consensus fails and config.PartitionPolicy
+// is set, it probes the workers and runs the consensus among a quorum of them instead, see quorum, so that peers
+// are pinged only after a failure. It returns the consensus to use for the rest of the change, and the cluster without
+// the workers left out by the policy. It returns an error if the consensus fails without a policy, or there is no quorum.
+func (p *Peer) agree(consensus consensusFunc, cluster plan.Cluster) (consensusFunc, plan.Cluster, bool, error) {
+	ok, err := consensus(cluster.Bytes())
+	if err == nil {
+		return consensus, cluster, ok, nil
+	}
+	if len(config.PartitionPolicy) == 0 {
+		return nil, cluster, false, err
+	}
+	log.Warnf("consensus failed: %v, probing the workers by the %s policy", err, config.PartitionPolicy)
+	if cluster, consensus, err = p.quorum(cluster); err != nil {
+		return nil, cluster, false, err
+	}
+	if ok, err = consensus(cluster.Bytes()); err != nil {
+		return nil, cluster, false, err
+	}
+	return consensus, cluster, ok, nil
+}
+
+// unreachable returns the peers of pl not answering pings within config.PartitionTimeout.
+func (p *Peer) unreachable(pl plan.PeerList) plan.PeerList {
+	down := make([]bool, len(pl))
+	var wg sync.WaitGroup
+	for i, id := range pl {
+		if id == p.self {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, id plan.PeerID) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.TODO(), config.PartitionTimeout)
+			defer cancel()
+			if _, err := p.router.Wait(ctx, id); err != nil {
+				down[i] = true
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	var ids plan.PeerList
+	for i, id := range pl {
+		if down[i] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// floodRounds is the number of rounds of exchanging the unreachable workers, in which all peers of a majority agree
+// on them, as long as fewer than half of the workers fail during the exchange.
+func floodRounds(workers int) int {
+	return workers/2 + 1
+}
+
+// mergeDown returns the workers taken as unreachable by rank after a round of exchange: those it took as unreachable,
+// those taken as unreachable by any worker it received from, and those it didn't receive from.
+// views[j] is the unreachable workers of j, or nil if rank didn't receive it within the round.
+func mergeDown(rank int, down []bool, views [][]bool) []bool {
+	merged := append([]bool(nil), down...)
+	for j, view := range views {
+		if j == rank || down[j] {
+			continue
+		}
+		if view == nil {
+			merged[j] = true
+			continue
+		}
+		for k, d := range view {
+			merged[k] = merged[k] || d
+		}
+	}
+	return merged
+}
+
+// exchangeDown sends down to the workers not in it, and receives theirs within config.PartitionTimeout.
+func (p *Peer) exchangeDown(workers plan.PeerList, rank, round int, down []bool) [][]bool {
+	name := fmt.Sprintf("kungfu::quorum:%d:%d", p.router.Collective.Version(), round)
+	bs := make([]byte, len(down))
+	for i, d := range down {
+		if d {
+			bs[i] = 1
+		}
+	}
+	cancel := make(chan struct{})
+	t := time.AfterFunc(config.PartitionTimeout, func() { close(cancel) })
+	defer t.Stop()
+	views := make([][]bool, len(workers))
+	var wg sync.WaitGroup
+	for j, id := range workers {
+		if j == rank || down[j] {
+			continue
+		}
+		go func(id plan.PeerID) { // not waited, as the sends to unreachable workers may be retried
+			if err := p.router.Send(id.WithName(name), bs, connection.ConnCollective, connection.NoFlag); err != nil {
+				log.Debugf("sending the unreachable workers to %s failed: %v", id, err)
+			}
+		}(id)
+		wg.Add(1)
+		go func(j int, id plan.PeerID) {
+			defer wg.Done()
+			m, err := p.router.Collective.RecvCancel(id.WithName(name), cancel)
+			if err != nil || len(m.Data) != len(workers) {
+				return
+			}
+			view := make([]bool, len(workers))
+			for k, b := range m.Data {
+				view[k] = b != 0
+			}
+			views[j] = view
+		}(j, id)
+	}
+	wg.Wait()
+	return views
+}
+
+// agreeDown returns the workers unreachable by any peer that this peer can reach, which all peers of a majority
+// agree on by exchanging them over floodRounds rounds, so that a majority continues without the same workers
+// even if the reachability of the workers is asymmetric. It includes this peer if others can't reach it.
+func (p *Peer) agreeDown(workers, failed plan.PeerList) plan.PeerList {
+	rank, _ := workers.Rank(p.self)
+	down := make([]bool, len(workers))
+	for i, id := range workers {
+		down[i] = failed.Contains(id)
+	}
+	alive, _ := workers.Diff(failed)
+	for _, id := range p.unreachable(alive) {
+		i, _ := workers.Rank(id)
+		down[i] = true
+	}
+	for round := 0; round < floodRounds(len(workers)); round++ {
+		down = mergeDown(rank, down, p.exchangeDown(workers, rank, round, down))
+	}
+	var ids plan.PeerList
+	for i, d := range down {
+		if d {
+			ids = append(ids, workers[i])
+		}
+	}
+	return ids
+}
+
+// quorum checks that this peer can change the current cluster to cluster, which requires a quorum of the current workers,
+// so that the parts of a partitioned cluster never change it independently and train divergent models.
+// It returns the cluster to change to, without the unreachable peers if they are left out by PartitionMajority,
+// and the consensus among the reachable workers, which fails after config.PartitionTimeout.
+// It returns errNoQuorum if this peer must stop.
+func (p *Peer) quorum(cluster plan.Cluster) (plan.Cluster, consensusFunc, error) {
+	old := p.getCurrentCluster()
+	failed := p.agreedFailed(old.Workers)
+	down := p.agreeDown(old.Workers, failed)
+	if len(down) == 0 {
+		return cluster, p.consensus, nil
+	}
+	up, _ := old.Workers.Diff(down)
+	log.Warnf("partition detected: %d of %d workers unreachable: %s", len(down), len(old.Workers), down)
+	switch {
+	case down.Contains(p.self):
+		return cluster, nil, fmt.Errorf("%w: unreachable by the other workers", errNoQuorum)
+	case len(failed) == len(down):
+		log.Warnf("the unreachable workers were agreed as failed in resilience mode")
+	case config.PartitionPolicy == PartitionHalt:
+		return cluster, nil, fmt.Errorf("%w: %d of %d workers unreachable, halting by the %s policy", errNoQuorum, len(down), len(old.Workers), PartitionHalt)
+	case config.PartitionPolicy == PartitionMajority:
+		if 2*len(up) <= len(old.Workers) {
+			return cluster, nil, fmt.Errorf("%w: %d of %d workers reachable, not a majority", errNoQuorum, len(up), len(old.Workers))
+		}
+	default:
+		return cluster, nil, fmt.Errorf("%w: invalid partition policy %q", errNoQuorum, config.PartitionPolicy)
+	}
+	sess, err := p.CurrentSession().Subset("quorum", up)
+	if err != nil {
+		return cluster, nil, err
+	}
+	consensus := func(bs []byte) (bool, error) { return sess.BytesConsensusWithin(bs, "", config.PartitionTimeout) }
+	if ok, err := consensus(down.Bytes()); err != nil {
+		return cluster, nil, fmt.Errorf("%w: %w", errNoQuorum, err)
+	} else if !ok {
+		return cluster, nil, fmt.Errorf("%w: peers disagree on the unreachable workers", errNoQuorum)
+	}
+	cluster.Workers, _ = cluster.Workers.Diff(down)
+	cluster.Runners, _ = cluster.Runners.Diff(p.unreachable(cluster.Runners))
+	log.Warnf("continuing with %d workers by the %s policy", len(cluster.Workers), PartitionMajority)
+	return cluster, consensus, nil
+}
@@ -0,0 +1,91 @@
+package peer
+
+import (
+	"reflect"
+	"testing"
+)
+
+// floodDown runs the exchange of the unreachable workers in lockstep, j receives from i if reach[i][j].
+func floodDown(reach [][]bool) [][]bool {
+	n := len(reach)
+	downs := make([][]bool, n)
+	for i := range downs {
+		downs[i] = make([]bool, n)
+		for j := range downs[i] {
+			downs[i][j] = i != j && !(reach[i][j] && reach[j][i]) // as the pings
+		}
+	}
+	for round := 0; round < floodRounds(n); round++ {
+		next := make([][]bool, n)
+		for j := range downs {
+			views := make([][]bool, n)
+			for i := range downs {
+				if i != j && !downs[i][j] && reach[i][j] {
+					views[i] = downs[i]
+				}
+			}
+			next[j] = mergeDown(j, downs[j], views)
+		}
+		downs = next
+	}
+	return downs
+}
+
+func Test_agreeDownPartition(t *testing.T) {
+	full := func(n int) [][]bool {
+		reach := make([][]bool, n)
+		for i := range reach {
+			reach[i] = make([]bool, n)
+			for j := range reach[i] {
+				reach[i][j] = true
+			}
+		}
+		return reach
+	}
+	{
+		reach := full(5) // 0 and 4 can't reach each other, the others agree without both
+		reach[0][4], reach[4][0] = false, false
+		downs := floodDown(reach)
+		want := []bool{true, false, false, false, true}
+		for i := 1; i < 4; i++ {
+			if !reflect.DeepEqual(downs[i], want) {
+				t.Errorf("unreachable workers of %d: %v, want %v", i, downs[i], want)
+			}
+		}
+		for _, i := range []int{0, 4} {
+			if up := 5 - count(downs[i]); !downs[i][i] && 2*up > 5 {
+				t.Errorf("%d doesn't stop: %v", i, downs[i])
+			}
+		}
+	}
+	{
+		reach := full(5) // 3 and 4 are partitioned from 0, 1 and 2
+		for _, i := range []int{0, 1, 2} {
+			for _, j := range []int{3, 4} {
+				reach[i][j], reach[j][i] = false, false
+			}
+		}
+		downs := floodDown(reach)
+		want := []bool{false, false, false, true, true}
+		for i := 0; i < 3; i++ {
+			if !reflect.DeepEqual(downs[i], want) {
+				t.Errorf("unreachable workers of %d: %v, want %v", i, downs[i], want)
+			}
+		}
+		for _, i := range []int{3, 4} {
+			if up := 5 - count(downs[i]); 2*up > 5 {
+				t.Errorf("the minority %d reaches a majority: %v", i, downs[i])
+			}
+		}
+	}
+}
+
+func count(bs []bool) int {
+	var n int
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
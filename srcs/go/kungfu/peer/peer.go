@@ -5,13 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/base"
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/daemon"
 	"github.com/lsds/KungFu/srcs/go/kungfu/env"
 	"github.com/lsds/KungFu/srcs/go/kungfu/execution"
 	"github.com/lsds/KungFu/srcs/go/kungfu/runner"
@@ -19,6 +23,8 @@ import (
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/monitor"
 	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/platforms/cloud"
+	"github.com/lsds/KungFu/srcs/go/platforms/hwinfo"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 	"github.com/lsds/KungFu/srcs/go/rchannel/server"
 	"github.com/lsds/KungFu/srcs/go/utils"
@@ -36,11 +42,23 @@ type Peer struct {
 	single             bool
 	router             *router
 	server             server.Server
+	daemon             *daemon.Client // nil if not attached to a daemon
+	preemption         *preemption
+	stopReport         chan struct{}
+	reportDone         chan struct{}
 	httpClient         http.Client
+	labels             plan.Labels
+	groups             plan.Groups
+	capability         plan.Capability
+	locality           plan.Locality
+	controller         *controller
+	coordinator        *coordinator
+	stepCounters       *stepCounters
 
 	// dynamic
 	clusterVersion int
 	currentSession *session.Session
+	existing       atomic.Pointer[session.Session] // currentSession, for the handlers that must not wait on the update holding the lock
 	currentCluster *plan.Cluster
 	updated        bool
 
@@ -56,8 +74,22 @@ func New() (*Peer, error) {
 }
 
 func NewFromConfig(cfg *env.Config) (*Peer, error) {
-	router := NewRouter(cfg.Self)
-	server := server.New(cfg.Self, router, config.UseUnixSock)
+	transport := cfg.Transport
+	if len(transport) == 0 {
+		transport = config.Transport
+	}
+	router := NewRouter(cfg.Self, transport)
+	var listen func() (net.Listener, error)
+	var d *daemon.Client
+	if len(config.DaemonSock) > 0 && !cfg.Single {
+		job := config.Job
+		if len(job) == 0 {
+			job = cfg.Parent.String() // the runner is unique on the host
+		}
+		d = daemon.Attach(config.DaemonSock, job, config.JobPriority)
+		listen = func() (net.Listener, error) { return d.Listener(cfg.Self.Port) }
+	}
+	server := server.NewWithListen(cfg.Self, router, listen, config.UseUnixSock, transport)
 	var initClusterVersion int
 	if len(cfg.InitClusterVersion) > 0 {
 		var err error
@@ -70,18 +102,152 @@ func NewFromConfig(cfg *env.Config) (*Peer, error) {
 		Runners: cfg.InitRunners,
 		Workers: cfg.InitPeers,
 	}
-	return &Peer{
+	p := &Peer{
 		configServerURL:    cfg.ConfigServer,
 		parent:             cfg.Parent,
 		currentCluster:     initCluster,
 		self:               cfg.Self,
 		strategy:           cfg.Strategy,
+		groups:             cfg.Groups,
 		initClusterVersion: initClusterVersion,
 		clusterVersion:     initClusterVersion,
 		single:             cfg.Single,
 		router:             router,
 		server:             server,
-	}, nil
+		daemon:             d,
+		preemption:         newPreemption(),
+		controller:         newController(),
+		coordinator:        &coordinator{},
+		stepCounters:       &stepCounters{steps: make(map[plan.PeerID]int64)},
+	}
+	router.ctrlHandler.Register(session.TopologyMessageName, p.handleTopologyMessage)
+	return p, nil
+}
+
+// handleTopologyMessage passes a topology event reported by another peer to the current session.
+func (p *Peer) handleTopologyMessage(data []byte) {
+	e, err := session.ParseTopologyEvent(data)
+	if err != nil {
+		log.Errorf("invalid topology message: %v", err)
+		return
+	}
+	if sess := p.existingSession(); sess != nil {
+		sess.HandleTopologyEvent(*e)
+	}
+}
+
+// existingSession returns the current session without creating one, or nil.
+// It doesn't take the lock, which Update holds while it waits on the other peers for the next session.
+func (p *Peer) existingSession() *session.Session {
+	return p.existing.Load()
+}
+
+// writeSignalCorrelations writes the correlations of the external signals of the current session to the monitoring endpoint.
+func (p *Peer) writeSignalCorrelations(w io.Writer) {
+	sess := p.existingSession()
+	if sess == nil {
+		return
+	}
+	var corrs, samples []monitor.Sample
+	for _, c := range sess.SignalCorrelations() {
+		l := fmt.Sprintf("{signal=%q,strategy=%q}", c.Signal, c.Strategy)
+		corrs = append(corrs, monitor.Sample{Labels: l, Value: c.Correlation})
+		samples = append(samples, monitor.Sample{Labels: l, Value: float64(c.Samples)})
+	}
+	monitor.WriteFamily(w, "signal_chunk_duration_correlation", monitor.Gauge, "Correlation of each external signal with the durations of chunks of each strategy.", corrs)
+	monitor.WriteFamily(w, "signal_chunk_duration_samples", monitor.Counter, "Samples of the correlation of each external signal with the durations of chunks.", samples)
+}
+
+// writeSessionUsages writes the communication of each session of the peer to the monitoring endpoint.
+func (p *Peer) writeSessionUsages(w io.Writer) {
+	sess := p.existingSession()
+	if sess == nil {
+		return
+	}
+	var messages, bytes, wire, queue, share []monitor.Sample
+	for _, u := range sess.SessionUsages() {
+		l := fmt.Sprintf("{session=%q}", u.Session)
+		messages = append(messages, monitor.Sample{Labels: l, Value: float64(u.Messages)})
+		bytes = append(bytes, monitor.Sample{Labels: l, Value: float64(u.Bytes)})
+		wire = append(wire, monitor.Sample{Labels: l, Value: u.Wire.Seconds()})
+		queue = append(queue, monitor.Sample{Labels: l, Value: u.Queue.Seconds()})
+		share = append(share, monitor.Sample{Labels: l, Value: u.QueueShare})
+	}
+	monitor.WriteFamily(w, "session_sent_messages", monitor.Counter, "Messages sent by each session of the peer.", messages)
+	monitor.WriteFamily(w, "session_sent_bytes", monitor.Counter, "Bytes sent by each session of the peer.", bytes)
+	monitor.WriteFamily(w, "session_wire_seconds", monitor.Counter, "Time of each session writing messages to connections.", wire)
+	monitor.WriteFamily(w, "session_queue_seconds", monitor.Counter, "Time of each session waiting for its QoS share and for the messages of other sessions.", queue)
+	monitor.WriteFamily(w, "session_queue_share", monitor.Gauge, "Fraction of the queueing delay of all sessions of the peer, of each session.", share)
+}
+
+// writeStrategyStats writes the communication of each global strategy of the peer to the monitoring endpoint.
+func (p *Peer) writeStrategyStats(w io.Writer) {
+	sess := p.existingSession()
+	if sess == nil {
+		return
+	}
+	var messages, bytes, seconds, bandwidth, errs []monitor.Sample
+	for _, ss := range sess.StrategyStats() {
+		l := fmt.Sprintf("{strategy=%q}", ss.Name)
+		messages = append(messages, monitor.Sample{Labels: l, Value: float64(ss.Messages)})
+		bytes = append(bytes, monitor.Sample{Labels: l, Value: float64(ss.Bytes)})
+		seconds = append(seconds, monitor.Sample{Labels: l, Value: ss.Duration.Seconds()})
+		bandwidth = append(bandwidth, monitor.Sample{Labels: l, Value: ss.Bandwidth})
+		errs = append(errs, monitor.Sample{Labels: l, Value: float64(ss.Errors)})
+	}
+	monitor.WriteFamily(w, "strategy_sent_messages", monitor.Counter, "Messages sent by the peer on each global strategy, estimated from the sampled chunks.", messages)
+	monitor.WriteFamily(w, "strategy_sent_bytes", monitor.Counter, "Bytes sent by the peer on each global strategy, estimated from the sampled chunks.", bytes)
+	monitor.WriteFamily(w, "strategy_chunk_seconds", monitor.Counter, "Total duration of the chunks of each global strategy.", seconds)
+	monitor.WriteFamily(w, "strategy_bandwidth", monitor.Gauge, "Bytes per second of the chunks of each global strategy.", bandwidth)
+	monitor.WriteFamily(w, "strategy_errors", monitor.Counter, "Chunks of each global strategy that failed.", errs)
+}
+
+// writeSessionMetrics writes the state of the global strategies, the collectives and the liveness of the other peers
+// of the current session to the monitoring endpoint.
+func (p *Peer) writeSessionMetrics(w io.Writer) {
+	sess := p.existingSession()
+	if sess == nil {
+		return
+	}
+	var suspended, broken, failures []monitor.Sample
+	for _, s := range sess.GlobalStrategies() {
+		l := fmt.Sprintf("{strategy=%q}", s.Name)
+		suspended = append(suspended, monitor.Sample{Labels: l, Value: boolMetric(s.Suspended)})
+		broken = append(broken, monitor.Sample{Labels: l, Value: boolMetric(s.Broken)})
+		failures = append(failures, monitor.Sample{Labels: l, Value: float64(s.Failures)})
+	}
+	monitor.WriteFamily(w, "strategy_suspended", monitor.Gauge, "Whether each global strategy is suspended.", suspended)
+	monitor.WriteFamily(w, "strategy_broken", monitor.Gauge, "Whether each global strategy uses a link of an open circuit breaker.", broken)
+	monitor.WriteFamily(w, "strategy_failures", monitor.Counter, "Chunks of each global strategy that timed out and were retried on another strategy.", failures)
+	var avgs []monitor.Sample
+	for _, ss := range sess.StrategyStats() {
+		var avg float64
+		if ss.Chunks > 0 {
+			avg = ss.Duration.Seconds() / float64(ss.Chunks)
+		}
+		avgs = append(avgs, monitor.Sample{Labels: fmt.Sprintf("{strategy=%q}", ss.Name), Value: avg})
+	}
+	monitor.WriteFamily(w, "strategy_avg_chunk_seconds", monitor.Gauge, "Mean duration of the chunks of each global strategy.", avgs)
+	var counts, bytes []monitor.Sample
+	for _, c := range sess.CollectiveCounts() {
+		l := fmt.Sprintf("{kind=%q}", c.Kind)
+		counts = append(counts, monitor.Sample{Labels: l, Value: float64(c.Count)})
+		bytes = append(bytes, monitor.Sample{Labels: l, Value: float64(c.Bytes)})
+	}
+	monitor.WriteFamily(w, "collectives_total", monitor.Counter, "Collectives of each kind run by the peer.", counts)
+	monitor.WriteFamily(w, "collective_bytes_total", monitor.Counter, "Bytes of the collectives of each kind run by the peer.", bytes)
+	var up []monitor.Sample
+	for _, l := range sess.PeerLiveness() {
+		up = append(up, monitor.Sample{Labels: fmt.Sprintf("{peer=%q,rank=\"%d\"}", l.ID, l.Rank), Value: boolMetric(l.Up)})
+	}
+	monitor.WriteFamily(w, "peer_up", monitor.Gauge, "Whether no link between the peer and each peer of the session failed since it last succeeded.", up)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func (p *Peer) Start() error {
@@ -89,8 +255,18 @@ func (p *Peer) Start() error {
 		if err := p.server.Start(); err != nil {
 			return err
 		}
+		if config.UseLoopback {
+			connection.ServeLocal(p.self, connection.ConnCollective, p.router.Collective)
+		}
 		if config.EnableMonitoring {
 			monitoringPort := p.self.Port + 10000
+			if config.MonitoringPort > 0 {
+				monitoringPort = uint16(config.MonitoringPort)
+			}
+			monitor.AddReport(p.writeSignalCorrelations)
+			monitor.AddReport(p.writeSessionUsages)
+			monitor.AddReport(p.writeStrategyStats)
+			monitor.AddReport(p.writeSessionMetrics)
 			monitor.StartServer(int(monitoringPort))
 			monitorAddr := plan.NetAddr{
 				IPv4: p.self.IPv4, // FIXME: use pubAddr
@@ -98,8 +274,41 @@ func (p *Peer) Start() error {
 			}
 			log.Infof("Kungfu peer %s started, monitoring endpoint http://%s/metrics", p.self, monitorAddr)
 		}
+		if p.daemon != nil && (config.EnableCloudHints || config.EnableCapabilities) {
+			probe, err := p.daemon.Probe(config.EnableCloudHints)
+			if err != nil {
+				return err
+			}
+			if config.EnableCloudHints {
+				p.labels = cloudLabels(probe.Placement)
+			}
+			p.capability = probe.Capability
+		} else {
+			if config.EnableCloudHints {
+				p.labels = detectCloudLabels()
+			}
+			if config.EnableCapabilities {
+				p.capability = hwinfo.Detect()
+			}
+		}
+		if len(config.Rack) > 0 {
+			if p.labels == nil {
+				p.labels = make(plan.Labels)
+			}
+			p.labels[plan.LabelRack] = config.Rack
+		}
+		if config.EnableTopology {
+			p.locality = hwinfo.DetectLocality()
+			p.locality.Switch = config.Rack
+		}
+	}
+	if p.daemon != nil {
+		p.stopReport = make(chan struct{})
+		p.reportDone = make(chan struct{})
+		go p.reportToDaemon()
 	}
 	p.Update()
+	p.electCoordinator()
 	return nil
 }
 
@@ -108,11 +317,54 @@ func (p *Peer) Close() error {
 		if config.EnableMonitoring {
 			monitor.StopServer()
 		}
+		connection.StopLocal(p.self, connection.ConnCollective)
+		p.coordinator.Lock()
+		p.coordinator.stopServers()
+		p.coordinator.Unlock()
 		p.server.Close() // TODO: check error
+		p.Lock()
+		if p.currentSession != nil {
+			p.currentSession.ReleaseBuffers()
+		}
+		p.Unlock()
+		if p.daemon != nil {
+			close(p.stopReport)
+			<-p.reportDone
+		}
 	}
 	return nil
 }
 
+const daemonReportPeriod = 1 * time.Second
+
+// reportToDaemon reports the bytes sent by this peer to the daemon periodically, and applies the bandwidth share.
+// It detaches from the daemon when stopped.
+func (p *Peer) reportToDaemon() {
+	defer close(p.reportDone)
+	var last int64
+	ticker := time.NewTicker(daemonReportPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sent := p.router.client.SentBytes()
+			rate, target, err := p.daemon.Report(p.self.Port, sent-last)
+			if err != nil {
+				log.Warnf("failed to report to daemon: %v", err)
+				continue
+			}
+			last = sent
+			p.router.client.SetRateLimit(rate)
+			p.preemption.setTarget(target)
+		case <-p.stopReport:
+			if err := p.daemon.Detach(p.self.Port, p.router.client.SentBytes()-last); err != nil {
+				log.Warnf("failed to detach from daemon: %v", err)
+			}
+			return
+		}
+	}
+}
+
 func (p *Peer) Detached() bool {
 	return p.detached
 }
@@ -147,45 +399,166 @@ func (p *Peer) updateTo(pl plan.PeerList) bool {
 		defer utils.InstallStallDetector(name).Stop()
 	}
 	p.server.SetToken(uint32(p.clusterVersion))
+	p.server.SetMembers(plan.Cluster{Runners: p.currentCluster.Runners, Workers: pl}.Members())
+	connection.SetLocalToken(p.self, uint32(p.clusterVersion))
 	if p.updated {
 		log.Debugf("ignore update")
 		return true
 	}
 	log.Debugf("Kungfu::updateTo v%d of %d peers: %s", p.clusterVersion, len(pl), pl)
 	p.router.ResetConnections(pl, uint32(p.clusterVersion))
-	sess, exist := session.New(p.strategy, p.self, pl, p.router.client, p.router.Collective)
+	p.router.Collective.Renew(uint32(p.clusterVersion))
+	sess, exist := session.New(p.strategy, p.self, pl, p.groups, p.router.client, p.router.Collective)
 	if !exist {
 		return false
 	}
+	sess.SetLogger(log.With(log.F("session", p.clusterVersion)))
 	if err := sess.Barrier(); err != nil {
 		utils.ExitErr(fmt.Errorf("barrier failed after newSession: %v", err))
 	}
+	if old := p.currentSession; old != nil {
+		sess.SetProgress(old.Step(), old.Epoch())
+		sess.InheritChanges(old)
+		sess.InheritMonitorConfig(old)
+		sess.InheritPostHooks(old)
+		sess.InheritBuffers(old)
+		oldPeers := make(plan.PeerList, old.Size())
+		for i := range oldPeers {
+			oldPeers[i] = old.Peer(i)
+		}
+		var joined []int
+		for rank, peer := range pl {
+			if !oldPeers.Contains(peer) {
+				joined = append(joined, rank)
+			}
+		}
+		defer sess.HandleTopologyEvent(session.TopologyEvent{Kind: session.MembershipChanged, Joined: joined})
+	}
+	if !p.single {
+		if err := sess.CheckFingerprint(); err != nil {
+			utils.ExitErr(fmt.Errorf("CheckFingerprint failed after newSession: %v", err))
+		}
+		if err := sess.CheckStrategyHash(); err != nil {
+			utils.ExitErr(fmt.Errorf("CheckStrategyHash failed after newSession: %v", err))
+		}
+		if len(config.Codec) > 0 {
+			if err := sess.SetCodec(config.Codec); err != nil {
+				utils.ExitErr(fmt.Errorf("SetCodec failed after newSession: %v", err))
+			}
+		}
+		if len(config.SyncPeriods) > 0 {
+			if err := sess.SetSyncPeriods(config.SyncPeriods); err != nil {
+				utils.ExitErr(fmt.Errorf("SetSyncPeriods failed after newSession: %v", err))
+			}
+		}
+		if err := sess.SyncProgress(); err != nil {
+			utils.ExitErr(fmt.Errorf("SyncProgress failed after newSession: %v", err))
+		}
+		if err := sess.SyncChanges(); err != nil {
+			utils.ExitErr(fmt.Errorf("SyncChanges failed after newSession: %v", err))
+		}
+		if config.ChunkTuningInterval > 0 {
+			if err := sess.SyncChunkSizes(); err != nil {
+				utils.ExitErr(fmt.Errorf("SyncChunkSizes failed after newSession: %v", err))
+			}
+		}
+		if config.CodecSelectionInterval > 0 {
+			if err := sess.SyncCodecSizes(); err != nil {
+				utils.ExitErr(fmt.Errorf("SyncCodecSizes failed after newSession: %v", err))
+			}
+		}
+		if err := sess.SyncMonitorConfig(); err != nil {
+			utils.ExitErr(fmt.Errorf("SyncMonitorConfig failed after newSession: %v", err))
+		}
+		if err := sess.WarmStandby(); err != nil {
+			utils.ExitErr(fmt.Errorf("WarmStandby failed after newSession: %v", err))
+		}
+	}
+	if config.EnableCloudHints && !p.single {
+		if err := sess.SetZoneHints(p.labels[plan.LabelZone]); err != nil {
+			utils.ExitErr(fmt.Errorf("SetZoneHints failed after newSession: %v", err))
+		}
+	}
+	if len(config.Rack) > 0 && !p.single {
+		if err := sess.SetRackHints(p.labels[plan.LabelRack]); err != nil {
+			utils.ExitErr(fmt.Errorf("SetRackHints failed after newSession: %v", err))
+		}
+	}
+	if config.EnableCapabilities && !p.single {
+		if err := sess.SetCapabilities(p.capability); err != nil {
+			utils.ExitErr(fmt.Errorf("SetCapabilities failed after newSession: %v", err))
+		}
+	}
+	if config.EnableTopology && !p.single {
+		if err := sess.SetTopology(p.locality); err != nil {
+			utils.ExitErr(fmt.Errorf("SetTopology failed after newSession: %v", err))
+		}
+	}
+	if config.EnableRootSelection && !p.single {
+		if _, err := sess.SelectTreeRoot(); err != nil {
+			utils.ExitErr(fmt.Errorf("SelectTreeRoot failed after newSession: %v", err))
+		}
+	}
+	if config.EnableAutoTune && !p.single {
+		if err := sess.AutoTune(); err != nil {
+			utils.ExitErr(fmt.Errorf("AutoTune failed after newSession: %v", err))
+		}
+	}
 	p.currentSession = sess
+	p.existing.Store(sess)
 	p.updated = true
 	return true
 }
 
-func (p *Peer) consensus(bs []byte) bool {
-	sess := p.CurrentSession()
-	ok, err := sess.BytesConsensus(bs, "")
+func detectCloudLabels() plan.Labels {
+	placement, err := cloud.Detect(context.TODO())
 	if err != nil {
-		utils.ExitErr(err)
+		log.Warnf("failed to detect cloud placement: %v", err)
 	}
-	return ok
+	return cloudLabels(placement)
 }
 
-func (p *Peer) propose(cluster plan.Cluster) (bool, bool) {
+func cloudLabels(placement *cloud.Placement) plan.Labels {
+	labels := make(plan.Labels)
+	if placement == nil {
+		return labels
+	}
+	log.Debugf("detected cloud placement: %s zone %q group %q", placement.Provider, placement.Zone, placement.PlacementGroup)
+	labels[plan.LabelProvider] = placement.Provider
+	labels[plan.LabelZone] = placement.Zone
+	if len(placement.PlacementGroup) > 0 {
+		labels[plan.LabelPlacementGroup] = placement.PlacementGroup
+	}
+	return labels
+}
+
+// consensus is the consensus of the workers on changing the cluster. With a partition policy, it fails after
+// config.ResilienceTimeout, so that the workers are probed rather than waited on forever, see agree.
+func (p *Peer) consensus(bs []byte) (bool, error) {
+	sess := p.CurrentSession()
+	if len(config.PartitionPolicy) > 0 {
+		return sess.BytesConsensusWithin(bs, "", config.ResilienceTimeout)
+	}
+	return sess.BytesConsensus(bs, "")
+}
+
+// propose changes the cluster to cluster if the workers agree on it by consensus, see agree.
+func (p *Peer) propose(cluster plan.Cluster, consensus consensusFunc) (bool, bool, error) {
 	if config.EnableStallDetection {
 		name := fmt.Sprintf("propose(%s)", cluster.DebugString())
 		defer utils.InstallStallDetector(name).Stop()
 	}
 	if p.currentCluster.Eq(cluster) {
 		log.Debugf("ingore unchanged proposal")
-		return false, true
+		return false, true, nil
+	}
+	_, cluster, ok, err := p.agree(consensus, cluster)
+	if err != nil {
+		return false, true, err
 	}
-	if digest := cluster.Bytes(); !p.consensus(digest) {
+	if !ok {
 		log.Errorf("diverge proposal detected among %d peers! I proposed %s", len(cluster.Workers), cluster.Workers)
-		return false, true
+		return false, true, nil
 	}
 	{
 		stage := runner.Stage{
@@ -205,7 +578,7 @@ func (p *Peer) propose(cluster plan.Cluster) (bool, bool) {
 			return p.router.Send(ctrl.WithName("update"), stage.Encode(), connection.ConnControl, 0)
 		}
 		if err := notify.Par(cluster.Runners); err != nil {
-			utils.ExitErr(err)
+			return false, true, err
 		}
 	}
 	func() {
@@ -221,10 +594,11 @@ func (p *Peer) propose(cluster plan.Cluster) (bool, bool) {
 		p.updated = false
 	}()
 	_, keep := cluster.Workers.Rank(p.self)
-	return true, keep
+	return true, keep, nil
 }
 
 func (p *Peer) ResizeClusterFromURL() (bool, bool, error) {
+	var consensus consensusFunc = p.consensus
 	var cluster *plan.Cluster
 	for i := 0; ; i++ {
 		var err error
@@ -233,7 +607,13 @@ func (p *Peer) ResizeClusterFromURL() (bool, bool, error) {
 			log.Errorf("getClusterConfig failed: %v, using current config", err)
 			cluster = p.currentCluster
 		}
-		if digest := cluster.Bytes(); p.consensus(digest) {
+		var ok bool
+		var agreed plan.Cluster
+		if consensus, agreed, ok, err = p.agree(consensus, *cluster); err != nil {
+			return false, true, err
+		}
+		if ok {
+			cluster = &agreed
 			if i > 0 {
 				log.Infof("New peer list is consistent after failed %d times", i)
 			} else {
@@ -244,11 +624,16 @@ func (p *Peer) ResizeClusterFromURL() (bool, bool, error) {
 		log.Warnf("diverge proposal detected among %d peers! I proposed %s", len(p.currentCluster.Workers), cluster.DebugString())
 		time.Sleep(50 * time.Millisecond)
 	}
-	changed, keep := p.propose(*cluster)
+	changed, keep, err := p.propose(*cluster, consensus)
+	if err != nil {
+		return false, true, err
+	}
 	if keep {
 		p.Update()
+		p.electCoordinator()
 	} else {
 		p.detached = true
+		p.resignCoordinator()
 	}
 	return changed, keep, nil
 }
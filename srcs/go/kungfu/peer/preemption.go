@@ -0,0 +1,78 @@
+package peer
+
+import (
+	"sync/atomic"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/daemon"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// preemption tracks the number of workers the job may have on this host, given by the daemon,
+// and the workers removed by preemption, which are restored when the daemon allows.
+type preemption struct {
+	target    int32          // atomic, daemon.NoLimit if not limited
+	preempted map[uint32]int // host -> number of preempted workers
+}
+
+func newPreemption() *preemption {
+	return &preemption{target: daemon.NoLimit, preempted: make(map[uint32]int)}
+}
+
+func (p *preemption) setTarget(target int) {
+	atomic.StoreInt32(&p.target, int32(target))
+}
+
+// propose agrees on the workers leaving and joining the job with all peers, it must be called by all peers.
+// The workers of the largest local ranks of a host leave if the job has more workers than the target of the host,
+// except rank 0. The local rank 0 of a host grows the job back if the target allows.
+// It returns nil if the cluster is unchanged.
+func (p *preemption) propose(sess *session.Session, self plan.PeerID, cluster plan.Cluster) (*plan.Cluster, error) {
+	workers := cluster.Workers
+	x := base.NewVector(len(workers), base.I32)
+	y := base.NewVector(len(workers), base.I32)
+	if target := int(atomic.LoadInt32(&p.target)); target != daemon.NoLimit {
+		rank, _ := workers.Rank(self)
+		localRank, _ := workers.LocalRank(self)
+		local := len(workers.On(self.IPv4))
+		if local > target && localRank >= target && rank != 0 {
+			x.AsI32()[rank] = -1
+		} else if localRank == 0 && target > local {
+			n := target - local
+			if m := p.preempted[self.IPv4]; n > m {
+				n = m
+			}
+			x.AsI32()[rank] = int32(n)
+		}
+	}
+	w := base.Workspace{SendBuf: x, RecvBuf: y, OP: base.SUM, Name: "kungfu::preemption"}
+	if err := sess.AllReduce(w); err != nil {
+		return nil, err
+	}
+	var leaving plan.PeerList
+	grows := make(map[uint32]int)
+	for rank, v := range y.AsI32() {
+		if v < 0 {
+			leaving = append(leaving, workers[rank])
+		} else if v > 0 {
+			grows[workers[rank].IPv4] += int(v)
+		}
+	}
+	if len(leaving) == 0 && len(grows) == 0 {
+		return nil, nil
+	}
+	d := cluster.Without(leaving)
+	for _, w := range leaving {
+		p.preempted[w.IPv4]++
+	}
+	// grow in the order of workers, so that all peers get the same cluster
+	for _, w := range workers {
+		if n := grows[w.IPv4]; n > 0 {
+			d.GrowOn(w.IPv4, n)
+			p.preempted[w.IPv4] -= n
+			delete(grows, w.IPv4)
+		}
+	}
+	return &d, nil
+}
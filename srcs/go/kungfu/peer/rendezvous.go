@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+var errInvalidParties = errors.New("invalid parties")
+
+// rendezvous groups calls of the same name until the expected number of parties arrived,
+// for the barriers and the consensus of the gRPC control plane.
+type rendezvous struct {
+	sync.Mutex
+	groups map[string]*party
+}
+
+type party struct {
+	parties int
+	values  []*[]byte // of the parties that arrived and are waiting
+	done    chan struct{}
+	ok      bool
+}
+
+func newRendezvous() *rendezvous {
+	return &rendezvous{groups: make(map[string]*party)}
+}
+
+// join returns when parties calls of name have joined, true if all of them joined with the same value.
+// A call that returns with the error of ctx before then leaves the group, and the group is removed
+// when the last of its parties leaves, so that abandoned calls don't count for later calls of the name.
+func (r *rendezvous) join(ctx context.Context, name string, parties int, value []byte) (bool, error) {
+	if parties <= 0 {
+		return false, fmt.Errorf("%w: %d", errInvalidParties, parties)
+	}
+	r.Lock()
+	g, ok := r.groups[name]
+	if !ok {
+		g = &party{parties: parties, done: make(chan struct{})}
+		r.groups[name] = g
+	}
+	if g.parties != parties {
+		r.Unlock()
+		return false, fmt.Errorf("%w: %d for %s, others joined with %d", errInvalidParties, parties, name, g.parties)
+	}
+	v := &value
+	g.values = append(g.values, v)
+	if len(g.values) == g.parties {
+		g.ok = true
+		for _, v := range g.values[1:] {
+			if !utils.BytesEq(*v, *g.values[0]) {
+				g.ok = false
+			}
+		}
+		delete(r.groups, name) // the name can be reused after all parties arrived
+		close(g.done)
+	}
+	r.Unlock()
+	select {
+	case <-g.done:
+		return g.ok, nil
+	case <-ctx.Done():
+		r.leave(name, g, v)
+		select {
+		case <-g.done: // all parties arrived before it left
+			return g.ok, nil
+		default:
+			return false, ctx.Err()
+		}
+	}
+}
+
+func (r *rendezvous) leave(name string, g *party, v *[]byte) {
+	r.Lock()
+	defer r.Unlock()
+	select {
+	case <-g.done:
+		return
+	default:
+	}
+	for i, u := range g.values {
+		if u == v {
+			g.values = append(g.values[:i], g.values[i+1:]...)
+			break
+		}
+	}
+	if len(g.values) == 0 && r.groups[name] == g {
+		delete(r.groups, name)
+	}
+}
@@ -0,0 +1,57 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func joinAll(r *rendezvous, name string, values [][]byte) []bool {
+	oks := make([]bool, len(values))
+	var wg sync.WaitGroup
+	for i, v := range values {
+		wg.Add(1)
+		go func(i int, v []byte) {
+			defer wg.Done()
+			oks[i], _ = r.join(context.TODO(), name, len(values), v)
+		}(i, v)
+	}
+	wg.Wait()
+	return oks
+}
+
+func Test_rendezvous(t *testing.T) {
+	r := newRendezvous()
+	for _, ok := range joinAll(r, "same", [][]byte{[]byte("a"), []byte("a"), []byte("a")}) {
+		if !ok {
+			t.Errorf("consensus on the same value failed")
+		}
+	}
+	for _, ok := range joinAll(r, "diff", [][]byte{[]byte("a"), []byte("b")}) {
+		if ok {
+			t.Errorf("consensus on different values succeeded")
+		}
+	}
+	if _, err := r.join(context.TODO(), "bad", 0, nil); !errors.Is(err, errInvalidParties) {
+		t.Errorf("join of 0 parties: %v", err)
+	}
+}
+
+func Test_rendezvousLeave(t *testing.T) {
+	r := newRendezvous()
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.join(ctx, "x", 2, []byte("stale")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("join without other parties: %v", err)
+	}
+	if len(r.groups) != 0 {
+		t.Errorf("%d groups left after the only party left", len(r.groups))
+	}
+	for _, ok := range joinAll(r, "x", [][]byte{[]byte("a"), []byte("a")}) {
+		if !ok {
+			t.Errorf("consensus counted the value of a party that left")
+		}
+	}
+}
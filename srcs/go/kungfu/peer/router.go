@@ -2,9 +2,11 @@ package peer
 
 import (
 	"context"
-	"errors"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/client"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
@@ -20,14 +22,21 @@ type router struct {
 	client      *client.Client
 }
 
-func NewRouter(self plan.PeerID) *router {
-	client := client.New(self, config.UseUnixSock)
+func NewRouter(self plan.PeerID, transport string) *router {
+	client := client.NewWithTransport(self, config.UseUnixSock, transport)
+	collective := handler.NewCollectiveEndpoint()
+	ctrlHandler := &handler.ControlHandler{}
+	ctrlHandler.Register(session.AbortMessageName, func(data []byte) {
+		e := session.ParseAbortMessage(data)
+		log.Errorf("%v", e)
+		collective.AbortVersion(e.Version, e)
+	})
 	router := &router{
 		self:        self,
-		Collective:  handler.NewCollectiveEndpoint(),
+		Collective:  collective,
 		P2P:         handler.NewPeerToPeerEndpoint(client),
-		ctrlHandler: &handler.ControlHandler{},
-		pingHandler: &handler.PingHandler{},
+		ctrlHandler: ctrlHandler,
+		pingHandler: &handler.PingHandler{Client: client},
 		client:      client,
 	}
 	return router
@@ -46,7 +55,7 @@ func (r *router) Send(a plan.Addr, buf []byte, t connection.ConnType, flags uint
 	return r.client.Send(a, buf, t, flags)
 }
 
-var errWaitPeerFailed = errors.New("wait peer failed")
+var errWaitPeerFailed = failure.New(failure.PeerCrash, "wait peer failed")
 
 func (r *router) Wait(ctx context.Context, target plan.PeerID) (int, error) {
 	n, ok := r.client.Wait(ctx, target)
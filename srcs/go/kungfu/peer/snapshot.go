@@ -0,0 +1,132 @@
+package peer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+// SnapshotStepHeader is the HTTP header of the step of a snapshot served by the control server.
+const SnapshotStepHeader = `X-KungFu-Step`
+
+const defaultSnapshotTimeout = 1 * time.Minute
+
+// snapshots holds the models published by rank 0 for evaluators, by name.
+type snapshots struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	requested map[string]int64 // the least step of a snapshot that evaluators are waiting for
+	latest    map[string]*snapshot
+}
+
+type snapshot struct {
+	step int64
+	data []byte
+}
+
+func newSnapshots() *snapshots {
+	s := &snapshots{
+		requested: make(map[string]int64),
+		latest:    make(map[string]*snapshot),
+	}
+	s.cond = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// wait requests a snapshot of name taken at minStep or later, and waits until it's published or timeout.
+func (s *snapshots) wait(name string, minStep int64, timeout time.Duration) (*snapshot, bool) {
+	s.Lock()
+	defer s.Unlock()
+	t := time.AfterFunc(timeout, s.cond.Broadcast)
+	defer t.Stop()
+	deadline := time.Now().Add(timeout)
+	for {
+		if snap := s.latest[name]; snap != nil && snap.step >= minStep {
+			return snap, true
+		}
+		if time.Now().After(deadline) {
+			return nil, false
+		}
+		if step, ok := s.requested[name]; !ok || minStep < step {
+			s.requested[name] = minStep
+		}
+		s.cond.Wait()
+	}
+}
+
+// pending returns true if an evaluator is waiting for a snapshot of name that can be taken at step.
+func (s *snapshots) pending(name string, step int64) bool {
+	s.Lock()
+	defer s.Unlock()
+	minStep, ok := s.requested[name]
+	return ok && minStep <= step
+}
+
+// publish replaces the snapshot of name by a copy of data, and wakes up the waiting evaluators,
+// those waiting for a later step request again.
+func (s *snapshots) publish(name string, step int64, data []byte) {
+	snap := &snapshot{step: step, data: make([]byte, len(data))}
+	copy(snap.data, data)
+	s.Lock()
+	defer s.Unlock()
+	s.latest[name] = snap
+	delete(s.requested, name)
+	s.cond.Broadcast()
+}
+
+// SnapshotBoundary publishes buf as the snapshot of name at the current step of the session, if an evaluator is waiting for it.
+// It must be called by all peers at the same step after the model is updated, so that the snapshot is fenced by the step counter.
+// Only rank 0 copies buf, which is the model of all peers in synchronous training, it never blocks training.
+func (p *Peer) SnapshotBoundary(name string, buf *base.Vector) bool {
+	sess := p.CurrentSession()
+	if sess.Rank() != 0 {
+		return false
+	}
+	step := sess.Step()
+	if !p.controller.snapshots.pending(name, step) {
+		return false
+	}
+	p.controller.snapshots.publish(name, step, buf.Data)
+	return true
+}
+
+// serveSnapshot serves the snapshot of the given name taken at min_step or later,
+// by default the first one taken after the request. It waits for the trainers to publish it.
+func (c *controller) serveSnapshot(w http.ResponseWriter, req *http.Request) {
+	name := req.FormValue("name")
+	if len(name) == 0 {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	minStep := c.session().Step()
+	if val := req.FormValue("min_step"); len(val) > 0 {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid min_step: %q", val), http.StatusBadRequest)
+			return
+		}
+		minStep = n
+	}
+	timeout := defaultSnapshotTimeout
+	if val := req.FormValue("timeout"); len(val) > 0 {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid timeout: %q", val), http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	snap, ok := c.snapshots.wait(name, minStep, timeout)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no snapshot of %s at step %d within %s", name, minStep, timeout), http.StatusGatewayTimeout)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(SnapshotStepHeader, strconv.FormatInt(snap.step, 10))
+	w.Write(snap.data)
+}
@@ -0,0 +1,85 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/execution"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const (
+	sspStepName       = "kungfu::ssp:step"
+	sspPollPeriod     = 10 * time.Millisecond
+	sspWarnAfterPolls = 1000
+)
+
+// stepCounters caches the last known steps of other peers, steps are monotonic.
+type stepCounters struct {
+	sync.Mutex
+	steps map[plan.PeerID]int64
+}
+
+func (sc *stepCounters) get(id plan.PeerID) int64 {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.steps[id]
+}
+
+func (sc *stepCounters) update(id plan.PeerID, step int64) {
+	sc.Lock()
+	defer sc.Unlock()
+	if step > sc.steps[id] {
+		sc.steps[id] = step
+	}
+}
+
+// StaleSync implements the stale synchronous parallel (SSP) mode.
+// It publishes the step of this peer, and blocks only while any other peer is more than bound steps behind.
+func (p *Peer) StaleSync(step, bound int) error {
+	self := base.NewVector(1, base.I64)
+	self.AsI64()[0] = int64(step)
+	if err := p.Save(sspStepName, self); err != nil {
+		return err
+	}
+	sess := p.CurrentSession()
+	others := make(plan.PeerList, 0, sess.Size())
+	for i := 0; i < sess.Size(); i++ {
+		if i != sess.Rank() {
+			others = append(others, sess.Peer(i))
+		}
+	}
+	for n := 0; ; n++ {
+		var laggards plan.PeerList
+		for _, id := range others {
+			if int64(step)-p.stepCounters.get(id) > int64(bound) {
+				laggards = append(laggards, id)
+			}
+		}
+		if len(laggards) == 0 {
+			return nil
+		}
+		if n > 0 {
+			time.Sleep(sspPollPeriod)
+		}
+		if n == sspWarnAfterPolls {
+			log.Warnf("step %d is blocked by %d peers more than %d steps behind", step, len(laggards), bound)
+		}
+		var query execution.PeerFunc = func(id plan.PeerID) error {
+			buf := base.NewVector(1, base.I64)
+			ok, err := p.Request(id, "", sspStepName, buf)
+			if err != nil {
+				return err
+			}
+			if ok { // the peer may not have published any step yet
+				p.stepCounters.update(id, buf.AsI64()[0])
+			}
+			return nil
+		}
+		if err := query.Par(laggards); err != nil {
+			return err
+		}
+	}
+}
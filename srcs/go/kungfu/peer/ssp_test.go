@@ -0,0 +1,41 @@
+package peer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/tests/go/testutils"
+)
+
+func Test_StaleSync(t *testing.T) {
+	c, err := testutils.StartCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, p := range c.Peers[1:] {
+		if err := p.StaleSync(1, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Peers[0].StaleSync(5, 2) }()
+	select {
+	case err := <-done:
+		t.Fatalf("step 5 with bound 2 passed peers at step 1: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	for _, p := range c.Peers[1:] {
+		if err := p.StaleSync(3, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("step 5 with bound 2 is still blocked by peers at step 3")
+	}
+}
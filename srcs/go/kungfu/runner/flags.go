@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/plan/hostfile"
 	"github.com/lsds/KungFu/srcs/go/utils"
@@ -45,6 +46,7 @@ type FlagSet struct {
 	AllowNVLink bool
 
 	Strategy base.Strategy
+	Profile  string
 
 	Port        int
 	DebugPort   int
@@ -56,6 +58,8 @@ type FlagSet struct {
 	LogDir  string
 	Quiet   bool
 
+	Sweep string // spec file of the runs of a sweep
+
 	JobStartTime int
 	Prog         string
 	Args         []string
@@ -66,7 +70,7 @@ type FlagSet struct {
 
 func (f *FlagSet) Register(flag *flag.FlagSet) {
 	flag.IntVar(&f.ClusterSize, "np", 1, "number of peers")
-	flag.StringVar(&f.hostList, "H", plan.DefaultHostList.String(), "comma separated list of <internal IP>:<nslots>[:<public addr>]")
+	flag.StringVar(&f.hostList, "H", plan.DefaultHostList.String(), "comma separated list of <internal IP>:<nslots>[:<public addr>[:<group>+<group>...]]")
 	flag.StringVar(&f.hostFile, "hostfile", "", "path to hostfile, will override -H if specified")
 	flag.StringVar(&f.peerList, "P", "", "comma separated list of <host>:<port>[:slot]")
 
@@ -83,6 +87,7 @@ func (f *FlagSet) Register(flag *flag.FlagSet) {
 
 	f.Strategy = base.DefaultStrategy
 	flag.Var(&f.Strategy, "strategy", fmt.Sprintf("all reduce strategy, options are: %s", strings.Join(base.StrategyNames(), " | ")))
+	flag.StringVar(&f.Profile, "profile", "", fmt.Sprintf("configuration profile, options are: %s", strings.Join(config.ProfileNames(), " | ")))
 
 	flag.IntVar(&f.Port, "port", int(plan.DefaultRunnerPort), "port for rchannel")
 	flag.IntVar(&f.DebugPort, "debug-port", 0, "port for HTTP debug server")
@@ -95,6 +100,7 @@ func (f *FlagSet) Register(flag *flag.FlagSet) {
 	flag.StringVar(&f.Logfile, "logfile", "", "path to log file")
 	flag.StringVar(&f.LogDir, "logdir", "", "path to log dir")
 	flag.BoolVar(&f.Quiet, "q", false, "don't log debug info")
+	flag.StringVar(&f.Sweep, "sweep", "", "JSON spec file of a sweep, which runs the program with varied -np, -strategy, args and envs")
 
 	flag.DurationVar(&f.DelayStart, "delay", 0, "delay start for testing purpose")
 }
@@ -108,6 +114,9 @@ func (f *FlagSet) Parse(args []string) error {
 	if err := f.resolveHostList(); err != nil {
 		return err
 	}
+	if err := f.useProfile(commandLine); err != nil {
+		return err
+	}
 	args = commandLine.Args()
 	if len(args) < 1 {
 		return errMissingProgramName
@@ -117,6 +126,27 @@ func (f *FlagSet) Parse(args []string) error {
 	return nil
 }
 
+// useProfile applies the profile to the runner, and to the strategy unless -strategy is given.
+func (f *FlagSet) useProfile(commandLine *flag.FlagSet) error {
+	if len(f.Profile) == 0 {
+		return nil
+	}
+	if err := config.UseProfile(f.Profile); err != nil {
+		return err
+	}
+	var hasStrategy bool
+	commandLine.Visit(func(fl *flag.Flag) {
+		if fl.Name == "strategy" {
+			hasStrategy = true
+		}
+	})
+	if !hasStrategy {
+		p, _ := config.GetProfile(f.Profile)
+		return f.Strategy.Set(p.Strategy)
+	}
+	return nil
+}
+
 func (f *FlagSet) resolveHostList() error {
 	if len(f.hostFile) > 0 {
 		hl, err := hostfile.ParseFile(f.hostFile)
@@ -3,7 +3,10 @@ package runner
 import (
 	"flag"
 	"testing"
+	"time"
 
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/plan"
 )
 
@@ -26,3 +29,33 @@ func Test_flag(t *testing.T) {
 		}
 	}
 }
+
+func Test_profile(t *testing.T) {
+	{
+		var f FlagSet
+		if err := f.Parse([]string{`kungfu-run`, `-profile`, `wan`, `prog`}); err != nil {
+			t.Fatal(err)
+		}
+		if f.Strategy != base.MultiBinaryTreeStar {
+			t.Errorf("strategy of profile not used: %s", f.Strategy)
+		}
+		if config.CollectiveTimeout != 5*time.Minute || config.ResilienceTimeout != 10*time.Minute {
+			t.Errorf("timeouts of profile not used: %s, %s", config.CollectiveTimeout, config.ResilienceTimeout)
+		}
+	}
+	{
+		var f FlagSet
+		if err := f.Parse([]string{`kungfu-run`, `-profile`, `wan`, `-strategy`, `RING`, `prog`}); err != nil {
+			t.Fatal(err)
+		}
+		if f.Strategy != base.Ring {
+			t.Errorf("-strategy should override profile: %s", f.Strategy)
+		}
+	}
+	{
+		var f FlagSet
+		if err := f.Parse([]string{`kungfu-run`, `-profile`, `unknown`, `prog`}); err == nil {
+			t.Error("invalid profile should fail")
+		}
+	}
+}
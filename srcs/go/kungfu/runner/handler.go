@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/proc"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 	"github.com/lsds/KungFu/srcs/go/rchannel/handler"
 	"github.com/lsds/KungFu/srcs/go/utils"
@@ -36,11 +38,16 @@ func (s Stage) Eq(t Stage) bool {
 	return s.Version == t.Version && s.Cluster.Eq(t.Cluster)
 }
 
+// ConfigMessageName is the name of the control messages by which workers publish config learned during training,
+// e.g. the tuned chunk sizes, as JSON encoded proc.Envs.
+const ConfigMessageName = "config"
+
 type Handler struct {
 	self plan.PeerID
 
 	mu       sync.RWMutex
 	versions map[int]Stage
+	envs     proc.Envs // published by ConfigMessageName
 	ch       chan Stage
 	cancel   context.CancelFunc
 
@@ -56,6 +63,7 @@ func NewHandler(self plan.PeerID, ch chan Stage, cancel context.CancelFunc) *Han
 	h := &Handler{
 		self:            self,
 		versions:        make(map[int]Stage),
+		envs:            make(proc.Envs),
 		ch:              ch,
 		cancel:          cancel,
 		controlHandlers: make(map[string]connection.MsgHandleFunc),
@@ -63,6 +71,7 @@ func NewHandler(self plan.PeerID, ch chan Stage, cancel context.CancelFunc) *Han
 	}
 	h.controlHandlers["update"] = h.handleContrlUpdate
 	h.controlHandlers["exit"] = h.handleContrlExit
+	h.controlHandlers[ConfigMessageName] = h.handleContrlConfig
 	return h
 }
 
@@ -114,6 +123,31 @@ func (h *Handler) handleContrlExit(_name string, msg *connection.Message, _conn
 	h.cancel()
 }
 
+// handleContrlConfig keeps the config envs published by a worker, so that the workers started later start from them.
+// Envs other than config.ConfigEnvKeys are ignored.
+func (h *Handler) handleContrlConfig(_name string, msg *connection.Message, conn connection.Connection) {
+	var envs proc.Envs
+	if err := json.Unmarshal(msg.Data, &envs); err != nil {
+		log.Warnf("invalid config message from %s: %v", conn.Src(), err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range config.ConfigEnvKeys {
+		if val, ok := envs[k]; ok {
+			h.envs[k] = val
+			log.Debugf("%s=%s published by %s", k, val, conn.Src())
+		}
+	}
+}
+
+// ConfigEnvs returns the config envs published by the workers.
+func (h *Handler) ConfigEnvs() proc.Envs {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return proc.Merge(h.envs, nil)
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	e := json.NewEncoder(w)
 	e.SetIndent("", "    ")
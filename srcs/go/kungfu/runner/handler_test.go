@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/proc"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/server"
+)
+
+func Test_handlerConfig(t *testing.T) {
+	localhost := plan.MustParseIPv4(`127.0.0.1`)
+	self := plan.PeerID{IPv4: localhost, Port: 38183}
+	worker := plan.PeerID{IPv4: localhost, Port: 38184}
+	_, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	h := NewHandler(self, make(chan Stage, 1), cancel)
+	s := server.New(self, h, false)
+	s.SetMembers(plan.PeerList{self, worker})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	bs, _ := json.Marshal(proc.Envs{config.ChunkSizesEnvKey: "1024:256", "PATH": "/tmp"})
+	if err := client.New(worker, false).Send(self.WithName(ConfigMessageName), bs, connection.ConnControl, connection.NoFlag); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(h.ConfigEnvs()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	envs := h.ConfigEnvs()
+	if val := envs[config.ChunkSizesEnvKey]; val != "1024:256" {
+		t.Errorf("published %s=%q, want %q", config.ChunkSizesEnvKey, val, "1024:256")
+	}
+	if _, ok := envs["PATH"]; ok {
+		t.Errorf("published an env which is not a config env")
+	}
+}
@@ -0,0 +1,359 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/daemon"
+	"github.com/lsds/KungFu/srcs/go/kungfu/job"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/proc"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/server"
+	"github.com/lsds/KungFu/srcs/go/utils"
+	"github.com/lsds/KungFu/srcs/go/utils/runner/local"
+)
+
+// SweepRun is a job of a sweep, fields that are not set are taken from the flags of kungfu-run.
+type SweepRun struct {
+	Name     string            `json:"name,omitempty"`
+	NP       int               `json:"np,omitempty"`
+	Strategy string            `json:"strategy,omitempty"`
+	Args     []string          `json:"args,omitempty"` // appended to the args of the program
+	Env      map[string]string `json:"env,omitempty"`
+	Timeout  string            `json:"timeout,omitempty"`
+}
+
+// SweepMatrix generates a run for each combination of its values, e.g. for ablations of strategies on cluster sizes.
+type SweepMatrix struct {
+	NP       []int      `json:"np,omitempty"`
+	Strategy []string   `json:"strategy,omitempty"`
+	Args     [][]string `json:"args,omitempty"`
+}
+
+// SweepSpec is the spec file of kungfu-run -sweep.
+type SweepSpec struct {
+	Parallel int          `json:"parallel,omitempty"` // runs at the same time, 1 if not set
+	Runs     []SweepRun   `json:"runs,omitempty"`
+	Matrix   *SweepMatrix `json:"matrix,omitempty"` // its runs follow Runs
+}
+
+var errEmptySweep = errors.New("sweep has no runs")
+
+// ParseSweepSpec reads a SweepSpec from a JSON file.
+func ParseSweepSpec(filename string) (*SweepSpec, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var s SweepSpec
+	if err := json.Unmarshal(bs, &s); err != nil {
+		return nil, fmt.Errorf("invalid sweep spec %s: %v", filename, err)
+	}
+	if s.Parallel <= 0 {
+		s.Parallel = 1
+	}
+	if len(s.Expand()) == 0 {
+		return nil, errEmptySweep
+	}
+	for _, r := range s.Expand() {
+		if len(r.Strategy) > 0 {
+			var st base.Strategy
+			if err := st.Set(r.Strategy); err != nil {
+				return nil, fmt.Errorf("run %s: %v", r.Name, err)
+			}
+		}
+		if len(r.Timeout) > 0 {
+			if _, err := time.ParseDuration(r.Timeout); err != nil {
+				return nil, fmt.Errorf("run %s: %v", r.Name, err)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Expand returns the runs of Runs then of Matrix, with unique names.
+func (s SweepSpec) Expand() []SweepRun {
+	runs := append([]SweepRun{}, s.Runs...)
+	if m := s.Matrix; m != nil {
+		nps, strategies, argss := m.NP, m.Strategy, m.Args
+		if len(nps) == 0 {
+			nps = []int{0}
+		}
+		if len(strategies) == 0 {
+			strategies = []string{""}
+		}
+		if len(argss) == 0 {
+			argss = [][]string{nil}
+		}
+		for _, np := range nps {
+			for _, st := range strategies {
+				for _, args := range argss {
+					runs = append(runs, SweepRun{NP: np, Strategy: st, Args: args})
+				}
+			}
+		}
+	}
+	for i := range runs {
+		if len(runs[i].Name) == 0 {
+			runs[i].Name = fmt.Sprintf("run-%d", i)
+		}
+	}
+	return runs
+}
+
+// SweepResult is the outcome of a SweepRun.
+type SweepResult struct {
+	Name     string        `json:"name"`
+	NP       int           `json:"np"`
+	Strategy string        `json:"strategy"`
+	Duration time.Duration `json:"duration"`
+	Err      string        `json:"error,omitempty"`
+}
+
+// Sweep runs the runs of spec on the hosts of hl, at most spec.Parallel at a time, each on its share of the port range,
+// and logs the result of each run. Run i takes slot i % spec.Parallel, after the previous run of the slot finished
+// on all hosts, so that the runs of all hosts take the same ports, as kungfu-run is started on each host with the same
+// spec, and a run never takes the ports of a run still running on another host. Runs attach to kungfu-daemon, or to a
+// daemon of the sweep if there isn't one, so that they reuse the listeners and the topology probes of the host.
+func Sweep(ctx context.Context, selfIPv4 uint32, hl plan.HostList, pr plan.PortRange, j job.Job, f *FlagSet, spec *SweepSpec) []SweepResult {
+	runs := spec.Expand()
+	parallel := spec.Parallel
+	if parallel > len(runs) {
+		parallel = len(runs)
+	}
+	if pr.Cap()/parallel < 1 {
+		utils.ExitErr(fmt.Errorf("port range %s is too small for %d parallel runs", pr, parallel))
+	}
+	if len(config.DaemonSock) == 0 {
+		sockFile := fmt.Sprintf("/tmp/kungfu-sweep-%d.sock", os.Getpid())
+		d := daemon.New(sockFile, 0, 0)
+		if err := d.Listen(); err != nil {
+			utils.ExitErr(err)
+		}
+		go d.Serve()
+		defer func() {
+			d.Close()
+			os.Remove(sockFile)
+		}()
+		j.Envs = proc.Envs{config.DaemonSockEnvKey: sockFile}
+		log.Infof("runs of the sweep attach to the daemon at %s", sockFile)
+	}
+	barrier := newSweepBarrier(plan.PeerID{IPv4: selfIPv4, Port: uint16(f.Port)}, hl.GenRunnerList(uint16(f.Port)))
+	defer barrier.close()
+	results := make([]SweepResult, len(runs))
+	free := make([]chan struct{}, parallel) // a slot owns a share of the port range
+	for i := range free {
+		free[i] = make(chan struct{}, 1)
+		free[i] <- struct{}{}
+	}
+	share := pr.Cap() / parallel
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		slot := i % parallel // the same on all hosts, so that the peers of a run agree on its ports
+		<-free[slot]
+		if ctx.Err() != nil {
+			results[i] = SweepResult{Name: r.Name, Err: ctx.Err().Error()}
+			barrier.done(r.Name)
+			free[slot] <- struct{}{}
+			continue
+		}
+		wg.Add(1)
+		go func(i, slot int, r SweepRun) {
+			defer func() {
+				barrier.done(r.Name)
+				free[slot] <- struct{}{}
+				wg.Done()
+			}()
+			if i >= parallel {
+				if err := barrier.wait(ctx, runs[i-parallel].Name); err != nil {
+					results[i] = SweepResult{Name: r.Name, Err: err.Error()}
+					return
+				}
+			}
+			slotRange := plan.PortRange{Begin: pr.Begin + uint16(slot*share), End: pr.Begin + uint16((slot+1)*share-1)}
+			results[i] = runSweep(ctx, selfIPv4, hl, slotRange, j, f, r)
+		}(i, slot, r)
+	}
+	wg.Wait()
+	for _, r := range runs[len(runs)-parallel:] { // keep serving the other hosts until their runs finished
+		if err := barrier.wait(ctx, r.Name); err != nil {
+			log.Warnf("sweep run %s didn't finish on all hosts: %v", r.Name, err)
+		}
+	}
+	for _, r := range results {
+		status := "OK"
+		if len(r.Err) > 0 {
+			status = r.Err
+		}
+		log.Infof("sweep run %s: np=%d strategy=%s took %s: %s", r.Name, r.NP, r.Strategy, r.Duration, status)
+	}
+	if len(f.LogDir) > 0 {
+		filename := path.Join(f.LogDir, "sweep.json")
+		if bs, err := json.MarshalIndent(results, "", "    "); err == nil {
+			if err := ioutil.WriteFile(filename, bs, 0644); err != nil {
+				log.Warnf("failed to write %s: %v", filename, err)
+			}
+		}
+	}
+	return results
+}
+
+func runSweep(ctx context.Context, selfIPv4 uint32, hl plan.HostList, pr plan.PortRange, j job.Job, f *FlagSet, r SweepRun) SweepResult {
+	res := SweepResult{Name: r.Name, NP: f.ClusterSize, Strategy: j.Strategy.String()}
+	if r.NP > 0 {
+		res.NP = r.NP
+	}
+	if len(r.Strategy) > 0 {
+		j.Strategy.Set(r.Strategy)
+		res.Strategy = j.Strategy.String()
+	}
+	j.Args = append(append([]string{}, j.Args...), r.Args...)
+	j.Envs = proc.Merge(proc.Merge(proc.Envs{config.JobEnvKey: "sweep-" + r.Name}, j.Envs), r.Env) // a job of the daemon
+	if len(f.LogDir) > 0 {
+		j.LogDir = path.Join(f.LogDir, r.Name)
+	}
+	peers, err := hl.GenPeerList(res.NP, pr)
+	if err != nil {
+		res.Err = fmt.Sprintf("failed to create peers: %v", err)
+		return res
+	}
+	if len(r.Timeout) > 0 {
+		timeout, _ := time.ParseDuration(r.Timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cluster := plan.Cluster{Runners: hl.GenRunnerList(uint16(f.Port)), Workers: peers}
+	procs := j.CreateProcs(cluster, selfIPv4)
+	log.Infof("sweep run %s: %d/%d local peers of %s with %q", r.Name, len(procs), len(peers), j.Prog, j.Args)
+	res.Duration, err = utils.Measure(func() error { return local.RunAll(ctx, procs, f.VerboseLog) })
+	if err != nil {
+		res.Err = err.Error()
+	}
+	return res
+}
+
+// sweepDoneName is the control message of a runner to the other runners of a sweep, that a run finished on its host.
+const sweepDoneName = "sweep-done"
+
+// sweepBarrier tracks the runs of a sweep that finished on each host, by the control messages of the runners,
+// so that a run reusing the ports of an earlier run waits until the earlier run finished on all hosts.
+// It's nil for a sweep of a single host.
+type sweepBarrier struct {
+	sync.Mutex
+	self     plan.PeerID
+	runners  plan.PeerList
+	client   *client.Client
+	server   server.Server
+	finished map[string]map[plan.PeerID]struct{} // the runners each run finished on
+	all      map[string]chan struct{}            // closed when a run finished on all runners
+}
+
+// newSweepBarrier serves the control messages of the runners on the port of self.
+func newSweepBarrier(self plan.PeerID, runners plan.PeerList) *sweepBarrier {
+	if len(runners) <= 1 {
+		return nil
+	}
+	b := &sweepBarrier{
+		self:     self,
+		runners:  runners,
+		client:   client.New(self, false),
+		finished: make(map[string]map[plan.PeerID]struct{}),
+		all:      make(map[string]chan struct{}),
+	}
+	b.server = server.New(self, b, false)
+	b.server.SetMembers(runners)
+	if err := b.server.Start(); err != nil {
+		utils.ExitErr(err)
+	}
+	return b
+}
+
+func (b *sweepBarrier) Handle(conn connection.Connection) (int, error) {
+	if t := conn.Type(); t != connection.ConnControl {
+		return 0, fmt.Errorf("%v: %s from %s", connection.ErrInvalidConnectionType, t, conn.Src())
+	}
+	return connection.Stream(conn, connection.Accept, func(name string, msg *connection.Message, conn connection.Connection) {
+		if name != sweepDoneName {
+			log.Warnf("invalid control message of sweep: %s", name)
+			return
+		}
+		b.finish(string(msg.Data), conn.Src())
+	})
+}
+
+// allOf returns the channel closed when run finished on all runners.
+func (b *sweepBarrier) allOf(run string) chan struct{} {
+	ch, ok := b.all[run]
+	if !ok {
+		ch = make(chan struct{})
+		b.all[run] = ch
+	}
+	return ch
+}
+
+func (b *sweepBarrier) finish(run string, runner plan.PeerID) {
+	if !b.runners.Contains(runner) {
+		log.Warnf("sweep run %s finished on %s, which is not a runner of the sweep", run, runner)
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.finished[run] == nil {
+		b.finished[run] = make(map[plan.PeerID]struct{})
+	}
+	b.finished[run][runner] = struct{}{}
+	if len(b.finished[run]) == len(b.runners) {
+		close(b.allOf(run))
+	}
+}
+
+// done records that run finished on this host, and tells the other runners.
+func (b *sweepBarrier) done(run string) {
+	if b == nil {
+		return
+	}
+	b.finish(run, b.self)
+	for _, r := range b.runners {
+		if r == b.self {
+			continue
+		}
+		if err := b.client.Send(r.WithName(sweepDoneName), []byte(run), connection.ConnControl, connection.NoFlag); err != nil {
+			log.Warnf("failed to tell %s that sweep run %s finished: %v", r, run, err)
+		}
+	}
+}
+
+// wait waits until run finished on all hosts.
+func (b *sweepBarrier) wait(ctx context.Context, run string) error {
+	if b == nil {
+		return nil
+	}
+	b.Lock()
+	ch := b.allOf(run)
+	b.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *sweepBarrier) close() {
+	if b != nil {
+		b.server.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/job"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_sweep_expand(t *testing.T) {
+	s := SweepSpec{
+		Runs: []SweepRun{{Name: "base", NP: 2}},
+		Matrix: &SweepMatrix{
+			NP:       []int{2, 4},
+			Strategy: []string{"RING", "STAR"},
+		},
+	}
+	runs := s.Expand()
+	if len(runs) != 5 {
+		t.Fatalf("expand to %d runs, want 5", len(runs))
+	}
+	if runs[0].Name != "base" || runs[1].Name != "run-1" {
+		t.Errorf("unexpected names %q, %q", runs[0].Name, runs[1].Name)
+	}
+	if r := runs[4]; r.NP != 4 || r.Strategy != "STAR" {
+		t.Errorf("unexpected last run %+v", r)
+	}
+	if runs := (SweepSpec{}).Expand(); len(runs) != 0 {
+		t.Errorf("empty spec expands to %d runs", len(runs))
+	}
+}
+
+func Test_sweep_parallel(t *testing.T) {
+	dir := t.TempDir()
+	hl, err := plan.ParseHostList(`127.0.0.1:1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := plan.PortRange{Begin: 10000, End: 10009}
+	j := job.Job{
+		Prog:     `sh`,
+		Args:     []string{`-c`, `sleep $1; echo $KUNGFU_SELF_SPEC > ` + dir + `/$0`},
+		HostList: hl,
+	}
+	spec := &SweepSpec{Parallel: 2}
+	for i, delay := range []string{`0.3`, `0`, `0`, `0`} { // run-2 waits for run-0, not for run-1
+		spec.Runs = append(spec.Runs, SweepRun{Name: fmt.Sprintf("run-%d", i), NP: 1, Args: []string{fmt.Sprintf("run-%d", i), delay}})
+	}
+	f := &FlagSet{ClusterSize: 1, Port: 38080}
+	results := Sweep(context.TODO(), plan.MustParseIPv4(`127.0.0.1`), hl, pr, j, f, spec)
+	for i, r := range results {
+		if len(r.Err) > 0 {
+			t.Fatalf("run %s failed: %s", r.Name, r.Err)
+		}
+		bs, err := ioutil.ReadFile(path.Join(dir, r.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := plan.ParsePeerID(strings.TrimSpace(string(bs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := pr.Begin + uint16(5*(i%2)); id.Port != want {
+			t.Errorf("run %s took port %d, want %d of slot %d", r.Name, id.Port, want, i%2)
+		}
+	}
+}
+
+func Test_sweepBarrier(t *testing.T) {
+	localhost := plan.MustParseIPv4(`127.0.0.1`)
+	runners := plan.PeerList{{IPv4: localhost, Port: 38181}, {IPv4: localhost, Port: 38182}}
+	a := newSweepBarrier(runners[0], runners)
+	defer a.close()
+	b := newSweepBarrier(runners[1], runners)
+	defer b.close()
+	a.done("run-0")
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	if err := a.wait(ctx, "run-0"); err == nil {
+		t.Fatalf("run-0 finished on all hosts before it finished on %s", runners[1])
+	}
+	b.done("run-0")
+	ctx, cancel = context.WithTimeout(context.TODO(), 10*time.Second)
+	defer cancel()
+	for _, x := range []*sweepBarrier{a, b} {
+		if err := x.wait(ctx, "run-0"); err != nil {
+			t.Errorf("run-0 didn't finish on all hosts for %s: %v", x.self, err)
+		}
+	}
+}
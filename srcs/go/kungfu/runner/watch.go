@@ -22,6 +22,7 @@ import (
 
 type watcher struct {
 	server  server.Server
+	handler *Handler
 	parent  plan.PeerID
 	parents plan.PeerList
 
@@ -47,7 +48,9 @@ func (w *watcher) create(id plan.PeerID, s Stage) {
 	if gpuID < 0 {
 		log.Errorf("gpuID = %d", gpuID)
 	}
-	proc := w.job.NewProc(id, gpuID, s.Version, s.Cluster)
+	j := w.job
+	j.Envs = proc.Merge(j.Envs, w.handler.ConfigEnvs()) // start from the config learned by the running workers
+	proc := j.NewProc(id, gpuID, s.Version, s.Cluster)
 	go func(g *sync.WaitGroup) {
 		runProc(w.ctx, w.cancel, proc, s.Version, w.job.LogDir)
 		g.Done()
@@ -63,6 +66,7 @@ func (w *watcher) delete(id plan.PeerID) {
 
 func (w *watcher) update(s Stage) {
 	w.server.SetToken(uint32(s.Version))
+	w.server.SetMembers(s.Cluster.Members())
 	if w.current.Workers.Disjoint(s.Cluster.Workers) {
 		log.Errorf("full update detected: %s -> %s", w.current.DebugString(), s.Cluster.DebugString())
 	}
@@ -118,6 +122,7 @@ func WatchRun(ctx context.Context, self plan.PeerID, runners plan.PeerList, ch c
 	defer server.Close()
 	watcher := &watcher{
 		server:  server,
+		handler: handler,
 		parent:  self,
 		parents: runners,
 		job:     j,
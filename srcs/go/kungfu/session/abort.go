@@ -0,0 +1,70 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+// AbortMessageName is the name of the control message sent to all peers by Abort.
+const AbortMessageName = `abort`
+
+// AbortError is returned by the collectives in flight and all later collectives of the session, after any peer called Abort.
+type AbortError struct {
+	Rank    int    `json:"rank"` // the rank of the peer which called Abort
+	Reason  string `json:"reason"`
+	Version uint32 `json:"version"` // of the session, so that the abort doesn't fail the later sessions
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("aborted by rank %d: %s", e.Rank, e.Reason)
+}
+
+func (e *AbortError) FailureKind() failure.Kind {
+	return failure.Cancelled
+}
+
+// ParseAbortMessage decodes an abort control message sent by Abort.
+func ParseAbortMessage(data []byte) *AbortError {
+	var e AbortError
+	if err := json.Unmarshal(data, &e); err != nil {
+		return &AbortError{Rank: -1, Reason: fmt.Sprintf("invalid abort message: %v", err)}
+	}
+	return &e
+}
+
+// Abort makes the collectives in flight and all later collectives of the session of all peers fail with an AbortError,
+// so that a fatal condition on one peer doesn't leave the others waiting at a barrier.
+// It can be called by any peer, and sends the abort to the other peers over the control plane.
+func (sess *Session) Abort(reason string) error {
+	e := &AbortError{Rank: sess.rank, Reason: reason, Version: sess.collectiveHandler.Version()}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sess.collectiveHandler.AbortVersion(e.Version, e)
+	errs := make([]error, len(sess.peers))
+	var wg sync.WaitGroup
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		wg.Add(1)
+		go func(rank int, peer plan.PeerID) {
+			errs[rank] = sess.client.Send(peer.WithName(AbortMessageName), data, connection.ConnControl, connection.NoFlag)
+			wg.Done()
+		}(rank, peer)
+	}
+	wg.Wait()
+	return utils.MergeErrors(errs, "Abort")
+}
+
+// Aborted returns the AbortError if any peer called Abort, or nil.
+func (sess *Session) Aborted() error {
+	return sess.collectiveHandler.Aborted()
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// RootAccount is the account of the collectives of the root session.
+// Sub-sessions are accounted by their tags, e.g. group:workers or qos:checkpoint.
+const RootAccount = `root`
+
+// SessionUsage is the communication of a session of a peer.
+type SessionUsage struct {
+	Session    string        `json:"session"`
+	Messages   int64         `json:"messages"`
+	Bytes      int64         `json:"bytes"`
+	Wire       time.Duration `json:"wire"`        // time writing messages to connections
+	Queue      time.Duration `json:"queue"`       // time waiting for the QoS share and for messages of other sessions
+	QueueShare float64       `json:"queue_share"` // fraction of the queueing delay of all sessions of the peer
+}
+
+func (sess *Session) account() string {
+	if len(sess.tag) == 0 {
+		return RootAccount
+	}
+	return strings.TrimSuffix(sess.tag, "/")
+}
+
+// send sends data of a collective to a, within the QoS class of sess, and charges it to the account of sess.
+func (sess *Session) send(a plan.Addr, data []byte, flags uint32) error {
+	traceSendBegin(a.Name, len(data))
+	defer traceSendEnd(a.Name, len(data))
+	return sess.client.SendAs(sess.account(), sess.qos, a, data, connection.ConnCollective, flags)
+}
+
+// SessionUsages returns the communication of each session of the peer, which share the connections of the peer,
+// so that the sessions responsible for slowdowns can be told by their wire time and queueing delay.
+// The root sessions before and after membership changes share the same account.
+func (sess *Session) SessionUsages() []SessionUsage {
+	us := sess.client.Usages()
+	var total time.Duration
+	for _, u := range us {
+		total += u.Queue
+	}
+	var sus []SessionUsage
+	for _, u := range us {
+		su := SessionUsage{
+			Session:  u.Account,
+			Messages: u.Messages,
+			Bytes:    u.Bytes,
+			Wire:     u.Wire,
+			Queue:    u.Queue,
+		}
+		if total > 0 {
+			su.QueueShare = float64(u.Queue) / float64(total)
+		}
+		sus = append(sus, su)
+	}
+	return sus
+}
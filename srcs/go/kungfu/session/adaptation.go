@@ -1,6 +1,11 @@
 package session
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/plan/graph"
 	"github.com/lsds/KungFu/srcs/go/utils/assert"
@@ -14,7 +19,8 @@ func (sess *Session) SetGlobalStrategy(sl strategyList) error {
 	ok, err := sess.BytesConsensus(sl.digestBytes(), "kungfu::SetStrategy")
 	assert.True(ok)
 	assert.OK(err)
-	sess.globalStrategies = sl
+	sess.swap.set(sl)
+	assert.OK(sess.negotiateCodec())
 
 	assert.OK(sess.barrier())
 	return nil
@@ -26,6 +32,152 @@ func (sess *Session) SimpleSetGlobalStrategy(forest []int32) error {
 	assert.True(m == 1)
 	assert.True(ok)
 	rg := plan.GenDefaultReduceGraph(bg)
-	s0 := strategy{reduceGraph: rg, bcastGraph: bg}
+	s0 := strategy{name: "CUSTOM", reduceGraph: rg, bcastGraph: bg}
 	return sess.SetGlobalStrategy([]strategy{s0})
 }
+
+// BuildGlobalStrategy sets the global strategies to the graph pairs built by bs, e.g.
+//
+//	sess.BuildGlobalStrategy("MIXED", graph.NewRing(n).WithOffset(3), graph.NewTree(n).RootedAt(5).Binary())
+func (sess *Session) BuildGlobalStrategy(name string, bs ...graph.Builder) error {
+	if len(bs) == 0 {
+		return errInvalidStrategyGraph
+	}
+	var sl strategyList
+	for _, b := range bs {
+		p, err := b.Build()
+		if err != nil {
+			return err
+		}
+		if n := len(p.Bcast.Nodes); n != len(sess.peers) {
+			return fmt.Errorf("%v: %d nodes for %d peers", errInvalidStrategyGraph, n, len(sess.peers))
+		}
+		sl = append(sl, strategy{reduceGraph: p.Reduce, bcastGraph: p.Bcast})
+	}
+	return sess.SwapGlobalStrategy(named(name, sl))
+}
+
+// StrategyInfo describes a global strategy to operators.
+type StrategyInfo struct {
+	Name      string `json:"name"`
+	Suspended bool   `json:"suspended"`
+	Standby   bool   `json:"standby"`
+	Broken    bool   `json:"broken"` // uses a link of an open circuit breaker
+	Graph     string `json:"graph"`
+	Failures  int    `json:"failures"` // chunks that timed out and were retried on another strategy
+	Chunks    int64  `json:"chunks"`   // estimated from the sampled chunks
+	AvgChunk  string `json:"avg_chunk"`
+}
+
+func (sess *Session) GlobalStrategies() []StrategyInfo {
+	var infos []StrategyInfo
+	for _, s := range sess.nextGlobalStrategies() {
+		chunks, avg := sess.stats.get(s.name)
+		infos = append(infos, StrategyInfo{
+			Name:      s.name,
+			Suspended: s.suspended,
+			Standby:   s.standby,
+			Broken:    s.broken,
+			Graph:     s.bcastGraph.DebugString(),
+			Failures:  sess.failures.get(s.name),
+			Chunks:    chunks,
+			AvgChunk:  avg.String(),
+		})
+	}
+	return infos
+}
+
+var (
+	errStrategyNotFound     = errors.New("strategy not found")
+	errNoActiveStrategy     = errors.New("can't suspend the last active strategy")
+	errInvalidStrategyGraph = errors.New("invalid strategy graph")
+)
+
+// SuspendStrategy stops using the global strategy of the given name, it must be called by all peers.
+// A standby strategy is promoted in place of a suspended active strategy, if any.
+func (sess *Session) SuspendStrategy(name string) error {
+	return sess.setSuspended(name, true)
+}
+
+// ResumeStrategy resumes a suspended global strategy, it must be called by all peers.
+// A strategy promoted in place of a suspended one returns to standby, the slowest one by the stats of all peers.
+func (sess *Session) ResumeStrategy(name string) error {
+	return sess.setSuspended(name, false)
+}
+
+func (sess *Session) setSuspended(name string, suspended bool) error {
+	latest := sess.swap.latest()
+	sl := make(strategyList, len(latest))
+	copy(sl, latest)
+	var found, wasActive, standby bool
+	for i := range sl {
+		if sl[i].name == name {
+			wasActive = !sl[i].suspended && !sl[i].standby
+			standby = sl[i].standby
+			sl[i].suspended = suspended
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%v: %s", errStrategyNotFound, name)
+	}
+	if suspended && wasActive {
+		if promoted, ok := sl.promote(); ok {
+			sess.logger.Infof("promoted standby strategy %s in place of %s", promoted, name)
+		}
+	} else if !suspended && !wasActive && !standby {
+		var durations map[string]time.Duration
+		if names := sl.promoted(); len(names) > 1 {
+			var err error
+			if durations, err = sess.agreeDurations(names); err != nil {
+				return err
+			}
+		}
+		if demoted, ok := sl.demote(durations); ok {
+			sess.logger.Infof("returned strategy %s to standby in place of %s", demoted, name)
+		}
+	}
+	if len(sl.active()) == 0 {
+		return errNoActiveStrategy
+	}
+	return sess.SwapGlobalStrategy(sl)
+}
+
+// InstallStrategy adds a global strategy with a broadcast tree given by forest, it must be called by all peers.
+func (sess *Session) InstallStrategy(name string, forest []int32) error {
+	if len(forest) != len(sess.peers) {
+		return fmt.Errorf("%v: %d nodes for %d peers", errInvalidStrategyGraph, len(forest), len(sess.peers))
+	}
+	bg, m, ok := graph.FromForestArrayI32(forest)
+	if !ok || m != 1 {
+		return errInvalidStrategyGraph
+	}
+	var sl strategyList
+	for _, s := range sess.swap.latest() {
+		if s.name != name { // replace the strategy of the same name
+			sl = append(sl, s)
+		}
+	}
+	sl = append(sl, strategy{
+		name:        name,
+		reduceGraph: plan.GenDefaultReduceGraph(bg),
+		bcastGraph:  bg,
+	})
+	return sess.SwapGlobalStrategy(sl)
+}
+
+// Retune regenerates the global strategies, or runs AutoTune if enabled, it must be called by all peers.
+func (sess *Session) Retune() error {
+	if config.EnableAutoTune {
+		return sess.AutoTune()
+	}
+	if len(sess.registeredName) > 0 {
+		sl, err := genRegisteredStrategyList(sess.registeredName, sess.peers)
+		if err != nil {
+			return err
+		}
+		return sess.SwapGlobalStrategy(sl)
+	}
+	sl := named(sess.strategyName.String(), genGlobalStrategyList(sess.peers, sess.strategyName))
+	return sess.SwapGlobalStrategy(append(sl, genStandbyStrategyList(sess.peers)...))
+}
@@ -1,45 +1,225 @@
 package session
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
-	"github.com/lsds/KungFu/srcs/go/kungfu/execution"
 	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 	"github.com/lsds/KungFu/srcs/go/utils"
 )
 
+// AllGather gathers the SendBuf of all peers into RecvBuf, ordered by rank.
+// RecvBuf must have Size() times the count of SendBuf.
 func (sess *Session) AllGather(w kb.Workspace) error {
-	return sess.runAllGather(w)
+	defer sess.track("all_gather", w)()
+	if err := checkHost(w); err != nil {
+		return err
+	}
+	return sess.orLocalWith(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runAllGather(w) }
+	}, func(w kb.Workspace) { sess.ownBlock(w).CopyFrom(w.SendBuf) })()
 }
 
-func (sess *Session) runAllGather(w kb.Workspace) error {
+// ownBlock returns the block of this peer of the RecvBuf of a gather of w.
+func (sess *Session) ownBlock(w kb.Workspace) *kb.Vector {
 	count := w.SendBuf.Count
-	var sendInto execution.PeerFunc = func(peer plan.PeerID) error {
-		return sess.client.Send(peer.WithName(w.Name), w.SendBuf.Data, connection.ConnCollective, connection.WaitRecvBuf)
+	return w.RecvBuf.Slice(sess.rank*count, (sess.rank+1)*count)
+}
+
+// runAllGather splits SendBuf into chunks as AllReduce does, and gathers each chunk on the strategy chosen for it,
+// so that a large tensor is pipelined over the links of the strategies rather than sent as a single message to each peer.
+// A SendBuf larger than maxMessageBytes is gathered in parts of at most maxMessageBytes in order, as runGiant does.
+func (sess *Session) runAllGather(w kb.Workspace) error {
+	parts := giantIntervals(w.SendBuf.Count, w.SendBuf.Type.Size())
+	if len(parts) <= 1 {
+		return sess.runAllGatherPart(w, w.Name, plan.Interval{Begin: 0, End: w.SendBuf.Count})
 	}
-	var recvInto execution.PeerFunc = func(peer plan.PeerID) error {
-		rank, ok := sess.peers.Rank(peer)
-		if !ok {
-			utils.Immpossible()
+	for _, r := range parts {
+		if err := sess.runAllGatherPart(w, fmt.Sprintf("part::%s[%d:%d]", w.Name, r.Begin, r.End), r); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// runAllGatherPart gathers the interval part of the SendBuf of all peers, in chunks named after name.
+func (sess *Session) runAllGatherPart(w kb.Workspace, name string, part plan.Interval) error {
+	strategies := sess.nextGlobalStrategies().active()
+	sendBuf := w.SendBuf.Slice(part.Begin, part.End)
+	chunked := kb.Workspace{SendBuf: sendBuf, RecvBuf: sendBuf, OP: w.OP, Name: name}
+	n := sendBuf.Count * sendBuf.Type.Size()
+	cp := sess.plans.get(chunked, plan.EvenPartition, len(strategies), sess.getStrategyHash(), sess.chunks.chunkSize(sess.Step(), n))
+	errs := make([]error, len(cp.intervals))
+	var wg sync.WaitGroup
+	for i, r := range cp.intervals {
+		wg.Add(1)
+		r := plan.Interval{Begin: part.Begin + r.Begin, End: part.Begin + r.End}
+		go func(i int, r plan.Interval, s strategy) {
+			errs[i] = sess.runAllGatherGraphs(w, r, cp.names[i], s)
+			wg.Done()
+		}(i, r, strategies[cp.choices[i]])
+	}
+	wg.Wait()
+	return utils.MergeErrors(errs, "AllGather")
+}
+
+// runAllGatherGraphs gathers the interval r of the SendBuf of all peers into the same interval of their blocks of RecvBuf.
+// The blocks are gathered along the reverse of the broadcast graph of s, each peer forwarding the blocks of its subtree,
+// to the root, which broadcasts them along the broadcast graph, each peer forwarding the blocks that are not of the
+// subtree of the next. The gather doesn't use the reduce graph, which needs not be the reverse of the broadcast graph.
+func (sess *Session) runAllGatherGraphs(w kb.Workspace, r plan.Interval, name string, s strategy) error {
+	if err := checkBcastTree(s.bcastGraph); err != nil {
+		return fmt.Errorf("%w of strategy %s", err, s.name)
+	}
+	gatherGraph := s.bcastGraph.Reverse()
+	count := w.SendBuf.Count
+	block := func(rank int) *kb.Vector {
 		offset := rank * count
-		sess.collectiveHandler.RecvInto(peer.WithName(w.Name), asMessage(w.RecvBuf.Slice(offset, offset+count)))
+		return w.RecvBuf.Slice(offset+r.Begin, offset+r.End)
+	}
+	block(sess.rank).CopyFrom(w.SendBuf.Slice(r.Begin, r.End))
+	subtrees := reduceSubtrees(gatherGraph)
+	recv := func(peer, rank int, phase string) error {
+		a := sess.peers[peer].WithName(sess.tagged(fmt.Sprintf("%s:%s:%d", name, phase, rank)))
+		return sess.collectiveHandler.RecvInto(a, asMessage(block(rank)))
+	}
+	send := func(peer, rank int, phase string) error {
+		a := sess.peers[peer].WithName(sess.tagged(fmt.Sprintf("%s:%s:%d", name, phase, rank)))
+		if err := sess.send(a, block(rank).Data, connection.WaitRecvBuf); err != nil {
+			return sess.sendFailed(sess.peers[peer], err)
+		}
 		return nil
 	}
-	others := sess.peers.Others(sess.self)
+	var fs []func() error
+	for _, j := range otherRanks(gatherGraph.Prevs(sess.rank), sess.rank) {
+		for _, k := range subtrees[j] {
+			j, k := j, k
+			fs = append(fs, func() error { return recv(j, k, "gather") })
+		}
+	}
+	if err := runPar(fs); err != nil {
+		return err
+	}
+	fs = nil
+	for _, j := range otherRanks(gatherGraph.Nexts(sess.rank), sess.rank) {
+		for _, k := range subtrees[sess.rank] {
+			j, k := j, k
+			fs = append(fs, func() error { return send(j, k, "gather") })
+		}
+	}
+	if err := runPar(fs); err != nil {
+		return err
+	}
+	prevs := otherRanks(s.bcastGraph.Prevs(sess.rank), sess.rank)
+	nexts := otherRanks(s.bcastGraph.Nexts(sess.rank), sess.rank)
+	fs = nil
+	for k := range sess.peers {
+		k := k
+		fs = append(fs, func() error { // forward each block as soon as it's received
+			if !contains(subtrees[sess.rank], k) {
+				for _, j := range prevs {
+					if err := recv(j, k, "bcast"); err != nil {
+						return err
+					}
+				}
+			}
+			for _, j := range nexts {
+				if contains(subtrees[j], k) {
+					continue
+				}
+				if err := send(j, k, "bcast"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return runPar(fs)
+}
+
+var errNotBcastTree = errors.New("AllGather requires a broadcast tree")
+
+// checkBcastTree checks that g is a tree of a single root, which reaches all vertices, so that the root gathers all
+// blocks along the reverse of g, and each block is received once by each vertex.
+func checkBcastTree(g *graph.Graph) error {
+	root := -1
+	for i := range g.Nodes {
+		switch len(otherRanks(g.Prevs(i), i)) {
+		case 0:
+			if root >= 0 {
+				return fmt.Errorf("%w: roots %d and %d", errNotBcastTree, root, i)
+			}
+			root = i
+		case 1:
+		default:
+			return fmt.Errorf("%w: %d has %d parents", errNotBcastTree, i, len(g.Prevs(i)))
+		}
+	}
+	if root < 0 {
+		return fmt.Errorf("%w: no root", errNotBcastTree)
+	}
+	if n := len(reduceSubtrees(g.Reverse())[root]); n != len(g.Nodes) {
+		return fmt.Errorf("%w: root %d reaches %d of %d vertices", errNotBcastTree, root, n, len(g.Nodes))
+	}
+	return nil
+}
+
+// reduceSubtrees returns the ranks of the subtree of each vertex of the reduce graph g, i.e. those reduced through it.
+func reduceSubtrees(g *graph.Graph) [][]int {
+	subtrees := make([][]int, len(g.Nodes))
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		if subtrees[i] == nil {
+			subtrees[i] = []int{i}
+			for _, j := range otherRanks(g.Prevs(i), i) {
+				for _, k := range visit(j) {
+					if !contains(subtrees[i], k) {
+						subtrees[i] = append(subtrees[i], k)
+					}
+				}
+			}
+		}
+		return subtrees[i]
+	}
+	for i := range subtrees {
+		visit(i)
+	}
+	return subtrees
+}
+
+func otherRanks(ranks []int, rank int) []int {
+	var others []int
+	for _, r := range ranks {
+		if r != rank {
+			others = append(others, r)
+		}
+	}
+	return others
+}
+
+func contains(ranks []int, rank int) bool {
+	for _, r := range ranks {
+		if r == rank {
+			return true
+		}
+	}
+	return false
+}
+
+func runPar(fs []func() error) error {
+	errs := make([]error, len(fs))
 	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		sendInto.Par(others)
-		wg.Done()
-	}()
-	go func() {
-		recvInto.Par(others)
-		wg.Done()
-	}()
-	w.RecvBuf.Slice(sess.rank*count, (sess.rank+1)*count).CopyFrom(w.SendBuf)
+	for i, f := range fs {
+		wg.Add(1)
+		go func(i int, f func() error) {
+			errs[i] = f()
+			wg.Done()
+		}(i, f)
+	}
 	wg.Wait()
-	return nil // FIXME: handle errors
+	return utils.MergeErrors(errs, "AllGather")
 }
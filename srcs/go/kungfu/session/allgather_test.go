@@ -0,0 +1,45 @@
+package session
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+func Test_reduceSubtrees(t *testing.T) {
+	g := plan.GenDefaultReduceGraph(plan.GenBinaryTree(5)) // 0 -> 1, 2; 1 -> 3, 4
+	subtrees := reduceSubtrees(g)
+	for i, want := range [][]int{{0, 1, 2, 3, 4}, {1, 3, 4}, {2}, {3}, {4}} {
+		got := append([]int(nil), subtrees[i]...)
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("subtree of %d: %v, want %v", i, got, want)
+		}
+	}
+}
+
+func Test_checkBcastTree(t *testing.T) {
+	if err := checkBcastTree(plan.GenBinaryTree(5)); err != nil {
+		t.Errorf("binary tree: %v", err)
+	}
+	if err := checkBcastTree(plan.GenStarBcastGraph(4, 2)); err != nil {
+		t.Errorf("star: %v", err)
+	}
+	g := graph.New(4) // 0 -> 1 -> 2 -> 3, and 0 -> 3
+	g.AddEdge(0, 1)
+	g.AddEdge(1, 2)
+	g.AddEdge(2, 3)
+	g.AddEdge(0, 3)
+	if err := checkBcastTree(g); !errors.Is(err, errNotBcastTree) {
+		t.Errorf("a vertex of two parents: %v", err)
+	}
+	g = graph.New(3) // 0 -> 1, and 2
+	g.AddEdge(0, 1)
+	if err := checkBcastTree(g); !errors.Is(err, errNotBcastTree) {
+		t.Errorf("two roots: %v", err)
+	}
+}
@@ -1,26 +1,104 @@
 package session
 
 import (
+	"strings"
+	"time"
+
 	"github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/plan/graph"
 	"github.com/lsds/KungFu/srcs/go/utils/assert"
 )
 
 func (sess *Session) AllReduce(w base.Workspace) error {
-	return sess.runStrategies(w, plan.EvenPartition, sess.globalStrategies)
+	if err := checkHost(w); err != nil {
+		return err
+	}
+	if err := sess.allReduceParts(w); err != nil {
+		return err
+	}
+	return sess.postProcess(w)
+}
+
+func (sess *Session) allReduceParts(w base.Workspace) error {
+	if giant, err := runGiant(w, sess.allReduceParts); giant {
+		return err
+	}
+	if sess.skipSync(w) {
+		return nil
+	}
+	return sess.allReduce(w)
+}
+
+// allReduce runs the all reduce of w, which is at most maxMessageBytes, on every call.
+func (sess *Session) allReduce(w base.Workspace) error {
+	defer sess.track("all_reduce", w)()
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return err
+	}
+	if config.EnableResilience {
+		return sess.orLocal(w, func(w base.Workspace) func() error {
+			return func() error { return sess.allReduceResilient(w) }
+		})()
+	}
+	return sess.orLocal(w, sess.prepareAllReduce)()
+}
+
+// prepareAllReduce chooses the strategies of an all reduce on w, and returns the function running it.
+// The strategies are chosen in the order of collectives, which must be the same for all peers.
+func (sess *Session) prepareAllReduce(w base.Workspace) func() error {
+	if r, ok := sess.selection.lookup(w); ok {
+		return func() error { return sess.runSelected(w, r) }
+	}
+	sl := sess.nextGlobalStrategies()
+	codec := sess.codecFor(w)
+	run := func() error { return sess.runStrategies(w, plan.EvenPartition, sl) }
+	if codec != nil {
+		h := sess.getStrategyHash()
+		run = func() error { return sess.runEncodedStrategies(w, plan.EvenPartition, sl, h, codec) }
+	}
+	if sess.codecs == nil || !encodable(w) || sess.getCodec() == nil || strings.HasPrefix(w.Name, "kungfu::") {
+		return run
+	}
+	return func() error { // measures the choice of the codec selection
+		t0 := time.Now()
+		if err := run(); err != nil {
+			return err
+		}
+		sess.codecs.record(sess.linkClass(), w.RecvBuf.Count*w.RecvBuf.Type.Size(), codec != nil, time.Since(t0))
+		return nil
+	}
 }
 
 func (sess *Session) AllReduceWith(forest []int32, w base.Workspace) error {
+	if giant, err := runGiant(w, func(w base.Workspace) error { return sess.AllReduceWith(forest, w) }); giant {
+		return err
+	}
+	defer sess.track("all_reduce", w)()
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return err
+	}
 	bg, m, ok := graph.FromForestArrayI32(forest)
 	assert.True(m == 1)
 	assert.True(ok)
 	rg := plan.GenDefaultReduceGraph(bg)
-	s0 := strategy{reduceGraph: rg, bcastGraph: bg}
-	return sess.runStrategies(w, plan.EvenPartition, []strategy{s0})
+	s0 := strategy{name: "CUSTOM", reduceGraph: rg, bcastGraph: bg}
+	return sess.orLocal(w, func(w base.Workspace) func() error {
+		return func() error { return sess.runStrategies(w, plan.EvenPartition, []strategy{s0}) }
+	})()
 }
 
 // CrossAllReduce performs allreduce across all local roots.
 func (sess *Session) CrossAllReduce(w base.Workspace) error {
-	return sess.runStrategies(w, plan.EvenPartition, sess.crossStrategies)
+	if giant, err := runGiant(w, sess.CrossAllReduce); giant {
+		return err
+	}
+	defer sess.track("cross_all_reduce", w)()
+	if err := sess.checkOP(w, crossOPs); err != nil {
+		return err
+	}
+	return sess.orLocal(w, func(w base.Workspace) func() error {
+		return func() error { return sess.runStrategies(w, plan.EvenPartition, sess.crossStrategies) }
+	})()
 }
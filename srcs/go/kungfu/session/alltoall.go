@@ -0,0 +1,74 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// AllToAll sends the i-th slice of SendBuf to rank i, where splits[i] is the count of the slice,
+// and receives the slices sent to this peer into RecvBuf, ordered by the rank of the senders.
+// Peers may send slices of different counts, RecvBuf must have the total count of the slices received.
+func (sess *Session) AllToAll(w kb.Workspace, splits []int) error {
+	defer sess.track("all_to_all", w)()
+	return sess.runAllToAll(w, splits)
+}
+
+// runAllToAll runs the rounds of plan.GenPairwiseExchangeGraphs in order, so that a peer receives from one peer at a time.
+func (sess *Session) runAllToAll(w kb.Workspace, splits []int) error {
+	k := len(sess.peers)
+	if len(splits) != k {
+		return fmt.Errorf("AllToAll: %d splits for %d peers", len(splits), k)
+	}
+	offsets := make([]int, k+1)
+	for i, n := range splits {
+		if n < 0 {
+			return fmt.Errorf("AllToAll: negative split %d", n)
+		}
+		offsets[i+1] = offsets[i] + n
+	}
+	if offsets[k] != w.SendBuf.Count {
+		return fmt.Errorf("AllToAll: splits sum to %d, SendBuf has %d elements", offsets[k], w.SendBuf.Count)
+	}
+	name := sess.tagged(w.Name)
+	received := make([][]byte, k)
+	received[sess.rank] = w.SendBuf.Slice(offsets[sess.rank], offsets[sess.rank+1]).Data
+	for _, g := range plan.GenPairwiseExchangeGraphs(k) {
+		next, prev := g.Nexts(sess.rank)[0], g.Prevs(sess.rank)[0]
+		var sendErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			data := w.SendBuf.Slice(offsets[next], offsets[next+1]).Data
+			sendErr = sess.send(sess.peers[next].WithName(name), data, connection.NoFlag)
+			wg.Done()
+		}()
+		m, recvErr := sess.collectiveHandler.Recv(sess.peers[prev].WithName(name))
+		wg.Wait()
+		if sendErr != nil {
+			return sendErr
+		}
+		if recvErr != nil {
+			return recvErr
+		}
+		received[prev] = m.Data
+	}
+	var total int
+	for _, bs := range received {
+		total += len(bs)
+	}
+	if total != len(w.RecvBuf.Data) {
+		return fmt.Errorf("AllToAll: received %d bytes, RecvBuf has %d", total, len(w.RecvBuf.Data))
+	}
+	var offset int
+	for i, bs := range received {
+		offset += copy(w.RecvBuf.Data[offset:], bs)
+		if i != sess.rank {
+			connection.PutBuf(bs)
+		}
+	}
+	return nil
+}
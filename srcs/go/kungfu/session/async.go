@@ -0,0 +1,78 @@
+package session
+
+import (
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+// Handle is the completion of an asynchronous collective.
+type Handle struct {
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel which is closed when the collective finishes.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the collective to finish, and returns its error.
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Err returns the error of the collective, or nil if it succeeded or hasn't finished.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// AllReduceAsync starts an AllReduce on w, and returns without waiting for it, so that the communication
+// of a gradient overlaps with the backward computation of later layers. w must not be used until it finishes.
+// The collectives must still be started in the same order by all peers. The post hooks of w run before it finishes.
+func (sess *Session) AllReduceAsync(w kb.Workspace) (*Handle, error) {
+	if err := sess.collectiveHandler.Aborted(); err != nil {
+		return nil, err
+	}
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return nil, err
+	}
+	ws, err := giantParts(w)
+	if err != nil {
+		return nil, err
+	}
+	finish := sess.track("all_reduce", w)
+	if ws == nil {
+		ws = []kb.Workspace{w}
+	}
+	runs := make([]func() error, len(ws))
+	for i, w := range ws {
+		if sess.skipSync(w) {
+			runs[i] = func() error { return nil }
+		} else {
+			runs[i] = sess.orLocal(w, sess.prepareAllReduce)
+		}
+	}
+	run := func() error {
+		for _, run := range runs {
+			if err := run(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	h := &Handle{done: make(chan struct{})}
+	go func() {
+		h.err = run()
+		finish()
+		if h.err == nil {
+			h.err = sess.postProcess(w)
+		}
+		close(h.done)
+	}()
+	return h, nil
+}
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// candidate strategies of the auto-tuner, in a fixed order so that all peers agree on the indexes.
+var autoTuneCandidates = []kb.Strategy{
+	kb.Star,
+	kb.Ring,
+	kb.Clique,
+	kb.Tree,
+	kb.BinaryTree,
+	kb.BinaryTreeStar,
+	kb.MultiBinaryTreeStar,
+	kb.MultiRing,
+}
+
+// representative message sizes in bytes
+var autoTuneSizes = []int{
+	64 * 1024,
+	1 * Mi,
+	8 * Mi,
+}
+
+const autoTuneRounds = 3
+
+type tuneCandidate struct {
+	name       string
+	strategies strategyList
+	strategy   kb.Strategy // Auto for the current strategies
+}
+
+// AutoTune benchmarks AllReduce over the current global strategies and all built-in strategies,
+// and installs the fastest as the global strategies. It must be called by all peers.
+func (sess *Session) AutoTune() error {
+	current := sess.swap.latest()
+	cs := []tuneCandidate{{name: "CURRENT", strategies: current, strategy: kb.Auto}}
+	for _, s := range autoTuneCandidates {
+		cs = append(cs, tuneCandidate{
+			name:       s.String(),
+			strategies: named(s.String(), sess.genCandidateStrategyList(s)),
+			strategy:   s,
+		})
+	}
+	x := kb.NewVector(len(cs), kb.F32)
+	for i, c := range cs {
+		d, err := sess.benchmark(c)
+		if err != nil {
+			return err
+		}
+		x.AsF32()[i] = float32(d.Seconds())
+	}
+	// peers measure different durations, use the slowest
+	y := kb.NewVector(len(cs), kb.F32)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::autotune:result"}
+	if err := sess.runStrategies(w, plan.EvenPartition, current); err != nil {
+		return err
+	}
+	best := 0
+	for i, t := range y.AsF32() {
+		if sess.rank == defaultRoot {
+			sess.logger.Infof("autotune: %s took %.3fms", cs[i].name, t*1000)
+		}
+		if t < y.AsF32()[best] {
+			best = i
+		}
+	}
+	if sess.rank == defaultRoot {
+		sess.logger.Infof("autotune: using %s", cs[best].name)
+	}
+	if err := sess.SetGlobalStrategy(cs[best].strategies); err != nil {
+		return err
+	}
+	if s := cs[best].strategy; s != kb.Auto {
+		sess.Lock()
+		sess.strategyName = s
+		sess.registeredName = ""
+		sess.Unlock()
+	}
+	return sess.TuneSelectionTable()
+}
+
+func (sess *Session) benchmark(c tuneCandidate) (time.Duration, error) {
+	var total time.Duration
+	for _, size := range autoTuneSizes {
+		count := size / kb.F32.Size()
+		w := kb.Workspace{
+			SendBuf: kb.NewVector(count, kb.F32),
+			RecvBuf: kb.NewVector(count, kb.F32),
+			OP:      kb.SUM,
+			Name:    fmt.Sprintf("kungfu::autotune:%s:%d", c.name, size),
+		}
+		if err := sess.barrier(); err != nil {
+			return 0, err
+		}
+		t0 := time.Now()
+		for i := 0; i < autoTuneRounds; i++ {
+			if err := sess.runStrategies(w, plan.EvenPartition, c.strategies); err != nil {
+				return 0, err
+			}
+		}
+		total += time.Since(t0)
+	}
+	return total, nil
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/handler"
+)
+
+// BarrierTimeoutError is returned by BarrierTimeout if some peers didn't arrive in time,
+// e.g. for the caller to resize the cluster without them.
+type BarrierTimeoutError struct {
+	Timeout time.Duration
+	Missing plan.PeerList // the peers that didn't arrive, all other peers if rank 0 didn't answer
+}
+
+func (e *BarrierTimeoutError) Error() string {
+	return fmt.Sprintf("barrier timeout after %s: %d peers didn't arrive: %s", e.Timeout, len(e.Missing), e.Missing)
+}
+
+func (e *BarrierTimeoutError) FailureKind() failure.Kind {
+	return failure.Timeout
+}
+
+var errMessageTimeout = failure.New(failure.Timeout, "message timeout")
+
+// BarrierTimeout waits until all peers arrive, as Barrier, or until timeout. Each peer reports its arrival to rank 0,
+// which releases all peers once they have arrived, or at the timeout with the peers that didn't arrive.
+// It returns a *BarrierTimeoutError listing the peers that didn't arrive, on all peers that arrived.
+func (sess *Session) BarrierTimeout(timeout time.Duration) error {
+	sess.Lock()
+	defer sess.Unlock()
+	sess.barrierRounds++
+	name := "kungfu::barrier-timeout:" + strconv.Itoa(sess.barrierRounds)
+	deadline := time.Now().Add(timeout)
+	if sess.rank != defaultRoot {
+		return sess.arriveAtRoot(name, deadline, timeout)
+	}
+	arrived := make([]bool, len(sess.peers))
+	var wg sync.WaitGroup
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			arrived[rank] = true
+			continue
+		}
+		wg.Add(1)
+		go func(rank int, peer plan.PeerID) {
+			defer wg.Done()
+			_, err := sess.recvBefore(peer, name+":arrive", deadline)
+			arrived[rank] = err == nil
+		}(rank, peer)
+	}
+	wg.Wait()
+	var missing []byte // ranks of the peers that didn't arrive
+	for rank, ok := range arrived {
+		if !ok {
+			missing = binary.LittleEndian.AppendUint32(missing, uint32(rank))
+		}
+	}
+	for rank, peer := range sess.peers {
+		if arrived[rank] && rank != sess.rank {
+			go sess.send(peer.WithName(sess.tagged(name+":release")), missing, connection.NoFlag) // not waiting for peers that may fail after arriving
+		}
+	}
+	return sess.missingError(missing, timeout)
+}
+
+// arriveAtRoot reports the arrival of this peer to rank 0 and waits for the release by rank 0 until deadline.
+func (sess *Session) arriveAtRoot(name string, deadline time.Time, timeout time.Duration) error {
+	root := sess.peers[defaultRoot]
+	sent := make(chan error, 1)
+	go func() { sent <- sess.send(root.WithName(sess.tagged(name+":arrive")), nil, connection.NoFlag) }()
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case err := <-sent:
+		if err != nil {
+			return &BarrierTimeoutError{Timeout: timeout, Missing: plan.PeerList{root}}
+		}
+	case <-t.C:
+		return &BarrierTimeoutError{Timeout: timeout, Missing: plan.PeerList{root}}
+	}
+	m, err := sess.recvBefore(root, name+":release", deadline.Add(timeout)) // rank 0 releases at the deadline at the latest
+	if err != nil {
+		return &BarrierTimeoutError{Timeout: timeout, Missing: plan.PeerList{root}}
+	}
+	return sess.missingError(m.Data, timeout)
+}
+
+// missingError returns the error of the ranks of the peers that didn't arrive, nil if there are none.
+func (sess *Session) missingError(ranks []byte, timeout time.Duration) error {
+	if len(ranks) == 0 {
+		return nil
+	}
+	var missing plan.PeerList
+	for i := 0; i+4 <= len(ranks); i += 4 {
+		missing = append(missing, sess.peers[binary.LittleEndian.Uint32(ranks[i:])])
+	}
+	return &BarrierTimeoutError{Timeout: timeout, Missing: missing}
+}
+
+// recvBefore receives the named message from peer, or fails at deadline.
+// The receive is withdrawn at the deadline, and a message arriving later stays queued under its name, which is unique
+// to the round of the barrier.
+func (sess *Session) recvBefore(peer plan.PeerID, name string, deadline time.Time) (connection.Message, error) {
+	cancel := make(chan struct{})
+	t := time.AfterFunc(time.Until(deadline), func() { close(cancel) })
+	defer t.Stop()
+	m, err := sess.collectiveHandler.RecvCancel(peer.WithName(sess.tagged(name)), cancel)
+	if errors.Is(err, handler.ErrCancelled) {
+		return m, fmt.Errorf("%w: %s didn't arrive from %s", errMessageTimeout, name, peer)
+	}
+	return m, err
+}
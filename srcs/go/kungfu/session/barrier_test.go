@@ -0,0 +1,33 @@
+package session
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/handler"
+)
+
+func Test_recvBefore(t *testing.T) {
+	sess := &Session{collectiveHandler: handler.NewCollectiveEndpoint()}
+	peer := plan.PeerID{IPv4: 1, Port: 1}
+	n := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if _, err := sess.recvBefore(peer, "late", time.Now().Add(time.Millisecond)); !errors.Is(err, errMessageTimeout) {
+			t.Fatalf("unexpected error of a missing message: %v", err)
+		}
+	}
+	if got := runtime.NumGoroutine(); got > n {
+		t.Errorf("%d goroutines left after timeouts, %d before", got, n)
+	}
+	m := connection.Message{Length: 1, Data: []byte{7}}
+	if err := sess.collectiveHandler.Deliver(peer, sess.tagged("late"), &m, connection.NoFlag); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sess.recvBefore(peer, "late", time.Now().Add(time.Second)); err != nil || got.Data[0] != 7 {
+		t.Errorf("message arriving late is not received by the next receive: %v, %v", got.Data, err)
+	}
+}
@@ -0,0 +1,36 @@
+package session
+
+import (
+	"errors"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+var errInvalidBatchNormStats = errors.New("batch norm stats requires f32 buffers of 2 * channels + 1 elements")
+
+// BatchNormStats synchronizes batch norm statistics of all peers in a single small AllReduce.
+// w.SendBuf is [sum(c), sum of squares(c), count] of the local batch, and
+// w.RecvBuf becomes [mean(c), biased variance(c), total count] of the global batch.
+func (sess *Session) BatchNormStats(w kb.Workspace) error {
+	n := w.SendBuf.Count
+	if w.SendBuf.Type != kb.F32 || w.RecvBuf.Type != kb.F32 || n%2 != 1 || w.RecvBuf.Count != n {
+		return errInvalidBatchNormStats
+	}
+	y := kb.NewVector(n, kb.F32)
+	sum := kb.Workspace{SendBuf: w.SendBuf, RecvBuf: y, OP: kb.SUM, Name: w.Name}
+	if err := sess.runStrategies(sum, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	c := n / 2
+	s := y.AsF32()
+	r := w.RecvBuf.AsF32()
+	count := s[2*c]
+	for i := 0; i < c; i++ {
+		mean := s[i] / count
+		r[i] = mean
+		r[c+i] = s[c+i]/count - mean*mean
+	}
+	r[2*c] = count
+	return nil
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// noCachedHash is proposed by peers without a cached value, it never equals a content hash.
+const noCachedHash int64 = -1
+
+var errBroadcastSizeMismatch = failure.New(failure.ProtocolMismatch, "peers broadcast different sizes")
+
+type cachedValue struct {
+	hash int64
+	data []byte
+}
+
+// broadcastCache holds the values received by CachedBroadcast, by name.
+type broadcastCache struct {
+	sync.Mutex
+	values map[string]*cachedValue
+}
+
+func newBroadcastCache() *broadcastCache {
+	return &broadcastCache{values: make(map[string]*cachedValue)}
+}
+
+func (c *broadcastCache) get(name string) *cachedValue {
+	c.Lock()
+	defer c.Unlock()
+	return c.values[name]
+}
+
+func (c *broadcastCache) put(name string, v *cachedValue) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[name] = v
+}
+
+func (c *broadcastCache) invalidate(name string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.values, name)
+}
+
+func contentHash(b []byte) int64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return int64(h.Sum64() >> 1)
+}
+
+// CachedBroadcast is Broadcast that skips the transfer if all peers have cached the value of the root,
+// e.g. for frozen embeddings or configs broadcast repeatedly.
+// Changes of the value on the root are detected by the content hash, and a value of another size is transferred.
+func (sess *Session) CachedBroadcast(w kb.Workspace) error {
+	sl := sess.nextGlobalStrategies()
+	strategy := sl[0]
+	isRoot := len(strategy.bcastGraph.Nodes[sess.rank].Prevs) == 0
+	cached := sess.bcastCache.get(w.Name)
+	if cached != nil && len(cached.data) != len(w.RecvBuf.Data) {
+		sess.bcastCache.invalidate(w.Name) // the value of another size is not cached
+		cached = nil
+	}
+	var h int64
+	if isRoot {
+		h = contentHash(w.SendBuf.Data)
+	} else if cached != nil {
+		h = cached.hash
+	} else {
+		h = noCachedHash
+	}
+	size := int64(len(w.RecvBuf.Data))
+	x := kb.NewVector(4, kb.I64)
+	y := kb.NewVector(4, kb.I64)
+	copy(x.AsI64(), []int64{h, -h, size, -size})
+	cw := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::bcast-cache:" + w.Name}
+	if err := sess.runStrategies(cw, plan.EvenPartition, sl); err != nil {
+		return err
+	}
+	r := y.AsI64()
+	if r[2] != -r[3] {
+		sess.bcastCache.invalidate(w.Name)
+		return fmt.Errorf("%w: %s of %d to %d bytes", errBroadcastSizeMismatch, w.Name, -r[3], r[2])
+	}
+	if r[0] == -r[1] { // all peers proposed the same hash
+		if isRoot {
+			w.Forward()
+		} else {
+			copy(w.RecvBuf.Data, cached.data)
+		}
+		return nil
+	}
+	if err := sess.runGraphs(w, strategy.bcastGraph); err != nil {
+		return err
+	}
+	if !isRoot {
+		data := make([]byte, len(w.RecvBuf.Data))
+		copy(data, w.RecvBuf.Data)
+		sess.bcastCache.put(w.Name, &cachedValue{hash: contentHash(data), data: data})
+	}
+	return nil
+}
+
+// InvalidateBroadcast drops the cached value of name, so that the next CachedBroadcast of name transfers the value.
+// It can be called by any peer, e.g. after writing to the received value.
+func (sess *Session) InvalidateBroadcast(name string) {
+	sess.bcastCache.invalidate(name)
+}
@@ -0,0 +1,56 @@
+package session_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+)
+
+func Test_CachedBroadcast(t *testing.T) {
+	c := startCluster(t)
+	bcast := func(values ...float32) error {
+		return c.Run(func(rank int, sess *session.Session) error {
+			x := f32s(values...)
+			if rank != 0 {
+				x = kb.NewVector(len(values), kb.F32)
+			}
+			if err := sess.CachedBroadcast(kb.Workspace{SendBuf: x, RecvBuf: x, Name: "cached"}); err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(x.AsF32(), values) {
+				return fmt.Errorf("received %v, want %v", x.AsF32(), values)
+			}
+			return nil
+		})
+	}
+	for i, values := range [][]float32{
+		{1, 2},
+		{1, 2}, // cached
+		{3, 4}, // changed on the root
+		{3, 4, 5},
+		{3},
+		{3}, // cached
+	} {
+		if err := bcast(values...); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
+
+func Test_CachedBroadcastSizeMismatch(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		x := kb.NewVector(1+rank%2, kb.F32)
+		if err := sess.CachedBroadcast(kb.Workspace{SendBuf: x, RecvBuf: x, Name: "mismatch"}); failure.Of(err) != failure.ProtocolMismatch {
+			return fmt.Errorf("broadcast of different sizes: %v, want a protocol mismatch", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,149 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// Values of a link in the agreement of CheckLinkBreakers, merged by MAX.
+const (
+	breakerKeep   = 0
+	breakerOpen   = 1 // a peer failed on the link more than the retry budget
+	breakerClosed = 2 // the sender of an open link probed it successfully
+)
+
+// linkBreakers are the circuit breakers of the links between peers.
+// A peer counts the consecutive failures of its own links, a link is open once all peers agree that a peer
+// has failed on it more than the retry budget, and the strategies using an open link are not used.
+// The sender of an open link probes it periodically, and the link is closed if the probe succeeds.
+type linkBreakers struct {
+	sync.Mutex
+	budget   int
+	failures map[linkKey]int       // consecutive failures observed by this peer
+	open     map[linkKey]time.Time // when the link was opened or last probed
+}
+
+func newLinkBreakers(budget int) *linkBreakers {
+	return &linkBreakers{
+		budget:   budget,
+		failures: make(map[linkKey]int),
+		open:     make(map[linkKey]time.Time),
+	}
+}
+
+func (b *linkBreakers) fail(l linkKey) {
+	b.Lock()
+	defer b.Unlock()
+	b.failures[l]++
+}
+
+func (b *linkBreakers) succeed(l linkKey) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.failures, l)
+}
+
+// chunkDone records the result of a chunk on s at rank, against the links of s from which rank receives.
+func (b *linkBreakers) chunkDone(rank int, s strategy, ok bool) {
+	for _, g := range s.graphs() {
+		for _, prev := range g.Prevs(rank) {
+			if ok {
+				b.succeed(linkKey{prev, rank})
+			} else {
+				b.fail(linkKey{prev, rank})
+			}
+		}
+	}
+}
+
+func (b *linkBreakers) isOpen(l linkKey) bool {
+	b.Lock()
+	defer b.Unlock()
+	_, ok := b.open[l]
+	return ok
+}
+
+// sendFailed records the failure of sending to peer, and returns err.
+func (sess *Session) sendFailed(peer plan.PeerID, err error) error {
+	if rank, ok := sess.peers.Rank(peer); ok {
+		sess.breakers.fail(linkKey{sess.rank, rank})
+	}
+	return err
+}
+
+// CheckLinkBreakers opens the circuit breakers of the links failed by any peer more than config.LinkRetryBudget times,
+// and closes those which recovered, it must be called by all peers, e.g. at step boundaries.
+// The strategies using an open link are marked broken and are not used, unless all active strategies are broken.
+func (sess *Session) CheckLinkBreakers() error {
+	b := sess.breakers
+	if b.budget <= 0 {
+		return nil
+	}
+	k := len(sess.peers)
+	x := kb.NewVector(k*k, kb.I8)
+	var probes []linkKey
+	b.Lock()
+	for l, n := range b.failures {
+		if _, open := b.open[l]; !open && n > b.budget {
+			x.AsI8()[l.from*k+l.to] = breakerOpen
+		}
+	}
+	for l, t := range b.open {
+		if l.from == sess.rank && time.Since(t) >= config.LinkProbePeriod {
+			probes = append(probes, l)
+			b.open[l] = time.Now()
+		}
+	}
+	b.Unlock()
+	for _, l := range probes {
+		if _, err := sess.client.Ping(sess.peers[l.to]); err == nil {
+			x.AsI8()[l.from*k+l.to] = breakerClosed
+		}
+	}
+	y := kb.NewVector(k*k, kb.I8)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::link-breakers"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.swap.latest()); err != nil {
+		return err
+	}
+	var changed bool
+	b.Lock()
+	for i, v := range y.AsI8() {
+		l := linkKey{i / k, i % k}
+		switch v {
+		case breakerOpen:
+			sess.logger.Warnf("opened the circuit breaker of link %d -> %d", l.from, l.to)
+			b.open[l] = time.Now()
+		case breakerClosed:
+			sess.logger.Infof("closed the circuit breaker of link %d -> %d", l.from, l.to)
+			delete(b.open, l)
+		default:
+			continue
+		}
+		delete(b.failures, l)
+		changed = true
+	}
+	b.Unlock()
+	if !changed {
+		return nil
+	}
+	latest := sess.swap.latest()
+	sl := make(strategyList, len(latest))
+	copy(sl, latest)
+	for i := range sl {
+		sl[i].broken = false
+		for _, g := range sl[i].graphs() {
+			for a := range g.Nodes {
+				for _, c := range g.Nexts(a) {
+					if b.isOpen(linkKey{a, c}) {
+						sl[i].broken = true
+					}
+				}
+			}
+		}
+	}
+	return sess.SwapGlobalStrategy(sl)
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+var (
+	errDeviceBuffer = errors.New("device buffers can only be broadcast in place, collectives reduce and copy on the host")
+	errNoGPUDirect  = errors.New("device buffers can only be broadcast if all peers agreed on GPUDirect RDMA")
+)
+
+// ownedBuffers are the buffers registered by RegisterBuffers, by the address of their first byte,
+// with the functions deregistering them.
+type ownedBuffers struct {
+	sync.Mutex
+	deregister map[*byte]func()
+}
+
+// RegisterBuffers registers bufs if the transport of the session sends registered buffers without a copy, i.e. RDMA,
+// e.g. the buffers of the gradients that are all reduced in every step. A buffer is registered once, when it's
+// passed for the first time, and is owned by the session, and by the later sessions of the peer, until it's released
+// by ReleaseBuffers. The messages of collectives in other buffers are copied by the transport, as a buffer reused
+// for other data after it's freed must not be sent from a stale registration.
+func (sess *Session) RegisterBuffers(bufs ...[]byte) {
+	sess.buffers.Lock()
+	defer sess.buffers.Unlock()
+	if sess.buffers.deregister == nil {
+		sess.buffers.deregister = make(map[*byte]func())
+	}
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		if _, ok := sess.buffers.deregister[&b[0]]; !ok {
+			sess.buffers.deregister[&b[0]] = connection.RegisterBuffer(sess.client.Transport(), b)
+		}
+	}
+}
+
+// ReleaseBuffers deregisters bufs, or all buffers registered by RegisterBuffers if there are none.
+func (sess *Session) ReleaseBuffers(bufs ...[]byte) {
+	sess.buffers.Lock()
+	defer sess.buffers.Unlock()
+	if len(bufs) == 0 {
+		for p, deregister := range sess.buffers.deregister {
+			deregister()
+			delete(sess.buffers.deregister, p)
+		}
+		return
+	}
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		if deregister, ok := sess.buffers.deregister[&b[0]]; ok {
+			deregister()
+			delete(sess.buffers.deregister, &b[0])
+		}
+	}
+}
+
+// InheritBuffers takes over the buffers registered by the previous session of this peer.
+func (sess *Session) InheritBuffers(old *Session) {
+	old.buffers.Lock()
+	defer old.buffers.Unlock()
+	sess.buffers.Lock()
+	defer sess.buffers.Unlock()
+	sess.buffers.deregister, old.buffers.deregister = old.buffers.deregister, nil
+}
+
+// RegisterDeviceBuffer registers size bytes of device memory at ptr for GPUDirect RDMA, by the dma-buf of dmabufFD
+// at offset if it's not negative, see connection.RegisterDeviceBuffer, and returns the memory as a slice.
+// The memory is owned by the session as the buffers of RegisterBuffers. Collectives reduce and copy their buffers
+// on the host, so the slice may only be broadcast in place after GPUDirect returned true, and other collectives
+// fail on it; the GPU integration reduces device tensors by NCCL, or stages them in host memory, instead.
+func (sess *Session) RegisterDeviceBuffer(ptr unsafe.Pointer, size int, dmabufFD int, offset uint64) ([]byte, error) {
+	deregister, b, err := connection.RegisterDeviceBuffer(sess.client.Transport(), ptr, size, dmabufFD, offset)
+	if err != nil {
+		return nil, err
+	}
+	sess.buffers.Lock()
+	defer sess.buffers.Unlock()
+	if sess.buffers.deregister == nil {
+		sess.buffers.deregister = make(map[*byte]func())
+	}
+	if _, ok := sess.buffers.deregister[&b[0]]; ok {
+		deregister()
+	} else {
+		sess.buffers.deregister[&b[0]] = deregister
+	}
+	return b, nil
+}
+
+// gpuDirect is the agreement of GPUDirect, which is made once per session.
+type gpuDirect struct {
+	sync.Once
+	ok  atomic.Bool // read by checkDeviceBroadcast without the Once
+	err error
+}
+
+// GPUDirect returns true if all peers send device memory registered by RegisterDeviceBuffer by GPUDirect RDMA,
+// so that device tensors can be broadcast without staging them in host memory. It must be called by all peers.
+// Colocated peers are connected by unix sockets and TLS connections are not RDMA, so either disables it.
+func (sess *Session) GPUDirect() (bool, error) {
+	sess.gpuDirect.Do(func() {
+		var local int64
+		if connection.GPUDirect(sess.client.Transport()) && !connection.EnableTLS() && sess.hostCount == len(sess.peers) {
+			local = 1
+		}
+		x := kb.NewVector(1, kb.I64)
+		y := kb.NewVector(1, kb.I64)
+		x.AsI64()[0] = local
+		w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: "kungfu::gpudirect"}
+		sess.gpuDirect.err = sess.runStrategies(w, plan.EvenPartition, createStarStrategies(sess.peers))
+		sess.gpuDirect.ok.Store(sess.gpuDirect.err == nil && y.AsI64()[0] == 1)
+	})
+	return sess.gpuDirect.ok.Load(), sess.gpuDirect.err
+}
+
+func isDevice(v *kb.Vector) bool {
+	return v != nil && connection.IsDeviceBuffer(v.Data)
+}
+
+// checkHost fails if a buffer of w is device memory, which collectives can't reduce or copy.
+func checkHost(w kb.Workspace) error {
+	if isDevice(w.SendBuf) || isDevice(w.RecvBuf) {
+		return errDeviceBuffer
+	}
+	return nil
+}
+
+// checkDeviceBroadcast fails if w has a device buffer, unless it's broadcast in place after the peers agreed on GPUDirect.
+func (sess *Session) checkDeviceBroadcast(w kb.Workspace) error {
+	if !isDevice(w.SendBuf) && !isDevice(w.RecvBuf) {
+		return nil
+	}
+	if w.IsEmpty() || !w.IsInplace() {
+		return errDeviceBuffer
+	}
+	if !sess.gpuDirect.ok.Load() {
+		return errNoGPUDirect
+	}
+	return nil
+}
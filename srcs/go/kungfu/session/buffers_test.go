@@ -0,0 +1,40 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+func Test_ownedBuffers(t *testing.T) {
+	a, b := make([]byte, 16), make([]byte, 16)
+	c := client.NewWithTransport(plan.PeerID{}, false, connection.TransportTCP)
+	old, sess := Session{client: c}, Session{client: c}
+	old.RegisterBuffers(a, b, a, nil)
+	if n := len(old.buffers.deregister); n != 2 {
+		t.Fatalf("%d buffers registered, want 2", n)
+	}
+	sess.InheritBuffers(&old)
+	if len(old.buffers.deregister) != 0 || len(sess.buffers.deregister) != 2 {
+		t.Errorf("buffers should be taken over by the new session")
+	}
+	sess.ReleaseBuffers(a)
+	if _, ok := sess.buffers.deregister[&b[0]]; !ok || len(sess.buffers.deregister) != 1 {
+		t.Errorf("only a should be released")
+	}
+	sess.ReleaseBuffers()
+	if len(sess.buffers.deregister) != 0 {
+		t.Errorf("all buffers should be released")
+	}
+}
+
+func Test_sessionTransport(t *testing.T) {
+	for _, transport := range []string{connection.TransportTCP, connection.TransportRDMA} {
+		sess := Session{client: client.NewWithTransport(plan.PeerID{}, false, transport)}
+		if got := sess.LocalFingerprint()[`transport`]; got != transport {
+			t.Errorf("fingerprint of transport %s is %s", transport, got)
+		}
+	}
+}
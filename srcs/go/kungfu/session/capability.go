@@ -0,0 +1,82 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const maxCapabilityLen = 512
+
+// SetCapabilities exchanges the capability of each peer, so that they are available to all peers by Peers.
+// If the NICs of hosts have different speeds, it switches the global strategy to a bandwidth-aware tree.
+func (sess *Session) SetCapabilities(c plan.Capability) error {
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if len(bs) > maxCapabilityLen {
+		return fmt.Errorf("capability is longer than %d bytes", maxCapabilityLen)
+	}
+	k := len(sess.peers)
+	x := kb.NewVector(maxCapabilityLen, kb.U8)
+	copy(x.Data, bs)
+	y := kb.NewVector(maxCapabilityLen*k, kb.U8)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: "kungfu::capabilities"}
+	if err := sess.AllGather(w); err != nil {
+		return err
+	}
+	caps := make([]plan.Capability, k)
+	for i := range caps {
+		bs := bytes.TrimRight(y.Data[i*maxCapabilityLen:(i+1)*maxCapabilityLen], "\x00")
+		if err := json.Unmarshal(bs, &caps[i]); err != nil {
+			return err
+		}
+	}
+	sess.Lock()
+	sess.capabilities = caps
+	sess.Unlock()
+	speeds := plan.NICSpeeds(caps)
+	distinct := make(map[int]struct{})
+	for _, s := range speeds {
+		distinct[s] = struct{}{}
+	}
+	if len(distinct) <= 1 {
+		return nil
+	}
+	sess.logger.Debugf("peers have %d different NIC speeds, using bandwidth aware strategy", len(distinct))
+	bcastGraph := plan.GenBandwidthAwareBinaryTreeStar(sess.peers, speeds)
+	return sess.SetGlobalStrategy(named("BANDWIDTH_AWARE_BINARY_TREE_STAR", strategyList{simpleStrategy(bcastGraph)}))
+}
+
+// PeerInfo describes a peer of the session to operators.
+type PeerInfo struct {
+	Rank       int              `json:"rank"`
+	ID         string           `json:"id"`
+	Capability *plan.Capability `json:"capability,omitempty"`
+}
+
+// Peers returns all peers of the session, with their capabilities if SetCapabilities has been called.
+func (sess *Session) Peers() []PeerInfo {
+	sess.Lock()
+	defer sess.Unlock()
+	infos := make([]PeerInfo, len(sess.peers))
+	for i, p := range sess.peers {
+		infos[i] = PeerInfo{Rank: i, ID: p.String()}
+		if sess.capabilities != nil {
+			c := sess.capabilities[i]
+			infos[i].Capability = &c
+		}
+	}
+	return infos
+}
+
+// Capabilities returns the capabilities of all peers, or nil if SetCapabilities has not been called.
+func (sess *Session) Capabilities() []plan.Capability {
+	sess.Lock()
+	defer sess.Unlock()
+	return sess.capabilities
+}
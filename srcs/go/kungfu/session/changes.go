@@ -0,0 +1,154 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// A Change sets a cluster-wide setting, e.g. the learning rate or the codec, to Value from Step on.
+type Change struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	Step  int64  `json:"step"`
+}
+
+var errChangeRejected = failure.New(failure.ProtocolMismatch, "change rejected") // peers disagree on the change
+
+// changeLog holds the committed changes of each key, sorted by step, of which only the latest in effect is kept.
+type changeLog struct {
+	sync.Mutex
+	changes map[string][]Change
+}
+
+func (l *changeLog) add(c Change) {
+	l.Lock()
+	defer l.Unlock()
+	if l.changes == nil {
+		l.changes = make(map[string][]Change)
+	}
+	cs := append(l.changes[c.Key], c)
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].Step < cs[j].Step })
+	l.changes[c.Key] = cs
+}
+
+// get returns the latest change of key in effect at step, and drops the earlier ones.
+func (l *changeLog) get(key string, step int64) (*Change, bool) {
+	l.Lock()
+	defer l.Unlock()
+	cs := l.changes[key]
+	i := sort.Search(len(cs), func(i int) bool { return cs[i].Step > step })
+	if i == 0 {
+		return nil, false
+	}
+	cs = cs[i-1:]
+	l.changes[key] = cs
+	return &cs[0], true
+}
+
+func (l *changeLog) all() []Change {
+	l.Lock()
+	defer l.Unlock()
+	var keys []string
+	for k := range l.changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var cs []Change
+	for _, k := range keys {
+		cs = append(cs, l.changes[k]...)
+	}
+	return cs
+}
+
+// ProposeChange sets key to value on all peers from the given step on, by a two-phase commit:
+// peers first agree on the change and vote that none of them has reached the step, then each commits it,
+// so that it takes effect by the synchronized step counter, and no step runs with peers disagreeing on the setting.
+// It must be called by all peers with the same change. The change is rejected on all peers if any peer proposes
+// a different change, or has already reached the step.
+func (sess *Session) ProposeChange(key string, value []byte, step int64) error {
+	c := Change{Key: key, Value: value, Step: step}
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	ok, err := sess.BytesConsensus(bs, "kungfu::change:"+key)
+	if err != nil {
+		return err
+	}
+	x := kb.NewVector(1, kb.I8)
+	y := kb.NewVector(1, kb.I8)
+	x.AsI8()[0] = boolToInt8(ok && sess.Step() < step)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: "kungfu::change:vote:" + key}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: peers proposed different changes of %s", errChangeRejected, key)
+	}
+	if y.AsI8()[0] == 0 {
+		return fmt.Errorf("%w: some peer has reached step %d of %s", errChangeRejected, step, key)
+	}
+	sess.changes.add(c)
+	return nil
+}
+
+// Setting returns the value of key in effect at the current step, and false if it was never changed.
+func (sess *Session) Setting(key string) ([]byte, bool) {
+	c, ok := sess.changes.get(key, sess.Step())
+	if !ok {
+		return nil, false
+	}
+	return c.Value, true
+}
+
+// InheritChanges copies the committed changes of the previous session of this peer.
+func (sess *Session) InheritChanges(old *Session) {
+	for _, c := range old.changes.all() {
+		sess.changes.add(c)
+	}
+}
+
+// SyncChanges replaces the committed changes of all peers by those of rank 0, so that new peers catch up with existing ones.
+// It must be called by all peers after SyncProgress.
+func (sess *Session) SyncChanges() error {
+	bs, err := json.Marshal(sess.changes.all())
+	if err != nil {
+		return err
+	}
+	if bs, err = sess.broadcastBytes(bs, "kungfu::changes"); err != nil {
+		return err
+	}
+	var cs []Change
+	if err := json.Unmarshal(bs, &cs); err != nil {
+		return err
+	}
+	var l changeLog
+	for _, c := range cs {
+		l.add(c)
+	}
+	sess.changes.Lock()
+	defer sess.changes.Unlock()
+	sess.changes.changes = l.changes
+	return nil
+}
+
+// broadcastBytes returns bs of rank 0 on all peers, which may pass bs of different sizes.
+func (sess *Session) broadcastBytes(bs []byte, name string) ([]byte, error) {
+	x := kb.NewVector(1, kb.I32)
+	x.AsI32()[0] = int32(len(bs))
+	if err := sess.Broadcast(kb.Workspace{SendBuf: x, RecvBuf: x, Name: name + ":len"}); err != nil {
+		return nil, err
+	}
+	y := kb.NewVector(int(x.AsI32()[0]), kb.U8)
+	copy(y.Data, bs)
+	if err := sess.Broadcast(kb.Workspace{SendBuf: y, RecvBuf: y, Name: name}); err != nil {
+		return nil, err
+	}
+	return y.Data, nil
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+)
+
+// Bounds of the tuned chunk sizes.
+const (
+	minChunkSize = 64 << 10
+	maxChunkSize = 64 * Mi
+)
+
+// chunkTuningTolerance is the relative change of throughput taken as noise, the chunk size of a class is kept
+// while its throughput changes by less.
+const chunkTuningTolerance = 0.05
+
+// defaultChunkClasses are the max bytes of the message size classes of chunk tuning, if config.ChunkSizes is not set.
+var defaultChunkClasses = []int{4 * Mi, 32 * Mi, 256 * Mi, 0}
+
+// chunkTuning is the state of tuning the chunk size of a class.
+type chunkTuning struct {
+	shrink bool    // the direction of the next change
+	last   float64 // bytes per second at the previous tuning, 0 if it's not tuned
+}
+
+// chunkTuner keeps the chunk size of each message size class, which replaces config.ChunkSize in chunking collectives.
+type chunkTuner struct {
+	sizes  *sizeTuner
+	tuning []chunkTuning // of each class, only used by TuneChunkSizes
+}
+
+func newChunkTunerOf(classes []sizeClass) *chunkTuner {
+	return &chunkTuner{sizes: newSizeTuner("chunk-tuning", 1, classes), tuning: make([]chunkTuning, len(classes))}
+}
+
+// newChunkTuner returns the chunk tuner of config.ChunkSizes, or of config.ChunkSize for defaultChunkClasses if it's not set.
+// It returns nil if there are neither chunk sizes nor chunk tuning, so that collectives are chunked by config.ChunkSize.
+func newChunkTuner() (*chunkTuner, error) {
+	if val := config.ChunkSizes; len(val) > 0 {
+		classes, err := parseChunkSizes(val)
+		if err != nil {
+			return nil, err
+		}
+		return newChunkTunerOf(classes), nil
+	}
+	if config.ChunkTuningInterval <= 0 {
+		return nil, nil
+	}
+	var classes []sizeClass
+	for _, n := range defaultChunkClasses {
+		classes = append(classes, sizeClass{maxBytes: n, value: config.ChunkSize})
+	}
+	return newChunkTunerOf(classes), nil
+}
+
+// parseChunkSizes parses a comma separated list of <max bytes>:<chunk bytes>, in increasing order of sizes,
+// the last one can be *:<chunk bytes> for no limit.
+func parseChunkSizes(val string) ([]sizeClass, error) {
+	return parseSizeClasses(val, "chunk size", func(v string) (int, bool) {
+		n, err := strconv.Atoi(v)
+		return n, err == nil && n > 0
+	})
+}
+
+func (t *chunkTuner) String() string {
+	return formatSizeClasses(t.sizes.latest(), strconv.Itoa)
+}
+
+// chunkSize returns the chunk size of messages of n bytes, of the collectives of the given step.
+func (t *chunkTuner) chunkSize(step int64, n int) int {
+	if t == nil {
+		return config.ChunkSize
+	}
+	return t.sizes.value(step, n, config.ChunkSize)
+}
+
+// record adds a collective of messages of n bytes, which took d, i.e. the duration of its slowest chunk.
+func (t *chunkTuner) record(n int, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.sizes.record(n, 0, d)
+}
+
+// update doubles or halves the chunk size of each class by its throughput in bytes per second, 0 for an idle class.
+// A class keeps changing its chunk size in the same direction while the throughput increases, turns back when it
+// decreases, and stays while it changes within chunkTuningTolerance. It returns true if any chunk size changed.
+func (t *chunkTuner) update(classes []sizeClass, throughputs [][]float64) bool {
+	var changed bool
+	for i := range classes {
+		c, s := &classes[i], &t.tuning[i]
+		tp := throughputs[i][0]
+		if tp <= 0 {
+			continue
+		}
+		last := s.last
+		s.last = tp
+		if last > 0 {
+			if tp < last*(1-chunkTuningTolerance) {
+				s.shrink = !s.shrink
+			} else if tp <= last*(1+chunkTuningTolerance) {
+				continue
+			}
+		}
+		size := c.value * 2
+		if s.shrink {
+			size = c.value / 2
+		} else if c.maxBytes > 0 && c.value >= c.maxBytes {
+			size = c.value // messages of the class have a single chunk already
+		}
+		if size < minChunkSize {
+			size = minChunkSize
+		}
+		if size > maxChunkSize {
+			size = maxChunkSize
+		}
+		if size != c.value {
+			c.value = size
+			changed = true
+		}
+	}
+	return changed
+}
+
+// TuneChunkSizes tunes the chunk size of each message size class every config.ChunkTuningInterval calls, e.g. steps,
+// by the throughput of its collectives since the previous tuning, see chunkTuner.update and Session.tuneSizes.
+// The tuned chunk sizes are used from the next step of all peers, so that they chunk the collectives of a step the same
+// way. They are kept in config.ChunkSizes, so that the next sessions of the peer start from them, and the peer
+// publishes them to its runner, so that the workers it starts later start from them. It must be called by all peers,
+// at the same point of their collectives, e.g. at step boundaries. It does nothing if config.ChunkTuningInterval
+// is not positive.
+func (sess *Session) TuneChunkSizes() error {
+	t := sess.chunks
+	if t == nil || config.ChunkTuningInterval <= 0 {
+		return nil
+	}
+	changed, err := sess.tuneSizes(t.sizes, config.ChunkTuningInterval, t.update)
+	if err != nil || !changed {
+		return err
+	}
+	sess.plans.clear()
+	config.ChunkSizes = t.String()
+	if sess.rank == defaultRoot {
+		sess.logger.Infof("chunk sizes are tuned to %s", config.ChunkSizes)
+	}
+	return nil
+}
+
+// SyncChunkSizes sets the chunk sizes of all peers to those of rank 0, e.g. for peers joining a session
+// after the chunk sizes have been tuned. It must be called by all peers.
+func (sess *Session) SyncChunkSizes() error {
+	bs, err := sess.broadcastBytes([]byte(config.ChunkSizes), "kungfu::chunk-sizes")
+	if err != nil {
+		return err
+	}
+	if val := string(bs); val != config.ChunkSizes {
+		config.ChunkSizes = val
+		t, err := newChunkTuner()
+		if err != nil {
+			return err
+		}
+		sess.chunks = t
+		sess.plans.clear()
+	}
+	return nil
+}
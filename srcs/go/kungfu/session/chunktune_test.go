@@ -0,0 +1,53 @@
+package session
+
+import (
+	"testing"
+)
+
+func Test_parseChunkSizes(t *testing.T) {
+	const val = `4194304:1048576,*:8388608`
+	classes, err := parseChunkSizes(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := newChunkTunerOf(classes)
+	if got := ct.String(); got != val {
+		t.Errorf("unexpected chunk sizes %s", got)
+	}
+	if got := ct.chunkSize(0, 4*Mi); got != 1*Mi {
+		t.Errorf("chunk size of 4 MiB: %d", got)
+	}
+	if got := ct.chunkSize(0, 4*Mi+1); got != 8*Mi {
+		t.Errorf("chunk size of 4 MiB + 1: %d", got)
+	}
+	for _, val := range []string{`1024:512,512:256`, `*:1024,1024:512`, `1024:0`, `1024`} {
+		if _, err := parseChunkSizes(val); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+}
+
+func Test_chunkTunerUpdate(t *testing.T) {
+	ct := newChunkTunerOf([]sizeClass{{maxBytes: 0, value: 1 * Mi}})
+	classes := ct.sizes.latest()
+	for _, c := range []struct {
+		throughput float64
+		chunkSize  int
+	}{
+		{100, 2 * Mi}, // first tuning grows
+		{120, 4 * Mi}, // better, keep growing
+		{90, 2 * Mi},  // worse, turn back
+		{91, 2 * Mi},  // within tolerance, stay
+		{80, 4 * Mi},  // worse, turn back
+		{0, 4 * Mi},   // idle
+	} {
+		ct.update(classes, [][]float64{{c.throughput}})
+		if got := classes[0].value; got != c.chunkSize {
+			t.Errorf("after throughput %.0f: chunk size %d, want %d", c.throughput, got, c.chunkSize)
+		}
+	}
+	ct = newChunkTunerOf([]sizeClass{{maxBytes: 1 * Mi, value: 1 * Mi}, {value: maxChunkSize}})
+	if ct.update(ct.sizes.latest(), [][]float64{{100}, {100}}) {
+		t.Errorf("chunk sizes should stay at a single chunk per message and at the max")
+	}
+}
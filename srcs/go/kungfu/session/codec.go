@@ -0,0 +1,378 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/compress"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+)
+
+// A Codec encodes the messages of an all reduce on the wire, e.g. to compress gradients.
+// It is applied to the all reduce of F32 tensors by SUM, where a peer decodes each message it receives before reducing it.
+// A lossy codec makes the result approximate, but all peers still get the same result,
+// because the broadcast forwards the message encoded by its root as is.
+// Encode and Decode may be called concurrently on different chunks.
+type Codec interface {
+	Name() string
+	Encode(buf *kb.Vector) []byte
+	Decode(data []byte, buf *kb.Vector) error // decodes data into buf, which has the size of the encoded vector
+}
+
+// Names of the built-in codecs.
+const (
+	FP16Codec = `FP16` // casts to half precision
+	TopKCodec = `TOPK` // sends the 1% of elements of the largest magnitude
+	QSGDCodec = `QSGD` // quantizes to 8 bits by stochastic rounding
+)
+
+var errInvalidEncoding = failure.New(failure.ProtocolMismatch, "invalid encoding")
+
+var (
+	codecsMu sync.Mutex
+	codecs   = map[string]Codec{
+		FP16Codec: fp16Codec{},
+		TopKCodec: NewTopKCodec(TopKCodec, 0.01),
+		QSGDCodec: NewQSGDCodec(QSGDCodec, 127),
+	}
+)
+
+// RegisterCodec makes a codec available by its name, it is intended to be called from init functions.
+// The codec is selected by setting KUNGFU_CONFIG_CODEC to its name, or by SetCodec.
+// It panics if the name is empty or is already registered.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if c == nil || len(c.Name()) == 0 {
+		panic("RegisterCodec: nil codec or empty name")
+	}
+	if _, dup := codecs[c.Name()]; dup {
+		panic("RegisterCodec: " + c.Name() + " is registered twice")
+	}
+	codecs[c.Name()] = c
+}
+
+// Codecs returns the sorted names of the built-in and registered codecs, and of the compressors registered to package compress.
+func Codecs() []string {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	var names []string
+	for name := range codecs {
+		names = append(names, name)
+	}
+	for _, name := range compress.Names() {
+		if _, ok := codecs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecsMu.Lock()
+	c, ok := codecs[name]
+	if !ok {
+		c, ok = codecs[strings.ToUpper(name)] // the built-in names are case insensitive
+	}
+	codecsMu.Unlock()
+	if ok {
+		return c, nil
+	}
+	if c, ok := compress.Lookup(name); ok {
+		return compressorCodec{c}, nil
+	}
+	return nil, fmt.Errorf("codec %q is not registered, options are %q", name, Codecs())
+}
+
+// compressorCodec encodes a message by a Compressor, as the length of the metadata in uvarint, the metadata and the data.
+type compressorCodec struct {
+	c compress.Compressor
+}
+
+func (c compressorCodec) Name() string { return c.c.Name() }
+
+func (c compressorCodec) Encode(buf *kb.Vector) []byte {
+	data, meta := c.c.Compress(buf.AsF32())
+	bs := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(meta)+len(data))
+	bs = bs[:binary.PutUvarint(bs, uint64(len(meta)))]
+	bs = append(bs, meta...)
+	return append(bs, data...)
+}
+
+func (c compressorCodec) Decode(data []byte, buf *kb.Vector) error {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || n > uint64(len(data)-k) {
+		return fmt.Errorf("%w: %d bytes of %s", errInvalidEncoding, len(data), c.Name())
+	}
+	meta := data[k : k+int(n)]
+	return c.c.Decompress(data[k+int(n):], meta, buf.AsF32())
+}
+
+var errCodecMismatch = failure.New(failure.ProtocolMismatch, "peers use different codecs")
+
+// missingCodec prefixes the name of a codec that is not registered, in the consensus of SetCodec.
+const missingCodec = "\x00missing:"
+
+// CodecName returns the name of the codec in use, or an empty string if messages are not encoded.
+func (sess *Session) CodecName() string {
+	if c := sess.getCodec(); c != nil {
+		return c.Name()
+	}
+	return ""
+}
+
+func (sess *Session) getCodec() Codec {
+	sess.codecMu.Lock()
+	defer sess.codecMu.Unlock()
+	return sess.codec
+}
+
+// SetCodec encodes the messages of all reduce by the named codec, or stops encoding them if name is empty.
+// It must be called by all peers with the same name, the codec is not changed if any peer passes a different name.
+// A peer that has no codec of the name still takes part in the consensus, so that the other peers fail instead of blocking.
+func (sess *Session) SetCodec(name string) error {
+	var c Codec
+	var lookupErr error
+	if len(name) > 0 {
+		if c, lookupErr = lookupCodec(name); lookupErr == nil {
+			name = c.Name()
+		} else {
+			name = missingCodec + name
+		}
+	}
+	ok, err := sess.BytesConsensus([]byte(name), "kungfu::codec")
+	if err != nil {
+		return err
+	}
+	if lookupErr != nil {
+		return lookupErr
+	}
+	if !ok {
+		return fmt.Errorf("%w: %q is not used by all peers", errCodecMismatch, name)
+	}
+	sess.codecMu.Lock()
+	defer sess.codecMu.Unlock()
+	sess.codec = c
+	return nil
+}
+
+// negotiateCodec agrees on the codec in use with all peers, and stops encoding if they disagree,
+// so that strategies are never changed to a wire format that some peers can't read.
+func (sess *Session) negotiateCodec() error {
+	name := sess.CodecName()
+	ok, err := sess.BytesConsensus([]byte(name), "kungfu::codec")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		sess.logger.Warnf("%v, not encoding with %q", errCodecMismatch, name)
+		sess.codecMu.Lock()
+		defer sess.codecMu.Unlock()
+		sess.codec = nil
+	}
+	return nil
+}
+
+// encodable returns true if the messages of w can be encoded by a codec.
+func encodable(w kb.Workspace) bool {
+	return w.OP == kb.SUM && w.SendBuf.Type == kb.F32 && w.RecvBuf.Type == kb.F32
+}
+
+// codecFor returns the codec of the messages of w, or nil if they are not encoded, see SelectCodecs.
+func (sess *Session) codecFor(w kb.Workspace) Codec {
+	if !encodable(w) || !sess.codecs.encode(sess.linkClass(), sess.Step(), w.RecvBuf.Count*w.RecvBuf.Type.Size()) {
+		return nil
+	}
+	return sess.getCodec()
+}
+
+type fp16Codec struct{}
+
+func (fp16Codec) Name() string { return FP16Codec }
+
+func (fp16Codec) Encode(buf *kb.Vector) []byte {
+	data := make([]byte, 2*buf.Count)
+	for i, x := range buf.AsF32() {
+		binary.LittleEndian.PutUint16(data[2*i:], float32ToHalf(x))
+	}
+	return data
+}
+
+func (fp16Codec) Decode(data []byte, buf *kb.Vector) error {
+	if len(data) != 2*buf.Count {
+		return fmt.Errorf("%w: %d bytes of %d halves", errInvalidEncoding, len(data), buf.Count)
+	}
+	xs := buf.AsF32()
+	for i := range xs {
+		xs[i] = halfToFloat32(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return nil
+}
+
+// float32ToHalf converts x to the bits of the nearest IEEE 754 half precision value, rounding half to even.
+func float32ToHalf(x float32) uint16 {
+	b := math.Float32bits(x)
+	sign := uint16(b>>16) & 0x8000
+	e := int(b>>23) & 0xff
+	mant := b & 0x7fffff
+	if e == 0xff { // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	exp := e - 127 + 15
+	if exp >= 0x1f {
+		return sign | 0x7c00
+	}
+	if exp <= 0 { // subnormal
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h := uint16(mant >> shift)
+		rem, half := mant&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > half || (rem == half && h&1 == 1) {
+			h++
+		}
+		return sign | h
+	}
+	h := sign | uint16(exp)<<10 | uint16(mant>>13)
+	if rem := mant & 0x1fff; rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		h++ // may carry into the exponent, which rounds up to the next power of 2 or Inf
+	}
+	return h
+}
+
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		x := float32(mant) / (1 << 24)
+		if sign != 0 {
+			return -x
+		}
+		return x
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+type topKCodec struct {
+	name  string
+	ratio float64
+}
+
+// NewTopKCodec creates a codec which sends the given ratio of the elements of the largest magnitude, as pairs of index and value.
+// The others are taken as 0 by receivers.
+func NewTopKCodec(name string, ratio float64) Codec {
+	return &topKCodec{name: name, ratio: ratio}
+}
+
+func (c *topKCodec) Name() string { return c.name }
+
+func (c *topKCodec) Encode(buf *kb.Vector) []byte {
+	xs := buf.AsF32()
+	k := int(math.Ceil(c.ratio * float64(len(xs))))
+	if k > len(xs) {
+		k = len(xs)
+	}
+	idx := make([]int, len(xs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return math.Abs(float64(xs[idx[i]])) > math.Abs(float64(xs[idx[j]])) })
+	idx = idx[:k]
+	sort.Ints(idx)
+	data := make([]byte, 8*k)
+	for j, i := range idx {
+		binary.LittleEndian.PutUint32(data[8*j:], uint32(i))
+		binary.LittleEndian.PutUint32(data[8*j+4:], math.Float32bits(xs[i]))
+	}
+	return data
+}
+
+func (c *topKCodec) Decode(data []byte, buf *kb.Vector) error {
+	if len(data)%8 != 0 {
+		return fmt.Errorf("%w: %d bytes of index and value pairs", errInvalidEncoding, len(data))
+	}
+	xs := buf.AsF32()
+	for i := range xs {
+		xs[i] = 0
+	}
+	for j := 0; j < len(data); j += 8 {
+		i := int(binary.LittleEndian.Uint32(data[j:]))
+		if i >= len(xs) {
+			return fmt.Errorf("%w: index %d of %d elements", errInvalidEncoding, i, len(xs))
+		}
+		xs[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[j+4:]))
+	}
+	return nil
+}
+
+type qsgdCodec struct {
+	name   string
+	levels int
+}
+
+// NewQSGDCodec creates a codec which quantizes elements to the given levels in [1, 127] of the largest magnitude,
+// by stochastic rounding, so that the decoded values are unbiased.
+func NewQSGDCodec(name string, levels int) Codec {
+	if levels < 1 || levels > math.MaxInt8 {
+		panic(fmt.Sprintf("NewQSGDCodec: levels %d is not in [1, %d]", levels, math.MaxInt8))
+	}
+	return &qsgdCodec{name: name, levels: levels}
+}
+
+func (c *qsgdCodec) Name() string { return c.name }
+
+func (c *qsgdCodec) Encode(buf *kb.Vector) []byte {
+	xs := buf.AsF32()
+	var scale float32
+	for _, x := range xs {
+		if a := float32(math.Abs(float64(x))); a > scale {
+			scale = a
+		}
+	}
+	data := make([]byte, 4+len(xs))
+	binary.LittleEndian.PutUint32(data, math.Float32bits(scale))
+	if scale == 0 {
+		return data
+	}
+	s := float32(c.levels)
+	for i, x := range xs {
+		q := float32(math.Abs(float64(x))) / scale * s
+		l := float32(math.Floor(float64(q)))
+		if rand.Float32() < q-l {
+			l++
+		}
+		if x < 0 {
+			l = -l
+		}
+		data[4+i] = byte(int8(l))
+	}
+	return data
+}
+
+func (c *qsgdCodec) Decode(data []byte, buf *kb.Vector) error {
+	if len(data) != 4+buf.Count {
+		return fmt.Errorf("%w: %d bytes of %d quantized elements", errInvalidEncoding, len(data), buf.Count)
+	}
+	scale := math.Float32frombits(binary.LittleEndian.Uint32(data)) / float32(c.levels)
+	xs := buf.AsF32()
+	for i := range xs {
+		xs[i] = float32(int8(data[4+i])) * scale
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/compress"
+)
+
+func Test_half(t *testing.T) {
+	for _, x := range []float32{0, 1, -2.5, 0.1, 65504, 1e-7, -6.1e-5} {
+		y := halfToFloat32(float32ToHalf(x))
+		if d := math.Abs(float64(x - y)); d > 1e-3*math.Abs(float64(x))+6e-8 {
+			t.Errorf("half(%g) = %g", x, y)
+		}
+	}
+	if y := halfToFloat32(float32ToHalf(1e6)); !math.IsInf(float64(y), 1) {
+		t.Errorf("half(1e6) = %g, want +Inf", y)
+	}
+}
+
+func Test_codecs(t *testing.T) {
+	const n = 1000
+	x := kb.NewVector(n, kb.F32)
+	for i := range x.AsF32() {
+		x.AsF32()[i] = float32(math.Sin(float64(i)))
+	}
+	x.AsF32()[7] = 10
+	for _, name := range Codecs() {
+		c, err := lookupCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := kb.NewVector(n, kb.F32)
+		if err := c.Decode(c.Encode(x), y); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if y.AsF32()[7] != 10 {
+			t.Errorf("%s: decoded the largest element as %g", name, y.AsF32()[7])
+		}
+		if err := c.Decode([]byte{1, 2, 3}, y); err == nil {
+			t.Errorf("%s: decoding invalid data should fail", name)
+		}
+	}
+}
+
+// rawCompressor sends float32 bits as is, with the count as metadata.
+type rawCompressor struct{}
+
+func (rawCompressor) Name() string { return "RAW" }
+
+func (rawCompressor) Compress(chunk []float32) ([]byte, []byte) {
+	data := make([]byte, 4*len(chunk))
+	for i, x := range chunk {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	return data, []byte{byte(len(chunk))}
+}
+
+func (rawCompressor) Decompress(data []byte, meta []byte, chunk []float32) error {
+	if len(meta) != 1 || int(meta[0]) != len(chunk) || len(data) != 4*len(chunk) {
+		return fmt.Errorf("%v: %d bytes of %d floats", errInvalidEncoding, len(data), len(chunk))
+	}
+	for i := range chunk {
+		chunk[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return nil
+}
+
+func Test_compressorCodec(t *testing.T) {
+	compress.Register(rawCompressor{})
+	c, err := lookupCodec("RAW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := kb.NewVector(100, kb.F32)
+	y := kb.NewVector(100, kb.F32)
+	for i := range x.AsF32() {
+		x.AsF32()[i] = float32(i) / 3
+	}
+	if err := c.Decode(c.Encode(x), y); err != nil {
+		t.Fatal(err)
+	}
+	for i := range x.AsF32() {
+		if x.AsF32()[i] != y.AsF32()[i] {
+			t.Fatalf("decoded %g as %g", x.AsF32()[i], y.AsF32()[i])
+		}
+	}
+	for _, data := range [][]byte{nil, {0x80}, {5, 1}} {
+		if err := c.Decode(data, y); err == nil {
+			t.Errorf("decoding %v should fail", data)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+)
+
+// codecSelectionTolerance is the relative difference of throughput taken as noise, a size class keeps its choice
+// unless the other choice is faster by more.
+const codecSelectionTolerance = 0.05
+
+// codecExploreRounds is the number of selections after which a size class tries the choice it's not using again,
+// so that the choice follows changes of the speed of the links.
+const codecExploreRounds = 10
+
+// defaultCodecClasses are the max bytes of the message size classes of codec selection, if config.CodecSizes is not set.
+var defaultCodecClasses = []int{64 << 10, 1 * Mi, 16 * Mi, 0}
+
+// linkClass is the speed class of the links of collectives, encoding pays off on slower links.
+type linkClass int
+
+const (
+	hostLinks    linkClass = iota // all peers are on one host
+	networkLinks                  // peers are on different hosts
+	linkClasses
+)
+
+var linkClassNames = [linkClasses]string{`host`, `network`}
+
+// linkClass returns the class of the links of the collectives of the session.
+func (sess *Session) linkClass() linkClass {
+	if sess.hostCount > 1 {
+		return networkLinks
+	}
+	return hostLinks
+}
+
+// codecArms is the state of choosing whether a size class encodes its messages.
+type codecArms struct {
+	throughputs [2]float64 // without and with the codec, in bytes per second at the last selection it was measured
+	age         int        // selections since the choice was changed
+}
+
+// codecSelector chooses for each message size class and each link class whether all reduce encodes its messages by the
+// codec, by the throughputs of all reduce with and without it, which depend on the sizes and on the speed of the links.
+// The value of a size class is 1 if its messages are encoded.
+type codecSelector struct {
+	sizes [linkClasses]*sizeTuner
+	arms  [linkClasses][]codecArms // of each class, only used by SelectCodecs
+}
+
+func newCodecSelectorOf(classes [linkClasses][]sizeClass) *codecSelector {
+	s := &codecSelector{}
+	for l := range classes {
+		s.sizes[l] = newSizeTuner("codec-selection-"+linkClassNames[l], 2, classes[l])
+		s.arms[l] = make([]codecArms, len(classes[l]))
+	}
+	return s
+}
+
+// newCodecSelector returns the codec selector of config.CodecSizes, or of defaultCodecClasses encoding all messages
+// if it's not set. It returns nil if there are neither codec sizes nor codec selection, so that all messages are encoded.
+func newCodecSelector() (*codecSelector, error) {
+	if val := config.CodecSizes; len(val) > 0 {
+		classes, err := parseCodecSizes(val)
+		if err != nil {
+			return nil, err
+		}
+		return newCodecSelectorOf(classes), nil
+	}
+	if config.CodecSelectionInterval <= 0 {
+		return nil, nil
+	}
+	var classes [linkClasses][]sizeClass
+	for l := range classes {
+		for _, n := range defaultCodecClasses {
+			classes[l] = append(classes[l], sizeClass{maxBytes: n, value: 1})
+		}
+	}
+	return newCodecSelectorOf(classes), nil
+}
+
+// parseCodecSizes parses a semicolon separated list of <link class>=<sizes> of each link class, or <sizes> of all,
+// where <sizes> is a comma separated list of <max bytes>:<on | off>, in increasing order of sizes,
+// the last one can be *:<on | off> for no limit.
+func parseCodecSizes(val string) ([linkClasses][]sizeClass, error) {
+	var classes [linkClasses][]sizeClass
+	parse := func(val string) ([]sizeClass, error) {
+		return parseSizeClasses(val, "codec size", func(v string) (int, bool) {
+			switch v {
+			case `on`:
+				return 1, true
+			case `off`:
+				return 0, true
+			}
+			return 0, false
+		})
+	}
+	if !strings.Contains(val, "=") {
+		cs, err := parse(val)
+		if err != nil {
+			return classes, err
+		}
+		for l := range classes {
+			classes[l] = append([]sizeClass(nil), cs...)
+		}
+		return classes, nil
+	}
+	for _, spec := range strings.Split(val, ";") {
+		kv := strings.SplitN(spec, "=", 2)
+		l := -1
+		for i, name := range linkClassNames {
+			if kv[0] == name {
+				l = i
+			}
+		}
+		if len(kv) != 2 || l < 0 || classes[l] != nil {
+			return classes, fmt.Errorf("invalid codec sizes of link class: %q", spec)
+		}
+		cs, err := parse(kv[1])
+		if err != nil {
+			return classes, err
+		}
+		classes[l] = cs
+	}
+	for l, cs := range classes {
+		if cs == nil {
+			return classes, fmt.Errorf("no codec sizes of %s links: %q", linkClassNames[l], val)
+		}
+	}
+	return classes, nil
+}
+
+func (s *codecSelector) String() string {
+	var specs []string
+	for l, t := range s.sizes {
+		specs = append(specs, linkClassNames[l]+"="+formatSizeClasses(t.latest(), func(v int) string {
+			if v != 0 {
+				return `on`
+			}
+			return `off`
+		}))
+	}
+	return strings.Join(specs, ";")
+}
+
+// encode returns true if messages of n bytes on links of l are encoded at the given step,
+// which they are if there is no selector.
+func (s *codecSelector) encode(l linkClass, step int64, n int) bool {
+	if s == nil {
+		return true
+	}
+	return s.sizes[l].value(step, n, 1) != 0
+}
+
+// record adds an all reduce of messages of n bytes on links of l, encoded or not, which took d.
+func (s *codecSelector) record(l linkClass, n int, encoded bool, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.sizes[l].record(n, boolToInt(encoded), d)
+}
+
+// update sets the throughputs of the arms of the size classes on links of l measured since the previous selection,
+// and changes the choice of each class in use to the faster arm. A class also changes its choice if the other arm
+// is not measured, or its choice is codecExploreRounds selections old. It returns true if any choice changed.
+func (s *codecSelector) update(l linkClass, classes []sizeClass, throughputs [][]float64) bool {
+	var changed bool
+	for i := range classes {
+		c, a := &classes[i], &s.arms[l][i]
+		for j, tp := range throughputs[i] {
+			if tp > 0 {
+				a.throughputs[j] = tp
+			}
+		}
+		cur := c.value
+		if throughputs[i][cur] <= 0 {
+			continue // idle
+		}
+		a.age++
+		other := a.throughputs[1-cur]
+		if other == 0 || a.age >= codecExploreRounds || other > a.throughputs[cur]*(1+codecSelectionTolerance) {
+			c.value = 1 - cur
+			a.age = 0
+			changed = true
+		}
+	}
+	return changed
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// SelectCodecs chooses for each message size class whether all reduce encodes its messages by the codec on the links
+// of the session, every config.CodecSelectionInterval calls, e.g. steps, by the throughput of its all reduces since
+// the previous selection, see codecSelector.update and Session.tuneSizes. The choices are used from the next step
+// of all peers, so that they encode the same messages. They are kept for each link class in config.CodecSizes,
+// so that the next sessions start from them, and the throughputs are measured again in them, as the links change
+// with the cluster. It must be called by all peers, at the same point of their collectives, e.g. at step boundaries.
+// It does nothing if config.CodecSelectionInterval is not positive, or if there is no codec.
+func (sess *Session) SelectCodecs() error {
+	s := sess.codecs
+	if s == nil || config.CodecSelectionInterval <= 0 || sess.getCodec() == nil {
+		return nil
+	}
+	l := sess.linkClass()
+	update := func(classes []sizeClass, throughputs [][]float64) bool { return s.update(l, classes, throughputs) }
+	changed, err := sess.tuneSizes(s.sizes[l], config.CodecSelectionInterval, update)
+	if err != nil || !changed {
+		return err
+	}
+	config.CodecSizes = s.String()
+	if sess.rank == defaultRoot {
+		sess.logger.Infof("messages are encoded by %s at sizes %s", sess.CodecName(), config.CodecSizes)
+	}
+	return nil
+}
+
+// SyncCodecSizes sets the choices of codec selection of all peers to those of rank 0, e.g. for peers joining
+// a session after the choices have been changed. It must be called by all peers.
+func (sess *Session) SyncCodecSizes() error {
+	bs, err := sess.broadcastBytes([]byte(config.CodecSizes), "kungfu::codec-sizes")
+	if err != nil {
+		return err
+	}
+	if val := string(bs); val != config.CodecSizes {
+		config.CodecSizes = val
+		s, err := newCodecSelector()
+		if err != nil {
+			return err
+		}
+		sess.codecs = s
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"testing"
+)
+
+func Test_parseCodecSizes(t *testing.T) {
+	classes, err := parseCodecSizes(`65536:off,*:on`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newCodecSelectorOf(classes)
+	if got, want := s.String(), `host=65536:off,*:on;network=65536:off,*:on`; got != want {
+		t.Errorf("unexpected codec sizes %s, want %s", got, want)
+	}
+	for l := hostLinks; l < linkClasses; l++ {
+		if s.encode(l, 0, 65536) || !s.encode(l, 0, 65537) {
+			t.Errorf("messages up to 64 KiB should not be encoded, larger ones should")
+		}
+	}
+	if !(*codecSelector)(nil).encode(hostLinks, 0, 1) {
+		t.Errorf("messages should be encoded without a selector")
+	}
+	const val = `host=*:off;network=1024:off,*:on`
+	if classes, err = parseCodecSizes(val); err != nil {
+		t.Fatal(err)
+	}
+	s = newCodecSelectorOf(classes)
+	if got := s.String(); got != val {
+		t.Errorf("unexpected codec sizes %s", got)
+	}
+	if s.encode(hostLinks, 0, 2048) || !s.encode(networkLinks, 0, 2048) {
+		t.Errorf("messages of 2 KiB should be encoded on network links only")
+	}
+	for _, val := range []string{`1024:on,512:off`, `*:on,1024:off`, `1024:yes`, `1024`, `host=*:on`, `host=*:on;host=*:off`, `wan=*:on`} {
+		if _, err := parseCodecSizes(val); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+}
+
+func Test_codecSelectorUpdate(t *testing.T) {
+	var classes [linkClasses][]sizeClass
+	classes[networkLinks] = []sizeClass{{value: 1}}
+	s := newCodecSelectorOf(classes)
+	cs := s.sizes[networkLinks].latest()
+	for i, c := range []struct {
+		plain, encoded float64
+		encode         bool
+	}{
+		{0, 100, false}, // the plain arm is not measured
+		{80, 0, true},   // encoded is faster
+		{0, 102, true},  // still faster, stay
+		{0, 0, true},    // idle
+		{0, 70, false},  // plain is faster now, e.g. on faster links
+		{90, 0, false},  // slower than before, still faster
+	} {
+		s.update(networkLinks, cs, [][]float64{{c.plain, c.encoded}})
+		if got := cs[0].value != 0; got != c.encode {
+			t.Errorf("#%d: encode = %v, want %v", i, got, c.encode)
+		}
+	}
+	for i := 0; i < codecExploreRounds; i++ {
+		s.update(networkLinks, cs, [][]float64{{90, 0}})
+	}
+	if cs[0].value == 0 {
+		t.Errorf("the encoded arm should be explored again")
+	}
+}
@@ -0,0 +1,573 @@
+package session_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/tests/go/testutils"
+)
+
+const clusterSize = 3
+
+func startCluster(t *testing.T) *testutils.Cluster {
+	c, err := testutils.StartCluster(clusterSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func f32s(xs ...float32) *kb.Vector {
+	v := kb.NewVector(len(xs), kb.F32)
+	copy(v.AsF32(), xs)
+	return v
+}
+
+func near(x, y float64) bool {
+	return math.Abs(x-y) <= 1e-4*math.Max(1, math.Abs(y))
+}
+
+func Test_GradientNoiseScale(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		// the same gradients on all peers have no noise
+		x := f32s(1, 2, 2)
+		y := kb.NewVector(3, kb.F32)
+		ns, err := sess.GradientNoiseScale(kb.Workspace{SendBuf: x, RecvBuf: y, Name: "gns"}, 4)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(y.AsF32(), x.AsF32()) {
+			return fmt.Errorf("average %v, want %v", y.AsF32(), x.AsF32())
+		}
+		if !near(ns.GradSqr, 9) || !near(ns.Trace, 0) {
+			return fmt.Errorf("noise scale %+v, want |G|^2 = 9, tr = 0", *ns)
+		}
+		w := kb.Workspace{SendBuf: kb.NewVector(1, kb.F64), RecvBuf: kb.NewVector(1, kb.F64), Name: "gns-f64"}
+		if _, err := sess.GradientNoiseScale(w, 4); err == nil {
+			return fmt.Errorf("noise scale of f64 gradients succeeded")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_GlobalNorm(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		norm, err := sess.GlobalNorm([]*kb.Vector{f32s(float32(rank+1), 0), f32s(1)}, "norm")
+		if err != nil {
+			return err
+		}
+		if want := math.Sqrt(1 + 4 + 9 + clusterSize); !near(float64(norm), want) {
+			return fmt.Errorf("global norm %f, want %f", norm, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_BatchNormStats(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		// rank r has 2 samples r and r + 1 of a single channel
+		r := float32(rank)
+		x := f32s(r+r+1, r*r+(r+1)*(r+1), 2)
+		y := kb.NewVector(3, kb.F32)
+		if err := sess.BatchNormStats(kb.Workspace{SendBuf: x, RecvBuf: y, Name: "bn"}); err != nil {
+			return err
+		}
+		// samples 0, 1, 1, 2, 2, 3
+		mean, variance := 1.5, (2.25+0.25+0.25+0.25+0.25+2.25)/6
+		if s := y.AsF32(); !near(float64(s[0]), mean) || !near(float64(s[1]), variance) || s[2] != 6 {
+			return fmt.Errorf("batch norm stats %v, want [%f %f 6]", s, mean, variance)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_LossScaleConsensus(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		overflow, scale, err := sess.LossScaleConsensus(rank == 1, float32(int(1)<<(10+rank)), "loss-scale")
+		if err != nil {
+			return err
+		}
+		if !overflow || scale != 1024 {
+			return fmt.Errorf("consensus (%v, %f), want (true, 1024)", overflow, scale)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_TopK(t *testing.T) {
+	c := startCluster(t)
+	scores := []float32{1, 3, 3}
+	err := c.Run(func(rank int, sess *session.Session) error {
+		top, err := sess.TopK(scores[rank], 2, "topk")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(top, []int{1, 2}) {
+			return fmt.Errorf("top 2 %v, want [1 2]", top)
+		}
+		x := f32s(float32(rank))
+		y := f32s(-1)
+		top, err = sess.TopKExploit(scores[rank], 1, kb.Workspace{SendBuf: x, RecvBuf: y, Name: "exploit"})
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(top, []int{1}) || y.AsF32()[0] != 1 {
+			return fmt.Errorf("top 1 %v with model %v, want [1] with the model of 1", top, y.AsF32())
+		}
+		if _, err := sess.TopK(1, clusterSize+1, "topk-invalid"); err == nil {
+			return fmt.Errorf("top %d of %d peers succeeded", clusterSize+1, clusterSize)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_Sharding(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		var owners []int
+		for i, size := range []int{400, 100, 100, 100} {
+			owner, err := sess.AssignShard(fmt.Sprintf("w%d", i), size)
+			if err != nil {
+				return err
+			}
+			owners = append(owners, owner)
+		}
+		if want := []int{0, 1, 2, 1}; !reflect.DeepEqual(owners, want) {
+			return fmt.Errorf("owners %v, want %v", owners, want)
+		}
+		if _, err := sess.AssignShard("w0", 200); err == nil {
+			return fmt.Errorf("assigning w0 with another size succeeded")
+		}
+		p := f32s(float32(rank))
+		if err := sess.ShardBroadcast(kb.Workspace{SendBuf: p, RecvBuf: p, Name: "w1"}); err != nil {
+			return err
+		}
+		if p.AsF32()[0] != 1 {
+			return fmt.Errorf("broadcast w1 %v, want the parameter of its owner 1", p.AsF32())
+		}
+		g := f32s(float32(rank + 1))
+		s := f32s(0)
+		if err := sess.ShardReduce(kb.Workspace{SendBuf: g, RecvBuf: s, OP: kb.SUM, Name: "w2"}); err != nil {
+			return err
+		}
+		if rank == 2 && s.AsF32()[0] != 6 {
+			return fmt.Errorf("reduced w2 %v on its owner, want 6", s.AsF32())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_GPUDirect(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		// colocated peers are connected by unix sockets
+		if ok, err := sess.GPUDirect(); ok || err != nil {
+			return fmt.Errorf("GPUDirect: %v, %v, want false", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_FallbackAbandonsAttempts(t *testing.T) {
+	defer func(d time.Duration) { config.CollectiveTimeout = d }(config.CollectiveTimeout)
+	config.CollectiveTimeout = 200 * time.Millisecond
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		if rank == clusterSize-1 {
+			time.Sleep(3 * config.CollectiveTimeout) // the first attempts of the others time out
+		}
+		x := f32s(float32(rank), 1)
+		if err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "fallback"}); err != nil {
+			return err
+		}
+		if got := x.AsF32(); got[0] != 3 || got[1] != clusterSize {
+			return fmt.Errorf("all reduced %v, want [3 %d]", got, clusterSize)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the abandoned attempts are cancelled rather than waiting for their messages forever
+	for i := 0; ; i++ {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "runChunkWithTimeout") {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("abandoned attempts are still running:\n%s", stacks)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func Test_BytesConsensusWithin(t *testing.T) {
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		if rank != clusterSize-1 { // the last peer is partitioned from the others
+			_, err := sess.BytesConsensusWithin([]byte("x"), "partitioned", 200*time.Millisecond)
+			if k := failure.Of(err); k != failure.Timeout {
+				return fmt.Errorf("consensus without a peer: %v of kind %s, want %s", err, k, failure.Timeout)
+			}
+		}
+		ok, err := sess.BytesConsensusWithin([]byte("y"), "all", 10*time.Second)
+		if err != nil || !ok {
+			return fmt.Errorf("consensus of all peers: %t, %v", ok, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ResilientAllReduce(t *testing.T) {
+	defer func(on bool, d, p time.Duration) {
+		config.EnableResilience, config.ResilienceTimeout, config.PartitionTimeout = on, d, p
+	}(config.EnableResilience, config.ResilienceTimeout, config.PartitionTimeout)
+	config.EnableResilience = true
+	config.ResilienceTimeout = 500 * time.Millisecond
+	config.PartitionTimeout = 200 * time.Millisecond
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		x := f32s(float32(rank), 1)
+		if err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "resilient"}); err != nil {
+			return err
+		}
+		if got := x.AsF32(); got[0] != 3 || got[1] != clusterSize {
+			return fmt.Errorf("all reduced %v, want [3 %d]", got, clusterSize)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Peers[clusterSize-1].Close() // crashes
+	err = c.Run(func(rank int, sess *session.Session) error {
+		if rank == clusterSize-1 {
+			return nil
+		}
+		for i := 0; i < 2; i++ {
+			x := f32s(float32(rank), 1)
+			err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "resilient"})
+			if k := failure.Of(err); k != failure.PeerCrash {
+				return fmt.Errorf("all reduce without a peer: %v of kind %s, want %s", err, k, failure.PeerCrash)
+			}
+			if got := x.AsF32(); got[0] != 1 || got[1] != clusterSize-1 {
+				return fmt.Errorf("all reduced %v over the survivors, want [1 %d]", got, clusterSize-1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the abandoned attempts are cancelled rather than waiting for the crashed peer forever
+	for i := 0; ; i++ {
+		buf := make([]byte, 1<<20)
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "runAttempt") {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("abandoned attempts are still running:\n%s", stacks)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func Test_CompensatedPartialSums(t *testing.T) {
+	c := startCluster(t)
+	chain := []int32{0, 0, 1} // 2 -> 1 -> 0 in the reduce graph
+	xs := []float32{-1e8, 1e8, 1}
+	err := c.Run(func(rank int, sess *session.Session) error {
+		x := f32s(xs[rank])
+		w := kb.Workspace{SendBuf: x, RecvBuf: kb.NewVector(1, kb.F32), OP: kb.SUM, Name: "compensated", Compensated: true}
+		if err := sess.AllReduceWith(chain, w); err != nil {
+			return err
+		}
+		// 1e8 + 1 rounds to 1e8 at rank 1, whose rounding error is corrected at the root
+		if got := w.RecvBuf.AsF32()[0]; got != 1 {
+			return fmt.Errorf("compensated sum %v, want 1", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_CustomOPsConcurrently(t *testing.T) {
+	var ops []kb.OP
+	for _, name := range []string{"first", "second"} {
+		op, err := kb.RegisterOP(name, func(z, x, y *kb.Vector) { kb.Transform2(z, x, y, kb.MAX) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, op)
+	}
+	c := startCluster(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(func(rank int, sess *session.Session) error {
+			errs := make(chan error, len(ops))
+			for i := range ops {
+				op := ops[(i+rank)%len(ops)] // the first uses of the ops are checked in different orders by the peers
+				go func() {
+					x := f32s(float32(rank))
+					errs <- sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: op, Name: "custom:" + op.String()})
+				}()
+			}
+			for range ops {
+				if err := <-errs; err != nil {
+					return err
+				}
+			}
+			if rank == 0 { // the only local root, whose check doesn't wait for the others
+				x := f32s(1)
+				return sess.CrossAllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: ops[0], Name: "custom:cross"})
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the checks of custom ops are blocked")
+	}
+	err := c.Run(func(rank int, sess *session.Session) error {
+		x := f32s(1)
+		err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.BXOR, Name: "bxor"})
+		if err == nil {
+			return fmt.Errorf("%s of %s is not rejected", kb.BXOR, kb.F32)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_GiantGathers(t *testing.T) {
+	defer session.SetMaxMessageBytes(64)()
+	const count = 40 // 160 bytes, gathered in 3 parts
+	c := startCluster(t)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		x := kb.NewVector(count, kb.F32)
+		for i := range x.AsF32() {
+			x.AsF32()[i] = float32(rank*count + i)
+		}
+		all := kb.NewVector(clusterSize*count, kb.F32)
+		if err := sess.AllGather(kb.Workspace{SendBuf: x, RecvBuf: all, Name: "giant-all-gather"}); err != nil {
+			return err
+		}
+		for i, y := range all.AsF32() {
+			if y != float32(i) {
+				return fmt.Errorf("all gathered [%d] = %v, want %d", i, y, i)
+			}
+		}
+		gathered := kb.NewVector(clusterSize*count, kb.F32)
+		if err := sess.Gather(kb.Workspace{SendBuf: x, RecvBuf: gathered, Name: "giant-gather"}); err != nil {
+			return err
+		}
+		if rank == 0 && !reflect.DeepEqual(gathered.AsF32(), all.AsF32()) {
+			return fmt.Errorf("gathered %v, want %v", gathered.AsF32(), all.AsF32())
+		}
+		r := sess.ReduceScatterShard(count)
+		shard := kb.NewVector(r.Len(), kb.F32)
+		if err := sess.ReduceScatter(kb.Workspace{SendBuf: x, RecvBuf: shard, OP: kb.SUM, Name: "giant-reduce-scatter"}); err != nil {
+			return err
+		}
+		for i, y := range shard.AsF32() {
+			if want := float32(3*(r.Begin+i) + count*(0+1+2)); y != want {
+				return fmt.Errorf("reduced shard [%d] = %v, want %v", i, y, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_AllReduceStreamReadFailure(t *testing.T) {
+	const count = 12 // 3 segments of 4 floats
+	c := startCluster(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(func(rank int, sess *session.Session) error {
+			src := io.Reader(bytes.NewReader(make([]byte, 4*count)))
+			if rank == 1 {
+				src = io.LimitReader(src, 16) // fails to read the second segment
+			}
+			var dst bytes.Buffer
+			w := session.StreamWorkspace{Src: src, Dst: &dst, Count: count, Type: kb.F32, OP: kb.SUM, Name: "failing-stream", SegmentBytes: 16}
+			if err := sess.AllReduceStream(w); err == nil {
+				return fmt.Errorf("stream failed to be read on rank 1 succeeded")
+			}
+			if dst.Len() > 16 {
+				return fmt.Errorf("%d bytes written, want the first segment only", dst.Len())
+			}
+			w2 := kb.Workspace{SendBuf: f32s(1), RecvBuf: kb.NewVector(1, kb.F32), OP: kb.SUM, Name: "after-stream"}
+			if err := sess.AllReduce(w2); err != nil {
+				return err
+			}
+			if got := w2.RecvBuf.AsF32()[0]; got != clusterSize {
+				return fmt.Errorf("all reduce after the failed stream = %v, want %d", got, clusterSize)
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("peers blocked by the stream failed to be read on rank 1")
+	}
+}
+
+func withLocalFallback(t *testing.T) {
+	on, d, p := config.EnableLocalFallback, config.ResilienceTimeout, config.PartitionTimeout
+	t.Cleanup(func() { config.EnableLocalFallback, config.ResilienceTimeout, config.PartitionTimeout = on, d, p })
+	config.EnableLocalFallback = true
+	config.ResilienceTimeout = 2 * time.Second
+	config.PartitionTimeout = 100 * time.Millisecond
+}
+
+// runDegraded runs the collectives of the local buffer x in local-only mode, and checks their results and flags.
+func runDegraded(rank int, sess *session.Session, x *kb.Vector) error {
+	count := x.Count
+	var degraded bool
+	check := func(name string, got, want []float32) error {
+		if !degraded {
+			return fmt.Errorf("%s not flagged degraded in local-only mode", name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%s returned %v in local-only mode, want %v", name, got, want)
+		}
+		degraded = false
+		return nil
+	}
+	y := kb.NewVector(count, kb.F32)
+	if err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "degraded-all-reduce", Degraded: &degraded}); err != nil {
+		return err
+	}
+	if err := check("AllReduce", y.AsF32(), x.AsF32()); err != nil {
+		return err
+	}
+	all := kb.NewVector(clusterSize*count, kb.F32)
+	if err := sess.AllGather(kb.Workspace{SendBuf: x, RecvBuf: all, Name: "degraded-all-gather", Degraded: &degraded}); err != nil {
+		return err
+	}
+	if err := check("AllGather", all.AsF32()[rank*count:(rank+1)*count], x.AsF32()); err != nil {
+		return err
+	}
+	r := sess.ReduceScatterShard(count)
+	shard := kb.NewVector(r.Len(), kb.F32)
+	if err := sess.ReduceScatter(kb.Workspace{SendBuf: x, RecvBuf: shard, OP: kb.SUM, Name: "degraded-reduce-scatter", Degraded: &degraded}); err != nil {
+		return err
+	}
+	if err := check("ReduceScatter", shard.AsF32(), x.AsF32()[r.Begin:r.End]); err != nil {
+		return err
+	}
+	z := kb.NewVector(count, kb.F32)
+	if err := sess.Reduce(kb.Workspace{SendBuf: x, RecvBuf: z, OP: kb.SUM, Name: "degraded-reduce", Degraded: &degraded}); err != nil {
+		return err
+	}
+	if err := check("Reduce", z.AsF32(), x.AsF32()); err != nil {
+		return err
+	}
+	return sess.Barrier()
+}
+
+func Test_LocalOnlyFallback(t *testing.T) {
+	withLocalFallback(t)
+	c := startCluster(t)
+	for _, p := range c.Peers[1:] {
+		p.Close() // unreachable
+	}
+	sess := c.Peers[0].CurrentSession()
+	if err := runDegraded(0, sess, f32s(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if !sess.LocalOnly() {
+		t.Errorf("not in local-only mode without the remote peers")
+	}
+}
+
+func Test_LocalOnlyRejoin(t *testing.T) {
+	withLocalFallback(t)
+	c := startCluster(t)
+	steps := make([]int64, clusterSize)
+	err := c.Run(func(rank int, sess *session.Session) error {
+		session.EnterLocalOnly(sess)
+		if err := runDegraded(rank, sess, f32s(float32(rank), 1, 2)); err != nil {
+			return err
+		}
+		for step := int64(10 * rank); !sess.Rejoined(); step++ { // the peers train at different paces
+			sess.AdvanceStepTo(step)
+			time.Sleep(time.Duration(rank+1) * 10 * time.Millisecond)
+		}
+		steps[rank] = sess.Step()
+		var degraded bool
+		x := f32s(float32(rank), 1)
+		if err := sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "rejoined", Degraded: &degraded}); err != nil {
+			return err
+		}
+		if got := x.AsF32(); degraded || got[0] != 3 || got[1] != clusterSize {
+			return fmt.Errorf("all reduced %v, degraded: %t after the rejoin, want [3 %d]", got, degraded, clusterSize)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range steps[1:] {
+		if s != steps[0] || s < 10*(clusterSize-1) {
+			t.Errorf("peers rejoined at steps %v, want the same latest step", steps)
+		}
+	}
+}
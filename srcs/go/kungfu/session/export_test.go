@@ -0,0 +1,16 @@
+package session
+
+import "errors"
+
+// SetMaxMessageBytes sets the bound of the bytes of a collective for the tests of package session_test,
+// and returns a function to restore it.
+func SetMaxMessageBytes(n int) func() {
+	old := maxMessageBytes
+	maxMessageBytes = n
+	return func() { maxMessageBytes = old }
+}
+
+// EnterLocalOnly puts sess in local-only mode, as if all remote peers were unreachable.
+func EnterLocalOnly(sess *Session) {
+	sess.enterLocalOnly(errors.New("unreachable for the test"))
+}
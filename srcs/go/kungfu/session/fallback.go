@@ -0,0 +1,196 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+var errCollectiveTimeout = failure.New(failure.Timeout, "collective timeout")
+
+// failureCounter counts the chunks that timed out on each strategy.
+type failureCounter struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func newFailureCounter() *failureCounter {
+	return &failureCounter{counts: make(map[string]int)}
+}
+
+func (c *failureCounter) add(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.counts[name]++
+}
+
+func (c *failureCounter) get(name string) int {
+	c.Lock()
+	defer c.Unlock()
+	return c.counts[name]
+}
+
+// attemptNames names the attempts of chunks. Late messages of an abandoned attempt still arrive under its name,
+// so that all later attempts of the chunk, in this and the next collectives, take a new name.
+type attemptNames struct {
+	sync.Mutex
+	abandoned map[string]int // attempts of each chunk abandoned by all peers
+}
+
+func newAttemptNames() *attemptNames {
+	return &attemptNames{abandoned: make(map[string]int)}
+}
+
+// name returns the name of the next attempt of the chunk, which is the name of the chunk until an attempt is abandoned.
+func (a *attemptNames) name(chunk string) string {
+	a.Lock()
+	defer a.Unlock()
+	if n := a.abandoned[chunk]; n > 0 {
+		return fmt.Sprintf("%s:attempt:%d", chunk, n)
+	}
+	return chunk
+}
+
+func (a *attemptNames) abandon(chunk string) {
+	a.Lock()
+	defer a.Unlock()
+	a.abandoned[chunk]++
+}
+
+// runStrategiesWithFallback runs the chunks of w as runStrategiesWithHash, but a chunk which doesn't finish within
+// config.CollectiveTimeout is re-issued on the next active strategy, rather than failing the whole collective.
+// All peers agree on the chunks to re-issue by a small all reduce after each round.
+// Each attempt of a chunk runs on a copy of its buffers and without WaitRecvBuf, so that an abandoned attempt neither
+// reads the input after it's abandoned, nor blocks the connection, and its late messages don't overwrite the result,
+// and under a name of no abandoned attempt, see attemptNames.
+// The durations of the chunks are recorded for tuning the chunk size only if none was re-issued, as those of chunks
+// finished after a timeout measure the timeout rather than the throughput.
+func (sess *Session) runStrategiesWithFallback(w kb.Workspace, p kb.PartitionFunc, strategies strategyList, strategyHash StrategyHash, codec Codec) error {
+	if w.IsEmpty() {
+		return nil
+	}
+	n := w.RecvBuf.Count * w.RecvBuf.Type.Size()
+	cp := sess.plans.get(w, p, len(strategies), strategyHash, sess.chunks.chunkSize(sess.Step(), n))
+	ws := cp.split(w)
+	chosen := make([]int, len(ws))
+	pending := make([]int, len(ws))
+	for i := range ws {
+		chosen[i] = cp.choices[i]
+		pending[i] = i
+	}
+	maxRounds := len(strategies)
+	if maxRounds < 2 {
+		maxRounds = 2 // retry once on the only strategy
+	}
+	for round := 0; ; round++ {
+		timedOut := make([]bool, len(ws))
+		errs := make([]error, len(pending))
+		durations := make([]time.Duration, len(pending))
+		var wg sync.WaitGroup
+		for j, i := range pending {
+			wg.Add(1)
+			go func(j, i int) {
+				var err error
+				t0 := time.Now()
+				timedOut[i], err = sess.runChunkWithTimeout(ws[i], sess.attempts.name(ws[i].Name), strategies[chosen[i]], codec, config.CollectiveTimeout)
+				durations[j] = time.Since(t0)
+				errs[j] = err
+				wg.Done()
+			}(j, i)
+		}
+		wg.Wait()
+		if err := utils.MergeErrors(errs, "runStrategiesWithFallback"); err != nil {
+			return err
+		}
+		failed, err := sess.agreeOnTimeouts(w.Name, round, timedOut, strategies.choose(round+1))
+		if err != nil {
+			return err
+		}
+		for _, i := range pending {
+			if timedOut[i] || !failed[i] {
+				sess.breakers.chunkDone(sess.rank, strategies[chosen[i]], !timedOut[i])
+			}
+		}
+		pending = pending[:0]
+		for i, f := range failed {
+			if !f {
+				continue
+			}
+			s := strategies[chosen[i]]
+			sess.failures.add(s.name)
+			sess.attempts.abandon(ws[i].Name)
+			if round+1 >= maxRounds {
+				return fmt.Errorf("%w: %s after %d rounds", errCollectiveTimeout, ws[i].Name, maxRounds)
+			}
+			chosen[i] = (chosen[i] + 1) % len(strategies)
+			sess.strategyLogger(s.name).Warnf("%s timed out on strategy %s, retrying on %s", ws[i].Name, s.name, strategies[chosen[i]].name)
+			pending = append(pending, i)
+		}
+		if len(pending) == 0 {
+			if round == 0 && !strings.HasPrefix(w.Name, "kungfu::") {
+				sess.chunks.record(n, maxDuration(durations))
+			}
+			return nil
+		}
+	}
+}
+
+// runChunkWithTimeout runs w on s as an attempt of the given name, and returns true if it didn't finish within timeout.
+// The attempt runs on copies of the buffers of w, and the results are copied to w.RecvBuf only if it finished.
+// The receives of an attempt that didn't finish are cancelled, and it doesn't access w after that.
+func (sess *Session) runChunkWithTimeout(w kb.Workspace, name string, s strategy, codec Codec, timeout time.Duration) (bool, error) {
+	input := kb.NewVector(w.SendBuf.Count, w.SendBuf.Type)
+	input.CopyFrom(w.SendBuf)
+	attempt := kb.Workspace{
+		SendBuf: input,
+		RecvBuf: kb.NewVector(w.RecvBuf.Count, w.RecvBuf.Type),
+		OP:      w.OP,
+		Name:    name,
+
+		Compensated: w.Compensated,
+	}
+	abandon := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		messages := s.sends(sess.rank)
+		done <- sess.stats.timeChunk(s.name, messages, messages*attempt.SendBuf.Count*attempt.SendBuf.Type.Size(), func() error {
+			return sess.runGraphsWith(attempt, connection.NoFlag, codec, abandon, s.graphs()...)
+		})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return false, err
+		}
+		w.RecvBuf.CopyFrom(attempt.RecvBuf)
+		return false, nil
+	case <-time.After(timeout):
+		close(abandon)
+		return true, nil
+	}
+}
+
+// agreeOnTimeouts returns the chunks that timed out on any peer in the given round.
+func (sess *Session) agreeOnTimeouts(name string, round int, timedOut []bool, s strategy) ([]bool, error) {
+	x := kb.NewVector(len(timedOut), kb.I8)
+	y := kb.NewVector(len(timedOut), kb.I8)
+	for i, t := range timedOut {
+		x.AsI8()[i] = boolToInt8(t)
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: fmt.Sprintf("kungfu::fallback:%s:%d", name, round)}
+	if err := sess.runGraphs(w, s.graphs()...); err != nil {
+		return nil, err
+	}
+	failed := make([]bool, len(timedOut))
+	for i, v := range y.AsI8() {
+		failed[i] = v > 0
+	}
+	return failed, nil
+}
@@ -0,0 +1,20 @@
+package session
+
+import "testing"
+
+func Test_attemptNames(t *testing.T) {
+	a := newAttemptNames()
+	if got := a.name("grad"); got != "grad" {
+		t.Errorf("first attempt is named %q", got)
+	}
+	a.abandon("grad")
+	a.abandon("grad")
+	for i := 0; i < 2; i++ { // the retry, and the attempts of later collectives
+		if got := a.name("grad"); got != "grad:attempt:2" {
+			t.Errorf("attempt after abandoning twice is named %q", got)
+		}
+	}
+	if got := a.name("bias"); got != "bias" {
+		t.Errorf("other chunks keep their names: %q", got)
+	}
+}
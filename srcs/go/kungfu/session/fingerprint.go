@@ -0,0 +1,146 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+const maxFingerprintLen = 1024
+
+// Fingerprint describes the build and the environment of a peer, which must be the same on all peers of a session.
+type Fingerprint map[string]string
+
+var fingerprints = struct {
+	sync.Mutex
+	extra Fingerprint
+}{extra: make(Fingerprint)}
+
+// SetFingerprint adds a field to the fingerprint of this peer, e.g. the CUDA and NCCL versions of a framework.
+// It must be called before the peer starts to be checked with the first session.
+func SetFingerprint(key, value string) {
+	fingerprints.Lock()
+	defer fingerprints.Unlock()
+	fingerprints.extra[key] = value
+}
+
+// LocalFingerprint returns the fingerprint of this peer.
+func (sess *Session) LocalFingerprint() Fingerprint {
+	f := Fingerprint{
+		`version`:   kb.Version,
+		`protocol`:  strconv.Itoa(int(connection.ProtocolVersion)),
+		`transport`: sess.client.Transport(),
+		`dtypes`:    strings.Join(kb.DataTypeNames(), ","),
+		`f16-simd`:  strconv.FormatBool(kb.F16SIMD()),
+	}
+	fingerprints.Lock()
+	defer fingerprints.Unlock()
+	for k, v := range fingerprints.extra {
+		f[k] = v
+	}
+	return f
+}
+
+// FingerprintDiff is a field of the fingerprints that differs between peers.
+type FingerprintDiff struct {
+	Key    string
+	Values map[string]plan.PeerList // the peers of each value, the empty value for peers without the field
+}
+
+func (d FingerprintDiff) String() string {
+	var values []string
+	for v := range d.Values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("%q on %s", v, d.Values[v]))
+	}
+	return d.Key + ": " + strings.Join(parts, ", ")
+}
+
+// FingerprintMismatchError is returned by CheckFingerprint if the fingerprints of peers differ.
+type FingerprintMismatchError struct {
+	Diffs []FingerprintDiff
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	var lines []string
+	for _, d := range e.Diffs {
+		lines = append(lines, "\t"+d.String())
+	}
+	return fmt.Sprintf("peers have different fingerprints:\n%s", strings.Join(lines, "\n"))
+}
+
+func (e *FingerprintMismatchError) FailureKind() failure.Kind {
+	return failure.ProtocolMismatch
+}
+
+// CheckFingerprint checks that all peers have the same fingerprint, so that peers of different builds or environments
+// fail at the start instead of in the middle of training. If they differ, the fingerprints are gathered to all peers,
+// which return a *FingerprintMismatchError listing the peers of each value of the fields that differ.
+func (sess *Session) CheckFingerprint() error {
+	bs, err := json.Marshal(sess.LocalFingerprint()) // keys are sorted
+	if err != nil {
+		return err
+	}
+	if len(bs) > maxFingerprintLen {
+		return fmt.Errorf("fingerprint is longer than %d bytes", maxFingerprintLen)
+	}
+	ok, err := sess.BytesConsensus(bs, "kungfu::fingerprint")
+	if err != nil || ok {
+		return err
+	}
+	k := len(sess.peers)
+	x := kb.NewVector(maxFingerprintLen, kb.U8)
+	copy(x.Data, bs)
+	y := kb.NewVector(maxFingerprintLen*k, kb.U8)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: "kungfu::fingerprints"}
+	if err := sess.AllGather(w); err != nil {
+		return err
+	}
+	fs := make([]Fingerprint, k)
+	for i := range fs {
+		bs := bytes.TrimRight(y.Data[i*maxFingerprintLen:(i+1)*maxFingerprintLen], "\x00")
+		if err := json.Unmarshal(bs, &fs[i]); err != nil {
+			return err
+		}
+	}
+	return &FingerprintMismatchError{Diffs: diffFingerprints(sess.peers, fs)}
+}
+
+// diffFingerprints returns the fields of fs that differ between peers, in order of their keys.
+func diffFingerprints(peers plan.PeerList, fs []Fingerprint) []FingerprintDiff {
+	keys := make(map[string]struct{})
+	for _, f := range fs {
+		for k := range f {
+			keys[k] = struct{}{}
+		}
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var diffs []FingerprintDiff
+	for _, k := range sorted {
+		values := make(map[string]plan.PeerList)
+		for i, f := range fs {
+			values[f[k]] = append(values[f[k]], peers[i])
+		}
+		if len(values) > 1 {
+			diffs = append(diffs, FingerprintDiff{Key: k, Values: values})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,36 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_diffFingerprints(t *testing.T) {
+	peers := plan.PeerList{{IPv4: 1, Port: 1}, {IPv4: 1, Port: 2}, {IPv4: 2, Port: 1}}
+	fs := []Fingerprint{
+		{`version`: `0.2.2`, `nccl`: `2708`},
+		{`version`: `0.2.2`, `nccl`: `2708`},
+		{`version`: `0.2.2`},
+	}
+	diffs := diffFingerprints(peers, fs)
+	if len(diffs) != 1 || diffs[0].Key != `nccl` {
+		t.Fatalf("unexpected diffs: %v", diffs)
+	}
+	if vs := diffs[0].Values; len(vs[`2708`]) != 2 || len(vs[``]) != 1 || vs[``][0] != peers[2] {
+		t.Errorf("unexpected peers of values: %v", vs)
+	}
+	if diffs := diffFingerprints(peers, []Fingerprint{fs[0], fs[1], fs[0]}); len(diffs) != 0 {
+		t.Errorf("unexpected diffs of same fingerprints: %v", diffs)
+	}
+}
+
+func Test_mismatchKinds(t *testing.T) {
+	for _, e := range []error{errStrategyHashMismatch, errSyncPeriodMismatch, errChangeRejected, errStreamMismatch} {
+		if err := fmt.Errorf("%w: %s", e, "x"); failure.Of(err) != failure.ProtocolMismatch {
+			t.Errorf("%v is of kind %s, want %s", err, failure.Of(err), failure.ProtocolMismatch)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package session
+
+// Smoothing factors of the level, trend and seasonal offsets of forecasts.
+const (
+	forecastAlpha = 0.5
+	forecastBeta  = 0.1
+	forecastGamma = 0.3
+)
+
+// A forecaster predicts the chunk durations of a strategy by additive Holt-Winters smoothing of the mean durations
+// of its chunks in each monitoring interval: a level, a linear trend, and a seasonal offset for each interval of a period,
+// so that recurring interference, e.g. nightly backup traffic, is predicted from the previous periods before it starts.
+type forecaster struct {
+	period   int // in intervals, 0 for level and trend only
+	level    float64
+	trend    float64
+	seasonal []float64
+	n        int // observed intervals
+}
+
+func newForecaster(period int) *forecaster {
+	return &forecaster{period: period, seasonal: make([]float64, period)}
+}
+
+func (f *forecaster) season(t int) float64 {
+	if f.period == 0 {
+		return 0
+	}
+	return f.seasonal[t%f.period]
+}
+
+// observe adds the mean duration x of the next interval, in nanoseconds.
+func (f *forecaster) observe(x float64) {
+	s := f.season(f.n)
+	if f.n == 0 {
+		f.level = x - s
+	} else {
+		prev := f.level
+		f.level = forecastAlpha*(x-s) + (1-forecastAlpha)*(f.level+f.trend)
+		f.trend = forecastBeta*(f.level-prev) + (1-forecastBeta)*f.trend
+	}
+	if f.period > 0 {
+		f.seasonal[f.n%f.period] = forecastGamma*(x-f.level) + (1-forecastGamma)*s
+	}
+	f.n++
+}
+
+// skip advances over an interval without observations, e.g. while the strategy is suspended, so that the seasons
+// stay aligned. The trend is dropped rather than extrapolated over the gap, as it's stale once the strategy resumes.
+func (f *forecaster) skip() {
+	if f.n == 0 {
+		return
+	}
+	f.trend = 0
+	f.n++
+}
+
+// forecast returns the predicted mean duration of the h-th interval from now, in nanoseconds.
+func (f *forecaster) forecast(h int) float64 {
+	if f.n == 0 {
+		return 0
+	}
+	y := f.level + float64(h)*f.trend + f.season(f.n-1+h)
+	if y < 0 {
+		return 0
+	}
+	return y
+}
+
+// peak returns the max forecast of the next h intervals.
+func (f *forecaster) peak(h int) float64 {
+	var y float64
+	for i := 1; i <= h; i++ {
+		if x := f.forecast(i); x > y {
+			y = x
+		}
+	}
+	return y
+}
+
+// observeInterval updates the forecaster of strategy name with the mean duration of the chunks sampled since its last call.
+// The interval is skipped if there were none, or if the strategy is suspended, whose chunks are only canaries.
+// It returns the peak forecast of the next horizon intervals.
+func (s *strategyStats) observeInterval(name string, suspended bool, period, horizon int) float64 {
+	s.Lock()
+	defer s.Unlock()
+	f, ok := s.forecasts[name]
+	if !ok || f.period != period {
+		f = newForecaster(period)
+		s.forecasts[name] = f
+	}
+	st, ok := s.stats[name]
+	if ok && st.window > 0 && !suspended {
+		f.observe(st.windowTotal / float64(st.window))
+	} else {
+		f.skip()
+	}
+	if ok {
+		st.window, st.windowTotal = 0, 0
+	}
+	return f.peak(horizon)
+}
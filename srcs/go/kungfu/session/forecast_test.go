@@ -0,0 +1,42 @@
+package session
+
+import "testing"
+
+func Test_forecaster(t *testing.T) {
+	const period = 8
+	f := newForecaster(period)
+	for i := 0; i < 10*period; i++ {
+		x := 10.0
+		if i%period == period-1 {
+			x = 30 // slow down in the last interval of each period
+		}
+		f.observe(x)
+	}
+	if y := f.forecast(1); y > 15 {
+		t.Fatalf("forecast %f of a normal interval, want about 10", y)
+	}
+	if y := f.forecast(period); y < 20 { // the next slow interval
+		t.Fatalf("forecast %f of a slow interval, want about 30", y)
+	}
+	if y := f.peak(period); y < 20 {
+		t.Fatalf("peak %f of a period, want about 30", y)
+	}
+}
+
+func Test_forecasterSkip(t *testing.T) {
+	f := newForecaster(0)
+	for i := 0; i < 20; i++ {
+		f.observe(float64(10 + i)) // slowing down until the strategy is suspended
+	}
+	for i := 0; i < 100; i++ {
+		f.skip()
+	}
+	if y := f.forecast(1); y > 40 {
+		t.Fatalf("forecast %f after a suspension, extrapolates the trend before it", y)
+	}
+	f = newForecaster(4)
+	f.skip()
+	if f.n != 0 {
+		t.Errorf("skipped %d intervals before any observation", f.n)
+	}
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+// Scatter sends the i-th of the equal slices of w.SendBuf of rank 0 to the peer of rank i, into its w.RecvBuf.
+// w.SendBuf of the other peers is not used.
+func (sess *Session) Scatter(w kb.Workspace) error {
+	defer sess.track("scatter", w)()
+	if err := checkHost(w); err != nil {
+		return err
+	}
+	return sess.runScatter(w)
+}
+
+func (sess *Session) runScatter(w kb.Workspace) error {
+	if sess.rank != defaultRoot {
+		return sess.recvChunked(sess.peers[defaultRoot], w.Name, w.RecvBuf.Data)
+	}
+	count := w.RecvBuf.Count
+	return sess.fromAll(func(rank int, peer plan.PeerID) error {
+		sendBuf := w.SendBuf.Slice(count*rank, count*(rank+1))
+		if rank == sess.rank {
+			w.RecvBuf.CopyFrom(sendBuf)
+			return nil
+		}
+		return sess.sendChunked(peer, w.Name, sendBuf.Data)
+	}, "runScatter")
+}
+
+// GatherV gathers the vectors of all peers at rank 0, which may have different counts, e.g. evaluation results of dataset shards.
+// It returns the vectors concatenated in the order of ranks and their counts at rank 0, and nil on other peers.
+// The counts are exchanged before the vectors, which must have the same type.
+func (sess *Session) GatherV(x *kb.Vector, name string) (*kb.Vector, []int, error) {
+	defer sess.track("gather", kb.Workspace{SendBuf: x})()
+	np := len(sess.peers)
+	c := kb.NewVector(1, kb.I64)
+	c.AsI64()[0] = int64(x.Count)
+	cs := kb.NewVector(np, kb.I64)
+	if err := sess.runGather(kb.Workspace{SendBuf: c, RecvBuf: cs, Name: name + ":counts"}); err != nil {
+		return nil, nil, err
+	}
+	if sess.rank != defaultRoot {
+		return nil, nil, sess.sendChunked(sess.peers[defaultRoot], name, x.Data)
+	}
+	counts, offsets, total := countsOf(cs)
+	y := kb.NewVector(total, x.Type)
+	err := sess.fromAll(func(rank int, peer plan.PeerID) error {
+		recvBuf := y.Slice(offsets[rank], offsets[rank]+counts[rank])
+		if rank == sess.rank {
+			recvBuf.CopyFrom(x)
+			return nil
+		}
+		return sess.recvChunked(peer, name, recvBuf.Data)
+	}, "GatherV")
+	if err != nil {
+		return nil, nil, err
+	}
+	return y, counts, nil
+}
+
+// ScatterV sends consecutive slices of x of rank 0, of the given counts, to the peers in the order of ranks,
+// e.g. dataset shards of different sizes, and returns the slice received by this peer.
+// x and counts of the other peers are not used, except for the type of x. The counts are sent before the slices.
+func (sess *Session) ScatterV(x *kb.Vector, counts []int, name string) (*kb.Vector, error) {
+	np := len(sess.peers)
+	cs := kb.NewVector(np, kb.I64)
+	if sess.rank == defaultRoot {
+		if len(counts) != np {
+			return nil, fmt.Errorf("%d counts for %d peers", len(counts), np)
+		}
+		var total int
+		for i, n := range counts {
+			cs.AsI64()[i] = int64(n)
+			total += n
+		}
+		if total != x.Count {
+			return nil, fmt.Errorf("counts of %d elements for a vector of %d", total, x.Count)
+		}
+	}
+	c := kb.NewVector(1, kb.I64)
+	if err := sess.runScatter(kb.Workspace{SendBuf: cs, RecvBuf: c, Name: name + ":counts"}); err != nil {
+		return nil, err
+	}
+	y := kb.NewVector(int(c.AsI64()[0]), x.Type)
+	defer sess.track("scatter", kb.Workspace{SendBuf: y})()
+	if sess.rank != defaultRoot {
+		return y, sess.recvChunked(sess.peers[defaultRoot], name, y.Data)
+	}
+	_, offsets, _ := countsOf(cs)
+	err := sess.fromAll(func(rank int, peer plan.PeerID) error {
+		sendBuf := x.Slice(offsets[rank], offsets[rank]+counts[rank])
+		if rank == sess.rank {
+			y.CopyFrom(sendBuf)
+			return nil
+		}
+		return sess.sendChunked(peer, name, sendBuf.Data)
+	}, "ScatterV")
+	if err != nil {
+		return nil, err
+	}
+	return y, nil
+}
+
+func countsOf(cs *kb.Vector) ([]int, []int, int) {
+	counts := make([]int, cs.Count)
+	offsets := make([]int, cs.Count)
+	var total int
+	for i, n := range cs.AsI64() {
+		counts[i] = int(n)
+		offsets[i] = total
+		total += int(n)
+	}
+	return counts, offsets, total
+}
+
+// fromAll runs f for all peers in parallel, at the root of gather and scatter.
+func (sess *Session) fromAll(f func(rank int, peer plan.PeerID) error, name string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(sess.peers))
+	for rank, peer := range sess.peers {
+		wg.Add(1)
+		go func(rank int, peer plan.PeerID) {
+			errs[rank] = f(rank, peer)
+			wg.Done()
+		}(rank, peer)
+	}
+	wg.Wait()
+	return utils.MergeErrors(errs, name)
+}
+
+// chunksOf returns the number of messages of config.ChunkSize bytes, and of at most maxMessageBytes,
+// to transfer n bytes, at least 1.
+func chunksOf(n int) int {
+	k := (n + config.ChunkSize - 1) / config.ChunkSize
+	if g := ceilDiv(n, maxMessageBytes); g > k {
+		k = g
+	}
+	if k > 1 {
+		return k
+	}
+	return 1
+}
+
+// sendChunked sends data to peer in messages of config.ChunkSize bytes, so that large buffers don't block
+// the connection for other collectives.
+func (sess *Session) sendChunked(peer plan.PeerID, name string, data []byte) error {
+	k := chunksOf(len(data))
+	for i := 0; i < k; i++ {
+		chunk := data[i*len(data)/k : (i+1)*len(data)/k]
+		if err := sess.send(peer.WithName(sess.tagged(chunkName(name, i, k))), chunk, connection.NoFlag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recvChunked receives the messages sent by sendChunked of peer into buf, which has the size of the sent data.
+func (sess *Session) recvChunked(peer plan.PeerID, name string, buf []byte) error {
+	k := chunksOf(len(buf))
+	for i := 0; i < k; i++ {
+		chunk := buf[i*len(buf)/k : (i+1)*len(buf)/k]
+		m, err := sess.collectiveHandler.Recv(peer.WithName(sess.tagged(chunkName(name, i, k))))
+		if err != nil {
+			return err
+		}
+		if len(m.Data) != len(chunk) {
+			return fmt.Errorf("%s: received %d bytes, want %d", name, len(m.Data), len(chunk))
+		}
+		copy(chunk, m.Data)
+		connection.PutBuf(m.Data)
+	}
+	return nil
+}
+
+// chunkName returns the name of the message of the i-th of k chunks, the name itself if k is 1.
+func chunkName(name string, i, k int) string {
+	if k == 1 {
+		return name
+	}
+	return name + ":chunk:" + strconv.Itoa(i)
+}
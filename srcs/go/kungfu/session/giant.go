@@ -0,0 +1,49 @@
+package session
+
+import (
+	"fmt"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// maxMessageBytes bounds the bytes of a collective, well below the 32-bit lengths of messages.
+var maxMessageBytes = 1 << 30
+
+// giantParts splits w into parts of at most maxMessageBytes, and returns nil if w is not larger.
+func giantParts(w kb.Workspace) ([]kb.Workspace, error) {
+	n := len(w.RecvBuf.Data)
+	if n <= maxMessageBytes {
+		return nil, nil
+	}
+	if !w.OP.ElementWise() {
+		return nil, fmt.Errorf("%s of %d bytes is too large for %s, at most %d bytes", w.Name, n, w.OP, maxMessageBytes)
+	}
+	return w.Split(plan.EvenPartition, ceilDiv(n, maxMessageBytes)), nil
+}
+
+// giantIntervals splits count elements of size bytes into intervals of at most maxMessageBytes,
+// e.g. of a SendBuf gathered into the blocks of a RecvBuf, which can't be split as a Workspace.
+func giantIntervals(count, size int) []plan.Interval {
+	r := plan.Interval{Begin: 0, End: count}
+	if k := ceilDiv(count*size, maxMessageBytes); k > 1 {
+		return plan.EvenPartition(r, k)
+	}
+	return []plan.Interval{r}
+}
+
+// runGiant runs f on the parts of w in order, as separate collectives, if w is larger than maxMessageBytes,
+// so that huge workspaces, e.g. embedding tables, don't overflow the counts and lengths of messages.
+// It returns false if w is not larger, for the caller to run it.
+func runGiant(w kb.Workspace, f func(kb.Workspace) error) (bool, error) {
+	ws, err := giantParts(w)
+	if err != nil {
+		return true, err
+	}
+	for _, w := range ws {
+		if err := f(w); err != nil {
+			return true, err
+		}
+	}
+	return len(ws) > 0, nil
+}
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+func Test_giantParts(t *testing.T) {
+	defer func(n int) { maxMessageBytes = n }(maxMessageBytes)
+	maxMessageBytes = 1000
+	for _, tt := range []struct {
+		count int
+		want  int
+	}{
+		{250, 0},
+		{251, 2},
+		{1000, 4},
+	} {
+		w := kb.Workspace{SendBuf: kb.NewVector(tt.count, kb.F32), RecvBuf: kb.NewVector(tt.count, kb.F32), OP: kb.SUM, Name: "x"}
+		ws, err := giantParts(w)
+		if err != nil || len(ws) != tt.want {
+			t.Errorf("giantParts(%d bytes) = %d parts, %v, want %d", 4*tt.count, len(ws), err, tt.want)
+		}
+		var n int
+		for _, p := range ws {
+			if len(p.RecvBuf.Data) > maxMessageBytes {
+				t.Errorf("part of %d bytes, want at most %d", len(p.RecvBuf.Data), maxMessageBytes)
+			}
+			n += p.RecvBuf.Count
+		}
+		if len(ws) > 0 && n != tt.count {
+			t.Errorf("parts cover %d elements, want %d", n, tt.count)
+		}
+	}
+	w := kb.Workspace{SendBuf: kb.NewVector(1000, kb.F32), RecvBuf: kb.NewVector(1000, kb.F32), OP: kb.ADASUM, Name: "x"}
+	if _, err := giantParts(w); err == nil {
+		t.Errorf("giant AdaSum workspace is split")
+	}
+}
+
+func Test_giantIntervals(t *testing.T) {
+	defer func(n int) { maxMessageBytes = n }(maxMessageBytes)
+	maxMessageBytes = 1000
+	for _, tt := range []struct {
+		count int
+		want  int
+	}{
+		{0, 1},
+		{250, 1},
+		{251, 2},
+		{1000, 4},
+	} {
+		rs := giantIntervals(tt.count, 4)
+		if len(rs) != tt.want || rs[len(rs)-1].End != tt.count {
+			t.Errorf("giantIntervals(%d) = %v, want %d intervals", tt.count, rs, tt.want)
+		}
+		for _, r := range rs {
+			if 4*r.Len() > maxMessageBytes {
+				t.Errorf("interval of %d bytes, want at most %d", 4*r.Len(), maxMessageBytes)
+			}
+		}
+	}
+	if k := chunksOf(4000); 4000/k > maxMessageBytes {
+		t.Errorf("chunksOf(4000) = %d, want chunks of at most %d bytes", k, maxMessageBytes)
+	}
+}
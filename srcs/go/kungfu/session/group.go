@@ -0,0 +1,54 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// Group returns the sub-session over the peers of the named group, in the order of their ranks in sess.
+// Collectives of the sub-session only involve the members of the group, and don't interfere with collectives of sess.
+// It must be called by all members of the group.
+func (sess *Session) Group(name string) (*Session, error) {
+	sess.Lock()
+	defer sess.Unlock()
+	if g, ok := sess.subSessions[name]; ok {
+		return g, nil
+	}
+	members, ok := sess.groups.Select(name, sess.peers)
+	if !ok {
+		return nil, fmt.Errorf("group %q not found", name)
+	}
+	g, ok := New(sess.strategyName, sess.self, members, nil, sess.client, sess.collectiveHandler)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a member of group %q", sess.self, name)
+	}
+	g.tag = sess.tag + "group:" + name + "/"
+	g.logger = sess.logger.With(log.F("group", name))
+	g.qos = sess.qos
+	sess.subSessions[name] = g
+	return g, nil
+}
+
+// Subset returns a sub-session over the given peers of sess, e.g. those reachable in a network partition,
+// which is not cached as the peers may differ from call to call. It must be called by all peers of pl with the same name and peers.
+func (sess *Session) Subset(name string, pl plan.PeerList) (*Session, error) {
+	for _, p := range pl {
+		if !sess.peers.Contains(p) {
+			return nil, fmt.Errorf("%s is not a peer of the session", p)
+		}
+	}
+	s, ok := New(sess.strategyName, sess.self, pl, nil, sess.client, sess.collectiveHandler)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a member of subset %q", sess.self, name)
+	}
+	s.tag = sess.tag + "subset:" + name + "/"
+	s.logger = sess.logger.With(log.F("subset", name))
+	s.qos = sess.qos
+	return s, nil
+}
+
+func (sess *Session) tagged(name string) string {
+	return sess.tag + sess.rejoinPrefix() + name
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"fmt"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// runHalvingDoubling runs all reduce by recursive halving reduce-scatter followed by recursive doubling all-gather (Rabenseifner).
+// It takes 2*log(p) steps and sends 2*(p-1)/p of the data per peer, which suits medium sized messages.
+// If p is not a power of 2, the first 2*r peers are paired and only the odd peer of each pair takes part,
+// where r = p - 2^floor(log(p)).
+func (sess *Session) runHalvingDoubling(w kb.Workspace) error {
+	if w.IsEmpty() {
+		return nil
+	}
+	w.Forward()
+	n := len(sess.peers)
+	if n == 1 {
+		return nil
+	}
+	m := 1
+	for m*2 <= n {
+		m *= 2
+	}
+	r := n - m
+	buf := w.RecvBuf
+	// pair the first 2*r peers
+	if sess.rank < 2*r {
+		if sess.rank%2 == 0 {
+			if err := sess.hdSend(sess.rank+1, w.Name, "pre", buf); err != nil {
+				return err
+			}
+			return sess.hdRecv(sess.rank+1, w.Name, "post", buf, nil)
+		}
+		if err := sess.hdRecv(sess.rank-1, w.Name, "pre", buf, &w.OP); err != nil {
+			return err
+		}
+	}
+	vrank := sess.rank - r
+	if sess.rank < 2*r {
+		vrank = sess.rank / 2
+	}
+	realRank := func(v int) int {
+		if v < r {
+			return 2*v + 1
+		}
+		return v + r
+	}
+	type segment struct{ begin, end int }
+	var segs []segment
+	seg := segment{0, buf.Count}
+	// reduce-scatter: halve the segment at each step
+	for d := m / 2; d >= 1; d /= 2 {
+		partner := realRank(vrank ^ d)
+		mid := seg.begin + (seg.end-seg.begin)/2
+		keep, give := segment{seg.begin, mid}, segment{mid, seg.end}
+		if vrank&d != 0 {
+			keep, give = give, keep
+		}
+		step := fmt.Sprintf("rs:%d", d)
+		if err := sess.hdSend(partner, w.Name, step, buf.Slice(give.begin, give.end)); err != nil {
+			return err
+		}
+		if err := sess.hdRecv(partner, w.Name, step, buf.Slice(keep.begin, keep.end), &w.OP); err != nil {
+			return err
+		}
+		segs = append(segs, seg)
+		seg = keep
+	}
+	// all-gather: double the segment in the reverse order
+	for d := 1; d < m; d *= 2 {
+		partner := realRank(vrank ^ d)
+		parent := segs[len(segs)-1]
+		segs = segs[:len(segs)-1]
+		other := segment{parent.begin, seg.begin}
+		if seg.begin == parent.begin {
+			other = segment{seg.end, parent.end}
+		}
+		step := fmt.Sprintf("ag:%d", d)
+		if err := sess.hdSend(partner, w.Name, step, buf.Slice(seg.begin, seg.end)); err != nil {
+			return err
+		}
+		if err := sess.hdRecv(partner, w.Name, step, buf.Slice(other.begin, other.end), nil); err != nil {
+			return err
+		}
+		seg = parent
+	}
+	if sess.rank < 2*r {
+		return sess.hdSend(sess.rank-1, w.Name, "post", buf)
+	}
+	return nil
+}
+
+// hdSend and hdRecv skip empty segments, which are empty on both sides.
+func (sess *Session) hdSend(rank int, name, step string, b *kb.Vector) error {
+	if b.Count == 0 {
+		return nil
+	}
+	peer := sess.peers[rank]
+	return sess.send(peer.WithName(sess.tagged(name+":hd:"+step)), b.Data, connection.NoFlag)
+}
+
+// hdRecv receives into b, or reduces into b if op is not nil.
+func (sess *Session) hdRecv(rank int, name, step string, b *kb.Vector, op *kb.OP) error {
+	if b.Count == 0 {
+		return nil
+	}
+	peer := sess.peers[rank]
+	m, err := sess.collectiveHandler.Recv(peer.WithName(sess.tagged(name + ":hd:" + step)))
+	if err != nil {
+		return err
+	}
+	defer connection.PutBuf(m.Data)
+	if len(m.Data) != len(b.Data) {
+		return fmt.Errorf("halving-doubling %s: received %d bytes from %s, expected %d", step, len(m.Data), peer, len(b.Data))
+	}
+	x := &kb.Vector{Data: m.Data, Count: b.Count, Type: b.Type}
+	if op == nil {
+		b.CopyFrom(x)
+	} else {
+		kb.Transform2(b, b, x, *op)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package session
+
+import (
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// SetRackHints exchanges the rack of each peer and switches the global strategy to a three-level hierarchy
+// (peers of a host, hosts of a rack, racks) if peers are spread over more than one rack.
+func (sess *Session) SetRackHints(rack string) error {
+	racks, err := sess.allGatherLabel(plan.LabelRack, rack)
+	if err != nil {
+		return err
+	}
+	distinct := make(map[string]struct{})
+	for _, r := range racks {
+		distinct[r] = struct{}{}
+	}
+	if len(distinct) <= 1 {
+		return nil
+	}
+	sess.logger.Debugf("peers are spread over %d racks, using hierarchical strategy", len(distinct))
+	levels := plan.GenHierarchy(sess.peers, racks)
+	bcastGraph := plan.MergeGraphs(levels...)
+	s := strategy{
+		reduceGraph: plan.GenDefaultReduceGraph(bcastGraph),
+		bcastGraph:  bcastGraph,
+		stages:      plan.HierarchySchedule(levels),
+	}
+	return sess.SetGlobalStrategy(named("RACK_HIERARCHY", strategyList{s}))
+}
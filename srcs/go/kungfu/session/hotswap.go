@@ -0,0 +1,118 @@
+package session
+
+import (
+	"hash/fnv"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// hotSwapHistory is the number of generations of the global strategies kept for the collectives of earlier steps.
+const hotSwapHistory = 8
+
+var errInconsistentSwap = failure.New(failure.ProtocolMismatch, "peers proposed different strategies")
+
+// generation is the global strategies used from a step on.
+type generation struct {
+	from       int64
+	strategies strategyList
+}
+
+// hotSwap guards the global strategies, by the step that a collective is submitted at.
+// A swap commits at a step after the current step of all peers, which they agree on:
+// collectives of earlier steps run on the old strategies and collectives from that step on run on the new ones,
+// so that in-flight collectives are not interrupted, whatever order the collectives of a step are submitted in.
+// It requires that all peers submit a collective at the same step.
+type hotSwap struct {
+	sync.Mutex
+	gens []generation // in the order of from, gens[0] is used for all steps before gens[1]
+}
+
+func newHotSwap(sl strategyList) *hotSwap {
+	return &hotSwap{gens: []generation{{strategies: sl}}}
+}
+
+// at returns the global strategies of the collectives of the given step.
+func (h *hotSwap) at(step int64) strategyList {
+	h.Lock()
+	defer h.Unlock()
+	for i := len(h.gens) - 1; i > 0; i-- {
+		if h.gens[i].from <= step {
+			return h.gens[i].strategies
+		}
+	}
+	return h.gens[0].strategies
+}
+
+// latest returns the strategies used after all committed swaps.
+func (h *hotSwap) latest() strategyList {
+	h.Lock()
+	defer h.Unlock()
+	return h.gens[len(h.gens)-1].strategies
+}
+
+// set replaces the global strategies immediately, dropping any committed swap.
+func (h *hotSwap) set(sl strategyList) {
+	h.Lock()
+	defer h.Unlock()
+	h.gens = []generation{{strategies: sl}}
+}
+
+// commit uses sl from the given step on, in place of the swaps committed at the same or later steps.
+func (h *hotSwap) commit(from int64, sl strategyList) {
+	h.Lock()
+	defer h.Unlock()
+	i := len(h.gens)
+	for i > 1 && h.gens[i-1].from >= from {
+		i--
+	}
+	h.gens = append(h.gens[:i], generation{from: from, strategies: sl})
+	if n := len(h.gens); n > hotSwapHistory {
+		h.gens = h.gens[n-hotSwapHistory:]
+	}
+}
+
+// agreeNextStep returns the step after the current step of all peers, and checks that all peers proposed digest.
+// Changes that apply from the returned step don't affect in-flight collectives.
+func (sess *Session) agreeNextStep(digest []byte, name string) (int64, error) {
+	var h int64
+	{
+		f := fnv.New64a()
+		f.Write(digest)
+		h = int64(f.Sum64() >> 1)
+	}
+	x := kb.NewVector(3, kb.I64)
+	y := kb.NewVector(3, kb.I64)
+	copy(x.AsI64(), []int64{sess.Step(), h, -h})
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: name}
+	// the global strategies may differ among peers during a swap, so a star is used
+	if err := sess.runStrategies(w, plan.EvenPartition, createStarStrategies(sess.peers)); err != nil {
+		return 0, err
+	}
+	r := y.AsI64()
+	if r[1] != -r[2] {
+		return 0, errInconsistentSwap
+	}
+	return r[0] + 1, nil
+}
+
+// SwapGlobalStrategy replaces the global strategies by sl without stopping the world, it must be called by all peers.
+// Unlike SetGlobalStrategy, it doesn't wait for in-flight collectives: sl is used from the next step of all peers,
+// i.e. after AdvanceStep or the next step boundary.
+func (sess *Session) SwapGlobalStrategy(sl strategyList) error {
+	sess.Lock()
+	defer sess.Unlock()
+	from, err := sess.agreeNextStep(sl.digestBytes(), "kungfu::hotswap")
+	if err != nil {
+		return err
+	}
+	sess.swap.commit(from, sl)
+	return nil
+}
+
+// nextGlobalStrategies returns the global strategies of the collectives of the current step.
+func (sess *Session) nextGlobalStrategies() strategyList {
+	return sess.swap.at(sess.Step())
+}
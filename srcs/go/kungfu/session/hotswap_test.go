@@ -0,0 +1,34 @@
+package session
+
+import "testing"
+
+func Test_hotSwap(t *testing.T) {
+	old := strategyList{{name: "old"}}
+	next := strategyList{{name: "next"}}
+	last := strategyList{{name: "last"}}
+	h := newHotSwap(old)
+	h.commit(3, next)
+	for step, want := range []string{"old", "old", "old", "next", "next"} {
+		if got := h.at(int64(step))[0].name; got != want {
+			t.Errorf("step %d uses %s, want %s", step, got, want)
+		}
+	}
+	h.commit(5, last)
+	h.commit(4, next) // replaces the swap at 5
+	if got := h.at(5)[0].name; got != "next" {
+		t.Errorf("step 5 uses %s, want next", got)
+	}
+	if got := h.latest()[0].name; got != "next" {
+		t.Errorf("latest strategies %s, want next", got)
+	}
+	for i := 0; i < 2*hotSwapHistory; i++ {
+		h.commit(int64(10+i), last)
+	}
+	if n := len(h.gens); n != hotSwapHistory {
+		t.Errorf("%d generations, want %d", n, hotSwapHistory)
+	}
+	h.set(old)
+	if got := h.at(100)[0].name; got != "old" {
+		t.Errorf("step 100 uses %s after set, want old", got)
+	}
+}
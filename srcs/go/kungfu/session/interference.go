@@ -0,0 +1,248 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const (
+	canarySize   = 64 * 1024
+	canaryRounds = 3
+)
+
+// MonitorConfig configures the suspension of strategies by MonitorStrategies.
+type MonitorConfig struct {
+	InterferenceThreshold float64 `json:"interference_threshold"` // suspend an active strategy slower than this times the others
+	Decay                 float64 `json:"decay"`                  // of the moving average of chunk durations in [0, 1], 0 for the mean of all chunks
+	MinSamples            int64   `json:"min_samples"`            // sampled chunks of a strategy before it can be suspended
+	Interval              int     `json:"interval"`               // check every Interval calls of MonitorStrategies, e.g. steps
+	ForecastHorizon       int     `json:"forecast_horizon"`       // suspend strategies predicted to slow down within this many checks, 0 to disable
+	ForecastPeriod        int     `json:"forecast_period"`        // checks in a period of recurring interference, e.g. a day, 0 for trends only
+}
+
+// DefaultMonitorConfig returns the MonitorConfig of new sessions.
+func DefaultMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		InterferenceThreshold: 1.5,
+		Decay:                 0,
+		MinSamples:            10,
+		Interval:              1,
+	}
+}
+
+var (
+	errInvalidMonitorConfig  = errors.New("invalid monitor config")
+	errMonitorConfigMismatch = failure.New(failure.ProtocolMismatch, "peers use different monitor configs")
+)
+
+func (c MonitorConfig) validate() error {
+	if c.InterferenceThreshold <= 1 || c.Decay < 0 || c.Decay > 1 || c.MinSamples < 1 || c.Interval < 1 || c.ForecastHorizon < 0 || c.ForecastPeriod < 0 {
+		return fmt.Errorf("%v: %+v", errInvalidMonitorConfig, c)
+	}
+	return nil
+}
+
+// SetMonitorConfig replaces the MonitorConfig, it must be called by all peers with the same config,
+// so that they make the same decisions. The config is not changed if any peer passes a different one.
+func (sess *Session) SetMonitorConfig(c MonitorConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	ok, err := sess.BytesConsensus(bs, "kungfu::monitor-config")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errMonitorConfigMismatch
+	}
+	sess.setMonitorConfig(c)
+	return nil
+}
+
+// SyncMonitorConfig replaces the MonitorConfig of all peers by that of rank 0, so that new peers catch up with existing ones.
+// It must be called by all peers, after rank 0 inherits the config of its previous session.
+func (sess *Session) SyncMonitorConfig() error {
+	bs, err := json.Marshal(sess.MonitorConfig())
+	if err != nil {
+		return err
+	}
+	if bs, err = sess.broadcastBytes(bs, "kungfu::monitor-config"); err != nil {
+		return err
+	}
+	var c MonitorConfig
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return err
+	}
+	sess.setMonitorConfig(c)
+	return nil
+}
+
+// InheritMonitorConfig copies the MonitorConfig of the previous session of this peer.
+func (sess *Session) InheritMonitorConfig(old *Session) {
+	sess.setMonitorConfig(old.MonitorConfig())
+}
+
+func (sess *Session) setMonitorConfig(c MonitorConfig) {
+	sess.Lock()
+	defer sess.Unlock()
+	sess.monitorConfig = c
+	sess.stats.setDecay(c.Decay)
+}
+
+// MonitorConfig returns the MonitorConfig in use.
+func (sess *Session) MonitorConfig() MonitorConfig {
+	sess.Lock()
+	defer sess.Unlock()
+	return sess.monitorConfig
+}
+
+// MonitorStrategies suspends the active strategy slowed down most by interference, if its chunk duration exceeds
+// the InterferenceThreshold times the mean of the other active strategies. If ForecastHorizon > 0, the duration of
+// a strategy is the max of its recent duration and the peak of its forecast over the horizon, so that a strategy is suspended
+// before a predicted slow down, rather than after its average crosses the threshold. Every config.CanaryPeriod, it runs a small canary
+// all reduce over the suspended strategies, and reactivates those whose canary takes at most config.ReactivationThreshold
+// times the canary of the active strategies, so that a strategy comes back once the interference disappears.
+// Peers agree on the durations by their max, so that they make the same decisions. It must be called by all peers,
+// e.g. at step boundaries.
+func (sess *Session) MonitorStrategies() error {
+	mc := sess.MonitorConfig()
+	if sess.monitorCalls++; sess.monitorCalls%mc.Interval != 0 {
+		return nil
+	}
+	sl := sess.swap.latest()
+	x := kb.NewVector(len(sl)+1, kb.F32)
+	y := kb.NewVector(len(sl)+1, kb.F32)
+	if time.Since(sess.lastCanary) >= config.CanaryPeriod {
+		x.AsF32()[0] = 1
+	}
+	for i, s := range sl {
+		var peak time.Duration
+		if mc.ForecastHorizon > 0 {
+			peak = time.Duration(sess.stats.observeInterval(s.name, s.suspended, mc.ForecastPeriod, mc.ForecastHorizon))
+		}
+		if samples, avg := sess.stats.recent(s.name); samples >= mc.MinSamples {
+			if peak > avg {
+				avg = peak
+			}
+			x.AsF32()[i+1] = float32(avg.Seconds())
+		}
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::monitor"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	if y.AsF32()[0] > 0 {
+		sess.lastCanary = time.Now()
+		if err := sess.reactivateStrategies(sl); err != nil {
+			return err
+		}
+	}
+	return sess.suspendInterfered(sl, y.AsF32()[1:], mc.InterferenceThreshold)
+}
+
+// suspendInterfered suspends the slowest active strategy of sl by the agreed durations of their chunks, if it exceeds
+// threshold times the mean of the others. A strategy without enough samples on any peer has duration 0.
+func (sess *Session) suspendInterfered(sl strategyList, avgs []float32, threshold float64) error {
+	worst := -1
+	var total float64
+	var n int
+	for i, s := range sl {
+		if s.suspended || s.standby || s.broken || avgs[i] <= 0 {
+			continue
+		}
+		total += float64(avgs[i])
+		n++
+		if worst < 0 || avgs[i] > avgs[worst] {
+			worst = i
+		}
+	}
+	if n < 2 {
+		return nil
+	}
+	resAvg := (total - float64(avgs[worst])) / float64(n-1)
+	if float64(avgs[worst]) <= threshold*resAvg {
+		return nil
+	}
+	if sess.rank == defaultRoot {
+		sess.strategyLogger(sl[worst].name).Warnf("suspending strategy %s, its chunks take %.3fms (or are predicted to), others %.3fms", sl[worst].name, avgs[worst]*1000, resAvg*1000)
+	}
+	return sess.SuspendStrategy(sl[worst].name)
+}
+
+// reactivateStrategies resumes the suspended strategies of sl whose canary is not much slower than that of the active ones.
+func (sess *Session) reactivateStrategies(sl strategyList) error {
+	var suspended strategyList
+	for _, s := range sl {
+		if s.suspended && !s.broken {
+			suspended = append(suspended, s)
+		}
+	}
+	if len(suspended) == 0 {
+		return nil
+	}
+	x := kb.NewVector(len(suspended)+1, kb.F32)
+	y := kb.NewVector(len(suspended)+1, kb.F32)
+	active := sl.active()
+	for _, s := range active {
+		d, err := sess.canary(s)
+		if err != nil {
+			return err
+		}
+		x.AsF32()[0] += float32(d.Seconds()) / float32(len(active))
+	}
+	for i, s := range suspended {
+		d, err := sess.canary(s)
+		if err != nil {
+			return err
+		}
+		x.AsF32()[i+1] = float32(d.Seconds())
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::canary:result"}
+	if err := sess.runStrategies(w, plan.EvenPartition, active); err != nil {
+		return err
+	}
+	ref := float64(y.AsF32()[0])
+	for i, s := range suspended {
+		d := float64(y.AsF32()[i+1])
+		if d > config.ReactivationThreshold*ref {
+			continue
+		}
+		if sess.rank == defaultRoot {
+			sess.strategyLogger(s.name).Infof("reactivating strategy %s, its canary took %.3fms, active strategies %.3fms", s.name, d*1000, ref*1000)
+		}
+		if err := sess.ResumeStrategy(s.name); err != nil {
+			return err
+		}
+		sess.stats.invalidate(s.name) // drop the durations measured under interference
+	}
+	return nil
+}
+
+// canary returns the mean duration of a small all reduce on s.
+func (sess *Session) canary(s strategy) (time.Duration, error) {
+	count := canarySize / kb.F32.Size()
+	w := kb.Workspace{
+		SendBuf: kb.NewVector(count, kb.F32),
+		RecvBuf: kb.NewVector(count, kb.F32),
+		OP:      kb.SUM,
+		Name:    "kungfu::canary:" + s.name,
+	}
+	t0 := time.Now()
+	for i := 0; i < canaryRounds; i++ {
+		if err := sess.runGraphs(w, s.graphs()...); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(t0) / canaryRounds, nil
+}
@@ -0,0 +1,58 @@
+package session
+
+import (
+	"strconv"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+// A GraphLink is an edge of a graph of an active global strategy, between the ranks of peers.
+type GraphLink struct {
+	Source   int    `json:"source"`
+	Target   int    `json:"target"`
+	Strategy string `json:"strategy"`
+	Kind     string `json:"kind"` // reduce, bcast, or stage:<i> of staged strategies
+}
+
+// GraphLinks returns the edges of the graphs of the active global strategies.
+func (sess *Session) GraphLinks() []GraphLink {
+	var links []GraphLink
+	add := func(s strategy, kind string, g *graph.Graph) {
+		for i, n := range g.Nodes {
+			for _, j := range n.Nexts {
+				if i != j {
+					links = append(links, GraphLink{Source: i, Target: j, Strategy: s.name, Kind: kind})
+				}
+			}
+		}
+	}
+	for _, s := range sess.swap.latest().active() {
+		if len(s.stages) > 0 {
+			for i, g := range s.stages {
+				add(s, "stage:"+strconv.Itoa(i), g)
+			}
+			continue
+		}
+		add(s, "reduce", s.reduceGraph)
+		add(s, "bcast", s.bcastGraph)
+	}
+	return links
+}
+
+// AllGatherSentBytes gathers the bytes sent by each peer to each other peer at all peers, as a matrix of the ranks of
+// senders and receivers, so that any peer taking over as the coordinator has them. It must be called by all peers.
+func (sess *Session) AllGatherSentBytes() ([]int64, error) {
+	np := len(sess.peers)
+	x := kb.NewVector(np, kb.I64)
+	for i, p := range sess.peers {
+		if i != sess.rank {
+			x.AsI64()[i] = sess.client.SentBytesTo(p)
+		}
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: kb.NewVector(np*np, kb.I64), Name: "kungfu::sent-bytes"}
+	if err := sess.runAllGather(w); err != nil {
+		return nil, err
+	}
+	return w.RecvBuf.AsI64(), nil
+}
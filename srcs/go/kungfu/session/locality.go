@@ -0,0 +1,53 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const maxLocalityLen = 256
+
+// SetTopology exchanges the locality of each peer, and switches the global strategy to topology-aware graphs
+// if peers of a host are bound to different sockets or peers are spread over more than one switch.
+func (sess *Session) SetTopology(l plan.Locality) error {
+	bs, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if len(bs) > maxLocalityLen {
+		return fmt.Errorf("locality is longer than %d bytes", maxLocalityLen)
+	}
+	k := len(sess.peers)
+	x := kb.NewVector(maxLocalityLen, kb.U8)
+	copy(x.Data, bs)
+	y := kb.NewVector(maxLocalityLen*k, kb.U8)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: "kungfu::topology"}
+	if err := sess.AllGather(w); err != nil {
+		return err
+	}
+	topo := make(plan.Topology, k)
+	for i := range topo {
+		bs := bytes.TrimRight(y.Data[i*maxLocalityLen:(i+1)*maxLocalityLen], "\x00")
+		if err := json.Unmarshal(bs, &topo[i]); err != nil {
+			return err
+		}
+	}
+	var multiSocket bool
+	for _, n := range topo.Sockets(sess.peers) {
+		multiSocket = multiSocket || n > 1
+	}
+	switches := len(topo.Switches())
+	if !multiSocket && switches <= 1 {
+		return nil
+	}
+	sess.logger.Debugf("peers are spread over sockets and %d switches, using topology aware strategy", switches)
+	var sl strategyList
+	for _, p := range plan.GenTopologyAwareStrategies(sess.peers, topo) {
+		sl = append(sl, strategy{reduceGraph: p.Reduce, bcastGraph: p.Bcast})
+	}
+	return sess.SetGlobalStrategy(named("TOPOLOGY_AWARE", sl))
+}
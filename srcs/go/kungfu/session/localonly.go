@@ -0,0 +1,218 @@
+package session
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// localOnly is the local-only mode of a session, which it enters with config.EnableLocalFallback if all remote peers
+// become unreachable, e.g. in a network outage, so that each peer continues training on its own.
+// Collectives return the local buffer unchanged in this mode, and set the Degraded flag of their Workspace.
+//
+// When the network returns, the peers rejoin: each peer asks rank 0 to rejoin once it can reach it,
+// and rank 0 releases all peers once all of them have asked. The released peers agree on the step to leave the mode
+// at their next call of Rejoined, and their models must be synchronized again, as they have diverged.
+// The messages of collectives after a rejoin are named by the number of rejoins, so that the messages of
+// the collectives abandoned at the outage are never taken as theirs.
+type localOnly struct {
+	sync.Mutex
+	on          bool
+	since       time.Time
+	collectives int  // returned the local buffer since the outage
+	released    bool // by rank 0, for the peers to leave the mode
+	rejoins     int32
+}
+
+// LocalOnly returns true if the session is in local-only mode, in which collectives return the local buffer unchanged.
+func (sess *Session) LocalOnly() bool {
+	sess.localOnly.Lock()
+	defer sess.localOnly.Unlock()
+	return sess.localOnly.on
+}
+
+// LocalOnlyCollectives returns the collectives that returned the local buffer since the session entered local-only mode.
+func (sess *Session) LocalOnlyCollectives() int {
+	sess.localOnly.Lock()
+	defer sess.localOnly.Unlock()
+	return sess.localOnly.collectives
+}
+
+// Rejoined returns true if the session has left local-only mode, after all peers have reached each other again.
+// The caller must then synchronize the model, e.g. by broadcasting it from rank 0, as peers trained on their own.
+// It must be called at step boundaries in local-only mode. Once released, a peer waits in its next call for all peers
+// to agree on the latest of their steps, to which the session advances, so that all peers leave the mode at the same
+// step. If they don't agree within config.ResilienceTimeout, they stay in the mode and agree again at the next call.
+func (sess *Session) Rejoined() bool {
+	l := &sess.localOnly
+	l.Lock()
+	released := l.on && l.released
+	l.Unlock()
+	if !released {
+		return false
+	}
+	step, err := sess.agreeRejoinStep()
+	if err != nil {
+		sess.logger.Warnf("staying in local-only mode, the peers didn't agree on the step to rejoin: %v", err)
+		return false
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.on, l.released = false, false
+	atomic.AddInt32(&l.rejoins, 1)
+	sess.AdvanceStepTo(step)
+	sess.logger.Warnf("rejoined the other %d peers at step %d after %s in local-only mode, %d collectives returned the local buffer",
+		len(sess.peers)-1, step, time.Since(l.since), l.collectives)
+	return true
+}
+
+// agreeRejoinStep returns the latest step of all peers, which are released from local-only mode.
+func (sess *Session) agreeRejoinStep() (int64, error) {
+	x := kb.NewVector(1, kb.I64)
+	y := kb.NewVector(1, kb.I64)
+	x.AsI64()[0] = sess.Step()
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::rejoin:step"}
+	if err := sess.runAttempt(w, "", sess.nextGlobalStrategies(), config.ResilienceTimeout); err != nil {
+		return 0, err
+	}
+	return y.AsI64()[0], nil
+}
+
+// rejoinPrefix prefixes the names of collective messages after rejoins.
+func (sess *Session) rejoinPrefix() string {
+	if n := atomic.LoadInt32(&sess.localOnly.rejoins); n > 0 {
+		return "rejoin:" + strconv.Itoa(int(n)) + "/"
+	}
+	return ""
+}
+
+// orLocal prepares f on w as prepareAllReduce, and returns the function running it unless the session is in local-only mode.
+// With config.EnableLocalFallback, f writes to a copy of w.RecvBuf, as it may never finish. If it fails or doesn't finish
+// within config.ResilienceTimeout and no remote peer answers pings, the session enters local-only mode.
+func (sess *Session) orLocal(w kb.Workspace, prepare func(kb.Workspace) func() error) func() error {
+	return sess.orLocalWith(w, prepare, kb.Workspace.Forward)
+}
+
+// orLocalWith is orLocal of a collective whose local buffer is returned by forward in local-only mode,
+// e.g. into the block of this peer of an AllGather. w.RecvBuf may be nil, e.g. of the peers other than the root of Gather.
+func (sess *Session) orLocalWith(w kb.Workspace, prepare func(kb.Workspace) func() error, forward func(kb.Workspace)) func() error {
+	if !config.EnableLocalFallback || len(sess.peers) == 1 {
+		return prepare(w)
+	}
+	if sess.forwardLocal(w, forward) {
+		return func() error { return nil }
+	}
+	attempt := w
+	if w.RecvBuf != nil {
+		attempt.RecvBuf = kb.NewVector(w.RecvBuf.Count, w.RecvBuf.Type)
+	}
+	run := prepare(attempt)
+	return func() error {
+		err := withTimeout(run, config.ResilienceTimeout)
+		if err == nil {
+			if w.RecvBuf != nil {
+				w.RecvBuf.CopyFrom(attempt.RecvBuf)
+			}
+			return nil
+		}
+		for rank, up := range sess.pingAll(config.PartitionTimeout) {
+			if up && rank != sess.rank {
+				return err
+			}
+		}
+		sess.enterLocalOnly(err)
+		sess.forwardLocal(w, forward)
+		return nil
+	}
+}
+
+// forwardLocal returns the local buffer of w by forward, and returns true if the session is in local-only mode.
+func (sess *Session) forwardLocal(w kb.Workspace, forward func(kb.Workspace)) bool {
+	sess.localOnly.Lock()
+	defer sess.localOnly.Unlock()
+	if !sess.localOnly.on {
+		return false
+	}
+	sess.localOnly.collectives++
+	if !w.IsEmpty() {
+		forward(w)
+	}
+	if w.Degraded != nil {
+		*w.Degraded = true
+	}
+	return true
+}
+
+func (sess *Session) enterLocalOnly(err error) {
+	l := &sess.localOnly
+	l.Lock()
+	defer l.Unlock()
+	if l.on {
+		return
+	}
+	l.on, l.since, l.collectives = true, time.Now(), 0
+	sess.logger.Warnf("all %d remote peers are unreachable: %v, continuing in local-only mode", len(sess.peers)-1, err)
+	name := "kungfu::rejoin:" + strconv.Itoa(int(atomic.LoadInt32(&l.rejoins)))
+	if sess.rank == defaultRoot {
+		go sess.releaseRejoin(name)
+	} else {
+		go sess.askRejoin(name)
+	}
+}
+
+// askRejoin asks rank 0 to rejoin once it answers pings, and waits for the release.
+func (sess *Session) askRejoin(name string) {
+	root := sess.peers[defaultRoot]
+	for {
+		if _, err := sess.client.Ping(root); err == nil {
+			if err := sess.send(root.WithName(sess.tagged(name+":ask")), nil, connection.NoFlag); err == nil {
+				break
+			}
+		}
+		time.Sleep(config.PartitionTimeout)
+	}
+	if _, err := sess.collectiveHandler.Recv(root.WithName(sess.tagged(name + ":release"))); err != nil {
+		sess.logger.Errorf("rejoin failed: %v", err)
+		return
+	}
+	sess.localOnly.release()
+}
+
+// releaseRejoin waits until all peers have asked to rejoin, and releases them.
+func (sess *Session) releaseRejoin(name string) {
+	var wg sync.WaitGroup
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		wg.Add(1)
+		go func(peer plan.PeerID) {
+			defer wg.Done()
+			if _, err := sess.collectiveHandler.Recv(peer.WithName(sess.tagged(name + ":ask"))); err != nil {
+				sess.logger.Errorf("rejoin of %s failed: %v", peer, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		for sess.send(peer.WithName(sess.tagged(name+":release")), nil, connection.NoFlag) != nil {
+			time.Sleep(config.PartitionTimeout)
+		}
+	}
+	sess.localOnly.release()
+}
+
+func (l *localOnly) release() {
+	l.Lock()
+	defer l.Unlock()
+	l.released = true
+}
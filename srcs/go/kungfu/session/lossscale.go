@@ -0,0 +1,28 @@
+package session
+
+import (
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// LossScaleConsensus keeps dynamic loss scaling of mixed-precision training consistent across peers in a single small AllReduce.
+// Each peer passes its local overflow flag and the next loss scale it proposes,
+// it returns whether any peer detected an overflow, and the minimum of the proposed loss scales.
+func (sess *Session) LossScaleConsensus(overflow bool, scale float32, name string) (bool, float32, error) {
+	x := kb.NewVector(2, kb.F32)
+	y := kb.NewVector(2, kb.F32)
+	x.AsF32()[0] = boolToF32(overflow)
+	x.AsF32()[1] = -scale // max(-s) = -min(s)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: name}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return false, 0, err
+	}
+	return y.AsF32()[0] > 0, -y.AsF32()[1], nil
+}
+
+func boolToF32(v bool) float32 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// CollectiveCount is the number of collectives of a kind run by a peer, e.g. all_reduce, and the bytes of their send buffers.
+type CollectiveCount struct {
+	Kind  string `json:"kind"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+type collectiveCounters struct {
+	sync.Mutex
+	counts map[string]*CollectiveCount
+}
+
+func (c *collectiveCounters) add(kind string, bytes int) {
+	c.Lock()
+	defer c.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]*CollectiveCount)
+	}
+	cc, ok := c.counts[kind]
+	if !ok {
+		cc = &CollectiveCount{Kind: kind}
+		c.counts[kind] = cc
+	}
+	cc.Count++
+	cc.Bytes += int64(bytes)
+}
+
+func (c *collectiveCounters) get() []CollectiveCount {
+	c.Lock()
+	defer c.Unlock()
+	var ccs []CollectiveCount
+	for _, cc := range c.counts {
+		ccs = append(ccs, *cc)
+	}
+	sort.Slice(ccs, func(i, j int) bool { return ccs[i].Kind < ccs[j].Kind })
+	return ccs
+}
+
+// CollectiveCounts returns the collectives of each kind run by the peer in this session.
+func (sess *Session) CollectiveCounts() []CollectiveCount {
+	return sess.collectives.get()
+}
+
+// PeerLiveness is whether a peer communicates with this peer.
+type PeerLiveness struct {
+	Rank int         `json:"rank"`
+	ID   plan.PeerID `json:"id"`
+	Up   bool        `json:"up"` // no link between the peers failed since it last succeeded, or is open
+}
+
+// PeerLiveness returns the liveness of the other peers, as observed by the link breakers of this peer.
+func (sess *Session) PeerLiveness() []PeerLiveness {
+	b := sess.breakers
+	b.Lock()
+	defer b.Unlock()
+	var ls []PeerLiveness
+	for rank, id := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		up := true
+		for _, l := range []linkKey{{sess.rank, rank}, {rank, sess.rank}} {
+			if _, open := b.open[l]; open || b.failures[l] > 0 {
+				up = false
+			}
+		}
+		ls = append(ls, PeerLiveness{Rank: rank, ID: id, Up: up})
+	}
+	return ls
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -28,6 +29,22 @@ func (sess *Session) GetPeerLatencies() []time.Duration {
 	return results
 }
 
+// ProbeLink measures the link from peer a to peer b (by rank) with size bytes, while the job is running.
+// It can be called by any peer at any time, and only involves a and b.
+func (sess *Session) ProbeLink(a, b, size int) (*client.LinkStats, error) {
+	n := len(sess.peers)
+	if a < 0 || a >= n || b < 0 || b >= n || a == b {
+		return nil, fmt.Errorf("invalid link %d -> %d of %d peers", a, b, n)
+	}
+	if size < 0 || size > client.MaxProbeSize {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	if a == sess.rank {
+		return sess.client.Probe(sess.peers[b], size)
+	}
+	return sess.client.ProbeFrom(sess.peers[a], sess.peers[b], size)
+}
+
 func getLatency(self, peer plan.PeerID) time.Duration {
 	client := client.New(self, config.UseUnixSock)
 	d, err := client.Ping(peer)
@@ -0,0 +1,67 @@
+package session
+
+import (
+	"errors"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// NoiseScale is an estimation of the gradient noise scale B = tr(Σ) / |G|^2,
+// following "An Empirical Model of Large-Batch Training" (McCandlish et al. 2018).
+// Both terms are noisy, callers usually smooth them over steps before taking the ratio.
+type NoiseScale struct {
+	GradSqr float64 // unbiased estimation of |G|^2
+	Trace   float64 // unbiased estimation of tr(Σ)
+}
+
+// Simple returns the simple noise scale tr(Σ) / |G|^2.
+func (ns NoiseScale) Simple() float64 {
+	return ns.Trace / ns.GradSqr
+}
+
+var (
+	errNoiseScaleDtype  = errors.New("gradient noise scale requires f32 gradients")
+	errNoiseScaleSingle = errors.New("gradient noise scale requires at least 2 peers")
+)
+
+// GradientNoiseScale averages the local gradients w.SendBuf into w.RecvBuf, like AllReduce,
+// and estimates the gradient noise scale in the same pass, by appending the local squared norm to the gradients.
+// batchSize is the local batch size of each peer.
+func (sess *Session) GradientNoiseScale(w kb.Workspace, batchSize int) (*NoiseScale, error) {
+	if w.SendBuf.Type != kb.F32 || w.RecvBuf.Type != kb.F32 {
+		return nil, errNoiseScaleDtype
+	}
+	k := len(sess.peers)
+	if k < 2 {
+		return nil, errNoiseScaleSingle
+	}
+	n := w.SendBuf.Count
+	x := kb.NewVector(n+1, kb.F32)
+	y := kb.NewVector(n+1, kb.F32)
+	g := x.AsF32()
+	copy(g, w.SendBuf.AsF32())
+	var localSqr float64
+	for _, v := range g[:n] {
+		localSqr += float64(v) * float64(v)
+	}
+	g[n] = float32(localSqr)
+	fused := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: w.Name}
+	if err := sess.runStrategies(fused, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return nil, err
+	}
+	sum := y.AsF32()
+	avg := w.RecvBuf.AsF32()
+	var bigSqr float64
+	for i := 0; i < n; i++ {
+		avg[i] = sum[i] / float32(k)
+		bigSqr += float64(avg[i]) * float64(avg[i])
+	}
+	smallSqr := float64(sum[n]) / float64(k)
+	bSmall := float64(batchSize)
+	bBig := float64(batchSize * k)
+	return &NoiseScale{
+		GradSqr: (bBig*bigSqr - bSmall*smallSqr) / (bBig - bSmall),
+		Trace:   (smallSqr - bigSqr) / (1/bSmall - 1/bBig),
+	}, nil
+}
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"errors"
+	"math"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+var errGlobalNormDtype = errors.New("global norm requires f32 tensors")
+
+// GlobalNorm returns sqrt of the sum of squared norms of all tensors of all peers.
+// Squared norms are computed locally per tensor, so that clipping any number of tensors costs a single small AllReduce.
+func (sess *Session) GlobalNorm(tensors []*kb.Vector, name string) (float32, error) {
+	m := len(tensors)
+	x := kb.NewVector(m, kb.F32)
+	y := kb.NewVector(m, kb.F32)
+	sqrs := x.AsF32()
+	for i, t := range tensors {
+		if t.Type != kb.F32 {
+			return 0, errGlobalNormDtype
+		}
+		var s float64
+		for _, v := range t.AsF32() {
+			s += float64(v) * float64(v)
+		}
+		sqrs[i] = float32(s)
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: name}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, s := range y.AsF32() {
+		total += float64(s)
+	}
+	return float32(math.Sqrt(total)), nil
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+var (
+	errOPMismatch = failure.New(failure.ProtocolMismatch, "peers registered different ops")
+	errOPType     = errors.New("op doesn't apply to dtype")
+)
+
+// opScope is the peers of the collectives that an op is checked among.
+type opScope int
+
+const (
+	globalOPs opScope = iota // all peers
+	localOPs                 // the peers of each host
+	crossOPs                 // the local roots of the hosts
+)
+
+type opKey struct {
+	op    kb.OP
+	scope opScope
+}
+
+// opCheck is the check of a custom op, done once its consensus finishes.
+type opCheck struct {
+	done chan struct{}
+	err  error
+}
+
+// checkedOPs holds the checks of the custom ops used in the session.
+type checkedOPs struct {
+	sync.Mutex
+	checks map[opKey]*opCheck
+}
+
+// checkOP checks that w.OP applies to the dtype of w, and that the peers of the collective registered the same name as
+// w.OP, if it's a custom op, when they first use it in scope. It must be called by the peers of the collective in the order
+// of collectives. The collectives of an op being checked wait for the check, but not those of other ops.
+func (sess *Session) checkOP(w kb.Workspace, scope opScope) error {
+	if err := checkOPType(w.OP, w.RecvBuf.Type); err != nil {
+		return err
+	}
+	op := w.OP
+	if !op.IsCustom() {
+		return nil
+	}
+	if sess.LocalOnly() {
+		return nil // checked once the peers rejoin, as the result is not kept
+	}
+	key := opKey{op, scope}
+	sess.checkedOPs.Lock()
+	if sess.checkedOPs.checks == nil {
+		sess.checkedOPs.checks = make(map[opKey]*opCheck)
+	}
+	c, ok := sess.checkedOPs.checks[key]
+	if !ok {
+		c = &opCheck{done: make(chan struct{})}
+		sess.checkedOPs.checks[key] = c
+	}
+	sess.checkedOPs.Unlock()
+	if ok {
+		<-c.done
+		return c.err
+	}
+	defer close(c.done)
+	strategies := func() strategyList {
+		switch scope {
+		case localOPs:
+			return sess.localStrategies
+		case crossOPs:
+			return sess.crossStrategies
+		}
+		return sess.nextGlobalStrategies()
+	}
+	name := op.String()
+	ok, err := sess.bytesConsensus([]byte(name), "kungfu::op:"+strconv.Itoa(int(op)), func(w kb.Workspace) error {
+		return sess.runStrategies(w, plan.EvenPartition, strategies())
+	})
+	if err == nil && !ok {
+		err = fmt.Errorf("%w than %s as %d", errOPMismatch, name, int(op))
+	}
+	c.err = err
+	return err
+}
+
+// checkOPType returns an error if op doesn't apply to dtype, rather than failing in the kernels.
+func checkOPType(op kb.OP, dtype kb.DataType) error {
+	switch op {
+	case kb.BAND, kb.BOR, kb.BXOR:
+		switch dtype {
+		case kb.F16, kb.F32, kb.F64, kb.BF16:
+			return fmt.Errorf("%w: %s of %s", errOPType, op, dtype)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+func Test_checkOPType(t *testing.T) {
+	for _, op := range []kb.OP{kb.BAND, kb.BOR, kb.BXOR} {
+		for _, dtype := range []kb.DataType{kb.F16, kb.F32, kb.F64, kb.BF16} {
+			if err := checkOPType(op, dtype); !errors.Is(err, errOPType) {
+				t.Errorf("%s of %s: %v", op, dtype, err)
+			}
+		}
+		if err := checkOPType(op, kb.I32); err != nil {
+			t.Errorf("%s of %s: %v", op, kb.I32, err)
+		}
+	}
+	if err := checkOPType(kb.LAND, kb.F32); err != nil {
+		t.Errorf("%s of %s: %v", kb.LAND, kb.F32, err)
+	}
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"errors"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// segments splits w into segments of about config.SegmentSize bytes, element-wise operations only.
+func segments(w kb.Workspace) []kb.Workspace {
+	if config.SegmentSize <= 0 || !w.OP.ElementWise() {
+		return []kb.Workspace{w}
+	}
+	k := ceilDiv(len(w.RecvBuf.Data), config.SegmentSize)
+	if k > w.RecvBuf.Count {
+		k = w.RecvBuf.Count
+	}
+	if k <= 1 {
+		return []kb.Workspace{w}
+	}
+	return w.Split(plan.EvenPartition, k)
+}
+
+var errAbandoned = errors.New("abandoned")
+
+// cancellation cancels the pending receives of a pipeline on its first error.
+type cancellation struct {
+	once sync.Once
+	done chan struct{}
+	err  error // the first error, which caused the cancellation
+}
+
+// newCancellation returns a cancellation, which is also cancelled once parent is closed, if it's not nil.
+func newCancellation(parent <-chan struct{}) *cancellation {
+	c := &cancellation{done: make(chan struct{})}
+	if parent != nil {
+		go func() {
+			select {
+			case <-parent:
+				c.cancel(errAbandoned)
+			case <-c.done:
+			}
+		}()
+	}
+	return c
+}
+
+// cancel closes Done on the first error, it's called by the pipeline and by the receives failing to receive
+// from a peer, so that the receives of the same segment from the other peers are cancelled too.
+func (c *cancellation) cancel(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// Done returns the channel that is closed once the receives are cancelled.
+func (c *cancellation) Done() <-chan struct{} {
+	return c.done
+}
+
+// pipeline runs recv(i) then send(i) for i in [0, n). The receives of all segments are posted at once, so that
+// segment i is sent while the next ones are received, and a sender never waits on the receiver to send other segments.
+// On the first error, the pending receives are cancelled by the cancellation passed to recv, and pipeline returns
+// the error once all of them have returned, so that the buffers of the segments are not written after it returns.
+// The receives are also cancelled once abandon is closed, if it's not nil.
+func pipeline(n int, abandon <-chan struct{}, recv func(i int, c *cancellation) error, send func(i int) error) error {
+	c := newCancellation(abandon)
+	defer c.cancel(nil) // ends the watch of abandon
+	if n == 1 {
+		if err := recv(0, c); err != nil {
+			return err
+		}
+		return send(0)
+	}
+	recvs := make([]chan error, n)
+	var wg sync.WaitGroup
+	for i := range recvs {
+		recvs[i] = make(chan error, 1)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := recv(i, c)
+			if err != nil {
+				c.cancel(err)
+			}
+			recvs[i] <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		err := <-recvs[i]
+		if err == nil {
+			err = send(i)
+		}
+		if err != nil {
+			c.cancel(err)
+			wg.Wait()
+			return c.err // rather than the error of a cancelled receive
+		}
+	}
+	return nil
+}
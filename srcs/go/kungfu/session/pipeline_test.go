@@ -0,0 +1,112 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+)
+
+func Test_segments(t *testing.T) {
+	defer func(n int) { config.SegmentSize = n }(config.SegmentSize)
+	w := kb.Workspace{SendBuf: kb.NewVector(1000, kb.F32), RecvBuf: kb.NewVector(1000, kb.F32), OP: kb.SUM, Name: "x"}
+	for _, tt := range []struct {
+		size int
+		op   kb.OP
+		want int
+	}{
+		{0, kb.SUM, 1},
+		{4000, kb.SUM, 1},
+		{1000, kb.SUM, 4},
+		{1, kb.SUM, 1000},
+		{1000, kb.ADASUM, 1},
+	} {
+		config.SegmentSize = tt.size
+		w.OP = tt.op
+		segs := segments(w)
+		if len(segs) != tt.want {
+			t.Errorf("segments(%d bytes, %d, %v) = %d, want %d", len(w.RecvBuf.Data), tt.size, tt.op, len(segs), tt.want)
+		}
+		var n int
+		for _, s := range segs {
+			n += s.RecvBuf.Count
+		}
+		if n != w.RecvBuf.Count {
+			t.Errorf("segments cover %d elements, want %d", n, w.RecvBuf.Count)
+		}
+	}
+}
+
+func Test_pipeline(t *testing.T) {
+	const n = 8
+	// the receive of the last segment completes first, segments must still be sent in order
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	recv := func(i int, c *cancellation) error {
+		if i+1 < n {
+			<-done[i+1]
+		}
+		close(done[i])
+		return nil
+	}
+	var lock sync.Mutex
+	var sent []int
+	send := func(i int) error {
+		lock.Lock()
+		defer lock.Unlock()
+		sent = append(sent, i)
+		return nil
+	}
+	if err := pipeline(n, nil, recv, send); err != nil {
+		t.Fatal(err)
+	}
+	for i, j := range sent {
+		if i != j {
+			t.Fatalf("sent %v, want in order", sent)
+		}
+	}
+	if len(sent) != n {
+		t.Fatalf("sent %d segments, want %d", len(sent), n)
+	}
+}
+
+func Test_pipelineCancel(t *testing.T) {
+	const n = 4
+	failed := errors.New("failed")
+	var pending int32 = n - 1
+	recv := func(i int, c *cancellation) error {
+		if i == 1 {
+			return failed
+		}
+		<-c.Done() // blocks until cancelled, as a receive from a failed peer
+		atomic.AddInt32(&pending, -1)
+		return errors.New("cancelled")
+	}
+	send := func(i int) error { return nil }
+	if err := pipeline(n, nil, recv, send); err != failed {
+		t.Errorf("pipeline failed with %v, want %v", err, failed)
+	}
+	if p := atomic.LoadInt32(&pending); p != 0 {
+		t.Errorf("pipeline returned with %d pending receives", p)
+	}
+	failedSend := func(i int) error { return failed }
+	pending = n
+	recv = func(i int, c *cancellation) error {
+		if i > 0 {
+			<-c.Done()
+		}
+		atomic.AddInt32(&pending, -1)
+		return nil
+	}
+	if err := pipeline(n, nil, recv, failedSend); err != failed {
+		t.Errorf("pipeline failed with %v, want %v", err, failed)
+	}
+	if p := atomic.LoadInt32(&pending); p != 0 {
+		t.Errorf("pipeline returned with %d pending receives after a failed send", p)
+	}
+}
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"reflect"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// maxCachedPlans bounds the plans of collectives of distinct names, the cache is cleared when it's full.
+const maxCachedPlans = 4096
+
+// A collectivePlan is the chunking of a collective and the strategy of each chunk.
+type collectivePlan struct {
+	intervals []plan.Interval
+	names     []string
+	choices   []int // index of the strategy of each chunk
+}
+
+// planKey identifies the collectives of the same plan.
+type planKey struct {
+	name       string
+	count      int
+	dtype      kb.DataType
+	op         kb.OP
+	partition  uintptr
+	chunkSize  int
+	hash       string
+	weights    int64 // the step from which the weights of WeightedHash are used
+	strategies int
+}
+
+// planCache keeps the plans of collectives, so that tensors reduced every step with the same name and size
+// skip chunking and hashing after the first step.
+type planCache struct {
+	sync.Mutex
+	plans map[planKey]*collectivePlan
+}
+
+func newPlanCache() *planCache {
+	return &planCache{plans: make(map[planKey]*collectivePlan)}
+}
+
+// get returns the plan of w over n strategies in chunks of chunkSize bytes, it makes the plan if it's not cached.
+// It requires that strategyHash depends only on the index, name and size of chunks, and the weights of WeightedHash.
+func (c *planCache) get(w kb.Workspace, p kb.PartitionFunc, n int, strategyHash StrategyHash, chunkSize int) *collectivePlan {
+	var weights int64
+	if wc, ok := strategyHash.(*weightedChoice); ok {
+		weights = wc.from
+	}
+	key := planKey{
+		name:       w.Name,
+		count:      w.RecvBuf.Count,
+		dtype:      w.RecvBuf.Type,
+		op:         w.OP,
+		partition:  reflect.ValueOf(p).Pointer(),
+		chunkSize:  chunkSize,
+		hash:       strategyHash.Name(),
+		weights:    weights,
+		strategies: n,
+	}
+	c.Lock()
+	cp, ok := c.plans[key]
+	c.Unlock()
+	if ok {
+		return cp
+	}
+	cp = makePlan(w, p, n, strategyHash, chunkSize)
+	c.Lock()
+	defer c.Unlock()
+	if len(c.plans) >= maxCachedPlans {
+		c.plans = make(map[planKey]*collectivePlan)
+	}
+	c.plans[key] = cp
+	return cp
+}
+
+func makePlan(w kb.Workspace, p kb.PartitionFunc, n int, strategyHash StrategyHash, chunkSize int) *collectivePlan {
+	k := chunkCount(w, chunkSize)
+	cp := &collectivePlan{intervals: p(plan.Interval{Begin: 0, End: w.SendBuf.Count}, k)}
+	for i, w := range w.Split(p, k) {
+		cp.names = append(cp.names, w.Name)
+		if c, ok := strategyHash.(strategyChooser); ok {
+			cp.choices = append(cp.choices, c.choose(i, w, n))
+		} else {
+			cp.choices = append(cp.choices, int(strategyHash.Hash(i, w)%uint64(n)))
+		}
+	}
+	return cp
+}
+
+// clear drops all plans, e.g. after the weights of strategies changed.
+func (c *planCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.plans = make(map[planKey]*collectivePlan)
+}
+
+// split splits w into chunks as Workspace.Split does.
+func (cp *collectivePlan) split(w kb.Workspace) []kb.Workspace {
+	ws := make([]kb.Workspace, len(cp.intervals))
+	for i, r := range cp.intervals {
+		ws[i] = kb.Workspace{
+			SendBuf: w.SendBuf.Slice(r.Begin, r.End),
+			RecvBuf: w.RecvBuf.Slice(r.Begin, r.End),
+			OP:      w.OP,
+			Name:    cp.names[i],
+
+			Compensated: w.Compensated,
+		}
+	}
+	return ws
+}
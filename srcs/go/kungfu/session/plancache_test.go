@@ -0,0 +1,41 @@
+package session
+
+import (
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_planCache(t *testing.T) {
+	h, err := lookupStrategyHash(NameHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newPlanCache()
+	x := kb.NewVector(1000, kb.F32)
+	w := kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "grad"}
+	cp := c.get(w, plan.EvenPartition, 3, h, 1024)
+	if c.get(w, plan.EvenPartition, 3, h, 1024) != cp {
+		t.Errorf("plan is not cached")
+	}
+	if c.get(w, plan.EvenPartition, 2, h, 1024) == cp {
+		t.Errorf("plan should depend on the number of strategies")
+	}
+	if c.get(w, plan.EvenPartition, 3, h, 2048) == cp {
+		t.Errorf("plan should depend on the chunk size")
+	}
+	ws := w.Split(plan.EvenPartition, chunkCount(w, 1024))
+	got := cp.split(w)
+	if len(got) != len(ws) || len(got) != 4 {
+		t.Fatalf("%d chunks, want %d", len(got), len(ws))
+	}
+	for i := range ws {
+		if got[i].Name != ws[i].Name || got[i].SendBuf.Count != ws[i].SendBuf.Count || &got[i].RecvBuf.Data[0] != &ws[i].RecvBuf.Data[0] {
+			t.Errorf("chunk %d differs from Workspace.Split", i)
+		}
+		if want := int(h.Hash(i, ws[i]) % 3); cp.choices[i] != want {
+			t.Errorf("chunk %d on strategy %d, want %d", i, cp.choices[i], want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+// A PostHook processes the result of a collective in w.RecvBuf after the collective finishes on this peer,
+// e.g. unscales, clips or casts gradients.
+type PostHook func(w kb.Workspace) error
+
+// postHooks are the hooks of the collectives of each name.
+type postHooks struct {
+	sync.Mutex
+	hooks map[string][]PostHook
+}
+
+func (p *postHooks) get(name string) []PostHook {
+	p.Lock()
+	defer p.Unlock()
+	return p.hooks[name]
+}
+
+// SetPostHooks sets the hooks run in order after every AllReduce and AllReduceAsync of the name,
+// it removes the hooks of the name if there are none. The hooks run in the goroutine of the collective,
+// e.g. that of AllReduceAsync or of an asynchronous operation of the C API, so that they overlap with
+// other collectives instead of running on the thread of the framework. A collective fails with the error of a hook.
+func (sess *Session) SetPostHooks(name string, hooks ...PostHook) {
+	sess.postHooks.Lock()
+	defer sess.postHooks.Unlock()
+	if len(hooks) == 0 {
+		delete(sess.postHooks.hooks, name)
+		return
+	}
+	if sess.postHooks.hooks == nil {
+		sess.postHooks.hooks = make(map[string][]PostHook)
+	}
+	sess.postHooks.hooks[name] = hooks
+}
+
+// InheritPostHooks copies the post hooks of the previous session of this peer.
+func (sess *Session) InheritPostHooks(old *Session) {
+	old.postHooks.Lock()
+	defer old.postHooks.Unlock()
+	for name, hooks := range old.postHooks.hooks {
+		sess.SetPostHooks(name, hooks...)
+	}
+}
+
+func (sess *Session) postProcess(w kb.Workspace) error {
+	for _, hook := range sess.postHooks.get(w.Name) {
+		if err := hook(w); err != nil {
+			return fmt.Errorf("post hook of %s: %w", w.Name, err)
+		}
+	}
+	return nil
+}
+
+// ScaleHook returns a PostHook multiplying the results by factor, e.g. 1/S to unscale gradients of loss scale S.
+func ScaleHook(factor float64) PostHook {
+	return func(w kb.Workspace) error {
+		return mapFloats(w.RecvBuf, func(x float64) float64 { return x * factor })
+	}
+}
+
+// ClipHook returns a PostHook clipping the results to [lo, hi].
+func ClipHook(lo, hi float64) PostHook {
+	return func(w kb.Workspace) error {
+		return mapFloats(w.RecvBuf, func(x float64) float64 { return math.Max(lo, math.Min(hi, x)) })
+	}
+}
+
+// CastHook returns a PostHook converting the results into y, of the same count and of any floating point type.
+func CastHook(y *kb.Vector) PostHook {
+	return func(w kb.Workspace) error {
+		x := w.RecvBuf
+		if x.Count != y.Count {
+			return fmt.Errorf("can't cast %d elements into %d", x.Count, y.Count)
+		}
+		get, _, err := floatsOf(x)
+		if err != nil {
+			return err
+		}
+		_, set, err := floatsOf(y)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < x.Count; i++ {
+			set(i, get(i))
+		}
+		return nil
+	}
+}
+
+func mapFloats(v *kb.Vector, f func(float64) float64) error {
+	get, set, err := floatsOf(v)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Count; i++ {
+		set(i, f(get(i)))
+	}
+	return nil
+}
+
+// floatsOf returns the accessors of the elements of v, which must be of a floating point type.
+func floatsOf(v *kb.Vector) (func(int) float64, func(int, float64), error) {
+	switch v.Type {
+	case kb.F16:
+		return func(i int) float64 { return float64(halfToFloat32(binary.LittleEndian.Uint16(v.Data[2*i:]))) },
+			func(i int, x float64) { binary.LittleEndian.PutUint16(v.Data[2*i:], float32ToHalf(float32(x))) },
+			nil
+	case kb.F32:
+		xs := v.AsF32()
+		return func(i int) float64 { return float64(xs[i]) }, func(i int, x float64) { xs[i] = float32(x) }, nil
+	case kb.F64:
+		xs := v.AsF64()
+		return func(i int) float64 { return xs[i] }, func(i int, x float64) { xs[i] = x }, nil
+	}
+	return nil, nil, fmt.Errorf("post hooks don't support %s", v.Type)
+}
+
+// ParsePostHooks parses a comma separated list of scale=<factor> and clip=<lo>:<hi>, the hooks of the C API.
+func ParsePostHooks(val string) ([]PostHook, error) {
+	if len(val) == 0 {
+		return nil, nil
+	}
+	var hooks []PostHook
+	for _, spec := range strings.Split(val, ",") {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid post hook: %q", spec)
+		}
+		switch kv[0] {
+		case `scale`:
+			factor, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid factor of post hook: %q", spec)
+			}
+			hooks = append(hooks, ScaleHook(factor))
+		case `clip`:
+			bounds := strings.SplitN(kv[1], ":", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("invalid bounds of post hook: %q", spec)
+			}
+			lo, err1 := strconv.ParseFloat(bounds[0], 64)
+			hi, err2 := strconv.ParseFloat(bounds[1], 64)
+			if err1 != nil || err2 != nil || lo > hi {
+				return nil, fmt.Errorf("invalid bounds of post hook: %q", spec)
+			}
+			hooks = append(hooks, ClipHook(lo, hi))
+		default:
+			return nil, fmt.Errorf("unknown post hook: %q", spec)
+		}
+	}
+	return hooks, nil
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+)
+
+func Test_postHooks(t *testing.T) {
+	hooks, err := ParsePostHooks(`scale=0.5,clip=-1:1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sess Session
+	sess.SetPostHooks("grad", hooks...)
+	x := kb.NewVector(3, kb.F32)
+	copy(x.AsF32(), []float32{-4, 1, 3})
+	w := kb.Workspace{SendBuf: x, RecvBuf: x, OP: kb.SUM, Name: "grad"}
+	if err := sess.postProcess(w); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float32{-1, 0.5, 1} {
+		if got := x.AsF32()[i]; got != want {
+			t.Errorf("x[%d] = %f, want %f", i, got, want)
+		}
+	}
+	y := kb.NewVector(3, kb.F16)
+	sess.SetPostHooks("grad", CastHook(y))
+	if err := sess.postProcess(w); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float32{-1, 0.5, 1} {
+		if got := halfToFloat32(uint16(y.Data[2*i]) | uint16(y.Data[2*i+1])<<8); got != want {
+			t.Errorf("y[%d] = %f, want %f", i, got, want)
+		}
+	}
+	sess.SetPostHooks("grad")
+	if len(sess.postHooks.get("grad")) != 0 {
+		t.Errorf("hooks should be removed")
+	}
+	for _, val := range []string{`scale`, `scale=x`, `clip=1`, `clip=1:-1`, `round=1`} {
+		if _, err := ParsePostHooks(val); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+}
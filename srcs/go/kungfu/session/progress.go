@@ -0,0 +1,66 @@
+package session
+
+import (
+	"sync/atomic"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// progress is the step and epoch of training, which all peers of a session agree on,
+// so that elasticity, checkpointing and logging refer to the same step.
+type progress struct {
+	step  int64
+	epoch int64
+}
+
+// Step returns the current step.
+func (sess *Session) Step() int64 {
+	return atomic.LoadInt64(&sess.progress.step)
+}
+
+// Epoch returns the current epoch.
+func (sess *Session) Epoch() int64 {
+	return atomic.LoadInt64(&sess.progress.epoch)
+}
+
+// AdvanceStep increments the step and returns it. It must be called by all peers once per step, and doesn't communicate.
+func (sess *Session) AdvanceStep() int64 {
+	return atomic.AddInt64(&sess.progress.step, 1)
+}
+
+// AdvanceEpoch increments the epoch and returns it. It must be called by all peers once per epoch, and doesn't communicate.
+func (sess *Session) AdvanceEpoch() int64 {
+	return atomic.AddInt64(&sess.progress.epoch, 1)
+}
+
+// SetProgress sets the step and epoch of this peer, e.g. when it's restored from a checkpoint, or inherited from the previous session.
+func (sess *Session) SetProgress(step, epoch int64) {
+	atomic.StoreInt64(&sess.progress.step, step)
+	atomic.StoreInt64(&sess.progress.epoch, epoch)
+}
+
+// SyncProgress sets the step and epoch of all peers to the maximum of all peers, in a single small AllReduce.
+// It must be called by all peers, it's called after each resize so that new peers catch up with existing ones.
+func (sess *Session) SyncProgress() error {
+	x := kb.NewVector(2, kb.I64)
+	y := kb.NewVector(2, kb.I64)
+	x.AsI64()[0] = sess.Step()
+	x.AsI64()[1] = sess.Epoch()
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::progress"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	sess.SetProgress(y.AsI64()[0], y.AsI64()[1])
+	return nil
+}
+
+// AdvanceStepTo sets the step to step if it's ahead of the current step, e.g. at the boundary of step.
+func (sess *Session) AdvanceStepTo(step int64) {
+	for {
+		cur := sess.Step()
+		if step <= cur || atomic.CompareAndSwapInt64(&sess.progress.step, cur, step) {
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package session
+
+import (
+	"fmt"
+)
+
+// QoS returns the sub-session over all peers of sess, whose collectives are sent within the bandwidth share of a QoS class,
+// e.g. to protect gradients from checkpoint streaming.
+// Collectives of the sub-session don't interfere with collectives of sess. It must be called by all peers.
+func (sess *Session) QoS(class string) (*Session, error) {
+	sess.Lock()
+	defer sess.Unlock()
+	key := "qos:" + class
+	if q, ok := sess.subSessions[key]; ok {
+		return q, nil
+	}
+	q, ok := New(sess.strategyName, sess.self, sess.peers, sess.groups, sess.client, sess.collectiveHandler)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a member of session", sess.self)
+	}
+	q.tag = sess.tag + key + "/"
+	q.qos = class
+	sess.subSessions[key] = q
+	return q, nil
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+// ReduceScatterShard returns the interval of a tensor of count elements that ReduceScatter leaves on this peer.
+// The tensor is partitioned by plan.EvenPartition, the i-th shard belongs to rank i.
+func (sess *Session) ReduceScatterShard(count int) plan.Interval {
+	return plan.EvenPartition(plan.Interval{Begin: 0, End: count}, len(sess.peers))[sess.rank]
+}
+
+// ReduceScatter reduces the SendBuf of all peers, and leaves each peer with its shard of the result in RecvBuf.
+// RecvBuf must have the count of the interval returned by ReduceScatterShard for the count of SendBuf.
+// It sends (n - 1) / n of SendBuf from each peer, rather than twice of it by AllReduce.
+func (sess *Session) ReduceScatter(w kb.Workspace) error {
+	defer sess.track("reduce_scatter", w)()
+	if err := checkHost(w); err != nil {
+		return err
+	}
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return err
+	}
+	return sess.orLocalWith(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runReduceScatter(w) }
+	}, func(w kb.Workspace) {
+		r := sess.ReduceScatterShard(w.SendBuf.Count)
+		w.RecvBuf.CopyFrom(w.SendBuf.Slice(r.Begin, r.End))
+	})()
+}
+
+// runReduceScatter reduces the shards to their owners concurrently, each over a star rooted at the owner,
+// so that each peer sends the shard of each other peer to it directly.
+func (sess *Session) runReduceScatter(w kb.Workspace) error {
+	k := len(sess.peers)
+	shards := plan.EvenPartition(plan.Interval{Begin: 0, End: w.SendBuf.Count}, k)
+	if n := shards[sess.rank].Len(); w.RecvBuf.Count != n {
+		return fmt.Errorf("ReduceScatter: RecvBuf has %d elements, want %d of shard %d", w.RecvBuf.Count, n, sess.rank)
+	}
+	errs := make([]error, k)
+	var wg sync.WaitGroup
+	for owner, r := range shards {
+		sendBuf := w.SendBuf.Slice(r.Begin, r.End)
+		recvBuf := sendBuf // the other peers only send their shard of owner
+		if owner == sess.rank {
+			recvBuf = w.RecvBuf
+		}
+		shard := kb.Workspace{
+			SendBuf: sendBuf,
+			RecvBuf: recvBuf,
+			OP:      w.OP,
+			Name:    fmt.Sprintf("%s:shard:%d", w.Name, owner),
+
+			Compensated: w.Compensated,
+		}
+		reduceGraph := plan.GenDefaultReduceGraph(plan.GenStarBcastGraph(k, owner))
+		wg.Add(1)
+		go func(owner int) {
+			run := func(w kb.Workspace) error { return sess.runGraphs(w, reduceGraph) }
+			if giant, err := runGiant(shard, run); giant {
+				errs[owner] = err
+			} else {
+				errs[owner] = run(shard)
+			}
+			wg.Done()
+		}(owner)
+	}
+	wg.Wait()
+	return utils.MergeErrors(errs, "ReduceScatter")
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+// Names of the built-in registered strategies.
+const (
+	HierarchicalStrategy     = `hierarchical`      // intra-host reduce, inter-host rings rooted at each host, intra-host broadcast
+	HierarchicalTreeStrategy = `hierarchical-tree` // as hierarchical, with a binary tree across hosts
+)
+
+func init() {
+	RegisterStrategy(HierarchicalStrategy, func(peers plan.PeerList) ([]*graph.Pair, error) {
+		return plan.GenTwoLevelGraphPairs(peers, true), nil
+	})
+	RegisterStrategy(HierarchicalTreeStrategy, func(peers plan.PeerList) ([]*graph.Pair, error) {
+		return plan.GenTwoLevelGraphPairs(peers, false), nil
+	})
+}
+
+// A StrategyGenerator generates the graph pairs of the global strategies for a list of peers.
+type StrategyGenerator func(peers plan.PeerList) ([]*graph.Pair, error)
+
+var (
+	generatorsMu sync.Mutex
+	generators   = make(map[string]StrategyGenerator)
+)
+
+// RegisterStrategy makes a strategy generator available by name, it is intended to be called from init functions.
+// The strategy is selected by setting KUNGFU_STRATEGY to its name.
+// It panics if the name is empty, is a built-in strategy, or is already registered.
+func RegisterStrategy(name string, gen StrategyGenerator) {
+	generatorsMu.Lock()
+	defer generatorsMu.Unlock()
+	if len(name) == 0 || gen == nil {
+		panic("RegisterStrategy: empty name or nil generator")
+	}
+	if _, err := kb.ParseStrategy(name); err == nil {
+		panic("RegisterStrategy: " + name + " is a built-in strategy")
+	}
+	if _, dup := generators[name]; dup {
+		panic("RegisterStrategy: " + name + " is registered twice")
+	}
+	generators[name] = gen
+}
+
+// RegisteredStrategies returns the sorted names of registered strategies.
+func RegisteredStrategies() []string {
+	generatorsMu.Lock()
+	defer generatorsMu.Unlock()
+	var names []string
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func genRegisteredStrategyList(name string, peers plan.PeerList) (strategyList, error) {
+	generatorsMu.Lock()
+	gen, ok := generators[name]
+	generatorsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("strategy %q is not registered, registered strategies are %q", name, RegisteredStrategies())
+	}
+	pairs, err := gen(peers)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("strategy %q generated no graph", name)
+	}
+	var sl strategyList
+	for _, p := range pairs {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("strategy %q: %v", name, err)
+		}
+		if n := len(p.Bcast.Nodes); n != len(peers) {
+			return nil, fmt.Errorf("strategy %q: %d nodes for %d peers", name, n, len(peers))
+		}
+		sl = append(sl, strategy{reduceGraph: p.Reduce, bcastGraph: p.Bcast})
+	}
+	return named(name, sl), nil
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+func Test_RegisterStrategy(t *testing.T) {
+	RegisterStrategy("test-rings", func(peers plan.PeerList) ([]*graph.Pair, error) {
+		var ps []*graph.Pair
+		for r := range peers {
+			p, err := graph.NewRing(len(peers)).WithOffset(r).Build()
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, p)
+		}
+		return ps, nil
+	})
+	peers := plan.PeerList{{IPv4: 1, Port: 1}, {IPv4: 1, Port: 2}, {IPv4: 2, Port: 1}, {IPv4: 2, Port: 2}}
+	sl, err := genRegisteredStrategyList("test-rings", peers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sl) != 4 || sl[0].name != "test-rings/0" {
+		t.Errorf("unexpected strategies")
+	}
+	if _, err := genRegisteredStrategyList("unknown", peers); err == nil {
+		t.Errorf("expect error for unknown strategy")
+	}
+}
+
+type testHash struct{}
+
+func (testHash) Name() string { return "test-hash" }
+
+func (testHash) Hash(i int, w kb.Workspace) uint64 { return uint64(len(w.Name)) }
+
+func Test_RegisterStrategyHash(t *testing.T) {
+	RegisterStrategyHash(testHash{})
+	if h, err := lookupStrategyHash("test-hash"); err != nil || h.Name() != "test-hash" {
+		t.Errorf("registered strategy hash not found: %v", err)
+	}
+	if h, err := lookupStrategyHash("size"); err != nil || h.Name() != SizeHash {
+		t.Errorf("built-in strategy hash should be case insensitive: %v", err)
+	}
+	if _, err := lookupStrategyHash("unknown"); err == nil {
+		t.Errorf("expect error for unknown strategy hash")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expect panic for duplicated strategy hash")
+			}
+		}()
+		RegisterStrategyHash(testHash{})
+	}()
+}
+
+func Test_BuiltinStrategyHashes(t *testing.T) {
+	small := kb.Workspace{RecvBuf: kb.NewVector(16, kb.F32), Name: "a"}
+	large := kb.Workspace{RecvBuf: kb.NewVector(1<<20, kb.F32), Name: "a"}
+	for _, tt := range []struct {
+		name string
+		i    int
+		w    kb.Workspace
+		want uint64
+	}{
+		{RoundRobinHash, 3, small, 3},
+		{NameHash, 3, small, 'a' * 'a'},
+		{SizeHash, 0, small, 7},
+		{SizeHash, 0, large, 23},
+	} {
+		h, err := lookupStrategyHash(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := h.Hash(tt.i, tt.w); got != tt.want {
+			t.Errorf("%s.Hash(%d, %d bytes) = %d, want %d", tt.name, tt.i, len(tt.w.RecvBuf.Data), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+// PeerFailedError is returned by AllReduce in resilience mode if peers of the session failed.
+// The all reduce is rerun over the surviving peers, and its result is reduced over them.
+// Later all reduces of the session also run over the surviving peers and return the error,
+// so that the runtime can shrink the cluster without the failed peers instead of crashing all workers.
+type PeerFailedError struct {
+	Ranks []int
+	Peers plan.PeerList
+}
+
+func (e *PeerFailedError) Error() string {
+	return fmt.Sprintf("%d peers failed: ranks %v (%s)", len(e.Ranks), e.Ranks, e.Peers)
+}
+
+func (e *PeerFailedError) FailureKind() failure.Kind {
+	return failure.PeerCrash
+}
+
+var (
+	errResilienceTimeout  = failure.New(failure.Timeout, "resilient all reduce timeout")
+	errFailedPeerMismatch = failure.New(failure.ProtocolMismatch, "surviving peers disagree on the failed peers")
+)
+
+// survivors is the sub-session over the peers that survived the failure of others, with the failed peers.
+type survivors struct {
+	sync.Mutex
+	sess   *Session
+	failed *PeerFailedError
+}
+
+func (s *survivors) get() (*Session, *PeerFailedError) {
+	s.Lock()
+	defer s.Unlock()
+	return s.sess, s.failed
+}
+
+// resilientRounds counts the resilient all reduces of each name, which prefix the names of their attempts, so that
+// the late messages of an abandoned attempt never arrive under the name of a later one. The all reduces of a name
+// are called in the same order by all peers, even if those of different names are concurrent.
+type resilientRounds struct {
+	sync.Mutex
+	rounds map[string]int
+}
+
+func (r *resilientRounds) next(name string) int {
+	r.Lock()
+	defer r.Unlock()
+	if r.rounds == nil {
+		r.rounds = make(map[string]int)
+	}
+	r.rounds[name]++
+	return r.rounds[name]
+}
+
+var errIncompleteElsewhere = failure.New(failure.PeerCrash, "all reduce incomplete on other peers")
+
+// allReduceResilient runs the all reduce of w, and agrees with all peers whether it completed on all of them, which costs
+// a small all reduce. If it didn't, e.g. as it failed or didn't finish within config.ResilienceTimeout on some peers,
+// the peers answering pings agree on the failed peers, and whether it completed on all of them. Unless it did, they
+// rerun it on the strategies of their sub-session, or of the session if no peer failed. All attempts are abandoned
+// after config.ResilienceTimeout, see runAttempt. Every step fails after a timeout rather than blocking, so that a peer
+// completing the all reduce while others don't agree on it fails them, rather than leaving them waiting for it.
+func (sess *Session) allReduceResilient(w kb.Workspace) error {
+	if sub, failed := sess.survivors.get(); sub != nil {
+		if err := sub.runStrategies(w, plan.EvenPartition, sub.nextGlobalStrategies()); err != nil {
+			return err
+		}
+		return failed
+	}
+	prefix := fmt.Sprintf("kungfu::resilient:%d:", sess.resilientRounds.next(w.Name))
+	err := sess.runAttempt(w, prefix, sess.nextGlobalStrategies(), config.ResilienceTimeout)
+	completed, aerr := sess.agreeCompleted(prefix, w.Name, err == nil)
+	if aerr == nil && completed {
+		return nil
+	}
+	cause := err
+	if cause == nil {
+		if cause = aerr; cause == nil {
+			cause = errIncompleteElsewhere
+		}
+	}
+	sub, failed, ferr := sess.excludeFailedPeers()
+	if ferr != nil {
+		return fmt.Errorf("%w, and %w", cause, ferr)
+	}
+	peers := sess
+	if failed != nil {
+		sess.logger.Warnf("%v, rerunning %s without them", failed, w.Name)
+		peers = sub
+	}
+	if completed, aerr = peers.agreeCompleted(prefix+"survivors:", w.Name, err == nil); aerr != nil {
+		return fmt.Errorf("%w, and the surviving peers didn't agree on its completion: %w", cause, aerr)
+	}
+	if !completed {
+		if err := peers.runAttempt(w, prefix+"retry:", peers.nextGlobalStrategies(), config.ResilienceTimeout); err != nil {
+			return fmt.Errorf("%w, and the rerun all reduce failed: %w", cause, err)
+		}
+	}
+	if failed == nil {
+		return nil
+	}
+	return failed
+}
+
+// runAttempt runs the all reduce of w on strategies, as runStrategies, under the names of its chunks prefixed by prefix.
+// It runs on copies of w.SendBuf and w.RecvBuf, and copies the result to w.RecvBuf only if it finishes within timeout.
+// Otherwise the attempt is abandoned: its receives are cancelled, and it never accesses w after it returns.
+func (sess *Session) runAttempt(w kb.Workspace, prefix string, strategies strategyList, timeout time.Duration) error {
+	strategies = strategies.active()
+	input := kb.NewVector(w.SendBuf.Count, w.SendBuf.Type)
+	input.CopyFrom(w.SendBuf)
+	attempt := kb.Workspace{
+		SendBuf: input,
+		RecvBuf: kb.NewVector(w.RecvBuf.Count, w.RecvBuf.Type),
+		OP:      w.OP,
+		Name:    w.Name,
+
+		Compensated: w.Compensated,
+	}
+	n := w.RecvBuf.Count * w.RecvBuf.Type.Size()
+	cp := sess.plans.get(attempt, plan.EvenPartition, len(strategies), sess.getStrategyHash(), sess.chunks.chunkSize(sess.Step(), n))
+	abandon := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		ws := cp.split(attempt)
+		errs := make([]error, len(ws))
+		var wg sync.WaitGroup
+		for i, c := range ws {
+			c.Name = prefix + c.Name
+			wg.Add(1)
+			go func(i int, c kb.Workspace, s strategy) {
+				defer wg.Done()
+				errs[i] = sess.runGraphsWith(c, connection.NoFlag, nil, abandon, s.graphs()...)
+			}(i, c, strategies[cp.choices[i]])
+		}
+		wg.Wait()
+		done <- utils.MergeErrors(errs, "runAttempt")
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		w.RecvBuf.CopyFrom(attempt.RecvBuf)
+		return nil
+	case <-t.C:
+		close(abandon)
+		return errResilienceTimeout
+	}
+}
+
+// agreeCompleted returns whether the attempt of the all reduce of name completed on all peers of sess, given whether it
+// completed on this peer, by an attempt prefixed by prefix. It fails if they don't agree within config.ResilienceTimeout.
+func (sess *Session) agreeCompleted(prefix, name string, completed bool) (bool, error) {
+	x := kb.NewVector(1, kb.I8)
+	y := kb.NewVector(1, kb.I8)
+	x.AsI8()[0] = boolToInt8(completed)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: "kungfu::completed:" + name}
+	if err := sess.runAttempt(w, prefix, sess.nextGlobalStrategies(), config.ResilienceTimeout); err != nil {
+		return false, err
+	}
+	return y.AsI8()[0] > 0, nil
+}
+
+// FailedPeers returns the peers not answering pings, agreed by the surviving peers, and nil if all peers answer.
+// It must be called by all surviving peers.
+func (sess *Session) FailedPeers() (*PeerFailedError, error) {
+	_, failed, err := sess.excludeFailedPeers()
+	return failed, err
+}
+
+// excludeFailedPeers finds the peers not answering pings, and agrees on them with the other surviving peers.
+// It returns nil if all peers answer.
+func (sess *Session) excludeFailedPeers() (*Session, *PeerFailedError, error) {
+	sess.survivors.Lock()
+	defer sess.survivors.Unlock()
+	if sess.survivors.sess != nil { // found by a concurrent all reduce
+		return sess.survivors.sess, sess.survivors.failed, nil
+	}
+	failed := &PeerFailedError{}
+	var up plan.PeerList
+	for rank, ok := range sess.pingAll(config.PartitionTimeout) {
+		if ok {
+			up = append(up, sess.peers[rank])
+		} else {
+			failed.Ranks = append(failed.Ranks, rank)
+			failed.Peers = append(failed.Peers, sess.peers[rank])
+		}
+	}
+	if len(failed.Ranks) == 0 {
+		return nil, nil, nil
+	}
+	sub, err := sess.Subset("resilience", up)
+	if err != nil {
+		return nil, nil, err
+	}
+	var agreed bool
+	err = withTimeout(func() error {
+		var err error
+		agreed, err = sub.BytesConsensus(failed.Peers.Bytes(), "kungfu::failed-peers")
+		return err
+	}, config.ResilienceTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !agreed {
+		return nil, nil, errFailedPeerMismatch
+	}
+	sess.survivors.sess, sess.survivors.failed = sub, failed
+	return sub, failed, nil
+}
+
+// pingAll returns whether each peer answers a ping within timeout, the peer itself answers.
+func (sess *Session) pingAll(timeout time.Duration) []bool {
+	up := make([]bool, len(sess.peers))
+	var wg sync.WaitGroup
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			up[rank] = true
+			continue
+		}
+		wg.Add(1)
+		go func(rank int, peer plan.PeerID) {
+			defer wg.Done()
+			up[rank] = withTimeout(func() error {
+				_, err := sess.client.Ping(peer)
+				return err
+			}, timeout) == nil
+		}(rank, peer)
+	}
+	wg.Wait()
+	return up
+}
+
+// withTimeout runs f, and returns errResilienceTimeout if it doesn't finish within timeout, leaving it running.
+func withTimeout(f func() error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errResilienceTimeout
+	}
+}
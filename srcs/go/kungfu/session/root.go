@@ -0,0 +1,102 @@
+package session
+
+import (
+	"fmt"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// treeStrategies are the strategies of a single tree, rooted at defaultRoot unless a root is selected.
+var treeStrategies = map[kb.Strategy]bool{
+	kb.Star:           true,
+	kb.Tree:           true,
+	kb.BinaryTree:     true,
+	kb.BinaryTreeStar: true,
+}
+
+// genRootedStrategyList generates the strategies of name rooted at root, by rotating the peers so that root comes first.
+// Unlike relabelling the generated graphs, it keeps the host masters of hierarchical trees on their hosts.
+func genRootedStrategyList(peers plan.PeerList, name kb.Strategy, root int) strategyList {
+	k := len(peers)
+	if root == defaultRoot || !treeStrategies[name] {
+		return genGlobalStrategyList(peers, name)
+	}
+	rotated := append(peers[root:].Clone(), peers[:root]...)
+	f := func(i int) int { return (i + root) % k }
+	var sl strategyList
+	for _, s := range genGlobalStrategyList(rotated, name) {
+		t := strategy{
+			reduceGraph: s.reduceGraph.Relabel(f),
+			bcastGraph:  s.bcastGraph.Relabel(f),
+		}
+		for _, g := range s.stages {
+			t.stages = append(t.stages, g.Relabel(f))
+		}
+		sl = append(sl, t)
+	}
+	return sl
+}
+
+// ProbeLinkCosts measures the links between all peers, it must be called by all peers.
+// The cost of a link is the expected duration in seconds of sending size bytes over it, by its latency and throughput.
+func (sess *Session) ProbeLinkCosts(size int) ([][]float64, error) {
+	k := len(sess.peers)
+	x := kb.NewVector(k, kb.F32)
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		stats, err := sess.client.Probe(peer, size)
+		if err != nil {
+			return nil, err
+		}
+		cost := stats.Latency.Seconds() / 2
+		if stats.Throughput > 0 {
+			cost += float64(size) / stats.Throughput
+		}
+		x.AsF32()[rank] = float32(cost)
+	}
+	y := kb.NewVector(k*k, kb.F32)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: "kungfu::link-costs"}
+	if err := sess.AllGather(w); err != nil {
+		return nil, err
+	}
+	costs := make([][]float64, k)
+	for i := range costs {
+		costs[i] = make([]float64, k)
+		for j := range costs[i] {
+			costs[i][j] = float64(y.AsF32()[i*k+j])
+		}
+	}
+	return costs, nil
+}
+
+// SelectTreeRoot probes the links between all peers, and roots the trees of tree strategies at the peer of min cost,
+// including the global strategies and the candidates of AutoTune. It must be called by all peers.
+func (sess *Session) SelectTreeRoot() (int, error) {
+	costs, err := sess.ProbeLinkCosts(config.ChunkSize)
+	if err != nil {
+		return defaultRoot, err
+	}
+	root := plan.MinCostRoot(costs)
+	sess.Lock()
+	sess.treeRoot = root
+	name, registered := sess.strategyName, len(sess.registeredName) > 0
+	sess.Unlock()
+	if sess.rank == defaultRoot {
+		sess.logger.Infof("selected tree root %d", root)
+	}
+	if registered || !treeStrategies[name] || root == defaultRoot {
+		return root, nil
+	}
+	return root, sess.SetGlobalStrategy(named(fmt.Sprintf("%s@%d", name, root), genRootedStrategyList(sess.peers, name, root)))
+}
+
+func (sess *Session) genCandidateStrategyList(name kb.Strategy) strategyList {
+	sess.Lock()
+	root := sess.treeRoot
+	sess.Unlock()
+	return genRootedStrategyList(sess.peers, name, root)
+}
@@ -0,0 +1,218 @@
+package session
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// HalvingDoubling names the recursive halving-doubling all reduce in selection tables.
+// It's not a graph strategy, so it can only be selected by message size.
+const HalvingDoubling = `HALVING_DOUBLING`
+
+// DefaultSelectionTable uses a tree for small messages, halving-doubling for medium ones and rings for large ones.
+const DefaultSelectionTable = `65536:BINARY_TREE_STAR,4194304:HALVING_DOUBLING,*:RING`
+
+// selectionRule selects an algorithm for all reduce of messages up to maxBytes.
+type selectionRule struct {
+	maxBytes   int // 0 for no limit
+	algorithm  string
+	strategies strategyList // nil for HalvingDoubling
+}
+
+// selectionTable maps message sizes to algorithms, like MPI implementations do.
+// It's consulted by AllReduce before the global strategies, messages of no rule use the global strategies.
+type selectionTable struct {
+	sync.Mutex
+	rules []selectionRule
+}
+
+// parseSelectionTable parses a comma separated list of <max bytes>:<algorithm>, in increasing order of sizes,
+// the last one can be *:<algorithm> for no limit. The algorithm is a strategy name or HALVING_DOUBLING.
+func parseSelectionTable(val string, peers plan.PeerList) ([]selectionRule, error) {
+	if strings.ToLower(val) == `default` {
+		val = DefaultSelectionTable
+	}
+	var rules []selectionRule
+	for _, spec := range strings.Split(val, ",") {
+		kv := strings.SplitN(spec, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid selection rule: %q", spec)
+		}
+		if n := len(rules); n > 0 && rules[n-1].maxBytes == 0 {
+			return nil, fmt.Errorf("selection rule after *: %q", spec)
+		}
+		var r selectionRule
+		if kv[0] != `*` {
+			n, err := strconv.Atoi(kv[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid size of selection rule: %q", spec)
+			}
+			if k := len(rules); k > 0 && n <= rules[k-1].maxBytes {
+				return nil, fmt.Errorf("sizes of selection rules must increase: %q", spec)
+			}
+			r.maxBytes = n
+		}
+		sl, err := genSelectedStrategies(kv[1], peers)
+		if err != nil {
+			return nil, err
+		}
+		r.algorithm, r.strategies = kv[1], sl
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func genSelectedStrategies(algorithm string, peers plan.PeerList) (strategyList, error) {
+	if algorithm == HalvingDoubling {
+		return nil, nil
+	}
+	s, err := kb.ParseStrategy(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, algorithm)
+	}
+	if *s == kb.Auto {
+		*s = autoSelect(peers)
+	}
+	return named(s.String(), genGlobalStrategyList(peers, *s)), nil
+}
+
+func (t *selectionTable) lookup(w kb.Workspace) (selectionRule, bool) {
+	if t == nil || !w.OP.ElementWise() {
+		return selectionRule{}, false
+	}
+	size := w.RecvBuf.Count * w.RecvBuf.Type.Size()
+	t.Lock()
+	defer t.Unlock()
+	for _, r := range t.rules {
+		if r.maxBytes == 0 || size <= r.maxBytes {
+			return r, true
+		}
+	}
+	return selectionRule{}, false
+}
+
+func (t *selectionTable) set(rules []selectionRule) {
+	t.Lock()
+	defer t.Unlock()
+	t.rules = rules
+}
+
+func (t *selectionTable) String() string {
+	t.Lock()
+	defer t.Unlock()
+	var parts []string
+	for _, r := range t.rules {
+		size := `*`
+		if r.maxBytes > 0 {
+			size = strconv.Itoa(r.maxBytes)
+		}
+		parts = append(parts, size+":"+r.algorithm)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (sess *Session) runSelected(w kb.Workspace, r selectionRule) error {
+	if r.strategies == nil {
+		return sess.runHalvingDoubling(w)
+	}
+	return sess.runStrategies(w, plan.EvenPartition, r.strategies)
+}
+
+// SelectionTable returns the selection table in the format of parseSelectionTable, or "" if there is none.
+func (sess *Session) SelectionTable() string {
+	if sess.selection == nil {
+		return ""
+	}
+	return sess.selection.String()
+}
+
+// TuneSelectionTable benchmarks all reduce of the algorithms at each of autoTuneSizes,
+// and selects the fastest for the range of sizes around it. It must be called by all peers.
+func (sess *Session) TuneSelectionTable() error {
+	if sess.selection == nil {
+		return nil
+	}
+	algorithms := []string{HalvingDoubling}
+	for _, s := range autoTuneCandidates {
+		algorithms = append(algorithms, s.String())
+	}
+	candidates := make([]selectionRule, len(algorithms))
+	for i, a := range algorithms {
+		sl, err := genSelectedStrategies(a, sess.peers)
+		if err != nil {
+			return err
+		}
+		candidates[i] = selectionRule{algorithm: a, strategies: sl}
+	}
+	x := kb.NewVector(len(autoTuneSizes)*len(candidates), kb.F32)
+	for i, size := range autoTuneSizes {
+		for j, c := range candidates {
+			d, err := sess.benchmarkSelected(c, size)
+			if err != nil {
+				return err
+			}
+			x.AsF32()[i*len(candidates)+j] = float32(d.Seconds())
+		}
+	}
+	// peers measure different durations, use the slowest
+	y := kb.NewVector(x.Count, kb.F32)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::autotune:selection"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	var rules []selectionRule
+	for i := range autoTuneSizes {
+		ts := y.AsF32()[i*len(candidates) : (i+1)*len(candidates)]
+		best := 0
+		for j, t := range ts {
+			if t < ts[best] {
+				best = j
+			}
+		}
+		r := candidates[best]
+		// the boundary between two sizes is their geometric mean
+		if i+1 < len(autoTuneSizes) {
+			r.maxBytes = int(math.Sqrt(float64(autoTuneSizes[i]) * float64(autoTuneSizes[i+1])))
+		}
+		if n := len(rules); n > 0 && rules[n-1].algorithm == r.algorithm {
+			rules[n-1].maxBytes = r.maxBytes
+		} else {
+			rules = append(rules, r)
+		}
+	}
+	if err := sess.barrier(); err != nil {
+		return err
+	}
+	sess.selection.set(rules)
+	if sess.rank == defaultRoot {
+		sess.logger.Infof("autotune: using selection table %s", sess.selection)
+	}
+	return nil
+}
+
+func (sess *Session) benchmarkSelected(r selectionRule, size int) (time.Duration, error) {
+	count := size / kb.F32.Size()
+	w := kb.Workspace{
+		SendBuf: kb.NewVector(count, kb.F32),
+		RecvBuf: kb.NewVector(count, kb.F32),
+		OP:      kb.SUM,
+		Name:    fmt.Sprintf("kungfu::autotune:selection:%s:%d", r.algorithm, size),
+	}
+	if err := sess.barrier(); err != nil {
+		return 0, err
+	}
+	t0 := time.Now()
+	for i := 0; i < autoTuneRounds; i++ {
+		if err := sess.runSelected(w, r); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(t0), nil
+}
@@ -0,0 +1,48 @@
+package session
+
+import (
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_selectionTable(t *testing.T) {
+	hl, err := plan.ParseHostList(`127.0.0.1:4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := hl.GenPeerList(4, plan.DefaultPortRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := parseSelectionTable(`default`, pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &selectionTable{rules: rules}
+	if got := st.String(); got != DefaultSelectionTable {
+		t.Errorf("unexpected table %s", got)
+	}
+	for _, c := range []struct {
+		count     int
+		algorithm string
+	}{
+		{1, `BINARY_TREE_STAR`},
+		{16 * 1024, `BINARY_TREE_STAR`},
+		{16*1024 + 1, HalvingDoubling},
+		{1024 * 1024, HalvingDoubling},
+		{1024*1024 + 1, `RING`},
+	} {
+		w := kb.Workspace{SendBuf: kb.NewVector(c.count, kb.F32), RecvBuf: kb.NewVector(c.count, kb.F32), OP: kb.SUM}
+		r, ok := st.lookup(w)
+		if !ok || r.algorithm != c.algorithm {
+			t.Errorf("%d floats: selected %q, want %q", c.count, r.algorithm, c.algorithm)
+		}
+	}
+	for _, val := range []string{`1024:RING,512:STAR`, `*:RING,1024:STAR`, `1024:UNKNOWN`, `RING`} {
+		if _, err := parseSelectionTable(val, pl); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package session
 
 import (
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/kungfu/execution"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/plan/graph"
@@ -12,15 +17,40 @@ import (
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 	"github.com/lsds/KungFu/srcs/go/rchannel/handler"
 	"github.com/lsds/KungFu/srcs/go/utils"
-	"github.com/lsds/KungFu/srcs/go/utils/assert"
 )
 
 const defaultRoot = 0
 
 // A strategy is a pair of graphs for collective communication
 type strategy struct {
+	name        string
+	suspended   bool
+	standby     bool // built but not used until promoted
+	promoted    bool // promoted from standby when another strategy was suspended
+	broken      bool // uses a link of an open circuit breaker
 	reduceGraph *graph.Graph
 	bcastGraph  *graph.Graph
+	stages      []*graph.Graph // if set, AllReduce runs stages in order instead of reduceGraph and bcastGraph
+}
+
+// sends returns the number of messages sent by the peer of rank to run a chunk on s.
+func (s strategy) sends(rank int) int {
+	var n int
+	for _, g := range s.graphs() {
+		for _, j := range g.Nexts(rank) {
+			if j != rank {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (s strategy) graphs() []*graph.Graph {
+	if len(s.stages) > 0 {
+		return s.stages
+	}
+	return []*graph.Graph{s.reduceGraph, s.bcastGraph}
 }
 
 // Session contains the immutable peer list for a given period of logical duration
@@ -28,7 +58,7 @@ type Session struct {
 	sync.Mutex
 
 	localStrategies   strategyList
-	globalStrategies  strategyList
+	swap              *hotSwap // guards the global strategies
 	crossStrategies   strategyList
 	self              plan.PeerID
 	peers             plan.PeerList
@@ -38,10 +68,50 @@ type Session struct {
 	hostCount         int
 	client            *client.Client
 	collectiveHandler *handler.CollectiveEndpoint
-	strategyHash      strategyHashFunc
+	hashMu            sync.Mutex
+	strategyHash      StrategyHash // guarded by hashMu
+	strategyName      kb.Strategy
+	treeRoot          int // root of tree strategies, selected by SelectTreeRoot
+	shards            *shardMap
+	bcastCache        *broadcastCache
+	groups            plan.Groups
+	logger            *log.Logger
+	tag               string // prefix of the names of collective messages, to separate groups from their parent
+	subSessions       map[string]*Session
+	capabilities      []plan.Capability
+	registeredName    string          // name of the registered strategy in use, if any
+	qos               string          // QoS class of collective messages
+	selection         *selectionTable // nil if all reduce doesn't select by message size
+	progress          progress
+	changes           changeLog
+	monitorConfig     MonitorConfig // guarded by the session lock
+	monitorCalls      int
+	barrierRounds     int // of BarrierTimeout
+	survivors         survivors
+	resilientRounds   resilientRounds
+	checkedOPs        checkedOPs
+	reweightCalls     int
+	lastCanary        time.Time // of MonitorStrategies
+	failures          *failureCounter
+	attempts          *attemptNames
+	breakers          *linkBreakers
+	stats             *strategyStats
+	profiler          stepProfiler
+	collectives       collectiveCounters
+	plans             *planCache
+	chunks            *chunkTuner // nil if collectives are chunked by config.ChunkSize
+	postHooks         postHooks
+	buffers           ownedBuffers
+	links             linkStats
+	codecMu           sync.Mutex
+	codec             Codec          // guarded by codecMu, nil if messages are not encoded
+	codecs            *codecSelector // nil if all messages are encoded by codec
+	syncPolicy        syncPolicy
+	localOnly         localOnly
+	gpuDirect         gpuDirect
 }
 
-func New(strategy kb.Strategy, self plan.PeerID, pl plan.PeerList, client *client.Client, collectiveHandler *handler.CollectiveEndpoint) (*Session, bool) {
+func New(strategy kb.Strategy, self plan.PeerID, pl plan.PeerList, groups plan.Groups, client *client.Client, collectiveHandler *handler.CollectiveEndpoint) (*Session, bool) {
 	rank, ok := pl.Rank(self)
 	if !ok {
 		return nil, false
@@ -53,9 +123,36 @@ func New(strategy kb.Strategy, self plan.PeerID, pl plan.PeerList, client *clien
 	if strategy == kb.Auto {
 		strategy = autoSelect(pl)
 	}
+	logger := log.With(log.F("rank", rank))
+	globalStrategies := named(strategy.String(), genGlobalStrategyList(pl, strategy))
+	var registeredName string
+	if name := config.Strategy; len(name) > 0 {
+		if sl, err := genRegisteredStrategyList(name, pl); err != nil {
+			logger.Errorf("using %s instead of %s: %v", strategy, name, err)
+		} else {
+			globalStrategies, registeredName = sl, name
+		}
+	}
+	globalStrategies = append(globalStrategies, genStandbyStrategyList(pl)...)
+	var selection *selectionTable
+	if val := config.AlgorithmTable; len(val) > 0 {
+		if rules, err := parseSelectionTable(val, pl); err != nil {
+			logger.Errorf("not selecting all reduce by message size: %v", err)
+		} else {
+			selection = &selectionTable{rules: rules}
+		}
+	}
+	chunks, err := newChunkTuner()
+	if err != nil {
+		logger.Errorf("chunking collectives by %d bytes: %v", config.ChunkSize, err)
+	}
+	codecs, err := newCodecSelector()
+	if err != nil {
+		logger.Errorf("encoding all messages by the codec: %v", err)
+	}
 	sess := &Session{
 		localStrategies:   genLocalStrategyList(pl),
-		globalStrategies:  genGlobalStrategyList(pl, strategy),
+		swap:              newHotSwap(globalStrategies),
 		crossStrategies:   genCrossStrategyList(pl, strategy),
 		self:              self,
 		peers:             pl,
@@ -66,10 +163,41 @@ func New(strategy kb.Strategy, self plan.PeerID, pl plan.PeerList, client *clien
 		client:            client,
 		collectiveHandler: collectiveHandler,
 		strategyHash:      getStrategyHash(),
+		strategyName:      strategy,
+		shards:            newShardMap(len(pl)),
+		bcastCache:        newBroadcastCache(),
+		groups:            groups,
+		subSessions:       make(map[string]*Session),
+		registeredName:    registeredName,
+		selection:         selection,
+		failures:          newFailureCounter(),
+		attempts:          newAttemptNames(),
+		breakers:          newLinkBreakers(config.LinkRetryBudget),
+		stats:             newStrategyStats(getStatSampler()),
+		plans:             newPlanCache(),
+		chunks:            chunks,
+		codecs:            codecs,
+		monitorConfig:     DefaultMonitorConfig(),
+		logger:            logger,
 	}
 	return sess, true
 }
 
+// SetLogger replaces the logger of the session by l with the rank of this peer, e.g. with the ID of the session.
+func (sess *Session) SetLogger(l *log.Logger) {
+	sess.logger = l.With(log.F("rank", sess.rank))
+}
+
+// strategyLogger returns the logger of the session with the index of the global strategy of the given name.
+func (sess *Session) strategyLogger(name string) *log.Logger {
+	for i, s := range sess.swap.latest() {
+		if s.name == name {
+			return sess.logger.With(log.F("strategy", i))
+		}
+	}
+	return sess.logger
+}
+
 func (sess *Session) Size() int {
 	return len(sess.peers)
 }
@@ -110,7 +238,9 @@ func (sess *Session) barrier() error {
 		OP:      kb.SUM,
 		Name:    "kungfu::barrier", // TODO: use tag
 	}
-	return sess.runStrategies(w, plan.EvenPartition, sess.globalStrategies)
+	return sess.orLocal(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()) }
+	})()
 }
 
 func (sess *Session) Consensus(w kb.Workspace) error {
@@ -123,6 +253,43 @@ func (sess *Session) Consensus(w kb.Workspace) error {
 }
 
 func (sess *Session) BytesConsensus(bs []byte, name string) (bool, error) {
+	return sess.bytesConsensus(bs, name, sess.AllReduce)
+}
+
+var errConsensusTimeout = failure.New(failure.Timeout, "consensus timeout")
+
+// errCompensationMismatch is returned by a compensated sum if peers disagree on Workspace.Compensated,
+// as the rounding errors are sent with the partial sums.
+var errCompensationMismatch = failure.New(failure.ProtocolMismatch, "peers disagree on compensated summation")
+
+// BytesConsensusWithin is BytesConsensus, which fails with errConsensusTimeout if the peers don't agree within timeout,
+// e.g. as some of them are partitioned from the others. The receives of the consensus are cancelled at the deadline,
+// and its messages are copied, so that they never block the connections of later collectives.
+func (sess *Session) BytesConsensusWithin(bs []byte, name string, timeout time.Duration) (bool, error) {
+	abandon := make(chan struct{})
+	t := time.AfterFunc(timeout, func() { close(abandon) })
+	defer t.Stop()
+	s := sess.nextGlobalStrategies().active().choose(0)
+	type result struct {
+		ok  bool
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, err := sess.bytesConsensus(bs, name, func(w kb.Workspace) error {
+			return sess.runGraphsWith(w, connection.NoFlag, nil, abandon, s.graphs()...)
+		})
+		done <- result{ok, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ok, r.err
+	case <-abandon: // the sends to unreachable peers may still be retried
+		return false, fmt.Errorf("%w: %s after %s", errConsensusTimeout, name, timeout)
+	}
+}
+
+func (sess *Session) bytesConsensus(bs []byte, name string, allReduce func(kb.Workspace) error) (bool, error) {
 	n := len(bs)
 	{
 		x := kb.NewVector(1, kb.I32)
@@ -131,8 +298,12 @@ func (sess *Session) BytesConsensus(bs []byte, name string) (bool, error) {
 		x.AsI32()[0] = int32(n)
 		w1 := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: ":consensus:len:min:" + name}
 		w2 := kb.Workspace{SendBuf: x, RecvBuf: z, OP: kb.MAX, Name: ":consensus:len:max:" + name}
-		assert.OK(sess.AllReduce(w1))
-		assert.OK(sess.AllReduce(w2))
+		if err := allReduce(w1); err != nil {
+			return false, err
+		}
+		if err := allReduce(w2); err != nil {
+			return false, err
+		}
 		if !utils.BytesEq(y.Data, z.Data) {
 			return false, nil
 		}
@@ -146,8 +317,12 @@ func (sess *Session) BytesConsensus(bs []byte, name string) (bool, error) {
 		z := kb.NewVector(n, kb.U8)
 		w1 := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: ":consensus:min:" + name}
 		w2 := kb.Workspace{SendBuf: x, RecvBuf: z, OP: kb.MAX, Name: ":consensus:max:" + name}
-		assert.OK(sess.AllReduce(w1))
-		assert.OK(sess.AllReduce(w2))
+		if err := allReduce(w1); err != nil {
+			return false, err
+		}
+		if err := allReduce(w2); err != nil {
+			return false, err
+		}
 		if !utils.BytesEq(y.Data, z.Data) {
 			return false, nil
 		}
@@ -156,26 +331,85 @@ func (sess *Session) BytesConsensus(bs []byte, name string) (bool, error) {
 }
 
 func (sess *Session) Reduce(w kb.Workspace) error {
-	strategy := sess.globalStrategies[0] // Assuming len(sess.globalStrategies) > 0
-	return sess.runGraphs(w, strategy.reduceGraph)
+	if giant, err := runGiant(w, sess.Reduce); giant {
+		return err
+	}
+	defer sess.track("reduce", w)()
+	if err := checkHost(w); err != nil {
+		return err
+	}
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return err
+	}
+	strategy := sess.nextGlobalStrategies()[0] // Assuming there is at least one global strategy
+	return sess.orLocal(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runGraphs(w, strategy.reduceGraph) }
+	})()
 }
 
 func (sess *Session) Broadcast(w kb.Workspace) error {
-	strategy := sess.globalStrategies[0] // Assuming len(sess.globalStrategies) > 0
-	return sess.runGraphs(w, strategy.bcastGraph)
+	if giant, err := runGiant(w, sess.Broadcast); giant {
+		return err
+	}
+	defer sess.track("broadcast", w)()
+	if err := sess.checkDeviceBroadcast(w); err != nil {
+		return err
+	}
+	strategy := sess.nextGlobalStrategies()[0] // Assuming there is at least one global strategy
+	return sess.orLocal(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runGraphs(w, strategy.bcastGraph) }
+	})()
+}
+
+// BroadcastFrom broadcasts w from the peer of rank root along a tree in which each peer forwards to at most fanout peers,
+// or to all others if fanout is 0, e.g. to disseminate weights initialized by a parameter server.
+// It must be called by all peers with the same root and fanout.
+func (sess *Session) BroadcastFrom(w kb.Workspace, root int, fanout int) error {
+	if giant, err := runGiant(w, func(w kb.Workspace) error { return sess.BroadcastFrom(w, root, fanout) }); giant {
+		return err
+	}
+	defer sess.track("broadcast", w)()
+	p, err := graph.NewTree(len(sess.peers)).RootedAt(root).Arity(fanout).Build()
+	if err != nil {
+		return err
+	}
+	return sess.orLocal(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runGraphs(w, p.Bcast) }
+	})()
 }
 
 func (sess *Session) Gather(w kb.Workspace) error {
+	defer sess.track("gather", w)()
+	if err := checkHost(w); err != nil {
+		return err
+	}
 	// TODO: validate input
-	return sess.runGather(w)
+	return sess.orLocalWith(w, func(w kb.Workspace) func() error {
+		return func() error { return sess.runGather(w) }
+	}, func(w kb.Workspace) {
+		if sess.rank == defaultRoot {
+			sess.ownBlock(w).CopyFrom(w.SendBuf)
+		}
+	})()
 }
 
 func (sess *Session) LocalReduce(w kb.Workspace) error {
+	if giant, err := runGiant(w, sess.LocalReduce); giant {
+		return err
+	}
+	defer sess.track("local_reduce", w)()
+	if err := sess.checkOP(w, localOPs); err != nil {
+		return err
+	}
 	strategy := sess.localStrategies[0] // len(sess.localStrategies) == 1
 	return sess.runGraphs(w, strategy.reduceGraph)
 }
 
 func (sess *Session) LocalBroadcast(w kb.Workspace) error {
+	if giant, err := runGiant(w, sess.LocalBroadcast); giant {
+		return err
+	}
+	defer sess.track("local_broadcast", w)()
 	strategy := sess.localStrategies[0] // len(sess.localStrategies) == 1
 	return sess.runGraphs(w, strategy.bcastGraph)
 }
@@ -189,26 +423,17 @@ func asMessage(b *kb.Vector) connection.Message {
 
 func (sess *Session) runGather(w kb.Workspace) error {
 	if sess.rank != defaultRoot {
-		peer := sess.peers[defaultRoot]
-		return sess.client.Send(peer.WithName(w.Name), w.SendBuf.Data, connection.ConnCollective, connection.NoFlag)
+		return sess.sendChunked(sess.peers[defaultRoot], w.Name, w.SendBuf.Data)
 	}
-	var wg sync.WaitGroup
 	count := w.SendBuf.Count
-	for rank, peer := range sess.peers {
-		wg.Add(1)
-		go func(rank int, peer plan.PeerID, recvBuf *kb.Vector) {
-			if rank == sess.rank {
-				recvBuf.CopyFrom(w.SendBuf)
-			} else {
-				m := sess.collectiveHandler.Recv(peer.WithName(w.Name))
-				b := &kb.Vector{Data: m.Data, Count: recvBuf.Count, Type: recvBuf.Type}
-				recvBuf.CopyFrom(b)
-			}
-			wg.Done()
-		}(rank, peer, w.RecvBuf.Slice(count*rank, count*(rank+1)))
-	}
-	wg.Wait()
-	return nil // FIXME: handle errors
+	return sess.fromAll(func(rank int, peer plan.PeerID) error {
+		recvBuf := w.RecvBuf.Slice(count*rank, count*(rank+1))
+		if rank == sess.rank {
+			recvBuf.CopyFrom(w.SendBuf)
+			return nil
+		}
+		return sess.recvChunked(peer, w.Name, recvBuf.Data)
+	}, "runGather")
 }
 
 func isIsolated(rank int, graphs ...*graph.Graph) bool {
@@ -220,69 +445,217 @@ func isIsolated(rank int, graphs ...*graph.Graph) bool {
 	return true
 }
 
+// runGraphs runs the graphs in order, each graph on the segments of w in a pipeline,
+// so that a peer forwards a segment while it receives the next one, rather than after it has received the whole of w.
 func (sess *Session) runGraphs(w kb.Workspace, graphs ...*graph.Graph) error {
+	return sess.runGraphsWith(w, connection.WaitRecvBuf, nil, nil, graphs...)
+}
+
+// runGraphsWith runs the graphs as runGraphs, forwarded messages are received directly into w.RecvBuf if flag is WaitRecvBuf,
+// or copied into it if flag is NoFlag, which never blocks the connection on a receiver that has given up.
+// If codec is not nil, messages are encoded by it, and always copied as their sizes differ from the buffers.
+// The receives are cancelled once abandon is closed, if it's not nil, e.g. for an attempt that timed out.
+func (sess *Session) runGraphsWith(w kb.Workspace, flag uint32, codec Codec, abandon <-chan struct{}, graphs ...*graph.Graph) error {
 	if w.IsEmpty() { // w.IsInplace will panic
 		return nil
 	}
+	if err := sess.collectiveHandler.Aborted(); err != nil {
+		return err
+	}
 	if isIsolated(sess.rank, graphs...) {
 		w.Forward()
 		return nil
 	}
+	if codec != nil {
+		flag = connection.NoFlag
+	}
 
-	var recvCount int
-	effectiveBuffer := func() *kb.Vector {
-		if recvCount > 0 || w.IsInplace() {
-			return w.RecvBuf
+	segs := segments(w)
+	recvCounts := make([]int, len(segs))
+	effectiveBuffer := func(i int) *kb.Vector {
+		if recvCounts[i] > 0 || w.IsInplace() {
+			return segs[i].RecvBuf
+		}
+		return segs[i].SendBuf
+	}
+	encoded := make([][]byte, len(segs)) // messages forwarded as received, so that all peers decode the same values
+	// sums of 16-bit floats are always accumulated with float32 compensations, which are sent with the partial sums
+	// unless they are encoded, so that the root corrects the sum once by the rounding errors of all peers
+	comps := make([]*kb.Compensator, len(segs))
+	if t := w.RecvBuf.Type; w.OP == kb.SUM && (w.Compensated || t == kb.F16 || t == kb.BF16) {
+		for i, s := range segs {
+			comps[i] = kb.NewCompensator(s.RecvBuf.Count, s.RecvBuf.Type)
+		}
+	}
+	// other ops of 16-bit floats are accumulated in float32, and rounded once per peer rather than once per received message
+	accs := make([]*kb.Accumulator, len(segs))
+	if comps[0] == nil && !w.OP.IsCustom() {
+		for i, s := range segs {
+			accs[i] = kb.NewAccumulator(s.RecvBuf.Count, s.RecvBuf.Type)
+		}
+	}
+	propagated := func(i int) bool { return codec == nil && comps[i] != nil }
+	reduced := func(i int) []byte {
+		if codec != nil {
+			return codec.Encode(effectiveBuffer(i))
+		}
+		if !propagated(i) {
+			return effectiveBuffer(i).Data
 		}
-		return w.SendBuf
+		terms := comps[i].Terms().Data
+		data := make([]byte, 0, len(effectiveBuffer(i).Data)+len(terms))
+		data = append(append(data, effectiveBuffer(i).Data...), terms...)
+		comps[i].Reset() // sent once
+		return data
 	}
-	var sendOnto execution.PeerFunc = func(peer plan.PeerID) error {
-		return sess.client.Send(peer.WithName(w.Name), effectiveBuffer().Data, connection.ConnCollective, connection.NoFlag)
+	forwarded := func(i int) ([]byte, error) {
+		if codec == nil {
+			return effectiveBuffer(i).Data, nil
+		}
+		if encoded[i] == nil { // the root decodes its own message, as the others do
+			encoded[i] = codec.Encode(effectiveBuffer(i))
+			if err := codec.Decode(encoded[i], segs[i].RecvBuf); err != nil {
+				return nil, err
+			}
+		}
+		return encoded[i], nil
 	}
-	var sendInto execution.PeerFunc = func(peer plan.PeerID) error {
-		return sess.client.Send(peer.WithName(w.Name), effectiveBuffer().Data, connection.ConnCollective, connection.WaitRecvBuf)
+	sendOnto := func(i int, data []byte) execution.PeerFunc {
+		return func(peer plan.PeerID) error {
+			if err := sess.send(peer.WithName(sess.tagged(segs[i].Name)), data, connection.NoFlag); err != nil {
+				return sess.sendFailed(peer, err)
+			}
+			return nil
+		}
+	}
+	sendInto := func(i int, data []byte) execution.PeerFunc {
+		return func(peer plan.PeerID) error {
+			if err := sess.send(peer.WithName(sess.tagged(segs[i].Name)), data, flag); err != nil {
+				return sess.sendFailed(peer, err)
+			}
+			return nil
+		}
 	}
 
 	var lock sync.Mutex
-	var recvOnto execution.PeerFunc = func(peer plan.PeerID) error {
-		m := sess.collectiveHandler.Recv(peer.WithName(w.Name))
-		b := &kb.Vector{Data: m.Data, Count: w.SendBuf.Count, Type: w.SendBuf.Type}
-		lock.Lock()
-		defer lock.Unlock()
-		kb.Transform2(w.RecvBuf, effectiveBuffer(), b, w.OP)
-		recvCount++
-		connection.PutBuf(m.Data) // Recycle buffer on the RecvOnto path
-		return nil
+	recvOnto := func(i int, c *cancellation) execution.PeerFunc {
+		return func(peer plan.PeerID) error {
+			m, err := sess.collectiveHandler.RecvCancel(peer.WithName(sess.tagged(segs[i].Name)), c.Done())
+			if err != nil {
+				c.cancel(err) // the receives from the other peers
+				return err
+			}
+			traceRecv(segs[i].Name, len(m.Data))
+			b := &kb.Vector{Data: m.Data, Count: segs[i].SendBuf.Count, Type: segs[i].SendBuf.Type}
+			var terms *kb.Vector
+			if propagated(i) {
+				n := len(segs[i].SendBuf.Data)
+				t := comps[i].Terms()
+				if len(m.Data) != n+len(t.Data) {
+					return fmt.Errorf("%w: %s of %d bytes from %s, expected %d bytes", errCompensationMismatch, segs[i].Name, len(m.Data), peer, n+len(t.Data))
+				}
+				b.Data = m.Data[:n]
+				terms = &kb.Vector{Data: m.Data[n:], Count: t.Count, Type: t.Type}
+			}
+			if codec != nil {
+				b = kb.NewVector(segs[i].SendBuf.Count, segs[i].SendBuf.Type)
+				err := codec.Decode(m.Data, b)
+				connection.PutBuf(m.Data)
+				if err != nil {
+					return err
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			traceReduceBegin(segs[i].Name, len(b.Data))
+			if comps[i] != nil {
+				comps[i].Transform2(segs[i].RecvBuf, effectiveBuffer(i), b)
+				if terms != nil {
+					comps[i].Add(terms)
+				}
+			} else {
+				accs[i].Transform2(segs[i].RecvBuf, effectiveBuffer(i), b, w.OP)
+			}
+			traceReduceEnd(segs[i].Name, len(b.Data))
+			recvCounts[i]++
+			if codec == nil {
+				connection.PutBuf(m.Data) // Recycle buffer on the RecvOnto path
+			}
+			return nil
+		}
 	}
-
-	var recvInto execution.PeerFunc = func(peer plan.PeerID) error {
-		sess.collectiveHandler.RecvInto(peer.WithName(w.Name), asMessage(w.RecvBuf))
-		recvCount++
-		return nil
+	recvInto := func(i int, c *cancellation) execution.PeerFunc {
+		return func(peer plan.PeerID) error {
+			if codec != nil {
+				m, err := sess.collectiveHandler.RecvCancel(peer.WithName(sess.tagged(segs[i].Name)), c.Done())
+				if err != nil {
+					return err
+				}
+				if err := codec.Decode(m.Data, segs[i].RecvBuf); err != nil {
+					return err
+				}
+				encoded[i] = m.Data
+			} else if flag == connection.NoFlag {
+				m, err := sess.collectiveHandler.RecvCancel(peer.WithName(sess.tagged(segs[i].Name)), c.Done())
+				if err != nil {
+					return err
+				}
+				copy(segs[i].RecvBuf.Data, m.Data)
+				connection.PutBuf(m.Data)
+			} else if err := sess.collectiveHandler.RecvIntoCancel(peer.WithName(sess.tagged(segs[i].Name)), asMessage(segs[i].RecvBuf), c.Done()); err != nil {
+				return err
+			}
+			traceRecv(segs[i].Name, len(segs[i].RecvBuf.Data))
+			recvCounts[i]++
+			return nil
+		}
 	}
 
 	for _, g := range graphs {
 		prevs := sess.peers.Select(g.Prevs(sess.rank))
 		nexts := sess.peers.Select(g.Nexts(sess.rank))
 		if g.IsSelfLoop(sess.rank) {
-			if err := recvOnto.Par(prevs); err != nil {
-				return err
+			recv := func(i int, c *cancellation) error {
+				if err := recvOnto(i, c).Par(prevs); err != nil {
+					return err
+				}
+				accs[i].Reset() // RecvBuf may be overwritten by the next graphs
+				if recvCounts[i] > 0 && (len(nexts) == 0 || !propagated(i)) {
+					comps[i].Compensate(segs[i].RecvBuf) // at the root, or before forwarding an encoded partial sum
+				}
+				return nil
+			}
+			send := func(i int) error {
+				if len(nexts) == 0 {
+					return nil
+				}
+				return sendOnto(i, reduced(i)).Par(nexts)
 			}
-			if err := sendOnto.Par(nexts); err != nil {
+			if err := pipeline(len(segs), abandon, recv, send); err != nil {
 				return err
 			}
 		} else {
 			if len(prevs) > 1 {
-				log.Errorf("more than once recvInto detected at node %d", sess.rank)
+				sess.logger.Errorf("more than once recvInto detected at node %d", sess.rank)
 			}
-			if len(prevs) == 0 && recvCount == 0 {
-				w.Forward()
-			} else {
-				if err := recvInto.Seq(prevs); err != nil { // len(prevs) == 1 is expected
+			recv := func(i int, c *cancellation) error {
+				if len(prevs) == 0 && recvCounts[i] == 0 {
+					segs[i].Forward()
+					return nil
+				}
+				return recvInto(i, c).Seq(prevs) // len(prevs) == 1 is expected
+			}
+			send := func(i int) error {
+				if len(nexts) == 0 {
+					return nil
+				}
+				data, err := forwarded(i)
+				if err != nil {
 					return err
 				}
+				return sendInto(i, data).Par(nexts)
 			}
-			if err := sendInto.Par(nexts); err != nil {
+			if err := pipeline(len(segs), abandon, recv, send); err != nil {
 				return err
 			}
 		}
@@ -290,10 +663,7 @@ func (sess *Session) runGraphs(w kb.Workspace, graphs ...*graph.Graph) error {
 	return nil
 }
 
-const (
-	Mi        = 1 << 20
-	chunkSize = 1 * Mi
-)
+const Mi = 1 << 20
 
 func ceilDiv(a, b int) int {
 	if a%b == 0 {
@@ -302,23 +672,65 @@ func ceilDiv(a, b int) int {
 	return a/b + 1
 }
 
-func (sess *Session) runStrategiesWithHash(w kb.Workspace, p kb.PartitionFunc, strategies strategyList, strategyHash strategyHashFunc) error {
-	k := ceilDiv(w.RecvBuf.Count*w.RecvBuf.Type.Size(), chunkSize)
-	errs := make([]error, k)
+func chunkCount(w kb.Workspace, chunkSize int) int {
+	if !w.OP.ElementWise() {
+		return 1
+	}
+	return ceilDiv(w.RecvBuf.Count*w.RecvBuf.Type.Size(), chunkSize)
+}
+
+func (sess *Session) runStrategiesWithHash(w kb.Workspace, p kb.PartitionFunc, strategies strategyList, strategyHash StrategyHash) error {
+	return sess.runEncodedStrategies(w, p, strategies, strategyHash, nil)
+}
+
+// runEncodedStrategies runs the chunks of w on the strategies chosen by strategyHash, with messages encoded by codec if it's not nil.
+func (sess *Session) runEncodedStrategies(w kb.Workspace, p kb.PartitionFunc, strategies strategyList, strategyHash StrategyHash, codec Codec) error {
+	strategies = strategies.active()
+	if config.CollectiveTimeout > 0 {
+		return sess.runStrategiesWithFallback(w, p, strategies, strategyHash, codec)
+	}
+	n := w.RecvBuf.Count * w.RecvBuf.Type.Size()
+	cp := sess.plans.get(w, p, len(strategies), strategyHash, sess.chunks.chunkSize(sess.Step(), n))
+	errs := make([]error, len(cp.intervals))
+	durations := make([]time.Duration, len(cp.intervals))
 	var wg sync.WaitGroup
-	for i, w := range w.Split(p, k) {
+	for i, w := range cp.split(w) {
 		wg.Add(1)
 		go func(i int, w kb.Workspace, s strategy) {
-			errs[i] = sess.runGraphs(w, s.reduceGraph, s.bcastGraph)
+			t0 := time.Now()
+			messages := s.sends(sess.rank)
+			errs[i] = sess.stats.timeChunk(s.name, messages, messages*w.SendBuf.Count*w.SendBuf.Type.Size(), func() error {
+				if codec != nil {
+					return sess.runGraphsWith(w, connection.NoFlag, codec, nil, s.graphs()...)
+				}
+				return sess.runGraphs(w, s.graphs()...)
+			})
+			durations[i] = time.Since(t0)
 			wg.Done()
-		}(i, w, strategies.choose(int(strategyHash(i, w.Name))))
+		}(i, w, strategies[cp.choices[i]])
 	}
 	wg.Wait()
-	return utils.MergeErrors(errs, "runStrategies")
+	if err := utils.MergeErrors(errs, "runStrategies"); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(w.Name, "kungfu::") { // the small collectives of KungFu itself would skew the throughput
+		sess.chunks.record(n, maxDuration(durations))
+	}
+	return nil
+}
+
+func maxDuration(ds []time.Duration) time.Duration {
+	var m time.Duration
+	for _, d := range ds {
+		if d > m {
+			m = d
+		}
+	}
+	return m
 }
 
 func (sess *Session) runStrategies(w kb.Workspace, p kb.PartitionFunc, strategies strategyList) error {
-	return sess.runStrategiesWithHash(w, p, strategies, sess.strategyHash)
+	return sess.runStrategiesWithHash(w, p, strategies, sess.getStrategyHash())
 }
 
 func boolToInt8(v bool) int8 {
@@ -1,31 +1,177 @@
 package session
 
 import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
 	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
 )
 
-// A strategyHashFunc is to map a given Workspace to a communication strategy.
+// A StrategyHash maps the i-th chunk w of a collective to a communication strategy, by the remainder of the number of strategies.
 // KungFu can create multiple communication strategies to balance the workload in the network core and edge links.
-// We support hash the Workspaces based on their names or their partition IDs if they are chunked.
-type strategyHashFunc func(int, string) uint64
+// A StrategyHash must be deterministic, and all peers must use the same one,
+// otherwise they run the same chunk on different strategies and deadlock.
+// It must depend only on the index, name and size of the chunk, because the strategies of chunks are cached by them.
+type StrategyHash interface {
+	Name() string
+	Hash(i int, w kb.Workspace) uint64
+}
+
+// Names of the built-in strategy hashes.
+const (
+	NameHash       = `NAME`        // by the name of the chunk, so that a tensor uses the same strategies in every step
+	RoundRobinHash = `ROUND_ROBIN` // by the index of the chunk
+	SizeHash       = `SIZE`        // by the power of 2 bucket of the size of the chunk, so that small messages don't queue behind large ones
+)
+
+type strategyHashFunc struct {
+	name string
+	f    func(i int, w kb.Workspace) uint64
+}
 
-func simpleHash(i int, name string) uint64 {
+func (h strategyHashFunc) Name() string { return h.name }
+
+func (h strategyHashFunc) Hash(i int, w kb.Workspace) uint64 { return h.f(i, w) }
+
+func roundRobinHash(i int, w kb.Workspace) uint64 {
 	return uint64(i)
 }
 
-func nameBasedHash(i int, name string) uint64 {
+func nameBasedHash(i int, w kb.Workspace) uint64 {
 	var h uint64
-	for _, c := range name {
+	for _, c := range w.Name {
 		h += uint64(c) * uint64(c)
 	}
 	return h
 }
 
-func getStrategyHash() strategyHashFunc {
-	if config.StrategyHashMethod == `NAME` {
-		log.Debugf("using name based hash")
-		return nameBasedHash
+func sizeBasedHash(i int, w kb.Workspace) uint64 {
+	return uint64(bits.Len(uint(len(w.RecvBuf.Data))))
+}
+
+var (
+	strategyHashesMu sync.Mutex
+	strategyHashes   = map[string]StrategyHash{
+		NameHash:       strategyHashFunc{NameHash, nameBasedHash},
+		RoundRobinHash: strategyHashFunc{RoundRobinHash, roundRobinHash},
+		SizeHash:       strategyHashFunc{SizeHash, sizeBasedHash},
+		WeightedHash:   &weightedHash{},
+	}
+)
+
+// RegisterStrategyHash makes a strategy hash available by its name, it is intended to be called from init functions.
+// The hash is selected by setting KUNGFU_CONFIG_STRATEGY_HASH_METHOD to its name, or by SetStrategyHash.
+// It panics if the name is empty or is already registered.
+func RegisterStrategyHash(h StrategyHash) {
+	strategyHashesMu.Lock()
+	defer strategyHashesMu.Unlock()
+	if h == nil || len(h.Name()) == 0 {
+		panic("RegisterStrategyHash: nil hash or empty name")
+	}
+	if _, dup := strategyHashes[h.Name()]; dup {
+		panic("RegisterStrategyHash: " + h.Name() + " is registered twice")
+	}
+	strategyHashes[h.Name()] = h
+}
+
+// StrategyHashes returns the sorted names of the built-in and registered strategy hashes.
+func StrategyHashes() []string {
+	strategyHashesMu.Lock()
+	defer strategyHashesMu.Unlock()
+	var names []string
+	for name := range strategyHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupStrategyHash(name string) (StrategyHash, error) {
+	strategyHashesMu.Lock()
+	h, ok := strategyHashes[name]
+	if !ok {
+		h, ok = strategyHashes[strings.ToUpper(name)] // the built-in names are case insensitive
+	}
+	strategyHashesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("strategy hash %q is not registered, options are %q", name, StrategyHashes())
+	}
+	return h, nil
+}
+
+func getStrategyHash() StrategyHash {
+	h, err := lookupStrategyHash(config.StrategyHashMethod)
+	if err != nil {
+		log.Errorf("using %s: %v", NameHash, err)
+		h, _ = lookupStrategyHash(NameHash)
+	}
+	log.Debugf("using %s strategy hash", h.Name())
+	return instance(h)
+}
+
+var errStrategyHashMismatch = failure.New(failure.ProtocolMismatch, "peers use different strategy hashes")
+
+// StrategyHashName returns the name of the strategy hash in use.
+func (sess *Session) StrategyHashName() string {
+	return sess.getStrategyHash().Name()
+}
+
+// getStrategyHash returns the strategy hash of the collectives of the current step.
+func (sess *Session) getStrategyHash() StrategyHash {
+	sess.hashMu.Lock()
+	h := sess.strategyHash
+	sess.hashMu.Unlock()
+	if wh, ok := h.(*weightedHash); ok {
+		return wh.at(sess.Step())
+	}
+	return h
+}
+
+// CheckStrategyHash returns an error if any peer uses a different strategy hash, it must be called by all peers.
+func (sess *Session) CheckStrategyHash() error {
+	return sess.checkStrategyHash(sess.StrategyHashName())
+}
+
+// SetStrategyHash replaces the strategy hash by the named one, it must be called by all peers with the same name.
+// The hash is not changed if any peer passes a different name.
+func (sess *Session) SetStrategyHash(name string) error {
+	h, err := lookupStrategyHash(name)
+	if err != nil {
+		return err
+	}
+	if err := sess.checkStrategyHash(name); err != nil {
+		return err
+	}
+	sess.hashMu.Lock()
+	defer sess.hashMu.Unlock()
+	sess.strategyHash = instance(h)
+	return nil
+}
+
+// checkStrategyHash agrees on name with all peers by the max of its digest and its negation, in one all reduce.
+// It runs on the first strategy, so that it doesn't depend on the strategy hashes being checked.
+func (sess *Session) checkStrategyHash(name string) error {
+	d := fnv.New32a()
+	d.Write([]byte(name))
+	h := int64(d.Sum32())
+	x := kb.NewVector(2, kb.I64)
+	y := kb.NewVector(2, kb.I64)
+	x.AsI64()[0], x.AsI64()[1] = h, -h
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::strategy-hash"}
+	first := strategyHashFunc{f: func(int, kb.Workspace) uint64 { return 0 }}
+	if err := sess.runStrategiesWithHash(w, plan.EvenPartition, sess.nextGlobalStrategies(), first); err != nil {
+		return err
+	}
+	if max, min := y.AsI64()[0], -y.AsI64()[1]; max != min {
+		return fmt.Errorf("%w: %s is not used by all peers", errStrategyHashMismatch, name)
 	}
-	return simpleHash
+	return nil
 }
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+var errShardSizeMismatch = errors.New("shard is registered with a different size")
+
+// shardMap assigns named tensors (parameters and optimizer states) to owner ranks for ZeRO-style sharded training.
+// Tensors are assigned to the least loaded rank in registration order, so all peers must register the same tensors
+// in the same order. A shardMap belongs to a Session and is reset when the cluster is resized.
+type shardMap struct {
+	sync.Mutex
+	owners map[string]int
+	sizes  map[string]int
+	loads  []int
+}
+
+func newShardMap(size int) *shardMap {
+	return &shardMap{
+		owners: make(map[string]int),
+		sizes:  make(map[string]int),
+		loads:  make([]int, size),
+	}
+}
+
+func (sm *shardMap) assign(name string, size int) (int, error) {
+	sm.Lock()
+	defer sm.Unlock()
+	if owner, ok := sm.owners[name]; ok {
+		if sm.sizes[name] != size {
+			return 0, errShardSizeMismatch
+		}
+		return owner, nil
+	}
+	owner := 0
+	for i, l := range sm.loads {
+		if l < sm.loads[owner] {
+			owner = i
+		}
+	}
+	sm.owners[name] = owner
+	sm.sizes[name] = size
+	sm.loads[owner] += size
+	return owner, nil
+}
+
+func (sm *shardMap) owner(name string) (int, bool) {
+	sm.Lock()
+	defer sm.Unlock()
+	owner, ok := sm.owners[name]
+	return owner, ok
+}
+
+// AssignShard registers a tensor of the given size in bytes and returns the rank owning it.
+func (sess *Session) AssignShard(name string, size int) (int, error) {
+	return sess.shards.assign(name, size)
+}
+
+// ShardOwner returns the rank owning the named tensor.
+func (sess *Session) ShardOwner(name string) (int, bool) {
+	return sess.shards.owner(name)
+}
+
+// ShardBroadcast broadcasts the parameter w.Name from its owner to all peers, typically before forward.
+func (sess *Session) ShardBroadcast(w kb.Workspace) error {
+	owner, ok := sess.shards.owner(w.Name)
+	if !ok {
+		return fmt.Errorf("shard %q is not assigned", w.Name)
+	}
+	bcastGraph := plan.GenStarBcastGraph(len(sess.peers), owner)
+	return sess.runGraphs(w, bcastGraph)
+}
+
+// ShardReduce reduces the gradient w.Name of all peers to its owner.
+// Only the RecvBuf of the owner holds the result.
+func (sess *Session) ShardReduce(w kb.Workspace) error {
+	if err := sess.checkOP(w, globalOPs); err != nil {
+		return err
+	}
+	owner, ok := sess.shards.owner(w.Name)
+	if !ok {
+		return fmt.Errorf("shard %q is not assigned", w.Name)
+	}
+	reduceGraph := plan.GenDefaultReduceGraph(plan.GenStarBcastGraph(len(sess.peers), owner))
+	return sess.runGraphs(w, reduceGraph)
+}
+
+// ShardReduceScatter reduces a group of gradients to their owners concurrently.
+func (sess *Session) ShardReduceScatter(ws []kb.Workspace) error {
+	return sess.runShardGroup(ws, sess.ShardReduce)
+}
+
+// ShardAllGather broadcasts a group of parameters from their owners concurrently.
+func (sess *Session) ShardAllGather(ws []kb.Workspace) error {
+	return sess.runShardGroup(ws, sess.ShardBroadcast)
+}
+
+func (sess *Session) runShardGroup(ws []kb.Workspace, f func(kb.Workspace) error) error {
+	errs := make([]error, len(ws))
+	var wg sync.WaitGroup
+	for i, w := range ws {
+		wg.Add(1)
+		go func(i int, w kb.Workspace) {
+			errs[i] = f(w)
+			wg.Done()
+		}(i, w)
+	}
+	wg.Wait()
+	return utils.MergeErrors(errs, "runShardGroup")
+}
@@ -0,0 +1,114 @@
+package session
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SignalCorrelation is the correlation between an external signal and the duration of the chunks of a strategy.
+// A strong correlation with a signal of compute activity suggests the slow collectives are caused by stalls of the peer,
+// while no correlation with any signal suggests interference in the network.
+type SignalCorrelation struct {
+	Signal      string  `json:"signal"`
+	Strategy    string  `json:"strategy"`
+	Samples     int64   `json:"samples"`
+	Correlation float64 `json:"correlation"` // Pearson correlation coefficient, 0 if there are not enough samples
+}
+
+type signalKey struct {
+	signal   string
+	strategy string
+}
+
+// pearson accumulates the sums of the Pearson correlation coefficient of x and y.
+type pearson struct {
+	n                     float64
+	sx, sy, sxx, syy, sxy float64
+}
+
+func (p *pearson) add(x, y float64) {
+	p.n++
+	p.sx += x
+	p.sy += y
+	p.sxx += x * x
+	p.syy += y * y
+	p.sxy += x * y
+}
+
+func (p *pearson) get() float64 {
+	cov := p.n*p.sxy - p.sx*p.sy
+	vx := p.n*p.sxx - p.sx*p.sx
+	vy := p.n*p.syy - p.sy*p.sy
+	if p.n < 2 || vx <= 0 || vy <= 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+// signalCorrelator pairs each timed chunk with the latest value of each external signal.
+type signalCorrelator struct {
+	sync.Mutex
+	values map[string]float64
+	pairs  map[signalKey]*pearson
+}
+
+func newSignalCorrelator() *signalCorrelator {
+	return &signalCorrelator{
+		values: make(map[string]float64),
+		pairs:  make(map[signalKey]*pearson),
+	}
+}
+
+func (c *signalCorrelator) report(name string, value float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[name] = value
+}
+
+func (c *signalCorrelator) observe(strategy string, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	for name, value := range c.values {
+		k := signalKey{signal: name, strategy: strategy}
+		p, ok := c.pairs[k]
+		if !ok {
+			p = &pearson{}
+			c.pairs[k] = p
+		}
+		p.add(value, d.Seconds())
+	}
+}
+
+func (c *signalCorrelator) correlations() []SignalCorrelation {
+	c.Lock()
+	defer c.Unlock()
+	var cs []SignalCorrelation
+	for k, p := range c.pairs {
+		cs = append(cs, SignalCorrelation{
+			Signal:      k.signal,
+			Strategy:    k.strategy,
+			Samples:     int64(p.n),
+			Correlation: p.get(),
+		})
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Signal != cs[j].Signal {
+			return cs[i].Signal < cs[j].Signal
+		}
+		return cs[i].Strategy < cs[j].Strategy
+	})
+	return cs
+}
+
+// ReportSignal sets the value of an external signal of this peer, e.g. the GPU utilization or the activity of co-located jobs.
+// The chunks timed for the stats of strategies are paired with the latest value of each signal, across steps.
+func (sess *Session) ReportSignal(name string, value float64) {
+	sess.stats.signals.report(name, value)
+}
+
+// SignalCorrelations returns the correlations between the signals reported by this peer and the durations of its chunks.
+func (sess *Session) SignalCorrelations() []SignalCorrelation {
+	return sess.stats.signals.correlations()
+}
@@ -0,0 +1,225 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// sizeClass is the value tuned for the collectives of messages up to maxBytes, e.g. their chunk size.
+type sizeClass struct {
+	maxBytes int // 0 for no limit
+	value    int
+}
+
+// sizeMeasure is the bytes of the collectives of an arm of a size class since the previous tuning, and their duration.
+type sizeMeasure struct {
+	bytes    int
+	duration time.Duration
+}
+
+// sizeGeneration is the values of the size classes used from a step on.
+type sizeGeneration struct {
+	from    int64
+	classes []sizeClass
+}
+
+// sizeTuner keeps a value for each message size class, which is tuned by the throughputs of the collectives of
+// the class, measured for each of its arms, e.g. without and with a codec. As hot swaps, a tuned value is used from
+// the next step of all peers, which they agree on, so that the collectives in flight keep the values they started with,
+// and all peers use the same values for the collectives of a step.
+type sizeTuner struct {
+	sync.Mutex
+	name     string           // of the agreements of the tuner
+	gens     []sizeGeneration // in the order of from, gens[0] is used for all steps before gens[1]
+	measures [][]sizeMeasure  // of each arm of each class
+	calls    int
+}
+
+func newSizeTuner(name string, arms int, classes []sizeClass) *sizeTuner {
+	t := &sizeTuner{name: name, gens: []sizeGeneration{{classes: classes}}}
+	t.measures = make([][]sizeMeasure, len(classes))
+	for i := range t.measures {
+		t.measures[i] = make([]sizeMeasure, arms)
+	}
+	return t
+}
+
+// parseSizeClasses parses a comma separated list of <max bytes>:<value> of what, in increasing order of sizes,
+// the last one can be *:<value> for no limit.
+func parseSizeClasses(val, what string, parseValue func(string) (int, bool)) ([]sizeClass, error) {
+	var classes []sizeClass
+	for _, spec := range strings.Split(val, ",") {
+		kv := strings.SplitN(spec, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s: %q", what, spec)
+		}
+		if n := len(classes); n > 0 && classes[n-1].maxBytes == 0 {
+			return nil, fmt.Errorf("%s after *: %q", what, spec)
+		}
+		var c sizeClass
+		if kv[0] != `*` {
+			n, err := strconv.Atoi(kv[0])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid message size of %s: %q", what, spec)
+			}
+			if k := len(classes); k > 0 && n <= classes[k-1].maxBytes {
+				return nil, fmt.Errorf("message sizes of %ss must increase: %q", what, spec)
+			}
+			c.maxBytes = n
+		}
+		v, ok := parseValue(kv[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: %q", what, spec)
+		}
+		c.value = v
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+func formatSizeClasses(classes []sizeClass, formatValue func(int) string) string {
+	var specs []string
+	for _, c := range classes {
+		size := `*`
+		if c.maxBytes > 0 {
+			size = strconv.Itoa(c.maxBytes)
+		}
+		specs = append(specs, size+":"+formatValue(c.value))
+	}
+	return strings.Join(specs, ",")
+}
+
+// classOf returns the index of the class of messages of n bytes, or -1 if there is none.
+func classOf(classes []sizeClass, n int) int {
+	for i, c := range classes {
+		if c.maxBytes == 0 || n <= c.maxBytes {
+			return i
+		}
+	}
+	return -1
+}
+
+// at returns the classes of the collectives of the given step.
+func (t *sizeTuner) at(step int64) []sizeClass {
+	t.Lock()
+	defer t.Unlock()
+	for i := len(t.gens) - 1; i > 0; i-- {
+		if t.gens[i].from <= step {
+			return t.gens[i].classes
+		}
+	}
+	return t.gens[0].classes
+}
+
+// latest returns a copy of the classes after all committed changes.
+func (t *sizeTuner) latest() []sizeClass {
+	t.Lock()
+	defer t.Unlock()
+	return append([]sizeClass(nil), t.gens[len(t.gens)-1].classes...)
+}
+
+// commit uses classes from the given step on, in place of the changes committed at the same or later steps.
+func (t *sizeTuner) commit(from int64, classes []sizeClass) {
+	t.Lock()
+	defer t.Unlock()
+	i := len(t.gens)
+	for i > 1 && t.gens[i-1].from >= from {
+		i--
+	}
+	t.gens = append(t.gens[:i], sizeGeneration{from: from, classes: classes})
+	if n := len(t.gens); n > hotSwapHistory {
+		t.gens = t.gens[n-hotSwapHistory:]
+	}
+}
+
+// value returns the value of the class of messages of n bytes at the given step, or def if there is none.
+func (t *sizeTuner) value(step int64, n, def int) int {
+	classes := t.at(step)
+	if i := classOf(classes, n); i >= 0 {
+		return classes[i].value
+	}
+	return def
+}
+
+// record adds a collective of messages of n bytes on arm, which took d.
+func (t *sizeTuner) record(n, arm int, d time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	if i := classOf(t.gens[0].classes, n); i >= 0 { // the bounds of the classes never change
+		m := &t.measures[i][arm]
+		m.bytes += n
+		m.duration += d
+	}
+}
+
+// take returns and resets the measures of all arms of all classes, every interval calls.
+func (t *sizeTuner) take(interval int) ([][]sizeMeasure, bool) {
+	t.Lock()
+	defer t.Unlock()
+	if t.calls++; t.calls%interval != 0 {
+		return nil, false
+	}
+	ms := t.measures
+	t.measures = make([][]sizeMeasure, len(ms))
+	for i := range ms {
+		t.measures[i] = make([]sizeMeasure, len(ms[i]))
+	}
+	return ms, true
+}
+
+// tuneSizes updates the values of t every interval calls, by the throughputs of each arm of each class since
+// the previous tuning, in bytes per second, 0 for an idle arm. Peers agree on the throughputs by the max durations
+// and the min bytes, so that update changes the same values on all peers, which returns true if it changed any.
+// The changed values are used from the next step of all peers. It must be called by all peers, at the same point of
+// their collectives, e.g. at step boundaries, and it returns true if the values changed.
+func (sess *Session) tuneSizes(t *sizeTuner, interval int, update func(classes []sizeClass, throughputs [][]float64) bool) (bool, error) {
+	ms, ok := t.take(interval)
+	if !ok {
+		return false, nil
+	}
+	var n int
+	for _, arms := range ms {
+		n += len(arms)
+	}
+	x := kb.NewVector(2*n, kb.F64)
+	y := kb.NewVector(2*n, kb.F64)
+	var k int
+	for _, arms := range ms {
+		for _, m := range arms {
+			x.AsF64()[k] = m.duration.Seconds()
+			x.AsF64()[n+k] = -float64(m.bytes) // the max of the negated bytes is the min
+			k++
+		}
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::" + t.name}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return false, err
+	}
+	throughputs := make([][]float64, len(ms))
+	k = 0
+	for i, arms := range ms {
+		throughputs[i] = make([]float64, len(arms))
+		for j := range arms {
+			if d := y.AsF64()[k]; d > 0 {
+				throughputs[i][j] = -y.AsF64()[n+k] / d
+			}
+			k++
+		}
+	}
+	classes := t.latest()
+	if !update(classes, throughputs) {
+		return false, nil
+	}
+	from, err := sess.agreeNextStep([]byte(fmt.Sprint(classes)), "kungfu::"+t.name+"-fence")
+	if err != nil {
+		return false, err
+	}
+	t.commit(from, classes)
+	return true, nil
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_sizeTunerGenerations(t *testing.T) {
+	st := newSizeTuner("test", 1, []sizeClass{{maxBytes: 10, value: 1}, {value: 2}})
+	st.commit(5, []sizeClass{{maxBytes: 10, value: 3}, {value: 4}})
+	if v := st.value(4, 10, 0); v != 1 {
+		t.Errorf("value at a step before the change: %d, want 1", v)
+	}
+	if v := st.value(5, 11, 0); v != 4 {
+		t.Errorf("value at the step of the change: %d, want 4", v)
+	}
+	st.commit(5, []sizeClass{{maxBytes: 10, value: 5}, {value: 6}}) // replaces the change at the same step
+	if v := st.value(7, 1, 0); v != 5 || len(st.gens) != 2 {
+		t.Errorf("value after replacing the change: %d of %d generations, want 5 of 2", v, len(st.gens))
+	}
+	if got := st.latest(); got[1].value != 6 {
+		t.Errorf("latest %v", got)
+	}
+	for i := 0; i < 2*hotSwapHistory; i++ {
+		st.commit(int64(10+i), st.latest())
+	}
+	if len(st.gens) != hotSwapHistory {
+		t.Errorf("%d generations kept, want %d", len(st.gens), hotSwapHistory)
+	}
+}
+
+func Test_sizeTunerTake(t *testing.T) {
+	st := newSizeTuner("test", 2, []sizeClass{{maxBytes: 10, value: 1}, {value: 2}})
+	st.record(10, 1, time.Second)
+	st.record(20, 0, time.Second)
+	if _, ok := st.take(2); ok {
+		t.Errorf("taken before the interval")
+	}
+	ms, ok := st.take(2)
+	if !ok || ms[0][1].bytes != 10 || ms[1][0].bytes != 20 || ms[0][0].bytes != 0 {
+		t.Fatalf("taken %v, %v", ms, ok)
+	}
+	if ms, _ = st.take(1); ms[0][1].bytes != 0 {
+		t.Errorf("measures are not reset: %v", ms)
+	}
+}
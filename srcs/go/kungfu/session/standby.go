@@ -0,0 +1,124 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const standbyPrefix = `STANDBY:`
+
+// genStandbyStrategyList generates the strategies listed in config.StandbyStrategies as standby strategies.
+func genStandbyStrategyList(peers plan.PeerList) strategyList {
+	var sl strategyList
+	for _, name := range strings.Split(config.StandbyStrategies, ",") {
+		if len(name) == 0 {
+			continue
+		}
+		s, err := kb.ParseStrategy(name)
+		if err != nil {
+			log.Errorf("ignored standby strategy %q: %v", name, err)
+			continue
+		}
+		if *s == kb.Auto {
+			*s = autoSelect(peers)
+		}
+		for _, st := range named(standbyPrefix+s.String(), genGlobalStrategyList(peers, *s)) {
+			st.standby = true
+			sl = append(sl, st)
+		}
+	}
+	return sl
+}
+
+// standbys returns the standby strategies of sl.
+func (sl strategyList) standbys() strategyList {
+	var ss strategyList
+	for _, s := range sl {
+		if s.standby {
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// promote makes the first available standby strategy active, and returns its name.
+func (sl strategyList) promote() (string, bool) {
+	for i := range sl {
+		if sl[i].standby && !sl[i].suspended {
+			sl[i].standby, sl[i].promoted = false, true
+			return sl[i].name, true
+		}
+	}
+	return "", false
+}
+
+// promoted returns the names of the promoted strategies of sl.
+func (sl strategyList) promoted() []string {
+	var names []string
+	for _, s := range sl {
+		if s.promoted {
+			names = append(names, s.name)
+		}
+	}
+	return names
+}
+
+// demote returns the promoted strategy of the longest mean duration of chunks to the standby set, and returns its name.
+// Of the strategies of the same duration, e.g. if none was measured, the first one is returned.
+func (sl strategyList) demote(durations map[string]time.Duration) (string, bool) {
+	worst := -1
+	for i := range sl {
+		if sl[i].promoted && (worst < 0 || durations[sl[i].name] > durations[sl[worst].name]) {
+			worst = i
+		}
+	}
+	if worst < 0 {
+		return "", false
+	}
+	sl[worst].standby, sl[worst].promoted = true, false
+	return sl[worst].name, true
+}
+
+// agreeDurations returns the max of the mean durations of chunks of the named strategies over all peers,
+// so that all peers demote the same strategy. It must be called by all peers.
+func (sess *Session) agreeDurations(names []string) (map[string]time.Duration, error) {
+	x := kb.NewVector(len(names), kb.I64)
+	y := kb.NewVector(len(names), kb.I64)
+	for i, name := range names {
+		_, d := sess.stats.get(name)
+		x.AsI64()[i] = int64(d)
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::demote"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return nil, err
+	}
+	durations := make(map[string]time.Duration, len(names))
+	for i, name := range names {
+		durations[name] = time.Duration(y.AsI64()[i])
+	}
+	return durations, nil
+}
+
+// WarmStandby runs a small AllReduce on each standby strategy, so that their connections are established
+// and their graphs are validated before they are promoted. It must be called by all peers.
+func (sess *Session) WarmStandby() error {
+	for _, s := range sess.swap.latest().standbys() {
+		x := kb.NewVector(1, kb.I32)
+		y := kb.NewVector(1, kb.I32)
+		x.AsI32()[0] = 1
+		w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "kungfu::standby:" + s.name}
+		if err := sess.runGraphs(w, s.graphs()...); err != nil {
+			return err
+		}
+		if n := int(y.AsI32()[0]); n != len(sess.peers) {
+			return fmt.Errorf("%v: standby strategy %s reduced %d of %d peers", errInvalidStrategyGraph, s.name, n, len(sess.peers))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/log"
+)
+
+var errInvalidStatSampling = errors.New("invalid stat sampling")
+
+// A statSampler selects the chunks whose durations update the stats of strategies,
+// so that the stats cost little at high collective rates.
+// A sampled chunk is weighted by the inverse of the sampling rate, so that the estimated counts and totals are unbiased.
+type statSampler struct {
+	every uint64  // sample every n-th chunk if > 0
+	p     float64 // otherwise sample a chunk with probability p
+	n     uint64  // chunks seen, updated atomically
+}
+
+var sampleAll = &statSampler{every: 1}
+
+// parseStatSampling parses an integer N as every N-th chunk, and a decimal in (0, 1] as the probability of each chunk.
+func parseStatSampling(val string) (*statSampler, error) {
+	if strings.Contains(val, ".") {
+		p, err := strconv.ParseFloat(val, 64)
+		if err != nil || p <= 0 || p > 1 {
+			return nil, fmt.Errorf("%v: %q is not a probability in (0, 1]", errInvalidStatSampling, val)
+		}
+		return &statSampler{p: p}, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("%v: %q is not a positive integer", errInvalidStatSampling, val)
+	}
+	return &statSampler{every: uint64(n)}, nil
+}
+
+// sample returns the weight of the next chunk if it's sampled.
+func (s *statSampler) sample() (float64, bool) {
+	if s.every > 0 {
+		if atomic.AddUint64(&s.n, 1)%s.every != 0 {
+			return 0, false
+		}
+		return float64(s.every), true
+	}
+	if rand.Float64() >= s.p {
+		return 0, false
+	}
+	return 1 / s.p, true
+}
+
+func (s *statSampler) String() string {
+	if s.every > 0 {
+		return strconv.FormatUint(s.every, 10)
+	}
+	return strconv.FormatFloat(s.p, 'f', -1, 64)
+}
+
+// strategyStat estimates the number of chunks run on a strategy, their messages, bytes and total duration from the sampled chunks.
+type strategyStat struct {
+	chunks   float64
+	messages float64
+	bytes    float64
+	duration float64 // nanoseconds
+	samples  int64
+	ewma     float64 // nanoseconds, of the sampled chunks
+	errors   int64   // of all chunks
+
+	window      int64   // sampled chunks since the last interval of the forecaster
+	windowTotal float64 // nanoseconds
+}
+
+type strategyStats struct {
+	sync.Mutex
+	sampler *statSampler
+	stats   map[string]*strategyStat
+	signals *signalCorrelator
+	decay   float64 // of the moving average of recent durations, 0 for the mean of all durations
+
+	forecasts map[string]*forecaster // kept when the stats of a strategy are invalidated, to predict recurring interference
+}
+
+func newStrategyStats(sampler *statSampler) *strategyStats {
+	return &strategyStats{
+		sampler:   sampler,
+		stats:     make(map[string]*strategyStat),
+		signals:   newSignalCorrelator(),
+		forecasts: make(map[string]*forecaster),
+	}
+}
+
+func (s *strategyStats) getSampler() *statSampler {
+	s.Lock()
+	defer s.Unlock()
+	return s.sampler
+}
+
+// timeChunk runs f, which sends the given messages and bytes of a chunk,
+// and records its duration on strategy name if the chunk is sampled, or its error.
+func (s *strategyStats) timeChunk(name string, messages, bytes int, f func() error) error {
+	weight, ok := s.getSampler().sample()
+	if !ok {
+		err := f()
+		if err != nil {
+			s.addError(name)
+		}
+		return err
+	}
+	t0 := time.Now()
+	err := f()
+	d := time.Since(t0)
+	if err != nil {
+		s.addError(name)
+		return err
+	}
+	s.add(name, weight, messages, bytes, d)
+	s.signals.observe(name, d)
+	return nil
+}
+
+func (s *strategyStats) stat(name string) *strategyStat {
+	st, ok := s.stats[name]
+	if !ok {
+		st = &strategyStat{}
+		s.stats[name] = st
+	}
+	return st
+}
+
+func (s *strategyStats) addError(name string) {
+	s.Lock()
+	defer s.Unlock()
+	s.stat(name).errors++
+}
+
+func (s *strategyStats) add(name string, weight float64, messages, bytes int, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	st := s.stat(name)
+	st.chunks += weight
+	st.messages += weight * float64(messages)
+	st.bytes += weight * float64(bytes)
+	st.duration += weight * float64(d)
+	if st.samples == 0 {
+		st.ewma = float64(d)
+	} else {
+		st.ewma += s.decay * (float64(d) - st.ewma)
+	}
+	st.samples++
+	st.window++
+	st.windowTotal += float64(d)
+}
+
+// invalidate drops the stats of strategy name, e.g. after the environment changed.
+func (s *strategyStats) invalidate(name string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.stats, name)
+}
+
+// reset drops the stats and forecasts of all strategies.
+func (s *strategyStats) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.stats = make(map[string]*strategyStat)
+	s.forecasts = make(map[string]*forecaster)
+}
+
+// get returns the estimated number of chunks of strategy name and their mean duration.
+func (s *strategyStats) get(name string) (int64, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.stats[name]
+	if !ok || st.chunks == 0 {
+		return 0, 0
+	}
+	return int64(st.chunks + 0.5), time.Duration(st.duration / st.chunks)
+}
+
+// recent returns the number of sampled chunks of strategy name, and the moving average of their durations,
+// or their mean if decay is 0.
+func (s *strategyStats) recent(name string) (int64, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.stats[name]
+	if !ok || st.chunks == 0 {
+		return 0, 0
+	}
+	if s.decay == 0 {
+		return st.samples, time.Duration(st.duration / st.chunks)
+	}
+	return st.samples, time.Duration(st.ewma)
+}
+
+// StrategyStatSnapshot is the communication of a strategy of a peer, estimated from the sampled chunks,
+// e.g. for monitors to balance strategies by their throughput rather than the latency of chunks.
+type StrategyStatSnapshot struct {
+	Name      string        `json:"name"`
+	Chunks    int64         `json:"chunks"`
+	Messages  int64         `json:"messages"`  // sent by the peer
+	Bytes     int64         `json:"bytes"`     // sent by the peer, before encoding by the codec
+	Duration  time.Duration `json:"duration"`  // total of the chunks
+	Bandwidth float64       `json:"bandwidth"` // bytes per second of the chunks
+	Errors    int64         `json:"errors"`
+}
+
+func (s *strategyStats) snapshot(name string) StrategyStatSnapshot {
+	s.Lock()
+	defer s.Unlock()
+	ss := StrategyStatSnapshot{Name: name}
+	st, ok := s.stats[name]
+	if !ok {
+		return ss
+	}
+	ss.Chunks = int64(st.chunks + 0.5)
+	ss.Messages = int64(st.messages + 0.5)
+	ss.Bytes = int64(st.bytes + 0.5)
+	ss.Duration = time.Duration(st.duration)
+	if st.duration > 0 {
+		ss.Bandwidth = st.bytes / time.Duration(st.duration).Seconds()
+	}
+	ss.Errors = st.errors
+	return ss
+}
+
+// StrategyStats returns the stats of the global strategies of the peer, in the order of GlobalStrategies.
+func (sess *Session) StrategyStats() []StrategyStatSnapshot {
+	var sss []StrategyStatSnapshot
+	for _, s := range sess.nextGlobalStrategies() {
+		sss = append(sss, sess.stats.snapshot(s.name))
+	}
+	return sss
+}
+
+func (s *strategyStats) setDecay(decay float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.decay = decay
+}
+
+// SetStatSampling changes the chunks timed for the stats of strategies in this session:
+// an integer N times every N-th chunk, and a decimal p in (0, 1] times each chunk with probability p.
+func (sess *Session) SetStatSampling(val string) error {
+	sampler, err := parseStatSampling(val)
+	if err != nil {
+		return err
+	}
+	sess.stats.Lock()
+	defer sess.stats.Unlock()
+	sess.stats.sampler = sampler
+	return nil
+}
+
+// StatSampling returns the sampling of chunks for the stats of strategies, as accepted by SetStatSampling.
+func (sess *Session) StatSampling() string {
+	return sess.stats.getSampler().String()
+}
+
+func getStatSampler() *statSampler {
+	sampler, err := parseStatSampling(config.StatSampling)
+	if err != nil {
+		log.Errorf("timing all chunks: %v", err)
+		return sampleAll
+	}
+	return sampler
+}
@@ -0,0 +1,96 @@
+package session
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_parseStatSampling(t *testing.T) {
+	for _, val := range []string{`1`, `16`, `0.5`, `1.0`} {
+		if _, err := parseStatSampling(val); err != nil {
+			t.Errorf("parseStatSampling(%q): %v", val, err)
+		}
+	}
+	for _, val := range []string{``, `0`, `-1`, `0.0`, `1.5`, `x`} {
+		if _, err := parseStatSampling(val); err == nil {
+			t.Errorf("parseStatSampling(%q) should fail", val)
+		}
+	}
+}
+
+func Test_strategyStats(t *testing.T) {
+	const n = 10000
+	for _, val := range []string{`1`, `7`, `0.25`} {
+		sampler, err := parseStatSampling(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := newStrategyStats(sampler)
+		for i := 0; i < n; i++ {
+			if weight, ok := s.getSampler().sample(); ok {
+				s.add("s", weight, 2, 1000, time.Millisecond)
+			}
+		}
+		chunks, avg := s.get("s")
+		if math.Abs(float64(chunks-n)) > 0.05*n {
+			t.Errorf("sampling %s: estimated %d chunks, want ~%d", val, chunks, n)
+		}
+		if avg != time.Millisecond {
+			t.Errorf("sampling %s: mean duration %s, want %s", val, avg, time.Millisecond)
+		}
+		ss := s.snapshot("s")
+		if ss.Messages != 2*ss.Chunks || ss.Bytes != 1000*ss.Chunks {
+			t.Errorf("sampling %s: %d messages and %d bytes of %d chunks", val, ss.Messages, ss.Bytes, ss.Chunks)
+		}
+		if math.Abs(ss.Bandwidth-1e6) > 1 {
+			t.Errorf("sampling %s: bandwidth %g, want %g", val, ss.Bandwidth, 1e6)
+		}
+	}
+}
+
+func Test_strategyStatsErrors(t *testing.T) {
+	s := newStrategyStats(&statSampler{every: 2})
+	for i := 0; i < 4; i++ {
+		s.timeChunk("s", 1, 8, func() error { return errors.New("failed") })
+	}
+	if ss := s.snapshot("s"); ss.Errors != 4 || ss.Chunks != 0 {
+		t.Errorf("%d errors of %d chunks, want 4 of 0", ss.Errors, ss.Chunks)
+	}
+}
+
+func Test_strategyStatsRecent(t *testing.T) {
+	s := newStrategyStats(sampleAll)
+	s.setDecay(0.5)
+	for _, d := range []time.Duration{time.Millisecond, time.Millisecond, 5 * time.Millisecond} {
+		s.add("s", 1, 1, 0, d)
+	}
+	if samples, avg := s.recent("s"); samples != 3 || avg != 3*time.Millisecond {
+		t.Errorf("recent: %d samples of %s, want 3 of %s", samples, avg, 3*time.Millisecond)
+	}
+	s.setDecay(0)
+	if _, avg := s.recent("s"); avg != 7*time.Millisecond/3 {
+		t.Errorf("recent without decay: %s, want the mean %s", avg, 7*time.Millisecond/3)
+	}
+}
+
+func Test_signalCorrelator(t *testing.T) {
+	c := newSignalCorrelator()
+	c.observe("s", time.Millisecond) // no signal yet
+	for i := 1; i <= 10; i++ {
+		c.report("busy", float64(i))
+		c.report("idle", float64(i%2))
+		c.observe("s", time.Duration(i)*time.Millisecond)
+	}
+	cs := c.correlations()
+	if len(cs) != 2 {
+		t.Fatalf("%d correlations, want 2", len(cs))
+	}
+	if cs[0].Signal != "busy" || cs[0].Samples != 10 || math.Abs(cs[0].Correlation-1) > 1e-9 {
+		t.Errorf("unexpected correlation: %+v", cs[0])
+	}
+	if math.Abs(cs[1].Correlation) > 0.5 {
+		t.Errorf("unexpected correlation: %+v", cs[1])
+	}
+}
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+)
+
+var errNoStep = errors.New("EndStep without BeginStep")
+
+// StepSummary summarizes the collectives of this peer between BeginStep and EndStep.
+type StepSummary struct {
+	Step             int64         `json:"step"`
+	Collectives      int           `json:"collectives"`
+	Bytes            int64         `json:"bytes"`
+	Duration         time.Duration `json:"duration"`          // from BeginStep to EndStep
+	CriticalPath     time.Duration `json:"critical_path"`     // time with any collective in flight, which training waits for if not overlapped
+	FusionEfficiency float64       `json:"fusion_efficiency"` // mean bytes of a collective over the fusion size (or chunk size if fusion is disabled), at most 1
+}
+
+// stepProfiler accumulates the collectives of the current step, it does nothing outside steps.
+type stepProfiler struct {
+	sync.Mutex
+	active    bool
+	begin     time.Time
+	summary   StepSummary
+	inflight  int
+	busySince time.Time
+	last      *StepSummary
+}
+
+func (p *stepProfiler) beginStep(step int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.active = true
+	p.begin = time.Now()
+	p.summary = StepSummary{Step: step}
+	if p.inflight > 0 {
+		p.busySince = p.begin // count collectives in flight from the beginning of the step
+	}
+}
+
+func (p *stepProfiler) endStep() (*StepSummary, error) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.active {
+		return nil, errNoStep
+	}
+	now := time.Now()
+	if p.inflight > 0 {
+		p.summary.CriticalPath += now.Sub(p.busySince)
+	}
+	p.active = false
+	s := p.summary
+	s.Duration = now.Sub(p.begin)
+	s.FusionEfficiency = fusionEfficiency(s.Collectives, s.Bytes)
+	p.last = &s
+	return &s, nil
+}
+
+func fusionEfficiency(collectives int, bytes int64) float64 {
+	if collectives == 0 {
+		return 0
+	}
+	target := config.FusionSize
+	if target <= 0 {
+		target = config.ChunkSize
+	}
+	e := float64(bytes) / float64(collectives) / float64(target)
+	if e > 1 {
+		return 1
+	}
+	return e
+}
+
+// start records a collective of n bytes, and returns the function to call when it finishes.
+func (p *stepProfiler) start(n int) func() {
+	p.Lock()
+	defer p.Unlock()
+	if p.inflight == 0 {
+		p.busySince = time.Now()
+	}
+	p.inflight++
+	if p.active {
+		p.summary.Collectives++
+		p.summary.Bytes += int64(n)
+	}
+	return p.finish
+}
+
+func (p *stepProfiler) finish() {
+	p.Lock()
+	defer p.Unlock()
+	p.inflight--
+	if p.inflight == 0 && p.active {
+		p.summary.CriticalPath += time.Since(p.busySince)
+	}
+}
+
+// track records a collective of kind on w in the current step, and returns the function to call when it finishes.
+func (sess *Session) track(kind string, w kb.Workspace) func() {
+	sess.collectives.add(kind, len(w.SendBuf.Data))
+	traceCollectiveBegin(w.Name, len(w.SendBuf.Data))
+	done := sess.profiler.start(len(w.SendBuf.Data))
+	return func() {
+		done()
+		traceCollectiveEnd(w.Name)
+	}
+}
+
+// BeginStep starts grouping the collectives of this peer into a step, until EndStep.
+func (sess *Session) BeginStep() {
+	sess.profiler.beginStep(sess.Step())
+}
+
+// EndStep returns the summary of the collectives since BeginStep.
+func (sess *Session) EndStep() (*StepSummary, error) {
+	return sess.profiler.endStep()
+}
+
+// LastStepSummary returns the summary of the last step ended, or nil.
+func (sess *Session) LastStepSummary() *StepSummary {
+	sess.profiler.Lock()
+	defer sess.profiler.Unlock()
+	return sess.profiler.last
+}
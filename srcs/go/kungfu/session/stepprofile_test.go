@@ -0,0 +1,34 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_stepProfiler(t *testing.T) {
+	var p stepProfiler
+	if _, err := p.endStep(); err == nil {
+		t.Errorf("EndStep without BeginStep should fail")
+	}
+	p.start(100)() // outside of steps
+	p.beginStep(1)
+	done1 := p.start(1000)
+	done2 := p.start(3000)
+	time.Sleep(10 * time.Millisecond)
+	done1()
+	done2()
+	time.Sleep(10 * time.Millisecond)
+	s, err := p.endStep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Step != 1 || s.Collectives != 2 || s.Bytes != 4000 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+	if s.CriticalPath < 10*time.Millisecond || s.CriticalPath >= s.Duration {
+		t.Errorf("critical path %s should cover the overlapping collectives only, step took %s", s.CriticalPath, s.Duration)
+	}
+	if s.FusionEfficiency <= 0 || s.FusionEfficiency > 1 {
+		t.Errorf("invalid fusion efficiency %f", s.FusionEfficiency)
+	}
+}
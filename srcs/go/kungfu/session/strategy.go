@@ -2,8 +2,10 @@ package session
 
 import (
 	"bytes"
+	"fmt"
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/plan/graph"
 	"github.com/lsds/KungFu/srcs/go/plan/subgraph"
@@ -18,15 +20,60 @@ func (sl strategyList) choose(i int) strategy {
 	return sl[i%len(sl)]
 }
 
+// active returns the strategies that are neither suspended nor standby,
+// excluding the broken ones unless all of them are broken.
+func (sl strategyList) active() strategyList {
+	var al, unbroken strategyList
+	for _, s := range sl {
+		if !s.suspended && !s.standby {
+			al = append(al, s)
+			if !s.broken {
+				unbroken = append(unbroken, s)
+			}
+		}
+	}
+	if len(unbroken) > 0 {
+		return unbroken
+	}
+	return al
+}
+
 func (sl strategyList) digestBytes() []byte {
 	b := &bytes.Buffer{}
 	for _, s := range sl {
+		b.WriteString(s.name)
+		b.WriteByte(boolToByte(s.suspended))
+		b.WriteByte(boolToByte(s.standby))
+		b.WriteByte(boolToByte(s.promoted))
+		b.WriteByte(boolToByte(s.broken))
 		b.Write(s.reduceGraph.DigestBytes())
 		b.Write(s.bcastGraph.DigestBytes())
+		for _, g := range s.stages {
+			b.Write(g.DigestBytes())
+		}
 	}
 	return b.Bytes()
 }
 
+// named sets the names of strategies to prefix, or prefix/i if there are more than one.
+func named(prefix string, sl strategyList) strategyList {
+	for i := range sl {
+		if len(sl) == 1 {
+			sl[i].name = prefix
+		} else {
+			sl[i].name = fmt.Sprintf("%s/%d", prefix, i)
+		}
+	}
+	return sl
+}
+
+func boolToByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
 var partitionStrategies = map[kb.Strategy]partitionStrategy{
 	kb.Star:                createStarStrategies,
 	kb.Clique:              createCliqueStrategies,
@@ -35,6 +82,7 @@ var partitionStrategies = map[kb.Strategy]partitionStrategy{
 	kb.BinaryTree:          createBinaryTreeStrategies,
 	kb.BinaryTreeStar:      createBinaryTreeStarStrategies,
 	kb.MultiBinaryTreeStar: createMultiBinaryTreeStarStrategies,
+	kb.MultiRing:           createMultiRingStrategies,
 }
 
 func simpleStrategy(bcastGraph *graph.Graph) strategy {
@@ -95,6 +143,19 @@ func createRingStrategies(peers plan.PeerList) strategyList {
 	return sl
 }
 
+// createMultiRingStrategies creates edge-disjoint rings, so that the chunks of different rings run in parallel.
+func createMultiRingStrategies(peers plan.PeerList) strategyList {
+	var sl strategyList
+	reduceGraphs, bcastGraphs := plan.GenEdgeDisjointRingPairs(len(peers))
+	for i := range reduceGraphs {
+		sl = append(sl, strategy{
+			reduceGraph: reduceGraphs[i],
+			bcastGraph:  bcastGraphs[i],
+		})
+	}
+	return sl
+}
+
 func autoSelect(peers plan.PeerList) kb.Strategy {
 	m := make(map[uint32]int)
 	for _, p := range peers {
@@ -111,13 +172,33 @@ func genLocalStrategyList(peers plan.PeerList) strategyList {
 	bcastGraph, m, ok := graph.FromForestArray(parents)
 	assert.True(ok)
 	assert.True(m == len(masters))
-	return strategyList{simpleStrategy(bcastGraph)}
+	return named("LOCAL", strategyList{simpleStrategy(bcastGraph)})
 }
 
 func genGlobalStrategyList(peers plan.PeerList, strategyName kb.Strategy) strategyList {
+	if config.EnableHostProxy && peers.HostCount() > 1 {
+		return genHostProxyStrategyList(peers, strategyName)
+	}
 	return partitionStrategies[strategyName](peers)
 }
 
+// genHostProxyStrategyList makes the first peer of each host the proxy of the others:
+// peers reduce to their proxy, proxies all reduce across hosts, and proxies broadcast to their peers.
+// Only proxies talk across hosts, so that there are O(hosts^2) rather than O(peers^2) connections between hosts.
+func genHostProxyStrategyList(peers plan.PeerList, strategyName kb.Strategy) strategyList {
+	local := genLocalStrategyList(peers)[0]
+	var sl strategyList
+	for _, s := range createCrossStrategies(peers, strategyName) {
+		bcastGraph := plan.MergeGraphs(s.bcastGraph, local.bcastGraph)
+		sl = append(sl, strategy{
+			reduceGraph: plan.GenDefaultReduceGraph(bcastGraph),
+			bcastGraph:  bcastGraph,
+			stages:      []*graph.Graph{local.reduceGraph, s.reduceGraph, s.bcastGraph, local.bcastGraph},
+		})
+	}
+	return sl
+}
+
 func createCrossRingStrategies(peers plan.PeerList) strategyList {
 	n := len(peers)
 	masters, _ := peers.PartitionByHost()
@@ -138,9 +219,16 @@ func createCrossBinaryTreeStrategies(peers plan.PeerList) strategyList {
 	return strategyList{simpleStrategy(bcastGraph)}
 }
 
-func genCrossStrategyList(peers plan.PeerList, strategyName kb.Strategy) strategyList {
+func createCrossStrategies(peers plan.PeerList, strategyName kb.Strategy) strategyList {
 	if strategyName == kb.Ring {
 		return createCrossRingStrategies(peers)
 	}
 	return createCrossBinaryTreeStrategies(peers)
 }
+
+func genCrossStrategyList(peers plan.PeerList, strategyName kb.Strategy) strategyList {
+	if strategyName == kb.Ring {
+		return named("CROSS_RING", createCrossStrategies(peers, strategyName))
+	}
+	return named("CROSS_BINARY_TREE", createCrossStrategies(peers, strategyName))
+}
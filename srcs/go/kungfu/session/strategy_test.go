@@ -0,0 +1,178 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_genHostProxyStrategyList(t *testing.T) {
+	hl, err := plan.ParseHostList(`192.168.1.1:3,192.168.1.2:3,192.168.1.3:2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := hl.GenPeerList(8, plan.DefaultPortRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masters, _ := pl.PartitionByHost()
+	isMaster := make(map[int]bool)
+	for _, m := range masters {
+		isMaster[m] = true
+	}
+	for _, name := range []kb.Strategy{kb.Ring, kb.BinaryTreeStar} {
+		sl := genHostProxyStrategyList(pl, name)
+		if name == kb.Ring && len(sl) != len(masters) {
+			t.Errorf("%s: %d strategies, want %d", name, len(sl), len(masters))
+		}
+		for _, s := range sl {
+			for _, g := range append(s.graphs(), s.reduceGraph, s.bcastGraph) {
+				for i := range pl {
+					for _, j := range g.Nexts(i) {
+						if pl[i].IPv4 != pl[j].IPv4 && !(isMaster[i] && isMaster[j]) {
+							t.Errorf("%s: edge %d -> %d across hosts between non-masters", name, i, j)
+						}
+					}
+				}
+			}
+			root := -1
+			for _, m := range masters {
+				if len(s.bcastGraph.Prevs(m)) == 0 {
+					root = m
+				}
+			}
+			if n := countReachable(s.bcastGraph.Nexts, root); n != len(pl) {
+				t.Errorf("%s: broadcast reaches %d peers, want %d", name, n, len(pl))
+			}
+		}
+	}
+}
+
+func countReachable(nexts func(int) []int, root int) int {
+	if root < 0 {
+		return 0
+	}
+	visited := map[int]bool{root: true}
+	q := []int{root}
+	for len(q) > 0 {
+		i := q[0]
+		q = q[1:]
+		for _, j := range nexts(i) {
+			if !visited[j] {
+				visited[j] = true
+				q = append(q, j)
+			}
+		}
+	}
+	return len(visited)
+}
+
+func Test_promoteStandby(t *testing.T) {
+	sl := strategyList{
+		{name: "A"},
+		{name: "B", standby: true, suspended: true},
+		{name: "C", standby: true},
+	}
+	sl[0].suspended = true
+	if name, ok := sl.promote(); !ok || name != "C" {
+		t.Errorf("promoted %q, want C", name)
+	}
+	if al := sl.active(); len(al) != 1 || al[0].name != "C" {
+		t.Errorf("unexpected active strategies %v", al)
+	}
+	if _, ok := sl.promote(); ok {
+		t.Errorf("no standby strategy should be available")
+	}
+	sl[0].suspended = false
+	if name, ok := sl.demote(nil); !ok || name != "C" {
+		t.Errorf("demoted %q, want C", name)
+	}
+	if al := sl.active(); len(al) != 1 || al[0].name != "A" {
+		t.Errorf("unexpected active strategies %v", al)
+	}
+	if ss := sl.standbys(); len(ss) != 2 {
+		t.Errorf("%d standby strategies, want 2", len(ss))
+	}
+}
+
+func Test_demoteSlowest(t *testing.T) {
+	sl := strategyList{
+		{name: "A", suspended: true},
+		{name: "B", promoted: true},
+		{name: "C", promoted: true},
+		{name: "D", promoted: true},
+	}
+	if names := sl.promoted(); len(names) != 3 {
+		t.Errorf("%d promoted strategies, want 3", len(names))
+	}
+	durations := map[string]time.Duration{"B": time.Millisecond, "C": 3 * time.Millisecond, "D": 2 * time.Millisecond}
+	if name, ok := sl.demote(durations); !ok || name != "C" {
+		t.Errorf("demoted %q, want C", name)
+	}
+	if name, ok := sl.demote(nil); !ok || name != "B" {
+		t.Errorf("demoted %q without durations, want B", name)
+	}
+	if al := sl.active(); len(al) != 1 || al[0].name != "D" {
+		t.Errorf("unexpected active strategies %v", al)
+	}
+}
+
+func Test_genRootedStrategyList(t *testing.T) {
+	hl, err := plan.ParseHostList(`192.168.1.1:3,192.168.1.2:3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := hl.GenPeerList(6, plan.DefaultPortRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []kb.Strategy{kb.Star, kb.BinaryTree, kb.BinaryTreeStar} {
+		for root := range pl {
+			sl := genRootedStrategyList(pl, name, root)
+			if len(sl) != 1 {
+				t.Fatalf("%s: %d strategies, want 1", name, len(sl))
+			}
+			g := sl[0].bcastGraph
+			if len(g.Prevs(root)) != 0 {
+				t.Errorf("%s: root %d has prevs %v", name, root, g.Prevs(root))
+			}
+			if n := countReachable(g.Nexts, root); n != len(pl) {
+				t.Errorf("%s: broadcast from %d reaches %d peers, want %d", name, root, n, len(pl))
+			}
+			if n := countReachable(sl[0].reduceGraph.Prevs, root); n != len(pl) {
+				t.Errorf("%s: reduce to %d collects %d peers, want %d", name, root, n, len(pl))
+			}
+			if name != kb.BinaryTreeStar {
+				continue
+			}
+			var cross int
+			for i := range pl {
+				for _, j := range g.Nexts(i) {
+					if pl[i].IPv4 != pl[j].IPv4 {
+						cross++
+					}
+				}
+			}
+			if cross != 1 {
+				t.Errorf("%s: %d edges across hosts from %d, want 1", name, cross, root)
+			}
+		}
+	}
+}
+
+func Test_activeExcludesBroken(t *testing.T) {
+	sl := strategyList{
+		{name: "A", broken: true},
+		{name: "B"},
+		{name: "C", suspended: true},
+	}
+	if al := sl.active(); len(al) != 1 || al[0].name != "B" {
+		t.Errorf("active strategies: %v", al)
+	}
+	sl[1].broken = true
+	if al := sl.active(); len(al) != 2 {
+		t.Errorf("all active strategies are broken, but %d are used", len(al))
+	}
+}
@@ -0,0 +1,164 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+)
+
+// defaultStreamSegmentBytes is the size of the segments of AllReduceStream if StreamWorkspace.SegmentBytes is 0.
+const defaultStreamSegmentBytes = 64 << 20
+
+// A StreamWorkspace is the AllReduce of a tensor that is not held in memory as a whole, e.g. an out-of-core tensor
+// larger than the available RAM. The elements of the local tensor are read from Src, and the elements of the result
+// are written to Dst, in order.
+type StreamWorkspace struct {
+	Src          io.Reader
+	Dst          io.Writer
+	Count        int64 // elements of the tensor
+	Type         kb.DataType
+	OP           kb.OP
+	Name         string
+	SegmentBytes int  // bytes of the segments reduced at a time, defaultStreamSegmentBytes if 0
+	Compensated  bool // as Workspace.Compensated
+}
+
+// AllReduceStream all reduces the tensor of w segment by segment, so that it takes memory of 3 segments regardless of its size:
+// the next segment is read from w.Src and the previous one is written to w.Dst while a segment is reduced in place.
+// All peers must stream tensors of the same count, type and op. It fails if reading or writing fails, leaving w.Dst incomplete.
+// The peers agree on each segment being read before reducing it, so that they all fail if any of them fails to read.
+func (sess *Session) AllReduceStream(w StreamWorkspace) error {
+	segmentBytes := w.SegmentBytes
+	if segmentBytes == 0 {
+		segmentBytes = defaultStreamSegmentBytes
+	}
+	size := w.Type.Size()
+	if segmentBytes < size || segmentBytes > maxMessageBytes {
+		return fmt.Errorf("invalid segment size %d bytes of %s", segmentBytes, w.Name)
+	}
+	if !w.OP.ElementWise() {
+		return fmt.Errorf("%s can't be streamed by %s", w.Name, w.OP)
+	}
+	if err := sess.checkStream(w); err != nil {
+		return err
+	}
+	if sess.syncPolicy.skip(w.Name) {
+		_, err := io.CopyN(w.Dst, w.Src, w.Count*int64(size))
+		return err
+	}
+	segment := int64(segmentBytes / size)
+	free := make(chan *kb.Vector, 3)
+	for i := 0; i < cap(free); i++ {
+		free <- kb.NewVector(int(minInt64(segment, w.Count)), w.Type)
+	}
+	read := make(chan *kb.Vector, 1)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(read)
+		for off := int64(0); off < w.Count; off += segment {
+			var buf *kb.Vector
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+			buf = buf.Slice(0, int(minInt64(segment, w.Count-off)))
+			if _, err := io.ReadFull(w.Src, buf.Data); err != nil {
+				readErr <- fmt.Errorf("reading %s at element %d: %v", w.Name, off, err)
+				return
+			}
+			select {
+			case read <- buf:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	reduced := make(chan *kb.Vector, 1)
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		for buf := range reduced {
+			if err == nil {
+				if _, e := w.Dst.Write(buf.Data); e != nil {
+					err = fmt.Errorf("writing %s: %v", w.Name, e)
+				}
+			}
+			free <- buf // the segments are still reduced after a failed write, as the other peers are waiting for them
+		}
+		writeErr <- err
+	}()
+	err := func() error {
+		defer close(reduced)
+		for off := int64(0); off < w.Count; off += segment {
+			buf, ok := <-read
+			all, err := sess.agreeSegment(w.Name, ok)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return <-readErr
+			}
+			if !all {
+				return fmt.Errorf("%w: %s at element %d", errStreamReadElsewhere, w.Name, off)
+			}
+			ws := kb.Workspace{SendBuf: buf, RecvBuf: buf, OP: w.OP, Name: w.Name + ":stream", Compensated: w.Compensated}
+			if err := sess.allReduce(ws); err != nil {
+				return err
+			}
+			reduced <- buf
+		}
+		return nil
+	}()
+	if werr := <-writeErr; err == nil {
+		err = werr
+	}
+	return err
+}
+
+var (
+	errStreamReadElsewhere = errors.New("stream failed to be read on other peers")
+	errStreamMismatch      = failure.New(failure.ProtocolMismatch, "peers stream tensors of different counts, types, ops or compensations")
+)
+
+// agreeSegment returns whether all peers have read the next segment of the stream of name, given whether this peer
+// has read it, so that a peer failing to read fails the stream on all peers, rather than leaving them waiting for its segment.
+func (sess *Session) agreeSegment(name string, ok bool) (bool, error) {
+	x := kb.NewVector(1, kb.I8)
+	y := kb.NewVector(1, kb.I8)
+	x.AsI8()[0] = boolToInt8(ok)
+	if err := sess.allReduce(kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MIN, Name: "kungfu::stream-segment:" + name}); err != nil {
+		return false, err
+	}
+	return y.AsI8()[0] > 0, nil
+}
+
+// checkStream checks that all peers stream tensors of the same count, type, op and compensation,
+// as a mismatch would hang the segments rather than fail the collective.
+func (sess *Session) checkStream(w StreamWorkspace) error {
+	bs := binary.LittleEndian.AppendUint64(nil, uint64(w.Count))
+	bs = binary.LittleEndian.AppendUint32(bs, uint32(w.Type))
+	bs = binary.LittleEndian.AppendUint32(bs, uint32(w.OP))
+	bs = append(bs, byte(boolToInt8(w.Compensated)))
+	ok, err := sess.BytesConsensus(bs, "kungfu::stream:"+w.Name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", errStreamMismatch, w.Name)
+	}
+	return nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+)
+
+var errSyncPeriodMismatch = failure.New(failure.ProtocolMismatch, "peers disagree on the sync periods")
+
+// syncRule synchronizes the tensors whose names contain pattern only every period steps.
+type syncRule struct {
+	pattern string
+	period  int
+}
+
+// syncPolicy skips the all reduce of low-importance tensors, e.g. batch norm statistics, on most steps.
+// A step of a tensor is an AllReduce of its name, all peers count them from the start of the session,
+// so that they skip the same steps as long as they all reduce the same tensors in the same order.
+type syncPolicy struct {
+	sync.Mutex
+	rules    []syncRule
+	counters map[string]int // AllReduce calls of each tensor of a rule
+}
+
+// parseSyncPeriods parses a comma separated list of <name pattern>=<period>.
+// A tensor is synchronized on every period-th step, starting from its first, by the first rule whose pattern its name contains.
+func parseSyncPeriods(val string) ([]syncRule, error) {
+	var rules []syncRule
+	for _, part := range strings.Split(val, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid sync period %q", part)
+		}
+		period, err := strconv.Atoi(kv[1])
+		if err != nil || period < 1 {
+			return nil, fmt.Errorf("invalid sync period %q", part)
+		}
+		rules = append(rules, syncRule{pattern: kv[0], period: period})
+	}
+	return rules, nil
+}
+
+func formatSyncPeriods(rules []syncRule) string {
+	var parts []string
+	for _, r := range rules {
+		parts = append(parts, fmt.Sprintf("%s=%d", r.pattern, r.period))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *syncPolicy) periodOf(name string) int {
+	for _, r := range p.rules {
+		if strings.Contains(name, r.pattern) {
+			return r.period
+		}
+	}
+	return 1
+}
+
+// skip counts a step of the tensor of the name, and returns true if it is not synchronized on this step.
+func (p *syncPolicy) skip(name string) bool {
+	p.Lock()
+	defer p.Unlock()
+	period := p.periodOf(name)
+	if period <= 1 {
+		return false
+	}
+	step := p.counters[name]
+	p.counters[name]++
+	return step%period != 0
+}
+
+// SetSyncPeriods sets the sync periods of tensors in the format of parseSyncPeriods, or removes them if val is empty,
+// and restarts the step counters of all tensors. It must be called by all peers with the same periods.
+func (sess *Session) SetSyncPeriods(val string) error {
+	var rules []syncRule
+	if len(val) > 0 {
+		var err error
+		if rules, err = parseSyncPeriods(val); err != nil {
+			return err
+		}
+	}
+	canonical := formatSyncPeriods(rules)
+	ok, err := sess.BytesConsensus([]byte(canonical), "kungfu::sync-periods")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %q is not used by all peers", errSyncPeriodMismatch, canonical)
+	}
+	sess.syncPolicy.Lock()
+	defer sess.syncPolicy.Unlock()
+	sess.syncPolicy.rules = rules
+	sess.syncPolicy.counters = make(map[string]int)
+	return nil
+}
+
+// SyncPeriods returns the sync periods of tensors in the format of parseSyncPeriods, or "" if all tensors are synchronized on every step.
+func (sess *Session) SyncPeriods() string {
+	sess.syncPolicy.Lock()
+	defer sess.syncPolicy.Unlock()
+	return formatSyncPeriods(sess.syncPolicy.rules)
+}
+
+// SkipsNext returns true if the next AllReduce of the named tensor will be skipped by its sync period,
+// e.g. for callers averaging the result to use the local value as it is.
+func (sess *Session) SkipsNext(name string) bool {
+	p := &sess.syncPolicy
+	p.Lock()
+	defer p.Unlock()
+	period := p.periodOf(name)
+	return period > 1 && p.counters[name]%period != 0
+}
+
+// skipSync returns true if the AllReduce of w is skipped on this step by its sync period,
+// in which case w.RecvBuf is set to w.SendBuf, as if the tensor was reduced over this peer alone.
+func (sess *Session) skipSync(w kb.Workspace) bool {
+	if !sess.syncPolicy.skip(w.Name) {
+		return false
+	}
+	if !w.IsEmpty() {
+		w.Forward()
+	}
+	return true
+}
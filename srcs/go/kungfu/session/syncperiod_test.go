@@ -0,0 +1,31 @@
+package session
+
+import "testing"
+
+func Test_syncPolicy(t *testing.T) {
+	rules, err := parseSyncPeriods("moving_mean=3,moving_variance=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatSyncPeriods(rules); got != "moving_mean=3,moving_variance=2" {
+		t.Errorf("formatted as %q", got)
+	}
+	p := syncPolicy{rules: rules, counters: make(map[string]int)}
+	var synced []int
+	for step := 0; step < 7; step++ {
+		if !p.skip("bn/moving_mean:0") {
+			synced = append(synced, step)
+		}
+		if p.skip("dense/kernel:0") {
+			t.Errorf("tensor of no rule skipped at step %d", step)
+		}
+	}
+	if want := []int{0, 3, 6}; len(synced) != len(want) || synced[1] != want[1] || synced[2] != want[2] {
+		t.Errorf("synchronized at steps %v, want %v", synced, want)
+	}
+	for _, val := range []string{"x", "x=0", "=2", "x=y"} {
+		if _, err := parseSyncPeriods(val); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+}
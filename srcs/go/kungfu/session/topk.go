@@ -0,0 +1,57 @@
+package session
+
+import (
+	"errors"
+	"sort"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+var errInvalidTopK = errors.New("k must be in [1, cluster size]")
+
+// TopK gathers a scalar score from each peer, and returns the ranks of the k peers with the highest scores,
+// in descending order of scores. Ties are broken by rank, so that all peers agree on the result.
+func (sess *Session) TopK(score float32, k int, name string) ([]int, error) {
+	n := len(sess.peers)
+	if k < 1 || k > n {
+		return nil, errInvalidTopK
+	}
+	x := kb.NewVector(1, kb.F32)
+	y := kb.NewVector(n, kb.F32)
+	x.AsF32()[0] = score
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: ":topk:scores:" + name}
+	if err := sess.runAllGather(w); err != nil {
+		return nil, err
+	}
+	scores := y.AsF32()
+	ranks := make([]int, n)
+	for i := range ranks {
+		ranks[i] = i
+	}
+	sort.SliceStable(ranks, func(i, j int) bool { return scores[ranks[i]] > scores[ranks[j]] })
+	return ranks[:k], nil
+}
+
+// TopKExploit is TopK for population-based training, it also replaces the models of the other peers by the models of the top k peers.
+// The i-th of the other peers (in rank order) receives the model w.SendBuf of the (i mod k)-th top peer into w.RecvBuf,
+// while the top k peers keep their own models.
+func (sess *Session) TopKExploit(score float32, k int, w kb.Workspace) ([]int, error) {
+	top, err := sess.TopK(score, k, w.Name)
+	if err != nil {
+		return nil, err
+	}
+	isTop := make(map[int]bool)
+	for _, r := range top {
+		isTop[r] = true
+	}
+	g := graph.New(len(sess.peers))
+	var i int
+	for r := range sess.peers {
+		if !isTop[r] {
+			g.AddEdge(top[i%k], r)
+			i++
+		}
+	}
+	return top, sess.runGraphs(w, g)
+}
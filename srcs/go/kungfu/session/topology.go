@@ -0,0 +1,161 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/utils"
+)
+
+// TopologyMessageName is the name of the control message sent to all peers by ReportTopologyEvent.
+const TopologyMessageName = `topology`
+
+// reprobeSize is the bytes sent to re-probe a link after a topology change.
+const reprobeSize = 64 * 1024
+
+type TopologyEventKind string
+
+const (
+	MembershipChanged TopologyEventKind = `membership-changed`
+	LinkDegraded      TopologyEventKind = `link-degraded`
+)
+
+// A TopologyEvent notifies the adaptation of a change of the environment, which makes the measured stats stale.
+type TopologyEvent struct {
+	Kind   TopologyEventKind `json:"kind"`
+	From   int               `json:"from"`             // ranks of the link of LinkDegraded
+	To     int               `json:"to"`               //
+	Joined []int             `json:"joined,omitempty"` // ranks of the peers joined, of MembershipChanged
+}
+
+// ParseTopologyEvent decodes a topology control message sent by ReportTopologyEvent.
+func ParseTopologyEvent(data []byte) (*TopologyEvent, error) {
+	var e TopologyEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ReportTopologyEvent handles e, and sends it to the other peers over the control plane.
+// It can be called by any peer, e.g. the one which flagged a degraded link.
+func (sess *Session) ReportTopologyEvent(e TopologyEvent) error {
+	if e.Kind == LinkDegraded {
+		if n := len(sess.peers); e.From < 0 || e.From >= n || e.To < 0 || e.To >= n || e.From == e.To {
+			return fmt.Errorf("invalid link %d -> %d of %d peers", e.From, e.To, n)
+		}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(sess.peers))
+	var wg sync.WaitGroup
+	for rank, peer := range sess.peers {
+		if rank == sess.rank {
+			continue
+		}
+		wg.Add(1)
+		go func(rank int, peer plan.PeerID) {
+			errs[rank] = sess.client.Send(peer.WithName(TopologyMessageName), data, connection.ConnControl, connection.NoFlag)
+			wg.Done()
+		}(rank, peer)
+	}
+	sess.HandleTopologyEvent(e)
+	wg.Wait()
+	return utils.MergeErrors(errs, "ReportTopologyEvent")
+}
+
+// HandleTopologyEvent invalidates the stats of the strategies affected by e,
+// and re-probes the links of this peer affected by e in the background.
+func (sess *Session) HandleTopologyEvent(e TopologyEvent) {
+	sess.logger.Infof("topology changed: %s", e)
+	switch e.Kind {
+	case MembershipChanged:
+		sess.stats.reset()
+		for _, rank := range e.Joined {
+			if rank != sess.rank {
+				go sess.reprobe(sess.rank, rank)
+			}
+		}
+	case LinkDegraded:
+		for _, s := range sess.swap.latest() {
+			if s.uses(e.From, e.To) {
+				sess.stats.invalidate(s.name)
+			}
+		}
+		if e.From == sess.rank {
+			go sess.reprobe(e.From, e.To)
+		}
+	default:
+		sess.logger.Warnf("unknown topology event: %s", e.Kind)
+	}
+}
+
+func (e TopologyEvent) String() string {
+	if e.Kind == LinkDegraded {
+		return fmt.Sprintf("%s %d -> %d", e.Kind, e.From, e.To)
+	}
+	return fmt.Sprintf("%s, joined %v", e.Kind, e.Joined)
+}
+
+// uses returns true if any graph of s has the link a -> b.
+func (s strategy) uses(a, b int) bool {
+	for _, g := range append(s.graphs(), s.reduceGraph, s.bcastGraph) {
+		if hasEdge(g, a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEdge(g *graph.Graph, a, b int) bool {
+	if g == nil || a >= len(g.Nodes) {
+		return false
+	}
+	for _, j := range g.Nexts(a) {
+		if j == b {
+			return true
+		}
+	}
+	return false
+}
+
+type linkKey struct{ from, to int }
+
+// linkStats keeps the latest measurements of links re-probed by this peer.
+type linkStats struct {
+	sync.Mutex
+	links map[linkKey]client.LinkStats
+}
+
+func (sess *Session) reprobe(a, b int) {
+	stats, err := sess.ProbeLink(a, b, reprobeSize)
+	if err != nil {
+		sess.logger.Warnf("failed to re-probe link %d -> %d: %v", a, b, err)
+		return
+	}
+	sess.logger.Debugf("re-probed link %d -> %d: latency %s", a, b, stats.Latency)
+	sess.links.Lock()
+	defer sess.links.Unlock()
+	if sess.links.links == nil {
+		sess.links.links = make(map[linkKey]client.LinkStats)
+	}
+	sess.links.links[linkKey{a, b}] = *stats
+}
+
+// LinkStats returns the latest measurements of the links re-probed by this peer after topology changes.
+func (sess *Session) LinkStats() []client.LinkStats {
+	sess.links.Lock()
+	defer sess.links.Unlock()
+	var ls []client.LinkStats
+	for _, s := range sess.links.links {
+		ls = append(ls, s)
+	}
+	return ls
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_strategyUses(t *testing.T) {
+	s := simpleStrategy(plan.GenStarBcastGraph(4, 0))
+	for _, l := range [][2]int{{0, 1}, {0, 3}, {2, 0}} {
+		if !s.uses(l[0], l[1]) {
+			t.Errorf("star strategy should use link %d -> %d", l[0], l[1])
+		}
+	}
+	for _, l := range [][2]int{{1, 2}, {3, 1}, {4, 0}} {
+		if s.uses(l[0], l[1]) {
+			t.Errorf("star strategy should not use link %d -> %d", l[0], l[1])
+		}
+	}
+}
+
+func Test_invalidateStrategyStats(t *testing.T) {
+	sampler, _ := parseStatSampling(`1`)
+	s := newStrategyStats(sampler)
+	s.add("a", 1, 1, 0, time.Millisecond)
+	s.add("b", 1, 1, 0, time.Millisecond)
+	s.invalidate("a")
+	if chunks, _ := s.get("a"); chunks != 0 {
+		t.Errorf("invalidated stats has %d chunks", chunks)
+	}
+	if chunks, _ := s.get("b"); chunks != 1 {
+		t.Errorf("stats has %d chunks, want 1", chunks)
+	}
+	s.reset()
+	if chunks, _ := s.get("b"); chunks != 0 {
+		t.Errorf("reset stats has %d chunks", chunks)
+	}
+}
@@ -0,0 +1,48 @@
+package session
+
+// The trace markers below are empty functions called on the hot path of collectives, which are never inlined,
+// so that operators can attach uprobes to them in a production worker, e.g. by bpftrace or perf,
+// without rebuilding it with debug logging. A call costs a few nanoseconds when no probe is attached.
+//
+// The symbols are github.com/lsds/KungFu/srcs/go/kungfu/session.trace*, and the arguments are passed
+// by the register ABI of Go in order: a string name takes 2 registers (pointer and length), an int takes 1.
+// E.g. on amd64, the histogram of the bytes of chunks sent:
+//
+//	bpftrace -e 'uprobe:./worker:"github.com/lsds/KungFu/srcs/go/kungfu/session.traceSendBegin" { @bytes = hist(reg("cx")); }'
+//
+// Markers of the same chunk are paired by the name of the chunk and the thread, e.g. to measure the time of reductions.
+
+// traceCollectiveBegin marks the start of a collective on the workspace of name.
+//
+//go:noinline
+func traceCollectiveBegin(name string, bytes int) {}
+
+// traceCollectiveEnd marks the end of a collective started by traceCollectiveBegin.
+//
+//go:noinline
+func traceCollectiveEnd(name string) {}
+
+// traceSendBegin marks the start of sending a chunk, which may wait for its QoS share and for earlier messages.
+//
+//go:noinline
+func traceSendBegin(name string, bytes int) {}
+
+// traceSendEnd marks the end of sending a chunk.
+//
+//go:noinline
+func traceSendEnd(name string, bytes int) {}
+
+// traceRecv marks a chunk received.
+//
+//go:noinline
+func traceRecv(name string, bytes int) {}
+
+// traceReduceBegin marks the start of reducing a received chunk.
+//
+//go:noinline
+func traceReduceBegin(name string, bytes int) {}
+
+// traceReduceEnd marks the end of reducing a received chunk.
+//
+//go:noinline
+func traceReduceEnd(name string, bytes int) {}
@@ -0,0 +1,194 @@
+package session
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// WeightedHash is the name of the strategy hash which chooses the strategy of a chunk with probability
+// in proportion to the throughput of the strategy, i.e. the inverse of the mean duration of its chunks,
+// so that faster strategies take more chunks. The weights are agreed by all peers by ReweightStrategies.
+const WeightedHash = `WEIGHTED`
+
+// A strategyChooser is a StrategyHash which chooses one of n strategies for the i-th chunk w itself,
+// instead of by the remainder of its hash.
+type strategyChooser interface {
+	choose(i int, w kb.Workspace, n int) int
+}
+
+// weightedHash chooses strategies by the cumulative weights of the active strategies,
+// at the position of a uniform hash of the name and index of the chunk, which is the same on all peers.
+// As hot swaps, new weights are used from the next step of all peers, so that all peers choose the same strategies
+// for the collectives of a step, see at.
+type weightedHash struct {
+	sync.Mutex
+	gens []weightGeneration // in the order of from, gens[0] is used for all steps before gens[1]
+}
+
+// weightGeneration is the weights of the active strategies used from a step on, normalized to sum 1, nil for equal weights.
+type weightGeneration struct {
+	from    int64
+	weights []float64
+}
+
+func (h *weightedHash) Name() string { return WeightedHash }
+
+func (h *weightedHash) Hash(i int, w kb.Workspace) uint64 {
+	return weightedHashOf(i, w)
+}
+
+// at returns the strategy hash choosing the strategies of the collectives of the given step.
+func (h *weightedHash) at(step int64) *weightedChoice {
+	h.Lock()
+	defer h.Unlock()
+	for i := len(h.gens) - 1; i >= 0; i-- {
+		if h.gens[i].from <= step || i == 0 {
+			return &weightedChoice{weightGeneration: h.gens[i]}
+		}
+	}
+	return &weightedChoice{}
+}
+
+// commit uses weights from the given step on, in place of the weights committed at the same or later steps.
+func (h *weightedHash) commit(from int64, weights []float64) {
+	h.Lock()
+	defer h.Unlock()
+	i := len(h.gens)
+	for i > 0 && h.gens[i-1].from >= from {
+		i--
+	}
+	h.gens = append(h.gens[:i], weightGeneration{from: from, weights: weights})
+	if n := len(h.gens); n > hotSwapHistory {
+		h.gens = h.gens[n-hotSwapHistory:]
+	}
+}
+
+// weightedChoice is a weightedHash at a step, which has the weights of the step.
+type weightedChoice struct {
+	weightGeneration
+}
+
+func (c *weightedChoice) Name() string { return WeightedHash }
+
+func (c *weightedChoice) Hash(i int, w kb.Workspace) uint64 {
+	return weightedHashOf(i, w)
+}
+
+func weightedHashOf(i int, w kb.Workspace) uint64 {
+	d := fnv.New64a()
+	d.Write([]byte(w.Name))
+	d.Write([]byte(strconv.Itoa(i)))
+	return mix64(d.Sum64())
+}
+
+// mix64 spreads the bits of x to the high bits by the finalizer of splitmix64, as FNV of similar names differ in the low bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (c *weightedChoice) choose(i int, w kb.Workspace, n int) int {
+	x := c.Hash(i, w)
+	ws := c.weights
+	if len(ws) != n {
+		return int(x % uint64(n)) // the active strategies changed since the last reweighting
+	}
+	u := float64(x>>11) / (1 << 53)
+	for j, wt := range ws {
+		if u < wt {
+			return j
+		}
+		u -= wt
+	}
+	return n - 1
+}
+
+// instance returns the strategy hash used by a session for h, as a weighted hash has the weights of its session.
+func instance(h StrategyHash) StrategyHash {
+	if _, ok := h.(*weightedHash); ok {
+		return &weightedHash{}
+	}
+	return h
+}
+
+// ReweightStrategies sets the weights of the active strategies for WeightedHash by the inverse of the mean durations
+// of their chunks, every config.ReweightInterval calls, e.g. steps. A strategy without chunks timed on any peer
+// gets the mean weight of the others, so that it keeps taking chunks until it's timed.
+// Peers agree on the durations by their max, so that they choose the same strategies, with the new weights from their
+// next step, so that the collectives in flight keep their strategies. It must be called by all peers, at the same point
+// of their collectives, e.g. at step boundaries. It does nothing if the strategy hash is not WeightedHash.
+func (sess *Session) ReweightStrategies() error {
+	sess.hashMu.Lock()
+	h, ok := sess.strategyHash.(*weightedHash)
+	sess.hashMu.Unlock()
+	if !ok {
+		return nil
+	}
+	if sess.reweightCalls++; config.ReweightInterval > 1 && sess.reweightCalls%config.ReweightInterval != 0 {
+		return nil
+	}
+	sl := sess.swap.latest().active()
+	x := kb.NewVector(len(sl), kb.F32)
+	y := kb.NewVector(len(sl), kb.F32)
+	for i, s := range sl {
+		if _, avg := sess.stats.get(s.name); avg > 0 {
+			x.AsF32()[i] = float32(avg.Seconds())
+		}
+	}
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "kungfu::reweight"}
+	if err := sess.runStrategies(w, plan.EvenPartition, sess.nextGlobalStrategies()); err != nil {
+		return err
+	}
+	ws := strategyWeights(y.AsF32())
+	from, err := sess.agreeNextStep([]byte(fmt.Sprint(ws)), "kungfu::reweight-fence")
+	if err != nil {
+		return err
+	}
+	h.commit(from, ws) // the plans of the new weights are cached apart, see planKey
+	if sess.rank == defaultRoot {
+		for i, s := range sl {
+			sess.strategyLogger(s.name).Debugf("strategy %s takes %.1f%% of chunks", s.name, ws[i]*100)
+		}
+	}
+	return nil
+}
+
+// strategyWeights returns the weights in proportion to the inverse of the durations, normalized to sum 1,
+// where a duration of 0 gets the mean weight of the others.
+func strategyWeights(durations []float32) []float64 {
+	ws := make([]float64, len(durations))
+	var total float64
+	var timed int
+	for i, d := range durations {
+		if d > 0 {
+			ws[i] = 1 / float64(d)
+			total += ws[i]
+			timed++
+		}
+	}
+	mean := 1.0
+	if timed > 0 {
+		mean = total / float64(timed)
+	}
+	total = 0
+	for i := range ws {
+		if ws[i] == 0 {
+			ws[i] = mean
+		}
+		total += ws[i]
+	}
+	for i := range ws {
+		ws[i] /= total
+	}
+	return ws
+}
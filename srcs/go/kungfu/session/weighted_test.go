@@ -0,0 +1,63 @@
+package session
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_strategyWeights(t *testing.T) {
+	ws := strategyWeights([]float32{1, 3, 0})
+	want := []float64{0.5, 1.0 / 6, 1.0 / 3} // the untimed strategy gets the mean weight 2/3 of the others
+	for i := range want {
+		if math.Abs(ws[i]-want[i]) > 1e-9 {
+			t.Fatalf("weights %v, want %v", ws, want)
+		}
+	}
+}
+
+func Test_weightedHash(t *testing.T) {
+	const n = 10000
+	h := &weightedHash{}
+	h.commit(1, []float64{0.75, 0.25})
+	c := h.at(1)
+	counts := make([]int, 2)
+	for i := 0; i < n; i++ {
+		w := kb.Workspace{Name: fmt.Sprintf("grad-%d", i)}
+		counts[c.choose(0, w, 2)]++
+	}
+	if math.Abs(float64(counts[0])/n-0.75) > 0.03 {
+		t.Errorf("chose %v, want 3:1", counts)
+	}
+	if j := c.choose(0, kb.Workspace{Name: "x"}, 3); j < 0 || j >= 3 {
+		t.Errorf("chose %d of 3 strategies with stale weights", j)
+	}
+}
+
+func Test_weightedHashGenerations(t *testing.T) {
+	h := &weightedHash{}
+	if ws := h.at(5).weights; ws != nil {
+		t.Errorf("weights %v before reweighting, want equal weights", ws)
+	}
+	h.commit(3, []float64{1, 0})
+	h.commit(6, []float64{0, 1})
+	for _, tc := range []struct {
+		step int64
+		want []float64
+	}{{1, []float64{1, 0}}, {3, []float64{1, 0}}, {5, []float64{1, 0}}, {6, []float64{0, 1}}, {9, []float64{0, 1}}} {
+		if ws := h.at(tc.step).weights; !reflect.DeepEqual(ws, tc.want) {
+			t.Errorf("weights at step %d: %v, want %v", tc.step, ws, tc.want)
+		}
+	}
+	c := newPlanCache()
+	w := kb.Workspace{SendBuf: kb.NewVector(4, kb.F32), RecvBuf: kb.NewVector(4, kb.F32), OP: kb.SUM, Name: "x"}
+	before := c.get(w, plan.EvenPartition, 2, h.at(5), 16)
+	after := c.get(w, plan.EvenPartition, 2, h.at(6), 16)
+	if before.choices[0] != 0 || after.choices[0] != 1 {
+		t.Errorf("chose %d before and %d after reweighting, want 0 and 1", before.choices[0], after.choices[0])
+	}
+}
@@ -0,0 +1,47 @@
+package session
+
+import (
+	"bytes"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+const maxLabelLen = 64
+
+// SetZoneHints exchanges the zone of each peer and switches the global strategy
+// to a zone-aware tree if peers are spread over more than one zone.
+func (sess *Session) SetZoneHints(zone string) error {
+	zones, err := sess.allGatherLabel(plan.LabelZone, zone)
+	if err != nil {
+		return err
+	}
+	distinct := make(map[string]struct{})
+	for _, z := range zones {
+		distinct[z] = struct{}{}
+	}
+	if len(distinct) <= 1 {
+		return nil
+	}
+	sess.logger.Debugf("peers are spread over %d zones, using zone aware strategy", len(distinct))
+	bcastGraph := plan.GenZoneAwareBinaryTreeStar(sess.peers, zones)
+	return sess.SetGlobalStrategy(named("ZONE_AWARE_BINARY_TREE_STAR", strategyList{simpleStrategy(bcastGraph)}))
+}
+
+// allGatherLabel exchanges a label of each peer, values longer than maxLabelLen are truncated.
+func (sess *Session) allGatherLabel(key, value string) ([]string, error) {
+	k := len(sess.peers)
+	x := kb.NewVector(maxLabelLen, kb.U8)
+	copy(x.Data, value)
+	y := kb.NewVector(maxLabelLen*k, kb.U8)
+	w := kb.Workspace{SendBuf: x, RecvBuf: y, Name: "kungfu::labels:" + key}
+	if err := sess.AllGather(w); err != nil {
+		return nil, err
+	}
+	values := make([]string, k)
+	for i := range values {
+		bs := y.Data[i*maxLabelLen : (i+1)*maxLabelLen]
+		values[i] = string(bytes.TrimRight(bs, "\x00"))
+	}
+	return values, nil
+}
@@ -8,6 +8,7 @@ import (
 )
 
 /*
+#include <kungfu/callback.h>
 #include <kungfu/dtype.h>
 */
 import "C"
@@ -23,6 +24,28 @@ func GoKungfuResizeClusterFromURL(pChanged, pKeep *C.char) int {
 	return 0
 }
 
+//export GoKungfuStepBoundary
+func GoKungfuStepBoundary(step int, pChanged, pKeep *C.char) int {
+	changed, keep, err := defaultPeer.StepBoundary(step)
+	if err != nil {
+		utils.ExitErr(err)
+	}
+	*pChanged = boolToChar(changed)
+	*pKeep = boolToChar(keep)
+	return 0
+}
+
+//export GoKungfuSnapshotBoundary
+func GoKungfuSnapshotBoundary(pName *C.char, buf unsafe.Pointer, count int, dtype C.KungFu_Datatype) bool {
+	return defaultPeer.SnapshotBoundary(C.GoString(pName), toVector(buf, count, dtype))
+}
+
+//export GoKungfuStaleSync
+func GoKungfuStaleSync(step, bound int, done *C.callback_t) int {
+	op := func() error { return defaultPeer.StaleSync(step, bound) }
+	return callOP("StaleSync", op, done)
+}
+
 //export GoKungfuProposeNewSize
 func GoKungfuProposeNewSize(newSize int) int {
 	err := defaultPeer.ProposeNewSize(newSize)
@@ -4,6 +4,7 @@ import (
 	"unsafe"
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
 )
 
 /*
@@ -73,6 +74,62 @@ func GoKungfuMonitoredAllReduce(sendBuf, recvBuf unsafe.Pointer, count int, dtyp
 	return callCollectiveOP("GoKungfuAllReduceWith", name, f, w, done)
 }
 
+//export GoKungfuGradientNoiseScale
+func GoKungfuGradientNoiseScale(sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, batchSize int, pResult unsafe.Pointer, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, dtype),
+		RecvBuf: toVector(recvBuf, count, dtype),
+		OP:      kb.SUM,
+		Name:    name,
+	}
+	result := toVector(pResult, 2, C.KungFu_FLOAT).AsF32() // [|G|^2, tr(Σ)]
+	sess := defaultPeer.CurrentSession()
+	f := func(w kb.Workspace) error {
+		ns, err := sess.GradientNoiseScale(w, batchSize)
+		if err != nil {
+			return err
+		}
+		result[0] = float32(ns.GradSqr)
+		result[1] = float32(ns.Trace)
+		return nil
+	}
+	return callCollectiveOP("GradientNoiseScale", name, f, w, done)
+}
+
+//export GoKungfuGlobalNorm
+func GoKungfuGlobalNorm(pBufs unsafe.Pointer, pCounts unsafe.Pointer, n int, dtype C.KungFu_Datatype, pNorm unsafe.Pointer, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	bufs := (*[1 << 28]unsafe.Pointer)(pBufs)[:n:n]
+	counts := toVector(pCounts, n, C.KungFu_INT32).AsI32()
+	tensors := make([]*kb.Vector, n)
+	for i := range tensors {
+		tensors[i] = toVector(bufs[i], int(counts[i]), dtype)
+	}
+	norm := toVector(pNorm, 1, C.KungFu_FLOAT).AsF32()
+	sess := defaultPeer.CurrentSession()
+	op := func() error {
+		v, err := sess.GlobalNorm(tensors, name)
+		norm[0] = v
+		return err
+	}
+	return callOP("GlobalNorm", op, done)
+}
+
+//export GoKungfuBatchNormStats
+func GoKungfuBatchNormStats(sendBuf, recvBuf unsafe.Pointer, channels int, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	count := 2*channels + 1
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, C.KungFu_FLOAT),
+		RecvBuf: toVector(recvBuf, count, C.KungFu_FLOAT),
+		OP:      kb.SUM,
+		Name:    name,
+	}
+	sess := defaultPeer.CurrentSession()
+	return callCollectiveOP("BatchNormStats", name, sess.BatchNormStats, w, done)
+}
+
 //export GoKungfuAllGather
 func GoKungfuAllGather(sendBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, recvBuf unsafe.Pointer, pName *C.char, done *C.callback_t) int {
 	name := C.GoString(pName)
@@ -110,6 +167,106 @@ func GoKungfuBroadcast(sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungF
 	return callCollectiveOP("Broadcast", name, sess.Broadcast, w, done)
 }
 
+//export GoKungfuCachedBroadcast
+func GoKungfuCachedBroadcast(sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, dtype),
+		RecvBuf: toVector(recvBuf, count, dtype),
+		Name:    name,
+	}
+	sess := defaultPeer.CurrentSession()
+	return callCollectiveOP("CachedBroadcast", name, sess.CachedBroadcast, w, done)
+}
+
+//export GoKungfuInvalidateBroadcast
+func GoKungfuInvalidateBroadcast(pName *C.char) {
+	defaultPeer.CurrentSession().InvalidateBroadcast(C.GoString(pName))
+}
+
+//export GoKungfuSetPostHooks
+func GoKungfuSetPostHooks(pName, pHooks *C.char) int {
+	hooks, err := session.ParsePostHooks(C.GoString(pHooks))
+	if err != nil {
+		return errorCode("SetPostHooks", err)
+	}
+	defaultPeer.CurrentSession().SetPostHooks(C.GoString(pName), hooks...)
+	return 0
+}
+
+//export GoKungfuLossScaleConsensus
+func GoKungfuLossScaleConsensus(overflow int, scale float32, pAnyOverflow *C.char, pNextScale unsafe.Pointer, pName *C.char) int {
+	name := C.GoString(pName)
+	sess := defaultPeer.CurrentSession()
+	anyOverflow, nextScale, err := sess.LossScaleConsensus(overflow != 0, scale, name)
+	*pAnyOverflow = boolToChar(anyOverflow)
+	toVector(pNextScale, 1, C.KungFu_FLOAT).AsF32()[0] = nextScale
+	return errorCode("LossScaleConsensus", err)
+}
+
+//export GoKungfuTopK
+func GoKungfuTopK(score float32, k int, pRanks unsafe.Pointer, pName *C.char) int {
+	name := C.GoString(pName)
+	sess := defaultPeer.CurrentSession()
+	top, err := sess.TopK(score, k, name)
+	copyRanks(toVector(pRanks, k, C.KungFu_INT32).AsI32(), top)
+	return errorCode("TopK", err)
+}
+
+//export GoKungfuTopKExploit
+func GoKungfuTopKExploit(score float32, k int, pRanks unsafe.Pointer, sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, pName *C.char) int {
+	name := C.GoString(pName)
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, dtype),
+		RecvBuf: toVector(recvBuf, count, dtype),
+		Name:    name,
+	}
+	sess := defaultPeer.CurrentSession()
+	top, err := sess.TopKExploit(score, k, w)
+	copyRanks(toVector(pRanks, k, C.KungFu_INT32).AsI32(), top)
+	return errorCode("TopKExploit", err)
+}
+
+func copyRanks(dst []int32, ranks []int) {
+	for i, r := range ranks {
+		dst[i] = int32(r)
+	}
+}
+
+//export GoKungfuAssignShard
+func GoKungfuAssignShard(pName *C.char, size int, pOwner unsafe.Pointer) int {
+	name := C.GoString(pName)
+	sess := defaultPeer.CurrentSession()
+	owner, err := sess.AssignShard(name, size)
+	toVector(pOwner, 1, C.KungFu_INT32).AsI32()[0] = int32(owner)
+	return errorCode("AssignShard", err)
+}
+
+//export GoKungfuShardBroadcast
+func GoKungfuShardBroadcast(sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, dtype),
+		RecvBuf: toVector(recvBuf, count, dtype),
+		Name:    name,
+	}
+	sess := defaultPeer.CurrentSession()
+	return callCollectiveOP("ShardBroadcast", name, sess.ShardBroadcast, w, done)
+}
+
+//export GoKungfuShardReduce
+func GoKungfuShardReduce(sendBuf, recvBuf unsafe.Pointer, count int, dtype C.KungFu_Datatype, op C.KungFu_Op, pName *C.char, done *C.callback_t) int {
+	name := C.GoString(pName)
+	w := kb.Workspace{
+		SendBuf: toVector(sendBuf, count, dtype),
+		RecvBuf: toVector(recvBuf, count, dtype),
+		OP:      kb.OP(op),
+		Name:    name,
+	}
+	sess := defaultPeer.CurrentSession()
+	return callCollectiveOP("ShardReduce", name, sess.ShardReduce, w, done)
+}
+
 //export GoKungfuGather
 func GoKungfuGather(sendBuf unsafe.Pointer, sendCount int, sendDtype C.KungFu_Datatype, recvBuf unsafe.Pointer, recvCount int, recvDtype C.KungFu_Datatype, pName *C.char, done *C.callback_t) int {
 	name := C.GoString(pName)
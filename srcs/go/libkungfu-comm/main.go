@@ -7,7 +7,9 @@ import (
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
 	"github.com/lsds/KungFu/srcs/go/kungfu/peer"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/utils"
 )
@@ -22,6 +24,11 @@ import "C"
 
 var defaultPeer *peer.Peer
 
+//export GoKungfuSetFingerprint
+func GoKungfuSetFingerprint(pKey, pValue *C.char) {
+	session.SetFingerprint(C.GoString(pKey), C.GoString(pValue))
+}
+
 //export GoKungfuInit
 func GoKungfuInit() int {
 	var err error
@@ -77,6 +84,99 @@ func GoKungfuHostCount() int {
 	return sess.HostCount()
 }
 
+//export GoKungfuStep
+func GoKungfuStep() int {
+	sess := defaultPeer.CurrentSession()
+	return int(sess.Step())
+}
+
+//export GoKungfuEpoch
+func GoKungfuEpoch() int {
+	sess := defaultPeer.CurrentSession()
+	return int(sess.Epoch())
+}
+
+//export GoKungfuAdvanceStep
+func GoKungfuAdvanceStep() int {
+	sess := defaultPeer.CurrentSession()
+	return int(sess.AdvanceStep())
+}
+
+//export GoKungfuAdvanceEpoch
+func GoKungfuAdvanceEpoch() int {
+	sess := defaultPeer.CurrentSession()
+	return int(sess.AdvanceEpoch())
+}
+
+//export GoKungfuBeginStep
+func GoKungfuBeginStep() {
+	sess := defaultPeer.CurrentSession()
+	sess.BeginStep()
+}
+
+//export GoKungfuEndStep
+func GoKungfuEndStep(pCollectives, pBytes, pDuration, pCriticalPath, pFusionEfficiency unsafe.Pointer) int {
+	sess := defaultPeer.CurrentSession()
+	s, err := sess.EndStep()
+	if err != nil {
+		return errorCode("EndStep", err)
+	}
+	toVector(pCollectives, 1, C.KungFu_INT32).AsI32()[0] = int32(s.Collectives)
+	toVector(pBytes, 1, C.KungFu_INT64).AsI64()[0] = s.Bytes
+	toVector(pDuration, 1, C.KungFu_FLOAT).AsF32()[0] = float32(s.Duration.Seconds())
+	toVector(pCriticalPath, 1, C.KungFu_FLOAT).AsF32()[0] = float32(s.CriticalPath.Seconds())
+	toVector(pFusionEfficiency, 1, C.KungFu_FLOAT).AsF32()[0] = float32(s.FusionEfficiency)
+	return 0
+}
+
+//export GoKungfuAbort
+func GoKungfuAbort(pReason *C.char) int {
+	sess := defaultPeer.CurrentSession()
+	return errorCode("Abort", sess.Abort(C.GoString(pReason)))
+}
+
+//export GoKungfuReportSignal
+func GoKungfuReportSignal(pName *C.char, value float64) int {
+	sess := defaultPeer.CurrentSession()
+	sess.ReportSignal(C.GoString(pName), value)
+	return 0
+}
+
+//export GoKungfuFusionSize
+func GoKungfuFusionSize() int {
+	return config.FusionSize
+}
+
+//export GoKungfuGPUDirect
+func GoKungfuGPUDirect() bool {
+	ok, err := defaultPeer.CurrentSession().GPUDirect()
+	if err != nil {
+		log.Warnf("GPUDirect failed: %v", err)
+	}
+	return ok
+}
+
+//export GoKungfuRegisterBuffer
+func GoKungfuRegisterBuffer(ptr unsafe.Pointer, size int) {
+	defaultPeer.CurrentSession().RegisterBuffers(unsafe.Slice((*byte)(ptr), size))
+}
+
+//export GoKungfuReleaseBuffer
+func GoKungfuReleaseBuffer(ptr unsafe.Pointer) {
+	defaultPeer.CurrentSession().ReleaseBuffers(unsafe.Slice((*byte)(ptr), 1))
+}
+
+//export GoKungfuRegisterDeviceBuffer
+func GoKungfuRegisterDeviceBuffer(ptr unsafe.Pointer, size int, dmabufFD int, offset uint64) int {
+	_, err := defaultPeer.CurrentSession().RegisterDeviceBuffer(ptr, size, dmabufFD, offset)
+	return errorCode("RegisterDeviceBuffer", err)
+}
+
+//export GoKungfuReleaseDeviceBuffer
+func GoKungfuReleaseDeviceBuffer(ptr unsafe.Pointer) {
+	defaultPeer.CurrentSession().ReleaseBuffers(unsafe.Slice((*byte)(ptr), 1))
+}
+
 //export GoKungfuRequest
 func GoKungfuRequest(rank int, pName *C.char, buf unsafe.Pointer, count int, dtype C.KungFu_Datatype, done *C.callback_t) int {
 	name := C.GoString(pName) // copy *C.char into go string before entering closure
@@ -123,6 +223,15 @@ func GoKungfuSaveVersion(version, name *C.char, buf unsafe.Pointer, count int, d
 	return callOP("SaveVersion", op, done)
 }
 
+//export GoKungfuCheckpoint
+func GoKungfuCheckpoint(url, name *C.char, buf unsafe.Pointer, count int, dtype C.KungFu_Datatype, done *C.callback_t) int {
+	goURL := C.GoString(url)
+	goName := C.GoString(name)
+	b := toVector(buf, count, dtype)
+	op := func() error { return defaultPeer.Checkpoint(goURL, goName, b) }
+	return callOP("Checkpoint", op, done)
+}
+
 //export GoKungfuGetPeerLatencies
 func GoKungfuGetPeerLatencies(recvBuf unsafe.Pointer, recvCount int, recvDtype C.KungFu_Datatype) int {
 	results := toVector(recvBuf, recvCount, recvDtype).AsF32()
@@ -194,6 +303,7 @@ func errorCode(name string, err error) int {
 	if err == nil {
 		return 0
 	}
-	log.Errorf("kungfu operation %s failed: %v", name, err)
-	return 1 // the caller should exit(1)
+	kind := failure.Of(err)
+	log.Errorf("kungfu operation %s failed (%s): %v", name, kind, err)
+	return kind.Code() // the caller may recover by the kind, see failure.Kind
 }
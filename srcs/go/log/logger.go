@@ -1,9 +1,11 @@
 package log
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +39,23 @@ const (
 	ShowTimestamp = 1 << iota
 )
 
+// Output formats of loggers.
+const (
+	Text = `text`
+	JSON = `json` // one object per line, with the level, time, message and fields, for aggregating the logs of workers
+)
+
+// A Field is a key and value attached to the messages of a logger, e.g. the rank of the peer.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
 type Logger struct {
 	sync.Mutex
 	outWriter io.Writer
@@ -45,6 +64,10 @@ type Logger struct {
 	t0        time.Time
 	level     Level
 	flags     uint32
+	format    string
+
+	root   *Logger // writes the messages of loggers derived by With, nil for root loggers
+	fields []Field
 }
 
 func New() *Logger {
@@ -53,6 +76,22 @@ func New() *Logger {
 		errWriter: os.Stderr,
 		t0:        time.Now(),
 		level:     parseLogLevel(config.LogLevel),
+		format:    config.LogFormat,
+	}
+	return l
+}
+
+// With returns a logger writing to the output of l, whose messages have the fields of l and the given fields.
+func (l *Logger) With(fields ...Field) *Logger {
+	fs := make([]Field, 0, len(l.fields)+len(fields))
+	fs = append(fs, l.fields...)
+	fs = append(fs, fields...)
+	return &Logger{root: l.getRoot(), fields: fs}
+}
+
+func (l *Logger) getRoot() *Logger {
+	if l.root != nil {
+		return l.root
 	}
 	return l
 }
@@ -74,55 +113,112 @@ func fmtDuration(d time.Duration) string {
 	return fmt.Sprintf("%dd %02d:%02d:%02d %6.2fms", n, hh, mm, ss, float64(ns)/float64(time.Millisecond))
 }
 
-func (l *Logger) output(w io.Writer, prefix, format string, v ...interface{}) {
+var levelNames = map[Level]string{
+	Debug: `DEBUG`,
+	Info:  `INFO`,
+	Warn:  `WARN`,
+	Error: `ERROR`,
+}
+
+func (l *Logger) output(stderr bool, level Level, prefix string, fields []Field, format string, v ...interface{}) {
 	l.Lock()
 	defer l.Unlock()
 	d := time.Since(l.t0)
+	s := fmt.Sprintf(format, v...)
 	l.buf = l.buf[:0]
-	l.buf = append(l.buf, prefix...)
+	if l.format == JSON {
+		l.buf = appendJSON(l.buf, d, level, s, fields)
+	} else {
+		l.buf = l.appendText(l.buf, d, prefix, s, fields)
+	}
+	w := l.outWriter
+	if stderr {
+		w = l.errWriter
+	}
+	w.Write(l.buf)
+}
+
+func (l *Logger) appendText(buf []byte, d time.Duration, prefix, s string, fields []Field) []byte {
+	buf = append(buf, prefix...)
 	if l.flags&ShowTimestamp != 0 {
-		l.buf = append(l.buf, ' ', '[')
-		l.buf = append(l.buf, fmtDuration(d)...)
-		l.buf = append(l.buf, ']', ' ')
+		buf = append(buf, ' ', '[')
+		buf = append(buf, fmtDuration(d)...)
+		buf = append(buf, ']', ' ')
 	} else {
-		l.buf = append(l.buf, ' ')
+		buf = append(buf, ' ')
 	}
-	s := fmt.Sprintf(format, v...)
-	l.buf = append(l.buf, s...)
+	for _, f := range fields {
+		buf = append(buf, fmt.Sprintf("%s=%v ", f.Key, f.Value)...)
+	}
+	buf = append(buf, s...)
 	if len(s) == 0 || s[len(s)-1] != '\n' {
-		l.buf = append(l.buf, '\n')
+		buf = append(buf, '\n')
 	}
-	w.Write(l.buf)
+	return buf
+}
+
+func appendJSON(buf []byte, d time.Duration, level Level, s string, fields []Field) []byte {
+	buf = append(buf, `{"level":`...)
+	buf = appendJSONValue(buf, levelNames[level])
+	buf = append(buf, `,"time":`...)
+	buf = appendJSONValue(buf, time.Now().Format(time.RFC3339Nano))
+	buf = append(buf, `,"uptime":`...)
+	buf = appendJSONValue(buf, d.Seconds())
+	buf = append(buf, `,"msg":`...)
+	buf = appendJSONValue(buf, strings.TrimSuffix(s, "\n"))
+	for _, f := range fields {
+		buf = append(buf, ',')
+		buf = appendJSONValue(buf, f.Key)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, f.Value)
+	}
+	return append(buf, '}', '\n')
+}
+
+func appendJSONValue(buf []byte, v interface{}) []byte {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		bs, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return append(buf, bs...)
 }
 
-func (l *Logger) logf(w io.Writer, level Level, prefix, format string, v ...interface{}) {
-	if level >= l.level {
-		l.output(w, prefix, format, v...)
+func (l *Logger) logf(stderr bool, level Level, prefix, format string, v ...interface{}) {
+	r := l.getRoot()
+	if level >= r.getLevel() {
+		r.output(stderr, level, prefix, l.fields, format, v...)
 	}
 }
 
+func (l *Logger) getLevel() Level {
+	l.Lock()
+	defer l.Unlock()
+	return l.level
+}
+
 func (l *Logger) Debugf(format string, v ...interface{}) {
-	l.logf(l.outWriter, Debug, "[D]", format, v...)
+	l.logf(false, Debug, "[D]", format, v...)
 }
 
 func (l *Logger) Infof(format string, v ...interface{}) {
-	l.logf(l.outWriter, Info, "[I]", format, v...)
+	l.logf(false, Info, "[I]", format, v...)
 }
 
 func (l *Logger) Warnf(format string, v ...interface{}) {
-	l.logf(l.errWriter, Warn, "[W]", format, v...)
+	l.logf(true, Warn, "[W]", format, v...)
 }
 
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.logf(l.errWriter, Error, xterm.Warn.S("[E]"), format, v...)
+	l.logf(true, Error, xterm.Warn.S("[E]"), format, v...)
 }
 
 func (l *Logger) Exitf(format string, v ...interface{}) {
-	l.logf(l.errWriter, Error, xterm.Warn.S("[F]"), format, v...)
+	l.logf(true, Error, xterm.Warn.S("[F]"), format, v...)
 	os.Exit(1)
 }
 
 func (l *Logger) SetOutput(w io.Writer) {
+	l = l.getRoot()
 	l.Lock()
 	defer l.Unlock()
 	l.outWriter = w
@@ -134,11 +230,20 @@ func (l *Logger) SetFlags(fs ...uint32) {
 	for _, f := range fs {
 		flags |= f
 	}
+	l = l.getRoot()
 	l.Lock()
 	defer l.Unlock()
 	l.flags = flags
 }
 
+// SetFormat sets the output format of l and the loggers derived from it, Text or JSON.
+func (l *Logger) SetFormat(format string) {
+	l = l.getRoot()
+	l.Lock()
+	defer l.Unlock()
+	l.format = format
+}
+
 var (
 	Debugf    = std.Debugf
 	Infof     = std.Infof
@@ -147,4 +252,6 @@ var (
 	Exitf     = std.Exitf
 	SetFlags  = std.SetFlags
 	SetOutput = std.SetOutput
+	SetFormat = std.SetFormat
+	With      = std.With
 )
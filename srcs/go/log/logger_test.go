@@ -0,0 +1,74 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTestLogger(format string) (*Logger, *bytes.Buffer) {
+	var b bytes.Buffer
+	l := New()
+	l.SetOutput(&b)
+	l.SetFormat(format)
+	l.level = Debug
+	return l, &b
+}
+
+func Test_JSON(t *testing.T) {
+	l, b := newTestLogger(JSON)
+	l.With(F("rank", 1)).With(F("session", "a\"b")).Warnf("took %d\n", 3)
+	l.Infof("root")
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines: %q", len(lines), b.String())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", lines[0], err)
+	}
+	for k, want := range map[string]interface{}{"level": "WARN", "msg": "took 3", "rank": 1.0, "session": `a"b`} {
+		if m[k] != want {
+			t.Errorf("%s: %v, want %v", k, m[k], want)
+		}
+	}
+	for _, k := range []string{"time", "uptime"} {
+		if _, ok := m[k]; !ok {
+			t.Errorf("no %s in %q", k, lines[0])
+		}
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil || m["msg"] != "root" || m["level"] != "INFO" {
+		t.Errorf("unexpected message of the root logger %q: %v", lines[1], err)
+	}
+}
+
+func Test_JSONUnencodableField(t *testing.T) {
+	l, b := newTestLogger(JSON)
+	l.With(F("f", func() {}), F("ch", make(chan int))).Errorf("x")
+	var m map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b.String(), err)
+	}
+	if _, ok := m["f"].(string); !ok {
+		t.Errorf("field of a func written as %v, want a string", m["f"])
+	}
+}
+
+func Test_Level(t *testing.T) {
+	l, b := newTestLogger(JSON)
+	l.level = Warn
+	l.With(F("rank", 0)).Infof("hidden")
+	l.Debugf("hidden")
+	if b.Len() > 0 {
+		t.Errorf("wrote %q below the level", b.String())
+	}
+}
+
+func Test_TextFields(t *testing.T) {
+	l, b := newTestLogger(Text)
+	l.With(F("rank", 2)).Infof("hello")
+	if got, want := b.String(), "[I] rank=2 hello\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
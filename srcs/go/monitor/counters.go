@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -66,6 +67,12 @@ func (r *rate) update(p time.Duration) {
 	r.prev = now
 }
 
+func (r *rate) Get() float64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.value
+}
+
 func (r *rate) WriteTo(w io.Writer) {
 	r.Lock()
 	defer r.Unlock()
@@ -95,12 +102,14 @@ type rateAccumulatorGroup struct {
 	sync.Mutex
 
 	prefix           string
+	help             string // of the bytes counted
 	rateAccumulators map[string]*rateAccumulator
 }
 
-func newRateAccumulatorGroup(prefix string) *rateAccumulatorGroup {
+func newRateAccumulatorGroup(prefix, help string) *rateAccumulatorGroup {
 	return &rateAccumulatorGroup{
 		prefix:           prefix,
+		help:             help,
 		rateAccumulators: make(map[string]*rateAccumulator),
 	}
 }
@@ -127,10 +136,21 @@ func (g *rateAccumulatorGroup) update(p time.Duration) {
 	}
 }
 
+// WriteTo writes the totals and the rates of all peers as two metric families.
 func (g *rateAccumulatorGroup) WriteTo(w io.Writer) {
 	g.Lock()
 	defer g.Unlock()
-	for _, ra := range g.rateAccumulators {
-		ra.WriteTo(w)
+	labels := make([]string, 0, len(g.rateAccumulators))
+	for l := range g.rateAccumulators {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	var totals, rates []Sample
+	for _, l := range labels {
+		ra := g.rateAccumulators[l]
+		totals = append(totals, Sample{Labels: l, Value: float64(ra.a.Get())})
+		rates = append(rates, Sample{Labels: l, Value: ra.r.Get()})
 	}
+	WriteFamily(w, g.prefix+"_total_"+totalUnitSuffix, Counter, "Total "+g.help+".", totals)
+	WriteFamily(w, g.prefix+"_rate_"+rateUnitSuffix, Gauge, "Rate of "+g.help+" per second.", rates)
 }
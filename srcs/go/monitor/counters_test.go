@@ -2,6 +2,7 @@ package monitor
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
@@ -16,14 +17,33 @@ func Test_rateAccumulator(t *testing.T) {
 	nm.Egress(3, a)
 	nm.Ingress(2, a)
 	nm.writeTo(&b)
+	want := fmt.Sprintf(`# HELP egress_total_bytes Total bytes sent to each peer.
+# TYPE egress_total_bytes counter
+egress_total_bytes{peer="%[1]s"} 3
+# HELP egress_rate_bytes_per_sec Rate of bytes sent to each peer per second.
+# TYPE egress_rate_bytes_per_sec gauge
+egress_rate_bytes_per_sec{peer="%[1]s"} 0
+# HELP ingress_total_bytes Total bytes received from each peer.
+# TYPE ingress_total_bytes counter
+ingress_total_bytes{peer="%[1]s"} 2
+# HELP ingress_rate_bytes_per_sec Rate of bytes received from each peer per second.
+# TYPE ingress_rate_bytes_per_sec gauge
+ingress_rate_bytes_per_sec{peer="%[1]s"} 0
+`, a)
+	if got := b.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
 
-	// FIXME: add assert
-	// 	const want = `egress_total_bytes 3
-	// egress_rate_bytes_per_sec 0.000000
-	// ingress_total_bytes 2
-	// ingress_rate_bytes_per_sec 0.000000
-	// `
-	// 	if got := b.String(); got != want {
-	// 		t.Errorf("want %q, got %q", want, got)
-	// 	}
+func Test_WriteFamily(t *testing.T) {
+	var b bytes.Buffer
+	WriteFamily(&b, "x", Gauge, "An x.", nil)
+	if b.Len() > 0 {
+		t.Errorf("wrote %q of no samples", b.String())
+	}
+	WriteFamily(&b, "x", Gauge, "An x.", []Sample{{Labels: `{a="1"}`, Value: 0.5}, {Labels: `{a="2"}`, Value: 3}})
+	const want = "# HELP x An x.\n# TYPE x gauge\nx{a=\"1\"} 0.5\nx{a=\"2\"} 3\n"
+	if got := b.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
 }
@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Types of metric families in the Prometheus text format.
+const (
+	Counter = `counter`
+	Gauge   = `gauge`
+)
+
+// A Sample is a value of a metric family, with its labels in the Prometheus text format, e.g. {peer="..."}, or none.
+type Sample struct {
+	Labels string
+	Value  float64
+}
+
+// WriteFamily writes the samples of the metric family name after its HELP and TYPE lines, as the Prometheus text format
+// requires all samples of a family together after them. It writes nothing if there are no samples.
+func WriteFamily(w io.Writer, name, typ, help string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, s.Labels, strconv.FormatFloat(s.Value, 'g', -1, 64))
+	}
+}
@@ -3,6 +3,7 @@ package monitor
 import (
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
@@ -32,6 +33,27 @@ func GetMonitor() Monitor {
 	return monitor
 }
 
+var (
+	reportsMu sync.Mutex
+	reports   []func(w io.Writer)
+)
+
+// AddReport adds a report written by the monitoring endpoint after the network counters, in the same format,
+// e.g. by WriteFamily.
+func AddReport(f func(w io.Writer)) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	reports = append(reports, f)
+}
+
+func writeReports(w io.Writer) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	for _, f := range reports {
+		f(w)
+	}
+}
+
 type noopMonitor struct {
 }
 
@@ -53,8 +75,8 @@ func newMonitor(p time.Duration) Monitor {
 		return &noopMonitor{}
 	}
 	m := &netMetrics{
-		egressCounters:  newRateAccumulatorGroup("egress"),
-		ingressCounters: newRateAccumulatorGroup("ingress"),
+		egressCounters:  newRateAccumulatorGroup("egress", "bytes sent to each peer"),
+		ingressCounters: newRateAccumulatorGroup("ingress", "bytes received from each peer"),
 	}
 	if p > 0 {
 		go m.start(p)
@@ -82,6 +104,7 @@ func (m *netMetrics) Ingress(n int64, a plan.NetAddr) {
 func (m *netMetrics) WriteTo(w io.Writer) {
 	m.egressCounters.WriteTo(w)
 	m.ingressCounters.WriteTo(w)
+	writeReports(w)
 }
 
 func (m *netMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,17 @@
+package plan
+
+// Capability describes the hardware of a peer, it is gathered by all peers of a session.
+type Capability struct {
+	GPUModel    string `json:"gpu_model,omitempty"`
+	NICSpeed    int    `json:"nic_speed_mbps,omitempty"` // the fastest NIC of the host in Mbit/s, 0 if unknown
+	MemoryBytes uint64 `json:"memory_bytes,omitempty"`
+}
+
+// NICSpeeds returns the NIC speed of each peer, in the order of caps.
+func NICSpeeds(caps []Capability) []int {
+	speeds := make([]int, len(caps))
+	for i, c := range caps {
+		speeds[i] = c.NICSpeed
+	}
+	return speeds
+}
@@ -27,6 +27,11 @@ func (c Cluster) Bytes() []byte {
 	return b.Bytes()
 }
 
+// Members returns the runners and the workers of c.
+func (c Cluster) Members() PeerList {
+	return append(c.Runners.Clone(), c.Workers...)
+}
+
 func (c Cluster) DebugString() string {
 	return fmt.Sprintf("[%d@%d]{%s}@{%s}", len(c.Workers), len(c.Runners), c.Workers, c.Runners)
 }
@@ -84,6 +89,25 @@ func (c *Cluster) growOne() error {
 			ipv4 = r.IPv4
 		}
 	}
+	c.growOn(ipv4)
+	return nil
+}
+
+// GrowOn appends n workers to the runner of host ipv4.
+func (c *Cluster) GrowOn(ipv4 uint32, n int) {
+	for i := 0; i < n; i++ {
+		c.growOn(ipv4)
+	}
+}
+
+// Without returns the cluster without the workers in leaving.
+func (c Cluster) Without(leaving PeerList) Cluster {
+	d := c.Clone()
+	d.Workers = d.Workers.sub(leaving)
+	return d
+}
+
+func (c *Cluster) growOn(ipv4 uint32) {
 	var port uint16
 	for _, w := range c.Workers {
 		if w.IPv4 == ipv4 && port <= w.Port {
@@ -95,7 +119,6 @@ func (c *Cluster) growOne() error {
 	}
 	newWorker := PeerID{IPv4: ipv4, Port: port}
 	c.Workers = append(c.Workers, newWorker)
-	return nil
 }
 
 func (c Cluster) Resize(newSize int) (*Cluster, error) {
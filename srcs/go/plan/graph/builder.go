@@ -0,0 +1,189 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Pair is a pair of reduce and broadcast graphs, which together perform an AllReduce.
+type Pair struct {
+	Reduce *Graph
+	Bcast  *Graph
+}
+
+// A Builder builds a validated Pair.
+type Builder interface {
+	Build() (*Pair, error)
+}
+
+var errEmptyGraph = errors.New("graph has no node")
+
+// DefaultReduceGraph reverses a broadcast tree and adds self loops to all nodes.
+func DefaultReduceGraph(g *Graph) *Graph {
+	r := g.Reverse()
+	for i := range r.Nodes {
+		r.AddEdge(i, i)
+	}
+	return r
+}
+
+// RingBuilder builds a ring of n nodes, the reduction ends at node r and the broadcast starts from it.
+type RingBuilder struct {
+	n int
+	r int
+}
+
+func NewRing(n int) *RingBuilder {
+	return &RingBuilder{n: n}
+}
+
+func (b *RingBuilder) WithOffset(r int) *RingBuilder {
+	b.r = r
+	return b
+}
+
+func (b *RingBuilder) Build() (*Pair, error) {
+	k := b.n
+	if k <= 0 {
+		return nil, errEmptyGraph
+	}
+	if b.r < 0 || b.r >= k {
+		return nil, fmt.Errorf("ring offset %d out of range [0, %d)", b.r, k)
+	}
+	rg := New(k)
+	for i := 0; i < k; i++ {
+		rg.AddEdge(i, i)
+	}
+	bg := New(k)
+	for i := 1; i < k; i++ {
+		rg.AddEdge((b.r+i)%k, (b.r+i+1)%k)
+		bg.AddEdge((b.r+i-1)%k, (b.r+i)%k)
+	}
+	return validated(rg, bg)
+}
+
+// TreeBuilder builds a tree of n nodes rooted at a given node, reduce along the tree and broadcast along the reverse.
+// Without arity set, the tree is a star.
+type TreeBuilder struct {
+	n     int
+	root  int
+	arity int
+}
+
+func NewTree(n int) *TreeBuilder {
+	return &TreeBuilder{n: n}
+}
+
+func (b *TreeBuilder) RootedAt(r int) *TreeBuilder {
+	b.root = r
+	return b
+}
+
+// Arity sets the maximum number of children of each node.
+func (b *TreeBuilder) Arity(k int) *TreeBuilder {
+	b.arity = k
+	return b
+}
+
+func (b *TreeBuilder) Binary() *TreeBuilder {
+	return b.Arity(2)
+}
+
+func (b *TreeBuilder) Star() *TreeBuilder {
+	return b.Arity(0)
+}
+
+func (b *TreeBuilder) Build() (*Pair, error) {
+	k := b.n
+	if k <= 0 {
+		return nil, errEmptyGraph
+	}
+	if b.root < 0 || b.root >= k {
+		return nil, fmt.Errorf("tree root %d out of range [0, %d)", b.root, k)
+	}
+	if b.arity < 0 {
+		return nil, fmt.Errorf("invalid tree arity %d", b.arity)
+	}
+	arity := b.arity
+	if arity == 0 {
+		arity = k - 1
+	}
+	// nodes are placed in BFS order starting from the root
+	idx := func(i int) int { return (i + b.root) % k }
+	bg := New(k)
+	for i := 0; i < k; i++ {
+		for j := i*arity + 1; j <= i*arity+arity && j < k; j++ {
+			bg.AddEdge(idx(i), idx(j))
+		}
+	}
+	return validated(DefaultReduceGraph(bg), bg)
+}
+
+func validated(rg, bg *Graph) (*Pair, error) {
+	p := &Pair{Reduce: rg, Bcast: bg}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks that Bcast is a spanning tree, and Reduce collects all nodes into the root of Bcast.
+func (p *Pair) Validate() error {
+	n := len(p.Bcast.Nodes)
+	if n == 0 {
+		return errEmptyGraph
+	}
+	if len(p.Reduce.Nodes) != n {
+		return fmt.Errorf("reduce graph has %d nodes, broadcast graph has %d", len(p.Reduce.Nodes), n)
+	}
+	f, err := p.Bcast.ForestArray()
+	if err != nil {
+		return err
+	}
+	root := -1
+	for i, father := range f {
+		if int(father) == i {
+			if root >= 0 {
+				return fmt.Errorf("broadcast graph has more than one root: %d and %d", root, i)
+			}
+			root = i
+		}
+	}
+	if root < 0 {
+		return errors.New("broadcast graph has no root")
+	}
+	for i := range f {
+		if !reaches(n, i, root, func(j int) []int {
+			if j == root {
+				return nil
+			}
+			return []int{int(f[j])}
+		}) {
+			return fmt.Errorf("node %d is not reachable from the root %d", i, root)
+		}
+	}
+	for i, node := range p.Reduce.Nodes {
+		if !node.SelfLoop {
+			return fmt.Errorf("node %d of reduce graph has no self loop", i)
+		}
+		if !reaches(n, i, root, p.Reduce.Nexts) {
+			return fmt.Errorf("node %d of reduce graph doesn't reach the root %d", i, root)
+		}
+	}
+	return nil
+}
+
+// reaches checks that all paths from i following nexts end at root, without cycles.
+func reaches(n, i, root int, nexts func(int) []int) bool {
+	for steps := 0; steps <= n; steps++ {
+		if i == root {
+			return true
+		}
+		ns := nexts(i)
+		if len(ns) != 1 {
+			return false
+		}
+		i = ns[0]
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var dotEdge = regexp.MustCompile(`(\d+)\s*->\s*(\d+)`)
+
+var errNoEdge = errors.New("no edge found")
+
+// FromDOT creates a Graph from the edges (i -> j) of a DOT digraph, other statements are ignored.
+// The graph has max(i, j) + 1 nodes.
+func FromDOT(text string) (*Graph, error) {
+	ms := dotEdge.FindAllStringSubmatch(text, -1)
+	if len(ms) == 0 {
+		return nil, errNoEdge
+	}
+	var edges [][2]int
+	var n int
+	for _, m := range ms {
+		i, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, err
+		}
+		j, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, [2]int{i, j})
+		if i >= n {
+			n = i + 1
+		}
+		if j >= n {
+			n = j + 1
+		}
+	}
+	g := New(n)
+	for _, e := range edges {
+		g.AddEdge(e[0], e[1])
+	}
+	return g, nil
+}
+
+// ForestArray is the inverse of FromForestArray, it fails if any node has more than one father.
+func (g *Graph) ForestArray() ([]int32, error) {
+	f := make([]int32, len(g.Nodes))
+	for i, n := range g.Nodes {
+		switch len(n.Prevs) {
+		case 0:
+			f[i] = int32(i)
+		case 1:
+			f[i] = int32(n.Prevs[0])
+		default:
+			return nil, fmt.Errorf("node %d has %d fathers", i, len(n.Prevs))
+		}
+	}
+	return f, nil
+}
@@ -107,6 +107,22 @@ func (g Graph) Reverse() *Graph {
 	return r
 }
 
+// Relabel returns a copy of g, in which vertex i is renamed to f(i). f must be a permutation.
+func (g Graph) Relabel(f func(int) int) *Graph {
+	r := New(len(g.Nodes))
+	for i, n := range g.Nodes {
+		m := &r.Nodes[f(i)]
+		m.SelfLoop = n.SelfLoop
+		for _, j := range n.Nexts {
+			m.Nexts.Append(f(j))
+		}
+		for _, j := range n.Prevs {
+			m.Prevs.Append(f(j))
+		}
+	}
+	return r
+}
+
 func (g *Graph) DebugString() string {
 	b := &bytes.Buffer{}
 	fmt.Fprintf(b, "[%d]{", len(g.Nodes))
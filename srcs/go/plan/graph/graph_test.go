@@ -29,3 +29,50 @@ func Test_FromForestArrayI32(t *testing.T) {
 		assert.True(m == 0)
 	}
 }
+
+func Test_FromDOT(t *testing.T) {
+	g, err := FromDOT(`digraph { 0 -> 1; 0 -> 2; 2 -> 3 }`)
+	assert.OK(err)
+	f, err := g.ForestArray()
+	assert.OK(err)
+	want := []int32{0, 0, 0, 2}
+	for i := range want {
+		if f[i] != want[i] {
+			t.Errorf("f[%d] = %d, want %d", i, f[i], want[i])
+		}
+	}
+	g, err = FromDOT(`digraph { 0 -> 2; 1 -> 2 }`)
+	assert.OK(err)
+	if _, err := g.ForestArray(); err == nil {
+		t.Errorf("expect error for node with 2 fathers")
+	}
+}
+
+func Test_Builder(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		for r := 0; r < n; r++ {
+			for _, b := range []Builder{
+				NewRing(n).WithOffset(r),
+				NewTree(n).RootedAt(r),
+				NewTree(n).RootedAt(r).Binary(),
+				NewTree(n).RootedAt(r).Arity(3),
+			} {
+				p, err := b.Build()
+				assert.OK(err)
+				f, err := p.Bcast.ForestArray()
+				assert.OK(err)
+				if int(f[r]) != r {
+					t.Errorf("root of %s is not %d", p.Bcast.DebugString(), r)
+				}
+			}
+		}
+	}
+	if _, err := NewRing(4).WithOffset(4).Build(); err == nil {
+		t.Errorf("expect error for out of range offset")
+	}
+	bg := New(3)
+	bg.AddEdge(0, 1)
+	if err := (&Pair{Reduce: DefaultReduceGraph(bg), Bcast: bg}).Validate(); err == nil {
+		t.Errorf("expect error for unreachable node")
+	}
+}
@@ -0,0 +1,86 @@
+package plan
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Groups maps the names of peer groups to the hosts in each group, all peers of a host are in the groups of the host.
+// Groups are declared in the host spec, e.g. 192.168.1.11:4:host1:zone-a+evaluators
+type Groups map[string][]uint32
+
+var errInvalidGroups = errors.New("invalid groups")
+
+func isValidGroupName(name string) bool {
+	return len(name) > 0 && !strings.ContainsAny(name, ":,+=;")
+}
+
+// Groups returns the groups declared by the hosts.
+func (hl HostList) Groups() Groups {
+	gs := make(Groups)
+	for _, h := range hl {
+		for _, name := range h.Groups {
+			gs[name] = append(gs[name], h.IPv4)
+		}
+	}
+	return gs
+}
+
+// String formats Groups as name=ipv4+ipv4;name=ipv4, sorted by name.
+func (gs Groups) String() string {
+	var names []string
+	for name := range gs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		var hosts []string
+		for _, ipv4 := range gs[name] {
+			hosts = append(hosts, FormatIPv4(ipv4))
+		}
+		parts = append(parts, name+"="+strings.Join(hosts, "+"))
+	}
+	return strings.Join(parts, ";")
+}
+
+func ParseGroups(val string) (Groups, error) {
+	gs := make(Groups)
+	if len(val) == 0 {
+		return gs, nil
+	}
+	for _, part := range strings.Split(val, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || !isValidGroupName(kv[0]) {
+			return nil, errInvalidGroups
+		}
+		for _, h := range strings.Split(kv[1], "+") {
+			ipv4, err := ParseIPv4(h)
+			if err != nil {
+				return nil, err
+			}
+			gs[kv[0]] = append(gs[kv[0]], ipv4)
+		}
+	}
+	return gs, nil
+}
+
+// Select returns the peers of pl in the named group, in the order of pl.
+func (gs Groups) Select(name string, pl PeerList) (PeerList, bool) {
+	hosts, ok := gs[name]
+	if !ok {
+		return nil, false
+	}
+	in := make(map[uint32]bool)
+	for _, ipv4 := range hosts {
+		in[ipv4] = true
+	}
+	var members PeerList
+	for _, p := range pl {
+		if in[p.IPv4] {
+			members = append(members, p)
+		}
+	}
+	return members, true
+}
@@ -0,0 +1,115 @@
+package plan
+
+import "github.com/lsds/KungFu/srcs/go/plan/graph"
+
+// GenHierarchy partitions peers into a three-level hierarchy: peers (GPUs) of the same host, hosts of the same rack, and racks.
+// racks[i] is the rack of peers[i]. It returns the broadcast graph of each level from the innermost,
+// each graph has all peers as nodes but only the edges of its level.
+//
+// The first peer of a host is the host master, the first host master of a rack is the rack master.
+// Host masters are connected by a binary tree within each rack, and rack masters are connected by a binary tree.
+func GenHierarchy(peers PeerList, racks []string) []*graph.Graph {
+	n := len(peers)
+	hostLevel := graph.New(n)
+	masters, masterOf := peers.PartitionByHost()
+	for rank, master := range masterOf {
+		if master != rank {
+			hostLevel.AddEdge(master, rank)
+		}
+	}
+	var rackNames []string
+	rackMasters := make(map[string][]int)
+	for _, rank := range masters {
+		r := racks[rank]
+		if _, ok := rackMasters[r]; !ok {
+			rackNames = append(rackNames, r)
+		}
+		rackMasters[r] = append(rackMasters[r], rank)
+	}
+	rackLevel := graph.New(n)
+	var rackRoots []int
+	for _, r := range rackNames {
+		addBinaryTree(rackLevel, rackMasters[r])
+		rackRoots = append(rackRoots, rackMasters[r][0])
+	}
+	crossLevel := graph.New(n)
+	addBinaryTree(crossLevel, rackRoots)
+	return []*graph.Graph{hostLevel, rackLevel, crossLevel}
+}
+
+// HierarchySchedule returns the graphs of an AllReduce over the levels of a hierarchy, to be run in order:
+// reduce from the innermost level to the outermost, then broadcast from the outermost level to the innermost.
+func HierarchySchedule(levels []*graph.Graph) []*graph.Graph {
+	var gs []*graph.Graph
+	for _, g := range levels {
+		gs = append(gs, GenDefaultReduceGraph(g))
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		gs = append(gs, levels[i])
+	}
+	return gs
+}
+
+// GenTwoLevelGraphPairs returns the graph pairs of a two-level AllReduce on peers of multiple GPUs per host:
+// peers reduce to the master of their host first, host masters reduce across hosts by a ring or a binary tree,
+// then they broadcast back, and host masters broadcast to the peers of their host.
+// With rings, there is a pair rooted at each host master, so that chunks spread over the NICs of all hosts.
+func GenTwoLevelGraphPairs(peers PeerList, ring bool) []*graph.Pair {
+	n := len(peers)
+	hostLevel := graph.New(n)
+	masters, masterOf := peers.PartitionByHost()
+	for rank, master := range masterOf {
+		if master != rank {
+			hostLevel.AddEdge(master, rank)
+		}
+	}
+	var crossLevels []*graph.Graph
+	if ring {
+		k := len(masters)
+		for r := 0; r < k; r++ {
+			g := graph.New(n)
+			for i := 1; i < k; i++ {
+				g.AddEdge(masters[(r+i-1)%k], masters[(r+i)%k])
+			}
+			crossLevels = append(crossLevels, g)
+		}
+	} else {
+		g := graph.New(n)
+		addBinaryTree(g, masters)
+		crossLevels = append(crossLevels, g)
+	}
+	var ps []*graph.Pair
+	for _, g := range crossLevels {
+		bcastGraph := MergeGraphs(g, hostLevel)
+		ps = append(ps, &graph.Pair{Reduce: GenDefaultReduceGraph(bcastGraph), Bcast: bcastGraph})
+	}
+	return ps
+}
+
+// MergeGraphs returns the union of the edges of graphs with the same nodes.
+func MergeGraphs(gs ...*graph.Graph) *graph.Graph {
+	g := graph.New(len(gs[0].Nodes))
+	for _, h := range gs {
+		for i, node := range h.Nodes {
+			if node.SelfLoop {
+				g.AddEdge(i, i)
+			}
+			for _, j := range node.Nexts {
+				g.AddEdge(i, j)
+			}
+		}
+	}
+	return g
+}
+
+func addBinaryTree(g *graph.Graph, ranks []int) {
+	k := len(ranks)
+	for i := 0; i < k; i++ {
+		if j := i*2 + 1; j < k {
+			g.AddEdge(ranks[i], ranks[j])
+		}
+		if j := i*2 + 2; j < k {
+			g.AddEdge(ranks[i], ranks[j])
+		}
+	}
+}
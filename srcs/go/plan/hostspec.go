@@ -16,9 +16,13 @@ type HostSpec struct {
 	IPv4       uint32
 	Slots      int
 	PublicAddr string
+	Groups     []string // names of the groups the peers of this host belong to
 }
 
 func (h HostSpec) String() string {
+	if len(h.Groups) > 0 {
+		return fmt.Sprintf("%s:%d:%s:%s", FormatIPv4(h.IPv4), h.Slots, h.PublicAddr, strings.Join(h.Groups, "+"))
+	}
 	return fmt.Sprintf("%s:%d:%s", FormatIPv4(h.IPv4), h.Slots, h.PublicAddr)
 }
 
@@ -50,6 +54,18 @@ func parseHostSpec(spec string) (*HostSpec, error) {
 			return nil, ErrInvalidHostSpec
 		}
 		return &HostSpec{IPv4: ipv4, Slots: slots, PublicAddr: parts[2]}, nil
+	case 4:
+		slots, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, ErrInvalidHostSpec
+		}
+		groups := strings.Split(parts[3], "+")
+		for _, g := range groups {
+			if !isValidGroupName(g) {
+				return nil, ErrInvalidHostSpec
+			}
+		}
+		return &HostSpec{IPv4: ipv4, Slots: slots, PublicAddr: parts[2], Groups: groups}, nil
 	}
 	return nil, ErrInvalidHostSpec
 }
@@ -39,3 +39,30 @@ func Test_HostSpecList(t *testing.T) {
 		t.Errorf("expect %d, got %d", 0, n)
 	}
 }
+
+func Test_HostSpecGroups(t *testing.T) {
+	hl, err := ParseHostList("192.168.1.11:4:a:zone-a+evaluators,192.168.1.12:4:b:zone-a,192.168.1.13:2")
+	if err != nil {
+		t.Fatalf("unexpect error: %v", err)
+	}
+	if s := hl.String(); s != "192.168.1.11:4:a:zone-a+evaluators,192.168.1.12:4:b:zone-a,192.168.1.13:2:192.168.1.13" {
+		t.Errorf("unexpected host list %s", s)
+	}
+	gs, err := ParseGroups(hl.Groups().String())
+	if err != nil {
+		t.Fatalf("unexpect error: %v", err)
+	}
+	pl := hl.MustGenPeerList(10, DefaultPortRange)
+	if members, ok := gs.Select("zone-a", pl); !ok || len(members) != 8 {
+		t.Errorf("expect 8 peers in zone-a, got %d", len(members))
+	}
+	if members, ok := gs.Select("evaluators", pl); !ok || len(members) != 4 {
+		t.Errorf("expect 4 peers in evaluators, got %d", len(members))
+	}
+	if _, ok := gs.Select("none", pl); ok {
+		t.Errorf("expect group none not found")
+	}
+	if _, err := ParseHostList("192.168.1.11:4:a:bad=name"); err == nil {
+		t.Errorf("expect error for invalid group name")
+	}
+}
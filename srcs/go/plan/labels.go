@@ -0,0 +1,30 @@
+package plan
+
+// Labels are optional key-value hints attached to a peer, e.g. its cloud zone.
+// They are kept outside PeerID so that PeerID remains comparable and fixed-size.
+type Labels map[string]string
+
+const (
+	LabelProvider       = `provider`
+	LabelZone           = `zone`
+	LabelPlacementGroup = `placement-group`
+	LabelRack           = `rack`
+)
+
+// Zones returns the zone label of each peer, in the order of labels.
+func Zones(labels []Labels) []string {
+	zones := make([]string, len(labels))
+	for i, l := range labels {
+		zones[i] = l[LabelZone]
+	}
+	return zones
+}
+
+// Racks returns the rack label of each peer, in the order of labels.
+func Racks(labels []Labels) []string {
+	racks := make([]string, len(labels))
+	for i, l := range labels {
+		racks[i] = l[LabelRack]
+	}
+	return racks
+}
@@ -0,0 +1,122 @@
+package plan
+
+import (
+	"fmt"
+
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
+
+// Locality describes where a peer runs: the CPU socket (NUMA node) of its host that it is bound to,
+// and the NIC and the switch that it reaches other hosts through.
+type Locality struct {
+	Host   string `json:"host,omitempty"`
+	Socket int    `json:"socket"` // -1 if the peer is not bound to a single socket
+	NIC    string `json:"nic,omitempty"`
+	Switch string `json:"switch,omitempty"`
+}
+
+// Topology is the locality of each peer, Topology[i] is the locality of peers[i].
+type Topology []Locality
+
+// Sockets returns the number of distinct sockets of peers on each host, keyed by the IPv4 of hosts.
+func (t Topology) Sockets(peers PeerList) map[uint32]int {
+	seen := make(map[string]struct{})
+	sockets := make(map[uint32]int)
+	for i, p := range peers {
+		if k := fmt.Sprintf("%d/%d", p.IPv4, t[i].Socket); !hasKey(seen, k) {
+			seen[k] = struct{}{}
+			sockets[p.IPv4]++
+		}
+	}
+	return sockets
+}
+
+// Switches returns the distinct switches of peers, in the order of their first peer.
+func (t Topology) Switches() []string {
+	seen := make(map[string]struct{})
+	var switches []string
+	for _, l := range t {
+		if !hasKey(seen, l.Switch) {
+			seen[l.Switch] = struct{}{}
+			switches = append(switches, l.Switch)
+		}
+	}
+	return switches
+}
+
+func hasKey(m map[string]struct{}, k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// GenTopologyAwareLevels partitions peers into four levels: peers of the same socket, sockets of the same host,
+// hosts of the same switch, and switches. It returns the broadcast graph of each level from the innermost,
+// so that each hop of a level crosses only its boundary: a message crosses a socket once per host, and a switch once per switch.
+//
+// The first peer of a group is its master, and it joins the group of the next level.
+// Peers of a socket and sockets of a host are connected by stars, as they share memory,
+// host masters are connected by a binary tree within each switch, and switch masters are connected by a binary tree
+// whose order is rotated by offset.
+func GenTopologyAwareLevels(peers PeerList, topo Topology, offset int) []*graph.Graph {
+	levels, _ := genTopologyAwareLevels(peers, topo, offset)
+	return levels
+}
+
+// genTopologyAwareLevels returns the levels of GenTopologyAwareLevels and the number of switch masters.
+func genTopologyAwareLevels(peers PeerList, topo Topology, offset int) ([]*graph.Graph, int) {
+	n := len(peers)
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	socketLevel, socketMasters := groupLevel(n, all, func(i int) string { return fmt.Sprintf("%d/%d", peers[i].IPv4, topo[i].Socket) }, addStar)
+	hostLevel, hostMasters := groupLevel(n, socketMasters, func(i int) string { return fmt.Sprint(peers[i].IPv4) }, addStar)
+	switchLevel, switchMasters := groupLevel(n, hostMasters, func(i int) string { return topo[i].Switch }, addBinaryTree)
+	crossLevel := graph.New(n)
+	k := len(switchMasters)
+	rotated := make([]int, k)
+	for i := range rotated {
+		rotated[i] = switchMasters[(i+offset)%k]
+	}
+	addBinaryTree(crossLevel, rotated)
+	return []*graph.Graph{socketLevel, hostLevel, switchLevel, crossLevel}, k
+}
+
+// GenTopologyAwareStrategies returns the graph pairs of an AllReduce that minimizes the hops crossing sockets and switches,
+// see GenTopologyAwareLevels. There is a pair rooted at each switch master, so that the roots spread over switches.
+func GenTopologyAwareStrategies(peers PeerList, topo Topology) []*graph.Pair {
+	var ps []*graph.Pair
+	for i, k := 0, 1; i < k; i++ {
+		var levels []*graph.Graph
+		levels, k = genTopologyAwareLevels(peers, topo, i)
+		bcastGraph := MergeGraphs(levels...)
+		ps = append(ps, &graph.Pair{Reduce: GenDefaultReduceGraph(bcastGraph), Bcast: bcastGraph})
+	}
+	return ps
+}
+
+// groupLevel groups ranks by key, connects the ranks of each group by add, and returns the graph and the first rank of each group.
+func groupLevel(n int, ranks []int, key func(int) string, add func(*graph.Graph, []int)) (*graph.Graph, []int) {
+	var keys []string
+	groups := make(map[string][]int)
+	for _, rank := range ranks {
+		k := key(rank)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], rank)
+	}
+	g := graph.New(n)
+	var masters []int
+	for _, k := range keys {
+		add(g, groups[k])
+		masters = append(masters, groups[k][0])
+	}
+	return g, masters
+}
+
+func addStar(g *graph.Graph, ranks []int) {
+	for _, rank := range ranks[1:] {
+		g.AddEdge(ranks[0], rank)
+	}
+}
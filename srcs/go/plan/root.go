@@ -0,0 +1,41 @@
+package plan
+
+import "math"
+
+// MinCostRoot returns the most central vertex, where a tree should be rooted to minimize its completion time.
+// cost[i][j] is the cost of sending a message from i to j, e.g. its expected duration by the probed latency and bandwidth.
+// Messages may be forwarded by other vertices, so the distances are the costs of the shortest paths.
+// The root has the least cost of a round trip to the farthest vertex, ties are broken by the total cost, then by the index.
+func MinCostRoot(cost [][]float64) int {
+	n := len(cost)
+	d := make([][]float64, n)
+	for i := range d {
+		d[i] = make([]float64, n)
+		copy(d[i], cost[i])
+		d[i][i] = 0
+	}
+	for k := 0; k < n; k++ {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if x := d[i][k] + d[k][j]; x < d[i][j] {
+					d[i][j] = x
+				}
+			}
+		}
+	}
+	best, bestMax, bestSum := 0, math.Inf(1), math.Inf(1)
+	for r := 0; r < n; r++ {
+		var max, sum float64
+		for i := 0; i < n; i++ {
+			x := d[r][i] + d[i][r]
+			if x > max {
+				max = x
+			}
+			sum += x
+		}
+		if max < bestMax || (max == bestMax && sum < bestSum) {
+			best, bestMax, bestSum = r, max, sum
+		}
+	}
+	return best
+}
@@ -1,6 +1,10 @@
 package plan
 
-import "github.com/lsds/KungFu/srcs/go/plan/graph"
+import (
+	"sort"
+
+	"github.com/lsds/KungFu/srcs/go/plan/graph"
+)
 
 func getLocalMasters(peers PeerList) ([]int, map[uint32]int) {
 	var masters []int
@@ -31,12 +35,7 @@ func GenTree(peers PeerList) *graph.Graph {
 }
 
 func GenDefaultReduceGraph(g *graph.Graph) *graph.Graph {
-	g0 := g.Reverse()
-	k := len(g.Nodes)
-	for i := 0; i < k; i++ {
-		g0.AddEdge(i, i)
-	}
-	return g0
+	return graph.DefaultReduceGraph(g)
 }
 
 func GenBinaryTree(k int) *graph.Graph {
@@ -113,3 +112,102 @@ func GenCircularGraphPair(k, r int) (*graph.Graph, *graph.Graph) {
 	}
 	return g, b
 }
+
+// GenEdgeDisjointRingPairs generates the reduce and broadcast graphs of rings of k vertices which share no edges,
+// so that the chunks pipelined on different rings don't contend on the same links.
+// The j-th ring visits the vertices by a stride s coprime with k, from the root j,
+// it reduces along the edges i -> i + s and broadcasts along i -> i - s.
+// Strides s and k - s would use the same edges in opposite phases, so only s < k / 2 are used,
+// which gives phi(k) / 2 rings for k > 2, and a single ring otherwise.
+func GenEdgeDisjointRingPairs(k int) ([]*graph.Graph, []*graph.Graph) {
+	var strides []int
+	for s := 1; 2*s < k; s++ {
+		if gcd(s, k) == 1 {
+			strides = append(strides, s)
+		}
+	}
+	if len(strides) == 0 {
+		strides = []int{1}
+	}
+	var rgs, bgs []*graph.Graph
+	for j, s := range strides {
+		g := graph.New(k)
+		for i := 0; i < k; i++ {
+			g.AddEdge(i, i)
+		}
+		b := graph.New(k)
+		r := j % k
+		for i := 1; i < k; i++ {
+			g.AddEdge((r+i*s)%k, (r+(i+1)*s)%k)
+			b.AddEdge((r+(k-i+1)*s)%k, (r+(k-i)*s)%k)
+		}
+		rgs = append(rgs, g)
+		bgs = append(bgs, b)
+	}
+	return rgs, bgs
+}
+
+// GenPairwiseExchangeGraphs generates the k - 1 rounds of the pairwise exchange of k vertices, for all to all.
+// In the r-th round, vertex i sends to i + r and receives from i - r, so that no vertex receives from two at once.
+func GenPairwiseExchangeGraphs(k int) []*graph.Graph {
+	var gs []*graph.Graph
+	for r := 1; r < k; r++ {
+		g := graph.New(k)
+		for i := 0; i < k; i++ {
+			g.AddEdge(i, (i+r)%k)
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// GenZoneAwareBinaryTreeStar is like GenBinaryTreeStar, but host masters are first grouped by zone,
+// so that only len(zones)-1 edges cross zone boundaries. zones[i] is the zone of peers[i].
+func GenZoneAwareBinaryTreeStar(peers PeerList, zones []string) *graph.Graph {
+	g := graph.New(len(peers))
+	masters, hostMaster := getLocalMasters(peers)
+	for rank, p := range peers {
+		if master := hostMaster[p.IPv4]; master != rank {
+			g.AddEdge(master, rank)
+		}
+	}
+	var zoneNames []string
+	zoneMasters := make(map[string][]int)
+	for _, rank := range masters {
+		z := zones[rank]
+		if _, ok := zoneMasters[z]; !ok {
+			zoneNames = append(zoneNames, z)
+		}
+		zoneMasters[z] = append(zoneMasters[z], rank)
+	}
+	var zoneRoots []int
+	for _, z := range zoneNames {
+		addBinaryTree(g, zoneMasters[z])
+		zoneRoots = append(zoneRoots, zoneMasters[z][0])
+	}
+	addBinaryTree(g, zoneRoots)
+	return g
+}
+
+// GenBandwidthAwareBinaryTreeStar is like GenBinaryTreeStar, but host masters with faster NICs are placed closer to the root,
+// so that they relay more traffic. speeds[i] is the NIC speed of peers[i]. The root remains the host master of rank 0.
+func GenBandwidthAwareBinaryTreeStar(peers PeerList, speeds []int) *graph.Graph {
+	g := graph.New(len(peers))
+	masters, hostMaster := getLocalMasters(peers)
+	for rank, p := range peers {
+		if master := hostMaster[p.IPv4]; master != rank {
+			g.AddEdge(master, rank)
+		}
+	}
+	others := append([]int{}, masters[1:]...)
+	sort.SliceStable(others, func(i, j int) bool { return speeds[others[i]] > speeds[others[j]] })
+	addBinaryTree(g, append([]int{masters[0]}, others...))
+	return g
+}
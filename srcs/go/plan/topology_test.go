@@ -96,3 +96,230 @@ func Test_trees(t *testing.T) {
 		}
 	}
 }
+
+func Test_zone_aware_tree(t *testing.T) {
+	peers := PeerList{
+		{1, 1}, // 0 a
+		{1, 2}, // 1 a
+		{2, 1}, // 2 b
+		{3, 1}, // 3 a
+		{4, 1}, // 4 b
+		{5, 1}, // 5 c
+		{5, 2}, // 6 c
+	}
+	zones := []string{"a", "a", "b", "a", "b", "c", "c"}
+	g := GenZoneAwareBinaryTreeStar(peers, zones)
+	if !isValidTreeWithRoot(g, 0) {
+		t.Errorf("zone aware binary tree star not generated correctly")
+	}
+	var cross int
+	for i, n := range g.Nodes {
+		for _, j := range n.Nexts {
+			if zones[i] != zones[j] {
+				cross++
+			}
+		}
+	}
+	if cross != 2 {
+		t.Errorf("expect 2 cross zone edges, got %d", cross)
+	}
+}
+
+func Test_hierarchy(t *testing.T) {
+	peers := PeerList{
+		{1, 1}, // 0 r1
+		{1, 2}, // 1 r1
+		{2, 1}, // 2 r2
+		{3, 1}, // 3 r1
+		{3, 2}, // 4 r1
+		{4, 1}, // 5 r2
+		{5, 1}, // 6 r3
+	}
+	racks := []string{"r1", "r1", "r2", "r1", "r1", "r2", "r3"}
+	levels := GenHierarchy(peers, racks)
+	if len(levels) != 3 {
+		t.Fatalf("expect 3 levels, got %d", len(levels))
+	}
+	if g := MergeGraphs(levels...); !isValidTreeWithRoot(g, 0) {
+		t.Errorf("hierarchy not generated correctly")
+	}
+	for i, n := range levels[0].Nodes {
+		for _, j := range n.Nexts {
+			if peers[i].IPv4 != peers[j].IPv4 {
+				t.Errorf("host level edge (%d, %d) crosses hosts", i, j)
+			}
+		}
+	}
+	for i, n := range levels[1].Nodes {
+		for _, j := range n.Nexts {
+			if racks[i] != racks[j] {
+				t.Errorf("rack level edge (%d, %d) crosses racks", i, j)
+			}
+		}
+	}
+	if gs := HierarchySchedule(levels); len(gs) != 6 {
+		t.Errorf("expect 6 stages, got %d", len(gs))
+	}
+}
+
+func Test_bandwidth_aware_tree(t *testing.T) {
+	peers := PeerList{
+		{1, 1}, // 0
+		{1, 2}, // 1
+		{2, 1}, // 2
+		{3, 1}, // 3
+		{4, 1}, // 4
+	}
+	speeds := []int{10000, 10000, 1000, 1000, 100000}
+	g := GenBandwidthAwareBinaryTreeStar(peers, speeds)
+	if !isValidTreeWithRoot(g, 0) {
+		t.Errorf("bandwidth aware binary tree star not generated correctly")
+	}
+	if len(g.Nexts(4)) != 1 || g.Prevs(4)[0] != 0 {
+		t.Errorf("the fastest host is expected to be a child of the root with children")
+	}
+}
+
+func Test_edge_disjoint_rings(t *testing.T) {
+	for k, want := range map[int]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 2, 7: 3, 8: 2, 16: 4} {
+		rgs, bgs := GenEdgeDisjointRingPairs(k)
+		if len(rgs) != want || len(bgs) != want {
+			t.Errorf("%d rings of %d vertices, want %d", len(rgs), k, want)
+		}
+		used := make(map[edge]bool)
+		for r := range rgs {
+			for _, g := range []*graph.Graph{rgs[r], bgs[r]} {
+				var n int
+				for i, node := range g.Nodes {
+					for _, j := range node.Nexts {
+						if i == j {
+							continue
+						}
+						if used[edge{i, j}] {
+							t.Errorf("edge %d -> %d of %d vertices is used twice", i, j, k)
+						}
+						used[edge{i, j}] = true
+						n++
+					}
+				}
+				if n != k-1 {
+					t.Errorf("ring %d of %d vertices has %d edges", r, k, n)
+				}
+			}
+			if !isValidTreeWithRoot(bgs[r], r%k) {
+				t.Errorf("broadcast graph of ring %d of %d vertices is not a path from %d", r, k, r%k)
+			}
+		}
+	}
+}
+
+func Test_min_cost_root(t *testing.T) {
+	// 1 is close to all the others, which are far from each other
+	cost := [][]float64{
+		{0, 1, 9, 9},
+		{1, 0, 1, 1},
+		{9, 1, 0, 9},
+		{9, 1, 9, 0},
+	}
+	if r := MinCostRoot(cost); r != 1 {
+		t.Errorf("min cost root is %d, want 1", r)
+	}
+	// forwarding through 2 is cheaper than the direct links of 0
+	cost = [][]float64{
+		{0, 9, 1, 9},
+		{9, 0, 1, 9},
+		{1, 1, 0, 1},
+		{9, 9, 1, 0},
+	}
+	if r := MinCostRoot(cost); r != 2 {
+		t.Errorf("min cost root is %d, want 2", r)
+	}
+	if r := MinCostRoot([][]float64{{0, 1}, {1, 0}}); r != 0 {
+		t.Errorf("min cost root of ties is %d, want 0", r)
+	}
+}
+
+func Test_pairwise_exchange(t *testing.T) {
+	const k = 5
+	gs := GenPairwiseExchangeGraphs(k)
+	if len(gs) != k-1 {
+		t.Fatalf("%d rounds of %d vertices", len(gs), k)
+	}
+	sent := make(map[edge]bool)
+	for r, g := range gs {
+		for i := 0; i < k; i++ {
+			if len(g.Nexts(i)) != 1 || len(g.Prevs(i)) != 1 {
+				t.Errorf("vertex %d of round %d sends %d and receives %d", i, r, len(g.Nexts(i)), len(g.Prevs(i)))
+				continue
+			}
+			sent[edge{i, g.Nexts(i)[0]}] = true
+		}
+	}
+	if len(sent) != k*(k-1) {
+		t.Errorf("%d pairs exchanged, want %d", len(sent), k*(k-1))
+	}
+}
+
+func Test_two_level_pairs(t *testing.T) {
+	var peers PeerList
+	for h := 1; h <= 3; h++ {
+		for p := 1; p <= 4; p++ {
+			peers = append(peers, PeerID{IPv4: uint32(h), Port: uint16(p)})
+		}
+	}
+	for _, ring := range []bool{true, false} {
+		ps := GenTwoLevelGraphPairs(peers, ring)
+		if want := map[bool]int{true: 3, false: 1}[ring]; len(ps) != want {
+			t.Errorf("ring=%v: %d pairs, want %d", ring, len(ps), want)
+		}
+		for _, p := range ps {
+			if err := p.Validate(); err != nil {
+				t.Errorf("ring=%v: %v", ring, err)
+			}
+			for i, node := range p.Bcast.Nodes {
+				for _, j := range node.Nexts {
+					if !peers[i].ColocatedWith(peers[j]) && (i%4 != 0 || j%4 != 0) {
+						t.Errorf("ring=%v: edge %d -> %d across hosts is not between host masters", ring, i, j)
+					}
+				}
+			}
+		}
+	}
+}
+
+func Test_topology_aware_strategies(t *testing.T) {
+	var peers PeerList
+	var topo Topology
+	for h := 1; h <= 4; h++ {
+		for p := 0; p < 4; p++ {
+			peers = append(peers, PeerID{IPv4: uint32(h), Port: uint16(p)})
+			topo = append(topo, Locality{Socket: p % 2, Switch: map[bool]string{true: "s1", false: "s2"}[h <= 2]})
+		}
+	}
+	ps := GenTopologyAwareStrategies(peers, topo)
+	if len(ps) != 2 {
+		t.Fatalf("%d pairs, want 2", len(ps))
+	}
+	for _, p := range ps {
+		if err := p.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		var crossSocket, crossSwitch int
+		for i, node := range p.Bcast.Nodes {
+			for _, j := range node.Nexts {
+				if peers[i].ColocatedWith(peers[j]) && topo[i].Socket != topo[j].Socket {
+					crossSocket++
+				}
+				if topo[i].Switch != topo[j].Switch {
+					crossSwitch++
+				}
+			}
+		}
+		if crossSocket != 4 {
+			t.Errorf("%d edges across sockets, want 4", crossSocket)
+		}
+		if crossSwitch != 1 {
+			t.Errorf("%d edges across switches, want 1", crossSwitch)
+		}
+	}
+}
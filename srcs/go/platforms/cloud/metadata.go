@@ -0,0 +1,101 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Placement describes where the current instance is located in a cloud.
+type Placement struct {
+	Provider       string
+	Zone           string
+	PlacementGroup string
+}
+
+const (
+	AWS = `aws`
+	GCP = `gcp`
+)
+
+const (
+	awsMetadataURL  = `http://169.254.169.254/latest`
+	gcpMetadataURL  = `http://metadata.google.internal/computeMetadata/v1`
+	metadataTimeout = 500 * time.Millisecond
+	awsTokenTTL     = `21600`
+)
+
+var errNoMetadataService = errors.New("no metadata service found")
+
+// Detect queries the instance metadata services of known cloud providers.
+func Detect(ctx context.Context) (*Placement, error) {
+	client := &http.Client{Timeout: metadataTimeout}
+	if p, err := detectAWS(ctx, client); err == nil {
+		return p, nil
+	}
+	if p, err := detectGCP(ctx, client); err == nil {
+		return p, nil
+	}
+	return nil, errNoMetadataService
+}
+
+func detectAWS(ctx context.Context, client *http.Client) (*Placement, error) {
+	// IMDSv2 requires a session token.
+	req, err := http.NewRequest(http.MethodPut, awsMetadataURL+`/api/token`, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(`X-aws-ec2-metadata-token-ttl-seconds`, awsTokenTTL)
+	token, err := fetch(ctx, client, req)
+	if err != nil {
+		return nil, err
+	}
+	get := func(path string) (string, error) {
+		req, err := http.NewRequest(http.MethodGet, awsMetadataURL+`/meta-data/`+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(`X-aws-ec2-metadata-token`, token)
+		return fetch(ctx, client, req)
+	}
+	zone, err := get(`placement/availability-zone`)
+	if err != nil {
+		return nil, err
+	}
+	group, _ := get(`placement/group-name`) // not all instances are in a placement group
+	return &Placement{Provider: AWS, Zone: zone, PlacementGroup: group}, nil
+}
+
+func detectGCP(ctx context.Context, client *http.Client) (*Placement, error) {
+	req, err := http.NewRequest(http.MethodGet, gcpMetadataURL+`/instance/zone`, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(`Metadata-Flavor`, `Google`)
+	zone, err := fetch(ctx, client, req)
+	if err != nil {
+		return nil, err
+	}
+	// projects/<project-number>/zones/<zone>
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+	return &Placement{Provider: GCP, Zone: zone}, nil
+}
+
+func fetch(ctx context.Context, client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
@@ -0,0 +1,87 @@
+// Package hwinfo detects the hardware capability of the current host from procfs and sysfs.
+package hwinfo
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// Detect returns the capability of the current host, fields that can't be detected are left empty.
+func Detect() plan.Capability {
+	return plan.Capability{
+		GPUModel:    gpuModel(),
+		NICSpeed:    nicSpeed(),
+		MemoryBytes: memoryBytes(),
+	}
+}
+
+func gpuModel() string {
+	files, _ := filepath.Glob(`/proc/driver/nvidia/gpus/*/information`)
+	sort.Strings(files)
+	for _, f := range files {
+		if model := readField(f, "Model:"); len(model) > 0 {
+			return model
+		}
+	}
+	return ""
+}
+
+func nicSpeed() int {
+	_, speed := fastestNIC()
+	return speed
+}
+
+// fastestNIC returns the name and the speed in Mbit/s of the fastest NIC of the host, other than the loopback.
+func fastestNIC() (string, int) {
+	dirs, _ := filepath.Glob(`/sys/class/net/*`)
+	var name string
+	var fastest int
+	for _, d := range dirs {
+		if filepath.Base(d) == "lo" {
+			continue
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(d, "speed"))
+		if err != nil {
+			continue
+		}
+		if speed, err := strconv.Atoi(strings.TrimSpace(string(bs))); err == nil && speed > fastest {
+			name, fastest = filepath.Base(d), speed
+		}
+	}
+	return name, fastest
+}
+
+func memoryBytes() uint64 {
+	fields := strings.Fields(readField(`/proc/meminfo`, "MemTotal:"))
+	if len(fields) == 0 {
+		return 0
+	}
+	kb, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return kb * 1024
+}
+
+// readField returns the rest of the first line of a file starting with prefix.
+func readField(filename, prefix string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := s.Text(); strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		}
+	}
+	return ""
+}
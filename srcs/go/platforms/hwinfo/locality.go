@@ -0,0 +1,77 @@
+package hwinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// DetectLocality returns the locality of the current process: its host, the NUMA node of the CPUs it is allowed to run on,
+// and the fastest NIC of the host. The switch is not visible from sysfs, it is left to the caller, e.g. from the rack label.
+func DetectLocality() plan.Locality {
+	host, _ := os.Hostname()
+	nic, _ := fastestNIC()
+	return plan.Locality{
+		Host:   host,
+		Socket: numaNode(parseCPUList(readField(`/proc/self/status`, "Cpus_allowed_list:"))),
+		NIC:    nic,
+	}
+}
+
+// numaNode returns the NUMA node of all cpus, -1 if they span more than one node or the nodes are unknown.
+func numaNode(cpus []int) int {
+	if len(cpus) == 0 {
+		return -1
+	}
+	dirs, _ := filepath.Glob(`/sys/devices/system/node/node*`)
+	nodeOf := make(map[int]int)
+	for _, d := range dirs {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(d), "node"))
+		if err != nil {
+			continue
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(d, "cpulist"))
+		if err != nil {
+			continue
+		}
+		for _, cpu := range parseCPUList(string(bs)) {
+			nodeOf[cpu] = node
+		}
+	}
+	node, ok := nodeOf[cpus[0]]
+	if !ok {
+		return -1
+	}
+	for _, cpu := range cpus[1:] {
+		if n, ok := nodeOf[cpu]; !ok || n != node {
+			return -1
+		}
+	}
+	return node
+}
+
+// parseCPUList parses a list of CPUs of the Linux cpulist format, e.g. 0-3,8,10-11. Invalid parts are skipped.
+func parseCPUList(val string) []int {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(val), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
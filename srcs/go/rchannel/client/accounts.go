@@ -0,0 +1,83 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// Usage is the communication charged to an account.
+type Usage struct {
+	Account  string
+	Messages int64
+	Bytes    int64
+	Wire     time.Duration // time writing messages to connections
+	Queue    time.Duration // time waiting for the QoS share and for messages of other accounts on the same connections
+}
+
+type accounts struct {
+	sync.Mutex
+	usages map[string]*Usage
+}
+
+func newAccounts() *accounts {
+	return &accounts{usages: make(map[string]*Usage)}
+}
+
+func (a *accounts) charge(account string, n int64, wire, queue time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	u, ok := a.usages[account]
+	if !ok {
+		u = &Usage{Account: account}
+		a.usages[account] = u
+	}
+	u.Messages++
+	u.Bytes += n
+	u.Wire += wire
+	u.Queue += queue
+}
+
+func (a *accounts) get() []Usage {
+	a.Lock()
+	defer a.Unlock()
+	var us []Usage
+	for _, u := range a.usages {
+		us = append(us, *u)
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Account < us[j].Account })
+	return us
+}
+
+// peerCounters counts the bytes sent to each peer.
+type peerCounters struct {
+	sync.Mutex
+	bytes map[plan.PeerID]int64
+}
+
+func (p *peerCounters) add(id plan.PeerID, n int64) {
+	p.Lock()
+	defer p.Unlock()
+	if p.bytes == nil {
+		p.bytes = make(map[plan.PeerID]int64)
+	}
+	p.bytes[id] += n
+}
+
+func (p *peerCounters) get(id plan.PeerID) int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.bytes[id]
+}
+
+// SentBytesTo returns the total bytes sent to a peer.
+func (c *Client) SentBytesTo(id plan.PeerID) int64 {
+	return c.sentTo.get(id)
+}
+
+// Usages returns the usage of each account that has sent a message, sorted by name.
+func (c *Client) Usages() []Usage {
+	return c.accounts.get()
+}
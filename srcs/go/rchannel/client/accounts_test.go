@@ -0,0 +1,20 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_accounts(t *testing.T) {
+	a := newAccounts()
+	a.charge("root", 100, time.Millisecond, 0)
+	a.charge("qos:checkpoint", 10, time.Millisecond, 2*time.Millisecond)
+	a.charge("root", 100, time.Millisecond, time.Millisecond)
+	us := a.get()
+	if len(us) != 2 || us[0].Account != "qos:checkpoint" || us[1].Account != "root" {
+		t.Fatalf("unexpected accounts: %v", us)
+	}
+	if u := us[1]; u.Messages != 2 || u.Bytes != 200 || u.Wire != 2*time.Millisecond || u.Queue != time.Millisecond {
+		t.Errorf("unexpected usage of root: %+v", u)
+	}
+}
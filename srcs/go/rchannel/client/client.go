@@ -2,8 +2,10 @@ package client
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/monitor"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
@@ -13,22 +15,59 @@ import (
 type Client struct {
 	self        plan.PeerID
 	useUnixSock bool
+	transport   string // of connections between hosts
 	connPool    *connectionPool
 	monitor     monitor.Monitor
+	bandwidth   float64 // configured bytes per second, 0 if unknown
+	qos         *qosScheduler
+	links       *linkSchedulers // nil if messages are sent in the order of arrival
+	local       *localStreams   // nil if peers of the same process use sockets
+	sentBytes   int64
+	sentTo      peerCounters
+	accounts    *accounts
 }
 
 func New(self plan.PeerID, useUnixSock bool) *Client {
+	return NewWithTransport(self, useUnixSock, config.Transport)
+}
+
+// NewWithTransport creates a Client connecting to other hosts by transport, see connection.New.
+func NewWithTransport(self plan.PeerID, useUnixSock bool, transport string) *Client {
+	classes, err := ParseQoSClasses(config.QoSClasses)
+	if err != nil {
+		utils.ExitErr(err)
+	}
+	bandwidth := float64(config.Bandwidth) * 1e6 / 8
+	var links *linkSchedulers
+	if config.EnableLinkScheduling {
+		links = &linkSchedulers{}
+	}
+	var local *localStreams
+	if config.UseLoopback {
+		local = &localStreams{}
+	}
 	return &Client{
 		self:        self,
 		useUnixSock: useUnixSock,
-		connPool:    newConnectionPool(useUnixSock),
+		transport:   transport,
+		connPool:    newConnectionPool(useUnixSock, transport),
 		monitor:     monitor.GetMonitor(),
+		bandwidth:   bandwidth,
+		qos:         newQoSScheduler(bandwidth, classes),
+		links:       links,
+		local:       local,
+		accounts:    newAccounts(),
 	}
 }
 
+// Transport returns the transport of the connections to other hosts.
+func (c *Client) Transport() string {
+	return c.transport
+}
+
 func (c *Client) Ping(target plan.PeerID) (time.Duration, error) {
 	t0 := time.Now()
-	conn, err := connection.Open(target, c.self, connection.ConnPing, 0, c.useUnixSock)
+	conn, err := connection.Open(target, c.self, connection.ConnPing, 0, c.useUnixSock, c.transport)
 	if err != nil {
 		return time.Since(t0), err
 	}
@@ -60,25 +99,84 @@ func (c *Client) Wait(ctx context.Context, target plan.PeerID) (int, bool) {
 
 // Send sends data in buf to given Addr
 func (c *Client) Send(a plan.Addr, buf []byte, t connection.ConnType, flags uint32) error {
+	return c.SendQoS(DefaultQoSClass, a, buf, t, flags)
+}
+
+// SendQoS sends data in buf to given Addr, within the bandwidth share of the QoS class.
+func (c *Client) SendQoS(class string, a plan.Addr, buf []byte, t connection.ConnType, flags uint32) error {
+	return c.SendAs("", class, a, buf, t, flags)
+}
+
+// SendAs sends data in buf as SendQoS, and charges the message to the named account, e.g. of a session.
+// Messages of the empty account are not charged.
+func (c *Client) SendAs(account, class string, a plan.Addr, buf []byte, t connection.ConnType, flags uint32) error {
 	msg := connection.Message{
 		Length: uint32(len(buf)),
 		Data:   buf,
 	}
-	if err := c.send(a, msg, t, flags); err != nil {
+	t0 := time.Now()
+	c.qos.wait(class, len(buf))
+	throttled := time.Since(t0)
+	blocked, err := c.send(class, a, msg, t, flags)
+	if err != nil {
 		return err
 	}
+	if len(account) > 0 {
+		queued := throttled + blocked
+		c.accounts.charge(account, int64(msg.Length), time.Since(t0)-queued, queued)
+	}
+	atomic.AddInt64(&c.sentBytes, int64(msg.Length))
+	c.sentTo.add(a.Peer(), int64(msg.Length))
 	c.monitor.Egress(int64(msg.Length), a.NetAddr())
 	return nil
 }
 
-func (c *Client) send(a plan.Addr, msg connection.Message, t connection.ConnType, flags uint32) error {
-	conn := c.connPool.get(a.Peer(), c.self, t)
-	if err := conn.Send(a.Name, msg, flags); err != nil {
-		return err
+// SetRateLimit limits the bytes sent per second below the configured bandwidth, 0 for no limit.
+// The QoS classes share the limited bandwidth.
+func (c *Client) SetRateLimit(bytesPerSec float64) {
+	bandwidth := c.bandwidth
+	if bytesPerSec > 0 && (bandwidth <= 0 || bytesPerSec < bandwidth) {
+		bandwidth = bytesPerSec
 	}
-	return nil
+	c.qos.setBandwidth(bandwidth)
+}
+
+// SetQoSClass adds or updates a QoS class.
+func (c *Client) SetQoSClass(class QoSClass) {
+	c.qos.setClass(class)
+}
+
+// SentBytes returns the total bytes sent.
+func (c *Client) SentBytes() int64 {
+	return atomic.LoadInt64(&c.sentBytes)
+}
+
+// send sends msg, and returns the time waiting for earlier messages on the connection.
+func (c *Client) send(class string, a plan.Addr, msg connection.Message, t connection.ConnType, flags uint32) (time.Duration, error) {
+	if c.local != nil {
+		if e, token, ok := connection.LookupLocal(a.Peer(), t); ok {
+			return c.sendLocal(e, token, a, msg, t, flags)
+		}
+	}
+	conn := c.connPool.get(a.Peer(), c.self, t, c.qos.dscp(class))
+	if c.links == nil {
+		return conn.SendWait(a.Name, msg, flags)
+	}
+	t0 := time.Now()
+	link := c.links.get(a.Peer(), t)
+	link.acquire(class, c.qos.weight(class), len(msg.Data))
+	defer link.release()
+	scheduled := time.Since(t0)
+	blocked, err := conn.SendWait(a.Name, msg, flags)
+	return scheduled + blocked, err
 }
 
 func (c *Client) ResetConnections(keeps plan.PeerList, token uint32) {
 	c.connPool.reset(keeps, token)
+	if c.local != nil {
+		c.local.reset(keeps)
+	}
+	if c.links != nil {
+		c.links.reset(keeps)
+	}
 }
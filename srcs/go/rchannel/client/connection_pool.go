@@ -8,36 +8,47 @@ import (
 )
 
 type connKey struct {
-	a plan.PeerID
-	t connection.ConnType
+	a    plan.PeerID
+	t    connection.ConnType
+	dscp int
 }
 
 type connectionPool struct {
 	sync.Mutex
 	useUnixSock bool
+	transport   string
 	conns       map[connKey]connection.Connection
 	token       uint32
 }
 
-func newConnectionPool(useUnixSock bool) *connectionPool {
+func newConnectionPool(useUnixSock bool, transport string) *connectionPool {
 	return &connectionPool{
 		useUnixSock: useUnixSock,
+		transport:   transport,
 		conns:       make(map[connKey]connection.Connection),
 	}
 }
 
-func (p *connectionPool) get(remote, local plan.PeerID, t connection.ConnType) connection.Connection {
+// get returns the connection to remote of type t, whose packets are marked by dscp, so that the QoS classes of
+// different DSCPs have their own connections and the marking of a socket never changes under messages in flight.
+func (p *connectionPool) get(remote, local plan.PeerID, t connection.ConnType, dscp int) connection.Connection {
 	p.Lock()
 	defer p.Unlock()
-	key := connKey{remote, t}
+	key := connKey{a: remote, t: t, dscp: dscp}
 	if conn, ok := p.conns[key]; ok {
 		return conn
 	}
-	conn := connection.New(remote, local, t, p.token, p.useUnixSock)
+	conn := connection.NewMultiplexed(remote, local, t, p.token, p.useUnixSock, p.transport, dscp)
 	p.conns[key] = conn
 	return conn
 }
 
+func (p *connectionPool) getToken() uint32 {
+	p.Lock()
+	defer p.Unlock()
+	return p.token
+}
+
 func (p *connectionPool) reset(keeps plan.PeerList, token uint32) {
 	m := keeps.Set()
 	p.Lock()
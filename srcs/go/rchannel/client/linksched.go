@@ -0,0 +1,104 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// linkScheduler time-slices a connection between the QoS classes of the messages waiting for it, by start-time fair queueing:
+// a message is tagged by the later of the virtual time of the link and the finish tag of the previous message of its class,
+// and the waiting message of the smallest tag is sent next. A class then gets a share of the link in proportion to its weight
+// while it has messages waiting, e.g. the chunks of a checkpoint broadcast are interleaved with the chunks of gradients,
+// instead of the gradients waiting behind the whole checkpoint.
+type linkScheduler struct {
+	sync.Mutex
+	busy    bool
+	vtime   float64            // start tag of the message being sent
+	finish  map[string]float64 // finish tag of the last message of each class
+	seq     uint64
+	waiting []*linkWaiter
+}
+
+type linkWaiter struct {
+	start float64
+	seq   uint64 // breaks ties by arrival
+	ready chan struct{}
+}
+
+func newLinkScheduler() *linkScheduler {
+	return &linkScheduler{finish: make(map[string]float64)}
+}
+
+// acquire blocks until the message of n bytes of the class of the given weight can be sent on the link.
+func (s *linkScheduler) acquire(class string, weight float64, n int) {
+	s.Lock()
+	start := s.vtime
+	if f := s.finish[class]; f > start {
+		start = f
+	}
+	s.finish[class] = start + float64(n)/weight
+	if !s.busy {
+		s.busy = true
+		s.vtime = start
+		s.Unlock()
+		return
+	}
+	s.seq++
+	w := &linkWaiter{start: start, seq: s.seq, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.Unlock()
+	<-w.ready
+}
+
+// release passes the link to the waiting message of the smallest start tag.
+func (s *linkScheduler) release() {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.waiting) == 0 {
+		s.busy = false
+		return
+	}
+	next := 0
+	for i, w := range s.waiting {
+		if w.start < s.waiting[next].start || (w.start == s.waiting[next].start && w.seq < s.waiting[next].seq) {
+			next = i
+		}
+	}
+	w := s.waiting[next]
+	s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+	s.vtime = w.start
+	close(w.ready)
+}
+
+type linkSchedulers struct {
+	sync.Mutex
+	links map[connKey]*linkScheduler
+}
+
+func (ls *linkSchedulers) get(remote plan.PeerID, t connection.ConnType) *linkScheduler {
+	ls.Lock()
+	defer ls.Unlock()
+	if ls.links == nil {
+		ls.links = make(map[connKey]*linkScheduler)
+	}
+	key := connKey{a: remote, t: t}
+	s, ok := ls.links[key]
+	if !ok {
+		s = newLinkScheduler()
+		ls.links[key] = s
+	}
+	return s
+}
+
+func (ls *linkSchedulers) reset(keeps plan.PeerList) {
+	m := keeps.Set()
+	ls.Lock()
+	defer ls.Unlock()
+	for k := range ls.links {
+		if _, ok := m[k.a]; !ok {
+			delete(ls.links, k)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_linkScheduler(t *testing.T) {
+	s := newLinkScheduler()
+	s.acquire("checkpoint", 1, 100) // the link is busy with a checkpoint chunk
+	order := make(chan string, 4)
+	enqueue := func(class string, weight float64) {
+		s.Lock()
+		n := len(s.waiting)
+		s.Unlock()
+		go func() {
+			s.acquire(class, weight, 100)
+			order <- class
+			s.release()
+		}()
+		for {
+			s.Lock()
+			queued := len(s.waiting) > n
+			s.Unlock()
+			if queued {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	enqueue("checkpoint", 1)
+	enqueue("checkpoint", 1)
+	enqueue("gradient", 4)
+	enqueue("gradient", 4)
+	s.release()
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, <-order)
+	}
+	want := []string{"gradient", "gradient", "checkpoint", "checkpoint"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// localStreamSize bounds the messages in flight to a peer of the same process, as the buffers of a socket would.
+const localStreamSize = 1024
+
+var (
+	errLocalTokenMismatch = failure.New(failure.ShrinkInProgress, "token of local peer mismatch")
+	errLocalStreamClosed  = errors.New("stream to local peer closed")
+)
+
+type localMessage struct {
+	name  string
+	m     *connection.Message
+	flags uint32
+}
+
+// A localStream passes the messages to a peer of the same process in order, by a goroutine as the reader of a connection.
+// The stream is closed if the peer fails to take a message, as a connection is closed by its reader on errors.
+type localStream struct {
+	msgs chan localMessage
+	once sync.Once
+	done chan struct{}
+}
+
+func newLocalStream(src plan.PeerID, e connection.LocalEndpoint) *localStream {
+	s := &localStream{
+		msgs: make(chan localMessage, localStreamSize),
+		done: make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case lm := <-s.msgs:
+				if err := e.Deliver(src, lm.name, lm.m, lm.flags); err != nil {
+					log.Errorf("local stream from #<%s> closed: %v", src, err)
+					s.close()
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+type localStreams struct {
+	sync.Mutex
+	streams map[connKey]*localStream
+}
+
+// send waits until the stream has room for lm, or fails if the stream is closed meanwhile.
+func (s *localStream) send(lm localMessage) error {
+	select {
+	case <-s.done:
+		return errLocalStreamClosed
+	default:
+	}
+	select {
+	case s.msgs <- lm:
+		return nil
+	case <-s.done:
+		return errLocalStreamClosed
+	}
+}
+
+func (s *localStream) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *localStream) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ls *localStreams) get(remote, local plan.PeerID, t connection.ConnType, e connection.LocalEndpoint) *localStream {
+	ls.Lock()
+	defer ls.Unlock()
+	if ls.streams == nil {
+		ls.streams = make(map[connKey]*localStream)
+	}
+	key := connKey{a: remote, t: t}
+	s, ok := ls.streams[key]
+	if !ok || s.closed() { // reopened as a connection closed on errors is redialed
+		s = newLocalStream(local, e)
+		ls.streams[key] = s
+	}
+	return s
+}
+
+func (ls *localStreams) reset(keeps plan.PeerList) {
+	m := keeps.Set()
+	ls.Lock()
+	defer ls.Unlock()
+	for k, s := range ls.streams {
+		if _, ok := m[k.a]; !ok {
+			s.close()
+			delete(ls.streams, k)
+		}
+	}
+}
+
+// sendLocal passes a copy of msg to e of a peer of the same process, once the peer has the token of this peer,
+// as a connection to a peer of a different cluster version is refused. It returns the time waiting for the stream.
+func (c *Client) sendLocal(e connection.LocalEndpoint, token uint32, a plan.Addr, msg connection.Message, t connection.ConnType, flags uint32) (time.Duration, error) {
+	want := c.connPool.getToken()
+	for i := 0; token != want; i++ {
+		if i >= config.ConnRetryCount {
+			return 0, errLocalTokenMismatch
+		}
+		time.Sleep(config.ConnRetryPeriod)
+		if e, token, _ = connection.LookupLocal(a.Peer(), t); e == nil {
+			return 0, errLocalTokenMismatch
+		}
+	}
+	m := &connection.Message{Length: msg.Length, Data: connection.GetBuf(msg.Length)}
+	copy(m.Data, msg.Data)
+	t0 := time.Now()
+	err := c.local.get(a.Peer(), c.self, t, e).send(localMessage{name: a.Name, m: m, flags: flags})
+	return time.Since(t0), err
+}
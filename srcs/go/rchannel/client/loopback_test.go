@@ -0,0 +1,96 @@
+package client_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/handler"
+)
+
+func Test_loopback(t *testing.T) {
+	a := plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: 31001}
+	b := plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: 31002}
+	e := handler.NewCollectiveEndpoint()
+	connection.ServeLocal(b, connection.ConnCollective, e)
+	defer connection.StopLocal(b, connection.ConnCollective)
+	defer func(v bool) { config.UseLoopback = v }(config.UseLoopback)
+	config.UseLoopback = true
+	c := client.New(a, false)
+	for i := 0; i < 3; i++ {
+		data := []byte{byte(i), 1, 2}
+		if err := c.Send(b.WithName("x"), data, connection.ConnCollective, connection.NoFlag); err != nil {
+			t.Fatal(err)
+		}
+		data[0] = 9 // the message is copied
+	}
+	for i := 0; i < 3; i++ {
+		m, err := e.Recv(a.WithName("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Data[0] != byte(i) {
+			t.Errorf("received message %d as %d", i, m.Data[0])
+		}
+	}
+	buf := make([]byte, 2)
+	done := make(chan error)
+	go func() { done <- e.RecvInto(a.WithName("y"), connection.Message{Length: 2, Data: buf}) }()
+	if err := c.Send(b.WithName("y"), []byte{7, 8}, connection.ConnCollective, connection.WaitRecvBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil || buf[0] != 7 || buf[1] != 8 {
+		t.Errorf("received %v into the registered buffer: %v", buf, err)
+	}
+}
+
+func Test_loopbackAbort(t *testing.T) {
+	a := plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: 31003}
+	b := plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: 31004}
+	e := handler.NewCollectiveEndpoint()
+	connection.ServeLocal(b, connection.ConnCollective, e)
+	defer connection.StopLocal(b, connection.ConnCollective)
+	defer func(v bool) { config.UseLoopback = v }(config.UseLoopback)
+	config.UseLoopback = true
+	c := client.New(a, false)
+	// the receiver never registers a buffer for the message, and aborts
+	if err := c.Send(b.WithName("x"), []byte{1}, connection.ConnCollective, connection.WaitRecvBuf); err != nil {
+		t.Fatal(err)
+	}
+	e.Abort(errors.New("abort"))
+	// the stream is closed rather than blocking the sender once it's full, and reopened for the next sends
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for i := 0; i < 2048 && err == nil; i++ {
+			err = c.Send(b.WithName("y"), []byte{1}, connection.ConnCollective, connection.NoFlag)
+		}
+		done <- err
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the sender is blocked by an aborted receiver")
+	}
+}
+
+func Test_loopbackLength(t *testing.T) {
+	a := plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: 31005}
+	e := handler.NewCollectiveEndpoint()
+	buf := connection.Message{Length: 2, Data: make([]byte, 2)}
+	done := make(chan error, 1)
+	go func() { done <- e.RecvInto(a.WithName("x"), buf) }()
+	if err := e.Deliver(a, "x", &connection.Message{Length: 3, Data: []byte{1, 2, 3}}, connection.WaitRecvBuf); err == nil {
+		t.Fatal("delivered a message of a different length")
+	}
+	if err := e.Deliver(a, "x", &connection.Message{Length: 2, Data: []byte{7, 8}}, connection.WaitRecvBuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil || buf.Data[0] != 7 {
+		t.Errorf("received %v into the registered buffer: %v", buf.Data, err)
+	}
+}
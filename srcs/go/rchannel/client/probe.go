@@ -0,0 +1,140 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+// Names of messages on ping connections, other names are echoed.
+const (
+	ProbeName       = `probe`       // size bytes to be acknowledged by an empty message
+	ProbeLinkPrefix = `probe-link:` // followed by <target>:<size>, asks the receiver to probe its link to target
+)
+
+// MaxProbeSize is the max size of a probe, which is allocated by the prober.
+const MaxProbeSize = 64 << 20
+
+// LinkStats is the measured latency and throughput of the link from one peer to another.
+type LinkStats struct {
+	From       string        `json:"from"`
+	To         string        `json:"to"`
+	Size       int           `json:"size"`
+	Latency    time.Duration `json:"latency"`    // round trip time of an empty message
+	Throughput float64       `json:"throughput"` // bytes per second, excluding the latency
+}
+
+var (
+	errProbeFailed = errors.New("probe failed")
+	errProbeSize   = fmt.Errorf("probe size must be in [0, %d]", MaxProbeSize)
+)
+
+// Probe measures the link to target by a ping, followed by size bytes which are acknowledged by target.
+// It uses a dedicated connection, which is not rate limited.
+func (c *Client) Probe(target plan.PeerID, size int) (*LinkStats, error) {
+	if size < 0 || size > MaxProbeSize {
+		return nil, errProbeSize
+	}
+	conn, err := connection.Open(target, c.self, connection.ConnPing, 0, c.useUnixSock, c.transport)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var empty connection.Message
+	t0 := time.Now()
+	if err := conn.Send("ping", empty, connection.NoFlag); err != nil {
+		return nil, err
+	}
+	if err := conn.Read("ping", empty); err != nil {
+		return nil, err
+	}
+	latency := time.Since(t0)
+	data := connection.Message{Length: uint32(size), Data: make([]byte, size)}
+	t1 := time.Now()
+	if err := conn.Send(ProbeName, data, connection.NoFlag); err != nil {
+		return nil, err
+	}
+	if err := conn.Read(ProbeName, empty); err != nil {
+		return nil, err
+	}
+	stats := &LinkStats{From: c.self.String(), To: target.String(), Size: size, Latency: latency}
+	if d := time.Since(t1) - latency; d > 0 {
+		stats.Throughput = float64(size) / d.Seconds()
+	}
+	return stats, nil
+}
+
+// ProbeFrom asks from to probe its link to target, see Probe.
+func (c *Client) ProbeFrom(from, target plan.PeerID, size int) (*LinkStats, error) {
+	if size < 0 || size > MaxProbeSize {
+		return nil, errProbeSize
+	}
+	conn, err := connection.Open(from, c.self, connection.ConnPing, 0, c.useUnixSock, c.transport)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	name := fmt.Sprintf("%s%s:%d", ProbeLinkPrefix, target, size)
+	if err := conn.Send(name, connection.Message{}, connection.NoFlag); err != nil {
+		return nil, err
+	}
+	reply := connection.Message{Length: 16, Data: make([]byte, 16)}
+	if err := conn.Read(name, reply); err != nil {
+		return nil, err
+	}
+	latency := int64(binary.LittleEndian.Uint64(reply.Data[:8]))
+	throughput := int64(binary.LittleEndian.Uint64(reply.Data[8:]))
+	if latency < 0 {
+		return nil, fmt.Errorf("%v: %s -> %s", errProbeFailed, from, target)
+	}
+	return &LinkStats{
+		From:       from.String(),
+		To:         target.String(),
+		Size:       size,
+		Latency:    time.Duration(latency),
+		Throughput: float64(throughput),
+	}, nil
+}
+
+// ServeProbeLink runs the probe requested by a message named ProbeLinkPrefix<target>:<size>, and returns the reply.
+func (c *Client) ServeProbeLink(name string) connection.Message {
+	latency, throughput := int64(-1), int64(0)
+	if target, size, err := parseProbeLink(name); err != nil {
+		log.Warnf("invalid probe request %q: %v", name, err)
+	} else if stats, err := c.Probe(*target, size); err != nil {
+		log.Warnf("probe %s failed: %v", target, err)
+	} else {
+		latency, throughput = int64(stats.Latency), int64(stats.Throughput)
+	}
+	reply := connection.Message{Length: 16, Data: make([]byte, 16)}
+	binary.LittleEndian.PutUint64(reply.Data[:8], uint64(latency))
+	binary.LittleEndian.PutUint64(reply.Data[8:], uint64(throughput))
+	return reply
+}
+
+func parseProbeLink(name string) (*plan.PeerID, int, error) {
+	spec := strings.TrimPrefix(name, ProbeLinkPrefix)
+	i := strings.LastIndex(spec, ":")
+	if i < 0 {
+		return nil, 0, errProbeFailed
+	}
+	size, err := strconv.Atoi(spec[i+1:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid size: %q", spec[i+1:])
+	}
+	if size < 0 || size > MaxProbeSize {
+		return nil, 0, errProbeSize
+	}
+	target, err := plan.ParsePeerID(spec[:i])
+	if err != nil {
+		return nil, 0, err
+	}
+	return target, size, nil
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_parseProbeLink(t *testing.T) {
+	target := plan.PeerID{IPv4: plan.MustParseIPv4(`127.0.0.1`), Port: 10000}
+	for _, tt := range []struct {
+		size int
+		ok   bool
+	}{
+		{0, true},
+		{MaxProbeSize, true},
+		{MaxProbeSize + 1, false},
+		{-1, false},
+	} {
+		name := fmt.Sprintf("%s%s:%d", ProbeLinkPrefix, target, tt.size)
+		got, size, err := parseProbeLink(name)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseProbeLink(%q): %v", name, err)
+			continue
+		}
+		if tt.ok && (*got != target || size != tt.size) {
+			t.Errorf("parseProbeLink(%q) = %s, %d", name, got, size)
+		}
+	}
+}
+
+func Test_ProbeSize(t *testing.T) {
+	c := New(plan.PeerID{}, false)
+	for _, size := range []int{-1, MaxProbeSize + 1} {
+		if _, err := c.Probe(plan.PeerID{}, size); err != errProbeSize {
+			t.Errorf("Probe of %d bytes: %v, want %v", size, err, errProbeSize)
+		}
+		if _, err := c.ProbeFrom(plan.PeerID{}, plan.PeerID{}, size); err != errProbeSize {
+			t.Errorf("ProbeFrom of %d bytes: %v, want %v", size, err, errProbeSize)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQoSClass is the class of traffic without a class.
+const DefaultQoSClass = ``
+
+// qosActiveWindow is the duration after the last send during which a class is considered active.
+const qosActiveWindow = 100 * time.Millisecond
+
+// qosMinShare is the minimum share of the bandwidth of a class, if the reservations exceed the bandwidth.
+const qosMinShare = 0.01
+
+// QoSClass describes how a class of traffic shares the bandwidth.
+type QoSClass struct {
+	Name    string
+	Weight  float64 // relative weight in the bandwidth left by reservations, must be positive
+	Reserve float64 // reserved bytes per second
+	DSCP    int     // marks the packets of the class, for networks prioritizing traffic by DSCP, 0 for best effort
+}
+
+// maxDSCP is the max differentiated services code point, of 6 bits.
+const maxDSCP = 63
+
+// ParseQoSClasses parses a comma separated list of <name>=<weight>[:<reserved Mbps>][@<DSCP>].
+func ParseQoSClasses(val string) ([]QoSClass, error) {
+	var cs []QoSClass
+	for _, spec := range strings.Split(val, ",") {
+		if len(spec) == 0 {
+			continue
+		}
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid QoS class: %q", spec)
+		}
+		c := QoSClass{Name: kv[0]}
+		var err error
+		if i := strings.Index(kv[1], "@"); i >= 0 {
+			if c.DSCP, err = strconv.Atoi(kv[1][i+1:]); err != nil || c.DSCP < 0 || c.DSCP > maxDSCP {
+				return nil, fmt.Errorf("invalid DSCP of QoS class %s: %q", c.Name, kv[1][i+1:])
+			}
+			kv[1] = kv[1][:i]
+		}
+		parts := strings.SplitN(kv[1], ":", 2)
+		if c.Weight, err = strconv.ParseFloat(parts[0], 64); err != nil || c.Weight <= 0 {
+			return nil, fmt.Errorf("invalid weight of QoS class %s: %q", c.Name, parts[0])
+		}
+		if len(parts) > 1 {
+			mbps, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || mbps < 0 {
+				return nil, fmt.Errorf("invalid reservation of QoS class %s: %q", c.Name, parts[1])
+			}
+			c.Reserve = mbps * 1e6 / 8
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+type qosState struct {
+	QoSClass
+	limiter  rateLimiter
+	lastSend time.Time
+}
+
+// qosScheduler enforces the bandwidth share of each class in the send path.
+// A class gets its reservation, plus the bandwidth left by the reservations of active classes, in proportion to its weight.
+// It doesn't limit if the bandwidth is 0.
+type qosScheduler struct {
+	sync.Mutex
+	bandwidth float64 // bytes per second
+	classes   map[string]*qosState
+}
+
+func newQoSScheduler(bandwidth float64, cs []QoSClass) *qosScheduler {
+	s := &qosScheduler{
+		bandwidth: bandwidth,
+		classes:   make(map[string]*qosState),
+	}
+	s.classes[DefaultQoSClass] = &qosState{QoSClass: QoSClass{Name: DefaultQoSClass, Weight: 1}}
+	for _, c := range cs {
+		s.classes[c.Name] = &qosState{QoSClass: c}
+	}
+	return s
+}
+
+func (s *qosScheduler) setBandwidth(bandwidth float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.bandwidth = bandwidth
+}
+
+func (s *qosScheduler) setClass(c QoSClass) {
+	s.Lock()
+	defer s.Unlock()
+	if st, ok := s.classes[c.Name]; ok {
+		st.QoSClass = c
+		return
+	}
+	s.classes[c.Name] = &qosState{QoSClass: c}
+}
+
+// wait blocks until n bytes of the class can be sent, unknown classes share the default class.
+func (s *qosScheduler) wait(class string, n int) {
+	s.Lock()
+	st, ok := s.classes[class]
+	if !ok {
+		st = s.classes[DefaultQoSClass]
+	}
+	now := time.Now()
+	st.lastSend = now
+	st.limiter.setRate(s.rate(st, now))
+	s.Unlock()
+	st.limiter.wait(n)
+}
+
+// weight returns the weight of the class, unknown classes share the default class.
+func (s *qosScheduler) weight(class string) float64 {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.classes[class]
+	if !ok {
+		st = s.classes[DefaultQoSClass]
+	}
+	return st.Weight
+}
+
+// dscp returns the DSCP of the class, unknown classes share the default class.
+func (s *qosScheduler) dscp(class string) int {
+	s.Lock()
+	defer s.Unlock()
+	st, ok := s.classes[class]
+	if !ok {
+		st = s.classes[DefaultQoSClass]
+	}
+	return st.DSCP
+}
+
+func (s *qosScheduler) rate(st *qosState, now time.Time) float64 {
+	if s.bandwidth <= 0 {
+		return 0
+	}
+	var reserved, weights float64
+	for _, c := range s.classes {
+		if now.Sub(c.lastSend) < qosActiveWindow {
+			reserved += c.Reserve
+			weights += c.Weight
+		}
+	}
+	rate := st.Reserve
+	if left := s.bandwidth - reserved; left > 0 && weights > 0 {
+		rate += left * st.Weight / weights
+	}
+	if min := s.bandwidth * qosMinShare; rate < min {
+		rate = min
+	}
+	return rate
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+func Test_QoS(t *testing.T) {
+	cs, err := ParseQoSClasses("gradient=3:400@46,checkpoint=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 || cs[0].Weight != 3 || cs[0].Reserve != 50e6 || cs[0].DSCP != 46 || cs[1].Reserve != 0 || cs[1].DSCP != 0 {
+		t.Fatalf("unexpected classes: %+v", cs)
+	}
+	for _, val := range []string{"gradient", "=1", "gradient=0", "gradient=1:x", "gradient=1@64", "gradient=1@x"} {
+		if _, err := ParseQoSClasses(val); err == nil {
+			t.Errorf("%q should be invalid", val)
+		}
+	}
+	s := newQoSScheduler(150e6, cs)
+	now := time.Now()
+	g, c := s.classes["gradient"], s.classes["checkpoint"]
+	g.lastSend = now
+	if r := s.rate(g, now); r != 150e6 {
+		t.Errorf("idle bandwidth is not used: %f", r)
+	}
+	c.lastSend = now
+	if r := s.rate(g, now); r != 50e6+75e6 {
+		t.Errorf("unexpected rate of gradient: %f", r)
+	}
+	if r := s.rate(c, now); r != 25e6 {
+		t.Errorf("unexpected rate of checkpoint: %f", r)
+	}
+}
+
+func Test_connectionPoolDSCP(t *testing.T) {
+	p := newConnectionPool(false, connection.TransportTCP)
+	remote, local := plan.PeerID{IPv4: 1, Port: 1}, plan.PeerID{IPv4: 2, Port: 1}
+	be := p.get(remote, local, connection.ConnCollective, 0)
+	ef := p.get(remote, local, connection.ConnCollective, 46)
+	if be == ef {
+		t.Errorf("classes of different DSCPs share a connection")
+	}
+	if p.get(remote, local, connection.ConnCollective, 46) != ef {
+		t.Errorf("a class of DSCP 46 has a new connection")
+	}
+	p.reset(plan.PeerList{local}, 1)
+	if len(p.conns) != 0 {
+		t.Errorf("%d connections to removed peers are kept", len(p.conns))
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// rateBurst is the duration of traffic that can be sent at once after idling.
+const rateBurst = 100 * time.Millisecond
+
+// rateLimiter is a token bucket limiting the bytes sent per second, it doesn't limit if rate is 0.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the rate, the tokens accumulated at the old rate are kept.
+func (l *rateLimiter) setRate(rate float64) {
+	l.Lock()
+	defer l.Unlock()
+	if rate == l.rate {
+		return
+	}
+	if l.rate > 0 {
+		l.refill(time.Now())
+	} else {
+		l.tokens = 0
+		l.last = time.Now()
+	}
+	l.rate = rate
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if burst := rateBurst.Seconds() * l.rate; l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+}
+
+// wait blocks until n bytes can be sent.
+func (l *rateLimiter) wait(n int) {
+	l.Lock()
+	if l.rate <= 0 {
+		l.Unlock()
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+	time.Sleep(d)
+}
@@ -1,15 +1,29 @@
 package connection
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"sync"
 	"time"
+	"unsafe"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/quic"
+	"github.com/lsds/KungFu/srcs/go/rchannel/rdma"
+	"github.com/lsds/KungFu/srcs/go/rchannel/rudp"
+)
+
+// Transports of connections between hosts, selected per peer, by config.Transport by default.
+const (
+	TransportTCP  = `tcp`
+	TransportQUIC = `quic`
+	TransportRDMA = `rdma`
 )
 
 // Connection is a simplex logical connection from one peer to another
@@ -21,48 +35,196 @@ type Connection interface {
 	Src() plan.PeerID
 	Dest() plan.PeerID
 	Send(name string, m Message, flags uint32) error
+	SendWait(name string, m Message, flags uint32) (time.Duration, error) // as Send, and returns the time waiting for earlier messages
 	Read(name string, m Message) error
+
+	// Version and Features are negotiated at connection setup.
+	Version() uint16
+	Features() uint32
 }
 
-// UpgradeFrom performs the server side operations to upgrade a TCP connection to a Connection
-func UpgradeFrom(conn net.Conn, self plan.PeerID, token uint32) (Connection, error) {
+var errNotMember = errors.New("connection from a peer not of the cluster")
+
+// UpgradeFrom performs the server side operations to upgrade a TCP connection to a Connection.
+// If conn is secured by TLS, its source must be verified by its certificate, and a member if isMember is not nil,
+// as the sources of other connections are not authenticated.
+func UpgradeFrom(conn net.Conn, self plan.PeerID, token uint32, isMember func(plan.PeerID) bool) (Connection, error) {
 	var ch connectionHeader
 	if err := ch.ReadFrom(conn); err != nil {
 		return nil, err
 	}
+	src := plan.PeerID{IPv4: ch.SrcIPv4, Port: ch.SrcPort}
+	if err := verifyPeer(conn, src); err != nil {
+		return nil, err
+	}
+	if _, ok := conn.(tlsConn); ok && isMember != nil && !isMember(src) {
+		return nil, fmt.Errorf("%w: %s", errNotMember, src)
+	}
+	hello := legacyHello
+	versioned := ch.Type&versionedType != 0
+	if versioned {
+		var remote connectionHello
+		if err := remote.readHello(conn); err != nil {
+			return nil, err
+		}
+		hello = negotiate(remote, localHello())
+	}
 	ack := connectionACK{
 		Token: token,
 	}
 	if err := ack.WriteTo(conn); err != nil {
 		return nil, err
 	}
+	if versioned {
+		if err := hello.writeHello(conn); err != nil {
+			return nil, err
+		}
+	}
 	return &tcpConnection{
 		src:      plan.PeerID{IPv4: ch.SrcIPv4, Port: ch.SrcPort},
 		dest:     self,
-		connType: ConnType(ch.Type),
+		connType: ConnType(ch.Type &^ versionedType),
 		conn:     conn,
+		hello:    hello,
 	}, nil
 }
 
-var errInvalidToken = errors.New("invalid token")
+func localHello() connectionHello {
+	return connectionHello{Version: ProtocolVersion, Features: LocalFeatures}
+}
+
+var errInvalidToken = failure.New(failure.ShrinkInProgress, "invalid token") // the peer is of another cluster version
 
-func Open(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool) (*tcpConnection, error) {
-	conn := New(remote, local, t, token, useUnixSock)
+func Open(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool, transport string) (*tcpConnection, error) {
+	conn := New(remote, local, t, token, useUnixSock, transport)
 	if err := conn.initOnce(); err != nil {
 		return nil, err
 	}
 	return conn, nil
 }
 
-func New(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool) *tcpConnection {
-	init := func() (net.Conn, error) {
+// useRUDP caches the transport chosen for each remote peer.
+var useRUDP sync.Map // plan.PeerID -> bool
+
+// dialByRTT connects to remote via reliable UDP if the RTT measured by the TCP connect exceeds config.RUDPRTTThreshold.
+func dialByRTT(remote plan.PeerID) (net.Conn, error) {
+	if v, ok := useRUDP.Load(remote); ok && v.(bool) {
+		return rudp.Dial(remote.String())
+	}
+	t0 := time.Now()
+	conn, err := net.Dial("tcp", remote.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := useRUDP.Load(remote); ok {
+		return conn, nil
+	}
+	rtt := time.Since(t0)
+	slow := rtt > config.RUDPRTTThreshold
+	useRUDP.Store(remote, slow)
+	if !slow {
+		return conn, nil
+	}
+	log.Infof("using reliable UDP to #<%s>, RTT: %s", remote, rtt)
+	conn.Close()
+	return rudp.Dial(remote.String())
+}
+
+var errLegacyPeer = errors.New("peer doesn't support versioned handshake")
+
+// dialQUIC opens a stream to remote, on a QUIC connection secured by the TLS config of peers if it's enabled.
+func dialQUIC(remote plan.PeerID) (net.Conn, error) {
+	var tlsConf *tls.Config
+	if EnableTLS() {
+		var err error
+		if tlsConf, err = ClientTLSConfig(remote); err != nil {
+			return nil, err
+		}
+	}
+	return quic.Dial(remote.String(), tlsConf)
+}
+
+// useQUIC returns true if connections to remote are streams of QUIC connections.
+func useQUIC(remote, local plan.PeerID, transport string) bool {
+	return transport == TransportQUIC && !remote.ColocatedWith(local)
+}
+
+// useRDMA returns true if connections to remote are over RDMA queue pairs.
+func useRDMA(remote, local plan.PeerID, transport string) bool {
+	return transport == TransportRDMA && !remote.ColocatedWith(local)
+}
+
+// RegisterBuffer registers a buffer if transport is RDMA, so that it is sent without a copy,
+// and returns the function deregistering it.
+func RegisterBuffer(transport string, b []byte) func() {
+	if transport != TransportRDMA {
+		return func() {}
+	}
+	return rdma.Register(b)
+}
+
+var errNoGPUDirect = errors.New("device memory can only be sent by the RDMA transport")
+
+// GPUDirect returns true if device memory registered by its address is sent by GPUDirect RDMA over transport.
+func GPUDirect(transport string) bool {
+	return transport == TransportRDMA && rdma.GPUDirect()
+}
+
+// RegisterDeviceBuffer registers device memory for the RDMA transport, see rdma.RegisterDevice,
+// and returns the function deregistering it and the memory as a slice, which the host must not access.
+func RegisterDeviceBuffer(transport string, ptr unsafe.Pointer, size int, dmabufFD int, offset uint64) (func(), []byte, error) {
+	if transport != TransportRDMA {
+		return nil, nil, errNoGPUDirect
+	}
+	return rdma.RegisterDevice(ptr, size, dmabufFD, offset)
+}
+
+// IsDeviceBuffer returns true if b is in device memory registered by RegisterDeviceBuffer.
+func IsDeviceBuffer(b []byte) bool {
+	return rdma.IsDevice(b)
+}
+
+// New returns a Connection to remote, over a unix socket if useUnixSock and remote is colocated, or else over transport.
+func New(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool, transport string) *tcpConnection {
+	dial := func() (net.Conn, error) {
+		if useUnixSock && remote.ColocatedWith(local) {
+			addr := net.UnixAddr{Name: remote.SockFile(), Net: "unix"}
+			return net.DialUnix(addr.Net, nil, &addr)
+		}
+		if useQUIC(remote, local, transport) {
+			return dialQUIC(remote)
+		}
 		conn, err := func() (net.Conn, error) {
-			if useUnixSock && remote.ColocatedWith(local) {
-				addr := net.UnixAddr{Name: remote.SockFile(), Net: "unix"}
-				return net.DialUnix(addr.Net, nil, &addr)
+			if useRDMA(remote, local, transport) {
+				return rdma.Dial(remote.String())
+			}
+			if config.EnableRUDP && !remote.ColocatedWith(local) {
+				return dialByRTT(remote)
 			}
 			return net.Dial("tcp", remote.String())
 		}()
+		if err != nil || !EnableTLS() {
+			return conn, err
+		}
+		tlsConf, err := ClientTLSConfig(remote)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tls.Client(conn, tlsConf), nil
+	}
+	return newConnection(remote, local, t, token, dial)
+}
+
+// newConnection returns a Connection over the net.Conn returned by dial, which is called on the first use of the Connection.
+func newConnection(remote, local plan.PeerID, t ConnType, token uint32, dial func() (net.Conn, error)) *tcpConnection {
+	c := &tcpConnection{
+		src:      local,
+		dest:     remote,
+		connType: t,
+	}
+	handshake := func(versioned bool) (net.Conn, error) {
+		conn, err := dial()
 		if err != nil {
 			return nil, err
 		}
@@ -71,11 +233,23 @@ func New(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool)
 			SrcIPv4: local.IPv4,
 			SrcPort: local.Port,
 		}
+		if versioned {
+			h.Type |= versionedType
+		}
 		if err := h.WriteTo(conn); err != nil {
 			return nil, err
 		}
+		if versioned {
+			if err := localHello().writeHello(conn); err != nil {
+				return nil, err
+			}
+		}
 		var ack connectionACK
 		if err := ack.ReadFrom(conn); err != nil {
+			if versioned {
+				conn.Close()
+				return nil, errLegacyPeer
+			}
 			return nil, err
 		}
 		if ack.Token != token {
@@ -85,19 +259,28 @@ func New(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool)
 			}
 			// FIXME: ignored token check for other connection types
 		}
+		c.hello = legacyHello
+		if versioned {
+			// a legacy server sends the ACK, then closes the connection of invalid type
+			if err := c.hello.readHello(conn); err != nil {
+				conn.Close()
+				return nil, errLegacyPeer
+			}
+		}
 		return conn, nil
 	}
-	var initRetry int
-	if t == ConnCollective || t == ConnPeerToPeer {
-		initRetry = config.ConnRetryCount
+	c.init = func() (net.Conn, error) {
+		conn, err := handshake(true)
+		if err == errLegacyPeer {
+			log.Debugf("%s connection to #<%s>: %v, falling back to protocol version 0", t, remote, err)
+			return handshake(false)
+		}
+		return conn, err
 	}
-	return &tcpConnection{
-		init:      init,
-		src:       local,
-		dest:      remote,
-		initRetry: initRetry,
-		connType:  t,
+	if t == ConnCollective || t == ConnPeerToPeer {
+		c.initRetry = config.ConnRetryCount
 	}
+	return c
 }
 
 type tcpConnection struct {
@@ -107,9 +290,12 @@ type tcpConnection struct {
 	conn      net.Conn
 	initRetry int
 	connType  ConnType
+	hello     connectionHello // negotiated
+	dscp      int             // marks the packets of the socket when it's established, 0 for best effort
+	mw        messageWriter   // of large messages
 }
 
-var errCantEstablishConnection = errors.New("can't establish connection")
+var errCantEstablishConnection = failure.New(failure.PeerCrash, "can't establish connection")
 
 func (c *tcpConnection) Conn() net.Conn {
 	return c.conn
@@ -127,6 +313,14 @@ func (c *tcpConnection) Dest() plan.PeerID {
 	return c.dest
 }
 
+func (c *tcpConnection) Version() uint16 {
+	return c.hello.Version
+}
+
+func (c *tcpConnection) Features() uint32 {
+	return c.hello.Features
+}
+
 func (c *tcpConnection) initOnce() error {
 	c.Lock()
 	defer c.Unlock()
@@ -134,24 +328,39 @@ func (c *tcpConnection) initOnce() error {
 		return nil
 	}
 	t0 := time.Now()
+	var err error
 	for i := 0; i <= c.initRetry; i++ {
-		var err error
 		if c.conn, err = c.init(); err == nil {
+			if c.dscp > 0 {
+				if err := setDSCP(c.conn, c.dscp); err != nil {
+					log.Warnf("can't mark connection to #<%s> by DSCP %d: %v", c.dest, c.dscp, err)
+				}
+			}
 			log.Debugf("%s connection to #<%s> established after %d trials, took %s", c.connType, c.dest, i+1, time.Since(t0))
 			return nil
 		}
 		log.Debugf("failed to establish connection to #<%s> for %d times: %v", c.dest, i+1, err)
 		time.Sleep(config.ConnRetryPeriod)
 	}
-	return errCantEstablishConnection
+	return fmt.Errorf("%w: %w", errCantEstablishConnection, err) // the last error, e.g. an invalid token during a resize
 }
 
 func (c *tcpConnection) Send(name string, m Message, flags uint32) error {
+	_, err := c.SendWait(name, m, flags)
+	return err
+}
+
+func (c *tcpConnection) SendWait(name string, m Message, flags uint32) (time.Duration, error) {
 	if err := c.initOnce(); err != nil {
-		return err
+		return 0, err
 	}
+	t0 := time.Now()
 	c.Lock()
 	defer c.Unlock()
+	wait := time.Since(t0)
+	if config.ZeroCopyThreshold > 0 && len(m.Data) >= config.ZeroCopyThreshold {
+		return wait, c.mw.write(c.conn, name, m, flags)
+	}
 	bs := []byte(name)
 	mh := MessageHeader{
 		NameLength: uint32(len(bs)),
@@ -159,9 +368,9 @@ func (c *tcpConnection) Send(name string, m Message, flags uint32) error {
 		Flags:      flags,
 	}
 	if err := mh.WriteTo(c.conn); err != nil {
-		return err
+		return wait, err
 	}
-	return m.WriteTo(c.conn)
+	return wait, m.WriteTo(c.conn)
 }
 
 func (c *tcpConnection) Read(name string, m Message) error {
@@ -180,5 +389,8 @@ func (c *tcpConnection) Read(name string, m Message) error {
 func (c *tcpConnection) Close() error {
 	c.Lock()
 	defer c.Unlock()
+	if c.conn == nil { // not used yet
+		return nil
+	}
 	return c.conn.Close()
 }
@@ -0,0 +1,37 @@
+package connection
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDSCP marks the packets of a TCP connection by dscp, in the upper 6 bits of the TOS of IPv4 or the traffic class of IPv6.
+// It marks the TCP connection under a TLS connection, and does nothing for other connections, e.g. Unix sockets.
+func setDSCP(conn net.Conn, dscp int) error {
+	if tc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tc.NetConn()
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ipv6 := false
+	if a, ok := tc.LocalAddr().(*net.TCPAddr); ok && a.IP.To4() == nil {
+		ipv6 = true
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+		}
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,81 @@
+package connection
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_setDSCP(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := setDSCP(conn, 46); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	rc.Control(func(fd uintptr) { tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS) })
+	if err != nil || tos != 46<<2 {
+		t.Fatalf("TOS %d, want %d: %v", tos, 46<<2, err)
+	}
+}
+
+func Test_markedConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	remote, err := plan.ParsePeerID(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := UpgradeFrom(conn, *remote, 0, nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	local := plan.PeerID{IPv4: remote.IPv4 + 1, Port: remote.Port}
+	tosOf := func(c Connection) int {
+		rc, err := c.Conn().(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tos int
+		rc.Control(func(fd uintptr) { tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tos
+	}
+	for _, dscp := range []int{0, 46} {
+		c := NewMultiplexed(*remote, local, ConnPeerToPeer, 0, false, TransportTCP, dscp)
+		if err := c.(*tcpConnection).initOnce(); err != nil {
+			t.Fatal(err)
+		}
+		if tos := tosOf(c); tos != dscp<<2 {
+			t.Errorf("TOS %d of connection of DSCP %d, want %d", tos, dscp, dscp<<2)
+		}
+		c.Close()
+	}
+}
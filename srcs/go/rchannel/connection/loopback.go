@@ -0,0 +1,56 @@
+package connection
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// A LocalEndpoint receives the messages of peers of the same process by memory handoff instead of a socket,
+// e.g. of peers simulated in one process. Deliver takes the ownership of m, and is called in the order of messages
+// from each peer, as the reader of a connection would. An error of Deliver closes the stream of messages from src.
+type LocalEndpoint interface {
+	Deliver(src plan.PeerID, name string, m *Message, flags uint32) error
+}
+
+type localKey struct {
+	id plan.PeerID
+	t  ConnType
+}
+
+type localServer struct {
+	e     LocalEndpoint
+	token uint32 // updated atomically
+}
+
+var localServers sync.Map // localKey -> *localServer
+
+// ServeLocal makes e receive the messages of type t to self from peers of the same process.
+func ServeLocal(self plan.PeerID, t ConnType, e LocalEndpoint) {
+	localServers.Store(localKey{self, t}, &localServer{e: e})
+}
+
+// StopLocal stops ServeLocal of type t to self.
+func StopLocal(self plan.PeerID, t ConnType) {
+	localServers.Delete(localKey{self, t})
+}
+
+// SetLocalToken sets the token of the local endpoints of self, as the server of self checks the tokens of connections.
+func SetLocalToken(self plan.PeerID, token uint32) {
+	for _, t := range []ConnType{ConnPing, ConnControl, ConnCollective, ConnPeerToPeer} {
+		if v, ok := localServers.Load(localKey{self, t}); ok {
+			atomic.StoreUint32(&v.(*localServer).token, token)
+		}
+	}
+}
+
+// LookupLocal returns the endpoint of type t of remote in the same process, and its token.
+func LookupLocal(remote plan.PeerID, t ConnType) (LocalEndpoint, uint32, bool) {
+	v, ok := localServers.Load(localKey{remote, t})
+	if !ok {
+		return nil, 0, false
+	}
+	s := v.(*localServer)
+	return s.e, atomic.LoadUint32(&s.token), true
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
 )
 
 type ConnType uint16
@@ -17,7 +20,7 @@ const (
 )
 
 var (
-	ErrInvalidConnectionType = errors.New("invalid connection type")
+	ErrInvalidConnectionType = failure.New(failure.ProtocolMismatch, "invalid connection type")
 )
 
 func (t ConnType) String() string {
@@ -65,6 +68,51 @@ func (a *connectionACK) ReadFrom(r io.Reader) error {
 	return binary.Read(r, endian, a)
 }
 
+// ProtocolVersion is the version of the wire protocol.
+// Version 0 is the legacy protocol, which has no version handshake.
+const ProtocolVersion uint16 = 1
+
+// versionedType is set in connectionHeader.Type if a connectionHello follows the header.
+// Legacy servers reject such connections as of invalid type.
+const versionedType uint16 = 1 << 15
+
+// Features that can be negotiated at connection setup.
+const (
+	FeatureCompression     uint32 = 1 << iota
+	FeatureEncryption      uint32 = 1 << iota
+	FeatureSequenceNumbers uint32 = 1 << iota
+)
+
+// LocalFeatures is the bitmap of features supported by this peer.
+var LocalFeatures uint32
+
+// connectionHello is exchanged after connectionHeader and connectionACK if versionedType is set.
+// The server replies with the negotiated hello.
+type connectionHello struct {
+	Version  uint16
+	Reserved uint16
+	Features uint32
+}
+
+func (h connectionHello) writeHello(w io.Writer) error {
+	return binary.Write(w, endian, &h)
+}
+
+func (h *connectionHello) readHello(r io.Reader) error {
+	return binary.Read(r, endian, h)
+}
+
+// negotiate returns the common subset of two hellos.
+func negotiate(a, b connectionHello) connectionHello {
+	v := a.Version
+	if b.Version < v {
+		v = b.Version
+	}
+	return connectionHello{Version: v, Features: a.Features & b.Features}
+}
+
+var legacyHello = connectionHello{Version: 0, Features: 0}
+
 const NoFlag uint32 = 0
 
 const (
@@ -113,6 +161,8 @@ func (h *MessageHeader) ReadFrom(r io.Reader) error {
 	return nil
 }
 
+var errUnexpectedName = failure.New(failure.ProtocolMismatch, "unexpected name")
+
 // Expect reads the messageHeader from a reader into new buffer.
 // The result Name should be checked against name.
 func (h *MessageHeader) Expect(r io.Reader, name string) error {
@@ -120,14 +170,14 @@ func (h *MessageHeader) Expect(r io.Reader, name string) error {
 		return err
 	}
 	if int(h.NameLength) != len(name) {
-		return fmt.Errorf("unexpected name length: %d", h.NameLength)
+		return fmt.Errorf("%w length: %d", errUnexpectedName, h.NameLength)
 	}
 	h.Name = make([]byte, h.NameLength)
 	if err := readN(r, h.Name, int(h.NameLength)); err != nil {
 		return err
 	}
 	if string(h.Name) != name {
-		return fmt.Errorf("unexpected name %s", h.Name)
+		return fmt.Errorf("%w %s", errUnexpectedName, h.Name)
 	}
 	if err := binary.Read(r, endian, &h.Flags); err != nil {
 		return err
@@ -176,7 +226,7 @@ func (m *Message) ReadFrom(r io.Reader) error {
 	return nil
 }
 
-var errUnexpectedMessageLength = errors.New("Unexpected message length")
+var errUnexpectedMessageLength = failure.New(failure.ProtocolMismatch, "Unexpected message length")
 
 // ReadInto reads the message from a reader into existing buffer.
 // The message length obtained from the reader should be checked.
@@ -198,6 +248,28 @@ func (m Message) String() string {
 	return fmt.Sprintf("message{length=%d}", m.Length)
 }
 
+// messageWriter writes the header and the message as MessageHeader.WriteTo and Message.WriteTo,
+// by a single scatter-gather write (writev on TCP and Unix sockets) of the encoded headers and the message data,
+// so that the data is sent from its buffer without intermediate copies or extra syscalls.
+// Its buffers are reused by the writes of a connection, which must not be concurrent.
+type messageWriter struct {
+	hdr  []byte
+	iov  [2][]byte
+	bufs net.Buffers
+}
+
+func (mw *messageWriter) write(w io.Writer, name string, m Message, flags uint32) error {
+	mw.hdr = endian.AppendUint32(mw.hdr[:0], uint32(len(name)))
+	mw.hdr = append(mw.hdr, name...)
+	mw.hdr = endian.AppendUint32(mw.hdr, flags)
+	mw.hdr = endian.AppendUint32(mw.hdr, m.Length)
+	mw.iov = [2][]byte{mw.hdr, m.Data}
+	mw.bufs = mw.iov[:]
+	_, err := mw.bufs.WriteTo(w) // consumes bufs
+	mw.iov[1] = nil              // not to retain the data
+	return err
+}
+
 func readN(r io.Reader, buffer []byte, n int) error {
 	for offset := 0; offset < n; {
 		n, err := r.Read(buffer[offset:])
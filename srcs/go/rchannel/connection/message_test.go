@@ -2,6 +2,10 @@ package connection
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
 	"testing"
 )
 
@@ -112,3 +116,97 @@ func repeat(n int, str string) string {
 	}
 	return ss
 }
+
+func Test_negotiate(t *testing.T) {
+	a := connectionHello{Version: 2, Features: FeatureCompression | FeatureSequenceNumbers}
+	b := connectionHello{Version: 1, Features: FeatureSequenceNumbers | FeatureEncryption}
+	buf := &bytes.Buffer{}
+	if err := a.writeHello(buf); err != nil {
+		t.Fatalf("failed to write hello: %v", err)
+	}
+	var a2 connectionHello
+	if err := a2.readHello(buf); err != nil {
+		t.Fatalf("failed to read hello: %v", err)
+	}
+	h := negotiate(a2, b)
+	if h.Version != 1 || h.Features != FeatureSequenceNumbers {
+		t.Errorf("unexpected negotiated hello: %+v", h)
+	}
+	if h := negotiate(a, legacyHello); h.Version != 0 || h.Features != 0 {
+		t.Errorf("unexpected negotiated hello with legacy peer: %+v", h)
+	}
+}
+
+func Test_messageWriter(t *testing.T) {
+	bs := []byte("123456")
+	m := Message{Length: uint32(len(bs)), Data: bs}
+	want := &bytes.Buffer{}
+	h := MessageHeader{NameLength: 4, Name: []byte("name"), Flags: WaitRecvBuf}
+	if err := h.WriteTo(want); err != nil {
+		t.Fatalf("MessageHeader::WriteTo failed: %v", err)
+	}
+	if err := m.WriteTo(want); err != nil {
+		t.Fatalf("Message::WriteTo failed: %v", err)
+	}
+	var mw messageWriter
+	for i := 0; i < 2; i++ { // reusing its buffers
+		got := &bytes.Buffer{}
+		if err := mw.write(got, "name", m, WaitRecvBuf); err != nil {
+			t.Fatalf("messageWriter::write failed: %v", err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("messageWriter::write wrote %v, want %v", got.Bytes(), want.Bytes())
+		}
+	}
+}
+
+// Benchmark_sendMessage compares sending large messages over a TCP loopback connection
+// by separate writes of the headers and the data, and by a single scatter-gather write.
+func Benchmark_sendMessage(b *testing.B) {
+	for _, size := range []int{64 << 20, 256 << 20} {
+		m := Message{Length: uint32(size), Data: make([]byte, size)}
+		name := strconv.Itoa(size>>20) + "MiB"
+		b.Run("copy/"+name, func(b *testing.B) {
+			benchmarkSend(b, m, func(w io.Writer) error {
+				mh := MessageHeader{NameLength: 4, Name: []byte("name")}
+				if err := mh.WriteTo(w); err != nil {
+					return err
+				}
+				return m.WriteTo(w)
+			})
+		})
+		b.Run("writev/"+name, func(b *testing.B) {
+			var mw messageWriter
+			benchmarkSend(b, m, func(w io.Writer) error { return mw.write(w, "name", m, NoFlag) })
+		})
+	}
+}
+
+func benchmarkSend(b *testing.B, m Message, send func(io.Writer) error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	b.SetBytes(int64(m.Length))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := send(conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package connection
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// quicStreams is the number of streams of the QUIC connection to a remote host that the message names are hashed onto.
+const quicStreams = 32
+
+// NewMultiplexed returns a Connection as New, except that the collective messages to a remote host over QUIC
+// are sent on quicStreams streams by message name, so that the chunks of a collective don't block each other when
+// packets are lost, and those over TCP are spread by name over config.StreamsPerPeer connections.
+// The packets of its TCP connections are marked by dscp if it's positive, the QUIC streams share a UDP socket and aren't.
+func NewMultiplexed(remote, local plan.PeerID, t ConnType, token uint32, useUnixSock bool, transport string, dscp int) Connection {
+	if t == ConnCollective && useQUIC(remote, local, transport) {
+		return &streamConnection{
+			src:      local,
+			dest:     remote,
+			connType: t,
+			token:    token,
+			streams:  make([]*tcpConnection, quicStreams),
+		}
+	}
+	if t == ConnCollective && useStripes(remote, local, transport) {
+		return newStripedConnection(remote, local, t, token, config.StreamsPerPeer, dscp)
+	}
+	c := New(remote, local, t, token, useUnixSock, transport)
+	c.dscp = dscp
+	return c
+}
+
+// streamConnection is a Connection of a bounded set of streams of the QUIC connection to a remote host, which are
+// opened on the first message hashed onto them. The messages of a name remain in order, as they are on the same stream,
+// and the number of streams doesn't grow with the names of messages, e.g. of the chunks of all tensors.
+type streamConnection struct {
+	sync.Mutex
+	src, dest plan.PeerID
+	connType  ConnType
+	token     uint32
+	streams   []*tcpConnection // nil until opened
+	last      *tcpConnection   // the latest stream, of the negotiated version and features
+}
+
+// streamOf returns the index of the stream among n that the messages of name are sent on.
+func streamOf(name string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(n))
+}
+
+func (c *streamConnection) stream(name string) *tcpConnection {
+	c.Lock()
+	defer c.Unlock()
+	i := streamOf(name, len(c.streams))
+	if c.streams[i] == nil {
+		c.streams[i] = newConnection(c.dest, c.src, c.connType, c.token, func() (net.Conn, error) { return dialQUIC(c.dest) })
+		c.last = c.streams[i]
+	}
+	return c.streams[i]
+}
+
+func (c *streamConnection) Conn() net.Conn {
+	c.Lock()
+	defer c.Unlock()
+	if c.last == nil {
+		return nil
+	}
+	return c.last.Conn()
+}
+
+func (c *streamConnection) Type() ConnType {
+	return c.connType
+}
+
+func (c *streamConnection) Src() plan.PeerID {
+	return c.src
+}
+
+func (c *streamConnection) Dest() plan.PeerID {
+	return c.dest
+}
+
+func (c *streamConnection) Version() uint16 {
+	c.Lock()
+	defer c.Unlock()
+	if c.last == nil {
+		return 0
+	}
+	return c.last.Version()
+}
+
+func (c *streamConnection) Features() uint32 {
+	c.Lock()
+	defer c.Unlock()
+	if c.last == nil {
+		return 0
+	}
+	return c.last.Features()
+}
+
+func (c *streamConnection) Send(name string, m Message, flags uint32) error {
+	return c.stream(name).Send(name, m, flags)
+}
+
+func (c *streamConnection) SendWait(name string, m Message, flags uint32) (time.Duration, error) {
+	return c.stream(name).SendWait(name, m, flags)
+}
+
+func (c *streamConnection) Read(name string, m Message) error {
+	return c.stream(name).Read(name, m)
+}
+
+func (c *streamConnection) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	for _, s := range c.streams {
+		if s == nil {
+			continue
+		}
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
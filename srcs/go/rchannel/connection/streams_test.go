@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_streamOf(t *testing.T) {
+	used := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("grad-%d:chunk-%d", i/10, i%10)
+		s := streamOf(name, quicStreams)
+		if s < 0 || s >= quicStreams {
+			t.Fatalf("stream of %q is %d, out of %d streams", name, s, quicStreams)
+		}
+		if streamOf(name, quicStreams) != s {
+			t.Fatalf("stream of %q changed", name)
+		}
+		used[s] = true
+	}
+	if len(used) != quicStreams {
+		t.Errorf("%d names are sent on %d streams, want %d", 1000, len(used), quicStreams)
+	}
+}
+
+func Test_transport(t *testing.T) {
+	local := plan.PeerID{IPv4: plan.MustParseIPv4("10.0.0.1"), Port: 10000}
+	colocated := plan.PeerID{IPv4: local.IPv4, Port: 10001}
+	remote := plan.PeerID{IPv4: plan.MustParseIPv4("10.0.0.2"), Port: 10000}
+	tests := []struct {
+		transport  string
+		quic, rdma bool
+	}{
+		{TransportTCP, false, false},
+		{TransportQUIC, true, false},
+		{TransportRDMA, false, true},
+	}
+	for _, tt := range tests {
+		if useQUIC(remote, local, tt.transport) != tt.quic || useRDMA(remote, local, tt.transport) != tt.rdma {
+			t.Errorf("transport %s: quic %t, rdma %t", tt.transport, useQUIC(remote, local, tt.transport), useRDMA(remote, local, tt.transport))
+		}
+		if useQUIC(colocated, local, tt.transport) || useRDMA(colocated, local, tt.transport) {
+			t.Errorf("transport %s is used between colocated peers", tt.transport)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// useStripes returns true if collective messages to remote are spread over config.StreamsPerPeer TCP connections.
+func useStripes(remote, local plan.PeerID, transport string) bool {
+	return config.StreamsPerPeer > 1 && transport == TransportTCP && !remote.ColocatedWith(local)
+}
+
+// stripedConnection is a Connection of parallel TCP connections (streams) to a remote host, for links whose bandwidth
+// a single TCP connection can't fill. Messages are spread over the streams by name: each name is assigned to a stream
+// in turn, so that the chunks of collectives, which are of different names, are sent in parallel. A message is sent
+// whole by a single stream, so that the receiver needs no reassembly, and the messages of a name remain in order.
+// A failed stream fails the message being sent, rather than sending it again by another stream, as the messages it has
+// sent before may be lost with it, and the receiver fails the receive of a message it failed to read. The names of
+// a failed stream are assigned to the other streams, for the next collectives, until all streams have failed.
+type stripedConnection struct {
+	sync.Mutex
+	src, dest plan.PeerID
+	connType  ConnType
+	streams   []*tcpConnection
+	failed    []bool
+	names     map[string]int // the stream of each name
+	next      int            // the stream of the next new name
+}
+
+func newStripedConnection(remote, local plan.PeerID, t ConnType, token uint32, n, dscp int) *stripedConnection {
+	c := &stripedConnection{
+		src:      local,
+		dest:     remote,
+		connType: t,
+		failed:   make([]bool, n),
+		names:    make(map[string]int),
+	}
+	for i := 0; i < n; i++ {
+		s := New(remote, local, t, token, false, TransportTCP)
+		s.dscp = dscp
+		c.streams = append(c.streams, s)
+	}
+	return c
+}
+
+var errNoStream = failure.New(failure.PeerCrash, "no stream left")
+
+// stream returns the stream of name, assigning the next healthy stream to a new name or to a name of a failed stream.
+func (c *stripedConnection) stream(name string) (int, *tcpConnection, error) {
+	c.Lock()
+	defer c.Unlock()
+	if i, ok := c.names[name]; ok && !c.failed[i] {
+		return i, c.streams[i], nil
+	}
+	for j := range c.streams {
+		i := (c.next + j) % len(c.streams)
+		if !c.failed[i] {
+			c.next = i + 1
+			c.names[name] = i
+			return i, c.streams[i], nil
+		}
+	}
+	return 0, nil, errNoStream
+}
+
+func (c *stripedConnection) fail(i int, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.failed[i] {
+		return
+	}
+	c.failed[i] = true
+	c.streams[i].Close()
+	log.Warnf("stream %d/%d of %s connection to #<%s> failed: %v", i+1, len(c.streams), c.connType, c.dest, err)
+}
+
+func (c *stripedConnection) Conn() net.Conn {
+	return c.streams[0].Conn()
+}
+
+func (c *stripedConnection) Type() ConnType {
+	return c.connType
+}
+
+func (c *stripedConnection) Src() plan.PeerID {
+	return c.src
+}
+
+func (c *stripedConnection) Dest() plan.PeerID {
+	return c.dest
+}
+
+func (c *stripedConnection) Version() uint16 {
+	return c.streams[0].Version()
+}
+
+func (c *stripedConnection) Features() uint32 {
+	return c.streams[0].Features()
+}
+
+func (c *stripedConnection) Send(name string, m Message, flags uint32) error {
+	_, err := c.SendWait(name, m, flags)
+	return err
+}
+
+func (c *stripedConnection) SendWait(name string, m Message, flags uint32) (time.Duration, error) {
+	i, s, err := c.stream(name)
+	if err != nil {
+		return 0, err
+	}
+	wait, err := s.SendWait(name, m, flags)
+	if err != nil {
+		c.fail(i, err)
+		return wait, fmt.Errorf("stream %d of %s connection to #<%s>: %w", i+1, c.connType, c.dest, err)
+	}
+	return wait, nil
+}
+
+func (c *stripedConnection) Read(name string, m Message) error {
+	_, s, err := c.stream(name)
+	if err != nil {
+		return err
+	}
+	return s.Read(name, m)
+}
+
+func (c *stripedConnection) Close() error {
+	var err error
+	for _, s := range c.streams {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package connection
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+func Test_stripedConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	remote, err := plan.ParsePeerID(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string]net.Conn) // the server side stream of each message
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c, err := UpgradeFrom(conn, *remote, 0, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go Stream(c, func(c Connection) (string, *Message, error) {
+				var mh MessageHeader
+				if err := mh.ReadFrom(c.Conn()); err != nil {
+					return "", nil, err
+				}
+				var m Message
+				if err := m.ReadFrom(c.Conn()); err != nil {
+					return "", nil, err
+				}
+				return string(mh.Name), &m, nil
+			}, func(name string, _ *Message, c Connection) {
+				mu.Lock()
+				received[name] = c.Conn()
+				mu.Unlock()
+				wg.Done()
+			})
+		}
+	}()
+	local := plan.PeerID{IPv4: remote.IPv4 + 1, Port: remote.Port}
+	c := newStripedConnection(*remote, local, ConnCollective, 0, 3, 0)
+	defer c.Close()
+	send := func(names ...string) {
+		wg.Add(len(names))
+		for _, name := range names {
+			if err := c.Send(name, Message{Length: 1, Data: []byte{1}}, NoFlag); err != nil {
+				t.Fatalf("failed to send %s: %v", name, err)
+			}
+		}
+		wg.Wait()
+	}
+	send("a", "b", "c", "d")
+	if received["a"] == received["b"] || received["b"] == received["c"] || received["a"] != received["d"] {
+		t.Errorf("names are not striped over streams")
+	}
+	c.streams[0].conn.Close() // the stream of a and d
+	if err := c.Send("a", Message{Length: 1, Data: []byte{1}}, NoFlag); err == nil {
+		t.Errorf("a is sent by a failed stream")
+	}
+	send("a", "b")
+	if received["a"] == received["d"] {
+		t.Errorf("a is not moved to another stream")
+	}
+	if c.names["a"] == 0 || !c.failed[0] {
+		t.Errorf("unexpected streams after failure: %v, failed: %v", c.names, c.failed)
+	}
+	for _, s := range c.streams[1:] {
+		s.conn.Close()
+	}
+	for _, name := range []string{"a", "b"} {
+		c.Send(name, Message{Length: 1, Data: []byte{1}}, NoFlag)
+	}
+	if err := c.Send("c", Message{Length: 1, Data: []byte{1}}, NoFlag); !errors.Is(err, errNoStream) {
+		t.Errorf("sent by failed streams: %v", err)
+	}
+}
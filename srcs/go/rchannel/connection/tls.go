@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// TLS of connections between hosts is enabled by config.TLSCertFile, config.TLSKeyFile and config.TLSCAFile.
+// Both sides of a connection present certificates signed by the CA, and the certificate of a peer must have the IPv4 of
+// its PeerID as an IP SAN, so that peers are authenticated as the members of the peer list that they claim to be:
+// a client verifies the certificate of the server against the PeerID it dials, and a server verifies the certificate
+// of a client against the PeerID in the connection header, which must also be a member of the cluster of the server,
+// once the server has its members set.
+// A certificate with URI SANs of peerURIScheme, e.g. kungfu://10.0.0.1:10000, is bound to the peers of these URIs,
+// rather than to all peers of its host.
+var tlsConfig = struct {
+	sync.Once
+	cert tls.Certificate
+	ca   *x509.CertPool
+	err  error
+}{}
+
+var (
+	errNoPeerCert   = errors.New("no certificate of the peer")
+	errPeerIdentity = errors.New("certificate not of the peer")
+)
+
+// peerURIScheme is the scheme of the URI SANs binding a certificate to peers.
+const peerURIScheme = `kungfu`
+
+// EnableTLS returns true if connections between hosts use mutual TLS.
+func EnableTLS() bool {
+	return len(config.TLSCertFile) > 0 || len(config.TLSKeyFile) > 0 || len(config.TLSCAFile) > 0
+}
+
+func loadTLS() (tls.Certificate, *x509.CertPool, error) {
+	tlsConfig.Do(func() {
+		if len(config.TLSCertFile) == 0 || len(config.TLSKeyFile) == 0 || len(config.TLSCAFile) == 0 {
+			tlsConfig.err = errors.New("TLS requires the cert, key and CA files")
+			return
+		}
+		if tlsConfig.cert, tlsConfig.err = tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile); tlsConfig.err != nil {
+			return
+		}
+		var pem []byte
+		if pem, tlsConfig.err = ioutil.ReadFile(config.TLSCAFile); tlsConfig.err != nil {
+			return
+		}
+		tlsConfig.ca = x509.NewCertPool()
+		if !tlsConfig.ca.AppendCertsFromPEM(pem) {
+			tlsConfig.err = fmt.Errorf("no certificate in %s", config.TLSCAFile)
+		}
+	})
+	return tlsConfig.cert, tlsConfig.ca, tlsConfig.err
+}
+
+// ClientTLSConfig returns the TLS config of connections to remote, which verifies that the server is remote.
+func ClientTLSConfig(remote plan.PeerID) (*tls.Config, error) {
+	cert, ca, err := loadTLS()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca,
+		ServerName:   plan.FormatIPv4(remote.IPv4), // verified against the IP SANs
+		MinVersion:   tls.VersionTLS12,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyIdentity(cs.PeerCertificates, remote)
+		},
+	}, nil
+}
+
+// ServerTLSConfig returns the TLS config of accepted connections, which requires a client certificate signed by the CA.
+func ServerTLSConfig() (*tls.Config, error) {
+	cert, ca, err := loadTLS()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    ca,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// tlsConn is a net.Conn secured by TLS, e.g. a *tls.Conn or a stream of a QUIC connection.
+type tlsConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// verifyPeer checks that the verified certificate of the client of conn is of src, if conn is secured by TLS.
+func verifyPeer(conn net.Conn, src plan.PeerID) error {
+	tc, ok := conn.(tlsConn)
+	if !ok {
+		return nil
+	}
+	return verifyIdentity(tc.ConnectionState().PeerCertificates, src)
+}
+
+// verifyIdentity checks that the first of the verified certs is of id: it must have the IPv4 of id as an IP SAN,
+// and the URI of id if it has URI SANs of peerURIScheme.
+func verifyIdentity(certs []*x509.Certificate, id plan.PeerID) error {
+	if len(certs) == 0 {
+		return errNoPeerCert
+	}
+	cert := certs[0]
+	if err := cert.VerifyHostname(plan.FormatIPv4(id.IPv4)); err != nil {
+		return err
+	}
+	var bound bool
+	for _, u := range cert.URIs {
+		if u.Scheme != peerURIScheme {
+			continue
+		}
+		if u.Host == id.String() {
+			return nil
+		}
+		bound = true
+	}
+	if bound {
+		return fmt.Errorf("%w %s, bound to other peers of its host", errPeerIdentity, id)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kungfu-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate of ip, bound to the peers of uris if any.
+func (ca *testCA) issue(t *testing.T, ip string, uris ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func mustParsePeerID(t *testing.T, s string) plan.PeerID {
+	id, err := plan.ParsePeerID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *id
+}
+
+func Test_verifyIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	host := ca.issue(t, "127.0.0.1")
+	bound := ca.issue(t, "127.0.0.1", "kungfu://127.0.0.1:10000", "spiffe://cluster/worker")
+	tests := []struct {
+		cert tls.Certificate
+		id   string
+		ok   bool
+	}{
+		{host, "127.0.0.1:10000", true},
+		{host, "127.0.0.1:10001", true},
+		{host, "127.0.0.2:10000", false},
+		{bound, "127.0.0.1:10000", true},
+		{bound, "127.0.0.1:10001", false},
+	}
+	for _, tt := range tests {
+		err := verifyIdentity([]*x509.Certificate{tt.cert.Leaf}, mustParsePeerID(t, tt.id))
+		if (err == nil) != tt.ok {
+			t.Errorf("verifyIdentity(%v of %s) = %v, want ok: %t", tt.cert.Leaf.URIs, tt.id, err, tt.ok)
+		}
+	}
+	if err := verifyIdentity(nil, mustParsePeerID(t, "127.0.0.1:10000")); !errors.Is(err, errNoPeerCert) {
+		t.Errorf("verifyIdentity without certificates = %v", err)
+	}
+}
+
+// upgradeTLS upgrades a connection of mutual TLS from src to self, whose members are checked by isMember.
+func upgradeTLS(t *testing.T, ca *testCA, cert tls.Certificate, src, self plan.PeerID, isMember func(plan.PeerID) bool) error {
+	c, s := net.Pipe()
+	server := tls.Server(s, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "127.0.0.1")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	client := tls.Client(c, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca.pool,
+		ServerName:   plan.FormatIPv4(self.IPv4),
+	})
+	go func() {
+		defer client.Close()
+		if err := (connectionHeader{SrcPort: src.Port, SrcIPv4: src.IPv4}).WriteTo(client); err != nil {
+			return
+		}
+		io.Copy(io.Discard, client)
+	}()
+	defer server.Close()
+	_, err := UpgradeFrom(server, self, 0, isMember)
+	return err
+}
+
+func Test_UpgradeFromTLS(t *testing.T) {
+	ca := newTestCA(t)
+	self := mustParsePeerID(t, "127.0.0.1:10000")
+	member := mustParsePeerID(t, "127.0.0.1:10001")
+	stranger := mustParsePeerID(t, "127.0.0.1:10002")
+	members := plan.PeerList{self, member}.Set()
+	isMember := func(id plan.PeerID) bool { _, ok := members[id]; return ok }
+	host := ca.issue(t, "127.0.0.1")
+	if err := upgradeTLS(t, ca, host, member, self, isMember); err != nil {
+		t.Errorf("connection of a member refused: %v", err)
+	}
+	if err := upgradeTLS(t, ca, host, stranger, self, isMember); !errors.Is(err, errNotMember) {
+		t.Errorf("connection of a peer not of the cluster: %v, want %v", err, errNotMember)
+	}
+	if err := upgradeTLS(t, ca, host, stranger, self, nil); err != nil {
+		t.Errorf("connection refused without members: %v", err)
+	}
+	bound := ca.issue(t, "127.0.0.1", "kungfu://"+member.String())
+	if err := upgradeTLS(t, ca, bound, self, self, isMember); !errors.Is(err, errPeerIdentity) {
+		t.Errorf("connection claiming another peer than its certificate: %v, want %v", err, errPeerIdentity)
+	}
+}
@@ -2,20 +2,119 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
+	"github.com/lsds/KungFu/srcs/go/monitor"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 )
 
+// abortion is the abort of the receives of a session, which is closed by the first Abort of the session.
+type abortion struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newAbortion() *abortion {
+	return &abortion{done: make(chan struct{})}
+}
+
+func (a *abortion) abort(err error) {
+	a.once.Do(func() {
+		a.err = err
+		close(a.done)
+	})
+}
+
 type CollectiveEndpoint struct {
-	waitQ *BufferPool
-	recvQ *BufferPool
+	waitQ    *BufferPool
+	recvQ    *BufferPool
+	readErrs sync.Map // plan.Addr -> error, of the messages failed to be read into the buffers of receives
+	monitor  monitor.Monitor
+
+	mu       sync.Mutex
+	version  uint32               // of the current session
+	aborted  *abortion            // of the current session
+	upcoming map[uint32]*abortion // of the sessions aborted by peers before they started here
 }
 
 func NewCollectiveEndpoint() *CollectiveEndpoint {
 	return &CollectiveEndpoint{
-		waitQ: newBufferPool(1),
-		recvQ: newBufferPool(1),
+		waitQ:    newBufferPool(1),
+		recvQ:    newBufferPool(1),
+		monitor:  monitor.GetMonitor(),
+		aborted:  newAbortion(),
+		upcoming: make(map[uint32]*abortion),
+	}
+}
+
+// Renew starts the session of the given version, e.g. the cluster version, so that the receives of the new session
+// don't fail by the Abort of the previous ones.
+func (e *CollectiveEndpoint) Renew(version uint32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if version == e.version {
+		return
+	}
+	e.version = version
+	if a, ok := e.upcoming[version]; ok {
+		e.aborted = a
+	} else {
+		e.aborted = newAbortion()
+	}
+	for v := range e.upcoming {
+		if v <= version {
+			delete(e.upcoming, v)
+		}
+	}
+}
+
+// Version returns the version of the current session.
+func (e *CollectiveEndpoint) Version() uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.version
+}
+
+func (e *CollectiveEndpoint) current() *abortion {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.aborted
+}
+
+// Abort makes all pending and later receives of the current session fail with err, only the first call takes effect.
+func (e *CollectiveEndpoint) Abort(err error) {
+	e.current().abort(err)
+}
+
+// AbortVersion is Abort of the session of the given version. The abort of a previous session is ignored,
+// and the abort of a later session takes effect once it's started by Renew.
+func (e *CollectiveEndpoint) AbortVersion(version uint32, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case version == e.version:
+		e.aborted.abort(err)
+	case version > e.version:
+		a, ok := e.upcoming[version]
+		if !ok {
+			a = newAbortion()
+			e.upcoming[version] = a
+		}
+		a.abort(err)
+	}
+}
+
+// Aborted returns the error of Abort of the current session, or nil if not aborted.
+func (e *CollectiveEndpoint) Aborted() error {
+	a := e.current()
+	select {
+	case <-a.done:
+		return a.err
+	default:
+		return nil
 	}
 }
 
@@ -24,20 +123,81 @@ func (e *CollectiveEndpoint) Handle(conn connection.Connection) (int, error) {
 	return connection.Stream(conn, e.accept, e.handle)
 }
 
-func (e *CollectiveEndpoint) Recv(a plan.Addr) connection.Message {
-	m := <-e.recvQ.require(a)
-	return *m
+func (e *CollectiveEndpoint) Recv(a plan.Addr) (connection.Message, error) {
+	return e.RecvCancel(a, nil)
+}
+
+// ErrCancelled is the error of receives cancelled by their callers.
+var ErrCancelled = errors.New("receive cancelled")
+
+// RecvCancel is Recv, which fails with ErrCancelled once cancel is closed.
+func (e *CollectiveEndpoint) RecvCancel(a plan.Addr, cancel <-chan struct{}) (connection.Message, error) {
+	ab := e.current()
+	select {
+	case m := <-e.recvQ.require(a):
+		return *m, nil
+	case <-ab.done:
+		return connection.Message{}, ab.err
+	case <-cancel:
+		return connection.Message{}, ErrCancelled
+	}
 }
 
 var errRegisteredBufferNotUsed = errors.New("registered buffer not used")
 
 func (e *CollectiveEndpoint) RecvInto(a plan.Addr, m connection.Message) error {
-	e.waitQ.require(a) <- &m
-	pm := <-e.recvQ.require(a)
-	if !m.Same(pm) {
-		return errRegisteredBufferNotUsed
+	return e.RecvIntoCancel(a, m, nil)
+}
+
+// RecvIntoCancel is RecvInto, which fails with ErrCancelled once cancel is closed.
+// The buffer of m is not written after it returns, whether it's cancelled or aborted.
+func (e *CollectiveEndpoint) RecvIntoCancel(a plan.Addr, m connection.Message, cancel <-chan struct{}) error {
+	ab := e.current()
+	select {
+	case e.waitQ.require(a) <- &m:
+	case <-ab.done:
+		return ab.err
+	case <-cancel:
+		return ErrCancelled
+	}
+	select {
+	case pm := <-e.recvQ.require(a):
+		if pm == unread {
+			err, _ := e.readErrs.LoadAndDelete(a)
+			return err.(error)
+		}
+		if !m.Same(pm) {
+			return errRegisteredBufferNotUsed
+		}
+		return nil
+	case <-ab.done:
+		e.withdraw(a)
+		return ab.err
+	case <-cancel:
+		e.withdraw(a)
+		return ErrCancelled
 	}
-	return nil
+}
+
+// withdraw takes the buffer of a receive back from waitQ, or waits until the message being read into it is read,
+// or fails to be read.
+func (e *CollectiveEndpoint) withdraw(a plan.Addr) {
+	select {
+	case <-e.waitQ.require(a):
+	case pm := <-e.recvQ.require(a):
+		if pm == unread {
+			e.readErrs.Delete(a)
+		}
+	}
+}
+
+// unread is put into recvQ in place of the buffer of a receive, whose message failed to be read into it, so that the
+// receive fails with the error kept in readErrs, as the message is never sent again, e.g. by another stream of the peer.
+var unread = &connection.Message{}
+
+func (e *CollectiveEndpoint) failRead(a plan.Addr, err error) {
+	e.readErrs.Store(a, err)
+	e.recvQ.require(a) <- unread
 }
 
 func (e *CollectiveEndpoint) accept(conn connection.Connection) (string, *connection.Message, error) {
@@ -47,8 +207,10 @@ func (e *CollectiveEndpoint) accept(conn connection.Connection) (string, *connec
 	}
 	name := string(mh.Name)
 	if mh.HasFlag(connection.WaitRecvBuf) {
-		m := <-e.waitQ.require(conn.Src().WithName(name))
+		a := conn.Src().WithName(name)
+		m := <-e.waitQ.require(a)
 		if err := m.ReadInto(conn.Conn()); err != nil {
+			e.failRead(a, fmt.Errorf("reading %s from #<%s>: %w", name, conn.Src(), err))
 			return "", nil, err
 		}
 		return name, m, nil
@@ -60,6 +222,34 @@ func (e *CollectiveEndpoint) accept(conn connection.Connection) (string, *connec
 	return name, &m, nil
 }
 
+var errLocalMessageLength = errors.New("unexpected length of local message")
+
+// Deliver implements connection.LocalEndpoint, for messages from peers of the same process.
+// A message for a registered buffer waits for the buffer, unless the current session is aborted meanwhile.
+func (e *CollectiveEndpoint) Deliver(src plan.PeerID, name string, m *connection.Message, flags uint32) error {
+	a := src.WithName(name)
+	e.monitor.Ingress(int64(m.Length), a.NetAddr())
+	if flags&connection.WaitRecvBuf != 0 {
+		ab := e.current()
+		var pm *connection.Message
+		select {
+		case pm = <-e.waitQ.require(a):
+		case <-ab.done:
+			return ab.err
+		}
+		if pm.Length != m.Length {
+			e.waitQ.require(a) <- pm // the message is rejected before it's copied, for a message of the expected length
+			return fmt.Errorf("%w: %s of %d bytes from #<%s>, expected %d bytes", errLocalMessageLength, name, m.Length, src, pm.Length)
+		}
+		copy(pm.Data, m.Data)
+		connection.PutBuf(m.Data)
+		m = pm
+	}
+	e.recvQ.require(a) <- m
+	return nil
+}
+
 func (e *CollectiveEndpoint) handle(name string, msg *connection.Message, conn connection.Connection) {
+	e.monitor.Ingress(int64(msg.Length), conn.Src().WithName(name).NetAddr())
 	e.recvQ.require(conn.Src().WithName(name)) <- msg
 }
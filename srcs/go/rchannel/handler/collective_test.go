@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lsds/KungFu/srcs/go/plan"
+	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+)
+
+func Test_RecvIntoCancel(t *testing.T) {
+	e := NewCollectiveEndpoint()
+	a := plan.PeerID{IPv4: plan.MustParseIPv4(`127.0.0.1`), Port: 10000}.WithName("x")
+	m := connection.Message{Length: 4, Data: make([]byte, 4)}
+	cancel := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- e.RecvIntoCancel(a, m, cancel) }()
+	time.Sleep(10 * time.Millisecond)
+	close(cancel)
+	if err := <-done; err != ErrCancelled {
+		t.Fatalf("RecvIntoCancel: %v, want %v", err, ErrCancelled)
+	}
+	select {
+	case <-e.waitQ.require(a):
+		t.Errorf("the buffer of a cancelled receive is left to be written")
+	default:
+	}
+	if _, err := e.RecvCancel(a, cancel); err != ErrCancelled {
+		t.Errorf("RecvCancel: %v, want %v", err, ErrCancelled)
+	}
+}
+
+func Test_RecvIntoAbort(t *testing.T) {
+	e := NewCollectiveEndpoint()
+	a := plan.PeerID{IPv4: plan.MustParseIPv4(`127.0.0.1`), Port: 10000}.WithName("x")
+	m := connection.Message{Length: 4, Data: make([]byte, 4)}
+	errAbort := errors.New("abort")
+	done := make(chan error, 1)
+	go func() { done <- e.RecvInto(a, m) }()
+	time.Sleep(10 * time.Millisecond)
+	e.Abort(errAbort)
+	if err := <-done; err != errAbort {
+		t.Fatalf("RecvInto: %v, want %v", err, errAbort)
+	}
+	select {
+	case <-e.waitQ.require(a):
+		t.Errorf("the buffer of an aborted receive is left to be written")
+	default:
+	}
+	if err := e.Aborted(); err != errAbort {
+		t.Errorf("Aborted: %v, want %v", err, errAbort)
+	}
+}
+
+func Test_AbortVersion(t *testing.T) {
+	e := NewCollectiveEndpoint()
+	a := plan.PeerID{IPv4: plan.MustParseIPv4(`127.0.0.1`), Port: 10000}.WithName("x")
+	errAbort := errors.New("abort")
+	e.Abort(errAbort)
+	e.Renew(1)
+	if err := e.Aborted(); err != nil {
+		t.Errorf("the abort of the previous session fails the new one: %v", err)
+	}
+	e.AbortVersion(0, errAbort)
+	if err := e.Aborted(); err != nil {
+		t.Errorf("a late abort of the previous session fails the new one: %v", err)
+	}
+	e.AbortVersion(2, errAbort)
+	if err := e.Aborted(); err != nil {
+		t.Errorf("the abort of the next session fails the current one: %v", err)
+	}
+	e.Renew(2)
+	if _, err := e.Recv(a); err != errAbort {
+		t.Errorf("Recv: %v, want %v", err, errAbort)
+	}
+}
+
+// pipeConn is a Connection from src over a pipe.
+type pipeConn struct {
+	connection.Connection
+	src  plan.PeerID
+	conn net.Conn
+}
+
+func (c pipeConn) Conn() net.Conn   { return c.conn }
+func (c pipeConn) Src() plan.PeerID { return c.src }
+
+func Test_RecvIntoUnread(t *testing.T) {
+	e := NewCollectiveEndpoint()
+	src := plan.PeerID{IPv4: plan.MustParseIPv4(`127.0.0.1`), Port: 10000}
+	a := src.WithName("x")
+	m := connection.Message{Length: 4, Data: make([]byte, 4)}
+	done := make(chan error, 1)
+	go func() { done <- e.RecvInto(a, m) }()
+	r, w := net.Pipe()
+	go func() {
+		mh := connection.MessageHeader{NameLength: 1, Name: []byte("x"), Flags: connection.WaitRecvBuf}
+		mh.WriteTo(w)
+		connection.Message{Length: 4, Data: []byte{1, 2}}.WriteTo(w) // the stream fails in the middle of the message
+		w.Close()
+	}()
+	if _, _, err := e.accept(pipeConn{src: src, conn: r}); err == nil {
+		t.Fatal("accepted a partial message")
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("received a partial message")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the receive waits for a message that is never sent again")
+	}
+	select {
+	case <-e.waitQ.require(a):
+		t.Errorf("the buffer of a failed receive is left to be written")
+	default:
+	}
+}
@@ -2,12 +2,25 @@ package handler
 
 import (
 	"os"
+	"sync"
 
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 )
 
 type ControlHandler struct {
+	sync.Mutex
+	handlers map[string]func(data []byte)
+}
+
+// Register handles the control messages of name by f.
+func (h *ControlHandler) Register(name string, f func(data []byte)) {
+	h.Lock()
+	defer h.Unlock()
+	if h.handlers == nil {
+		h.handlers = make(map[string]func(data []byte))
+	}
+	h.handlers[name] = f
 }
 
 func (h *ControlHandler) Handle(conn connection.Connection) (int, error) {
@@ -19,5 +32,12 @@ func (h *ControlHandler) handleControl(name string, msg *connection.Message, _co
 		log.Errorf("exit control message received.")
 		os.Exit(0)
 	}
+	h.Lock()
+	f, ok := h.handlers[name]
+	h.Unlock()
+	if ok {
+		f(msg.Data)
+		return
+	}
 	log.Errorf("unexpected control message: %q", name)
 }
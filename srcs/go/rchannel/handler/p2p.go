@@ -2,10 +2,13 @@ package handler
 
 import (
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
+	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/client"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 	"github.com/lsds/KungFu/srcs/go/store"
+	"github.com/lsds/KungFu/srcs/go/utils"
 )
 
 const defaultVersionCount = 3
@@ -16,15 +19,24 @@ type PeerToPeerEndpoint struct {
 	waitQ          *BufferPool
 	recvQ          *BufferPool
 	client         *client.Client
+	sealer         *sealer // nil if responses are sent in plain
 }
 
 func NewPeerToPeerEndpoint(client *client.Client) *PeerToPeerEndpoint {
+	var s *sealer
+	if len(config.P2PKeyFile) > 0 {
+		var err error
+		if s, err = newSealer(config.P2PKeyFile); err != nil {
+			utils.ExitErr(err)
+		}
+	}
 	return &PeerToPeerEndpoint{
 		versionedStore: store.NewVersionedStore(defaultVersionCount),
 		store:          store.NewStore(),
 		waitQ:          newBufferPool(1),
 		recvQ:          newBufferPool(1),
 		client:         client,
+		sealer:         s,
 	}
 }
 
@@ -33,7 +45,25 @@ func (e *PeerToPeerEndpoint) Handle(conn connection.Connection) (int, error) {
 	return connection.Stream(conn, e.accept, e.handle)
 }
 
+// Request requests the model of the given name and version from a peer into m, it returns false if the peer doesn't have it.
+// If the endpoints seal responses, it fails unless the response verifies.
 func (e *PeerToPeerEndpoint) Request(a plan.Addr, version string, m connection.Message) (bool, error) {
+	if e.sealer == nil {
+		return e.request(a, version, m)
+	}
+	n := len(m.Data) + e.sealer.overhead()
+	sealed := connection.Message{Length: uint32(n), Data: make([]byte, n)}
+	ok, err := e.request(a, version, sealed)
+	if !ok || err != nil {
+		return ok, err
+	}
+	if err := e.sealer.open(m.Data, sealed.Data, sealedData(a.Name, version)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *PeerToPeerEndpoint) request(a plan.Addr, version string, m connection.Message) (bool, error) {
 	e.waitQ.require(a) <- &m
 	if err := e.client.Send(a, []byte(version), connection.ConnPeerToPeer, connection.NoFlag); err != nil {
 		<-e.waitQ.require(a)
@@ -113,8 +143,19 @@ func (e *PeerToPeerEndpoint) response(name string, version []byte, remote plan.P
 		blob.RLock()
 		defer blob.RUnlock()
 		buf = blob.Data
+		if e.sealer != nil {
+			if buf, err = e.sealer.seal(buf, sealedData(name, string(version))); err != nil {
+				log.Errorf("failed to seal %s: %v", name, err)
+				buf, flags = nil, flags|connection.RequestFailed
+			}
+		}
 	} else {
 		flags |= connection.RequestFailed
 	}
 	return e.client.Send(remote.WithName(name), buf, connection.ConnPeerToPeer, flags)
 }
+
+// sealedData returns the additional data of sealed responses, so that a response can't be replayed for another model or version.
+func sealedData(name, version string) []byte {
+	return []byte(name + "\x00" + version)
+}
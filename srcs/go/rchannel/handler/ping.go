@@ -1,19 +1,32 @@
 package handler
 
 import (
+	"strings"
+
+	"github.com/lsds/KungFu/srcs/go/log"
+	"github.com/lsds/KungFu/srcs/go/rchannel/client"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
 )
 
+// PingHandler echoes pings and acknowledges probes, it also serves probe requests of other links if Client is set.
 type PingHandler struct {
+	Client *client.Client
 }
 
 func (h *PingHandler) Handle(conn connection.Connection) (int, error) {
-	name, msg, err := connection.Accept(conn)
-	if err != nil {
-		return 0, err
+	return connection.Stream(conn, connection.Accept, h.handlePing)
+}
+
+func (h *PingHandler) handlePing(name string, msg *connection.Message, conn connection.Connection) {
+	reply := *msg
+	switch {
+	case name == client.ProbeName:
+		connection.PutBuf(msg.Data)
+		reply = connection.Message{}
+	case strings.HasPrefix(name, client.ProbeLinkPrefix) && h.Client != nil:
+		reply = h.Client.ServeProbeLink(name)
 	}
-	if err := conn.Send(name, *msg, connection.NoFlag); err != nil {
-		return 1, err
+	if err := conn.Send(name, reply, connection.NoFlag); err != nil {
+		log.Warnf("failed to reply %s to %s: %v", name, conn.Src(), err)
 	}
-	return 1, nil
 }
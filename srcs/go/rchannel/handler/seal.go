@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+
+	"github.com/lsds/KungFu/srcs/go/kungfu/failure"
+)
+
+var errTamperedMessage = failure.New(failure.ProtocolMismatch, "message failed verification, tampered or sealed with another key")
+
+// sealer encrypts and authenticates the models transferred between peers by AES-256-GCM, with a key shared by the peers,
+// independent of the transport, so that weights pulled by joining peers can't be read or tampered with in transit.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer returns a sealer with the key derived from the secret in keyFile.
+func newSealer(keyFile string) (*sealer, error) {
+	secret, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{aead: aead}, nil
+}
+
+// overhead is the bytes of the nonce and the tag added to a sealed message.
+func (s *sealer) overhead() int {
+	return s.aead.NonceSize() + s.aead.Overhead()
+}
+
+// seal returns the nonce and the encrypted and authenticated data, bound to ad, e.g. the name and version of a model.
+func (s *sealer) seal(data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, data, ad), nil
+}
+
+// open decrypts sealed into data, which has the size of the original data, if it verifies with ad.
+func (s *sealer) open(data, sealed, ad []byte) error {
+	n := s.aead.NonceSize()
+	if len(sealed) != n+len(data)+s.aead.Overhead() {
+		return errTamperedMessage
+	}
+	if _, err := s.aead.Open(data[:0], sealed[:n], sealed[n:], ad); err != nil {
+		return errTamperedMessage
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_sealer(t *testing.T) {
+	f, err := ioutil.TempFile("", "kungfu-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret")
+	f.Close()
+	s, err := newSealer(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("model weights")
+	sealed, err := s.seal(data, sealedData("model", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(data)+s.overhead() || bytes.Contains(sealed, data) {
+		t.Fatalf("unexpected sealed data %q", sealed)
+	}
+	out := make([]byte, len(data))
+	if err := s.open(out, sealed, sealedData("model", "1")); err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("open: %q, %v", out, err)
+	}
+	if err := s.open(out, sealed, sealedData("model", "2")); err != errTamperedMessage {
+		t.Errorf("replayed for another version: %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if err := s.open(out, sealed, sealedData("model", "1")); err != errTamperedMessage {
+		t.Errorf("tampered: %v", err)
+	}
+}
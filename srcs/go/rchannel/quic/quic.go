@@ -0,0 +1,211 @@
+//go:build quic
+// +build quic
+
+// Package quic carries the connections of peers over QUIC, where each connection is a stream of a QUIC connection
+// between the two hosts, so that connections don't block each other when packets are lost, as TCP connections do.
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// Available is true if KungFu is built with QUIC.
+const Available = true
+
+const (
+	alpn           = `kungfu`
+	maxStreams     = 1 << 20 // streams of a connection, there is a stream per message name of collectives
+	keepAlive      = 10 * time.Second
+	maxIdleTimeout = 60 * time.Second
+)
+
+func quicConfig() *quicgo.Config {
+	return &quicgo.Config{
+		MaxIncomingStreams: maxStreams,
+		KeepAlivePeriod:    keepAlive,
+		MaxIdleTimeout:     maxIdleTimeout,
+	}
+}
+
+// streamConn is a stream of a QUIC connection as a net.Conn.
+type streamConn struct {
+	quicgo.Stream
+	conn quicgo.Connection
+}
+
+func (s *streamConn) LocalAddr() net.Addr  { return s.conn.LocalAddr() }
+func (s *streamConn) RemoteAddr() net.Addr { return s.conn.RemoteAddr() }
+
+// ConnectionState returns the state of the TLS handshake of the QUIC connection, for the peer to be verified.
+func (s *streamConn) ConnectionState() tls.ConnectionState { return s.conn.ConnectionState().TLS }
+
+// dialTimeout bounds the handshake of a QUIC connection, and the wait for a stream of it.
+var dialTimeout = 10 * time.Second
+
+// dialer keeps a QUIC connection to each remote address, and opens streams on it.
+var dialer = struct {
+	sync.Mutex
+	remotes map[string]*remote
+}{remotes: make(map[string]*remote)}
+
+// A remote is the QUIC connection to an address, which has its own lock, so that the handshake with an unreachable
+// address doesn't block the connections to the other addresses.
+type remote struct {
+	sync.Mutex
+	conn quicgo.Connection
+}
+
+// Dial opens a new stream to a Listener at addr, on the QUIC connection to addr, which is established on the first Dial
+// with tlsConf. The certificate of the listener is not verified if tlsConf is nil.
+func Dial(addr string, tlsConf *tls.Config) (net.Conn, error) {
+	conn, err := connect(addr, tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	s, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Stream: s, conn: conn}, nil
+}
+
+func connect(addr string, tlsConf *tls.Config) (quicgo.Connection, error) {
+	dialer.Lock()
+	r, ok := dialer.remotes[addr]
+	if !ok {
+		r = &remote{}
+		dialer.remotes[addr] = r
+	}
+	dialer.Unlock()
+	r.Lock()
+	defer r.Unlock()
+	if r.conn != nil && r.conn.Context().Err() == nil {
+		return r.conn, nil
+	}
+	if tlsConf == nil {
+		tlsConf = &tls.Config{
+			InsecureSkipVerify: true, // peers are authenticated by the token of the connection header, as with TCP
+		}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{alpn}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := quicgo.DialAddr(ctx, addr, tlsConf, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	r.conn = conn
+	return conn, nil
+}
+
+// Listener accepts the streams of QUIC connections as net.Conns.
+type Listener struct {
+	ln      *quicgo.Listener
+	accepts chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Listen listens on the UDP address addr, with the TLS config tlsConf, or a self-signed certificate if tlsConf is nil.
+func Listen(addr string, tlsConf *tls.Config) (*Listener, error) {
+	if tlsConf == nil {
+		cert, err := selfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		tlsConf = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{alpn}
+	ln, err := quicgo.ListenAddr(addr, tlsConf, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		ln:      ln,
+		accepts: make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *Listener) serve() {
+	for {
+		conn, err := l.ln.Accept(context.TODO())
+		if err != nil {
+			l.Close()
+			return
+		}
+		go l.acceptStreams(conn)
+	}
+}
+
+func (l *Listener) acceptStreams(conn quicgo.Connection) {
+	for {
+		s, err := conn.AcceptStream(context.TODO())
+		if err != nil {
+			return
+		}
+		select {
+		case l.accepts <- &streamConn{Stream: s, conn: conn}:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepts:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "quic", Addr: l.ln.Addr(), Err: net.ErrClosed}
+	}
+}
+
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// selfSignedCert generates the certificate of the TLS handshake that QUIC requires, which is not verified by peers.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: alpn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
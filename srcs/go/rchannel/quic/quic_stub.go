@@ -0,0 +1,26 @@
+//go:build !quic
+// +build !quic
+
+// Package quic carries the connections of peers over QUIC, it is only available if KungFu is built with -tags quic.
+package quic
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// Available is true if KungFu is built with QUIC.
+const Available = false
+
+var errNotAvailable = errors.New("QUIC transport is not available, rebuild with -tags quic")
+
+// Dial fails, as KungFu is built without QUIC.
+func Dial(addr string, tlsConf *tls.Config) (net.Conn, error) {
+	return nil, errNotAvailable
+}
+
+// Listen fails, as KungFu is built without QUIC.
+func Listen(addr string, tlsConf *tls.Config) (net.Listener, error) {
+	return nil, errNotAvailable
+}
@@ -0,0 +1,86 @@
+//go:build quic
+// +build quic
+
+package quic
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) *Listener {
+	l, err := Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c) // echo
+		}
+	}()
+	return l
+}
+
+func Test_Dial(t *testing.T) {
+	l := listen(t)
+	var conns []interface{}
+	for i := 0; i < 2; i++ {
+		c, err := Dial(l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("echoed %q, %v", buf, err)
+		}
+		conns = append(conns, c.(*streamConn).conn)
+		c.Close()
+	}
+	if conns[0] != conns[1] {
+		t.Errorf("streams of different QUIC connections to the same address")
+	}
+}
+
+func Test_DialUnreachable(t *testing.T) {
+	defer func(d time.Duration) { dialTimeout = d }(dialTimeout)
+	dialTimeout = time.Second
+	hole, err := net.ListenPacket("udp", "127.0.0.1:0") // never answers the handshake
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hole.Close()
+	failed := make(chan error, 1)
+	go func() {
+		_, err := Dial(hole.LocalAddr().String(), nil)
+		failed <- err
+	}()
+	time.Sleep(100 * time.Millisecond) // dialing the hole
+	l := listen(t)
+	t0 := time.Now()
+	c, err := Dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if d := time.Since(t0); d >= dialTimeout/2 {
+		t.Errorf("dialing a reachable address took %s while dialing an unreachable one", d)
+	}
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Errorf("dialing an unreachable address succeeded")
+		}
+	case <-time.After(5 * dialTimeout):
+		t.Errorf("dialing an unreachable address not timed out")
+	}
+}
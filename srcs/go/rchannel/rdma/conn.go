@@ -0,0 +1,368 @@
+package rdma
+
+import (
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	slots      = 16      // chunks in flight of a connection
+	slotSize   = 1 << 20 // bytes of a chunk
+	creditSpin = 1000    // yields before sleeping, while waiting for the remote to consume a slot or to advertise
+	creditPoll = 10 * time.Microsecond
+
+	eofImm    = 0xffffffff // the immediate data of the end of the stream
+	directImm = 0xfffffffe // the immediate data of the completion of a write into advertised memory
+)
+
+var (
+	errNotAvailable = errors.New("RDMA transport is not available, rebuild with -tags rdma")
+
+	errClosed   = errors.New("rdma: use of closed connection")
+	errReset    = errors.New("rdma: connection reset by peer")
+	errDeadline = errors.New("rdma: deadlines are not supported")
+
+	errDeviceMemory   = errors.New("rdma: device memory can't be registered")
+	errDeviceMismatch = errors.New("rdma: device memory is read from or written to host memory of the remote")
+	errDeviceSize     = errors.New("rdma: device memory is written to advertised memory of another size")
+)
+
+// advert is the memory that a Conn reads into, which the remote writes into directly, for device memory.
+type advert struct {
+	addr uint64
+	size uint64
+	rkey uint32
+	seq  uint64 // of the adverts of the connection, from 1
+}
+
+// queuePair is the verbs of a connection that a Conn is built on, see verbs.h, or a fake of them in the tests.
+type queuePair interface {
+	domain() domain
+	ring() []byte            // the slots that the remote writes chunks into
+	stage() ([]byte, uint32) // the source of chunks that are not in registered memory, with its local key
+
+	// write writes b, in the memory of lkey, into the slot of the remote ring with imm, and waits for its completion.
+	write(slot int, b []byte, lkey, imm uint32) error
+	// writeDirect writes b, in the memory of lkey, into the memory of a with directImm, and waits for its completion.
+	writeDirect(a advert, b []byte, lkey uint32) error
+	returnCredit(consumed uint64) error // writes the number of consumed slots into the credit of the remote
+	credit() uint64                     // the number of slots consumed by the remote
+	advertise(a advert) error           // writes a into the advert of the remote
+	advert() advert                     // the latest advert of the remote
+
+	waitRecv() (uint32, error) // waits for the next write with immediate data of the remote
+	postRecv() error           // posts a receive for the next write with immediate data
+	disconnect()               // fails the pending waitRecv
+	free()
+}
+
+// Conn is a connection over a queue pair.
+type Conn struct {
+	qp     queuePair
+	ring   []byte
+	stage  []byte
+	skey   uint32 // of stage
+	local  net.Addr
+	remote net.Addr
+
+	wmu     sync.Mutex // of Write
+	sent    uint64     // chunks written
+	adverts uint64     // adverts of the remote written into
+
+	rmu       sync.Mutex // of Read
+	recvd     uint64     // chunks consumed
+	advertSeq uint64     // adverts written to the remote
+	off       int        // of the unread bytes of the current chunk in ring
+	avail     int        // unread bytes of the current chunk
+	remoteEOF bool
+
+	smu sync.Mutex // of the send queue, which is shared by chunks, credits and adverts
+
+	mu           sync.Mutex // of the states below
+	closed       bool
+	disconnected bool
+	watching     sync.WaitGroup // the goroutine watching the CM events of a dialed connection
+	onClose      func()
+}
+
+func newConn(qp queuePair, local, remote net.Addr, onClose func()) *Conn {
+	stage, skey := qp.stage()
+	return &Conn{
+		qp:      qp,
+		ring:    qp.ring(),
+		stage:   stage,
+		skey:    skey,
+		local:   local,
+		remote:  remote,
+		onClose: onClose,
+	}
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// disconnect moves the queue pair to the error state, which flushes the pending receives.
+func (c *Conn) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.qp != nil && !c.disconnected {
+		c.qp.disconnect()
+		c.disconnected = true
+	}
+}
+
+func (c *Conn) isDisconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed || c.disconnected
+}
+
+// Read reads the chunks written into the ring. Device memory registered by RegisterDevice is advertised to the remote,
+// which writes into it directly, so it must be read by a single Read from a Write of device memory of the same size.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.isClosed() {
+		return 0, errClosed
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if c.remoteEOF {
+		return 0, io.EOF
+	}
+	if _, ok := regions.device(b); ok {
+		if c.avail > 0 {
+			return 0, errDeviceMismatch
+		}
+		return c.readDirect(b)
+	}
+	if c.avail == 0 {
+		imm, err := c.waitRecv()
+		if err != nil {
+			return 0, err
+		}
+		switch imm {
+		case eofImm:
+			c.remoteEOF = true
+			return 0, io.EOF
+		case directImm:
+			return 0, errDeviceMismatch
+		}
+		c.off = int(c.recvd%slots) * slotSize
+		c.avail = int(imm)
+	}
+	n := copy(b, c.ring[c.off:c.off+c.avail])
+	c.off += n
+	c.avail -= n
+	if c.avail == 0 {
+		c.recvd++
+		if err := c.release(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *Conn) waitRecv() (uint32, error) {
+	imm, err := c.qp.waitRecv()
+	if err != nil && c.isDisconnected() {
+		return 0, errReset
+	}
+	return imm, err
+}
+
+// readDirect advertises b to the remote, and waits for the remote to write into it.
+// The registry isn't locked while waiting, the memory of b must not be deregistered before Read returns.
+func (c *Conn) readDirect(b []byte) (int, error) {
+	regions.RLock()
+	_, rkey, err := regions.keys(c.qp.domain(), b)
+	regions.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	c.advertSeq++
+	a := advert{addr: uint64(uintptr(unsafe.Pointer(&b[0]))), size: uint64(len(b)), rkey: rkey, seq: c.advertSeq}
+	if err := c.locked(func() error { return c.qp.advertise(a) }); err != nil {
+		return 0, err
+	}
+	imm, err := c.waitRecv()
+	if err != nil {
+		return 0, err
+	}
+	switch imm {
+	case directImm:
+		return len(b), c.qp.postRecv()
+	case eofImm:
+		c.remoteEOF = true
+		return 0, io.EOF
+	}
+	return 0, errDeviceMismatch // a chunk of host memory, which can't be copied into device memory
+}
+
+// release makes the consumed slot available to the remote.
+func (c *Conn) release() error {
+	if err := c.qp.postRecv(); err != nil {
+		return err
+	}
+	return c.locked(func() error { return c.qp.returnCredit(c.recvd) })
+}
+
+// locked runs f with the send queue locked.
+func (c *Conn) locked(f func() error) error {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return f()
+}
+
+// Write writes p in chunks into the slots of the remote ring. Device memory registered by RegisterDevice is written
+// directly into the device memory advertised by the remote, which must read it by a single Read of the same size.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.isClosed() {
+		return 0, errClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, ok := regions.device(p); ok {
+		return c.writeDirect(p)
+	}
+	var n int
+	for n < len(p) {
+		k := len(p) - n
+		if k > slotSize {
+			k = slotSize
+		}
+		if err := c.waitCredit(); err != nil {
+			return n, err
+		}
+		if err := c.writeChunk(p[n : n+k]); err != nil {
+			return n, err
+		}
+		n += k
+	}
+	return n, nil
+}
+
+// waitCredit waits until the remote has consumed the slot of the next chunk.
+func (c *Conn) waitCredit() error {
+	return c.spin(func() bool { return c.sent-c.qp.credit() < slots })
+}
+
+// spin waits until ok, or the connection is disconnected.
+func (c *Conn) spin(ok func() bool) error {
+	for i := 0; !ok(); i++ {
+		if c.isDisconnected() {
+			return errReset
+		}
+		if i < creditSpin {
+			runtime.Gosched()
+		} else {
+			time.Sleep(creditPoll)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) writeChunk(b []byte) error {
+	regions.RLock() // the region must not be deregistered before the write completes
+	defer regions.RUnlock()
+	lkey, ok, err := regions.lkey(c.qp.domain(), b)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		copy(c.stage, b)
+		b, lkey = c.stage[:len(b)], c.skey
+	}
+	slot := int(c.sent % slots)
+	if err := c.locked(func() error { return c.qp.write(slot, b, lkey, uint32(len(b))) }); err != nil {
+		return err
+	}
+	c.sent++
+	return nil
+}
+
+// writeDirect waits for the next advert of the remote, and writes p into it.
+func (c *Conn) writeDirect(p []byte) (int, error) {
+	regions.RLock()
+	_, err := c.directKey(p) // before waiting, as the remote may not read into device memory
+	regions.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	var a advert
+	if err := c.spin(func() bool { a = c.qp.advert(); return a.seq > c.adverts }); err != nil {
+		return 0, err
+	}
+	c.adverts = a.seq
+	if a.size != uint64(len(p)) {
+		return 0, errDeviceSize
+	}
+	regions.RLock() // the region must not be deregistered before the write completes
+	defer regions.RUnlock()
+	lkey, err := c.directKey(p)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.locked(func() error { return c.qp.writeDirect(a, p, lkey) }); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// directKey returns the local key of device memory p, the registry must be read locked.
+func (c *Conn) directKey(p []byte) (uint32, error) {
+	lkey, ok, err := regions.lkey(c.qp.domain(), p)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errDeviceMemory // deregistered
+	}
+	return lkey, nil
+}
+
+// Close tells the remote the end of the stream, and releases the queue pair and its buffers.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	eof := !c.disconnected // by the remote
+	c.mu.Unlock()
+	if eof {
+		c.locked(func() error { return c.qp.write(0, nil, 0, eofImm) })
+	}
+	c.disconnect()
+	c.watching.Wait()
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.onClose()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.qp.free()
+	c.qp = nil
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error      { return errDeadline }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return errDeadline }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return errDeadline }
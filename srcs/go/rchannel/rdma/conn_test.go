@@ -0,0 +1,324 @@
+package rdma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+// fakeDomain registers memory by keys, both ends of a fake connection share it as if they were on the same device.
+type fakeDomain struct {
+	sync.Mutex
+	mem    map[uint32][]byte
+	fail   bool // registers nothing
+	nextID uint32
+}
+
+func newFakeDomain() *fakeDomain {
+	return &fakeDomain{mem: make(map[uint32][]byte), nextID: 1}
+}
+
+func (d *fakeDomain) register(r *region) *memoryRegion {
+	d.Lock()
+	defer d.Unlock()
+	if d.fail {
+		return nil
+	}
+	key := d.nextID
+	d.nextID++
+	d.mem[key] = r.buf
+	return &memoryRegion{lkey: key, rkey: key, release: func() {
+		d.Lock()
+		defer d.Unlock()
+		delete(d.mem, key)
+	}}
+}
+
+// at returns the registered memory of rkey at addr.
+func (d *fakeDomain) at(rkey uint32, addr, size uint64) ([]byte, bool) {
+	d.Lock()
+	defer d.Unlock()
+	b, ok := d.mem[rkey]
+	if !ok {
+		return nil, false
+	}
+	off := addr - uint64(uintptr(unsafe.Pointer(&b[0])))
+	if off+size > uint64(len(b)) {
+		return nil, false
+	}
+	return b[off : off+size], true
+}
+
+const stageKey = 0xbeef
+
+// fakeQP is a queuePair in memory, which copies the writes into its remote.
+type fakeQP struct {
+	d       *fakeDomain
+	remote  *fakeQP
+	slots   []byte
+	staging []byte
+
+	recvs     chan uint32 // the immediate data of the writes into this end
+	posted    int32       // receives posted
+	creditV   uint64
+	advMu     sync.Mutex
+	adv       advert
+	lkeys     []uint32      // of the chunks written
+	done      chan struct{} // shared by both ends, disconnecting one end disconnects the other
+	closeOnce *sync.Once
+}
+
+func newFakePair(d *fakeDomain) (*fakeQP, *fakeQP) {
+	done, once := make(chan struct{}), &sync.Once{}
+	newQP := func() *fakeQP {
+		return &fakeQP{
+			d:         d,
+			slots:     make([]byte, slots*slotSize),
+			staging:   make([]byte, slotSize),
+			recvs:     make(chan uint32, slots+2),
+			posted:    slots + 2,
+			done:      done,
+			closeOnce: once,
+		}
+	}
+	a, b := newQP(), newQP()
+	a.remote, b.remote = b, a
+	return a, b
+}
+
+var errNotPosted = errors.New("no receive posted")
+
+func (q *fakeQP) deliver(imm uint32) error {
+	if atomic.AddInt32(&q.remote.posted, -1) < 0 {
+		return errNotPosted
+	}
+	q.remote.recvs <- imm
+	return nil
+}
+
+func (q *fakeQP) domain() domain              { return q.d }
+func (q *fakeQP) ring() []byte                { return q.slots }
+func (q *fakeQP) stage() ([]byte, uint32)     { return q.staging, stageKey }
+func (q *fakeQP) credit() uint64              { return atomic.LoadUint64(&q.creditV) }
+func (q *fakeQP) postRecv() error             { atomic.AddInt32(&q.posted, 1); return nil }
+func (q *fakeQP) disconnect()                 { q.closeOnce.Do(func() { close(q.done) }) }
+func (q *fakeQP) free()                       {}
+func (q *fakeQP) returnCredit(n uint64) error { atomic.StoreUint64(&q.remote.creditV, n); return nil }
+
+func (q *fakeQP) write(slot int, b []byte, lkey, imm uint32) error {
+	if imm != eofImm {
+		q.lkeys = append(q.lkeys, lkey)
+	}
+	copy(q.remote.slots[slot*slotSize:], b)
+	return q.deliver(imm)
+}
+
+func (q *fakeQP) writeDirect(a advert, b []byte, lkey uint32) error {
+	dst, ok := q.d.at(a.rkey, a.addr, a.size)
+	if !ok || len(b) != len(dst) {
+		return errDeviceMemory
+	}
+	q.lkeys = append(q.lkeys, lkey)
+	copy(dst, b)
+	return q.deliver(directImm)
+}
+
+func (q *fakeQP) advertise(a advert) error {
+	q.remote.advMu.Lock()
+	defer q.remote.advMu.Unlock()
+	q.remote.adv = a
+	return nil
+}
+
+func (q *fakeQP) advert() advert {
+	q.advMu.Lock()
+	defer q.advMu.Unlock()
+	return q.adv
+}
+
+func (q *fakeQP) waitRecv() (uint32, error) {
+	select {
+	case imm := <-q.recvs: // the completions before the disconnection are polled first
+		return imm, nil
+	default:
+	}
+	select {
+	case imm := <-q.recvs:
+		return imm, nil
+	case <-q.done:
+		return 0, errReset
+	}
+}
+
+func newFakeConns(d *fakeDomain) (*Conn, *Conn, *fakeQP) {
+	a, b := newFakePair(d)
+	return newConn(a, nil, nil, func() {}), newConn(b, nil, nil, func() {}), a
+}
+
+func randBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func Test_ConnChunks(t *testing.T) {
+	w, r, _ := newFakeConns(newFakeDomain())
+	msgs := [][]byte{randBytes(3), randBytes(slotSize), randBytes(2*slots*slotSize + 7), randBytes(1)}
+	go func() {
+		for _, m := range msgs {
+			if _, err := w.Write(m); err != nil {
+				t.Error(err)
+			}
+		}
+		w.Close()
+	}()
+	for i, m := range msgs {
+		got := make([]byte, len(m))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !bytes.Equal(got, m) {
+			t.Errorf("#%d: received different %d bytes", i, len(m))
+		}
+	}
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read after Close of the remote: %v, want EOF", err)
+	}
+	r.Close()
+}
+
+func Test_ConnRegistered(t *testing.T) {
+	d := newFakeDomain()
+	w, r, qp := newFakeConns(d)
+	defer r.Close()
+	defer w.Close()
+	m := randBytes(slotSize + 1)
+	deregister := register(&region{buf: m})
+	defer deregister()
+	go w.Write(m)
+	got := make([]byte, len(m))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, m) {
+		t.Errorf("received different bytes")
+	}
+	for _, lkey := range qp.lkeys {
+		if lkey == stageKey {
+			t.Errorf("a chunk of a registered buffer is staged")
+		}
+	}
+
+	d.fail = true // host memory that can't be registered is staged
+	n := randBytes(10)
+	defer register(&region{buf: n})()
+	w2, r2, qp2 := newFakeConns(d)
+	defer r2.Close()
+	defer w2.Close()
+	go w2.Write(n)
+	got = make([]byte, len(n))
+	if _, err := io.ReadFull(r2, got); err != nil || !bytes.Equal(got, n) {
+		t.Errorf("received %v, %v", got, err)
+	}
+	if len(qp2.lkeys) != 1 || qp2.lkeys[0] != stageKey {
+		t.Errorf("chunks of %v, want a staged chunk", qp2.lkeys)
+	}
+}
+
+func Test_ConnDirect(t *testing.T) {
+	w, r, qp := newFakeConns(newFakeDomain())
+	defer r.Close()
+	defer w.Close()
+	src := randBytes(3*slotSize + 5) // "device memory", which the fake accesses by its registration only
+	dst := make([]byte, len(src))
+	defer register(&region{buf: src, device: true})()
+	defer register(&region{buf: dst, device: true})()
+	header := randBytes(4)
+	go func() {
+		w.Write(header)
+		if _, err := w.Write(src); err != nil {
+			t.Error(err)
+		}
+	}()
+	got := make([]byte, len(header))
+	if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, header) {
+		t.Fatalf("received header %v, %v", got, err)
+	}
+	if n, err := r.Read(dst); n != len(dst) || err != nil {
+		t.Fatalf("Read of device memory: %d, %v", n, err)
+	}
+	if !bytes.Equal(dst, src) {
+		t.Errorf("received different bytes in device memory")
+	}
+	if len(qp.lkeys) != 2 || qp.lkeys[1] == stageKey {
+		t.Errorf("chunks of %v, want a header and a direct write", qp.lkeys)
+	}
+}
+
+func Test_ConnDirectMismatch(t *testing.T) {
+	d := newFakeDomain()
+	dst := make([]byte, 8)
+	defer register(&region{buf: dst, device: true})()
+
+	w, r, _ := newFakeConns(d)
+	go w.Write(randBytes(8)) // host memory
+	if _, err := r.Read(dst); err != errDeviceMismatch {
+		t.Errorf("Read of host memory into device memory: %v, want %v", err, errDeviceMismatch)
+	}
+	w.Close()
+	r.Close()
+
+	src := make([]byte, 4)
+	defer register(&region{buf: src, device: true})()
+	w, r, _ = newFakeConns(d)
+	defer w.Close()
+	defer r.Close()
+	go r.Read(dst)
+	if _, err := w.Write(src); err != errDeviceSize {
+		t.Errorf("Write of device memory into another size: %v, want %v", err, errDeviceSize)
+	}
+
+	d.fail = true
+	other := make([]byte, 4)
+	defer register(&region{buf: other, device: true})()
+	if _, err := w.Write(other); err != errDeviceMemory {
+		t.Errorf("Write of unregistered device memory: %v, want %v", err, errDeviceMemory)
+	}
+}
+
+func Test_registry(t *testing.T) {
+	d := newFakeDomain()
+	a, b := make([]byte, 100), make([]byte, 100)
+	da := register(&region{buf: a})
+	db := register(&region{buf: b, device: true})
+	if noop := register(&region{buf: a[10:20]}); len(regions.regions) != 2 {
+		t.Errorf("registering a part of a registered buffer added a region")
+	} else {
+		noop()
+	}
+	regions.RLock()
+	if r := regions.find(a[10:20]); r == nil || &r.buf[0] != &a[0] {
+		t.Errorf("the region of a part of a buffer is not found")
+	}
+	if _, ok, err := regions.lkey(d, make([]byte, 1)); ok || err != nil {
+		t.Errorf("unregistered memory has a key")
+	}
+	if _, ok, err := regions.lkey(d, a[50:]); !ok || err != nil {
+		t.Errorf("registered memory has no key: %v", err)
+	}
+	regions.RUnlock()
+	if IsDevice(a) || !IsDevice(b[1:2]) || IsDevice(nil) {
+		t.Errorf("unexpected device memory")
+	}
+	da()
+	db()
+	da() // deregisters once
+	if len(regions.regions) != 0 || len(d.mem) != 0 {
+		t.Errorf("%d regions and %d registrations after deregistering", len(regions.regions), len(d.mem))
+	}
+}
@@ -0,0 +1,20 @@
+package rdma
+
+import "os"
+
+// peerMemModules are the kernel modules of GPU drivers that let RDMA devices register device memory by its address.
+var peerMemModules = []string{`/sys/module/nvidia_peermem`, `/sys/module/nv_peer_mem`}
+
+// GPUDirect returns true if device memory can be registered by RegisterDevice by its address,
+// memory exported as a dma-buf can be registered without a peer memory module.
+func GPUDirect() bool {
+	if !Available {
+		return false
+	}
+	for _, m := range peerMemModules {
+		if _, err := os.Stat(m); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,306 @@
+//go:build rdma
+// +build rdma
+
+// Package rdma carries the connections of peers over RDMA (InfiniBand or RoCE) reliable connected queue pairs,
+// set up by the RDMA CM on the TCP port of the listener. Data is written in chunks of a slot by RDMA write with
+// immediate into a ring of slots registered by the receiver, which returns the credits of consumed slots by RDMA
+// writes into a word registered by the sender. Chunks of buffers registered by Register are written without a copy.
+// Device memory registered by RegisterDevice is read by GPUDirect RDMA: the receiver advertises the memory it reads
+// into, and the sender writes into it from its own device memory, so that neither side stages it in host memory.
+package rdma
+
+/*
+#cgo LDFLAGS: -lrdmacm -libverbs
+#include "verbs.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Available is true if KungFu is built with RDMA.
+const Available = true
+
+const (
+	resolveTimeout = 2000 // milliseconds to resolve the address and the route of a remote
+	eventPoll      = 100  // milliseconds to poll CM events, before checking if the listener is closed
+)
+
+func errno(op string, code C.int) error {
+	return &net.OpError{Op: op, Net: "rdma", Err: syscall.Errno(-code)}
+}
+
+func tcpAddr(sa *C.struct_sockaddr) net.Addr {
+	var ip C.uint32_t
+	var port C.uint16_t
+	C.kf_addr(sa, &ip, &port)
+	return &net.TCPAddr{IP: net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)), Port: int(port)}
+}
+
+// protectionDomain registers memory on a device, it's comparable for the memory regions of a region by domain.
+type protectionDomain struct {
+	pd *C.struct_ibv_pd
+}
+
+func (d protectionDomain) register(r *region) *memoryRegion {
+	var mr *C.struct_ibv_mr
+	if r.device {
+		mr = C.kf_reg_device_mr(d.pd, unsafe.Pointer(&r.buf[0]), C.size_t(len(r.buf)), C.int(r.dmabuf), C.uint64_t(r.offset))
+	} else {
+		mr = C.kf_reg_mr(d.pd, unsafe.Pointer(&r.buf[0]), C.size_t(len(r.buf)))
+	}
+	if mr == nil {
+		return nil
+	}
+	return &memoryRegion{lkey: uint32(mr.lkey), rkey: uint32(mr.rkey), release: func() { C.ibv_dereg_mr(mr) }}
+}
+
+// verbs is the queuePair of a kf_conn.
+type verbs struct {
+	c *C.kf_conn
+}
+
+func (v verbs) domain() domain { return protectionDomain{pd: v.c.pd} }
+
+func (v verbs) ring() []byte {
+	return (*[slots * slotSize]byte)(unsafe.Pointer(v.c.ring))[:]
+}
+
+func (v verbs) stage() ([]byte, uint32) {
+	return (*[slotSize]byte)(unsafe.Pointer(v.c.stage))[:], uint32(v.c.stage_mr.lkey)
+}
+
+func (v verbs) write(slot int, b []byte, lkey, imm uint32) error {
+	var addr unsafe.Pointer
+	if len(b) > 0 {
+		addr = unsafe.Pointer(&b[0])
+	}
+	if code := C.kf_write(v.c, C.uint32_t(slot), addr, C.uint32_t(len(b)), C.uint32_t(lkey), C.uint32_t(imm)); code != 0 {
+		return errno("write", code)
+	}
+	return nil
+}
+
+func (v verbs) writeDirect(a advert, b []byte, lkey uint32) error {
+	if code := C.kf_write_direct(v.c, C.uint64_t(a.addr), C.uint32_t(a.rkey), unsafe.Pointer(&b[0]), C.uint32_t(len(b)), C.uint32_t(lkey)); code != 0 {
+		return errno("write", code)
+	}
+	return nil
+}
+
+func (v verbs) returnCredit(consumed uint64) error {
+	if code := C.kf_return_credit(v.c, C.uint64_t(consumed)); code != 0 {
+		return errno("read", code)
+	}
+	return nil
+}
+
+func (v verbs) credit() uint64 { return uint64(C.kf_credit(v.c)) }
+
+func (v verbs) advertise(a advert) error {
+	if code := C.kf_advertise(v.c, C.uint64_t(a.addr), C.uint64_t(a.size), C.uint32_t(a.rkey), C.uint64_t(a.seq)); code != 0 {
+		return errno("read", code)
+	}
+	return nil
+}
+
+func (v verbs) advert() advert {
+	var a C.kf_advert
+	C.kf_remote_advert(v.c, &a)
+	return advert{addr: uint64(a.addr), size: uint64(a.size), rkey: uint32(a.rkey), seq: uint64(a.seq)}
+}
+
+func (v verbs) waitRecv() (uint32, error) {
+	var imm C.uint32_t
+	if code := C.kf_wait_recv(v.c, &imm); code != 0 {
+		return 0, errno("read", code)
+	}
+	return uint32(imm), nil
+}
+
+func (v verbs) postRecv() error {
+	if code := C.kf_post_recv(v.c); code != 0 {
+		return errno("read", code)
+	}
+	return nil
+}
+
+func (v verbs) disconnect() { C.rdma_disconnect(v.c.id) }
+
+func (v verbs) free() { C.kf_free(v.c) }
+
+func newVerbsConn(c *C.kf_conn, onClose func()) *Conn {
+	local, remote := tcpAddr(C.rdma_get_local_addr(c.id)), tcpAddr(C.rdma_get_peer_addr(c.id))
+	return newConn(verbs{c: c}, local, remote, onClose)
+}
+
+// Dial connects to a Listener at addr.
+func Dial(addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	chost, cport := C.CString(host), C.CString(port)
+	defer C.free(unsafe.Pointer(chost))
+	defer C.free(unsafe.Pointer(cport))
+	var code C.int
+	c := C.kf_dial(chost, cport, slots, slotSize, resolveTimeout, &code)
+	if c == nil {
+		return nil, errno("dial", code)
+	}
+	conn := newVerbsConn(c, func() {})
+	conn.watching.Add(1)
+	go watch(conn, c.ec)
+	return conn, nil
+}
+
+// watch disconnects a dialed connection when the remote disconnects, to fail the pending Read.
+func watch(c *Conn, ec *C.struct_rdma_event_channel) {
+	defer c.watching.Done()
+	for !c.isClosed() {
+		if C.kf_poll_event(ec, eventPoll) <= 0 {
+			continue
+		}
+		var id *C.struct_rdma_cm_id
+		var ring C.kf_ring
+		if event := C.kf_next_event(ec, &id, &ring); event < 0 || event == C.RDMA_CM_EVENT_DISCONNECTED {
+			c.disconnect()
+			return
+		}
+	}
+}
+
+// Listener accepts Conns from the RDMA CM.
+type Listener struct {
+	ec      *C.struct_rdma_event_channel
+	id      *C.struct_rdma_cm_id
+	addr    net.Addr
+	accepts chan *Conn
+
+	mu     sync.Mutex
+	conns  map[*C.struct_rdma_cm_id]*Conn
+	closed bool
+	done   chan struct{}
+	served sync.WaitGroup
+}
+
+// Listen listens on the TCP port of addr in the port space of the RDMA CM.
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	ec := C.rdma_create_event_channel()
+	if ec == nil {
+		return nil, errors.New("rdma: can't create event channel")
+	}
+	chost, cport := C.CString(host), C.CString(port)
+	defer C.free(unsafe.Pointer(chost))
+	defer C.free(unsafe.Pointer(cport))
+	var code C.int
+	id := C.kf_listen(ec, chost, cport, &code)
+	if id == nil {
+		C.rdma_destroy_event_channel(ec)
+		return nil, errno("listen", code)
+	}
+	l := &Listener{
+		ec:      ec,
+		id:      id,
+		addr:    &net.TCPAddr{IP: net.ParseIP(host), Port: p},
+		accepts: make(chan *Conn, 128),
+		conns:   make(map[*C.struct_rdma_cm_id]*Conn),
+		done:    make(chan struct{}),
+	}
+	l.served.Add(1)
+	go l.serve()
+	return l, nil
+}
+
+func (l *Listener) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+// serve handles the CM events of the listener and of the connections accepted by it.
+func (l *Listener) serve() {
+	defer l.served.Done()
+	for !l.isClosed() {
+		if C.kf_poll_event(l.ec, eventPoll) <= 0 {
+			continue
+		}
+		var id *C.struct_rdma_cm_id
+		var ring C.kf_ring
+		switch event := C.kf_next_event(l.ec, &id, &ring); event {
+		case C.RDMA_CM_EVENT_CONNECT_REQUEST:
+			l.accept(id, &ring)
+		case C.RDMA_CM_EVENT_DISCONNECTED:
+			l.mu.Lock()
+			c := l.conns[id]
+			l.mu.Unlock()
+			if c != nil {
+				c.disconnect()
+			}
+		}
+	}
+}
+
+// accept accepts a connection request, which is rejected if the backlog is full and the remote will retry.
+func (l *Listener) accept(id *C.struct_rdma_cm_id, ring *C.kf_ring) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || len(l.accepts) == cap(l.accepts) {
+		C.rdma_reject(id, nil, 0)
+		C.rdma_destroy_id(id)
+		return
+	}
+	var code C.int
+	c := C.kf_accept(id, ring, slots, slotSize, &code)
+	if c == nil {
+		return
+	}
+	conn := newVerbsConn(c, func() {
+		l.mu.Lock()
+		delete(l.conns, id)
+		l.mu.Unlock()
+	})
+	l.conns[id] = conn
+	l.accepts <- conn
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepts:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "rdma", Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+// Close stops accepting connections. Connections that have been accepted are not closed,
+// and the event channel is kept for them.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	l.mu.Unlock()
+	l.served.Wait()
+	C.rdma_destroy_id(l.id)
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,21 @@
+//go:build !rdma
+// +build !rdma
+
+// Package rdma carries the connections of peers over RDMA, it is only available if KungFu is built with -tags rdma,
+// which requires librdmacm and libibverbs.
+package rdma
+
+import "net"
+
+// Available is true if KungFu is built with RDMA.
+const Available = false
+
+// Dial fails, as KungFu is built without RDMA.
+func Dial(addr string) (net.Conn, error) {
+	return nil, errNotAvailable
+}
+
+// Listen fails, as KungFu is built without RDMA.
+func Listen(addr string) (net.Listener, error) {
+	return nil, errNotAvailable
+}
@@ -0,0 +1,173 @@
+package rdma
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// memoryRegion is the registration of a region on a protection domain.
+type memoryRegion struct {
+	lkey, rkey uint32
+	release    func()
+}
+
+// domain is the protection domain of a device, which registers memory for the queue pairs of the device.
+type domain interface {
+	// register registers r, it returns nil if r can't be registered.
+	register(r *region) *memoryRegion
+}
+
+// region is a buffer registered by Register or RegisterDevice, with its memory region of each protection domain.
+type region struct {
+	sync.Mutex
+	buf    []byte // aliases device memory if device
+	device bool
+	dmabuf int // file descriptor of the dma-buf of device memory, negative if it's registered by its address
+	offset uint64
+	mrs    map[domain]*memoryRegion
+}
+
+func (r *region) begin() uintptr { return uintptr(unsafe.Pointer(&r.buf[0])) }
+
+func (r *region) contains(b []byte) bool {
+	p := uintptr(unsafe.Pointer(&b[0]))
+	return r.begin() <= p && p+uintptr(len(b)) <= r.begin()+uintptr(len(r.buf))
+}
+
+// registry is the registered regions, sorted by their addresses.
+type registry struct {
+	sync.RWMutex
+	regions []*region
+}
+
+var regions registry
+
+// find returns the region containing b, the registry must be locked.
+func (rs *registry) find(b []byte) *region {
+	p := uintptr(unsafe.Pointer(&b[0]))
+	i := sort.Search(len(rs.regions), func(i int) bool { return rs.regions[i].begin() > p })
+	if i > 0 && rs.regions[i-1].contains(b) {
+		return rs.regions[i-1]
+	}
+	return nil
+}
+
+// device returns the region of device memory containing b, if b is in device memory registered by RegisterDevice.
+func (rs *registry) device(b []byte) (*region, bool) {
+	rs.RLock()
+	defer rs.RUnlock()
+	if r := rs.find(b); r != nil && r.device {
+		return r, true
+	}
+	return nil, false
+}
+
+// keys returns the local and remote keys of the memory region of d containing b, which is registered on the first use,
+// or errDeviceMemory if b is in device memory that can't be registered, as it can't be copied.
+// The registry must be read locked.
+func (rs *registry) keys(d domain, b []byte) (uint32, uint32, error) {
+	lkey, ok, err := rs.lkey(d, b)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return 0, 0, errDeviceMemory
+	}
+	r := rs.find(b)
+	r.Lock()
+	defer r.Unlock()
+	return lkey, r.mrs[d].rkey, nil
+}
+
+// lkey returns the local key of the memory region of d containing b, which is registered on the first use,
+// or false if b must be copied. It fails if b is in device memory that can't be registered, as it can't be copied.
+// The registry must be read locked.
+func (rs *registry) lkey(d domain, b []byte) (uint32, bool, error) {
+	r := rs.find(b)
+	if r == nil {
+		return 0, false, nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	mr, ok := r.mrs[d]
+	if !ok {
+		mr = d.register(r)
+		r.mrs[d] = mr // nil if it can't be registered, and chunks of a host buffer are copied
+	}
+	if mr == nil {
+		if r.device {
+			return 0, false, errDeviceMemory
+		}
+		return 0, false, nil
+	}
+	return mr.lkey, true, nil
+}
+
+// Register registers b, e.g. a buffer owned by a session, so that the chunks of b are written from it without a copy,
+// and returns the function deregistering it. The memory of b is pinned until then, so b must be kept alive and hold
+// the same data it's registered for, Go doesn't move heap objects. Registering a part of a registered buffer does nothing.
+func Register(b []byte) func() {
+	if !Available || len(b) == 0 {
+		return func() {}
+	}
+	return register(&region{buf: b})
+}
+
+// RegisterDevice registers size bytes of device memory at ptr for GPUDirect RDMA, by the dma-buf of the memory
+// if dmabufFD is not negative, where ptr is at offset of the dma-buf, or else by its address, which requires
+// the peer memory module of the GPU driver, see GPUDirect. It returns the function deregistering the memory,
+// and the memory as a slice, which is written by the RDMA device from the GPU, and read by the remote writing
+// into it directly, without staging it in host memory. The slice must not be read or written by the host,
+// and writing or reading it fails if the memory can't be registered on the device of a connection.
+func RegisterDevice(ptr unsafe.Pointer, size int, dmabufFD int, offset uint64) (func(), []byte, error) {
+	if !Available {
+		return nil, nil, errNotAvailable
+	}
+	if ptr == nil || size <= 0 {
+		return nil, nil, errDeviceMemory
+	}
+	b := unsafe.Slice((*byte)(ptr), size)
+	return register(&region{buf: b, device: true, dmabuf: dmabufFD, offset: offset}), b, nil
+}
+
+// IsDevice returns true if b is in device memory registered by RegisterDevice.
+func IsDevice(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	_, ok := regions.device(b)
+	return ok
+}
+
+func register(r *region) func() {
+	regions.Lock()
+	defer regions.Unlock()
+	if regions.find(r.buf) != nil {
+		return func() {}
+	}
+	r.mrs = make(map[domain]*memoryRegion)
+	i := sort.Search(len(regions.regions), func(i int) bool { return regions.regions[i].begin() > r.begin() })
+	regions.regions = append(regions.regions, nil)
+	copy(regions.regions[i+1:], regions.regions[i:])
+	regions.regions[i] = r
+	var once sync.Once
+	return func() { once.Do(func() { deregister(r) }) }
+}
+
+// deregister waits for the writes from r to complete, and deregisters its memory regions.
+func deregister(r *region) {
+	regions.Lock()
+	defer regions.Unlock()
+	for i, x := range regions.regions {
+		if x == r {
+			regions.regions = append(regions.regions[:i], regions.regions[i+1:]...)
+			break
+		}
+	}
+	for _, mr := range r.mrs {
+		if mr != nil {
+			mr.release()
+		}
+	}
+}
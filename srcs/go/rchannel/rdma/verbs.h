@@ -0,0 +1,507 @@
+// The verbs of a connection: a reliable connected queue pair set up by the RDMA CM, with a ring of registered slots
+// that the remote writes chunks into by RDMA write with immediate, and a control block that the remote writes the number
+// of slots it has consumed into, and the device memory that it reads into.
+#pragma once
+
+#include <arpa/inet.h>
+#include <errno.h>
+#include <netdb.h>
+#include <poll.h>
+#include <pthread.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <infiniband/verbs.h>
+#include <rdma/rdma_cma.h>
+
+#define KF_DIRECT_IMM 0xfffffffeu
+
+// kf_ring is exchanged as the private data of the CM connection.
+typedef struct {
+    uint64_t ring_addr;
+    uint64_t control_addr;
+    uint32_t ring_rkey;
+    uint32_t control_rkey;
+} kf_ring;
+
+// kf_advert is the memory that the remote reads into, which is written into by kf_write_direct.
+typedef struct {
+    uint64_t addr;
+    uint64_t size;
+    uint32_t rkey;
+    uint32_t reserved;
+    uint64_t seq;  // written after the others, see kf_advertise
+} kf_advert;
+
+typedef struct {
+    uint64_t credit;  // slots consumed
+    kf_advert advert;
+} kf_control;
+
+// A protection domain is shared by the connections of a device, so that a buffer is registered once for all of them.
+#define KF_MAX_DEVICES 16
+
+static struct {
+    pthread_mutex_t mu;
+    int n;
+    struct ibv_context *verbs[KF_MAX_DEVICES];
+    struct ibv_pd *pds[KF_MAX_DEVICES];
+} kf_pds = {.mu = PTHREAD_MUTEX_INITIALIZER};
+
+static struct ibv_pd *kf_pd(struct ibv_context *verbs)
+{
+    struct ibv_pd *pd = NULL;
+    pthread_mutex_lock(&kf_pds.mu);
+    for (int i = 0; i < kf_pds.n; i++) {
+        if (kf_pds.verbs[i] == verbs) {
+            pd = kf_pds.pds[i];
+        }
+    }
+    if (pd == NULL && kf_pds.n < KF_MAX_DEVICES && (pd = ibv_alloc_pd(verbs)) != NULL) {
+        kf_pds.verbs[kf_pds.n] = verbs;
+        kf_pds.pds[kf_pds.n] = pd;
+        kf_pds.n++;
+    }
+    pthread_mutex_unlock(&kf_pds.mu);
+    return pd;
+}
+
+typedef struct {
+    struct rdma_event_channel *ec;  // NULL for connections accepted by a listener, whose events go to the listener
+    struct rdma_cm_id *id;
+    struct ibv_pd *pd;  // shared, see kf_pd
+    struct ibv_comp_channel *cc;
+    struct ibv_cq *scq, *rcq;
+    uint32_t slots, slot_size;
+    char *ring;
+    struct ibv_mr *ring_mr;
+    char *stage;  // the source of chunks that are not in registered buffers
+    struct ibv_mr *stage_mr;
+    kf_control *control;  // written by the remote
+    kf_control *source;   // the source of the writes into the control block of the remote
+    struct ibv_mr *control_mr, *source_mr;
+    kf_ring remote;
+} kf_conn;
+
+static int kf_wait_event(struct rdma_event_channel *ec, enum rdma_cm_event_type want, kf_ring *ring)
+{
+    struct rdma_cm_event *e;
+    if (rdma_get_cm_event(ec, &e)) {
+        return -errno;
+    }
+    int ok = e->event == want;
+    if (ok && ring != NULL && e->param.conn.private_data_len >= sizeof(kf_ring)) {
+        memcpy(ring, e->param.conn.private_data, sizeof(kf_ring));
+    }
+    int status = e->status;
+    rdma_ack_cm_event(e);
+    return ok ? 0 : (status < 0 ? status : -ECONNREFUSED);
+}
+
+static void kf_free(kf_conn *c)
+{
+    if (c->id != NULL && c->id->qp != NULL) {
+        rdma_destroy_qp(c->id);
+    }
+    struct ibv_mr *mrs[] = {c->ring_mr, c->stage_mr, c->control_mr, c->source_mr};
+    for (int i = 0; i < 4; i++) {
+        if (mrs[i] != NULL) {
+            ibv_dereg_mr(mrs[i]);
+        }
+    }
+    if (c->scq != NULL) {
+        ibv_destroy_cq(c->scq);
+    }
+    if (c->rcq != NULL) {
+        ibv_destroy_cq(c->rcq);
+    }
+    if (c->cc != NULL) {
+        ibv_destroy_comp_channel(c->cc);
+    }
+    if (c->id != NULL) {
+        rdma_destroy_id(c->id);
+    }
+    if (c->ec != NULL) {
+        rdma_destroy_event_channel(c->ec);
+    }
+    free(c->ring);
+    free(c->stage);
+    free(c->control);
+    free(c->source);
+    free(c);
+}
+
+static int kf_post_recv(kf_conn *c)
+{
+    struct ibv_recv_wr wr, *bad;
+    memset(&wr, 0, sizeof(wr));
+    return -ibv_post_recv(c->id->qp, &wr, &bad);
+}
+
+// kf_setup creates the queue pair of c->id and the registered buffers, and posts a receive for each slot, the EOF and
+// the completion of a write into the advertised memory.
+static int kf_setup(kf_conn *c, uint32_t slots, uint32_t slot_size)
+{
+    c->slots = slots;
+    c->slot_size = slot_size;
+    struct ibv_context *verbs = c->id->verbs;
+    if ((c->pd = kf_pd(verbs)) == NULL || (c->cc = ibv_create_comp_channel(verbs)) == NULL ||
+        (c->scq = ibv_create_cq(verbs, 4, NULL, NULL, 0)) == NULL ||
+        (c->rcq = ibv_create_cq(verbs, slots + 2, NULL, c->cc, 0)) == NULL) {
+        return -ENOMEM;
+    }
+    struct ibv_qp_init_attr attr;
+    memset(&attr, 0, sizeof(attr));
+    attr.send_cq = c->scq;
+    attr.recv_cq = c->rcq;
+    attr.qp_type = IBV_QPT_RC;
+    attr.cap.max_send_wr = 4;
+    attr.cap.max_recv_wr = slots + 2;
+    attr.cap.max_send_sge = 1;
+    attr.cap.max_recv_sge = 1;
+    if (rdma_create_qp(c->id, c->pd, &attr)) {
+        return -errno;
+    }
+    size_t ring_size = (size_t)slots * slot_size;
+    c->ring = calloc(1, ring_size);
+    c->stage = calloc(1, slot_size);
+    c->control = calloc(1, sizeof(kf_control));
+    c->source = calloc(1, sizeof(kf_control));
+    if (c->ring == NULL || c->stage == NULL || c->control == NULL || c->source == NULL) {
+        return -ENOMEM;
+    }
+    int remote_write = IBV_ACCESS_LOCAL_WRITE | IBV_ACCESS_REMOTE_WRITE;
+    if ((c->ring_mr = ibv_reg_mr(c->pd, c->ring, ring_size, remote_write)) == NULL ||
+        (c->stage_mr = ibv_reg_mr(c->pd, c->stage, slot_size, IBV_ACCESS_LOCAL_WRITE)) == NULL ||
+        (c->control_mr = ibv_reg_mr(c->pd, c->control, sizeof(kf_control), remote_write)) == NULL ||
+        (c->source_mr = ibv_reg_mr(c->pd, c->source, sizeof(kf_control), IBV_ACCESS_LOCAL_WRITE)) == NULL) {
+        return -errno;
+    }
+    for (uint32_t i = 0; i < slots + 2; i++) {
+        int err = kf_post_recv(c);
+        if (err != 0) {
+            return err;
+        }
+    }
+    return 0;
+}
+
+static void kf_local_ring(kf_conn *c, kf_ring *ring)
+{
+    ring->ring_addr = (uint64_t)(uintptr_t)c->ring;
+    ring->ring_rkey = c->ring_mr->rkey;
+    ring->control_addr = (uint64_t)(uintptr_t)c->control;
+    ring->control_rkey = c->control_mr->rkey;
+}
+
+static void kf_conn_param(struct rdma_conn_param *param, kf_ring *ring)
+{
+    memset(param, 0, sizeof(*param));
+    param->private_data = ring;
+    param->private_data_len = sizeof(*ring);
+    param->responder_resources = 1;
+    param->initiator_depth = 1;
+    param->retry_count = 7;
+    param->rnr_retry_count = 7;
+}
+
+// kf_dial connects to host:port, it returns NULL and sets *err on failure.
+static kf_conn *kf_dial(const char *host, const char *port, uint32_t slots, uint32_t slot_size, int timeout_ms, int *err)
+{
+    kf_conn *c = calloc(1, sizeof(kf_conn));
+    struct addrinfo *ai = NULL;
+    if (c == NULL) {
+        *err = -ENOMEM;
+        return NULL;
+    }
+    if ((*err = getaddrinfo(host, port, NULL, &ai)) != 0) {
+        *err = -EHOSTUNREACH;
+        goto fail;
+    }
+    if ((c->ec = rdma_create_event_channel()) == NULL || rdma_create_id(c->ec, &c->id, NULL, RDMA_PS_TCP) ||
+        rdma_resolve_addr(c->id, NULL, ai->ai_addr, timeout_ms)) {
+        *err = -errno;
+        goto fail;
+    }
+    if ((*err = kf_wait_event(c->ec, RDMA_CM_EVENT_ADDR_RESOLVED, NULL)) != 0) {
+        goto fail;
+    }
+    if (rdma_resolve_route(c->id, timeout_ms)) {
+        *err = -errno;
+        goto fail;
+    }
+    if ((*err = kf_wait_event(c->ec, RDMA_CM_EVENT_ROUTE_RESOLVED, NULL)) != 0 ||
+        (*err = kf_setup(c, slots, slot_size)) != 0) {
+        goto fail;
+    }
+    kf_ring ring;
+    kf_local_ring(c, &ring);
+    struct rdma_conn_param param;
+    kf_conn_param(&param, &ring);
+    if (rdma_connect(c->id, &param)) {
+        *err = -errno;
+        goto fail;
+    }
+    if ((*err = kf_wait_event(c->ec, RDMA_CM_EVENT_ESTABLISHED, &c->remote)) != 0) {
+        goto fail;
+    }
+    freeaddrinfo(ai);
+    return c;
+fail:
+    if (ai != NULL) {
+        freeaddrinfo(ai);
+    }
+    kf_free(c);
+    return NULL;
+}
+
+// kf_accept accepts the connection request of id with the ring of the remote, it returns NULL and sets *err on failure.
+static kf_conn *kf_accept(struct rdma_cm_id *id, kf_ring *remote, uint32_t slots, uint32_t slot_size, int *err)
+{
+    kf_conn *c = calloc(1, sizeof(kf_conn));
+    if (c == NULL) {
+        *err = -ENOMEM;
+        rdma_reject(id, NULL, 0);
+        rdma_destroy_id(id);
+        return NULL;
+    }
+    c->id = id;
+    c->remote = *remote;
+    if ((*err = kf_setup(c, slots, slot_size)) != 0) {
+        rdma_reject(id, NULL, 0);
+        kf_free(c);
+        return NULL;
+    }
+    kf_ring ring;
+    kf_local_ring(c, &ring);
+    struct rdma_conn_param param;
+    kf_conn_param(&param, &ring);
+    if (rdma_accept(id, &param)) {
+        *err = -errno;
+        kf_free(c);
+        return NULL;
+    }
+    return c;
+}
+
+static struct ibv_mr *kf_reg_mr(struct ibv_pd *pd, void *addr, size_t len)
+{
+    return ibv_reg_mr(pd, addr, len, IBV_ACCESS_LOCAL_WRITE);
+}
+
+// kf_reg_device_mr registers device memory by the dma-buf of fd at offset if fd is not negative, or else by its address,
+// which requires the peer memory module of the GPU driver. The remote writes into it when it's advertised.
+static struct ibv_mr *kf_reg_device_mr(struct ibv_pd *pd, void *addr, size_t len, int fd, uint64_t offset)
+{
+    int access = IBV_ACCESS_LOCAL_WRITE | IBV_ACCESS_REMOTE_WRITE;
+    if (fd >= 0) {
+        return ibv_reg_dmabuf_mr(pd, offset, len, (uint64_t)(uintptr_t)addr, fd, access);
+    }
+    return ibv_reg_mr(pd, addr, len, access);
+}
+
+static int kf_wait_send(kf_conn *c)
+{
+    struct ibv_wc wc;
+    int n;
+    while ((n = ibv_poll_cq(c->scq, 1, &wc)) == 0) {
+    }
+    if (n < 0) {
+        return -EIO;
+    }
+    return wc.status == IBV_WC_SUCCESS ? 0 : -EIO;
+}
+
+// kf_post_write posts an RDMA write of len bytes at addr, which is in the region of lkey, to remote_addr of rkey,
+// with the immediate data imm if with_imm.
+static int kf_post_write(kf_conn *c, void *addr, uint32_t len, uint32_t lkey, uint64_t remote_addr, uint32_t rkey,
+                         int with_imm, uint32_t imm, int signaled)
+{
+    struct ibv_sge sge = {.addr = (uint64_t)(uintptr_t)addr, .length = len, .lkey = lkey};
+    struct ibv_send_wr wr, *bad;
+    memset(&wr, 0, sizeof(wr));
+    wr.opcode = with_imm ? IBV_WR_RDMA_WRITE_WITH_IMM : IBV_WR_RDMA_WRITE;
+    wr.send_flags = signaled ? IBV_SEND_SIGNALED : 0;
+    wr.imm_data = htonl(imm);
+    wr.sg_list = len > 0 ? &sge : NULL;
+    wr.num_sge = len > 0 ? 1 : 0;
+    wr.wr.rdma.remote_addr = remote_addr;
+    wr.wr.rdma.rkey = rkey;
+    return -ibv_post_send(c->id->qp, &wr, &bad);
+}
+
+// kf_write writes len bytes at addr, which is in the region of lkey, into the slot of the remote ring with the length
+// as the immediate data, and waits for its completion.
+static int kf_write(kf_conn *c, uint32_t slot, void *addr, uint32_t len, uint32_t lkey, uint32_t imm)
+{
+    uint64_t remote_addr = c->remote.ring_addr + (uint64_t)slot * c->slot_size;
+    int err = kf_post_write(c, addr, len, lkey, remote_addr, c->remote.ring_rkey, 1, imm, 1);
+    if (err != 0) {
+        return err;
+    }
+    return kf_wait_send(c);
+}
+
+// kf_write_direct writes len bytes at addr, which is in the region of lkey, into the memory advertised by the remote
+// at remote_addr of rkey, with KF_DIRECT_IMM as the immediate data, and waits for its completion.
+static int kf_write_direct(kf_conn *c, uint64_t remote_addr, uint32_t rkey, void *addr, uint32_t len, uint32_t lkey)
+{
+    int err = kf_post_write(c, addr, len, lkey, remote_addr, rkey, 1, KF_DIRECT_IMM, 1);
+    if (err != 0) {
+        return err;
+    }
+    return kf_wait_send(c);
+}
+
+// kf_write_control writes len bytes at off of the source block into the same offset of the control block of the remote.
+static int kf_write_control(kf_conn *c, size_t off, uint32_t len, int signaled)
+{
+    return kf_post_write(c, (char *)c->source + off, len, c->source_mr->lkey, c->remote.control_addr + off,
+                         c->remote.control_rkey, 0, 0, signaled);
+}
+
+// kf_return_credit writes the number of consumed slots into the control block of the remote.
+static int kf_return_credit(kf_conn *c, uint64_t consumed)
+{
+    c->source->credit = consumed;
+    int err = kf_write_control(c, offsetof(kf_control, credit), sizeof(uint64_t), 1);
+    if (err != 0) {
+        return err;
+    }
+    return kf_wait_send(c);
+}
+
+static uint64_t kf_credit(kf_conn *c)
+{
+    return __atomic_load_n(&c->control->credit, __ATOMIC_ACQUIRE);
+}
+
+// kf_advertise writes the memory that is read into into the control block of the remote. The sequence number is
+// written by a later write, which is placed after the others, so that the remote reads the advert once seq changes.
+static int kf_advertise(kf_conn *c, uint64_t addr, uint64_t size, uint32_t rkey, uint64_t seq)
+{
+    kf_advert *a = &c->source->advert;
+    a->addr = addr;
+    a->size = size;
+    a->rkey = rkey;
+    a->seq = seq;
+    int err = kf_write_control(c, offsetof(kf_control, advert), offsetof(kf_advert, seq), 0);
+    if (err != 0) {
+        return err;
+    }
+    err = kf_write_control(c, offsetof(kf_control, advert) + offsetof(kf_advert, seq), sizeof(uint64_t), 1);
+    if (err != 0) {
+        return err;
+    }
+    return kf_wait_send(c);
+}
+
+// kf_remote_advert reads the latest advert of the remote.
+static void kf_remote_advert(kf_conn *c, kf_advert *a)
+{
+    kf_advert *r = &c->control->advert;
+    a->seq = __atomic_load_n(&r->seq, __ATOMIC_ACQUIRE);
+    a->addr = r->addr;
+    a->size = r->size;
+    a->rkey = r->rkey;
+}
+
+// kf_wait_recv blocks until the next chunk arrives, and returns its immediate data in *imm.
+static int kf_wait_recv(kf_conn *c, uint32_t *imm)
+{
+    struct ibv_wc wc;
+    for (;;) {
+        int n = ibv_poll_cq(c->rcq, 1, &wc);
+        if (n == 0) {
+            if (ibv_req_notify_cq(c->rcq, 0)) {
+                return -errno;
+            }
+            n = ibv_poll_cq(c->rcq, 1, &wc);  // a completion may arrive before the notification is armed
+        }
+        if (n < 0) {
+            return -EIO;
+        }
+        if (n == 1) {
+            if (wc.status != IBV_WC_SUCCESS) {
+                return -ECONNRESET;
+            }
+            *imm = ntohl(wc.imm_data);
+            return 0;
+        }
+        struct ibv_cq *cq;
+        void *ctx;
+        if (ibv_get_cq_event(c->cc, &cq, &ctx)) {
+            return -errno;
+        }
+        ibv_ack_cq_events(cq, 1);
+    }
+}
+
+// kf_listen listens on host:port, host can be empty for any address.
+static struct rdma_cm_id *kf_listen(struct rdma_event_channel *ec, const char *host, const char *port, int *err)
+{
+    struct addrinfo hints, *ai;
+    memset(&hints, 0, sizeof(hints));
+    hints.ai_flags = AI_PASSIVE;
+    hints.ai_family = AF_INET;
+    if (getaddrinfo(host[0] ? host : NULL, port, &hints, &ai) != 0) {
+        *err = -EADDRNOTAVAIL;
+        return NULL;
+    }
+    struct rdma_cm_id *id;
+    if (rdma_create_id(ec, &id, NULL, RDMA_PS_TCP)) {
+        *err = -errno;
+        freeaddrinfo(ai);
+        return NULL;
+    }
+    if (rdma_bind_addr(id, ai->ai_addr) || rdma_listen(id, 128)) {
+        *err = -errno;
+        freeaddrinfo(ai);
+        rdma_destroy_id(id);
+        return NULL;
+    }
+    freeaddrinfo(ai);
+    return id;
+}
+
+// kf_next_event returns the next event of ec and its id, with the ring of the remote if it's a connection request.
+// A connection request without a ring is rejected, and returned as RDMA_CM_EVENT_REJECTED.
+static int kf_next_event(struct rdma_event_channel *ec, struct rdma_cm_id **id, kf_ring *ring)
+{
+    struct rdma_cm_event *e;
+    if (rdma_get_cm_event(ec, &e)) {
+        return -errno;
+    }
+    int event = e->event, rejected = 0;
+    *id = e->id;
+    if (event == RDMA_CM_EVENT_CONNECT_REQUEST) {
+        if (e->param.conn.private_data_len >= sizeof(kf_ring)) {
+            memcpy(ring, e->param.conn.private_data, sizeof(kf_ring));
+        } else {
+            rdma_reject(e->id, NULL, 0);
+            rejected = 1;
+        }
+    }
+    rdma_ack_cm_event(e);  // before the id is destroyed, which waits for its events to be acked
+    if (rejected) {
+        rdma_destroy_id(*id);
+        return RDMA_CM_EVENT_REJECTED;
+    }
+    return event;
+}
+
+static void kf_addr(struct sockaddr *sa, uint32_t *ip, uint16_t *port)
+{
+    struct sockaddr_in *in = (struct sockaddr_in *)sa;
+    *ip = ntohl(in->sin_addr.s_addr);
+    *port = ntohs(in->sin_port);
+}
+
+// kf_poll_event returns 1 if there is an event on ec within timeout_ms.
+static int kf_poll_event(struct rdma_event_channel *ec, int timeout_ms)
+{
+    struct pollfd p = {.fd = ec->fd, .events = POLLIN};
+    return poll(&p, 1, timeout_ms);
+}
@@ -0,0 +1,144 @@
+package rudp
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const maxDatagram = 64 * 1024
+
+// Dial connects to a Listener at addr.
+func Dial(addr string) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is not connected, because replies of a multi-homed listener may come from another address
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	output := func(b []byte) error {
+		_, err := udp.WriteToUDP(b, raddr)
+		return err
+	}
+	conv := rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	c := newConn(conv, udp.LocalAddr(), raddr, output, func() { udp.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, _, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				c.mu.Lock()
+				c.fail(err)
+				c.mu.Unlock()
+				return
+			}
+			c.input(buf[:n])
+		}
+	}()
+	return c, nil
+}
+
+type connKey struct {
+	addr string
+	conv uint32
+}
+
+// Listener accepts Conns from a UDP socket.
+type Listener struct {
+	udp     *net.UDPConn
+	accepts chan *Conn
+
+	mu     sync.Mutex
+	conns  map[connKey]*Conn
+	closed bool
+	done   chan struct{}
+}
+
+// Listen listens on the UDP address addr.
+func Listen(addr string) (*Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		udp:     udp,
+		accepts: make(chan *Conn, 128),
+		conns:   make(map[connKey]*Conn),
+		done:    make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *Listener) serve() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, raddr, err := l.udp.ReadFromUDP(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		if n < headerSize {
+			continue
+		}
+		var h header
+		h.decode(buf)
+		key := connKey{addr: raddr.String(), conv: h.Conv}
+		l.mu.Lock()
+		c, ok := l.conns[key]
+		// a new conversation starts from the first segment, it's dropped if the backlog is full and the peer will retry
+		if !ok && h.Cmd == cmdPush && h.SN == 0 && !l.closed && len(l.accepts) < cap(l.accepts) {
+			c = l.newConn(key, raddr)
+			l.conns[key] = c
+			l.accepts <- c
+		}
+		l.mu.Unlock()
+		if c != nil {
+			c.input(buf[:n])
+		}
+	}
+}
+
+func (l *Listener) newConn(key connKey, raddr *net.UDPAddr) *Conn {
+	output := func(b []byte) error {
+		_, err := l.udp.WriteToUDP(b, raddr)
+		return err
+	}
+	return newConn(key.conv, l.udp.LocalAddr(), raddr, output, func() {
+		l.mu.Lock()
+		delete(l.conns, key)
+		l.mu.Unlock()
+	})
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepts:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "rudp", Addr: l.udp.LocalAddr(), Err: net.ErrClosed}
+	}
+}
+
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	return l.udp.Close()
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.udp.LocalAddr()
+}
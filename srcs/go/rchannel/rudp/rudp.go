@@ -0,0 +1,429 @@
+// Package rudp implements a reliable transport over UDP in the style of KCP, for high-latency lossy links.
+// Data is split into segments, which are acknowledged selectively and retransmitted aggressively
+// based on the measured RTT, instead of backing off as TCP does on loss.
+package rudp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	cmdPush uint8 = 81
+	cmdAck  uint8 = 82
+	cmdFin  uint8 = 83
+)
+
+const (
+	headerSize = 24
+	mtu        = 1400
+	mss        = mtu - headerSize
+
+	wndSize     = 512 // segments in flight and in the receive buffer
+	interval    = 10 * time.Millisecond
+	minRTO      = 30 * time.Millisecond
+	maxRTO      = 5 * time.Second
+	fastResend  = 2  // resend a segment if it's skipped by this many ACKs
+	deadLink    = 30 // give up a segment after this many transmissions
+	lingerLimit = 5 * time.Second
+)
+
+var (
+	errClosed   = errors.New("rudp: use of closed connection")
+	errDeadLink = errors.New("rudp: peer unreachable")
+	errDeadline = errors.New("rudp: deadlines are not supported")
+)
+
+var endian = binary.LittleEndian
+
+type header struct {
+	Conv uint32
+	Cmd  uint8
+	_    uint8
+	Wnd  uint16
+	TS   uint32 // milliseconds since the sender started
+	SN   uint32
+	UNA  uint32 // all segments before UNA are received
+	Len  uint32
+}
+
+func (h *header) encode(b []byte) {
+	endian.PutUint32(b[0:], h.Conv)
+	b[4] = h.Cmd
+	endian.PutUint16(b[6:], h.Wnd)
+	endian.PutUint32(b[8:], h.TS)
+	endian.PutUint32(b[12:], h.SN)
+	endian.PutUint32(b[16:], h.UNA)
+	endian.PutUint32(b[20:], h.Len)
+}
+
+func (h *header) decode(b []byte) {
+	h.Conv = endian.Uint32(b[0:])
+	h.Cmd = b[4]
+	h.Wnd = endian.Uint16(b[6:])
+	h.TS = endian.Uint32(b[8:])
+	h.SN = endian.Uint32(b[12:])
+	h.UNA = endian.Uint32(b[16:])
+	h.Len = endian.Uint32(b[20:])
+}
+
+type segment struct {
+	cmd     uint8
+	sn      uint32
+	ts      uint32
+	data    []byte
+	resend  time.Time
+	rto     time.Duration
+	xmit    int
+	fastack int
+}
+
+type ack struct {
+	sn, ts uint32
+}
+
+// Conn is a reliable connection over UDP.
+type Conn struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	conv   uint32
+	local  net.Addr
+	remote net.Addr
+	output func([]byte) error
+	onDone func()
+	t0     time.Time
+
+	sndQueue []*segment // not sent yet
+	sndBuf   []*segment // in flight, ordered by sn
+	sndNxt   uint32
+	rmtWnd   uint16
+	finSent  bool
+
+	rcvNxt  uint32
+	rcvBuf  map[uint32]*segment
+	readBuf bytes.Buffer
+	acks    []ack
+	eof     bool
+
+	srtt, rttvar time.Duration
+	rto          time.Duration
+
+	closed   bool
+	err      error
+	lingerTo time.Time
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+func newConn(conv uint32, local, remote net.Addr, output func([]byte) error, onDone func()) *Conn {
+	c := &Conn{
+		conv:   conv,
+		local:  local,
+		remote: remote,
+		output: output,
+		onDone: onDone,
+		t0:     time.Now(),
+		rmtWnd: wndSize,
+		rcvBuf: make(map[uint32]*segment),
+		rto:    200 * time.Millisecond,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.loop()
+	return c
+}
+
+func (c *Conn) now() uint32 {
+	return uint32(time.Since(c.t0) / time.Millisecond)
+}
+
+func (c *Conn) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) loop() {
+	defer func() {
+		close(c.done)
+		if c.onDone != nil {
+			c.onDone()
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.wake:
+		}
+		if !c.flush() {
+			return
+		}
+	}
+}
+
+// flush sends ACKs, new segments and retransmissions, it returns false when the connection is finished.
+func (c *Conn) flush() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false
+	}
+	if c.closed && c.finSent && len(c.sndBuf) == 0 && len(c.sndQueue) == 0 {
+		return false
+	}
+	if c.closed && time.Now().After(c.lingerTo) {
+		c.fail(errClosed)
+		return false
+	}
+	var pkt []byte
+	send := func(h header, data []byte) {
+		if len(pkt)+headerSize+len(data) > mtu {
+			c.output(pkt)
+			pkt = nil
+		}
+		var b [headerSize]byte
+		h.encode(b[:])
+		pkt = append(pkt, b[:]...)
+		pkt = append(pkt, data...)
+	}
+	wnd := c.rcvWnd()
+	for _, a := range c.acks {
+		send(header{Conv: c.conv, Cmd: cmdAck, Wnd: wnd, TS: a.ts, SN: a.sn, UNA: c.rcvNxt}, nil)
+	}
+	c.acks = c.acks[:0]
+	limit := int(c.rmtWnd)
+	if limit > wndSize {
+		limit = wndSize
+	}
+	if limit == 0 {
+		limit = 1 // probe the window
+	}
+	now := time.Now()
+	for len(c.sndQueue) > 0 && len(c.sndBuf) < limit {
+		s := c.sndQueue[0]
+		c.sndQueue = c.sndQueue[1:]
+		s.sn = c.sndNxt
+		c.sndNxt++
+		s.rto = c.rto
+		s.resend = now // sent below
+		c.sndBuf = append(c.sndBuf, s)
+	}
+	for _, s := range c.sndBuf {
+		if !now.Before(s.resend) || s.fastack >= fastResend {
+			if s.xmit > 0 {
+				s.rto += s.rto / 2
+				if s.rto > maxRTO {
+					s.rto = maxRTO
+				}
+			}
+			s.xmit++
+			if s.xmit > deadLink {
+				c.fail(errDeadLink)
+				return false
+			}
+			s.fastack = 0
+			s.ts = c.now()
+			s.resend = now.Add(s.rto)
+			send(header{Conv: c.conv, Cmd: s.cmd, Wnd: wnd, TS: s.ts, SN: s.sn, UNA: c.rcvNxt, Len: uint32(len(s.data))}, s.data)
+		}
+	}
+	if len(pkt) > 0 {
+		c.output(pkt)
+	}
+	c.cond.Broadcast()
+	return true
+}
+
+func (c *Conn) rcvWnd() uint16 {
+	used := len(c.rcvBuf) + c.readBuf.Len()/mss
+	if used >= wndSize {
+		return 0
+	}
+	return uint16(wndSize - used)
+}
+
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+}
+
+// input processes a datagram received from the remote.
+func (c *Conn) input(pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var needFlush bool
+	for len(pkt) >= headerSize {
+		var h header
+		h.decode(pkt)
+		pkt = pkt[headerSize:]
+		if h.Conv != c.conv || int(h.Len) > len(pkt) {
+			return
+		}
+		data := pkt[:h.Len]
+		pkt = pkt[h.Len:]
+		c.rmtWnd = h.Wnd
+		c.ackUntil(h.UNA)
+		switch h.Cmd {
+		case cmdAck:
+			c.ackOne(h.SN, h.TS)
+		case cmdPush, cmdFin:
+			c.acks = append(c.acks, ack{sn: h.SN, ts: h.TS})
+			needFlush = true
+			if diff(h.SN, c.rcvNxt) >= 0 && diff(h.SN, c.rcvNxt) < wndSize {
+				if _, ok := c.rcvBuf[h.SN]; !ok {
+					c.rcvBuf[h.SN] = &segment{cmd: h.Cmd, sn: h.SN, data: append([]byte(nil), data...)}
+				}
+			}
+		}
+	}
+	for {
+		s, ok := c.rcvBuf[c.rcvNxt]
+		if !ok {
+			break
+		}
+		delete(c.rcvBuf, c.rcvNxt)
+		c.rcvNxt++
+		if s.cmd == cmdFin {
+			c.eof = true
+		} else {
+			c.readBuf.Write(s.data)
+		}
+	}
+	c.cond.Broadcast()
+	if needFlush {
+		c.notify()
+	}
+}
+
+func diff(a, b uint32) int32 { return int32(a - b) }
+
+func (c *Conn) ackUntil(una uint32) {
+	i := 0
+	for i < len(c.sndBuf) && diff(c.sndBuf[i].sn, una) < 0 {
+		i++
+	}
+	if i > 0 {
+		c.sndBuf = c.sndBuf[i:]
+	}
+}
+
+func (c *Conn) ackOne(sn, ts uint32) {
+	if rtt := time.Duration(diff(c.now(), ts)) * time.Millisecond; rtt >= 0 {
+		c.updateRTT(rtt)
+	}
+	for i, s := range c.sndBuf {
+		if s.sn == sn {
+			c.sndBuf = append(c.sndBuf[:i], c.sndBuf[i+1:]...)
+			break
+		}
+		if diff(s.sn, sn) < 0 {
+			s.fastack++
+		}
+	}
+}
+
+func (c *Conn) updateRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = rtt, rtt/2
+	} else {
+		d := c.srtt - rtt
+		if d < 0 {
+			d = -d
+		}
+		c.rttvar = (3*c.rttvar + d) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.readBuf.Len() == 0 || c.closed {
+		if c.closed {
+			return 0, errClosed // Close wakes the readers
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.cond.Wait()
+	}
+	wasFull := c.rcvWnd() == 0
+	n, _ := c.readBuf.Read(b)
+	if wasFull {
+		c.notify() // announce the window
+	}
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for len(b) > 0 {
+		for len(c.sndQueue) >= wndSize && c.err == nil && !c.closed {
+			c.cond.Wait()
+		}
+		if c.closed {
+			return n, errClosed
+		}
+		if c.err != nil {
+			return n, c.err
+		}
+		k := len(b)
+		if k > mss {
+			k = mss
+		}
+		c.sndQueue = append(c.sndQueue, &segment{cmd: cmdPush, data: append([]byte(nil), b[:k]...)})
+		b = b[k:]
+		n += k
+	}
+	c.notify()
+	return n, nil
+}
+
+// Close sends the remaining data followed by FIN, and returns without waiting for them to be acknowledged.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.lingerTo = time.Now().Add(lingerLimit)
+	if c.err == nil {
+		c.sndQueue = append(c.sndQueue, &segment{cmd: cmdFin})
+		c.finSent = true
+	}
+	c.cond.Broadcast()
+	c.notify()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error      { return errDeadline }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return errDeadline }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return errDeadline }
@@ -0,0 +1,125 @@
+package rudp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyRelay forwards datagrams between a client and addr, dropping some of them.
+func lossyRelay(t *testing.T, addr string, lossRate float64) string {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var client *net.UDPAddr
+	go func() {
+		r := rand.New(rand.NewSource(1))
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			client = from
+			mu.Unlock()
+			if r.Float64() >= lossRate {
+				up.Write(buf[:n])
+			}
+		}
+	}()
+	go func() {
+		r := rand.New(rand.NewSource(2))
+		buf := make([]byte, maxDatagram)
+		for {
+			n, err := up.Read(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			to := client
+			mu.Unlock()
+			if to != nil && r.Float64() >= lossRate {
+				relay.WriteToUDP(buf[:n], to)
+			}
+		}
+	}()
+	t.Cleanup(func() { relay.Close(); up.Close() })
+	return relay.LocalAddr().String()
+}
+
+func Test_LossyTransfer(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	got := make(chan []byte)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		bs, _ := ioutil.ReadAll(conn)
+		got <- bs
+		conn.Close()
+	}()
+	c, err := Dial(lossyRelay(t, l.Addr().String(), 0.1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if bs := <-got; !bytes.Equal(bs, data) {
+		t.Errorf("received %d bytes, want %d bytes", len(bs), len(data))
+	}
+}
+
+func Test_CloseWakesRead(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
+		if err != errClosed {
+			t.Errorf("Read after Close: %v, want %v", err, errClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read is blocked after Close")
+	}
+	if _, err := c.Read(make([]byte, 1)); err != errClosed {
+		t.Errorf("Read of a closed connection: %v, want %v", err, errClosed)
+	}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"net"
 	"os"
 	"sync"
 
+	"github.com/lsds/KungFu/srcs/go/kungfu/config"
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
@@ -15,40 +17,88 @@ type Server interface {
 	Start() error
 	Close()
 	SetToken(uint32)
+	SetMembers(plan.PeerList)
 }
 
 // New creates a new Server
 func New(self plan.PeerID, handler connection.Handler, useUnixSock bool) *composedServer {
+	return NewWithListen(self, handler, nil, useUnixSock, config.Transport)
+}
+
+// NewWithListen creates a new Server, which accepts TCP connections from the listener returned by listen,
+// e.g. a listener owned by a daemon. It listens on the TCP port of self if listen is nil,
+// and on the port of transport if it isn't TCP.
+func NewWithListen(self plan.PeerID, handler connection.Handler, listen func() (net.Listener, error), useUnixSock bool, transport string) *composedServer {
 	tcpServer := newTCPServer(self, handler)
+	if listen != nil {
+		tcpServer.listen = listen
+	}
 	var unixServer *server
 	if useUnixSock {
 		unixServer = newUnixServer(self, handler)
 	}
+	var rudpServer, quicServer, rdmaServer *server
+	switch transport {
+	case connection.TransportQUIC:
+		if config.EnableRUDP {
+			log.Warnf("reliable UDP is disabled by the %s transport, which listens on the same UDP port", transport)
+		}
+		quicServer = newQUICServer(self, handler)
+	case connection.TransportRDMA:
+		if config.EnableRUDP {
+			log.Warnf("reliable UDP is disabled by the %s transport, which carries connections between hosts", transport)
+		}
+		rdmaServer = newRDMAServer(self, handler)
+	case connection.TransportTCP:
+	default:
+		log.Warnf("unknown transport %q, using %s", transport, connection.TransportTCP)
+	}
+	if config.EnableRUDP && quicServer == nil && rdmaServer == nil {
+		rudpServer = newRUDPServer(self, handler)
+	}
 	return &composedServer{
 		tcpServer:  tcpServer,
 		unixServer: unixServer,
+		rudpServer: rudpServer,
+		quicServer: quicServer,
+		rdmaServer: rdmaServer,
 	}
 }
 
 type composedServer struct {
 	tcpServer  *server
 	unixServer *server
+	rudpServer *server
+	quicServer *server
+	rdmaServer *server
 }
 
-func (s *composedServer) SetToken(token uint32) {
-	for _, srv := range []*server{s.tcpServer, s.unixServer} {
+func (s *composedServer) all() []*server {
+	var srvs []*server
+	for _, srv := range []*server{s.tcpServer, s.unixServer, s.rudpServer, s.quicServer, s.rdmaServer} {
 		if srv != nil {
-			srv.SetToken(token)
+			srvs = append(srvs, srv)
 		}
 	}
+	return srvs
+}
+
+func (s *composedServer) SetToken(token uint32) {
+	for _, srv := range s.all() {
+		srv.SetToken(token)
+	}
+}
+
+func (s *composedServer) SetMembers(pl plan.PeerList) {
+	for _, srv := range s.all() {
+		srv.SetMembers(pl)
+	}
 }
 
 func (s *composedServer) listen() error {
-	for _, srv := range []*server{s.tcpServer, s.unixServer} {
-		if srv != nil {
-			if err := srv.Listen(); err != nil {
-				return err
-			}
+	for _, srv := range s.all() {
+		if err := srv.Listen(); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -56,14 +106,12 @@ func (s *composedServer) listen() error {
 
 func (s *composedServer) serve() {
 	var wg sync.WaitGroup
-	for _, srv := range []*server{s.tcpServer, s.unixServer} {
-		if srv != nil {
-			wg.Add(1)
-			go func(srv *server) {
-				srv.Serve()
-				wg.Done()
-			}(srv)
-		}
+	for _, srv := range s.all() {
+		wg.Add(1)
+		go func(srv *server) {
+			srv.Serve()
+			wg.Done()
+		}(srv)
 	}
 	wg.Wait()
 }
@@ -86,10 +134,8 @@ func (s *composedServer) Start() error {
 }
 
 func (s *composedServer) Close() {
-	for _, srv := range []*server{s.tcpServer, s.unixServer} {
-		if srv != nil {
-			srv.Close()
-		}
+	for _, srv := range s.all() {
+		srv.Close()
 	}
 	log.Debugf("Server Closed")
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
@@ -10,6 +11,9 @@ import (
 	"github.com/lsds/KungFu/srcs/go/log"
 	"github.com/lsds/KungFu/srcs/go/plan"
 	"github.com/lsds/KungFu/srcs/go/rchannel/connection"
+	"github.com/lsds/KungFu/srcs/go/rchannel/quic"
+	"github.com/lsds/KungFu/srcs/go/rchannel/rdma"
+	"github.com/lsds/KungFu/srcs/go/rchannel/rudp"
 	"github.com/lsds/KungFu/srcs/go/utils"
 )
 
@@ -19,7 +23,9 @@ type server struct {
 	self     plan.PeerID
 	handler  connection.Handler
 	token    uint32
+	members  atomic.Value // map[plan.PeerID]struct{} of the peers allowed to connect over TLS, all if not set
 	unix     bool
+	tls      *tls.Config // of the accepted connections, nil if they are not secured by the server
 }
 
 func newTCPServer(self plan.PeerID, handler connection.Handler) *server {
@@ -31,6 +37,62 @@ func newTCPServer(self plan.PeerID, handler connection.Handler) *server {
 		},
 		self:    self,
 		handler: handler,
+		tls:     serverTLSConfig(),
+	}
+}
+
+// serverTLSConfig returns the TLS config of the servers of connections between hosts, nil if TLS is not enabled.
+func serverTLSConfig() *tls.Config {
+	if !connection.EnableTLS() {
+		return nil
+	}
+	tlsConf, err := connection.ServerTLSConfig()
+	if err != nil {
+		utils.ExitErr(fmt.Errorf("invalid TLS config: %v", err))
+	}
+	return tlsConf
+}
+
+// newRUDPServer creates a new Server listening on the UDP port of self, for peers of high RTT.
+func newRUDPServer(self plan.PeerID, handler connection.Handler) *server {
+	return &server{
+		listen: func() (net.Listener, error) {
+			listenAddr := self.ListenAddr(false)
+			log.Debugf("listening: rudp://%s", listenAddr)
+			return rudp.Listen(listenAddr.String())
+		},
+		self:    self,
+		handler: handler,
+		tls:     serverTLSConfig(),
+	}
+}
+
+// newQUICServer creates a new Server listening on the UDP port of self, which accepts each stream of QUIC connections
+// as a connection, for the transport quic.
+func newQUICServer(self plan.PeerID, handler connection.Handler) *server {
+	return &server{
+		listen: func() (net.Listener, error) {
+			listenAddr := self.ListenAddr(false)
+			log.Debugf("listening: quic://%s", listenAddr)
+			return quic.Listen(listenAddr.String(), serverTLSConfig()) // streams are secured by the QUIC connection
+		},
+		self:    self,
+		handler: handler,
+	}
+}
+
+// newRDMAServer creates a new Server listening on the TCP port of self in the port space of the RDMA CM,
+// for the transport rdma.
+func newRDMAServer(self plan.PeerID, handler connection.Handler) *server {
+	return &server{
+		listen: func() (net.Listener, error) {
+			listenAddr := self.ListenAddr(false)
+			log.Debugf("listening: rdma://%s", listenAddr)
+			return rdma.Listen(listenAddr.String())
+		},
+		self:    self,
+		handler: handler,
+		tls:     serverTLSConfig(),
 	}
 }
 
@@ -73,6 +135,21 @@ func (s *server) SetToken(token uint32) {
 	atomic.StoreUint32(&s.token, token)
 }
 
+// SetMembers sets the peers allowed to connect over TLS, e.g. the runners and workers of the current cluster,
+// so that a peer with a certificate of the CA, but of another job or removed from the cluster, is refused.
+func (s *server) SetMembers(pl plan.PeerList) {
+	s.members.Store(pl.Set())
+}
+
+func (s *server) isMember(id plan.PeerID) bool {
+	m, ok := s.members.Load().(map[plan.PeerID]struct{})
+	if !ok {
+		return true
+	}
+	_, ok = m[id]
+	return ok
+}
+
 func (s *server) Listen() error {
 	var err error
 	s.listener, err = s.listen()
@@ -84,8 +161,12 @@ func (s *server) accept() (connection.Connection, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn, err := connection.UpgradeFrom(tcpConn, s.self, atomic.LoadUint32(&s.token))
+	if s.tls != nil {
+		tcpConn = tls.Server(tcpConn, s.tls)
+	}
+	conn, err := connection.UpgradeFrom(tcpConn, s.self, atomic.LoadUint32(&s.token), s.isMember)
 	if err != nil {
+		tcpConn.Close()
 		return nil, err
 	}
 	return conn, nil
@@ -20,11 +20,22 @@ func Immpossible() {
 	ExitErr(errImpossible)
 }
 
+// MergeErrors returns nil if all errs are nil, the error itself if all non-nil errs are the same error,
+// so that typed errors survive merging, otherwise an error with all messages, which wraps all non-nil errs.
 func MergeErrors(errs []error, hint string) error {
 	var msg string
 	var failed int
+	var first error
+	var nonNil []error
+	same := true
 	for _, e := range errs {
 		if e != nil {
+			if first == nil {
+				first = e
+			} else if e != first {
+				same = false
+			}
+			nonNil = append(nonNil, e)
 			failed++
 			if len(msg) > 0 {
 				msg += ", "
@@ -35,5 +46,19 @@ func MergeErrors(errs []error, hint string) error {
 	if failed == 0 {
 		return nil
 	}
-	return fmt.Errorf("%s failed with %s: %s", hint, Pluralize(failed, "error", "errors"), msg)
+	if same {
+		return first
+	}
+	return &mergedError{
+		msg:  fmt.Sprintf("%s failed with %s: %s", hint, Pluralize(failed, "error", "errors"), msg),
+		errs: nonNil,
+	}
 }
+
+type mergedError struct {
+	msg  string
+	errs []error
+}
+
+func (e *mergedError) Error() string   { return e.msg }
+func (e *mergedError) Unwrap() []error { return e.errs }
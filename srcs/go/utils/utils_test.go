@@ -2,6 +2,7 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/lsds/KungFu/srcs/go/utils/assert"
@@ -32,3 +33,13 @@ func Test_Poll_Fail(t *testing.T) {
 	assert.True(!ok)
 	assert.True(failed == 2)
 }
+
+func Test_MergeErrors(t *testing.T) {
+	e1 := errors.New("e1")
+	e2 := errors.New("e2")
+	assert.True(MergeErrors([]error{nil, nil}, "test") == nil)
+	assert.True(MergeErrors([]error{nil, e1, e1}, "test") == e1)
+	if err := MergeErrors([]error{e1, nil, e2}, "test"); err == nil || err.Error() != "test failed with 2 errors: e1, e2" {
+		t.Errorf("unexpected merged error: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+import numpy as np
+
+
+def _type_code(c, b):
+    # same as TYPE_CODE in kungfu/dtype.h
+    return (c << 16) | (b << 8) | 8
+
+
+_dtypes = {
+    np.dtype(np.uint8): _type_code(0, 1),
+    np.dtype(np.uint16): _type_code(0, 2),
+    np.dtype(np.uint32): _type_code(0, 4),
+    np.dtype(np.uint64): _type_code(0, 8),
+    np.dtype(np.int8): _type_code(1, 1),
+    np.dtype(np.int16): _type_code(1, 2),
+    np.dtype(np.int32): _type_code(1, 4),
+    np.dtype(np.int64): _type_code(1, 8),
+    np.dtype(np.float16): _type_code(2, 2),
+    np.dtype(np.float32): _type_code(2, 4),
+    np.dtype(np.float64): _type_code(2, 8),
+}
+
+# same as KungFu_Op in kungfu/op.h
+_ops = {
+    'sum': 0,
+    'min': 1,
+    'max': 2,
+    'prod': 3,
+    'adasum': 4,
+    'land': 5,
+    'lor': 6,
+    'band': 7,
+    'bor': 8,
+    'bxor': 9,
+}
+
+
+def kungfu_dtype(dtype):
+    return _dtypes[np.dtype(dtype)]
+
+
+def kungfu_op(op):
+    return _ops[op]
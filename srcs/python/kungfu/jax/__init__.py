@@ -0,0 +1,46 @@
+import ctypes
+
+import numpy as np
+from kungfu._dtypes import kungfu_dtype, kungfu_op
+from kungfu.loader import _load_clib
+from kungfu.python import current_cluster_size, current_rank  # initializes the default peer
+
+__all__ = [
+    'current_cluster_size',
+    'current_rank',
+    'descriptor',
+    'register_custom_call_targets',
+]
+
+_jax_lib = _load_clib('libkungfu_jax')
+
+_custom_call_targets = [
+    'kungfu_jax_all_reduce',
+    'kungfu_jax_all_reduce_async',
+    'kungfu_jax_broadcast',
+    'kungfu_jax_wait_handle',
+]
+
+
+def descriptor(count, dtype, op='sum'):
+    """Create the descriptor operand of a KungFu custom call."""
+    return np.array([count, kungfu_dtype(dtype), kungfu_op(op)],
+                    dtype=np.int64)
+
+
+def _capsule(fn):
+    new_capsule = ctypes.pythonapi.PyCapsule_New
+    new_capsule.restype = ctypes.py_object
+    new_capsule.argtypes = (ctypes.c_void_p, ctypes.c_char_p, ctypes.c_void_p)
+    ptr = ctypes.cast(fn, ctypes.c_void_p).value
+    return new_capsule(ptr, b'xla._CUSTOM_CALL_TARGET', None)
+
+
+def register_custom_call_targets():
+    """Register KungFu collectives as XLA custom call targets on CPU."""
+    from jax.lib import xla_client
+    for name in _custom_call_targets:
+        fn = getattr(_jax_lib, name)
+        xla_client.register_custom_call_target(name.encode(),
+                                               _capsule(fn),
+                                               platform='cpu')
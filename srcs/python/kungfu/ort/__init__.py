@@ -0,0 +1,82 @@
+import ctypes
+
+import numpy as np
+from kungfu._dtypes import kungfu_dtype, kungfu_op
+from kungfu.loader import _load_clib
+from kungfu.python import current_cluster_size, current_rank  # initializes the default peer
+
+__all__ = [
+    'all_reduce',
+    'all_reduce_async',
+    'broadcast',
+    'current_cluster_size',
+    'current_rank',
+    'resize_cluster_from_url',
+    'wait',
+]
+
+_ort_lib = _load_clib('libkungfu_ort')
+
+
+def _check(name, err):
+    if err != 0:
+        raise RuntimeError('%s failed: %d' % (name, err))
+
+
+def _ptr(x):
+    return ctypes.c_void_p(x.ctypes.data)
+
+
+def _count(x):
+    return ctypes.c_int64(x.size)
+
+
+def _name(name):
+    return ctypes.c_char_p(name.encode())
+
+
+def all_reduce(x, name, op='sum'):
+    """AllReduce a contiguous numpy array, e.g. OrtValue.numpy()."""
+    y = np.empty_like(x)
+    _check(
+        'all_reduce',
+        _ort_lib.kungfu_ort_all_reduce(_ptr(x), _ptr(y), _count(x),
+                                       kungfu_dtype(x.dtype), kungfu_op(op),
+                                       _name(name)))
+    return y
+
+
+def all_reduce_async(x, y, name, op='sum'):
+    """Start AllReduce from x into y, the returned handle must be passed to wait."""
+    handle = ctypes.c_int32()
+    _check(
+        'all_reduce_async',
+        _ort_lib.kungfu_ort_all_reduce_async(_ptr(x), _ptr(y), _count(x),
+                                             kungfu_dtype(x.dtype),
+                                             kungfu_op(op), _name(name),
+                                             ctypes.byref(handle)))
+    return handle.value
+
+
+def wait(handle):
+    _check('wait', _ort_lib.kungfu_ort_wait(ctypes.c_int32(handle)))
+
+
+def broadcast(x, name):
+    y = np.empty_like(x)
+    _check(
+        'broadcast',
+        _ort_lib.kungfu_ort_broadcast(_ptr(x), _ptr(y), _count(x),
+                                      kungfu_dtype(x.dtype), _name(name)))
+    return y
+
+
+def resize_cluster_from_url():
+    """Returns (changed, detached)."""
+    changed = ctypes.c_int()
+    detached = ctypes.c_int()
+    _check(
+        'resize_cluster_from_url',
+        _ort_lib.kungfu_ort_resize_cluster_from_url(ctypes.byref(changed),
+                                                    ctypes.byref(detached)))
+    return bool(changed.value), bool(detached.value)
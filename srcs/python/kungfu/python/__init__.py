@@ -3,18 +3,40 @@ import atexit
 from kungfu.loader import _call_method, _load_clib, _module_path
 
 __all__ = [
+    'CollectiveFailure',
+    'abort',
+    'advance_epoch',
+    'advance_step',
+    'begin_step',
+    'cached_broadcast',
     'current_cluster_size',
+    'current_epoch',
     'current_local_rank',
     'current_local_size',
     'current_rank',
+    'current_step',
+    'checkpoint',
     'detached',
+    'end_step',
+    'fusion_size',
+    'invalidate_broadcast',
+    'loss_scale_consensus',
+    'report_signal',
     'run_barrier',
+    'set_post_hooks',
+    'snapshot_boundary',
+    'stale_sync',
+    'step_boundary',
+    'top_k_exploit',
+    'top_k_peers',
 ]
 
 
 def _load_and_init_python_lib():
     _load_clib('libkungfu')
     _python_lib = _load_clib('libkungfu_python')
+    # CUDA and NCCL versions are checked across peers, if built with NCCL
+    _call_method(_python_lib, 'kungfu_python_init_fingerprint')
     _call_method(_python_lib, 'kungfu_python_init')
     has_nccl = _call_method(_python_lib, 'kungfu_python_init_nccl')
     return _python_lib, has_nccl
@@ -62,6 +84,94 @@ def current_local_size():
     return _python_lib.kungfu_local_size()
 
 
+def current_step():
+    """Get the step agreed by all peers, it's synchronized after resizing."""
+    return _python_lib.kungfu_step()
+
+
+def current_epoch():
+    """Get the epoch agreed by all peers, it's synchronized after resizing."""
+    return _python_lib.kungfu_epoch()
+
+
+def advance_step():
+    """Increment the step, must be called by all peers once per step. Returns the new step."""
+    return _python_lib.kungfu_advance_step()
+
+
+def advance_epoch():
+    """Increment the epoch, must be called by all peers once per epoch. Returns the new epoch."""
+    return _python_lib.kungfu_advance_epoch()
+
+
+def begin_step():
+    """Start grouping the collectives of this peer into a training step, until end_step."""
+    _python_lib.kungfu_begin_step()
+
+
+def end_step():
+    """Returns the summary of the collectives of this peer since begin_step, with durations in seconds."""
+    import ctypes
+    collectives = ctypes.c_int32()
+    nbytes = ctypes.c_int64()
+    duration = ctypes.c_float()
+    critical_path = ctypes.c_float()
+    fusion_efficiency = ctypes.c_float()
+    _python_lib.kungfu_end_step(ctypes.byref(collectives),
+                                ctypes.byref(nbytes), ctypes.byref(duration),
+                                ctypes.byref(critical_path),
+                                ctypes.byref(fusion_efficiency))
+    return {
+        'collectives': collectives.value,
+        'bytes': nbytes.value,
+        'duration': duration.value,
+        'critical_path': critical_path.value,
+        'fusion_efficiency': fusion_efficiency.value,
+    }
+
+
+# The kinds of failures, indexed by the error code of the C API minus 1, see srcs/go/kungfu/failure.
+FAILURE_KINDS = (
+    'unknown',
+    'peer-crash',
+    'timeout',
+    'protocol-mismatch',
+    'cancelled',
+    'shrink-in-progress',
+)
+
+
+class CollectiveFailure(RuntimeError):
+    """Raised when an operation fails, kind is one of FAILURE_KINDS, e.g. to
+    retry after a 'timeout', resize after a 'peer-crash', or abort after a
+    'protocol-mismatch'."""
+    def __init__(self, message, code):
+        self.kind = 'unknown'
+        if 0 < code <= len(FAILURE_KINDS):
+            self.kind = FAILURE_KINDS[code - 1]
+        super(CollectiveFailure, self).__init__('%s (%s)' % (message, self.kind))
+
+
+def abort(reason):
+    """Make the collectives of all peers fail with the reason, so that they don't wait for this peer after a fatal error."""
+    return _python_lib.kungfu_abort(reason.encode())
+
+
+def report_signal(name, value):
+    """Set the value of an external signal of this peer, e.g. GPU utilization or the activity of co-located jobs.
+
+    The monitor reports the correlation of each signal with the durations of collectives,
+    to tell network interference from compute-side stalls.
+    """
+    import ctypes
+    return _python_lib.kungfu_report_signal(name.encode(), ctypes.c_double(value))
+
+
+def fusion_size():
+    """Get the bytes of a bucket of fused gradients, 0 if fusion is disabled."""
+    return _python_lib.kungfu_fusion_size()
+
+
 def _get_cuda_index():
     return _python_lib.kungfu_get_cuda_index()
 
@@ -76,6 +186,133 @@ def propose_new_size(new_size):
     _python_lib.kungfu_propose_new_size(int(new_size))
 
 
+def loss_scale_consensus(overflow, next_scale, name='kungfu::loss_scale'):
+    """Agree on dynamic loss scaling of mixed-precision training in one collective.
+
+    Each peer passes its local overflow flag and the next loss scale it proposes.
+    Returns (any_overflow, scale), where scale is the minimum of the proposed scales,
+    so that all replicas skip the same steps and use the same loss scale.
+    """
+    import ctypes
+    any_overflow = ctypes.c_char()
+    scale = ctypes.c_float()
+    _python_lib.kungfu_loss_scale_consensus(int(bool(overflow)),
+                                            ctypes.c_float(next_scale),
+                                            ctypes.byref(any_overflow),
+                                            ctypes.byref(scale), name.encode())
+    return bool(ord(any_overflow.value)), scale.value
+
+
+def top_k_peers(score, k, name='kungfu::top_k'):
+    """Returns the ranks of the k peers with the highest scores, in descending order of scores."""
+    import ctypes
+    ranks = (ctypes.c_int32 * k)()
+    _python_lib.kungfu_top_k(ctypes.c_float(score), int(k), ranks,
+                             name.encode())
+    return list(ranks)
+
+
+def top_k_exploit(score, k, model, name='kungfu::top_k_exploit'):
+    """Population-based training: agree on the k peers with the highest scores,
+    and replace the model (bytes) of each other peer by the model of a top peer.
+
+    The model must have the same size on all peers.
+    Returns (ranks, model), where model is unchanged for the top k peers.
+    """
+    import ctypes
+    model = bytes(model)
+    ranks = (ctypes.c_int32 * k)()
+    sendbuf = ctypes.create_string_buffer(model, len(model))
+    recvbuf = ctypes.create_string_buffer(len(model))
+    code = _python_lib.kungfu_top_k_exploit(ctypes.c_float(score), int(k),
+                                            ranks, sendbuf, recvbuf,
+                                            len(model), name.encode())
+    if code != 0:
+        raise CollectiveFailure('top_k_exploit failed', code)
+    return list(ranks), recvbuf.raw
+
+
+def cached_broadcast(blob, name):
+    """Broadcast blob (bytes) of the root, the transfer is skipped if all peers
+    have cached the same blob from a previous call with the same name.
+
+    The blob must have the same size on all peers, returns the blob of the root.
+    """
+    import ctypes
+    blob = bytes(blob)
+    sendbuf = ctypes.create_string_buffer(blob, len(blob))
+    recvbuf = ctypes.create_string_buffer(len(blob))
+    code = _python_lib.kungfu_cached_broadcast(sendbuf, recvbuf, len(blob),
+                                               name.encode())
+    if code != 0:
+        raise CollectiveFailure('cached_broadcast failed', code)
+    return recvbuf.raw
+
+
+def invalidate_broadcast(name):
+    """Drop the blob of name cached by cached_broadcast on this peer."""
+    _python_lib.kungfu_invalidate_broadcast(name.encode())
+
+
+def set_post_hooks(name, hooks):
+    """Process the result of every all reduce of name by hooks, a list of
+    'scale=<factor>' and 'clip=<lo>:<hi>', in the goroutine of the all reduce.
+    An empty list removes the hooks of name."""
+    code = _python_lib.kungfu_set_post_hooks(name.encode(),
+                                             ','.join(hooks).encode())
+    if code != 0:
+        raise ValueError('invalid post hooks: %s' % (hooks, ))
+
+
+def stale_sync(step, bound):
+    """Stale synchronous parallel: publish the step of this peer,
+    and block while any other peer is more than bound steps behind."""
+    _python_lib.kungfu_stale_sync(int(step), int(bound))
+
+
+def step_boundary(step):
+    """Apply pause/resume/scale requests sent to the control server of rank 0.
+
+    Must be called by all peers at the same step, blocks while the job is paused.
+    Returns (changed, keep) like resize_cluster_from_url.
+    """
+    import ctypes
+    changed = ctypes.c_char()
+    keep = ctypes.c_char()
+    _python_lib.kungfu_step_boundary(int(step), ctypes.byref(changed),
+                                     ctypes.byref(keep))
+    return bool(ord(changed.value)), bool(ord(keep.value))
+
+
+def snapshot_boundary(name, model):
+    """Publish model (bytes) as the snapshot of name at the current step,
+    if an evaluator is waiting for it, e.g. by `kungfu-ctl snapshot <name>`.
+
+    Must be called by all peers at the same step after the model is updated,
+    only rank 0 copies the model. Returns True if it was published.
+    """
+    import ctypes
+    model = bytes(model)
+    buf = ctypes.create_string_buffer(model, len(model))
+    return bool(
+        _python_lib.kungfu_snapshot_boundary(name.encode(), buf, len(model)))
+
+
+def checkpoint(url, name, data):
+    """Upload data (bytes) of rank 0 to url/name, e.g. s3://bucket/prefix.
+
+    Must be called by all peers. Raises CollectiveFailure, a RuntimeError, if
+    the upload failed.
+    """
+    import ctypes
+    data = bytes(data)
+    buf = ctypes.create_string_buffer(data, len(data))
+    code = _python_lib.kungfu_checkpoint(url.encode(), name.encode(), buf,
+                                         len(data))
+    if code != 0:
+        raise CollectiveFailure('checkpoint %s/%s failed' % (url, name), code)
+
+
 def _get_other_ranks():
     self_rank = current_rank()
     ranks = list(range(current_cluster_size()))
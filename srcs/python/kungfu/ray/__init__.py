@@ -0,0 +1,88 @@
+import os
+import time
+
+import ray
+
+__all__ = [
+    'gen_cluster',
+    'launch',
+]
+
+# same as plan.DefaultPortRange and plan.DefaultRunnerPort
+DEFAULT_PORT_RANGE = (10000, 11000)
+DEFAULT_RUNNER_PORT = 38080
+
+
+def _alive_hosts(resource):
+    hosts = []
+    for node in ray.nodes():
+        if not node['Alive']:
+            continue
+        slots = int(node['Resources'].get(resource, 0))
+        if slots > 0:
+            hosts.append((node['NodeManagerAddress'], slots))
+    return hosts
+
+
+def gen_cluster(np, resource='CPU', port_range=DEFAULT_PORT_RANGE):
+    """Generate (peers, runners) from the membership known to the Ray GCS.
+
+    Peers are allocated in the same order as plan.HostList.GenPeerList.
+    """
+    hosts = _alive_hosts(resource)
+    peers = []
+    runners = []
+    for ip, slots in hosts:
+        if len(peers) >= np:
+            break
+        runners.append('%s:%d' % (ip, DEFAULT_RUNNER_PORT))
+        for j in range(min(slots, port_range[1] - port_range[0] + 1)):
+            if len(peers) >= np:
+                break
+            peers.append('%s:%d' % (ip, port_range[0] + j))
+    if len(peers) < np:
+        raise RuntimeError('no enough capacity in ray cluster: %d < %d' %
+                           (len(peers), np))
+    return peers, runners
+
+
+@ray.remote
+class KungFuWorker(object):
+    def __init__(self, envs):
+        os.environ.update(envs)
+
+    def run(self, fn, *args, **kwargs):
+        return fn(*args, **kwargs)
+
+
+def _worker_envs(self_spec, peers, runners, strategy, t0):
+    host = self_spec.split(':')[0]
+    return {
+        'KUNGFU_JOB_START_TIMESTAMP': str(t0),
+        'KUNGFU_PROC_START_TIMESTAMP': str(int(time.time())),
+        'KUNGFU_SELF_SPEC': self_spec,
+        'KUNGFU_INIT_PEERS': ','.join(peers),
+        'KUNGFU_INIT_RUNNERS': ','.join(runners),
+        'KUNGFU_PARENT_ID': '%s:%d' % (host, DEFAULT_RUNNER_PORT),
+        'KUNGFU_INIT_CLUSTER_VERSION': '0',
+        'KUNGFU_ALLREDUCE_STRATEGY': strategy,
+    }
+
+
+def launch(fn, np, *args, strategy='BINARY_TREE_STAR', resource='CPU', **kwargs):
+    """Run fn(*args, **kwargs) on np KungFu workers hosted by Ray actors.
+
+    Each actor is pinned to the node its peer ID was allocated on.
+    Returns the results of all workers ordered by rank.
+    """
+    peers, runners = gen_cluster(np, resource=resource)
+    t0 = int(time.time())
+    workers = []
+    for self_spec in peers:
+        host = self_spec.split(':')[0]
+        envs = _worker_envs(self_spec, peers, runners, strategy, t0)
+        w = KungFuWorker.options(resources={
+            'node:' + host: 0.001
+        }).remote(envs)
+        workers.append(w)
+    return ray.get([w.run.remote(fn, *args, **kwargs) for w in workers])
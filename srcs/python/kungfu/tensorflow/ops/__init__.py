@@ -6,7 +6,9 @@ from .adapt import resize_cluster_from_url, set_tree, step_based_schedule
 from .collective import (all_gather, all_reduce, barrier, broadcast, consensus,
                          group_all_reduce, group_nccl_all_reduce,
                          monitored_all_reduce)
+from .ema import DistributedExponentialMovingAverage
 from .local import save_variable, save_variables
+from .metrics import auc, confusion_matrix, global_mean, weighted_accuracy
 from .monitor import global_noise_scale
 from .p2p import request_variable, request_variable_with_template
 from .state import counter, exponential_moving_average
@@ -17,6 +19,7 @@ __all__ = [
     'barrier',
     'broadcast',
     'cluster_size',
+    'DistributedExponentialMovingAverage',
     'group_all_reduce',
     'rank',
     'set_tree',
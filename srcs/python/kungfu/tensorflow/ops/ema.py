@@ -0,0 +1,63 @@
+import tensorflow as tf
+from kungfu.python import current_cluster_size
+from kungfu.tensorflow.compat import _tf_assign, _tf_mod
+
+from .collective import group_all_reduce
+from .state import counter
+
+
+class DistributedExponentialMovingAverage(object):
+    """DistributedExponentialMovingAverage maintains an EMA of model weights across all peers.
+
+    Each peer updates its local shadow variables every step, and the shadow
+    variables of all peers are averaged by AllReduce every sync_period steps,
+    so that evaluation with EMA weights is consistent across peers.
+
+    Arguments:
+        decay {float} -- the decay of the moving average.
+
+    Keyword Arguments:
+        - sync_period {int} -- the number of steps between two synchronizations. (default: {100})
+        - name {str} -- name prefix of the shadow variables. (default: {'KungFuEMA'})
+    """
+    def __init__(self, decay, sync_period=100, name='KungFuEMA'):
+        self._decay = decay
+        self._sync_period = sync_period
+        self._name = name
+        self._shadows = dict()
+        self._step = counter()
+
+    def apply(self, var_list):
+        """Returns an op that updates the shadow variables of var_list."""
+        shadows = []
+        for v in var_list:
+            if v not in self._shadows:
+                name = '%s/%s' % (self._name, v.name.split(':')[0])
+                self._shadows[v] = tf.Variable(v.initialized_value(),
+                                               name=name,
+                                               trainable=False)
+            shadows.append(self._shadows[v])
+
+        update_ops = [
+            _tf_assign(s, self._decay * s + (1 - self._decay) * v)
+            for s, v in zip(shadows, var_list)
+        ]
+
+        def sync():
+            np = current_cluster_size()
+            summed = group_all_reduce(shadows)
+            return tf.group(
+                [_tf_assign(s, t / np) for s, t in zip(shadows, summed)])
+
+        with tf.control_dependencies(update_ops):
+            return tf.cond(
+                tf.equal(_tf_mod(self._step, self._sync_period), 0), sync,
+                tf.no_op)
+
+    def average(self, var):
+        """Returns the shadow variable of var, or None if var is not tracked."""
+        return self._shadows.get(var)
+
+    def variables_to_restore(self):
+        """Returns a map from shadow variable names to the original variables, for evaluation with EMA weights."""
+        return {s.op.name: v for v, s in self._shadows.items()}
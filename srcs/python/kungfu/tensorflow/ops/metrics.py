@@ -0,0 +1,69 @@
+"""Metrics aggregated across all peers.
+
+Averaging per-peer metrics is wrong when peers evaluate different numbers of
+samples, and is undefined for non-decomposable metrics such as AUC. The
+helpers below all-reduce the sufficient statistics (counts, matrices,
+histograms) of each metric in a single collective, and compute the metric from
+the global statistics.
+"""
+import tensorflow as tf
+
+from .collective import all_reduce
+
+
+def global_mean(value, weight):
+    """Returns the weighted mean of value over all peers.
+
+    Inputs:
+        value: a scalar, e.g. the mean loss of the local batch.
+        weight: a scalar, e.g. the size of the local batch.
+    """
+    value = tf.cast(value, tf.float32)
+    weight = tf.cast(weight, tf.float32)
+    stats = all_reduce(tf.stack([value * weight, weight]))
+    return tf.math.divide_no_nan(stats[0], stats[1])
+
+
+def weighted_accuracy(correct, total):
+    """Returns the global accuracy given the local number of correct predictions and the local number of samples."""
+    return global_mean(
+        tf.math.divide_no_nan(tf.cast(correct, tf.float32),
+                              tf.cast(total, tf.float32)), total)
+
+
+def confusion_matrix(labels, predictions, num_classes, weights=None):
+    """Returns the confusion matrix of shape [num_classes, num_classes] summed over all peers."""
+    m = tf.math.confusion_matrix(labels,
+                                 predictions,
+                                 num_classes=num_classes,
+                                 weights=weights,
+                                 dtype=tf.int64)
+    return all_reduce(m)
+
+
+def auc(labels, scores, num_bins=200):
+    """Returns the global ROC AUC, approximated with histograms of scores merged from all peers.
+
+    Inputs:
+        labels: a bool tensor, true for positive samples.
+        scores: a tensor of scores in [0, 1], e.g. the output of a sigmoid.
+        num_bins: the number of histogram bins, larger is more precise.
+    """
+    labels = tf.reshape(tf.cast(labels, tf.bool), [-1])
+    scores = tf.reshape(tf.cast(scores, tf.float32), [-1])
+    value_range = tf.constant([0.0, 1.0])
+
+    def hist(s):
+        return tf.cast(tf.histogram_fixed_width(s, value_range, num_bins),
+                       tf.float32)
+
+    pos = hist(tf.boolean_mask(scores, labels))
+    neg = hist(tf.boolean_mask(scores, tf.logical_not(labels)))
+    merged = all_reduce(tf.concat([pos, neg], 0))
+    # from the highest scores to the lowest
+    pos = tf.reverse(merged[:num_bins], [0])
+    neg = tf.reverse(merged[num_bins:], [0])
+    tp = tf.cumsum(pos)
+    area = tf.reduce_sum(neg * (tp - pos / 2))
+    return tf.math.divide_no_nan(area,
+                                 tf.reduce_sum(pos) * tf.reduce_sum(neg))
@@ -1,4 +1,5 @@
 from kungfu._utils import map_maybe
+from kungfu.python import fusion_size
 from kungfu.tensorflow.ops import defuse, fuse, peer_info
 from kungfu.tensorflow.ops.collective import (
     group_all_reduce, group_hierarchical_nccl_all_reduce,
@@ -64,6 +65,9 @@ class _SynchronousSGD(_KungFuAlgorithm):
         else:
             self._group_all_reduce_fn = group_all_reduce
 
+        # bucket size of fusing gradients for the non-NCCL path, set by the configuration profile
+        self._fusion_size = fusion_size()
+
         _rank, self._num_workers = peer_info()
 
     def apply_gradients(self, apply_grads_func, grads_and_vars, **kwargs):
@@ -83,6 +87,9 @@ class _SynchronousSGD(_KungFuAlgorithm):
                                           [g.shape for g in gradients])
             else:
                 summed_gradients = self._group_all_reduce_fn(gradients)
+        elif self._fusion_size > 0:
+            summed_gradients = _fused_group_all_reduce(gradients,
+                                                       self._fusion_size)
         else:
             summed_gradients = self._group_all_reduce_fn(gradients)
 
@@ -93,3 +100,33 @@ class _SynchronousSGD(_KungFuAlgorithm):
         reduced_grads_and_vars = zip(reduced_grads, variables)
 
         return apply_grads_func(reduced_grads_and_vars, **kwargs)
+
+
+def _fused_group_all_reduce(ts, bucket_size):
+    """All reduce the tensors fused into buckets of at most bucket_size bytes.
+
+    A tensor larger than bucket_size has a bucket of its own, None is kept.
+    """
+    buckets = []
+    bucket, nbytes = [], 0
+    for i, t in enumerate(ts):
+        if t is None:
+            continue
+        size = t.shape.num_elements() * t.dtype.size
+        if bucket and (nbytes + size > bucket_size
+                       or t.dtype != ts[bucket[0]].dtype):
+            buckets.append(bucket)
+            bucket, nbytes = [], 0
+        bucket.append(i)
+        nbytes += size
+    if bucket:
+        buckets.append(bucket)
+
+    results = [None] * len(ts)
+    for bucket in buckets:
+        fused = fuse([ts[i] for i in bucket])
+        summed = group_all_reduce([fused])[0]
+        for i, y in zip(bucket, defuse(summed, [ts[i].shape
+                                                for i in bucket])):
+            results[i] = y
+    return results
@@ -1,12 +1,16 @@
 from .collective import (all_reduce_fn, broadcast_parameters,
                          inplace_all_reduce_async_op, inplace_all_reduce_op,
-                         wait_all_handles, wait_handle)
+                         register_buffer, release_buffer,
+                         sync_batch_norm_stats, wait_all_handles, wait_handle)
 
 __all__ = [
     'all_reduce_fn',
     'broadcast_parameters',
     'inplace_all_reduce_async_op',
     'inplace_all_reduce_op',
+    'register_buffer',
+    'release_buffer',
+    'sync_batch_norm_stats',
     'wait_handle',
     'wait_all_handles',
 ]
@@ -12,6 +12,10 @@ all_reduce_op_map = {
     'torch.FloatTensor': ops.all_reduce_cpu,
 }
 
+batch_norm_stats_op_map = {
+    'torch.FloatTensor': ops.batch_norm_stats_cpu,
+}
+
 all_reduce_async_op_map = {}
 broadcast_async_op_map = {}
 
@@ -1,6 +1,7 @@
 import torch
 
-from .clib import all_reduce_async_op_map, all_reduce_op_map, broadcast_async_op_map, ops
+from .clib import (all_reduce_async_op_map, all_reduce_op_map,
+                   batch_norm_stats_op_map, broadcast_async_op_map, ops)
 
 
 def all_reduce_fn(x, op=None):
@@ -27,6 +28,33 @@ def inplace_broadcast_async_op(x, name):
     return broadcast_async_op_map[x.type()](x, x, x.type(), name)
 
 
+def sync_batch_norm_stats(local_sum, local_sqsum, count, name=''):
+    """Synchronize per-channel batch norm statistics across all peers in one collective.
+
+    Returns the global mean, the global biased variance and the global element count.
+    """
+    c = local_sum.numel()
+    x = torch.cat([
+        local_sum.reshape(-1),
+        local_sqsum.reshape(-1),
+        local_sum.new_tensor([float(count)])
+    ])
+    y = x.new(x.shape)
+    batch_norm_stats_op_map[x.type()](x, y, x.type(), name)
+    return y[:c], y[c:2 * c], y[2 * c].item()
+
+
+def register_buffer(x):
+    """Register the memory of a host tensor that is all reduced in every step,
+    so that the RDMA transport sends it without a copy, until it's released."""
+    ops.register_buffer(x)
+
+
+def release_buffer(ptr):
+    """Release the buffer registered at the data_ptr() ptr."""
+    ops.release_buffer(ptr)
+
+
 def wait_handle(handle):
     ops.wait_handle(handle)
 
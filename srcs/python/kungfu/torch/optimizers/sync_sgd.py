@@ -1,6 +1,7 @@
 import torch
 from kungfu.torch.ops import (inplace_all_reduce_async_op,
-                              inplace_all_reduce_op, wait_all_handles)
+                              inplace_all_reduce_op, register_buffer,
+                              release_buffer, wait_all_handles)
 
 
 class _SynchronousSGDOptimizer(torch.optim.Optimizer):
@@ -8,6 +9,17 @@ class _SynchronousSGDOptimizer(torch.optim.Optimizer):
         super(self.__class__, self).__init__(param_groups)
         self._named_parameters = named_parameters
         self._op = op
+        self._registered = {}  # name -> data_ptr of the registered gradient
+
+    def _register_gradient(self, name, grad):
+        # gradients are reused across steps, unless they are set to None
+        ptr = grad.data_ptr()
+        if self._registered.get(name) == ptr:
+            return
+        if name in self._registered:
+            release_buffer(self._registered[name])
+        register_buffer(grad)
+        self._registered[name] = ptr
 
     def sync_gradients(self):
         # FIXME: make sure order is consistent across all workers
@@ -18,6 +30,7 @@ class _SynchronousSGDOptimizer(torch.optim.Optimizer):
                     h = inplace_all_reduce_async_op(p.grad, name, self._op)
                     handles.append(h)
                 else:
+                    self._register_gradient(name, p.grad)
                     inplace_all_reduce_op(p.grad, self._op)
         wait_all_handles(handles)
 
@@ -2,18 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"time"
 
 	"github.com/lsds/KungFu/srcs/go/kungfu/peer"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
 	"github.com/lsds/KungFu/srcs/go/utils"
 )
 
 var (
 	runFor     = flag.Duration("run-for", 30*time.Second, "")
 	errorAfter = flag.Duration("error-after", 5*time.Second, "")
+	abort      = flag.Bool("abort", false, "rank 0 aborts the collectives of others instead of exiting")
 )
 
 func main() {
@@ -27,6 +30,10 @@ func main() {
 	rank := peer.CurrentSession().Rank()
 	fmt.Printf("OK, rank=%d.\n", rank)
 	fmt.Fprintf(os.Stderr, "Err, rank=%d!\n", rank)
+	if *abort {
+		runAbort(peer.CurrentSession(), rank)
+		return
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	if rank == 0 {
@@ -40,3 +47,26 @@ func main() {
 		return
 	}
 }
+
+// runAbort checks that others waiting at a barrier for rank 0 fail with the abort of rank 0.
+func runAbort(sess *session.Session, rank int) {
+	if rank == 0 {
+		time.Sleep(*errorAfter)
+		if err := sess.Abort("bad worker"); err != nil {
+			utils.ExitErr(err)
+		}
+		return
+	}
+	for {
+		err := sess.Barrier()
+		if err == nil {
+			continue
+		}
+		var e *session.AbortError
+		if !errors.As(err, &e) || e.Rank != 0 {
+			utils.ExitErr(fmt.Errorf("unexpected error: %v", err))
+		}
+		fmt.Printf("OK, %v\n", e)
+		return
+	}
+}
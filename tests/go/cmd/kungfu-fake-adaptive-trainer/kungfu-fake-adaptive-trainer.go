@@ -15,6 +15,8 @@ import (
 var (
 	maxStep  = flag.Int("max-step", 10, "")
 	runTrain = flag.Bool("train", true, "")
+
+	useStepBoundary = flag.Bool("step-boundary", false, "resize by control requests and preemption instead of the config server")
 )
 
 func main() {
@@ -61,7 +63,7 @@ func fakeTrainLoop(peer *peer.Peer) {
 	for step := 0; step < *maxStep; step++ {
 		// BEGIN tf.train.SessionRunHook::before_run
 		if shouldSync {
-			newStep := syncStep(peer, step)
+			newStep := int(peer.CurrentSession().Step()) // synchronized after resizing
 			fmt.Printf("sync step: %d -> %d\n", step, newStep)
 			step = newStep
 			// TODO: broadcast from the oldest
@@ -74,7 +76,11 @@ func fakeTrainLoop(peer *peer.Peer) {
 		}
 
 		// BEGIN tf.train.SessionRunHook::after_run
-		changed, keep := resize(peer)
+		peer.CurrentSession().AdvanceStep()
+		for _, name := range model.Names {
+			peer.SnapshotBoundary(name, model.Buffers[name].RecvBuf) // for kungfu-ctl snapshot
+		}
+		changed, keep := resize(peer, step)
 		if !keep {
 			break
 		}
@@ -86,27 +92,18 @@ func fakeTrainLoop(peer *peer.Peer) {
 	log.Infof("finished")
 }
 
-func syncStep(peer *peer.Peer, step int) int {
-	sess := peer.CurrentSession()
-	x := kb.NewVector(1, kb.I64)
-	y := kb.NewVector(1, kb.I64)
-	x.AsI64()[0] = int64(step)
-	w := kb.Workspace{
-		SendBuf: x,
-		RecvBuf: y,
-		OP:      kb.MAX,
-		Name:    "sync-step",
-	}
-	sess.AllReduce(w)
-	return int(y.AsI64()[0])
-}
-
-func resize(peer *peer.Peer) (bool, bool) {
+func resize(peer *peer.Peer, step int) (bool, bool) {
 	sess := peer.CurrentSession()
 	oldRank := sess.Rank()
 	oldSize := sess.Size()
 	t0 := time.Now()
-	changed, keep, err := peer.ResizeClusterFromURL()
+	var changed, keep bool
+	var err error
+	if *useStepBoundary {
+		changed, keep, err = peer.StepBoundary(step)
+	} else {
+		changed, keep, err = peer.ResizeClusterFromURL()
+	}
 	if err != nil {
 		utils.ExitErr(err)
 	}
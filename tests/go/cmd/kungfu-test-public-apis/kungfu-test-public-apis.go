@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"math"
+	"time"
 
 	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
 	"github.com/lsds/KungFu/srcs/go/kungfu/peer"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
 	"github.com/lsds/KungFu/srcs/go/utils"
 	"github.com/lsds/KungFu/srcs/go/utils/assert"
 )
@@ -23,9 +27,21 @@ func main() {
 		// TODO: more tests
 		testAllReduce,
 		testAllReduceWith,
+		testAllReduceAsync,
+		testAllReducePostHooks,
+		testAllReduceStream,
+		testAllReduceCodec,
 		testAllGather,
+		testBroadcastFrom,
+		testScatterGatherV,
+		testBarrierTimeout,
+		testReduceScatter,
+		testAllToAll,
 		testGetPeerLatencies,
 		testP2P,
+		testProgress,
+		testProposeChange,
+		testStepProfile,
 	}
 	for i, t := range tests {
 		fmt.Printf("# test: %d\n", i)
@@ -46,6 +62,16 @@ func testGetPeerLatencies(peer *peer.Peer) {
 	fmt.Printf("%s OK\n", `testGetPeerLatencies`)
 }
 
+var absMax, _ = kb.RegisterOP("absmax", func(z, x, y *kb.Vector) {
+	for i := range z.AsI32() {
+		a, b := x.AsI32()[i], y.AsI32()[i]
+		if a*a < b*b {
+			a = b
+		}
+		z.AsI32()[i] = a
+	}
+})
+
 func testAllReduce(peer *peer.Peer) {
 	const step = 20
 	sess := peer.CurrentSession()
@@ -80,6 +106,51 @@ func testAllReduce(peer *peer.Peer) {
 			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.MAX, Name: "2"}
 			assert.OK(sess.AllReduce(w))
 		}
+		{
+			const n = 1023
+			x := kb.NewVector(n, kb.I32)
+			y := kb.NewVector(n, kb.I32)
+			for j := range x.AsI32() {
+				x.AsI32()[j] = int32(sess.Rank() + j)
+			}
+			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "3"}
+			assert.OK(sess.AllReduce(w))
+			for j, v := range y.AsI32() {
+				assert.True(v == int32(np*j+np*(np-1)/2))
+			}
+		}
+		{
+			x := kb.NewVector(1, kb.F32)
+			y := kb.NewVector(1, kb.F32)
+			x.AsF32()[0] = float32(sess.Rank() + 1)
+			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "4", Compensated: true}
+			assert.OK(sess.AllReduce(w))
+			assert.True(y.AsF32()[0] == float32(np*(np+1)/2))
+		}
+		{
+			x := kb.NewVector(1, kb.I32)
+			y := kb.NewVector(1, kb.I32)
+			x.AsI32()[0] = int32(1 << uint(sess.Rank()))
+			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.BOR, Name: "5"}
+			assert.OK(sess.AllReduce(w))
+			assert.True(y.AsI32()[0] == int32(1<<uint(np))-1)
+		}
+		{
+			x := kb.NewVector(1, kb.I32)
+			y := kb.NewVector(1, kb.I32)
+			x.AsI32()[0] = int32(sess.Rank() - np)
+			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: absMax, Name: "6"}
+			assert.OK(sess.AllReduce(w))
+			assert.True(y.AsI32()[0] == int32(-np))
+		}
+		{
+			x := kb.NewVector(1, kb.BF16)
+			y := kb.NewVector(1, kb.BF16)
+			binary.LittleEndian.PutUint16(x.Data, uint16(math.Float32bits(float32(sess.Rank()+1))>>16))
+			w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "7"}
+			assert.OK(sess.AllReduce(w))
+			assert.True(math.Float32frombits(uint32(binary.LittleEndian.Uint16(y.Data))<<16) == float32(np*(np+1)/2))
+		}
 	}
 	fmt.Printf("%s OK\n", `testAllReduce`)
 }
@@ -103,6 +174,134 @@ func testAllReduceWith(peer *peer.Peer) {
 	assert.True(utils.BytesEq(y.Data, z.Data))
 }
 
+func testAllReduceAsync(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	const layers = 8
+	var ws []kb.Workspace
+	var hs []*session.Handle
+	for i := 0; i < layers; i++ {
+		w := kb.Workspace{
+			SendBuf: kb.NewVector(1024*(i+1), kb.I32),
+			RecvBuf: kb.NewVector(1024*(i+1), kb.I32),
+			OP:      kb.SUM,
+			Name:    fmt.Sprintf("async-grad-%d", i),
+		}
+		for j := range w.SendBuf.AsI32() {
+			w.SendBuf.AsI32()[j] = int32(i)
+		}
+		h, err := sess.AllReduceAsync(w)
+		assert.OK(err)
+		ws = append(ws, w)
+		hs = append(hs, h)
+	}
+	for i, h := range hs {
+		assert.OK(h.Wait())
+		for _, v := range ws[i].RecvBuf.AsI32() {
+			if v != int32(i*np) {
+				utils.ExitErr(fmt.Errorf("%s failed: %s = %d", "testAllReduceAsync", ws[i].Name, v))
+			}
+		}
+	}
+	fmt.Printf("%s OK\n", `testAllReduceAsync`)
+}
+
+func testAllReducePostHooks(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	sess.SetPostHooks("mean", session.ScaleHook(1/float64(np)))
+	sess.SetPostHooks("clipped", session.ClipHook(0, 1))
+	defer sess.SetPostHooks("mean")
+	defer sess.SetPostHooks("clipped")
+	x := kb.NewVector(1024, kb.F32)
+	y := kb.NewVector(1024, kb.F32)
+	for i := range x.AsF32() {
+		x.AsF32()[i] = float32(sess.Rank())
+	}
+	assert.OK(sess.AllReduce(kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "mean"}))
+	for _, v := range y.AsF32() {
+		if v != float32(np-1)/2 {
+			utils.ExitErr(fmt.Errorf("%s failed: mean = %f", "testAllReducePostHooks", v))
+		}
+	}
+	h, err := sess.AllReduceAsync(kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "clipped"})
+	assert.OK(err)
+	assert.OK(h.Wait())
+	var want float32 // the sum of ranks clipped to [0, 1]
+	if np > 1 {
+		want = 1
+	}
+	for _, v := range y.AsF32() {
+		if v != want {
+			utils.ExitErr(fmt.Errorf("%s failed: clipped = %f", "testAllReducePostHooks", v))
+		}
+	}
+	fmt.Printf("%s OK\n", `testAllReducePostHooks`)
+}
+
+func testAllReduceStream(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	const count = 10000
+	x := kb.NewVector(count, kb.I32)
+	for i := range x.AsI32() {
+		x.AsI32()[i] = int32(i + sess.Rank())
+	}
+	dst := &bytes.Buffer{}
+	w := session.StreamWorkspace{
+		Src:          bytes.NewReader(x.Data),
+		Dst:          dst,
+		Count:        count,
+		Type:         kb.I32,
+		OP:           kb.SUM,
+		Name:         "stream",
+		SegmentBytes: 4 * 999,
+	}
+	assert.OK(sess.AllReduceStream(w))
+	y := &kb.Vector{Data: dst.Bytes(), Count: dst.Len() / 4, Type: kb.I32}
+	if y.Count != count {
+		utils.ExitErr(fmt.Errorf("%s failed: %d elements written, want %d", "testAllReduceStream", y.Count, count))
+	}
+	for i, v := range y.AsI32() {
+		if want := int32(np*i + np*(np-1)/2); v != want {
+			utils.ExitErr(fmt.Errorf("%s failed: y[%d] = %d, want %d", "testAllReduceStream", i, v, want))
+		}
+	}
+	fmt.Printf("%s OK\n", `testAllReduceStream`)
+}
+
+func testAllReduceCodec(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	prev := sess.CodecName()
+	defer func() { assert.OK(sess.SetCodec(prev)) }()
+	const count = 1 << 20
+	x := kb.NewVector(count, kb.F32)
+	for i := range x.AsF32() {
+		x.AsF32()[i] = float32(i%16 + sess.Rank())
+	}
+	for _, name := range []string{session.FP16Codec, session.QSGDCodec} {
+		assert.OK(sess.SetCodec(name))
+		y := kb.NewVector(count, kb.F32)
+		w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: "codec-grad-" + name}
+		assert.OK(sess.AllReduce(w))
+		ok, err := sess.BytesConsensus(y.Data, "codec-result-"+name)
+		assert.OK(err)
+		if !ok {
+			utils.ExitErr(fmt.Errorf("%s failed: peers decoded different results by %s", "testAllReduceCodec", name))
+		}
+		if name != session.FP16Codec {
+			continue
+		}
+		for i, v := range y.AsF32() {
+			if want := float32(np*(i%16) + np*(np-1)/2); v != want {
+				utils.ExitErr(fmt.Errorf("%s failed: y[%d] = %g, want %g", "testAllReduceCodec", i, v, want))
+			}
+		}
+	}
+	fmt.Printf("%s OK\n", `testAllReduceCodec`)
+}
+
 func testAllGather(peer *peer.Peer) {
 	sess := peer.CurrentSession()
 	np := sess.Size()
@@ -125,10 +324,149 @@ func testAllGather(peer *peer.Peer) {
 		if s := sumI32(y); s != ySum {
 			utils.ExitErr(fmt.Errorf("%s failed", "testAllGather"))
 		}
+		for j, v := range y {
+			if v != int32(j/count+1) {
+				utils.ExitErr(fmt.Errorf("%s failed: y[%d] = %d", "testAllGather", j, v))
+			}
+		}
 	}
 	fmt.Printf("%s OK\n", `testAllGather`)
 }
 
+func testBroadcastFrom(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	count := 1024
+	for _, fanout := range []int{0, 1, 2} {
+		root := np - 1
+		w := kb.Workspace{
+			SendBuf: kb.NewVector(count, kb.I32),
+			RecvBuf: kb.NewVector(count, kb.I32),
+			Name:    fmt.Sprintf("bcast-from:%d", fanout),
+		}
+		fillI32(w.SendBuf.AsI32(), int32(sess.Rank()+1))
+		assert.OK(sess.BroadcastFrom(w, root, fanout))
+		if s := sumI32(w.RecvBuf.AsI32()); s != int32((root+1)*count) {
+			utils.ExitErr(fmt.Errorf("%s failed: sum = %d with fanout %d", "testBroadcastFrom", s, fanout))
+		}
+	}
+	fmt.Printf("%s OK\n", `testBroadcastFrom`)
+}
+
+func testScatterGatherV(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	rank := sess.Rank()
+	counts := make([]int, np)
+	var total int
+	for i := range counts {
+		counts[i] = (i + 1) * 300000 // spans chunks
+		total += counts[i]
+	}
+	x := kb.NewVector(total, kb.I32)
+	y := x.AsI32()
+	for i := range y {
+		y[i] = int32(i)
+	}
+	shard, err := sess.ScatterV(x, counts, "scatter-v")
+	assert.OK(err)
+	offset := rank * (rank + 1) / 2 * 300000
+	for i, v := range shard.AsI32() {
+		if v != int32(offset+i) {
+			utils.ExitErr(fmt.Errorf("%s failed: shard[%d] = %d", "testScatterGatherV", i, v))
+		}
+	}
+	all, cs, err := sess.GatherV(shard, "gather-v")
+	assert.OK(err)
+	if rank == 0 && (!bytes.Equal(all.Data, x.Data) || len(cs) != np || cs[np-1] != counts[np-1]) {
+		utils.ExitErr(fmt.Errorf("%s failed: gathered %d elements", "testScatterGatherV", all.Count))
+	}
+	w := kb.Workspace{
+		SendBuf: kb.NewVector(np*1024, kb.I32),
+		RecvBuf: kb.NewVector(1024, kb.I32),
+		Name:    "scatter",
+	}
+	for i := range w.SendBuf.AsI32() {
+		w.SendBuf.AsI32()[i] = int32(i / 1024)
+	}
+	assert.OK(sess.Scatter(w))
+	if s := sumI32(w.RecvBuf.AsI32()); s != int32(rank*1024) {
+		utils.ExitErr(fmt.Errorf("%s failed: sum = %d", "testScatter", s))
+	}
+	fmt.Printf("%s OK\n", `testScatterGatherV`)
+}
+
+func testBarrierTimeout(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	for i := 0; i < 3; i++ {
+		assert.OK(sess.BarrierTimeout(10 * time.Second))
+	}
+	fmt.Printf("%s OK\n", `testBarrierTimeout`)
+}
+
+func testReduceScatter(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	count := 1000 + np/2 // not divisible by np
+	r := sess.ReduceScatterShard(count)
+	w := kb.Workspace{
+		SendBuf: kb.NewVector(count, kb.I32),
+		RecvBuf: kb.NewVector(r.Len(), kb.I32),
+		OP:      kb.SUM,
+		Name:    "reduce-scatter",
+	}
+	x := w.SendBuf.AsI32()
+	for i := range x {
+		x[i] = int32(i + sess.Rank())
+	}
+	assert.OK(sess.ReduceScatter(w))
+	for i, v := range w.RecvBuf.AsI32() {
+		if j := r.Begin + i; v != int32(np*j+np*(np-1)/2) {
+			utils.ExitErr(fmt.Errorf("%s failed: y[%d] = %d", "testReduceScatter", j, v))
+		}
+	}
+	fmt.Printf("%s OK\n", `testReduceScatter`)
+}
+
+func testAllToAll(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	np := sess.Size()
+	rank := sess.Rank()
+	// rank i sends i + j + 1 elements of i * 1000 + j to rank j
+	splits := make([]int, np)
+	var sendCount, recvCount int
+	for j := range splits {
+		splits[j] = rank + j + 1
+		sendCount += splits[j]
+		recvCount += j + rank + 1
+	}
+	w := kb.Workspace{
+		SendBuf: kb.NewVector(sendCount, kb.I32),
+		RecvBuf: kb.NewVector(recvCount, kb.I32),
+		Name:    "all-to-all",
+	}
+	x := w.SendBuf.AsI32()
+	var offset int
+	for j, n := range splits {
+		for k := 0; k < n; k++ {
+			x[offset+k] = int32(rank*1000 + j)
+		}
+		offset += n
+	}
+	assert.OK(sess.AllToAll(w, splits))
+	y := w.RecvBuf.AsI32()
+	offset = 0
+	for i := 0; i < np; i++ {
+		for k := 0; k < i+rank+1; k++ {
+			if v := y[offset+k]; v != int32(i*1000+rank) {
+				utils.ExitErr(fmt.Errorf("%s failed: y[%d] = %d", "testAllToAll", offset+k, v))
+			}
+		}
+		offset += i + rank + 1
+	}
+	fmt.Printf("%s OK\n", `testAllToAll`)
+}
+
 func testP2P(peer *peer.Peer) {
 	const step = 20
 	const count = 10
@@ -172,6 +510,66 @@ func testP2P(peer *peer.Peer) {
 	fmt.Printf("%s OK\n", `testP2P`)
 }
 
+func testProgress(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	// peers of higher ranks start ahead, e.g. after they are restored from different checkpoints
+	sess.SetProgress(int64(sess.Rank()), 0)
+	assert.OK(sess.SyncProgress())
+	last := int64(sess.Size() - 1)
+	if sess.Step() != last || sess.Epoch() != 0 {
+		utils.ExitErr(fmt.Errorf("%s failed: step %d, epoch %d", `testProgress`, sess.Step(), sess.Epoch()))
+	}
+	if step, epoch := sess.AdvanceStep(), sess.AdvanceEpoch(); step != last+1 || epoch != 1 {
+		utils.ExitErr(fmt.Errorf("%s failed: step %d, epoch %d", `testProgress`, step, epoch))
+	}
+	fmt.Printf("%s OK\n", `testProgress`)
+}
+
+func testProposeChange(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	fail := func(format string, args ...interface{}) {
+		utils.ExitErr(fmt.Errorf("%s failed: %s", `testProposeChange`, fmt.Sprintf(format, args...)))
+	}
+	step := sess.Step()
+	assert.OK(sess.ProposeChange("lr", []byte("0.1"), step+2))
+	if _, ok := sess.Setting("lr"); ok {
+		fail("change took effect at step %d", sess.Step())
+	}
+	sess.AdvanceStep()
+	sess.AdvanceStep()
+	if v, ok := sess.Setting("lr"); !ok || string(v) != "0.1" {
+		fail("lr = %q at step %d", v, sess.Step())
+	}
+	if err := sess.ProposeChange("lr", []byte(fmt.Sprintf("%d", sess.Rank())), step+3); err == nil && sess.Size() > 1 {
+		fail("different changes were accepted")
+	}
+	if err := sess.ProposeChange("lr", []byte("0.2"), sess.Step()); err == nil {
+		fail("change at the current step was accepted")
+	}
+	if v, _ := sess.Setting("lr"); string(v) != "0.1" {
+		fail("rejected change took effect: lr = %q", v)
+	}
+	fmt.Printf("%s OK\n", `testProposeChange`)
+}
+
+func testStepProfile(peer *peer.Peer) {
+	sess := peer.CurrentSession()
+	const n = 10
+	sess.BeginStep()
+	for i := 0; i < n; i++ {
+		x := kb.NewVector(256, kb.F32)
+		y := kb.NewVector(256, kb.F32)
+		w := kb.Workspace{SendBuf: x, RecvBuf: y, OP: kb.SUM, Name: fmt.Sprintf("step-profile-%d", i)}
+		assert.OK(sess.AllReduce(w))
+	}
+	s, err := sess.EndStep()
+	assert.OK(err)
+	if s.Collectives != n || s.Bytes != n*256*4 || s.CriticalPath > s.Duration {
+		utils.ExitErr(fmt.Errorf("%s failed: %+v", `testStepProfile`, s))
+	}
+	fmt.Printf("%s OK\n", `testStepProfile`)
+}
+
 func sumI32(xs []int32) int32 {
 	var s int32
 	for _, x := range xs {
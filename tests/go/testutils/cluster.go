@@ -0,0 +1,85 @@
+package testutils
+
+import (
+	"net"
+	"sync"
+
+	kb "github.com/lsds/KungFu/srcs/go/kungfu/base"
+	"github.com/lsds/KungFu/srcs/go/kungfu/env"
+	"github.com/lsds/KungFu/srcs/go/kungfu/peer"
+	"github.com/lsds/KungFu/srcs/go/kungfu/session"
+	"github.com/lsds/KungFu/srcs/go/plan"
+)
+
+// Cluster is a cluster of peers in this process on 127.0.0.1, for the tests of collectives.
+type Cluster struct {
+	Peers []*peer.Peer
+}
+
+// StartCluster starts a cluster of n peers on free ports, and waits until all of them have their first session.
+func StartCluster(n int) (*Cluster, error) {
+	var pl plan.PeerList
+	for i := 0; i < n; i++ {
+		port, err := freePort()
+		if err != nil {
+			return nil, err
+		}
+		pl = append(pl, plan.PeerID{IPv4: plan.MustParseIPv4("127.0.0.1"), Port: port})
+	}
+	c := &Cluster{Peers: make([]*peer.Peer, n)}
+	for i := range c.Peers {
+		p, err := peer.NewFromConfig(&env.Config{Self: pl[i], InitPeers: pl, Strategy: kb.DefaultStrategy})
+		if err != nil {
+			return nil, err
+		}
+		c.Peers[i] = p
+	}
+	err := c.each(func(i int, p *peer.Peer) error { return p.Start() })
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run runs f with the current session of each peer concurrently, and returns the error of the lowest rank that failed.
+func (c *Cluster) Run(f func(rank int, sess *session.Session) error) error {
+	return c.each(func(i int, p *peer.Peer) error { return f(i, p.CurrentSession()) })
+}
+
+// Close closes all peers.
+func (c *Cluster) Close() {
+	for _, p := range c.Peers {
+		if p != nil {
+			p.Close()
+		}
+	}
+}
+
+func (c *Cluster) each(f func(i int, p *peer.Peer) error) error {
+	errs := make([]error, len(c.Peers))
+	var wg sync.WaitGroup
+	for i, p := range c.Peers {
+		wg.Add(1)
+		go func(i int, p *peer.Peer) {
+			defer wg.Done()
+			errs[i] = f(i, p)
+		}(i, p)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func freePort() (uint16, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), nil
+}
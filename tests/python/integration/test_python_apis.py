@@ -1,5 +1,6 @@
 # FIXME: make sure it runs without tensorflow
-from kungfu.python import current_cluster_size, current_rank, run_barrier
+from kungfu.python import (current_cluster_size, current_rank, report_signal,
+                           run_barrier)
 
 
 def test_barrier():
@@ -16,7 +17,13 @@ def test_peer_info():
     print('rank=%d, np=%d' % (rank, np))
 
 
+def test_report_signal():
+    report_signal('gpu_util', 0.5)
+    report_signal('gpu_util', 1)
+
+
 # TODO: more tests
 
 test_barrier()
 test_peer_info()
+test_report_signal()